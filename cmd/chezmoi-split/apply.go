@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+
+	"github.com/thirteen37/chezmoi-split/internal/atomicfile"
+)
+
+// runApplyInplace implements "chezmoi-split apply-inplace <script> <target>":
+// it merges the target file into the script's managed config, as chezmoi
+// would through the interpreter, and writes the result back to the target
+// atomically. A missing target is created. With --backup, the previous
+// contents are kept in <target>.bak first, with the target's mode. An
+// unchanged target isn't rewritten.
+//
+// The target keeps its mode, and a new one gets 0644; --mode sets it
+// instead, whether or not the target exists.
+func runApplyInplace(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("apply-inplace", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	backup := fs.Bool("backup", false, "save the previous contents to <target>.bak before writing")
+	modeFlag := fs.String("mode", "", "octal permission bits for the target, such as 600")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("apply-inplace: %w", err)
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("apply-inplace: expected a script and a target file, got %d arguments", fs.NArg())
+	}
+	scriptPath, target := fs.Arg(0), fs.Arg(1)
+
+	var mode os.FileMode
+	if *modeFlag != "" {
+		m, err := parseMode(*modeFlag)
+		if err != nil {
+			return fmt.Errorf("apply-inplace: %w", err)
+		}
+		mode = m
+	}
+
+	scr, err := loadScript(scriptPath)
+	if err != nil {
+		return fmt.Errorf("apply-inplace: %w", err)
+	}
+	for _, warning := range scr.Warnings {
+		fmt.Fprintf(os.Stderr, "chezmoi-split: warning: %s\n", warning)
+	}
+
+	currentData, existed, err := readTarget(target)
+	if err != nil {
+		return fmt.Errorf("apply-inplace: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := mergeScript(scr, scriptPath, currentData, &buf); err != nil {
+		return fmt.Errorf("apply-inplace: %w", err)
+	}
+	var current os.FileMode
+	if info, err := os.Stat(target); err == nil {
+		current = info.Mode().Perm()
+	}
+	if existed && bytes.Equal(buf.Bytes(), currentData) && (mode == 0 || mode == current) {
+		fmt.Fprintf(stdout, "%s is up to date\n", target)
+		return nil
+	}
+
+	// The backup holds the same contents as the target, so it must not be
+	// any more readable
+	if *backup && existed {
+		if err := atomicfile.WriteFileMode(target+".bak", currentData, current); err != nil {
+			return fmt.Errorf("apply-inplace: failed to write backup: %w", err)
+		}
+	}
+	write := atomicfile.WriteFile
+	if mode != 0 {
+		write = atomicfile.WriteFileMode
+	} else {
+		mode = 0o644
+	}
+	if err := write(target, buf.Bytes(), mode); err != nil {
+		return fmt.Errorf("apply-inplace: failed to write target file: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "Updated %s\n", target)
+	return nil
+}
+
+// parseMode parses octal permission bits such as "600" or "0640".
+func parseMode(s string) (fs.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m == 0 || m > 0o777 {
+		return 0, fmt.Errorf("invalid --mode %q: want octal permission bits such as 600", s)
+	}
+	return fs.FileMode(m), nil
+}
+
+// readTarget returns the contents of target and whether it exists. A missing
+// target reads as empty, like chezmoi's stdin for a file it hasn't created.
+func readTarget(target string) ([]byte, bool, error) {
+	data, err := os.ReadFile(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read target file: %w", err)
+	}
+	return data, true, nil
+}
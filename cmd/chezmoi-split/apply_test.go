@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const applyScript = `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore ["theme"]
+#---
+{
+  "theme": "dark",
+  "fontSize": 14
+}
+`
+
+func writeApplyFixture(t *testing.T, current string) (scriptPath, target string) {
+	t.Helper()
+	dir := t.TempDir()
+	scriptPath = filepath.Join(dir, "modify_settings.json")
+	if err := os.WriteFile(scriptPath, []byte(applyScript), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	target = filepath.Join(dir, "settings.json")
+	if current != "" {
+		if err := os.WriteFile(target, []byte(current), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return scriptPath, target
+}
+
+func TestApplyInplaceCommand(t *testing.T) {
+	current := `{"theme": "light", "fontSize": 12}`
+	scriptPath, target := writeApplyFixture(t, current)
+
+	var out bytes.Buffer
+	if err := runApplyInplace([]string{"--backup", scriptPath, target}, &out); err != nil {
+		t.Fatalf("runApplyInplace() error = %v", err)
+	}
+
+	want := `{
+  "theme": "light",
+  "fontSize": 14
+}
+`
+	data, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("target =\n%s\nwant:\n%s", data, want)
+	}
+	if !strings.Contains(out.String(), "Updated "+target) {
+		t.Errorf("output = %q, want an update message", out.String())
+	}
+
+	backup, err := os.ReadFile(target + ".bak")
+	if err != nil {
+		t.Fatalf("backup not written: %v", err)
+	}
+	if string(backup) != current {
+		t.Errorf("backup = %q, want %q", backup, current)
+	}
+
+	info, _ := os.Stat(target)
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("target mode = %v, want 0600 to be kept", info.Mode().Perm())
+	}
+
+	// A second run has nothing to change
+	out.Reset()
+	if err := runApplyInplace([]string{scriptPath, target}, &out); err != nil {
+		t.Fatalf("second runApplyInplace() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "is up to date") {
+		t.Errorf("second run output = %q, want up to date", out.String())
+	}
+}
+
+func TestApplyInplaceCommand_NoBackupByDefault(t *testing.T) {
+	scriptPath, target := writeApplyFixture(t, `{"theme": "light"}`)
+
+	if err := runApplyInplace([]string{scriptPath, target}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("runApplyInplace() error = %v", err)
+	}
+	if _, err := os.Stat(target + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("backup written without --backup (err = %v)", err)
+	}
+}
+
+func TestApplyInplaceCommand_MissingTarget(t *testing.T) {
+	scriptPath, target := writeApplyFixture(t, "")
+
+	if err := runApplyInplace([]string{"--backup", scriptPath, target}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("runApplyInplace() error = %v", err)
+	}
+
+	want := `{
+  "theme": "dark",
+  "fontSize": 14
+}
+`
+	data, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatalf("target not created: %v", err)
+	}
+	if string(data) != want {
+		t.Errorf("target =\n%s\nwant:\n%s", data, want)
+	}
+	if _, err := os.Stat(target + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("backup written for a new target (err = %v)", err)
+	}
+}
+
+func TestApplyInplaceCommand_Mode(t *testing.T) {
+	perm := func(t *testing.T, name string) os.FileMode {
+		t.Helper()
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Mode().Perm()
+	}
+
+	t.Run("new target defaults to 0644", func(t *testing.T) {
+		scriptPath, target := writeApplyFixture(t, "")
+		if err := runApplyInplace([]string{scriptPath, target}, &bytes.Buffer{}); err != nil {
+			t.Fatalf("runApplyInplace() error = %v", err)
+		}
+		if got := perm(t, target); got != 0o644 {
+			t.Errorf("target mode = %v, want 0644", got)
+		}
+	})
+
+	t.Run("new target with --mode", func(t *testing.T) {
+		scriptPath, target := writeApplyFixture(t, "")
+		if err := runApplyInplace([]string{"--mode", "600", scriptPath, target}, &bytes.Buffer{}); err != nil {
+			t.Fatalf("runApplyInplace() error = %v", err)
+		}
+		if got := perm(t, target); got != 0o600 {
+			t.Errorf("target mode = %v, want 0600", got)
+		}
+	})
+
+	t.Run("backup keeps a private target private", func(t *testing.T) {
+		scriptPath, target := writeApplyFixture(t, `{"theme": "light"}`)
+		// A stale, world-readable backup must not keep its mode
+		if err := os.WriteFile(target+".bak", []byte("old"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := runApplyInplace([]string{"--backup", scriptPath, target}, &bytes.Buffer{}); err != nil {
+			t.Fatalf("runApplyInplace() error = %v", err)
+		}
+		if got := perm(t, target); got != 0o600 {
+			t.Errorf("target mode = %v, want 0600 to be kept", got)
+		}
+		if got := perm(t, target+".bak"); got != 0o600 {
+			t.Errorf("backup mode = %v, want the target's 0600", got)
+		}
+	})
+
+	t.Run("--mode applies to an up-to-date target", func(t *testing.T) {
+		scriptPath, target := writeApplyFixture(t, "")
+		if err := runApplyInplace([]string{scriptPath, target}, &bytes.Buffer{}); err != nil {
+			t.Fatalf("runApplyInplace() error = %v", err)
+		}
+		var out bytes.Buffer
+		if err := runApplyInplace([]string{"--mode", "0640", scriptPath, target}, &out); err != nil {
+			t.Fatalf("runApplyInplace() error = %v", err)
+		}
+		if got := perm(t, target); got != 0o640 {
+			t.Errorf("target mode = %v, want 0640", got)
+		}
+		if !strings.Contains(out.String(), "Updated") {
+			t.Errorf("output = %q, want an update message", out.String())
+		}
+	})
+
+	for _, bad := range []string{"rw", "0", "1777", "9"} {
+		scriptPath, target := writeApplyFixture(t, "")
+		err := runApplyInplace([]string{"--mode", bad, scriptPath, target}, &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), "invalid --mode") {
+			t.Errorf("--mode %s: error = %v, want invalid --mode", bad, err)
+		}
+	}
+}
+
+func TestApplyInplaceCommand_Errors(t *testing.T) {
+	scriptPath, target := writeApplyFixture(t, "")
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no args", nil},
+		{"missing target", []string{scriptPath}},
+		{"too many args", []string{scriptPath, target, target}},
+		{"unknown flag", []string{"--bogus", scriptPath, target}},
+		{"missing script", []string{filepath.Join(t.TempDir(), "nope"), target}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := runApplyInplace(tt.args, &bytes.Buffer{}); err == nil {
+				t.Error("runApplyInplace() expected error")
+			}
+		})
+	}
+}
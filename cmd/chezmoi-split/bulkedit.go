@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+
+	"github.com/thirteen37/chezmoi-split/internal/atomicfile"
+	"github.com/thirteen37/chezmoi-split/internal/script"
+	"github.com/thirteen37/chezmoi-split/internal/scriptedit"
+	"github.com/thirteen37/chezmoi-split/internal/stats"
+)
+
+// stringsFlag collects every value of a repeatable flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ", ") }
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// bulkEdit is one script bulk-edit will rewrite.
+type bulkEdit struct {
+	path    string
+	content string
+	summary []string
+}
+
+// runBulkEdit implements "chezmoi-split bulk-edit": it changes directives in
+// every chezmoi-split script under the given source directories (or in the
+// given scripts) that matches all --where conditions and, with
+// --target-glob, whose target matches the glob. The --set name=value changes
+// apply first, in order, then the --unset name ones; a line per script lists
+// what changed. Every edited script must still parse, or nothing is written.
+// --dry-run only prints the changes.
+func runBulkEdit(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("bulk-edit", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var where, sets, unsets stringsFlag
+	fs.Var(&where, "where", "only edit scripts whose directive has this value (name=value, repeatable)")
+	fs.Var(&sets, "set", "set a directive (name=value, repeatable)")
+	fs.Var(&unsets, "unset", "remove a directive (name, repeatable)")
+	targetGlob := fs.String("target-glob", "", "only edit scripts whose target, relative to $HOME, matches this glob")
+	dryRun := fs.Bool("dry-run", false, "print the changes without writing them")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("bulk-edit: %w", err)
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("bulk-edit: expected at least one source directory or script")
+	}
+
+	var conditions []scriptedit.Condition
+	for _, w := range where {
+		c, err := scriptedit.ParseCondition(w)
+		if err != nil {
+			return fmt.Errorf("bulk-edit: --where %w", err)
+		}
+		conditions = append(conditions, c)
+	}
+	// Flags of one kind keep their order; sets run before unsets
+	var changes []scriptedit.Change
+	for _, s := range sets {
+		c, err := scriptedit.ParseSet(s)
+		if err != nil {
+			return fmt.Errorf("bulk-edit: --set %w", err)
+		}
+		changes = append(changes, c)
+	}
+	for _, u := range unsets {
+		c, err := scriptedit.ParseUnset(u)
+		if err != nil {
+			return fmt.Errorf("bulk-edit: --unset %w", err)
+		}
+		changes = append(changes, c)
+	}
+	if len(changes) == 0 {
+		return fmt.Errorf("bulk-edit: nothing to change; pass --set or --unset")
+	}
+	if *targetGlob != "" {
+		if _, err := pathpkg.Match(*targetGlob, ""); err != nil {
+			return fmt.Errorf("bulk-edit: --target-glob: %w", err)
+		}
+	}
+
+	scripts, err := findScripts(fs.Args())
+	if err != nil {
+		return fmt.Errorf("bulk-edit: %w", err)
+	}
+
+	var edits []bulkEdit
+	var failures []string
+	matched := 0
+	for _, s := range scripts {
+		if *targetGlob != "" {
+			if ok, _ := pathpkg.Match(*targetGlob, s.target); !ok {
+				continue
+			}
+		}
+		data, err := os.ReadFile(s.path)
+		if err != nil {
+			return fmt.Errorf("bulk-edit: %w", err)
+		}
+		content := string(data)
+		if !matchesAll(conditions, content) {
+			continue
+		}
+		matched++
+
+		edited, summary, err := scriptedit.Apply(content, changes)
+		if err == nil && len(summary) > 0 {
+			_, err = script.Parse(edited)
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", s.path, err))
+			continue
+		}
+		if len(summary) > 0 {
+			edits = append(edits, bulkEdit{path: s.path, content: edited, summary: summary})
+		}
+	}
+
+	for _, e := range edits {
+		fmt.Fprintf(stdout, "%s: %s\n", e.path, strings.Join(e.summary, "; "))
+	}
+	if len(failures) > 0 {
+		for _, f := range failures {
+			fmt.Fprintf(stdout, "%s\n", f)
+		}
+		return fmt.Errorf("bulk-edit: %d of %d matching scripts can't be edited; nothing written", len(failures), matched)
+	}
+
+	if *dryRun {
+		fmt.Fprintf(stdout, "Would change %d of %d matching scripts\n", len(edits), matched)
+		return nil
+	}
+	for _, e := range edits {
+		if err := atomicfile.WriteFile(e.path, []byte(e.content), 0o644); err != nil {
+			return fmt.Errorf("bulk-edit: %w", err)
+		}
+	}
+	fmt.Fprintf(stdout, "Changed %d of %d matching scripts\n", len(edits), matched)
+	return nil
+}
+
+// matchesAll reports whether content meets every condition.
+func matchesAll(conditions []scriptedit.Condition, content string) bool {
+	for _, c := range conditions {
+		if !c.Matches(content) {
+			return false
+		}
+	}
+	return true
+}
+
+// sourceScript is a chezmoi-split script found by findScripts.
+type sourceScript struct {
+	path   string
+	target string // Target name relative to $HOME
+}
+
+// findScripts returns the chezmoi-split scripts among paths: scripts given
+// directly, and files under directories (skipping hidden ones such as .git)
+// whose shebang runs chezmoi-split. A script's target is named from its
+// path relative to the directory it was found in.
+func findScripts(paths []string) ([]sourceScript, error) {
+	var scripts []sourceScript
+	for _, root := range paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			scripts = append(scripts, sourceScript{path: root, target: stats.SourceTarget(filepath.Base(root))})
+			continue
+		}
+		err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if p != root && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() || !isChezmoiSplitScript(p) {
+				return nil
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			scripts = append(scripts, sourceScript{path: p, target: stats.SourceTarget(rel)})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return scripts, nil
+}
+
+// isChezmoiSplitScript reports whether the file at p starts with a shebang
+// that runs chezmoi-split.
+func isChezmoiSplitScript(p string) bool {
+	f, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	buf := make([]byte, 256)
+	n, _ := io.ReadFull(f, buf)
+	first, _, _ := bytes.Cut(buf[:n], []byte("\n"))
+	return bytes.HasPrefix(first, []byte("#!")) && bytes.Contains(first, []byte("chezmoi-split"))
+}
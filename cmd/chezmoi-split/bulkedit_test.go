@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFixtureRepo writes a small chezmoi source directory and returns its
+// path and the contents of each file by relative path.
+func writeFixtureRepo(t *testing.T) (string, map[string]string) {
+	t.Helper()
+	files := map[string]string{
+		"dot_config/foo/modify_app.ini": `#!/usr/bin/env chezmoi-split
+# version 1
+# format ini
+# ignore ["user", "email"]
+#---
+[user]
+email = jane@example.com
+`,
+		"dot_config/foo/modify_private_other.ini.tmpl": `#!/usr/bin/env chezmoi-split
+# version 1
+# format ini
+#---
+[ui]
+theme = "dark"
+`,
+		"dot_config/bar/modify_tool.ini": `#!/usr/bin/env chezmoi-split
+# version 1
+# format ini
+#---
+[tool]
+name = "bar"
+`,
+		"dot_config/foo/modify_settings.json": `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+#---
+{"theme": "dark"}
+`,
+		// Not chezmoi-split scripts
+		"dot_config/foo/readme.txt": "# format ini\n#---\n",
+		".git/modify_hook":          "#!/usr/bin/env chezmoi-split\n# version 1\n# format ini\n#---\n",
+	}
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, files
+}
+
+func TestBulkEditCommand(t *testing.T) {
+	t.Run("dry run prints the changes and writes nothing", func(t *testing.T) {
+		dir, files := writeFixtureRepo(t)
+		var out strings.Builder
+		err := runBulkEdit([]string{"--where", "format=ini", "--set", "format=toml", "--target-glob", ".config/foo/*", "--dry-run", dir}, &out)
+		if err != nil {
+			t.Fatalf("runBulkEdit() error = %v", err)
+		}
+		want := filepath.Join(dir, "dot_config/foo/modify_app.ini") + ": set format toml (was ini)\n" +
+			filepath.Join(dir, "dot_config/foo/modify_private_other.ini.tmpl") + ": set format toml (was ini)\n" +
+			"Would change 2 of 2 matching scripts\n"
+		if out.String() != want {
+			t.Errorf("output =\n%s\nwant:\n%s", out.String(), want)
+		}
+		for name, content := range files {
+			if got, _ := os.ReadFile(filepath.Join(dir, name)); string(got) != content {
+				t.Errorf("%s changed during a dry run:\n%s", name, got)
+			}
+		}
+	})
+
+	t.Run("writes matching scripts only", func(t *testing.T) {
+		dir, files := writeFixtureRepo(t)
+		var out strings.Builder
+		err := runBulkEdit([]string{"--where", "format=ini", "--set", "canonical-order=template", "--unset", "ignore", dir}, &out)
+		if err != nil {
+			t.Fatalf("runBulkEdit() error = %v", err)
+		}
+		if !strings.HasSuffix(out.String(), "Changed 3 of 3 matching scripts\n") {
+			t.Errorf("output =\n%s", out.String())
+		}
+
+		got, _ := os.ReadFile(filepath.Join(dir, "dot_config/foo/modify_app.ini"))
+		want := `#!/usr/bin/env chezmoi-split
+# version 1
+# format ini
+# canonical-order template
+#---
+[user]
+email = jane@example.com
+`
+		if string(got) != want {
+			t.Errorf("modify_app.ini =\n%s\nwant:\n%s", got, want)
+		}
+		for _, name := range []string{"dot_config/foo/modify_settings.json", "dot_config/foo/readme.txt", ".git/modify_hook"} {
+			if got, _ := os.ReadFile(filepath.Join(dir, name)); string(got) != files[name] {
+				t.Errorf("%s should not change:\n%s", name, got)
+			}
+		}
+	})
+
+	t.Run("an invalid result writes nothing", func(t *testing.T) {
+		dir, files := writeFixtureRepo(t)
+		var out strings.Builder
+		err := runBulkEdit([]string{"--set", "array-merge=sideways", dir}, &out)
+		if err == nil || !strings.Contains(err.Error(), "4 of 4 matching scripts can't be edited; nothing written") {
+			t.Errorf("runBulkEdit() error = %v, want a parse failure", err)
+		}
+		if !strings.Contains(out.String(), "array-merge must be one of") {
+			t.Errorf("output = %s, want the parse error", out.String())
+		}
+		for name, content := range files {
+			if got, _ := os.ReadFile(filepath.Join(dir, name)); string(got) != content {
+				t.Errorf("%s changed after a failure:\n%s", name, got)
+			}
+		}
+	})
+
+	t.Run("bad arguments", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"."},
+			{"--set", "format", "."},
+			{"--where", "format", "--set", "format=toml", "."},
+			{"--set", "format=toml"},
+			{"--set", "format=toml", "--target-glob", "[", "."},
+		} {
+			if err := runBulkEdit(args, &strings.Builder{}); err == nil {
+				t.Errorf("runBulkEdit(%q) should fail", args)
+			}
+		}
+	})
+}
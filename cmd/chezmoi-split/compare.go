@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/thirteen37/chezmoi-split/internal/stats"
+	"github.com/thirteen37/chezmoi-split/internal/textdiff"
+)
+
+// runCompare implements "chezmoi-split compare [--target-file <file>] <old-script>
+// <new-script>": it merges the same current file with both scripts and prints
+// a unified diff from the old script's output to the new one's, showing what
+// a script edit changes for a real config. The current file is the new
+// script's chezmoi target in $HOME, or --target-file, which may also follow the
+// scripts. Differences make the command fail, like diff; nothing is written
+// and no stats are recorded.
+func runCompare(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var tf targetFlags
+	tf.register(fs)
+	tf.registerCurrentAlias(fs)
+	var scripts []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return fmt.Errorf("compare: %w", err)
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		scripts = append(scripts, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(scripts) != 2 {
+		return fmt.Errorf("compare: expected an old and a new script, got %d arguments", len(scripts))
+	}
+	oldPath, newPath := scripts[0], scripts[1]
+
+	home, _ := os.UserHomeDir()
+	target := stats.TargetName(newPath)
+	currentData, err := tf.read(target, home)
+	if err != nil {
+		return fmt.Errorf("compare: %w", err)
+	}
+
+	oldOut, err := compareOutput(oldPath, currentData)
+	if err != nil {
+		return fmt.Errorf("compare: %w", err)
+	}
+	newOut, err := compareOutput(newPath, currentData)
+	if err != nil {
+		return fmt.Errorf("compare: %w", err)
+	}
+
+	out := textdiff.Unified(oldPath, newPath, oldOut, newOut)
+	if out == "" {
+		return nil
+	}
+	if _, err := io.WriteString(stdout, out); err != nil {
+		return err
+	}
+	return fmt.Errorf("compare: %s and %s produce different output for %s", oldPath, newPath, tf.path(target, home))
+}
+
+// compareOutput loads the script at scriptPath and returns what it writes
+// for currentData, printing its warnings to stderr.
+func compareOutput(scriptPath string, currentData []byte) ([]byte, error) {
+	scr, err := loadScript(scriptPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", scriptPath, err)
+	}
+	for _, warning := range scr.Warnings {
+		fmt.Fprintf(os.Stderr, "chezmoi-split: warning: %s: %s\n", scriptPath, warning)
+	}
+
+	var buf bytes.Buffer
+	if err := mergeScript(scr, "", currentData, &buf); err != nil {
+		return nil, fmt.Errorf("%s: %w", scriptPath, err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompareCommand(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("CHEZMOI_SOURCE_DIR", "")
+	t.Setenv("CHEZMOI_SOURCE_FILE", "")
+	t.Setenv("CHEZMOI_SPLIT_STATS_DIR", filepath.Join(dir, "stats"))
+
+	write := func(name, content string) string {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	const template = `#---
+{
+  "theme": "dark",
+  "fontSize": 14
+}
+`
+	oldScript := write("old_settings.json", "#!/usr/bin/env chezmoi-split\n# version 1\n# format json\n# ignore [\"theme\"]\n"+template)
+	newScript := write("new_settings.json", "#!/usr/bin/env chezmoi-split\n# version 1\n# format json\n# ignore [\"theme\"]\n# ignore [\"fontSize\"]\n"+template)
+	current := write("fixture.json", `{"theme": "light", "fontSize": 12}`)
+
+	want := "--- " + oldScript + "\n+++ " + newScript + `
+@@ -1,4 +1,4 @@
+ {
+   "theme": "light",
+-  "fontSize": 14
++  "fontSize": 12
+ }
+`
+	for _, args := range [][]string{
+		{"--target-file", current, oldScript, newScript},
+		{oldScript, newScript, "--target-file", current},
+		{"--current", current, oldScript, newScript}, // deprecated alias
+	} {
+		var out bytes.Buffer
+		err := runCompare(args, &out)
+		if err == nil || !strings.Contains(err.Error(), "produce different output for "+current) {
+			t.Errorf("runCompare(%q) error = %v, want a difference error", args, err)
+		}
+		if out.String() != want {
+			t.Errorf("runCompare(%q) output =\n%s\nwant:\n%s", args, out.String(), want)
+		}
+	}
+
+	t.Run("same output", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := runCompare([]string{"--target-file", current, oldScript, oldScript}, &out); err != nil {
+			t.Errorf("runCompare() error = %v", err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("output = %q, want none", out.String())
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		tests := []struct {
+			name string
+			args []string
+			want string
+		}{
+			{"one script", []string{oldScript}, "expected an old and a new script, got 1"},
+			{"missing current", []string{"--target-file", filepath.Join(dir, "nope"), oldScript, newScript}, "failed to read target file"},
+			{"missing script", []string{"--target-file", current, oldScript, filepath.Join(dir, "nope")}, "nope"},
+		}
+		for _, tt := range tests {
+			err := runCompare(tt.args, &bytes.Buffer{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s: runCompare() error = %v, want containing %q", tt.name, err, tt.want)
+			}
+		}
+	})
+
+	if _, err := os.Stat(filepath.Join(dir, "stats")); !os.IsNotExist(err) {
+		t.Errorf("compare recorded stats: %v", err)
+	}
+}
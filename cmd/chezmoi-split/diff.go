@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/thirteen37/chezmoi-split/internal/fingerprint"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	formatplaintext "github.com/thirteen37/chezmoi-split/internal/format/plaintext"
+	"github.com/thirteen37/chezmoi-split/internal/script"
+	"github.com/thirteen37/chezmoi-split/internal/stats"
+	"github.com/thirteen37/chezmoi-split/internal/textdiff"
+)
+
+// runDiff implements "chezmoi-split diff [--target-file <file>] <script>": it
+// merges the live target into the script's managed config, as chezmoi would
+// through the interpreter, and prints a unified diff from the target to the
+// merged result. The target is the script's chezmoi target in $HOME, or
+// --target-file. Differences make the command fail, so scripts can check for
+// them; nothing is written and no stats are recorded. With a fingerprint, a
+// line before the diff says when the target has none or a stale one. For a plaintext
+// script, a hunk whose added lines all came from one ignored block of the
+// target is marked "app-owned", so a review can skip it.
+func runDiff(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var tf targetFlags
+	tf.register(fs)
+	tf.registerCurrentAlias(fs)
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("diff: %w", err)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("diff: expected exactly one script, got %d arguments", fs.NArg())
+	}
+	scriptPath := fs.Arg(0)
+
+	scr, err := loadScript(scriptPath)
+	if err != nil {
+		return fmt.Errorf("diff: %w", err)
+	}
+	for _, warning := range scr.Warnings {
+		fmt.Fprintf(os.Stderr, "chezmoi-split: warning: %s\n", warning)
+	}
+
+	home, _ := os.UserHomeDir()
+	target := stats.TargetName(scriptPath)
+	currentData, err := tf.read(target, home)
+	if err != nil {
+		return fmt.Errorf("diff: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := mergeScript(scr, "", currentData, &buf); err != nil {
+		return fmt.Errorf("diff: %w", err)
+	}
+
+	var note func(start, end int) string
+	if scr.Format == "plaintext" && len(currentData) > 0 {
+		text, err := decodeCurrent(scr, currentData)
+		if err != nil {
+			return fmt.Errorf("diff: %w", err)
+		}
+		spans, err := plaintextSpans(scr, text)
+		if err != nil {
+			return fmt.Errorf("diff: %w", err)
+		}
+		note = appOwnedNote(spans)
+	}
+
+	name := tf.path(target, home)
+	out := textdiff.UnifiedNotes(name, name+" (merged)", currentData, buf.Bytes(), note)
+	if out == "" {
+		return nil
+	}
+	if stale := fingerprintNote(scr, currentData); stale != "" {
+		fmt.Fprintf(stdout, "%s %s\n", name, stale)
+	}
+	if _, err := io.WriteString(stdout, out); err != nil {
+		return err
+	}
+	return fmt.Errorf("diff: merging would change %s", name)
+}
+
+// appOwnedNote returns a textdiff note naming the span of the current file
+// that holds all of a hunk's added lines, if one does.
+func appOwnedNote(spans []formatplaintext.Span) func(start, end int) string {
+	return func(start, end int) string {
+		for _, s := range spans {
+			if s.Source != formatplaintext.SourceCurrent || start < s.Start || end > s.End {
+				continue
+			}
+			lines := fmt.Sprintf("lines %d-%d", s.Start, s.End)
+			if s.Start == s.End {
+				lines = fmt.Sprintf("line %d", s.Start)
+			}
+			return fmt.Sprintf("app-owned: %s from the target (ignored block #%d)", lines, s.Ignored)
+		}
+		return ""
+	}
+}
+
+// fingerprintNote says why data's fingerprint doesn't match the script's
+// template, or returns "" when it does or the script has none.
+func fingerprintNote(scr *script.Script, data []byte) string {
+	if !scr.Fingerprint || len(data) == 0 {
+		return ""
+	}
+	text, err := decodeCurrent(scr, data)
+	if err != nil {
+		return ""
+	}
+	opts := format.ParseOptions{StripComments: scr.StripComments, JSON5: scr.JSON5}
+	hash, ok := fingerprint.Extract(scr.StripHeader(text), scr.Format, getHandler(scr.Format), opts, scr.FingerprintKey)
+	switch {
+	case !ok:
+		return "has no fingerprint; this script hasn't written it yet"
+	case hash != fingerprint.Compute(scr.Body()):
+		return "has a stale fingerprint; it was written from another version of the template"
+	}
+	return ""
+}
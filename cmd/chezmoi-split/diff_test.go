@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffCommand(t *testing.T) {
+	home := t.TempDir()
+	source := filepath.Join(home, ".local", "share", "chezmoi")
+	t.Setenv("HOME", home)
+	t.Setenv("CHEZMOI_SOURCE_DIR", source)
+	t.Setenv("CHEZMOI_SOURCE_FILE", "")
+	t.Setenv("CHEZMOI_SPLIT_STATS_DIR", filepath.Join(home, "stats"))
+
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore ["theme"]
+#---
+{
+  "theme": "dark",
+  "fontSize": 14
+}
+`
+	scriptPath := filepath.Join(source, "dot_config", "app", "modify_settings.json")
+	if err := os.MkdirAll(filepath.Dir(scriptPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(scriptPath, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(home, ".config", "app", "settings.json")
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, []byte("{\n  \"theme\": \"light\",\n  \"fontSize\": 12\n}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("differences", func(t *testing.T) {
+		var out bytes.Buffer
+		err := runDiff([]string{scriptPath}, &out)
+		if err == nil || !strings.Contains(err.Error(), "merging would change "+target) {
+			t.Errorf("runDiff() error = %v, want a difference error", err)
+		}
+		want := "--- " + target + "\n+++ " + target + ` (merged)
+@@ -1,4 +1,4 @@
+ {
+   "theme": "light",
+-  "fontSize": 12
++  "fontSize": 14
+ }
+`
+		if out.String() != want {
+			t.Errorf("output =\n%s\nwant:\n%s", out.String(), want)
+		}
+	})
+
+	t.Run("no differences with --target-file", func(t *testing.T) {
+		upToDate := filepath.Join(home, "settings.json")
+		if err := os.WriteFile(upToDate, []byte("{\n  \"theme\": \"light\",\n  \"fontSize\": 14\n}\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		// --current is the deprecated spelling of --target-file
+		for _, flag := range []string{"--target-file", "--current"} {
+			var out bytes.Buffer
+			if err := runDiff([]string{flag, upToDate, scriptPath}, &out); err != nil {
+				t.Errorf("runDiff(%s) error = %v", flag, err)
+			}
+			if out.Len() != 0 {
+				t.Errorf("runDiff(%s) output = %q, want none", flag, out.String())
+			}
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		tests := []struct {
+			name string
+			args []string
+			want string
+		}{
+			{"no script", nil, "expected exactly one script"},
+			{"missing current", []string{"--target-file", filepath.Join(home, "nope"), scriptPath}, "failed to read target file"},
+		}
+		for _, tt := range tests {
+			err := runDiff(tt.args, &bytes.Buffer{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s: runDiff() error = %v, want containing %q", tt.name, err, tt.want)
+			}
+		}
+	})
+
+	if _, err := os.Stat(filepath.Join(home, "stats")); !os.IsNotExist(err) {
+		t.Errorf("diff recorded stats: %v", err)
+	}
+}
+
+func TestDiffCommand_PlaintextAppOwned(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CHEZMOI_SPLIT_STATS_DIR", filepath.Join(dir, "stats"))
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format plaintext
+#---
+" chezmoi:managed
+set number
+set ruler
+set hlsearch
+set incsearch
+set showcmd
+set laststatus=2
+set cursorline
+" chezmoi:ignored sort
+" chezmoi:end
+`
+	scriptPath := filepath.Join(dir, "modify_dot_vimrc")
+	if err := os.WriteFile(scriptPath, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	current := filepath.Join(dir, "vimrc")
+	if err := os.WriteFile(current, []byte(`" chezmoi:managed
+set nonumber
+set ruler
+set hlsearch
+set incsearch
+set showcmd
+set laststatus=2
+set cursorline
+" chezmoi:ignored sort
+set wrap
+set list
+" chezmoi:end
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := runDiff([]string{"--target-file", current, scriptPath}, &out); err == nil {
+		t.Error("runDiff() should report the differences")
+	}
+	// The template's change is not app-owned; the sorted block is
+	want := "--- " + current + "\n+++ " + current + ` (merged)
+@@ -1,5 +1,5 @@
+ " chezmoi:managed
+-set nonumber
++set number
+ set ruler
+ set hlsearch
+ set incsearch
+@@ -7,6 +7,6 @@ app-owned: lines 10-11 from the target (ignored block #1)
+ set laststatus=2
+ set cursorline
+ " chezmoi:ignored sort
+-set wrap
+ set list
++set wrap
+ " chezmoi:end
+`
+	if out.String() != want {
+		t.Errorf("output =\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestDiffCommand_StaleFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CHEZMOI_SPLIT_STATS_DIR", filepath.Join(dir, "stats"))
+	script := "#!/usr/bin/env chezmoi-split\n# version 1\n# format toml\n# fingerprint true\n#---\na = 1\n"
+	scriptPath := filepath.Join(dir, "modify_app.toml")
+	if err := os.WriteFile(scriptPath, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	current := filepath.Join(dir, "app.toml")
+
+	tests := []struct {
+		name    string
+		content string
+		want    string // First line of the output; "" for no output
+	}{
+		{"missing", "a = 1\n", current + " has no fingerprint; this script hasn't written it yet"},
+		{"stale", "# chezmoi-split:fingerprint 000000000000\na = 1\n", current + " has a stale fingerprint; it was written from another version of the template"},
+		{"fresh", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := tt.content
+			if content == "" {
+				var buf bytes.Buffer
+				scr, err := loadScript(scriptPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := mergeScript(scr, "", nil, &buf); err != nil {
+					t.Fatal(err)
+				}
+				content = buf.String()
+			}
+			if err := os.WriteFile(current, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			_ = runDiff([]string{"--target-file", current, scriptPath}, &out)
+			first, _, _ := strings.Cut(out.String(), "\n")
+			if first != tt.want {
+				t.Errorf("first line = %q, want %q", first, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/thirteen37/chezmoi-split/internal/debugdump"
+	"github.com/thirteen37/chezmoi-split/internal/script"
+	"github.com/thirteen37/chezmoi-split/internal/stats"
+)
+
+// runDoctor implements "chezmoi-split doctor --from-dump <file>": it
+// summarizes a debug dump written under CHEZMOI_SPLIT_DEBUG_DUMP and lists
+// the anomalies found in it.
+func runDoctor(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fromDump := fs.String("from-dump", "", "analyze this debug dump file")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("doctor: %w", err)
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("doctor: unexpected arguments %q", fs.Args())
+	}
+	if *fromDump == "" {
+		return fmt.Errorf("doctor: --from-dump is required; set %s=<dir> to record dumps", debugdump.DirEnv)
+	}
+
+	d, err := debugdump.Load(*fromDump)
+	if err != nil {
+		return fmt.Errorf("doctor: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "Run at %s: %s (exit status %d)\n", d.Time.Local().Format("2006-01-02 15:04:05"), d.Script, d.ExitStatus)
+	if d.Error != "" {
+		fmt.Fprintf(stdout, "Error: %s\n", d.Error)
+	}
+
+	findings := debugdump.Analyze(d)
+	if len(findings) == 0 {
+		fmt.Fprintln(stdout, "No anomalies found")
+		return nil
+	}
+	fmt.Fprintln(stdout)
+	for _, finding := range findings {
+		fmt.Fprintf(stdout, "- %s\n", finding)
+	}
+	return nil
+}
+
+// writeDebugDump records an interpreter run in dir. stdin is only described
+// if it was read. Failures only produce a warning, so a dump never breaks
+// chezmoi apply.
+func writeDebugDump(dir, scriptPath string, scr *script.Script, stdin []byte, stdinRead bool, runErr error) {
+	home, _ := os.UserHomeDir()
+	d := &debugdump.Dump{
+		Time:             time.Now(),
+		Version:          version,
+		Env:              debugdump.Environ(os.Environ(), home),
+		Script:           debugdump.HidePath(scriptPath, home),
+		SupportedVersion: script.CurrentVersion,
+		Target:           stats.TargetName(scriptPath),
+	}
+	for _, arg := range os.Args {
+		d.Args = append(d.Args, debugdump.HidePath(arg, home))
+	}
+
+	if data, err := os.ReadFile(scriptPath); err == nil {
+		d.ScriptFile = debugdump.NewInput(data)
+	}
+	var versionErr *script.VersionError
+	switch {
+	case scr != nil:
+		d.ScriptVersion = scr.Version
+	case errors.As(runErr, &versionErr):
+		d.ScriptVersion = versionErr.Version
+	}
+	if stdinRead {
+		d.Stdin = debugdump.NewInput(stdin)
+	}
+	if home != "" {
+		if data, err := os.ReadFile(homePath(d.Target, home)); err == nil {
+			d.TargetFile = debugdump.NewInput(data)
+		}
+	}
+	if runErr != nil {
+		d.ExitStatus = 1
+		d.Error = runErr.Error()
+	}
+
+	if _, err := debugdump.Write(dir, d, debugdump.DefaultKeep); err != nil {
+		fmt.Fprintf(os.Stderr, "chezmoi-split: warning: failed to write debug dump: %v\n", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	formatjson "github.com/thirteen37/chezmoi-split/internal/format/json"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+	"github.com/thirteen37/chezmoi-split/internal/script"
+)
+
+// runGet implements "chezmoi-split get [--format <format>] <target> <path>":
+// it parses the live target file and prints the value at path as JSON. The
+// format is guessed from the target's name and content unless --format is
+// given. A path that isn't in the file makes the command fail. An empty
+// path prints the whole file.
+func runGet(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	formatName := fs.String("format", "", "format of the target file instead of guessing it")
+	var tf targetFlags
+	tf.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("get: %w", err)
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("get: expected a target and a path, got %d arguments", fs.NArg())
+	}
+	target := fs.Arg(0)
+	p, err := path.ParseArrayPath(fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("get: %w", err)
+	}
+
+	home, _ := os.UserHomeDir()
+	data, err := tf.read(target, home)
+	if err != nil {
+		return fmt.Errorf("get: %w", err)
+	}
+	name := tf.path(target, home)
+	if len(data) == 0 {
+		return fmt.Errorf("get: %s is empty or doesn't exist", name)
+	}
+
+	if *formatName == "" {
+		*formatName = format.Detect(data, name)
+	}
+	switch {
+	case *formatName == "plaintext":
+		return fmt.Errorf("get: %s is plaintext, which has no paths; pass --format", name)
+	case *formatName == "auto" || !slices.Contains(script.SupportedFormats, *formatName):
+		return fmt.Errorf("get: unsupported format %q", *formatName)
+	}
+
+	// Apps such as VS Code and Zed write comments in their JSON settings
+	handler := getHandler(*formatName)
+	tree, err := handler.Parse(data, format.ParseOptions{StripComments: *formatName == "json"})
+	if err != nil {
+		return fmt.Errorf("get: %s: %w", name, err)
+	}
+
+	val, ok := tree, tree != nil
+	if len(p.Segments()) > 0 {
+		val, ok = handler.GetPath(tree, p)
+	}
+	if !ok {
+		return fmt.Errorf("get: %s not found in %s", p, name)
+	}
+
+	val, err = format.NormalizeForFormat(val, "json")
+	if err != nil {
+		return fmt.Errorf("get: %s: %w", p, err)
+	}
+	out, err := formatjson.New().Serialize(val, format.SerializeOptions{})
+	if err != nil {
+		return fmt.Errorf("get: %w", err)
+	}
+	_, err = stdout.Write(out)
+	return err
+}
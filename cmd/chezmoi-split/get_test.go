@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetCommand(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	write := func(name, content string) {
+		t.Helper()
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".config/zed/settings.json", `// Zed settings
+{
+  "agent": {"default_model": {"provider": "anthropic", "model": "claude"}},
+  "tab_size": 4,
+}
+`)
+	write(".config/app/config.toml", "[server]\nport = 8080\n")
+	write(".config/app/settings", "[server]\nport = 8080\n")
+	write(".config/app/notes", "remember the milk\n")
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "object as JSON",
+			args: []string{".config/zed/settings.json", `["agent", "default_model"]`},
+			want: "{\n  \"provider\": \"anthropic\",\n  \"model\": \"claude\"\n}\n",
+		},
+		{
+			name: "number as written",
+			args: []string{"~/.config/zed/settings.json", `["tab_size"]`},
+			want: "4\n",
+		},
+		{
+			name: "format from the extension",
+			args: []string{".config/app/config.toml", `["server", "port"]`},
+			want: "8080\n",
+		},
+		{
+			name: "format flag",
+			args: []string{"--format", "ini", ".config/app/settings", `["server", "port"]`},
+			want: "\"8080\"\n",
+		},
+		{
+			name: "empty path is the whole file",
+			args: []string{".config/app/config.toml", `[]`},
+			want: "{\n  \"server\": {\n    \"port\": 8080\n  }\n}\n",
+		},
+		{
+			name: "target file flag",
+			args: []string{"--target-file", filepath.Join(dir, ".config/app/config.toml"), "elsewhere.toml", `["server"]`},
+			want: "{\n  \"port\": 8080\n}\n",
+		},
+		{
+			name:    "missing path",
+			args:    []string{".config/zed/settings.json", `["agent", "missing"]`},
+			wantErr: `["agent","missing"] not found in ` + filepath.Join(dir, ".config/zed/settings.json"),
+		},
+		{
+			name:    "missing file",
+			args:    []string{".config/none.json", `["a"]`},
+			wantErr: "is empty or doesn't exist",
+		},
+		{
+			name:    "plaintext has no paths",
+			args:    []string{".config/app/notes", `["server"]`},
+			wantErr: "plaintext, which has no paths",
+		},
+		{
+			name:    "unknown format",
+			args:    []string{"--format", "xml", ".config/app/settings", `["server"]`},
+			wantErr: `unsupported format "xml"`,
+		},
+		{
+			name:    "bad path",
+			args:    []string{".config/app/config.toml", `server.port`},
+			wantErr: "invalid path array",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := runGet(tt.args, &out)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("runGet() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("runGet() error = %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("output = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+	"github.com/thirteen37/chezmoi-split/internal/script"
+)
+
+// Lint codes, one per kind of finding.
+const (
+	lintNestedIgnore      = "nested-ignore"       // An ignore path lies inside another one
+	lintStripCommentsType = "strip-comments-type" // strip-comments with a format other than json
+	lintPlaintextIgnore   = "plaintext-ignore"    // ignore directives in a plaintext script
+	lintAutoFormat        = "auto-format"         // The format is left to auto-detection
+	lintUnmatchedWildcard = "unmatched-wildcard"  // A wildcard path matches nothing in --current
+)
+
+// lintFinding is one smell found in a script.
+type lintFinding struct {
+	line int // Script line number; 0 for the script as a whole
+	code string
+	msg  string
+}
+
+// runLint implements "chezmoi-split lint [--current <file>] <script>...": it
+// looks for directives that parse but probably don't do what the author
+// meant, and prints each as file:line: code: message. Unlike validate, which
+// checks that a script can run, lint checks how it is written. With
+// --current, wildcard paths are also checked against that sample current
+// file, so only one script may be given. Any finding makes the command fail.
+func runLint(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	current := fs.String("current", "", "check that wildcard paths match something in this sample current file")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("lint: %w", err)
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("lint: expected at least one script path")
+	}
+	if *current != "" && fs.NArg() > 1 {
+		return fmt.Errorf("lint: --current needs exactly one script, got %d", fs.NArg())
+	}
+
+	var currentData []byte
+	if *current != "" {
+		var err error
+		if currentData, err = os.ReadFile(*current); err != nil {
+			return fmt.Errorf("lint: %w", err)
+		}
+	}
+
+	total, flagged := 0, 0
+	for _, scriptPath := range fs.Args() {
+		scr, err := loadScript(scriptPath)
+		if err != nil {
+			if inner := errors.Unwrap(err); inner != nil {
+				err = inner
+			}
+			return fmt.Errorf("lint: %s: %w", scriptPath, err)
+		}
+
+		findings, err := lintScript(scr, currentData)
+		if err != nil {
+			return fmt.Errorf("lint: %s: %w", scriptPath, err)
+		}
+		for _, f := range findings {
+			if f.line > 0 {
+				fmt.Fprintf(stdout, "%s:%d: %s: %s\n", scriptPath, f.line, f.code, f.msg)
+			} else {
+				fmt.Fprintf(stdout, "%s: %s: %s\n", scriptPath, f.code, f.msg)
+			}
+		}
+		if len(findings) > 0 {
+			total += len(findings)
+			flagged++
+		}
+	}
+
+	if total > 0 {
+		return fmt.Errorf("lint: %d findings in %d of %d scripts", total, flagged, fs.NArg())
+	}
+	return nil
+}
+
+// lintScript returns the findings for scr, sorted by line. currentData, if
+// not nil, is a sample current file for the wildcard check.
+func lintScript(scr *script.Script, currentData []byte) ([]lintFinding, error) {
+	var findings []lintFinding
+
+	if scr.FormatDetected {
+		findings = append(findings, lintFinding{scr.DirectiveLine("format"), lintAutoFormat, fmt.Sprintf(
+			"format is auto-detected as %s; add '# format %s' so a change to the template can't switch it", scr.Format, scr.Format)})
+	}
+
+	if scr.StripComments && scr.Format != "json" {
+		findings = append(findings, lintFinding{scr.DirectiveLine("strip-comments"), lintStripCommentsType, fmt.Sprintf(
+			"strip-comments only applies to json, not %s", scr.Format)})
+	}
+
+	if scr.Format == "plaintext" {
+		for i, p := range scr.IgnorePaths {
+			findings = append(findings, lintFinding{scr.IgnoreLine(i), lintPlaintextIgnore, fmt.Sprintf(
+				"ignore path %s has no effect in plaintext; mark the lines with chezmoi:ignored blocks instead", p)})
+		}
+	}
+
+	for i, inner := range scr.IgnorePaths {
+		for j, outer := range scr.IgnorePaths {
+			if i != j && path.IsAncestor(outer, inner) {
+				findings = append(findings, lintFinding{scr.IgnoreLine(i), lintNestedIgnore, fmt.Sprintf(
+					"ignore path %s is inside ignore path %s on line %d, which already preserves it", inner, outer, scr.IgnoreLine(j))})
+				break
+			}
+		}
+	}
+
+	if currentData != nil && scr.Format != "plaintext" {
+		currentData, err := decodeCurrent(scr, currentData)
+		if err != nil {
+			return nil, err
+		}
+		currentData = scr.StripHeader(currentData)
+		// strip-comments on another format is already a finding; don't let it
+		// also stop the wildcard check
+		opts := format.ParseOptions{StripComments: scr.StripComments && scr.Format == "json", JSON5: scr.JSON5, KeepComments: scr.KeepComments && scr.Format == "json", MatchKey: scr.MatchKey}
+		tree, err := getHandler(scr.Format).Parse(currentData, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse --current as %s: %w", scr.Format, err)
+		}
+		check := func(kind string, line int, p path.Path) {
+			if path.HasWildcard(p) && len(format.ExpandPath(tree, p.Segments())) == 0 {
+				findings = append(findings, lintFinding{line, lintUnmatchedWildcard, fmt.Sprintf(
+					"%s path %s matches nothing in the current file", kind, p)})
+			}
+		}
+		for i, p := range scr.IgnorePaths {
+			check("ignore", scr.IgnoreLine(i), p)
+		}
+		for i, p := range scr.PreserveIfMissing {
+			check("preserve-if-missing", scr.PreserveLine(i), p)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].line < findings[j].line })
+	return findings, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLintCommand(t *testing.T) {
+	dir := t.TempDir()
+
+	// A script with several smells: no format, strip-comments on what turns
+	// out to be TOML, nested ignores, and wildcards the current file lacks
+	smelly := filepath.Join(dir, "modify_config.toml")
+	if err := os.WriteFile(smelly, []byte(`#!/usr/bin/env chezmoi-split
+# version 1
+# strip-comments true
+# ignore ["profiles"]
+# ignore ["profiles", "*", "font"]
+# ignore ["plugins", "*", "enabled"]
+# preserve-if-missing ["servers", "*"]
+#---
+[profiles.default]
+font = "mono"
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	current := filepath.Join(dir, "current.toml")
+	if err := os.WriteFile(current, []byte("[profiles.work]\nfont = \"serif\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	err := runLint([]string{"--current", current, smelly}, &out)
+	if err == nil || !strings.Contains(err.Error(), "5 findings in 1 of 1 scripts") {
+		t.Errorf("runLint() error = %v, want 5 findings", err)
+	}
+	want := smelly + `: auto-format: format is auto-detected as toml; add '# format toml' so a change to the template can't switch it
+` + smelly + `:3: strip-comments-type: strip-comments only applies to json, not toml
+` + smelly + `:5: nested-ignore: ignore path ["profiles","*","font"] is inside ignore path ["profiles"] on line 4, which already preserves it
+` + smelly + `:6: unmatched-wildcard: ignore path ["plugins","*","enabled"] matches nothing in the current file
+` + smelly + `:7: unmatched-wildcard: preserve-if-missing path ["servers","*"] matches nothing in the current file
+`
+	if out.String() != want {
+		t.Errorf("output =\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestLintCommand_Plaintext(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "script")
+	if err := os.WriteFile(scriptPath, []byte(`# version 1
+# format plaintext
+# ignore ["x"]
+#---
+# chezmoi:managed
+set number
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := runLint([]string{scriptPath}, &out); err == nil {
+		t.Error("runLint() should fail")
+	}
+	if want := scriptPath + `:3: plaintext-ignore: ignore path ["x"] has no effect in plaintext; mark the lines with chezmoi:ignored blocks instead`; !strings.Contains(out.String(), want) {
+		t.Errorf("output = %q, want containing %q", out.String(), want)
+	}
+}
+
+func TestLintCommand_Clean(t *testing.T) {
+	dir := t.TempDir()
+	scriptPath := filepath.Join(dir, "script")
+	if err := os.WriteFile(scriptPath, []byte(`# version 1
+# format json
+# ignore ["profiles", "*", "font"]
+#---
+{"profiles": {}}
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	current := filepath.Join(dir, "current.json")
+	if err := os.WriteFile(current, []byte(`{"profiles": {"work": {"font": "serif"}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := runLint([]string{"--current", current, scriptPath}, &out); err != nil {
+		t.Errorf("runLint() error = %v\n%s", err, out.String())
+	}
+	if out.Len() != 0 {
+		t.Errorf("output = %q, want none", out.String())
+	}
+}
+
+func TestLintCommand_Errors(t *testing.T) {
+	dir := t.TempDir()
+	scriptPath := filepath.Join(dir, "script")
+	if err := os.WriteFile(scriptPath, []byte("# version 1\n# format json\n#---\n{}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte("{oops"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"no scripts", nil, "expected at least one script path"},
+		{"current with two scripts", []string{"--current", bad, scriptPath, scriptPath}, "--current needs exactly one script"},
+		{"unparsable current", []string{"--current", bad, scriptPath}, "failed to parse --current as json"},
+		{"missing script", []string{filepath.Join(dir, "missing")}, "no such file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			if err := runLint(tt.args, &out); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("runLint() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
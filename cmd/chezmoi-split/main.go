@@ -2,19 +2,42 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/thirteen37/chezmoi-split/internal/charset"
+	"github.com/thirteen37/chezmoi-split/internal/debugdump"
+	"github.com/thirteen37/chezmoi-split/internal/fingerprint"
 	"github.com/thirteen37/chezmoi-split/internal/format"
+	formatdotenv "github.com/thirteen37/chezmoi-split/internal/format/dotenv"
+	formathcl "github.com/thirteen37/chezmoi-split/internal/format/hcl"
 	formatini "github.com/thirteen37/chezmoi-split/internal/format/ini"
 	formatjson "github.com/thirteen37/chezmoi-split/internal/format/json"
+	formatjsonl "github.com/thirteen37/chezmoi-split/internal/format/jsonl"
+	formatkdl "github.com/thirteen37/chezmoi-split/internal/format/kdl"
+	formatlua "github.com/thirteen37/chezmoi-split/internal/format/lua"
+	formatnginx "github.com/thirteen37/chezmoi-split/internal/format/nginx"
 	formatplaintext "github.com/thirteen37/chezmoi-split/internal/format/plaintext"
+	formatplist "github.com/thirteen37/chezmoi-split/internal/format/plist"
+	formatproperties "github.com/thirteen37/chezmoi-split/internal/format/properties"
+	formatreg "github.com/thirteen37/chezmoi-split/internal/format/reg"
+	formatsshconfig "github.com/thirteen37/chezmoi-split/internal/format/sshconfig"
+	formatsystemd "github.com/thirteen37/chezmoi-split/internal/format/systemd"
 	formattoml "github.com/thirteen37/chezmoi-split/internal/format/toml"
+	formatyaml "github.com/thirteen37/chezmoi-split/internal/format/yaml"
 	"github.com/thirteen37/chezmoi-split/internal/merge"
+	"github.com/thirteen37/chezmoi-split/internal/path"
 	"github.com/thirteen37/chezmoi-split/internal/script"
+	"github.com/thirteen37/chezmoi-split/internal/stats"
 )
 
 const usage = `chezmoi-split - merge chezmoi-managed config with app-managed paths
@@ -36,10 +59,58 @@ With contents like:
     "with": "{{ .chezmoi.templates }}"
   }
 
+Commands:
+
+  apply-inplace <script> <target>  Merge into a file directly and write it back (--backup keeps a copy)
+  bulk-edit [--where <name=value>] --set <name=value> | --unset <name> [--target-glob <glob>] [--dry-run] <source-dir>...
+                                   Change a directive in every matching script at once
+  compare [--current <file>] <old-script> <new-script>
+                                   Diff two scripts' output for the same current file
+  diff [--current <file>] <script> Show how merging would change the target, as a unified diff
+  doctor --from-dump <file>        Point out likely causes of a failed run in a CHEZMOI_SPLIT_DEBUG_DUMP dump
+  get [--format <format>] <target> <path>
+                                   Print the value at a path in a target file, as JSON
+  lint [--current <file>] <script>...
+                                   Point out directives that probably don't do what was meant
+  preview --script <script> [--current <file>] [--format <format>]
+                                   Print the merge of a sample current file without touching any files
+  stats <target>                   Show how often each ignore rule matched (needs CHEZMOI_SPLIT_STATS_DIR)
+  subtrees <script> <target>       Show only the parts of the config the ignore rules touch, managed vs merged
+  validate <script>...             Check modify scripts for directive and template errors
+
 See https://github.com/thirteen37/chezmoi-split for full documentation.
 `
 
+// version is set at build time by goreleaser.
+var version = "dev"
+
+// commands maps subcommand names to their implementations.
+var commands = map[string]func(args []string, stdout io.Writer) error{
+	"apply-inplace": runApplyInplace,
+	"bulk-edit":     runBulkEdit,
+	"compare":       runCompare,
+	"diff":          runDiff,
+	"doctor":        runDoctor,
+	"get":           runGet,
+	"lint":          runLint,
+	"preview":       runPreview,
+	"stats":         runStats,
+	"subtrees":      runSubtrees,
+	"validate":      runValidate,
+}
+
 func main() {
+	// Subcommand mode: argv[1] names a command
+	if len(os.Args) >= 2 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:], os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "chezmoi-split: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	// Interpreter mode: argv[0] = interpreter, argv[1] = script path
 	if len(os.Args) == 2 {
 		if err := runAsInterpreter(os.Args[1]); err != nil {
@@ -54,17 +125,20 @@ func main() {
 }
 
 // runAsInterpreter executes the merge logic when invoked via shebang.
-func runAsInterpreter(scriptPath string) error {
-	// Read script content
-	scriptContent, err := os.ReadFile(scriptPath)
-	if err != nil {
-		return fmt.Errorf("failed to read script: %w", err)
+// With CHEZMOI_SPLIT_DEBUG_DUMP set, each run also leaves a debug dump.
+func runAsInterpreter(scriptPath string) (err error) {
+	var scr *script.Script
+	var currentData []byte
+	stdinRead := false
+	if dir := os.Getenv(debugdump.DirEnv); dir != "" {
+		defer func() {
+			writeDebugDump(dir, scriptPath, scr, currentData, stdinRead, err)
+		}()
 	}
 
-	// Parse script
-	scr, err := script.Parse(string(scriptContent))
+	scr, err = loadScript(scriptPath)
 	if err != nil {
-		return fmt.Errorf("failed to parse script: %w", err)
+		return err
 	}
 
 	// Print any warnings from parsing
@@ -73,64 +147,462 @@ func runAsInterpreter(scriptPath string) error {
 	}
 
 	// Read current file from stdin
-	currentData, err := io.ReadAll(os.Stdin)
+	currentData, err = io.ReadAll(os.Stdin)
 	if err != nil {
 		return fmt.Errorf("failed to read stdin: %w", err)
 	}
+	stdinRead = true
+
+	return mergeScript(scr, scriptPath, currentData, os.Stdout)
+}
+
+// mergeScript merges currentData into the script's managed config and
+// writes the result, including the header and any fingerprint, to w. An empty
+// scriptPath records no stats. With an encoding directive, currentData is
+// decoded to UTF-8 for the merge and the output is encoded back.
+func mergeScript(scr *script.Script, scriptPath string, currentData []byte, w io.Writer) error {
+	// An empty target may just mean the app still keeps its config at an
+	// older location
+	if len(currentData) == 0 && len(scr.FallbackCurrent) > 0 {
+		home, _ := os.UserHomeDir()
+		data, used, err := readFallback(scr.FallbackCurrent, home)
+		if err != nil {
+			return err
+		}
+		if used != "" {
+			fmt.Fprintf(os.Stderr, "chezmoi-split: warning: target is empty; using %s as the current file (fallback-current)\n", used)
+			currentData = data
+		}
+	}
+
+	if scr.Encoding == "" || scr.Encoding == charset.UTF8 {
+		return mergeText(scr, scriptPath, currentData, w)
+	}
+	text, err := decodeCurrent(scr, currentData)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := mergeText(scr, scriptPath, text, &out); err != nil {
+		return err
+	}
+	data, err := charset.Encode(out.Bytes(), scr.Encoding)
+	if err != nil {
+		return fmt.Errorf("failed to encode output as %s: %w", scr.Encoding, err)
+	}
+	_, err = w.Write(data)
+	return err
+}
 
+// decodeCurrent converts currentData from the script's encoding to UTF-8.
+func decodeCurrent(scr *script.Script, currentData []byte) ([]byte, error) {
+	if scr.Encoding == "" {
+		return currentData, nil
+	}
+	text, err := charset.Decode(currentData, scr.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode current file as %s: %w", scr.Encoding, err)
+	}
+	return text, nil
+}
+
+// mergeText is mergeScript for a UTF-8 current file, writing UTF-8. The
+// output uses CRLF line breaks when most of the current file's do.
+func mergeText(scr *script.Script, scriptPath string, currentData []byte, w io.Writer) error {
 	// Handle plaintext format separately (uses block-based merging)
 	if scr.Format == "plaintext" {
-		return runPlaintextMerge(scr, currentData)
+		return runPlaintextMerge(scr, currentData, w)
+	}
+
+	if format.LineEnding(currentData) == "\r\n" {
+		var out bytes.Buffer
+		if err := mergeTree(scr, scriptPath, currentData, &out); err != nil {
+			return err
+		}
+		_, err := w.Write(format.WithLineEnding(out.Bytes(), "\r\n"))
+		return err
+	}
+	return mergeTree(scr, scriptPath, currentData, w)
+}
+
+// mergeTree is mergeText for the formats that parse to a tree.
+func mergeTree(scr *script.Script, scriptPath string, currentData []byte, w io.Writer) error {
+	m, err := mergeTrees(scr, currentData)
+	if err != nil {
+		return err
+	}
+	for _, warning := range m.report.Warnings {
+		fmt.Fprintf(os.Stderr, "chezmoi-split: warning: %s\n", warning)
+	}
+	if verbose() {
+		for _, outcome := range m.report.Outcomes {
+			fmt.Fprintf(os.Stderr, "chezmoi-split: %s\n", outcome)
+		}
+		merge.Measure(m.handler, m.managed, m.current, m.result, m.report)
+		printMetrics(os.Stderr, m.report.Metrics)
+	}
+	if m.current != nil && scriptPath != "" {
+		recordStats(scriptPath, m.report)
+	}
+	if err := checkPreservation(scr, currentData, m); err != nil {
+		return err
+	}
+	handler, result := m.handler, m.result
+
+	hash := fingerprint.Compute(scr.Body())
+	if scr.Fingerprint && !fingerprint.UsesComment(scr.Format) {
+		if err := handler.SetPath(result, path.NewArrayPath([]string{scr.FingerprintKey}), hash); err != nil {
+			return fmt.Errorf("failed to embed fingerprint: %w", err)
+		}
+	}
+
+	// Serialize and output
+	output, err := handler.Serialize(result, serializeOptions(scr, currentData))
+	if err != nil {
+		return fmt.Errorf("failed to serialize result: %w", err)
+	}
+
+	// Output header (comments before config) if present
+	if scr.Header != "" {
+		fmt.Fprintln(w, scr.Header)
+	}
+
+	line := fingerprint.CommentLine(fingerprint.CommentPrefix(scr.Format), hash)
+	comment := scr.Fingerprint && fingerprint.UsesComment(scr.Format)
+	if comment && !fingerprint.AtEnd(scr.Format) {
+		fmt.Fprintln(w, line)
+	}
+
+	if _, err := w.Write(output); err != nil {
+		return err
+	}
+	if comment && fingerprint.AtEnd(scr.Format) {
+		ending := format.LineEnding(output)
+		if len(output) > 0 && !bytes.HasSuffix(output, []byte("\n")) {
+			line = ending + line
+		}
+		_, err = io.WriteString(w, line+ending)
+	}
+	return err
+}
+
+// serializeOptions returns the output options set by the script's
+// directives. Without an indent directive, the output is indented like
+// currentData, keeping the app's style, or like the template when
+// currentData has no indented line (empty or minified).
+func serializeOptions(scr *script.Script, currentData []byte) format.SerializeOptions {
+	indent := scr.Indent
+	switch {
+	case indent != "":
+	case scr.Format == "json":
+		// Skips comment banners, whose " * " lines look indented
+		indent = formatjson.DetectIndent(currentData, []byte(scr.Template))
+	default:
+		indent = format.DetectIndent(currentData)
+		if indent == "" {
+			indent = format.DetectIndent([]byte(scr.Template))
+		}
+	}
+	return format.SerializeOptions{
+		Indent:           indent,
+		Compact:          scr.Output == "compact",
+		OmitFinalNewline: scr.OmitFinalNewline,
+		BoolStyle:        scr.BoolStyle,
+		NullStyle:        scr.NullStyle,
+	}
+}
+
+// errNothingPreserved is the message for a merge whose rules kept nothing
+// from a non-empty current file.
+const errNothingPreserved = "no app-owned values were preserved — check your ignore paths"
+
+// checkPreservation catches a rule set that silently matches nothing, such as
+// typoed paths or the wrong format directive: the current file has data and
+// the script has rules, yet the result is byte for byte the template. A
+// current file that already serializes like the template has nothing to lose.
+// It warns, or fails with require-preservation. m.managed is left with the
+// delete paths removed.
+func checkPreservation(scr *script.Script, currentData []byte, m *merged) error {
+	if len(bytes.TrimSpace(currentData)) == 0 || len(scr.IgnorePaths)+len(scr.PreserveIfMissing) == 0 {
+		return nil
+	}
+
+	// Compare against the template as the result would be written
+	for _, p := range scr.DeletePaths {
+		if err := m.handler.DeletePath(m.managed, p); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", p, err)
+		}
+	}
+	managed, err := m.handler.Serialize(m.managed, serializeOptions(scr, currentData))
+	if err != nil {
+		return fmt.Errorf("failed to serialize managed config: %w", err)
+	}
+	result, err := m.handler.Serialize(m.result, serializeOptions(scr, currentData))
+	if err != nil {
+		return fmt.Errorf("failed to serialize result: %w", err)
+	}
+	if !bytes.Equal(result, managed) {
+		return nil
+	}
+	if m.current != nil {
+		if current, err := m.handler.Serialize(m.current, serializeOptions(scr, currentData)); err == nil && bytes.Equal(current, managed) {
+			return nil
+		}
 	}
 
+	if scr.RequirePreservation {
+		return fmt.Errorf("%s (require-preservation)", errNothingPreserved)
+	}
+	fmt.Fprintf(os.Stderr, "chezmoi-split: warning: %s\n", errNothingPreserved)
+	return nil
+}
+
+// merged is a structured config merged as the interpreter would merge it.
+type merged struct {
+	handler format.Handler
+	managed any // Parsed template, without any fingerprint key
+	current any // Parsed current file; nil if empty or invalid
+	result  any
+	report  *merge.Report
+}
+
+// mergeTrees parses the template and currentData with the script's format
+// handler, merges them, and removes the delete paths. Fingerprints are
+// stripped but not embedded. The format must not be plaintext.
+func mergeTrees(scr *script.Script, currentData []byte) (*merged, error) {
 	// Create handler based on format
 	handler := getHandler(scr.Format)
-	parseOpts := format.ParseOptions{StripComments: scr.StripComments}
+	parseOpts := format.ParseOptions{StripComments: scr.StripComments, JSON5: scr.JSON5, KeepComments: scr.KeepComments, MatchKey: scr.MatchKey}
+
+	// Line-based fingerprints must be removed before parsing
+	template := scr.Template
+	if scr.Fingerprint && fingerprint.UsesComment(scr.Format) {
+		template = string(fingerprint.StripLine([]byte(template)))
+		currentData = fingerprint.StripLine(currentData)
+	}
+	// The header is written once, from the script
+	currentData = scr.StripHeader(currentData)
 
 	// Parse managed config from template
-	managed, err := handler.Parse([]byte(scr.Template), parseOpts)
+	managed, err := handler.Parse([]byte(template), parseOpts)
 	if err != nil {
-		return formatJSONError("managed config (in script)", scr.Template, err)
+		return nil, formatParseError(scr.Format, "managed config (in script)", template, err)
 	}
+	parseWarnings := handlerWarnings(handler, "managed config (in script)")
 
 	// Parse current config (may be empty)
 	var current any
 	if len(currentData) > 0 {
 		current, err = handler.Parse(currentData, parseOpts)
 		if err != nil {
-			// If current is invalid, just use managed
+			// If current is invalid, just use managed, unless it is a valid
+			// file in another format that the merge would replace
 			current = nil
+			if other := otherFormat(scr.Format, currentData); other != "" {
+				if scr.OnFormatMismatch != script.FormatMismatchOverwrite {
+					return nil, fmt.Errorf("current file appears to be %s but the script declares %s; not overwriting it (set '# on-format-mismatch overwrite' to replace it)", other, scr.Format)
+				}
+				parseWarnings = append(parseWarnings, fmt.Sprintf("current file appears to be %s, not %s; replacing it (on-format-mismatch overwrite)", other, scr.Format))
+			}
+		} else {
+			parseWarnings = append(parseWarnings, handlerWarnings(handler, "current file")...)
+		}
+	}
+
+	// Formats that now take a comment line may have a key from older output
+	if scr.Fingerprint {
+		fingerprint.StripKey(managed, scr.FingerprintKey)
+		if current != nil {
+			fingerprint.StripKey(current, scr.FingerprintKey)
 		}
 	}
 
 	// Merge
-	result := merge.Merge(handler, managed, current, scr.IgnorePaths)
+	result, report := merge.MergeWithOptions(handler, managed, current, scr.IgnorePaths, merge.Options{
+		ArrayMerge:        scr.ArrayMerge,
+		Rules:             scr.IgnoreRules,
+		Base:              scr.Base,
+		KeepExtra:         scr.KeepExtra,
+		Order:             scr.CanonicalOrder,
+		PreserveIfMissing: scr.PreserveIfMissing,
+		Computes:          scr.Computes,
+		// The app's own JSON Lines records follow the managed ones
+		KeepRecords: scr.Format == "jsonl",
+		// An ignored INI section keeps the template's keys the app hasn't set
+		MergeSections: scr.Format == "ini" || scr.Format == "phpini",
+	})
+	report.Warnings = append(parseWarnings, report.Warnings...)
+
+	// Remove paths the final file must not have, wherever they came from
+	for _, p := range scr.DeletePaths {
+		if err := handler.DeletePath(result, p); err != nil {
+			return nil, fmt.Errorf("failed to delete %s: %w", p, err)
+		}
+	}
+
+	return &merged{handler: handler, managed: managed, current: current, result: result, report: report}, nil
+}
+
+// iniFamily holds the formats whose files look alike: key = value lines
+// under [section] headers.
+var iniFamily = map[string]bool{"ini": true, "phpini": true, "toml": true}
+
+// otherFormat returns the format that data, which failed to parse as
+// declared, appears to be in, if its handler parses data cleanly. It returns
+// "" when data looks like the declared format (or a variant of it) or like
+// plaintext, which every file is.
+func otherFormat(declared string, data []byte) string {
+	detected := format.Detect(data, "")
+	switch {
+	case detected == "plaintext", detected == declared:
+		return ""
+	case iniFamily[declared] && iniFamily[detected]:
+		// The INI parser accepts most broken TOML, so a typo in either
+		// would otherwise look like the other format
+		return ""
+	}
+	if _, err := getHandler(detected).Parse(data, format.ParseOptions{StripComments: detected == "json"}); err != nil {
+		return ""
+	}
+	return detected
+}
+
+// handlerWarnings returns the warnings from handler's last Parse, if it
+// reports any, prefixed with the document's name.
+func handlerWarnings(handler format.Handler, name string) []string {
+	warner, ok := handler.(format.Warner)
+	if !ok {
+		return nil
+	}
+	var warnings []string
+	for _, w := range warner.Warnings() {
+		warnings = append(warnings, name+": "+w)
+	}
+	return warnings
+}
+
+// loadScript reads and parses the script at scriptPath, resolving an "auto"
+// format by sniffing the script name and template.
+func loadScript(scriptPath string) (*script.Script, error) {
+	scriptContent, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script: %w", err)
+	}
+
+	scr, err := script.Parse(string(scriptContent))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse script: %w", err)
+	}
+
+	if scr.Format == "auto" {
+		detected := format.Detect([]byte(scr.Body()), scriptPath)
+		if err := scr.ResolveFormat(detected); err != nil {
+			return nil, fmt.Errorf("failed to parse script: %w", err)
+		}
+		scr.Warnings = append(scr.Warnings,
+			fmt.Sprintf("format auto-detected as %s; add '# format %s' to the script to pin it", detected, detected))
+	}
+	return scr, nil
+}
+
+// printMetrics writes the verbose size summary of a merge.
+func printMetrics(w io.Writer, metrics *merge.Metrics) {
+	fmt.Fprintf(w, "chezmoi-split: metrics: managed: %s\n", metrics.Managed)
+	fmt.Fprintf(w, "chezmoi-split: metrics: current: %s\n", metrics.Current)
+	fmt.Fprintf(w, "chezmoi-split: metrics: result: %s\n", metrics.Result)
+	for _, rule := range metrics.Rules {
+		fmt.Fprintf(w, "chezmoi-split: metrics: %s\n", rule)
+	}
+}
+
+// verbose reports whether per-path merge diagnostics should be written to stderr.
+// Enabled by setting CHEZMOI_SPLIT_VERBOSE to any non-empty value other than "0" or "false".
+func verbose() bool {
+	switch os.Getenv("CHEZMOI_SPLIT_VERBOSE") {
+	case "", "0", "false":
+		return false
+	}
+	return true
+}
+
+// recordStats adds the merge report to the rule hit statistics when
+// CHEZMOI_SPLIT_STATS_DIR is set. Failures only produce a warning, so stats
+// can never break a merge.
+func recordStats(scriptPath string, report *merge.Report) {
+	dir := os.Getenv(stats.DirEnv)
+	if dir == "" || len(report.Outcomes) == 0 {
+		return
+	}
+	if err := stats.Record(dir, stats.TargetName(scriptPath), report, time.Now()); err != nil {
+		fmt.Fprintf(os.Stderr, "chezmoi-split: warning: failed to record stats: %v\n", err)
+	}
+}
+
+// runPlaintextMerge handles plaintext format using block-based merging.
+func runPlaintextMerge(scr *script.Script, currentData []byte, w io.Writer) error {
+	handler := formatplaintext.New()
+	managed, result, err := mergePlaintext(scr, currentData)
+	if err != nil {
+		return err
+	}
 
 	// Serialize and output
-	output, err := handler.Serialize(result, format.SerializeOptions{})
+	output, err := handler.Serialize(result, format.SerializeOptions{OmitFinalNewline: scr.OmitFinalNewline})
 	if err != nil {
-		return fmt.Errorf("failed to serialize result: %w", err)
+		return fmt.Errorf("failed to serialize: %w", err)
 	}
 
-	// Output header (comments before config) if present
-	if scr.Header != "" {
-		fmt.Println(scr.Header)
+	if scr.Fingerprint {
+		prefix := scr.CommentPrefix
+		if prefix == "" {
+			prefix = formatplaintext.CommentPrefix(managed)
+		}
+		line := fingerprint.CommentLine(prefix, fingerprint.Compute(scr.Body()))
+		ending := result.LineEnding
+		if ending == "" {
+			ending = "\n"
+		}
+		fmt.Fprint(w, line+ending)
 	}
 
-	_, err = os.Stdout.Write(output)
+	_, err = w.Write(output)
 	return err
 }
 
-// runPlaintextMerge handles plaintext format using block-based merging.
-func runPlaintextMerge(scr *script.Script, currentData []byte) error {
+// plaintextSpans returns where the lines runPlaintextMerge writes for
+// currentData came from, counting its fingerprint line.
+func plaintextSpans(scr *script.Script, currentData []byte) ([]formatplaintext.Span, error) {
+	_, result, err := mergePlaintext(scr, currentData)
+	if err != nil {
+		return nil, err
+	}
+	spans := result.Spans(format.SerializeOptions{})
+	if scr.Fingerprint {
+		for i := range spans {
+			spans[i].Start++
+			spans[i].End++
+		}
+	}
+	return spans, nil
+}
+
+// mergePlaintext parses the script's template and currentData, without any
+// fingerprint line, and returns the parsed template and the merged blocks.
+func mergePlaintext(scr *script.Script, currentData []byte) (*formatplaintext.ParsedConfig, *formatplaintext.ParsedConfig, error) {
 	handler := formatplaintext.New()
 
+	template := scr.Template
+	if scr.Fingerprint {
+		template = string(fingerprint.StripLine([]byte(template)))
+		currentData = fingerprint.StripLine(currentData)
+	}
+
 	// Parse managed (template)
 	// Note: For plaintext format, script.Template contains everything after #---
 	// (the parser doesn't use header/content separation for plaintext)
-	managedAny, err := handler.Parse([]byte(scr.Template), format.ParseOptions{})
+	managedAny, err := handler.Parse([]byte(template), format.ParseOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to parse managed config: %w", err)
+		return nil, nil, fmt.Errorf("failed to parse managed config: %w", err)
 	}
 	managed := managedAny.(*formatplaintext.ParsedConfig)
 
@@ -145,63 +617,147 @@ func runPlaintextMerge(scr *script.Script, currentData []byte) error {
 	}
 
 	// Merge using block-based logic
-	result := handler.MergeBlocks(managed, current)
+	return managed, handler.MergeBlocks(managed, current), nil
+}
 
-	// Serialize and output
-	output, err := handler.Serialize(result, format.SerializeOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to serialize: %w", err)
+// formatParseError creates a more helpful error message for a parse error
+// in content, which is in formatName: when the error's position is known
+// (see errorPosition), the message ends with the line and a caret under the
+// error.
+func formatParseError(formatName, context, content string, err error) error {
+	// JSON errors are reported as the syntax error alone
+	var syntaxErr *json.SyntaxError
+	if formatName == "json" && errors.As(err, &syntaxErr) {
+		err = syntaxErr
 	}
 
-	_, err = os.Stdout.Write(output)
-	return err
+	line, col := errorPosition(formatName, content, err)
+	offset, ok := lineOffset(content, line, col)
+	if !ok {
+		// Generic error
+		return fmt.Errorf("failed to parse %s: %w", context, err)
+	}
+
+	_, _, snippet := getErrorContext(content, offset)
+	where := fmt.Sprintf("line %d", line)
+	if col > 0 {
+		where += fmt.Sprintf(", column %d", col)
+	}
+	if start, end := lineBounds(content, offset); end-start > snippetWidth {
+		where += fmt.Sprintf(" (byte offset %d; the line is %d bytes long)", offset, end-start)
+	}
+	// ini.v1 messages end with the line they quote, newline included
+	return fmt.Errorf("failed to parse %s: %s\n  at %s:\n  %s", context, strings.TrimSpace(err.Error()), where, snippet)
 }
 
-// formatJSONError creates a more helpful error message for JSON parse errors.
-func formatJSONError(context, content string, err error) error {
-	// Try to extract position from JSON syntax error
-	if syntaxErr, ok := err.(*json.SyntaxError); ok {
-		offset := int(syntaxErr.Offset)
-		line, col, snippet := getErrorContext(content, offset)
-		return fmt.Errorf("failed to parse %s: %v\n  at line %d, column %d:\n  %s", context, syntaxErr, line, col, snippet)
+// lineRegex finds the line number in parser error messages ("line 3: ...",
+// "yaml: line 3: ...", "toml: line 3 (last key ...)").
+var lineRegex = regexp.MustCompile(`line (\d+)`)
+
+// errorPosition returns the 1-based line and column in content that a
+// parse error in formatName points at: a JSON syntax error's offset, the
+// position of a TOML or INI error, or else a "line N" in the message, which
+// gives column 0 (unknown). The line is 0 if the error doesn't say.
+func errorPosition(formatName, content string, err error) (line, col int) {
+	var syntaxErr *json.SyntaxError
+	switch {
+	case formatName == "json" && errors.As(err, &syntaxErr):
+		line, col, _ = getErrorContext(content, int(syntaxErr.Offset))
+		return line, col
+	case formatName == "toml":
+		if line, col = formattoml.ErrorPosition([]byte(content), err); line > 0 {
+			return line, col
+		}
+	case formatName == "ini" || formatName == "phpini":
+		if line, col = formatini.ErrorPosition([]byte(content), err); line > 0 {
+			return line, col
+		}
+	}
+	if m := lineRegex.FindStringSubmatch(err.Error()); m != nil {
+		line, _ = strconv.Atoi(m[1])
+		return line, 0
 	}
+	return 0, 0
+}
 
-	// Generic error
-	return fmt.Errorf("failed to parse %s: %w", context, err)
+// lineOffset returns the byte offset in content of the 1-based line and
+// column, or of the first non-blank byte of the line when col is 0. The
+// column is kept within the line.
+func lineOffset(content string, line, col int) (int, bool) {
+	if line < 1 {
+		return 0, false
+	}
+	start := 0
+	for i := 1; i < line; i++ {
+		next := strings.IndexByte(content[start:], '\n')
+		if next < 0 {
+			return 0, false
+		}
+		start += next + 1
+	}
+	_, end := lineBounds(content, start)
+	if col == 0 {
+		text := content[start:end]
+		return start + len(text) - len(strings.TrimLeft(text, " \t")), true
+	}
+	return min(start+col-1, end), true
 }
 
+// snippetWidth is the most bytes of a line that an error snippet shows.
+// Apps often write JSON as one huge line, so longer lines are cut to a
+// window around the error.
+const snippetWidth = 80
+
 // getErrorContext returns line number, column, and a snippet around the error position.
+// A line longer than snippetWidth is shown as a window centered on the
+// error, with "..." marking the cut ends.
 func getErrorContext(content string, offset int) (line, col int, snippet string) {
 	if offset < 0 || offset > len(content) {
 		return 1, 1, ""
 	}
 
-	// Count lines and find column
-	line = 1
-	lineStart := 0
-	for i := 0; i < offset && i < len(content); i++ {
-		if content[i] == '\n' {
-			line++
-			lineStart = i + 1
-		}
-	}
+	lineStart, lineEnd := lineBounds(content, offset)
+	line = strings.Count(content[:lineStart], "\n") + 1
 	col = offset - lineStart + 1
 
-	// Extract the line containing the error
-	lineEnd := lineStart
-	for lineEnd < len(content) && content[lineEnd] != '\n' {
-		lineEnd++
+	// Window a long line around the error, on rune boundaries
+	start, end := lineStart, lineEnd
+	prefix, suffix := "", ""
+	if lineEnd-lineStart > snippetWidth {
+		start = min(max(offset-snippetWidth/2, lineStart), lineEnd-snippetWidth)
+		for start > lineStart && !utf8.RuneStart(content[start]) {
+			start--
+		}
+		end = min(start+snippetWidth, lineEnd)
+		for end < lineEnd && !utf8.RuneStart(content[end]) {
+			end++
+		}
+		if start > lineStart {
+			prefix = "..."
+		}
+		if end < lineEnd {
+			suffix = "..."
+		}
 	}
 
-	currentLine := content[lineStart:lineEnd]
-
 	// Create snippet with pointer
-	pointer := strings.Repeat(" ", col-1) + "^"
-	snippet = currentLine + "\n  " + pointer
+	pointer := strings.Repeat(" ", len(prefix)+offset-start) + "^"
+	snippet = prefix + content[start:end] + suffix + "\n  " + pointer
 
 	return line, col, snippet
 }
 
+// lineBounds returns the start and end offsets of the line of content that
+// holds offset, excluding its newline.
+func lineBounds(content string, offset int) (start, end int) {
+	start = strings.LastIndexByte(content[:offset], '\n') + 1
+	end = len(content)
+	if i := strings.IndexByte(content[offset:], '\n'); i >= 0 {
+		end = offset + i
+	}
+	return start, end
+}
+
 // getHandler returns the appropriate format handler based on format name.
 func getHandler(formatName string) format.Handler {
 	switch formatName {
@@ -209,8 +765,36 @@ func getHandler(formatName string) format.Handler {
 		return formattoml.New()
 	case "ini":
 		return formatini.New()
+	case "phpini":
+		return formatini.NewWithLayout()
+	case "yaml":
+		return formatyaml.New()
+	case "plist":
+		return formatplist.New()
+	case "hcl":
+		return formathcl.New()
+	case "dotenv":
+		return formatdotenv.New()
+	case "properties":
+		return formatproperties.New()
+	case "sshconfig":
+		return formatsshconfig.New()
+	case "systemd":
+		return formatsystemd.New()
+	case "desktop":
+		return formatsystemd.NewDesktopEntry()
+	case "reg":
+		return formatreg.New()
+	case "nginx":
+		return formatnginx.New()
+	case "kdl":
+		return formatkdl.New()
+	case "lua":
+		return formatlua.New()
+	case "jsonl":
+		return formatjsonl.New()
 	default:
-		// "json" and "auto" both use JSON handler
+		// "auto" is resolved before a handler is needed, so this is "json"
 		return formatjson.New()
 	}
 }
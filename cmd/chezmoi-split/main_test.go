@@ -1,11 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"unicode/utf8"
+
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/script"
 )
 
 func TestGetErrorContext(t *testing.T) {
@@ -59,170 +66,2358 @@ func TestGetErrorContext(t *testing.T) {
 		},
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			line, col, snippet := getErrorContext(tt.content, tt.offset)
-			if line != tt.wantLine {
-				t.Errorf("line = %d, want %d", line, tt.wantLine)
-			}
-			if col != tt.wantCol {
-				t.Errorf("col = %d, want %d", col, tt.wantCol)
-			}
-			if tt.wantInSnip != "" && !contains(snippet, tt.wantInSnip) {
-				t.Errorf("snippet = %q, want it to contain %q", snippet, tt.wantInSnip)
-			}
-		})
-	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, col, snippet := getErrorContext(tt.content, tt.offset)
+			if line != tt.wantLine {
+				t.Errorf("line = %d, want %d", line, tt.wantLine)
+			}
+			if col != tt.wantCol {
+				t.Errorf("col = %d, want %d", col, tt.wantCol)
+			}
+			if tt.wantInSnip != "" && !contains(snippet, tt.wantInSnip) {
+				t.Errorf("snippet = %q, want it to contain %q", snippet, tt.wantInSnip)
+			}
+		})
+	}
+}
+
+func TestGetErrorContext_LongLine(t *testing.T) {
+	// One 200 KB line, as apps write JSON, with an error marker "X" placed
+	// at the given offset
+	long := func(at int) string {
+		b := []byte(strings.Repeat("a", 200000))
+		b[at] = 'X'
+		return "{\n" + string(b) + "\n}"
+	}
+
+	tests := []struct {
+		name     string
+		at       int
+		wantText string
+	}{
+		{"near the start", 5, "aaaaaXaaa"},
+		{"middle", 100000, "aaaXaaa"},
+		{"near the end", 199997, "aaaXaa"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := long(tt.at)
+			line, col, snippet := getErrorContext(content, 2+tt.at)
+			if line != 2 || col != tt.at+1 {
+				t.Errorf("line, col = %d, %d, want 2, %d", line, col, tt.at+1)
+			}
+
+			text, pointer, ok := strings.Cut(snippet, "\n  ")
+			if !ok {
+				t.Fatalf("snippet = %q, want text and pointer lines", snippet)
+			}
+			if len(text) > snippetWidth+6 {
+				t.Errorf("snippet text is %d bytes, want at most %d", len(text), snippetWidth+6)
+			}
+			if !strings.Contains(text, tt.wantText) {
+				t.Errorf("snippet text = %q, want containing %q", text, tt.wantText)
+			}
+			if caret := strings.Index(pointer, "^"); caret >= len(text) || text[caret] != 'X' {
+				t.Errorf("pointer %q doesn't point at the error in %q", pointer, text)
+			}
+			if gotPrefix, wantPrefix := strings.HasPrefix(text, "..."), tt.at > snippetWidth/2; gotPrefix != wantPrefix {
+				t.Errorf("leading ... = %v, want %v", gotPrefix, wantPrefix)
+			}
+			if gotSuffix, wantSuffix := strings.HasSuffix(text, "..."), tt.at < 200000-snippetWidth/2; gotSuffix != wantSuffix {
+				t.Errorf("trailing ... = %v, want %v", gotSuffix, wantSuffix)
+			}
+		})
+	}
+
+	// The window doesn't split a multi-byte character
+	content := strings.Repeat("é", 100) + "X" + strings.Repeat("é", 100)
+	_, _, snippet := getErrorContext(content, strings.Index(content, "X"))
+	if !utf8.ValidString(snippet) {
+		t.Errorf("snippet %q is not valid UTF-8", snippet)
+	}
+}
+
+func TestFormatParseError_LongLine(t *testing.T) {
+	content := `{"a": [` + strings.Repeat(`"x", `, 40000) + `oops]}`
+	h := getHandler("json")
+	_, err := h.Parse([]byte(content), format.ParseOptions{})
+	if err == nil {
+		t.Fatal("Parse() expected error")
+	}
+
+	msg := formatParseError("json", "managed config (in script)", content, err).Error()
+	// The offset counts the bytes read, including the bad one
+	offset := strings.Index(content, "oops") + 1
+	for _, want := range []string{
+		fmt.Sprintf("at line 1, column %d (byte offset %d; the line is %d bytes long):", offset+1, offset, len(content)),
+		`"x", oops]}`,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("error = %q, want containing %q", msg, want)
+		}
+	}
+	if len(msg) > 400 {
+		t.Errorf("error is %d bytes long, want the line cut down", len(msg))
+	}
+}
+
+func TestFormatParseError(t *testing.T) {
+	tests := []struct {
+		format  string
+		content string
+		want    string // Position line and snippet
+	}{
+		{
+			format:  "toml",
+			content: "[server]\nport = 8080\n[client\nname = \"x\"\n",
+			want:    "\n  at line 3, column 8:\n  [client\n         ^",
+		},
+		{
+			format:  "ini",
+			content: "[a]\nx = 1\n  [broken\ny = 2\n",
+			want:    "unclosed section: [broken\n  at line 3, column 3:\n    [broken\n    ^",
+		},
+		{
+			format:  "yaml",
+			content: "a: 1\nb: [\n",
+			want:    "\n  at line 2:\n  b: [\n  ^",
+		},
+		{
+			// The record's syntax error offset is within its own line
+			format:  "jsonl",
+			content: "{\"id\": 1}\n  {oops}\n",
+			want:    "\n  at line 2:\n    {oops}\n    ^",
+		},
+		{
+			format:  "json",
+			content: "{\n  \"a\": 1,\n  oops\n}\n",
+			want:    "\n  at line 3, column 4:\n    oops\n     ^",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			_, err := getHandler(tt.format).Parse([]byte(tt.content), format.ParseOptions{})
+			if err == nil {
+				t.Fatal("Parse() expected error")
+			}
+			msg := formatParseError(tt.format, "managed config (in script)", tt.content, err).Error()
+			if !strings.HasPrefix(msg, "failed to parse managed config (in script): ") || !strings.HasSuffix(msg, tt.want) {
+				t.Errorf("error =\n%s\nwant it to end with:\n%s", msg, tt.want)
+			}
+		})
+	}
+
+	t.Run("no position", func(t *testing.T) {
+		err := formatParseError("toml", "current file", "", errors.New("boom"))
+		if err.Error() != "failed to parse current file: boom" {
+			t.Errorf("error = %q", err)
+		}
+	})
+}
+
+func contains(s, substr string) bool {
+	return len(s) >= len(substr) && (s == substr || len(substr) == 0 ||
+		(len(s) > 0 && len(substr) > 0 && searchSubstring(s, substr)))
+}
+
+func searchSubstring(s, substr string) bool {
+	for i := 0; i <= len(s)-len(substr); i++ {
+		if s[i:i+len(substr)] == substr {
+			return true
+		}
+	}
+	return false
+}
+
+// Integration tests
+
+func TestIntegration_JSON(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore ["app", "setting"]
+#---
+{
+  "managed": "value",
+  "app": {
+    "setting": "default"
+  }
+}
+`
+	current := `{
+  "managed": "old",
+  "app": {
+    "setting": "user-modified"
+  }
+}
+`
+	want := `{
+  "managed": "value",
+  "app": {
+    "setting": "user-modified"
+  }
+}
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+// TestIntegration_JSON_BareValue checks that a bare managed value wins
+// whatever the current file holds: it has no paths to keep from current.
+func TestIntegration_JSON_BareValue(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore ["theme"]
+#---
+null
+`
+	runIntegrationTest(t, script, `{"theme": "light"}`, "null\n")
+	runIntegrationTest(t, script, "", "null\n")
+
+	// A bare current value is replaced by an object template
+	objectScript := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore ["theme"]
+#---
+{"theme": "dark"}
+`
+	runIntegrationTest(t, objectScript, "42", "{\n  \"theme\": \"dark\"\n}\n")
+}
+
+// TestIntegration_JSONC_TrailingCommas checks that a current file with the
+// trailing commas editors accept still parses, so its values are kept.
+func TestIntegration_JSONC_TrailingCommas(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# strip-comments true
+# ignore ["editor.fontSize"]
+#---
+{
+  "editor.fontSize": 12,
+  "editor.tabSize": 2
+}
+`
+	current := `{
+  // Set from the settings UI
+  "editor.fontSize": 15,
+  "files.exclude": {
+    "**/.git": true,
+  },
+}
+`
+	want := `{
+  "editor.fontSize": 15,
+  "editor.tabSize": 2
+}
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_JSONC_TrailingCommaInIgnoredObject(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# strip-comments true
+# ignore ["terminal", "font"]
+#---
+{
+  "terminal": {
+    "font": {"family": "Menlo", "size": 12},
+    "shell": "zsh"
+  }
+}
+`
+	// The comma after "size" would make the whole file unparsable, and the
+	// user's font would be reset to the template's
+	current := `{
+  "terminal": {
+    "font": {
+      "family": "Fira Code",
+      "size": 15,
+    },
+  },
+}
+`
+	want := `{
+  "terminal": {
+    "font": {
+      "family": "Fira Code",
+      "size": 15
+    },
+    "shell": "zsh"
+  }
+}
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+// TestIntegration_HeaderConverges applies scripts with a header banner to
+// their own output, as chezmoi does on every apply: the banner is written
+// once and the values the app changed survive.
+func TestIntegration_HeaderConverges(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		edit   func(string) string // The app's change between applies
+		want   string              // Start of the second apply's output
+		kept   string              // The app's change, which must survive
+	}{
+		{
+			name: "json without strip-comments",
+			script: `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore ["fontSize"]
+#---
+// Managed by chezmoi
+// Edit the source instead
+{
+  "fontSize": 12,
+  "theme": "dark"
+}
+`,
+			edit: func(s string) string { return strings.Replace(s, `"fontSize": 12`, `"fontSize": 15`, 1) },
+			kept: `"fontSize": 15`,
+			want: `// Managed by chezmoi
+// Edit the source instead
+{
+  "fontSize": 15,
+  "theme": "dark"
+}
+`,
+		},
+		{
+			name: "ini with fingerprint",
+			script: `#!/usr/bin/env chezmoi-split
+# version 1
+# format ini
+# fingerprint true
+# ignore ["ui", "zoom"]
+#---
+; Managed by chezmoi
+[ui]
+zoom = 1
+`,
+			edit: func(s string) string { return strings.Replace(s, "zoom = 1", "zoom = 2", 1) },
+			kept: "zoom = 2",
+			want: "; Managed by chezmoi\n; chezmoi-split:fingerprint ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := runIntegrationTestGetResult(t, tt.script, "")
+			second := runIntegrationTestGetResult(t, tt.script, tt.edit(first))
+			third := runIntegrationTestGetResult(t, tt.script, second)
+
+			if !strings.HasPrefix(second, tt.want) {
+				t.Errorf("second apply =\n%s\nwant starting with:\n%s", second, tt.want)
+			}
+			if !strings.Contains(second, tt.kept) {
+				t.Errorf("second apply lost %q:\n%s", tt.kept, second)
+			}
+			if n := strings.Count(second, "Managed by chezmoi"); n != 1 {
+				t.Errorf("banner appears %d times:\n%s", n, second)
+			}
+			if third != second {
+				t.Errorf("third apply changed the output:\n%s\nwant:\n%s", third, second)
+			}
+		})
+	}
+}
+
+func TestIntegration_JSON5(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# json5 true
+# ignore ["window"]
+#---
+{
+  theme: 'dark',
+  /* Set by the app */
+  window: {},
+}
+`
+	current := `{
+  // Remembered window state
+  theme: 'light',
+  window: {width: 1024, height: 768,},
+}
+`
+	want := `{
+  "theme": "dark",
+  "window": {
+    "width": 1024,
+    "height": 768
+  }
+}
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_JSON_KeepComments(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# keep-comments true
+# ignore ["window"]
+#---
+// Managed by chezmoi
+{
+  // Color theme
+  "theme": "dark",
+  // Window state, remembered by the app
+  "window": {},
+  // Font size in points
+  "fontSize": 12
+}
+`
+	current := `// Managed by chezmoi
+{
+  // The app's note on the theme
+  "theme": "light",
+  "window": {
+    // Last width
+    "width": 1024,
+  },
+  "fontSize": 14
+}
+`
+	want := `// Managed by chezmoi
+{
+  // Color theme
+  "theme": "dark",
+  // Window state, remembered by the app
+  "window": {
+    // Last width
+    "width": 1024
+  },
+  // Font size in points
+  "fontSize": 12
+}
+`
+	runIntegrationTest(t, script, current, want)
+	if second := runIntegrationTestGetResult(t, script, want); second != want {
+		t.Errorf("Second run changed output:\n%s", second)
+	}
+}
+
+func TestIntegration_JSON_Numbers(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore ["state"]
+#---
+{
+  "port": 8080,
+  "timeout": 1.50,
+  "limit": 1e3,
+  "state": {}
+}
+`
+	current := `{
+  "port": 8080,
+  "timeout": 1.50,
+  "limit": 1e3,
+  "state": {
+    "userId": 9007199254740993,
+    "offset": -0,
+    "scale": 6.02e23
+  }
+}
+`
+	// A merge that changes nothing writes every number as it was
+	runIntegrationTest(t, script, current, current)
+}
+
+func TestIntegration_JSON_CanonicalOrder(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# canonical-order template
+# ignore ["window"]
+#---
+{
+  "theme": "dark",
+  "window": {"x": 0, "y": 0}
+}
+`
+	want := `{
+  "theme": "dark",
+  "window": {
+    "x": 10,
+    "y": 5,
+    "maximized": true
+  }
+}
+`
+	// However the app orders its keys, the output is the same
+	for _, current := range []string{
+		`{"window": {"y": 5, "maximized": true, "x": 10}, "theme": "light"}`,
+		`{"theme": "light", "window": {"maximized": true, "x": 10, "y": 5}}`,
+		`{"window": {"x": 10, "y": 5, "maximized": true}}`,
+	} {
+		runIntegrationTest(t, script, current, want)
+	}
+}
+
+func TestIntegration_JSON_IgnoreRoot(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore []
+# delete ["session", "token"]
+#---
+{
+  "seeded": true
+}
+`
+	// A new file starts from the template
+	runIntegrationTest(t, script, "", "{\n  \"seeded\": true\n}\n")
+
+	// Afterwards the app owns the whole file, minus the deleted paths
+	current := `{
+  "window": {"w": 1},
+  "session": {"token": "abc", "user": "me"}
+}
+`
+	want := `{
+  "window": {
+    "w": 1
+  },
+  "session": {
+    "user": "me"
+  }
+}
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_JSON_Delete(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore ["experiments"]
+# delete ["experiments", "*", "stale"]
+# delete ["legacy"]
+#---
+{
+  "managed": "value",
+  "experiments": {}
+}
+`
+	current := `{
+  "legacy": true,
+  "experiments": {
+    "a": {"enabled": true, "stale": 1},
+    "b": {"stale": 2}
+  }
+}
+`
+	// Deletes apply to the merged result, so they reach ignored values too
+	want := `{
+  "managed": "value",
+  "experiments": {
+    "a": {
+      "enabled": true
+    },
+    "b": {}
+  }
+}
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_JSON_ArrayIndex(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore ["keybindings", "0", "keys"]
+#---
+{
+  "keybindings": [
+    {"keys": "ctrl-p", "command": "file_finder"},
+    {"keys": "ctrl-s", "command": "save"}
+  ]
+}
+`
+	current := `{
+  "keybindings": [
+    {"keys": "cmd-p", "command": "old_finder"},
+    {"keys": "cmd-s", "command": "save"}
+  ]
+}
+`
+	want := `{
+  "keybindings": [
+    {
+      "keys": "cmd-p",
+      "command": "file_finder"
+    },
+    {
+      "keys": "ctrl-s",
+      "command": "save"
+    }
+  ]
+}
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_JSON_Wildcard(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore ["servers", "*", "enabled"]
+#---
+{
+  "servers": {
+    "server1": {"host": "managed1", "enabled": false},
+    "server2": {"host": "managed2", "enabled": false}
+  }
+}
+`
+	current := `{
+  "servers": {
+    "server1": {"host": "old1", "enabled": true},
+    "server2": {"host": "old2", "enabled": true}
+  }
+}
+`
+	// enabled should be preserved from current (true), hosts from managed
+	result := runIntegrationTestGetResult(t, script, current)
+
+	if !strings.Contains(result, `"host": "managed1"`) {
+		t.Errorf("Expected managed host, got: %s", result)
+	}
+	if !strings.Contains(result, `"enabled": true`) {
+		t.Errorf("Expected preserved enabled=true, got: %s", result)
+	}
+}
+
+func TestIntegration_TOML(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format toml
+# ignore ["user", "preference"]
+#---
+[server]
+host = "localhost"
+port = 8080
+
+[user]
+name = "default"
+preference = "light"
+`
+	current := `[server]
+host = "oldhost"
+port = 9090
+
+[user]
+name = "oldname"
+preference = "dark"
+`
+	result := runIntegrationTestGetResult(t, script, current)
+
+	// Server values should come from managed
+	if !strings.Contains(result, "localhost") {
+		t.Errorf("Expected managed host 'localhost', got: %s", result)
+	}
+	if !strings.Contains(result, "8080") {
+		t.Errorf("Expected managed port 8080, got: %s", result)
+	}
+	// User preference should be preserved from current
+	if !strings.Contains(result, "dark") {
+		t.Errorf("Expected preserved preference 'dark', got: %s", result)
+	}
+}
+
+func TestIntegration_INI(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format ini
+# ignore ["database", "password"]
+#---
+[database]
+host = localhost
+port = 3306
+password = default
+
+[server]
+address = 0.0.0.0
+`
+	current := `[database]
+host = oldhost
+port = 5432
+password = secret123
+
+[server]
+address = 127.0.0.1
+`
+	result := runIntegrationTestGetResult(t, script, current)
+
+	// Database host/port should come from managed
+	if !strings.Contains(result, "localhost") {
+		t.Errorf("Expected managed host 'localhost', got: %s", result)
+	}
+	if !strings.Contains(result, "3306") {
+		t.Errorf("Expected managed port 3306, got: %s", result)
+	}
+	// Password should be preserved from current
+	if !strings.Contains(result, "secret123") {
+		t.Errorf("Expected preserved password 'secret123', got: %s", result)
+	}
+}
+
+// TestIntegration_INI_BoolStyle checks that booleans from the template and
+// the current file are both written in the script's bool-style.
+func TestIntegration_INI_BoolStyle(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format ini
+# bool-style yes
+# ignore ["sync", "enabled"]
+#---
+[ui]
+animations = false
+
+[sync]
+enabled = true
+`
+	current := `[ui]
+animations = true
+
+[sync]
+enabled = false
+`
+	want := `[ui]
+animations = no
+
+[sync]
+enabled = no
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+// TestIntegration_JSON_NullStyle checks that null-style omit drops null
+// members, whichever file they came from.
+func TestIntegration_JSON_NullStyle(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# null-style omit
+# ignore ["proxy"]
+#---
+{
+  "theme": "dark",
+  "cache": null,
+  "proxy": "http://proxy"
+}
+`
+	current := `{"theme": "light", "proxy": null}`
+	want := `{
+  "theme": "dark"
+}
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+// TestIntegration_JSON_Compact checks that output compact writes the merge
+// on one line, in the template's key order, and final-newline false ends it
+// without a newline.
+func TestIntegration_JSON_Compact(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# output compact
+# final-newline false
+# ignore ["token"]
+#---
+{
+  "theme": "dark",
+  "token": "",
+  "font": {"size": 12}
+}
+`
+	current := `{"token": "abc", "theme": "light"}`
+	want := `{"theme":"dark","token":"abc","font":{"size":12}}`
+	if got := runIntegrationTestGetResult(t, script, current); got != want {
+		t.Errorf("Result = %q, want %q", got, want)
+	}
+}
+
+func TestIntegration_TOML_Compute(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format toml
+# ignore [["font", "family"], ["font", "size"]]
+# compute ["font", "spec"] template="{{ .current.font.family }}:{{ .current.font.size }}" requires=[["font", "family"], ["font", "size"]]
+#---
+[font]
+spec = "Menlo:12"
+`
+	current := `[font]
+family = "Iosevka"
+size = 14
+`
+	want := `[font]
+spec = "Iosevka:14"
+family = "Iosevka"
+size = 14
+`
+	runIntegrationTest(t, script, current, want)
+
+	// Without both inputs the managed value stays
+	runIntegrationTest(t, script, "[font]\nfamily = \"Iosevka\"\n", `[font]
+spec = "Menlo:12"
+family = "Iosevka"
+`)
+}
+
+func TestIntegration_Encoding(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format ini
+# encoding %s
+# ignore ["user", "name"]
+#---
+[user]
+name = placeholder
+editor = vim
+`
+	// utf16le encodes Latin-1 text, which is all these tests use
+	utf16le := func(text string) string {
+		out := []byte{0xFF, 0xFE}
+		for _, r := range text {
+			out = append(out, byte(r), byte(r>>8))
+		}
+		return string(out)
+	}
+
+	tests := []struct {
+		encoding string
+		current  string
+		want     string
+	}{
+		{"latin1", "[user]\nname = Zo\xeb\n", "[user]\nname   = Zo\xeb\neditor = vim\n"},
+		{"utf-16le", utf16le("[user]\r\nname = Zoë\r\n"), utf16le("[user]\r\nname   = Zoë\r\neditor = vim\r\n")},
+		{"utf-8", "[user]\nname = Zoë\n", "[user]\nname   = Zoë\neditor = vim\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			got := runIntegrationTestGetResult(t, fmt.Sprintf(script, tt.encoding), tt.current)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntegration_LineEndings(t *testing.T) {
+	jsonScript := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore ["window"]
+#---
+// Managed by chezmoi
+{
+  "theme": "dark",
+  "window": {}
+}
+`
+	plaintextScript := `#!/usr/bin/env chezmoi-split
+# version 1
+# format plaintext
+# fingerprint true
+#---
+# chezmoi:managed
+set number
+# chezmoi:ignored
+# chezmoi:end
+`
+	tests := []struct {
+		name    string
+		script  string
+		current string
+		want    string
+	}{
+		{
+			name:    "json crlf",
+			script:  jsonScript,
+			current: "{\r\n  \"window\": {\"w\": 1}\r\n}\r\n",
+			want:    "// Managed by chezmoi\r\n{\r\n  \"theme\": \"dark\",\r\n  \"window\": {\r\n    \"w\": 1\r\n  }\r\n}\r\n",
+		},
+		{
+			name:    "json mixed, mostly crlf",
+			script:  jsonScript,
+			current: "{\r\n  \"window\": {\r\n    \"w\": 1\n  }\r\n}\n",
+			want:    "// Managed by chezmoi\r\n{\r\n  \"theme\": \"dark\",\r\n  \"window\": {\r\n    \"w\": 1\r\n  }\r\n}\r\n",
+		},
+		{
+			name:    "json mixed, mostly lf",
+			script:  jsonScript,
+			current: "{\r\n  \"window\": {\n    \"w\": 1\n  }\n}\n",
+			want:    "// Managed by chezmoi\n{\n  \"theme\": \"dark\",\n  \"window\": {\n    \"w\": 1\n  }\n}\n",
+		},
+		{
+			name:    "plaintext crlf",
+			script:  plaintextScript,
+			current: "# chezmoi:managed\r\nset nonumber\r\n# chezmoi:ignored\r\nset mouse=a\r\n# chezmoi:end\r\n",
+			want:    "# chezmoi:managed\r\nset number\r\n# chezmoi:ignored\r\nset mouse=a\r\n# chezmoi:end\r\n",
+		},
+		{
+			name:    "plaintext mixed",
+			script:  plaintextScript,
+			current: "# chezmoi:managed\r\nset nonumber\r\n# chezmoi:ignored\nset mouse=a\r\n# chezmoi:end\r\n",
+			want:    "# chezmoi:managed\r\nset number\r\n# chezmoi:ignored\r\nset mouse=a\r\n# chezmoi:end\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runIntegrationTestGetResult(t, tt.script, tt.current)
+			if strings.HasPrefix(tt.name, "plaintext") {
+				// The fingerprint line ends like the rest
+				line, rest, _ := strings.Cut(got, "\r\n")
+				if !strings.HasPrefix(line, "# chezmoi-split:fingerprint ") {
+					t.Fatalf("output = %q, want a CRLF fingerprint line first", got)
+				}
+				got = rest
+			}
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntegration_Indent(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+%s# ignore ["window"]
+#---
+{
+  "theme": "dark",
+  "window": {}
+}
+`
+	tests := []struct {
+		name      string
+		directive string
+		current   string
+		want      string
+	}{
+		{
+			name:    "follows current",
+			current: "{\n    \"window\": {\n        \"w\": 1\n    }\n}\n",
+			want:    "{\n    \"theme\": \"dark\",\n    \"window\": {\n        \"w\": 1\n    }\n}\n",
+		},
+		{
+			name:    "follows tabs",
+			current: "{\n\t\"window\": {\n\t\t\"w\": 1\n\t}\n}\n",
+			want:    "{\n\t\"theme\": \"dark\",\n\t\"window\": {\n\t\t\"w\": 1\n\t}\n}\n",
+		},
+		{
+			name:      "block comment banner doesn't count",
+			directive: "# strip-comments true\n",
+			current:   "/*\n * Written by the app\n */\n{\n    \"window\": {\n        \"w\": 1\n    }\n}\n",
+			want:      "{\n    \"theme\": \"dark\",\n    \"window\": {\n        \"w\": 1\n    }\n}\n",
+		},
+		{
+			name:    "minified current keeps the default",
+			current: `{"window":{"w":1}}`,
+			want:    "{\n  \"theme\": \"dark\",\n  \"window\": {\n    \"w\": 1\n  }\n}\n",
+		},
+		{
+			name:      "directive wins",
+			directive: "# indent tab\n",
+			current:   "{\n    \"window\": {\n        \"w\": 1\n    }\n}\n",
+			want:      "{\n\t\"theme\": \"dark\",\n\t\"window\": {\n\t\t\"w\": 1\n\t}\n}\n",
+		},
+		{
+			name:      "no current",
+			directive: "# indent 4\n",
+			want:      "{\n    \"theme\": \"dark\",\n    \"window\": {}\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runIntegrationTestGetResult(t, fmt.Sprintf(script, tt.directive), tt.current)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntegration_Indent_TemplateFallback(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+%s# ignore ["window"]
+#---
+{
+    "theme": "dark",
+    "window": {}
+}
+`
+	fourSpaces := "{\n    \"theme\": \"dark\",\n    \"window\": {\n        \"w\": 1\n    }\n}\n"
+	tests := []struct {
+		name      string
+		directive string
+		current   string
+		want      string
+	}{
+		{
+			name:    "two-space current wins",
+			current: "{\n  \"window\": {\n    \"w\": 1\n  }\n}\n",
+			want:    "{\n  \"theme\": \"dark\",\n  \"window\": {\n    \"w\": 1\n  }\n}\n",
+		},
+		{
+			name:    "tab current wins",
+			current: "{\n\t\"window\": {\n\t\t\"w\": 1\n\t}\n}\n",
+			want:    "{\n\t\"theme\": \"dark\",\n\t\"window\": {\n\t\t\"w\": 1\n\t}\n}\n",
+		},
+		{
+			name:    "minified current follows the template",
+			current: `{"window":{"w":1}}`,
+			want:    fourSpaces,
+		},
+		{
+			name: "no current follows the template",
+			want: "{\n    \"theme\": \"dark\",\n    \"window\": {}\n}\n",
+		},
+		{
+			name:      "directive wins",
+			directive: "# indent 2\n",
+			current:   "{\n\t\"window\": {\n\t\t\"w\": 1\n\t}\n}\n",
+			want:      "{\n  \"theme\": \"dark\",\n  \"window\": {\n    \"w\": 1\n  }\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runIntegrationTestGetResult(t, fmt.Sprintf(script, tt.directive), tt.current)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntegration_INI_WholeSection(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format ini
+# ignore ["database"]
+#---
+[database]
+host = localhost
+port = 3306
+pool = 5
+`
+	current := `[database]
+password = secret123
+port = 5432
+`
+	// Keys the app set win, template-only keys stay, new keys follow in order
+	want := `[database]
+host     = localhost
+port     = 5432
+pool     = 5
+password = secret123
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_INI_RepeatedKeys(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format ini
+# ignore ["main", "include"]
+#---
+[main]
+include = base.conf
+theme = dark
+`
+	current := `[main]
+include = base.conf
+include = work.conf
+theme = light
+`
+	// The app's lines for the ignored key are all kept
+	want := `[main]
+include = base.conf
+include = work.conf
+theme   = dark
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_INI_Subsections(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format ini
+# ignore ["user", "work", "email"]
+#---
+[user]
+name = Jane Doe
+
+[user "work"]
+email = jane@example.com
+signingkey = ABC123
+`
+	current := `[user]
+name = Jane
+
+[user "work"]
+email = jane@corp.example
+signingkey = OLD
+
+[remote "origin"]
+url = git@example.com:repo.git
+`
+	want := `[user]
+name = Jane Doe
+
+[user "work"]
+email      = jane@corp.example
+signingkey = ABC123
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+// TestIntegration_INI_FlatSectionPaths checks that paths naming a nested
+// section by its flat name, as scripts did before subsections, still match.
+func TestIntegration_INI_FlatSectionPaths(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format ini
+# ignore ["user \"work\"", "email"]
+# ignore ["tool.ruff", "*"]
+# ignore ["remote \"origin\""]
+#---
+[user "work"]
+email = jane@example.com
+
+[tool.ruff]
+line-length = 80
+`
+	current := `[user "work"]
+email = jane@corp.example
+
+[tool.ruff]
+line-length = 120
+
+[remote "origin"]
+url = git@example.com:repo.git
+`
+	want := `[user "work"]
+email = jane@corp.example
+
+[tool.ruff]
+line-length = 120
+
+[remote "origin"]
+url = git@example.com:repo.git
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_INI_SectionComments(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format ini
+# ignore ["database", "password"]
+#---
+[database]
+host = localhost
+password = default
+
+; Listen address for the web UI
+; Use 0.0.0.0 to expose it on the network
+[server]
+address = 127.0.0.1
+`
+	current := `[database]
+host = localhost
+password = secret123
+
+[server]
+address = 127.0.0.1
+`
+	want := `[database]
+host     = localhost
+password = secret123
+
+; Listen address for the web UI
+; Use 0.0.0.0 to expose it on the network
+[server]
+address = 127.0.0.1
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_YAML(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format yaml
+# ignore ["font", "size"]
+#---
+# Managed by chezmoi
+window:
+  opacity: 0.9
+font:
+  family: Hack
+  size: 12
+`
+	current := `window:
+  opacity: 1.0
+font:
+  family: Menlo
+  size: 14
+`
+	want := `# Managed by chezmoi
+window:
+  opacity: 0.9
+font:
+  family: Hack
+  size: 14
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_AutoDetectTOML(t *testing.T) {
+	// No format directive: the TOML template must not be parsed as JSON
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# ignore ["user", "theme"]
+#---
+[user]
+name = "managed"
+theme = "dark"
+`
+	current := `[user]
+name = "old"
+theme = "light"
+`
+	result := runIntegrationTestGetResult(t, script, current)
+
+	if !strings.Contains(result, `name = "managed"`) {
+		t.Errorf("Expected managed name, got: %s", result)
+	}
+	if !strings.Contains(result, `theme = "light"`) {
+		t.Errorf("Expected preserved theme, got: %s", result)
+	}
+}
+
+// TestLoadScript_AutoFormatFromName checks that an auto format is taken from
+// the extension in the script's chezmoi source name before the template is
+// sniffed.
+func TestLoadScript_AutoFormatFromName(t *testing.T) {
+	// Sniffed alone, this template would be toml
+	body := "#!/usr/bin/env chezmoi-split\n# version 1\n#---\n[user]\nname = \"Jane\"\n"
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "modify_settings.toml.tmpl", want: "toml"},
+		{name: "modify_app.ini.tmpl", want: "ini"},
+		{name: "modify_app.conf.tmpl", want: "toml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scriptPath := filepath.Join(t.TempDir(), tt.name)
+			if err := os.WriteFile(scriptPath, []byte(body), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			scr, err := loadScript(scriptPath)
+			if err != nil {
+				t.Fatalf("loadScript() error = %v", err)
+			}
+			if scr.Format != tt.want {
+				t.Errorf("Format = %q, want %q", scr.Format, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntegration_AutoDetectPlaintext(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+#---
+# chezmoi:managed
+set number
+# chezmoi:ignored
+colorscheme default
+# chezmoi:end
+`
+	current := `# chezmoi:managed
+set nonumber
+# chezmoi:ignored
+colorscheme gruvbox
+# chezmoi:end
+`
+	want := `# chezmoi:managed
+set number
+# chezmoi:ignored
+colorscheme gruvbox
+# chezmoi:end
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_Fingerprint_JSON(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# fingerprint true
+# ignore ["theme"]
+#---
+{
+  "theme": "dark",
+  "font": "Hack"
+}
+`
+	first := runIntegrationTestGetResult(t, script, `{"theme": "light"}`)
+	if strings.Count(first, `"_chezmoi_split"`) != 1 {
+		t.Fatalf("Expected exactly one fingerprint key, got:\n%s", first)
+	}
+	if !strings.Contains(first, `"theme": "light"`) {
+		t.Errorf("Expected preserved theme, got:\n%s", first)
+	}
+
+	// Feeding the output back in must not duplicate the marker or change anything
+	second := runIntegrationTestGetResult(t, script, first)
+	if second != first {
+		t.Errorf("Second run changed output:\nfirst:\n%s\nsecond:\n%s", first, second)
+	}
+}
+
+func TestIntegration_Fingerprint_CustomKey(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# fingerprint true
+# fingerprint-key x-managed-by
+#---
+{"key": "value"}
+`
+	result := runIntegrationTestGetResult(t, script, "")
+	if !strings.Contains(result, `"x-managed-by"`) {
+		t.Errorf("Expected custom fingerprint key, got:\n%s", result)
+	}
+}
+
+func TestIntegration_Fingerprint_INI(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format ini
+# fingerprint true
+# ignore ["database", "password"]
+#---
+[database]
+password = default
+`
+	first := runIntegrationTestGetResult(t, script, "[database]\npassword = secret\n")
+	if strings.Count(first, "chezmoi-split:fingerprint") != 1 {
+		t.Fatalf("Expected exactly one fingerprint line, got:\n%s", first)
+	}
+	if !strings.HasPrefix(first, "; chezmoi-split:fingerprint ") {
+		t.Errorf("Expected fingerprint comment first, got:\n%s", first)
+	}
+
+	second := runIntegrationTestGetResult(t, script, first)
+	if second != first {
+		t.Errorf("Second run changed output:\nfirst:\n%s\nsecond:\n%s", first, second)
+	}
+}
+
+// TestIntegration_Fingerprint_CommentFormats checks that every format with
+// comments gets a fingerprint line in its own syntax rather than a key, and
+// that feeding the output back in keeps a single line.
+func TestIntegration_Fingerprint_CommentFormats(t *testing.T) {
+	tests := []struct {
+		format   string
+		template string
+		prefix   string
+	}{
+		{"toml", "[a]\nb = 1\n", "#"},
+		{"yaml", "a: 1\n", "#"},
+		{"yaml", "a: 1\n---\nb: 2\n", "#"},
+		{"phpini", "[PHP]\nmemory_limit = 128M\n", ";"},
+		{"dotenv", "A=1\n", "#"},
+		{"properties", "a=1\n", "#"},
+		{"hcl", "a = 1\n", "#"},
+		{"sshconfig", "Host example\n  User me\n", "#"},
+		{"systemd", "[Unit]\nDescription=x\n", "#"},
+		{"desktop", "[Desktop Entry]\nName=x\n", "#"},
+		{"nginx", "worker_processes 1;\n", "#"},
+		{"kdl", "node 1\n", "//"},
+		{"lua", "return {\n  a = 1,\n}\n", "--"},
+		{"reg", "Windows Registry Editor Version 5.00\n\n[HKEY_CURRENT_USER\\Software\\Example]\n\"a\"=\"b\"\n", ";"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			script := "#!/usr/bin/env chezmoi-split\n# version 1\n# format " + tt.format + "\n# fingerprint true\n#---\n" + tt.template
+			first := runIntegrationTestGetResult(t, script, "")
+			if strings.Count(first, "chezmoi-split:fingerprint") != 1 || strings.Contains(first, "_chezmoi_split") {
+				t.Fatalf("Expected exactly one fingerprint line and no key, got:\n%s", first)
+			}
+			lines := strings.Split(strings.TrimRight(first, "\r\n"), "\n")
+			line := lines[0]
+			if tt.format == "reg" {
+				// A registry export must start with its header
+				line = lines[len(lines)-1]
+			}
+			if !strings.HasPrefix(line, tt.prefix+" chezmoi-split:fingerprint ") {
+				t.Errorf("Expected a %q fingerprint comment, got:\n%s", tt.prefix, first)
+			}
+
+			second := runIntegrationTestGetResult(t, script, first)
+			if second != first {
+				t.Errorf("Second run changed output:\nfirst:\n%s\nsecond:\n%s", first, second)
+			}
+		})
+	}
+}
+
+// TestIntegration_Fingerprint_OldKey checks that a fingerprint key written
+// by an older release, before TOML used a comment line, is dropped rather
+// than kept as app data.
+func TestIntegration_Fingerprint_OldKey(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format toml
+# fingerprint true
+# ignore ["theme"]
+#---
+theme = "dark"
+`
+	result := runIntegrationTestGetResult(t, script, "_chezmoi_split = \"0123456789ab\"\ntheme = \"light\"\n")
+	if strings.Contains(result, "_chezmoi_split") || !strings.Contains(result, `theme = "light"`) {
+		t.Errorf("Expected the old key dropped and theme kept, got:\n%s", result)
+	}
+}
+
+func TestIntegration_Fingerprint_Plaintext(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format plaintext
+# fingerprint true
+#---
+" chezmoi:managed
+set number
+" chezmoi:ignored
+colorscheme default
+" chezmoi:end
+`
+	first := runIntegrationTestGetResult(t, script, "")
+	if !strings.HasPrefix(first, `" chezmoi-split:fingerprint `) {
+		t.Fatalf("Expected vim-style fingerprint comment first, got:\n%s", first)
+	}
+
+	second := runIntegrationTestGetResult(t, script, first)
+	if second != first {
+		t.Errorf("Second run changed output:\nfirst:\n%s\nsecond:\n%s", first, second)
+	}
+}
+
+func TestIntegration_Plaintext_CommentPrefix(t *testing.T) {
+	// Without markers the fingerprint line would default to "#"
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format plaintext
+# comment-prefix vim
+# fingerprint true
+#---
+set number
+syntax on
+`
+	first := runIntegrationTestGetResult(t, script, "")
+	if want := "\" chezmoi-split:fingerprint "; !strings.HasPrefix(first, want) {
+		t.Fatalf("Expected output to start with %q, got:\n%s", want, first)
+	}
+	if second := runIntegrationTestGetResult(t, script, first); second != first {
+		t.Errorf("Second run changed output:\nfirst:\n%s\nsecond:\n%s", first, second)
+	}
+
+	// Markers stay as written, so a .vimrc keeps its vim-style end marker
+	script = `#!/usr/bin/env chezmoi-split
+# version 1
+# format plaintext
+# comment-prefix "\""
+#---
+" chezmoi:managed
+set number
+" chezmoi:ignored
+colorscheme default
+" chezmoi:end
+`
+	current := `" chezmoi:managed
+set nonumber
+" chezmoi:ignored
+colorscheme gruvbox
+" chezmoi:end
+`
+	want := `" chezmoi:managed
+set number
+" chezmoi:ignored
+colorscheme gruvbox
+" chezmoi:end
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_Dotenv(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format dotenv
+# ignore ["API_TOKEN"]
+#---
+export APP_ENV=production
+API_TOKEN="replace-me"
+LOG_LEVEL=info
+`
+	current := `export APP_ENV=development
+API_TOKEN="runtime token"
+LOG_LEVEL=debug
+`
+	want := `export APP_ENV=production
+API_TOKEN="runtime token"
+LOG_LEVEL=info
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_Properties(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format properties
+# ignore ["org.gradle.jvmargs"]
+#---
+# Managed by chezmoi
+org.gradle.jvmargs=-Xmx2g
+org.gradle.parallel=true
+kotlin.code.style: official
+`
+	current := `org.gradle.jvmargs=-Xmx4g \
+    -Dfile.encoding=UTF-8
+org.gradle.parallel=false
+`
+	want := `# Managed by chezmoi
+org.gradle.jvmargs=-Xmx4g -Dfile.encoding=UTF-8
+org.gradle.parallel=true
+kotlin.code.style: official
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_SSHConfig(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format sshconfig
+# keep-extra true
+# ignore ["Host github.com", "IdentityFile"]
+# ignore ["Host *bastion*"]
+#---
+# Managed by chezmoi
+Host github.com
+    User git
+    IdentityFile ~/.ssh/id_ed25519
+
+Host *bastion*
+    HostName bastion.example.com
+
+Host *
+    ServerAliveInterval 60
+`
+	current := `Host *
+	ServerAliveInterval 30
+
+Host scratch
+	HostName 10.0.0.5
+
+Host *bastion*
+	HostName bastion2.example.com
+	Port 2222
+
+Host github.com
+	User someone
+	IdentityFile ~/.ssh/id_work
+	IdentityFile ~/.ssh/id_personal
+`
+	want := `# Managed by chezmoi
+Host github.com
+    User git
+    IdentityFile ~/.ssh/id_work
+    IdentityFile ~/.ssh/id_personal
+
+Host *bastion*
+    HostName bastion2.example.com
+	Port 2222
+
+Host *
+    ServerAliveInterval 60
+
+Host scratch
+	HostName 10.0.0.5
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_Systemd(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format systemd
+# ignore ["Service", "Environment"]
+#---
+# Managed by chezmoi
+[Unit]
+Description=App
+
+[Service]
+Environment=LOG_LEVEL=info
+ExecStart=/usr/bin/app
+
+[Install]
+WantedBy=default.target
+`
+	current := `[Unit]
+Description=Old app
+
+[Service]
+Environment=
+Environment=API_TOKEN=secret
+Environment=LOG_LEVEL=debug
+ExecStart=/usr/local/bin/app
+`
+	want := `# Managed by chezmoi
+[Unit]
+Description=App
+
+[Service]
+Environment=
+Environment=API_TOKEN=secret
+Environment=LOG_LEVEL=debug
+ExecStart=/usr/bin/app
+
+[Install]
+WantedBy=default.target
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_Desktop(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format desktop
+# keep-extra true
+# ignore ["Desktop Entry", "X-GNOME-Autostart-enabled"]
+#---
+[Desktop Entry]
+Type=Application
+Name=Syncthing
+Name[de]=Syncthing
+Exec=syncthing serve --no-browser
+X-GNOME-Autostart-enabled=true
+`
+	current := `[Desktop Entry]
+Type=Application
+Name=Syncthing
+Exec=/usr/bin/syncthing serve
+X-GNOME-Autostart-enabled=false
+X-GNOME-Autostart-Delay=10
+`
+	want := `[Desktop Entry]
+Type=Application
+Name=Syncthing
+Name[de]=Syncthing
+Exec=syncthing serve --no-browser
+X-GNOME-Autostart-enabled=false
+X-GNOME-Autostart-Delay=10
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_Reg(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# ignore ["HKEY_CURRENT_USER\\Software\\Editor", "WindowWidth"]
+#---
+Windows Registry Editor Version 5.00
+
+; Managed by chezmoi
+[HKEY_CURRENT_USER\Software\Editor]
+"Theme"="dark"
+"FontSize"=dword:0000000e
+"WindowWidth"=dword:00000400
+`
+	current := `Windows Registry Editor Version 5.00
+
+[HKEY_CURRENT_USER\Software\Editor]
+"Theme"="light"
+"FontSize"=dword:0000000c
+"WindowWidth"=dword:00000780
+`
+	want := `Windows Registry Editor Version 5.00
+
+; Managed by chezmoi
+[HKEY_CURRENT_USER\Software\Editor]
+"Theme"="dark"
+"FontSize"=dword:0000000e
+"WindowWidth"=dword:00000780
+`
+	runIntegrationTest(t, script, current, strings.ReplaceAll(want, "\n", "\r\n"))
+}
+
+func TestIntegration_Nginx(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# ignore ["server", "ssl_certificate"]
+# ignore ["server", "ssl_certificate_key"]
+#---
+server {
+    listen 443 ssl;
+    server_name example.com;
+
+    location / {
+        root /srv/www;
+    }
+}
+`
+	current := `server {
+    server_name example.com;
+    listen 443 ssl;
+    location / {
+        root /var/www;
+    }
+    ssl_certificate /etc/letsencrypt/live/example.com/fullchain.pem; # managed by Certbot
+    ssl_certificate_key /etc/letsencrypt/live/example.com/privkey.pem; # managed by Certbot
+}
+`
+	want := `server {
+    listen 443 ssl;
+    server_name example.com;
+
+    location / {
+        root /srv/www;
+    }
+    ssl_certificate /etc/letsencrypt/live/example.com/fullchain.pem; # managed by Certbot
+    ssl_certificate_key /etc/letsencrypt/live/example.com/privkey.pem; # managed by Certbot
+}
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_KDL(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format kdl
+# ignore ["keybinds", "normal"]
+#---
+// Managed by chezmoi
+theme "dracula"
+pane_frames false
+
+keybinds {
+    normal {
+        bind "Ctrl g" { SwitchToMode "Locked"; }
+    }
+    locked {
+        bind "Ctrl g" { SwitchToMode "Normal"; }
+    }
+}
+`
+	current := `theme "nord"
+keybinds {
+    normal {
+        bind "Alt n" { NewPane; }
+        bind "Ctrl g" { SwitchToMode "Locked"; }
+    }
+    locked {
+        bind "Ctrl x" { SwitchToMode "Normal"; }
+    }
+}
+`
+	want := `// Managed by chezmoi
+theme "dracula"
+pane_frames false
+
+keybinds {
+    normal {
+        bind "Alt n" { NewPane; }
+        bind "Ctrl g" { SwitchToMode "Locked"; }
+    }
+    locked {
+        bind "Ctrl g" { SwitchToMode "Normal"; }
+    }
+}
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_Lua(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format lua
+# ignore ["plugins", "telescope", "defaults"]
+#---
+-- Managed by chezmoi
+return {
+  colorscheme = "tokyonight",
+  plugins = {
+    telescope = {
+      defaults = {
+        prompt_prefix = "> ",
+      },
+      on_attach = function(bufnr)
+        vim.keymap.set("n", "gd", vim.lsp.buf.definition, { buffer = bufnr })
+      end,
+    },
+  },
+}
+`
+	current := `return {
+  colorscheme = "gruvbox",
+  plugins = {
+    telescope = {
+      defaults = {
+        prompt_prefix = "🔍 ",
+        file_ignore_patterns = { "node_modules", "%.git/" },
+        mappings = { i = { ["<C-j>"] = require("telescope.actions").move_selection_next } },
+      },
+    },
+  },
+}
+`
+	want := `-- Managed by chezmoi
+return {
+  colorscheme = "tokyonight",
+  plugins = {
+    telescope = {
+      defaults = {
+        prompt_prefix = "🔍 ",
+        file_ignore_patterns = { "node_modules", "%.git/" },
+        mappings = {
+          i = {
+            ["<C-j>"] = require("telescope.actions").move_selection_next,
+          },
+        },
+      },
+      on_attach = function(bufnr)
+        vim.keymap.set("n", "gd", vim.lsp.buf.definition, { buffer = bufnr })
+      end,
+    },
+  },
+}
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_JSONL(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format jsonl
+# match-key name
+# ignore ["*", "lastUsed"]
+#---
+{"name": "deploy", "command": "make deploy", "lastUsed": 0}
+{"name": "test", "command": "go test ./..."}
+`
+	// The app reordered the records, recorded when each was used, and
+	// appended one of its own
+	current := `{"name":"test","command":"go test","lastUsed":1700000100}
+{"name":"user-added","command":"ls -la","lastUsed":1700000200}
+{"name":"deploy","command":"make ship","lastUsed":1700000000}
+`
+	want := `{"name":"deploy","command":"make deploy","lastUsed":1700000000}
+{"name":"test","command":"go test ./...","lastUsed":1700000100}
+{"name":"user-added","command":"ls -la","lastUsed":1700000200}
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_PHPIni(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format phpini
+# ignore ["PHP", "memory_limit"]
+#---
+[PHP]
+; Maximum amount of memory a script may consume
+memory_limit = 128M
+
+; Show errors during development
+display_errors = On
+`
+	current := `[PHP]
+memory_limit = 512M
+display_errors = Off
+`
+	want := `[PHP]
+; Maximum amount of memory a script may consume
+memory_limit = 512M
+
+; Show errors during development
+display_errors = On
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_Plist(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format plist
+# ignore ["NSWindow Frame Main"]
+#---
+<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>ShowStatusBar</key>
+	<true/>
+	<key>FontSize</key>
+	<integer>13</integer>
+</dict>
+</plist>
+`
+	current := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>ShowStatusBar</key>
+	<false/>
+	<key>FontSize</key>
+	<integer>18</integer>
+	<key>NSWindow Frame Main</key>
+	<string>0 0 800 600</string>
+</dict>
+</plist>
+`
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>ShowStatusBar</key>
+	<true/>
+	<key>FontSize</key>
+	<integer>13</integer>
+	<key>NSWindow Frame Main</key>
+	<string>0 0 800 600</string>
+</dict>
+</plist>
+`
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_HCL(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format hcl
+# ignore ["provider", "aws", "profile"]
+#---
+provider "aws" {
+  region = "us-east-1"
 }
 
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && (s == substr || len(substr) == 0 ||
-		(len(s) > 0 && len(substr) > 0 && searchSubstring(s, substr)))
+plugin "docker" {
+  enabled = true
+}
+`
+	current := `provider "aws" {
+  region  = "eu-west-1"
+  profile = var.profile
+}
+`
+	want := `provider "aws" {
+  region  = "us-east-1"
+  profile = var.profile
 }
 
-func searchSubstring(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
-	}
-	return false
+plugin "docker" {
+  enabled = true
+}
+`
+	runIntegrationTest(t, script, current, want)
 }
 
-// Integration tests
+func TestIntegration_ArrayMergeUnion(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format toml
+# array-merge union
+# ignore ["editor", "plugins"]
+#---
+[editor]
+theme = "dark"
+plugins = ["git", "lsp"]
+`
+	current := `[editor]
+theme = "light"
+plugins = ["lsp", "copilot"]
+`
+	want := `[editor]
+theme = "dark"
+plugins = ["git", "lsp", "copilot"]
+`
+	runIntegrationTest(t, script, current, want)
+}
 
-func TestIntegration_JSON(t *testing.T) {
+func TestIntegration_IgnoreArrayMerge(t *testing.T) {
 	script := `#!/usr/bin/env chezmoi-split
 # version 1
 # format json
-# ignore ["app", "setting"]
+# ignore ["plugins"] concat
+# ignore ["hosts"]
 #---
 {
-  "managed": "value",
-  "app": {
-    "setting": "default"
-  }
+  "plugins": ["git", "lsp"],
+  "hosts": ["a"]
 }
 `
-	current := `{
-  "managed": "old",
-  "app": {
-    "setting": "user-modified"
-  }
+	current := `{"plugins": ["lsp", "copilot"], "hosts": ["b"]}`
+	want := `{
+  "plugins": [
+    "git",
+    "lsp",
+    "copilot"
+  ],
+  "hosts": [
+    "b"
+  ]
 }
 `
+	runIntegrationTest(t, script, current, want)
+}
+
+func TestIntegration_IgnoreMaxDepth(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore ["context_servers"] max-depth=2 report-new-keys=true
+#---
+{
+  "context_servers": {}
+}
+`
+	current := `{"context_servers": {"github": {"enabled": true, "settings": {"token": "x"}}}}`
 	want := `{
-  "managed": "value",
-  "app": {
-    "setting": "user-modified"
+  "context_servers": {
+    "github": {
+      "enabled": true,
+      "settings": {}
+    }
   }
 }
 `
 	runIntegrationTest(t, script, current, want)
 }
 
-func TestIntegration_JSON_Wildcard(t *testing.T) {
+func TestIntegration_BaseCurrent(t *testing.T) {
 	script := `#!/usr/bin/env chezmoi-split
 # version 1
 # format json
-# ignore ["servers", "*", "enabled"]
+# base current
 #---
 {
-  "servers": {
-    "server1": {"host": "managed1", "enabled": false},
-    "server2": {"host": "managed2", "enabled": false}
+  "theme": "dark",
+  "editor": {
+    "fontSize": 14
   }
 }
 `
 	current := `{
-  "servers": {
-    "server1": {"host": "old1", "enabled": true},
-    "server2": {"host": "old2", "enabled": true}
-  }
+  "theme": "light",
+  "editor": {
+    "fontSize": 12,
+    "wordWrap": true
+  },
+  "lastOpened": "notes.md"
+}`
+	want := `{
+  "theme": "dark",
+  "editor": {
+    "fontSize": 14,
+    "wordWrap": true
+  },
+  "lastOpened": "notes.md"
 }
 `
-	// enabled should be preserved from current (true), hosts from managed
-	result := runIntegrationTestGetResult(t, script, current)
+	runIntegrationTest(t, script, current, want)
+}
 
-	if !strings.Contains(result, `"host": "managed1"`) {
-		t.Errorf("Expected managed host, got: %s", result)
-	}
-	if !strings.Contains(result, `"enabled": true`) {
-		t.Errorf("Expected preserved enabled=true, got: %s", result)
+func TestIntegration_FallbackCurrent(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.WriteFile(filepath.Join(home, ".app.toml"), []byte("theme = \"solarized\"\nfont = \"mono\"\n"), 0o644); err != nil {
+		t.Fatal(err)
 	}
-}
 
-func TestIntegration_TOML(t *testing.T) {
 	script := `#!/usr/bin/env chezmoi-split
 # version 1
 # format toml
-# ignore ["user", "preference"]
+# fallback-current ~/.app-v1.toml
+# fallback-current ~/.app.toml
+# ignore ["theme"]
 #---
-[server]
-host = "localhost"
-port = 8080
+theme = "dark"
+font = "sans"
+`
 
-[user]
-name = "default"
-preference = "light"
+	t.Run("empty target uses fallback", func(t *testing.T) {
+		want := `theme = "solarized"
+font = "sans"
 `
-	current := `[server]
-host = "oldhost"
-port = 9090
+		runIntegrationTest(t, script, "", want)
+	})
 
-[user]
-name = "oldname"
-preference = "dark"
+	t.Run("target wins over fallback", func(t *testing.T) {
+		want := `theme = "light"
+font = "sans"
 `
-	result := runIntegrationTestGetResult(t, script, current)
+		runIntegrationTest(t, script, "theme = \"light\"\n", want)
+	})
 
-	// Server values should come from managed
-	if !strings.Contains(result, "localhost") {
-		t.Errorf("Expected managed host 'localhost', got: %s", result)
+	t.Run("no fallback exists", func(t *testing.T) {
+		if err := os.Remove(filepath.Join(home, ".app.toml")); err != nil {
+			t.Fatal(err)
+		}
+		want := `theme = "dark"
+font = "sans"
+`
+		runIntegrationTest(t, script, "", want)
+	})
+}
+
+// TestIntegration_NothingPreserved checks the warning for a rule set that
+// keeps nothing from a current file that has data, and the error it becomes
+// with require-preservation.
+func TestIntegration_NothingPreserved(t *testing.T) {
+	const template = `theme = "dark"
+font = "sans"
+`
+
+	tests := []struct {
+		name       string
+		directives string
+		current    string
+		wantWarn   bool
+		wantErr    bool
+	}{
+		{"all paths miss", `# ignore ["colour"]`, "theme = \"light\"\nfont = \"mono\"\n", true, false},
+		{"partial hit", "# ignore [\"theme\"]\n# ignore [\"colour\"]", "theme = \"light\"\nfont = \"mono\"\n", false, false},
+		{"current matches template", `# ignore ["theme"]`, template, false, false},
+		{"empty current", `# ignore ["colour"]`, "", false, false},
+		{"no rules", "", "theme = \"light\"\n", false, false},
+		{"strict", "# ignore [\"colour\"]\n# require-preservation true", "theme = \"light\"\n", false, true},
+		{"strict partial hit", "# ignore [\"theme\"]\n# require-preservation true", "theme = \"light\"\n", false, false},
 	}
-	if !strings.Contains(result, "8080") {
-		t.Errorf("Expected managed port 8080, got: %s", result)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scr, err := script.Parse("#!/usr/bin/env chezmoi-split\n# version 1\n# format toml\n" + tt.directives + "\n#---\n" + template)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			oldStderr := os.Stderr
+			r, w, _ := os.Pipe()
+			os.Stderr = w
+			var out bytes.Buffer
+			err = mergeScript(scr, "", []byte(tt.current), &out)
+			w.Close()
+			os.Stderr = oldStderr
+			stderr, _ := io.ReadAll(r)
+
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("mergeScript() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !strings.Contains(err.Error(), "no app-owned values were preserved") {
+					t.Errorf("error = %v, want the nothing-preserved message", err)
+				}
+				if out.Len() != 0 {
+					t.Errorf("wrote output despite the error:\n%s", out.String())
+				}
+			}
+			gotWarn := strings.Contains(string(stderr), "warning: no app-owned values were preserved — check your ignore paths")
+			if gotWarn != tt.wantWarn {
+				t.Errorf("warning = %v, want %v; stderr:\n%s", gotWarn, tt.wantWarn, stderr)
+			}
+		})
 	}
-	// User preference should be preserved from current
-	if !strings.Contains(result, "dark") {
-		t.Errorf("Expected preserved preference 'dark', got: %s", result)
+}
+
+func TestMergeScript_VerboseMetrics(t *testing.T) {
+	t.Setenv("CHEZMOI_SPLIT_VERBOSE", "1")
+	scr, err := script.Parse("# version 1\n# format toml\n# ignore [\"theme\"]\n#---\ntheme = \"dark\"\n[font]\nsize = 12\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	oldStderr := os.Stderr
+	r, w, _ := os.Pipe()
+	os.Stderr = w
+	var out bytes.Buffer
+	err = mergeScript(scr, "", []byte("theme = \"light\"\n"), &out)
+	w.Close()
+	os.Stderr = oldStderr
+	stderr, _ := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("mergeScript() error = %v", err)
+	}
+
+	for _, want := range []string{
+		"chezmoi-split: metrics: managed: 3 keys, depth 2, 33 bytes\n",
+		"chezmoi-split: metrics: current: 1 key, depth 1, 16 bytes\n",
+		"chezmoi-split: metrics: result: 3 keys, depth 2, 34 bytes\n",
+		"chezmoi-split: metrics: [\"theme\"]: 1 path, 16 bytes preserved\n",
+	} {
+		if !strings.Contains(string(stderr), want) {
+			t.Errorf("stderr missing %q:\n%s", want, stderr)
+		}
 	}
 }
 
-func TestIntegration_INI(t *testing.T) {
-	script := `#!/usr/bin/env chezmoi-split
-# version 1
-# format ini
-# ignore ["database", "password"]
-#---
-[database]
-host = localhost
-port = 3306
-password = default
+func TestMergeScript_DuplicateKeys(t *testing.T) {
+	scr, err := script.Parse("# version 1\n# format json\n# ignore [\"theme\"]\n#---\n{\"theme\": \"dark\", \"theme\": \"light\"}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
 
-[server]
-address = 0.0.0.0
-`
-	current := `[database]
-host = oldhost
-port = 5432
-password = secret123
+	oldStderr := os.Stderr
+	r, w, _ := os.Pipe()
+	os.Stderr = w
+	var out bytes.Buffer
+	err = mergeScript(scr, "", []byte(`{"list": [{"a": 1, "a": 2}]}`), &out)
+	w.Close()
+	os.Stderr = oldStderr
+	stderr, _ := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("mergeScript() error = %v", err)
+	}
 
-[server]
-address = 127.0.0.1
-`
-	result := runIntegrationTestGetResult(t, script, current)
+	for _, want := range []string{
+		`chezmoi-split: warning: managed config (in script): duplicate key ["theme"], keeping the last value` + "\n",
+		`chezmoi-split: warning: current file: duplicate key ["list","0","a"], keeping the last value` + "\n",
+	} {
+		if !strings.Contains(string(stderr), want) {
+			t.Errorf("stderr missing %q:\n%s", want, stderr)
+		}
+	}
+	if want := "{\n  \"theme\": \"light\"\n}\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
 
-	// Database host/port should come from managed
-	if !strings.Contains(result, "localhost") {
-		t.Errorf("Expected managed host 'localhost', got: %s", result)
+// TestMergeScript_TemplateParseError checks that a broken TOML template is
+// reported with the line it is broken on.
+func TestMergeScript_TemplateParseError(t *testing.T) {
+	scr, err := script.Parse("# version 1\n# format toml\n#---\n[server]\nport = 8080\n[client\nname = \"x\"\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
 	}
-	if !strings.Contains(result, "3306") {
-		t.Errorf("Expected managed port 3306, got: %s", result)
+	err = mergeScript(scr, "", nil, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "\n  at line 3, column 8:\n  [client\n") {
+		t.Errorf("mergeScript() error = %v, want it to point at line 3 of the template", err)
 	}
-	// Password should be preserved from current
-	if !strings.Contains(result, "secret123") {
-		t.Errorf("Expected preserved password 'secret123', got: %s", result)
+}
+
+// TestMergeScript_JSON5ParseError checks that a JSON5 template error points
+// at the original text, not at the JSON it was rewritten into.
+func TestMergeScript_JSON5ParseError(t *testing.T) {
+	scr, err := script.Parse("# version 1\n# format json\n# json5 true\n#---\n{\n  name: 'x',\n  size: +1.5,\n}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	// As for JSON, the position is just after the byte that failed
+	err = mergeScript(scr, "", nil, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "\n  at line 3, column 10:\n    size: +1.5,\n           ^") {
+		t.Errorf("mergeScript() error = %v, want it to point at the + on line 3", err)
+	}
+}
+
+func TestMergeScript_FormatMismatch(t *testing.T) {
+	tomlCurrent := "theme = \"light\"\n\n[editor]\nfont_size = 14\n"
+	tests := []struct {
+		name      string
+		directive string
+		current   string
+		want      string
+		wantErr   string
+	}{
+		{
+			name:    "toml current fails the merge",
+			current: tomlCurrent,
+			wantErr: "current file appears to be toml but the script declares json",
+		},
+		{
+			name:      "overwrite replaces it",
+			directive: "# on-format-mismatch overwrite\n",
+			current:   tomlCurrent,
+			want:      "{\n  \"theme\": \"dark\"\n}\n",
+		},
+		{
+			name:    "broken json is still replaced",
+			current: `{"theme": "light",`,
+			want:    "{\n  \"theme\": \"dark\"\n}\n",
+		},
+		{
+			name:    "text in no format is still replaced",
+			current: "not a config file\n",
+			want:    "{\n  \"theme\": \"dark\"\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scr, err := script.Parse("# version 1\n# format json\n# ignore [\"theme\"]\n" + tt.directive + "#---\n{\"theme\": \"dark\"}\n")
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			var out bytes.Buffer
+			err = mergeScript(scr, "", []byte(tt.current), &out)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("mergeScript() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mergeScript() error = %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("output = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+// TestMergeScript_BrokenTOML checks that a TOML current file with a typo is
+// replaced like any broken file instead of being reported as INI.
+func TestMergeScript_BrokenTOML(t *testing.T) {
+	for _, declared := range []string{"toml", "ini"} {
+		t.Run(declared, func(t *testing.T) {
+			scr, err := script.Parse("# version 1\n# format " + declared + "\n#---\n[server]\nport = 8080\n")
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			current := "[server]\nport = oops\n"
+			if declared == "ini" {
+				current = "[server]\nport = 8080\n[client\n"
+			}
+
+			var out bytes.Buffer
+			if err := mergeScript(scr, "", []byte(current), &out); err != nil {
+				t.Fatalf("mergeScript() error = %v", err)
+			}
+			if want := "[server]\nport = 8080\n"; out.String() != want {
+				t.Errorf("output = %q, want %q", out.String(), want)
+			}
+		})
 	}
 }
 
@@ -407,6 +2602,19 @@ multiple lines
 	}
 }
 
+func TestIntegration_Plaintext_FinalNewline(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format plaintext
+# final-newline false
+#---
+set number
+`
+	if got := runIntegrationTestGetResult(t, script, ""); got != "set number" {
+		t.Errorf("Result = %q, want no final newline", got)
+	}
+}
+
 // Helper functions
 
 func runIntegrationTest(t *testing.T, script, current, want string) {
@@ -462,3 +2670,61 @@ func runIntegrationTestGetResult(t *testing.T, script, current string) string {
 	out, _ := io.ReadAll(r)
 	return string(out)
 }
+
+func TestStatsCommand(t *testing.T) {
+	statsDir := t.TempDir()
+	t.Setenv("CHEZMOI_SPLIT_STATS_DIR", statsDir)
+	t.Setenv("CHEZMOI_SOURCE_FILE", "dot_config/app/modify_settings.json")
+
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore ["theme"]
+# ignore ["legacy"]
+#---
+{"theme": "dark"}
+`
+	for i := 0; i < 3; i++ {
+		runIntegrationTestGetResult(t, script, `{"theme": "light"}`)
+	}
+
+	var out strings.Builder
+	if err := runStats([]string{"--stale-after", "3", "~/.config/app/settings.json"}, &out); err != nil {
+		t.Fatalf("runStats() error = %v", err)
+	}
+
+	got := out.String()
+	for _, want := range []string{
+		".config/app/settings.json: 3 runs",
+		`["legacy"]  (no match in 3 runs, candidate for removal)`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("stats output missing %q:\n%s", want, got)
+		}
+	}
+	// Rules that never hit are listed first
+	if strings.Index(got, `["legacy"]`) > strings.Index(got, `["theme"]`) {
+		t.Errorf("never-hit rule should be listed before recently hit rules:\n%s", got)
+	}
+	if strings.Contains(got, `["theme"]  (`) {
+		t.Errorf("recently hit rule should not be flagged:\n%s", got)
+	}
+}
+
+func TestStatsCommand_Errors(t *testing.T) {
+	t.Setenv("CHEZMOI_SPLIT_STATS_DIR", "")
+
+	var out strings.Builder
+	if err := runStats([]string{"target"}, &out); err == nil {
+		t.Error("runStats() without a stats directory should fail")
+	}
+	if err := runStats([]string{"--dir", t.TempDir()}, &out); err == nil {
+		t.Error("runStats() without a target should fail")
+	}
+	if err := runStats([]string{"--dir", t.TempDir(), "unknown"}, &out); err != nil {
+		t.Errorf("runStats() for a target without stats error = %v", err)
+	}
+	if !strings.Contains(out.String(), "No stats recorded for unknown") {
+		t.Errorf("output = %q", out.String())
+	}
+}
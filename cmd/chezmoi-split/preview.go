@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/thirteen37/chezmoi-split/internal/script"
+)
+
+// runPreview implements "chezmoi-split preview --script <script> [--current
+// <file>] [--format <format>]": it merges a sample current file into the
+// script's managed config, exactly as the interpreter would, and writes the
+// result to stdout. Without --current the current file is empty. --format
+// overrides the script's format. Nothing is written and no stats are recorded.
+func runPreview(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("preview", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	scriptPath := fs.String("script", "", "modify script to run")
+	currentPath := fs.String("current", "", "sample current file")
+	formatName := fs.String("format", "", "format to use instead of the script's")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("preview: %w", err)
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("preview: unexpected argument %q", fs.Arg(0))
+	}
+	if *scriptPath == "" {
+		return fmt.Errorf("preview: --script is required")
+	}
+
+	scr, err := loadPreviewScript(*scriptPath, *formatName)
+	if err != nil {
+		return fmt.Errorf("preview: %w", err)
+	}
+	for _, warning := range scr.Warnings {
+		fmt.Fprintf(os.Stderr, "chezmoi-split: warning: %s\n", warning)
+	}
+
+	var currentData []byte
+	if *currentPath != "" {
+		currentData, err = os.ReadFile(*currentPath)
+		if err != nil {
+			return fmt.Errorf("preview: failed to read current file: %w", err)
+		}
+	}
+
+	if err := mergeScript(scr, "", currentData, stdout); err != nil {
+		return fmt.Errorf("preview: %w", err)
+	}
+	return nil
+}
+
+// loadPreviewScript loads the script like loadScript, or, with formatName
+// set, parses it and resolves it to that format instead of the script's.
+func loadPreviewScript(scriptPath, formatName string) (*script.Script, error) {
+	if formatName == "" {
+		return loadScript(scriptPath)
+	}
+
+	scriptContent, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script: %w", err)
+	}
+	scr, err := script.Parse(string(scriptContent))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse script: %w", err)
+	}
+	if err := scr.ResolveFormat(formatName); err != nil {
+		return nil, fmt.Errorf("invalid --format: %w", err)
+	}
+	return scr, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPreviewCommand(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CHEZMOI_SPLIT_STATS_DIR", filepath.Join(dir, "stats"))
+
+	write := func(name, content string) string {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	jsonScript := write("modify_settings.json", `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore ["theme"]
+#---
+{
+  "theme": "dark",
+  "fontSize": 14
+}
+`)
+	jsonCurrent := write("settings.json", `{"theme": "light", "fontSize": 12}`)
+
+	plainScript := write("modify_aliases", `#!/usr/bin/env chezmoi-split
+# version 1
+# format plaintext
+#---
+# chezmoi:managed
+alias ll='ls -l'
+# chezmoi:end
+# chezmoi:ignored
+# chezmoi:end
+`)
+	plainCurrent := write("aliases", `# chezmoi:managed
+alias ll='ls'
+# chezmoi:end
+# chezmoi:ignored
+alias gs='git status'
+# chezmoi:end
+`)
+
+	autoScript := write("modify_app", `#!/usr/bin/env chezmoi-split
+# version 1
+# ignore ["", "name"]
+#---
+name = "managed"
+size = 16
+`)
+	autoCurrent := write("app", `name = "current"
+size = 8
+`)
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "structured",
+			args: []string{"--script", jsonScript, "--current", jsonCurrent},
+			want: "{\n  \"theme\": \"light\",\n  \"fontSize\": 14\n}\n",
+		},
+		{
+			name: "no current file",
+			args: []string{"--script", jsonScript},
+			want: "{\n  \"theme\": \"dark\",\n  \"fontSize\": 14\n}\n",
+		},
+		{
+			name: "plaintext blocks",
+			args: []string{"--script", plainScript, "--current", plainCurrent},
+			want: "# chezmoi:managed\nalias ll='ls -l'\n# chezmoi:end\n# chezmoi:ignored\nalias gs='git status'\n# chezmoi:end\n",
+		},
+		{
+			name: "format override",
+			args: []string{"--script", autoScript, "--current", autoCurrent, "--format", "ini"},
+			want: "name = \"current\"\nsize = 16\n", // ini keeps the current file's quotes
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := runPreview(tt.args, &out); err != nil {
+				t.Fatalf("runPreview() error = %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("output =\n%s\nwant:\n%s", out.String(), tt.want)
+			}
+		})
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "stats")); !os.IsNotExist(err) {
+		t.Errorf("preview recorded stats: %v", err)
+	}
+}
+
+func TestPreviewCommand_Errors(t *testing.T) {
+	dir := t.TempDir()
+	scriptPath := filepath.Join(dir, "modify_settings.json")
+	script := "#!/usr/bin/env chezmoi-split\n# version 1\n# format json\n#---\n{}\n"
+	if err := os.WriteFile(scriptPath, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"missing script", []string{"--current", scriptPath}, "--script is required"},
+		{"positional argument", []string{"--script", scriptPath, "extra"}, `unexpected argument "extra"`},
+		{"missing current", []string{"--script", scriptPath, "--current", filepath.Join(dir, "nope")}, "failed to read current file"},
+		{"unknown format", []string{"--script", scriptPath, "--format", "xml"}, "invalid --format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runPreview(tt.args, &bytes.Buffer{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("runPreview() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/thirteen37/chezmoi-split/internal/stats"
+)
+
+// runStats implements "chezmoi-split stats <target>": it prints the recorded
+// hit counts of each ignore rule for target, oldest last hit first, and flags
+// rules that haven't matched in a while as candidates for removal.
+func runStats(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	dir := fs.String("dir", os.Getenv(stats.DirEnv), "stats directory (default $"+stats.DirEnv+")")
+	staleAfter := fs.Int("stale-after", stats.DefaultStaleAfter, "flag rules without a match in this many runs")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("stats: %w", err)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("stats: expected exactly one target, got %d", fs.NArg())
+	}
+	if *dir == "" {
+		return fmt.Errorf("stats: no stats directory; set %s or pass --dir", stats.DirEnv)
+	}
+
+	home, _ := os.UserHomeDir()
+	target := stats.NormalizeTarget(fs.Arg(0), home)
+
+	f, err := stats.Load(*dir, target)
+	if err != nil {
+		return fmt.Errorf("stats: %w", err)
+	}
+	if f.Runs == 0 {
+		fmt.Fprintf(stdout, "No stats recorded for %s\n", target)
+		return nil
+	}
+
+	fmt.Fprintf(stdout, "%s: %d runs\n\n", target, f.Runs)
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LAST HIT\tHITS\tMISSES\tRULE")
+	for _, r := range f.Summarize(*staleAfter) {
+		lastHit := "never"
+		if r.LastHitRun > 0 {
+			lastHit = r.LastHit.Local().Format("2006-01-02 15:04")
+		}
+		note := ""
+		if r.Stale {
+			note = fmt.Sprintf("  (no match in %d runs, candidate for removal)", r.RunsSinceHit)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s%s\n", lastHit, r.Hits, r.Misses, r.Name, note)
+	}
+	return tw.Flush()
+}
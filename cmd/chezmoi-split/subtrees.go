@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// runSubtrees implements "chezmoi-split subtrees <script> <target>": it
+// merges the target file into the script's managed config, as chezmoi would
+// through the interpreter, and prints only the parts the ignore and
+// preserve-if-missing rules touched. For each path a rule resolved to, the
+// managed value is shown next to the merged one, each as a document holding
+// just that path. Nothing is written and no stats are recorded.
+func runSubtrees(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("subtrees", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("subtrees: %w", err)
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("subtrees: expected a script and a target file, got %d arguments", fs.NArg())
+	}
+	scriptPath, target := fs.Arg(0), fs.Arg(1)
+
+	scr, err := loadScript(scriptPath)
+	if err != nil {
+		return fmt.Errorf("subtrees: %w", err)
+	}
+	for _, warning := range scr.Warnings {
+		fmt.Fprintf(os.Stderr, "chezmoi-split: warning: %s\n", warning)
+	}
+	if scr.Format == "plaintext" {
+		return fmt.Errorf("subtrees: not supported for plaintext format, which merges blocks rather than paths")
+	}
+
+	currentData, _, err := readTarget(target)
+	if err == nil {
+		currentData, err = decodeCurrent(scr, currentData)
+	}
+	if err != nil {
+		return fmt.Errorf("subtrees: %w", err)
+	}
+
+	m, err := mergeTrees(scr, currentData)
+	if err != nil {
+		return fmt.Errorf("subtrees: %w", err)
+	}
+	if len(m.report.Outcomes) == 0 {
+		fmt.Fprintln(stdout, "No ignore rules in the script")
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for _, outcome := range m.report.Outcomes {
+		key := outcome.Path.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if len(seen) > 1 {
+			fmt.Fprintln(stdout)
+		}
+		fmt.Fprintf(stdout, "%s\n", outcome)
+		if path.HasWildcard(outcome.Path) {
+			// The rule matched nothing in current, so there is no single subtree
+			continue
+		}
+		if err := writeSubtree(stdout, "--- managed", m.handler, m.managed, outcome.Path); err != nil {
+			return fmt.Errorf("subtrees: %w", err)
+		}
+		if err := writeSubtree(stdout, "+++ merged", m.handler, m.result, outcome.Path); err != nil {
+			return fmt.Errorf("subtrees: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeSubtree writes a label line and the value at p in tree, serialized on
+// its own, or "(not set)" if tree has no value there.
+func writeSubtree(w io.Writer, label string, handler format.Handler, tree any, p path.Path) error {
+	fmt.Fprintln(w, label)
+	val, ok := handler.GetPath(tree, p)
+	if !ok {
+		fmt.Fprintln(w, "(not set)")
+		return nil
+	}
+
+	data, err := format.SerializeSubtree(handler, p, val)
+	if err != nil {
+		return fmt.Errorf("failed to serialize %s: %w", p, err)
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSubtreesCommand(t *testing.T) {
+	script := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore ["editor", "theme"]
+#---
+{
+  "editor": {
+    "theme": "dark",
+    "fontSize": 14
+  },
+  "terminal": {
+    "shell": "zsh"
+  },
+  "telemetry": false
+}
+`
+	current := `{"editor": {"theme": "light", "fontSize": 12}, "terminal": {"shell": "bash"}, "telemetry": true}`
+
+	dir := t.TempDir()
+	scriptPath := filepath.Join(dir, "modify_settings.json")
+	if err := os.WriteFile(scriptPath, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(dir, "settings.json")
+	if err := os.WriteFile(target, []byte(current), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := runSubtrees([]string{scriptPath, target}, &out); err != nil {
+		t.Fatalf("runSubtrees() error = %v", err)
+	}
+
+	want := `["editor","theme"]: overlay: replaced value with value
+--- managed
+{
+  "editor": {
+    "theme": "dark"
+  }
+}
++++ merged
+{
+  "editor": {
+    "theme": "light"
+  }
+}
+`
+	if out.String() != want {
+		t.Errorf("output =\n%s\nwant:\n%s", out.String(), want)
+	}
+	for _, unrelated := range []string{"fontSize", "terminal", "telemetry"} {
+		if strings.Contains(out.String(), unrelated) {
+			t.Errorf("output mentions %q, which no ignore rule touches", unrelated)
+		}
+	}
+}
+
+func TestSubtreesCommand_Errors(t *testing.T) {
+	dir := t.TempDir()
+	scriptPath := filepath.Join(dir, "modify_aliases")
+	script := "#!/usr/bin/env chezmoi-split\n# version 1\n# format plaintext\n#---\n# chezmoi:managed\nalias ll='ls -l'\n"
+	if err := os.WriteFile(scriptPath, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"missing target", []string{scriptPath}, "expected a script and a target file"},
+		{"plaintext", []string{scriptPath, filepath.Join(dir, "aliases")}, "not supported for plaintext"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runSubtrees(tt.args, &bytes.Buffer{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("runSubtrees() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/thirteen37/chezmoi-split/internal/stats"
+)
+
+// targetFlags holds the flags shared by commands that read the live target
+// file. The target argument names the config (and keys its stats), while
+// --target-file decouples that identity from where the file actually lives.
+type targetFlags struct {
+	targetFile string
+}
+
+// register adds the target flags to fs.
+func (f *targetFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.targetFile, "target-file", "", "read the live file from this path instead of the target's location in $HOME")
+}
+
+// registerCurrentAlias adds --current, the name diff and compare gave
+// --target-file before they shared it. It is deprecated.
+func (f *targetFlags) registerCurrentAlias(fs *flag.FlagSet) {
+	fs.StringVar(&f.targetFile, "current", "", "deprecated: use --target-file")
+}
+
+// path returns the physical file to read for target: --target-file when set,
+// otherwise the target resolved against home.
+func (f *targetFlags) path(target, home string) string {
+	if f.targetFile != "" {
+		return f.targetFile
+	}
+	return homePath(target, home)
+}
+
+// homePath resolves p against home: "~/x" and relative paths are taken
+// relative to home, absolute paths are returned as is.
+func homePath(p, home string) string {
+	rel := stats.NormalizeTarget(p, home)
+	if filepath.IsAbs(rel) {
+		return rel
+	}
+	return filepath.Join(home, filepath.FromSlash(rel))
+}
+
+// read returns the contents of the live file for target. A missing file at
+// the default location reads as empty, like chezmoi's stdin for a target that
+// doesn't exist yet; a missing --target-file is an error.
+func (f *targetFlags) read(target, home string) ([]byte, error) {
+	p := f.path(target, home)
+	data, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) && f.targetFile == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read target file: %w", err)
+	}
+	return data, nil
+}
+
+// readFallback returns the contents of the first of paths that exists, and
+// which path that was. Paths are resolved with homePath. If none exists, it
+// returns no data and an empty path.
+func readFallback(paths []string, home string) ([]byte, string, error) {
+	for _, p := range paths {
+		resolved := homePath(p, home)
+		data, err := os.ReadFile(resolved)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read fallback current file: %w", err)
+		}
+		return data, resolved, nil
+	}
+	return nil, "", nil
+}
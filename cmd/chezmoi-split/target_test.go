@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTargetFlags_Path(t *testing.T) {
+	home := "/home/u"
+
+	tests := []struct {
+		name   string
+		args   []string
+		target string
+		want   string
+	}{
+		{
+			name:   "home-relative target",
+			target: ".config/app/settings.json",
+			want:   "/home/u/.config/app/settings.json",
+		},
+		{
+			name:   "tilde target",
+			target: "~/.config/app/settings.json",
+			want:   "/home/u/.config/app/settings.json",
+		},
+		{
+			name:   "absolute target outside home",
+			target: "/etc/app.conf",
+			want:   "/etc/app.conf",
+		},
+		{
+			name:   "explicit target file",
+			args:   []string{"--target-file", "/srv/fixtures/settings.json"},
+			target: "~/.config/app/settings.json",
+			want:   "/srv/fixtures/settings.json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tf targetFlags
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			tf.register(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if got := tf.path(tt.target, home); got != tt.want {
+				t.Errorf("path(%q) = %q, want %q", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadFallback(t *testing.T) {
+	home := t.TempDir()
+	if err := os.WriteFile(filepath.Join(home, ".app.toml"), []byte("old = true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(t.TempDir(), "other.toml")
+	if err := os.WriteFile(other, []byte("other = true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Missing paths are skipped; the first existing one wins
+	data, used, err := readFallback([]string{"~/.missing.toml", "~/.app.toml", other}, home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != filepath.Join(home, ".app.toml") || string(data) != "old = true\n" {
+		t.Errorf("readFallback() = %q from %q, want the ~/.app.toml contents", data, used)
+	}
+
+	data, used, err = readFallback([]string{"~/.missing.toml"}, home)
+	if err != nil || data != nil || used != "" {
+		t.Errorf("readFallback() with no existing file = %q, %q, %v; want nothing", data, used, err)
+	}
+
+	// A fallback that exists but can't be read is an error
+	if _, _, err := readFallback([]string{home}, home); err == nil {
+		t.Error("readFallback() of a directory should fail")
+	}
+}
+
+func TestTargetFlags_Read(t *testing.T) {
+	home := t.TempDir()
+	elsewhere := filepath.Join(t.TempDir(), "actual.json")
+	if err := os.WriteFile(elsewhere, []byte(`{"from": "target-file"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("explicit file not at the default location", func(t *testing.T) {
+		tf := targetFlags{targetFile: elsewhere}
+		data, err := tf.read("~/.config/app/settings.json", home)
+		if err != nil {
+			t.Fatalf("read() error = %v", err)
+		}
+		if string(data) != `{"from": "target-file"}` {
+			t.Errorf("read() = %q", data)
+		}
+	})
+
+	t.Run("missing default location reads as empty", func(t *testing.T) {
+		var tf targetFlags
+		data, err := tf.read("~/.config/app/settings.json", home)
+		if err != nil || data != nil {
+			t.Errorf("read() = %q, %v; want nil, nil", data, err)
+		}
+	})
+
+	t.Run("missing explicit file is an error", func(t *testing.T) {
+		tf := targetFlags{targetFile: filepath.Join(home, "missing.json")}
+		if _, err := tf.read("~/.config/app/settings.json", home); err == nil {
+			t.Error("read() should fail for a missing --target-file")
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	formatplaintext "github.com/thirteen37/chezmoi-split/internal/format/plaintext"
+	"github.com/thirteen37/chezmoi-split/internal/script"
+)
+
+// validateResult is the outcome of checking one script.
+type validateResult int
+
+const (
+	validateOK      validateResult = iota
+	validateFailed                 // The script has errors
+	validateSkipped                // The script needs a newer chezmoi-split
+)
+
+// runValidate implements "chezmoi-split validate <script>...": it parses each
+// modify script and its template with the declared format handler, and
+// prints errors and warnings as file:line messages. It fails if any script
+// has an error; warnings alone don't fail.
+//
+// Scripts that declare a newer version than this build supports are
+// reported as skipped rather than failed, so one script written for a newer
+// release doesn't fail the run on machines that haven't upgraded yet.
+// --strict-version counts them as failures.
+func runValidate(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	strictVersion := fs.Bool("strict-version", false, "fail on scripts that need a newer chezmoi-split instead of skipping them")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("validate: %w", err)
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("validate: expected at least one script path")
+	}
+
+	failed, skipped, needVersion := 0, 0, 0
+	for _, scriptPath := range fs.Args() {
+		result, version := validateScript(scriptPath, *strictVersion, stdout)
+		switch result {
+		case validateFailed:
+			failed++
+		case validateSkipped:
+			skipped++
+			needVersion = max(needVersion, version)
+		}
+	}
+
+	if skipped > 0 {
+		fmt.Fprintf(stdout, "%d of %d scripts skipped: they need a chezmoi-split that supports script version %d (this one supports up to %d)\n",
+			skipped, fs.NArg(), needVersion, script.CurrentVersion)
+	}
+	if failed > 0 {
+		return fmt.Errorf("validate: %d of %d scripts failed validation", failed, fs.NArg())
+	}
+	return nil
+}
+
+// validateScript checks one script and reports what it finds to stdout.
+// For a skipped script, it also returns the script version it declares.
+func validateScript(scriptPath string, strictVersion bool, stdout io.Writer) (validateResult, int) {
+	report := func(kind string, line int, msg string) {
+		if line > 0 {
+			fmt.Fprintf(stdout, "%s:%d: %s: %s\n", scriptPath, line, kind, msg)
+		} else {
+			fmt.Fprintf(stdout, "%s: %s: %s\n", scriptPath, kind, msg)
+		}
+	}
+
+	scr, err := loadScript(scriptPath)
+	if err != nil {
+		var versionErr *script.VersionError
+		if errors.As(err, &versionErr) && !strictVersion {
+			report("skipped", versionErr.Line, fmt.Sprintf("requires a chezmoi-split that supports script version %d", versionErr.Version))
+			return validateSkipped, versionErr.Version
+		}
+
+		// Report script.Parse's own message rather than the interpreter's wrapping
+		if inner := errors.Unwrap(err); inner != nil {
+			err = inner
+		}
+		line, msg := splitLine(err.Error())
+		report("error", line, msg)
+		return validateFailed, 0
+	}
+
+	for _, warning := range scr.Warnings {
+		line, msg := splitLine(warning)
+		report("warning", line, msg)
+	}
+
+	// chezmoi renders templates before running the script, so a body with
+	// template actions can't be parsed as config here
+	if strings.Contains(scr.Body(), "{{") {
+		report("warning", scr.TemplateLine, "template not checked: it contains chezmoi template actions; "+
+			"validate the output of 'chezmoi execute-template' instead")
+		return validateOK, 0
+	}
+
+	var handler format.Handler = formatplaintext.New()
+	if scr.Format != "plaintext" {
+		handler = getHandler(scr.Format)
+	}
+	if _, err := handler.Parse([]byte(scr.Template), format.ParseOptions{StripComments: scr.StripComments, JSON5: scr.JSON5, KeepComments: scr.KeepComments, MatchKey: scr.MatchKey}); err != nil {
+		line := scr.TemplateLine
+		if n, _ := errorPosition(scr.Format, scr.Template, err); n > 0 {
+			line += n - 1
+		}
+		report("error", line, fmt.Sprintf("invalid %s template: %v", scr.Format, err))
+		return validateFailed, 0
+	}
+	for _, msg := range handlerWarnings(handler, "template") {
+		report("warning", scr.TemplateLine, msg)
+	}
+
+	fmt.Fprintf(stdout, "%s: ok (%s)\n", scriptPath, scr.Format)
+	return validateOK, 0
+}
+
+// splitLine separates a leading "line N: " from a message.
+func splitLine(msg string) (int, string) {
+	rest, ok := strings.CutPrefix(msg, "line ")
+	if !ok {
+		return 0, msg
+	}
+	num, text, ok := strings.Cut(rest, ": ")
+	if !ok {
+		return 0, msg
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil {
+		return 0, msg
+	}
+	return n, text
+}
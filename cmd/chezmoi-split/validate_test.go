@@ -0,0 +1,237 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		wantOK  bool
+		wantOut string
+	}{
+		{
+			name: "valid json",
+			script: `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore ["theme"]
+#---
+{"theme": "dark"}
+`,
+			wantOK:  true,
+			wantOut: "script: ok (json)",
+		},
+		{
+			name: "unknown directive",
+			script: `#!/usr/bin/env chezmoi-split
+# version 1
+# colour blue
+#---
+{}
+`,
+			wantOut: `script:3: error: unknown directive "colour"`,
+		},
+		{
+			name: "unsupported format",
+			script: `# version 1
+# format xml
+#---
+<a/>
+`,
+			wantOut: `script:2: error: unsupported format "xml"`,
+		},
+		{
+			name: "missing version",
+			script: `# format json
+#---
+{}
+`,
+			wantOut: "script:1: error: version directive must come first",
+		},
+		{
+			name: "malformed json",
+			script: `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+#---
+// header
+{
+  "a": 1,
+  "b": ,
+}
+`,
+			wantOut: "script:8: error: invalid json template",
+		},
+		{
+			name: "malformed toml",
+			script: `# version 1
+# format toml
+#---
+[server]
+port = = 8080
+`,
+			wantOut: "script:5: error: invalid toml template",
+		},
+		{
+			// The parser says line 4; the table name is unclosed on line 3
+			name: "unclosed toml table",
+			script: `# version 1
+# format toml
+#---
+[server]
+port = 8080
+[client
+name = "x"
+`,
+			wantOut: "script:6: error: invalid toml template",
+		},
+		{
+			name: "malformed ini",
+			script: `# version 1
+# format ini
+#---
+[server]
+port = 8080
+[client
+`,
+			wantOut: "script:6: error: invalid ini template",
+		},
+		{
+			name: "plaintext with ignore warns",
+			script: `# version 1
+# format plaintext
+# ignore ["a"]
+#---
+# chezmoi:managed
+x
+# chezmoi:end
+`,
+			wantOK:  true,
+			wantOut: "script:3: warning: ignore directives are not used with plaintext format",
+		},
+		{
+			name: "duplicate key warns",
+			script: `# version 1
+# format json
+#---
+{"servers": {"web": {"port": 80, "port": 8080}}}
+`,
+			wantOK:  true,
+			wantOut: `script:4: warning: template: duplicate key ["servers","web","port"], keeping the last value`,
+		},
+		{
+			name: "template actions are not checked",
+			script: `# version 1
+# format json
+#---
+{"font": {{ .size }}}
+`,
+			wantOK:  true,
+			wantOut: "script:4: warning: template not checked",
+		},
+		{
+			name: "auto-detected format warns",
+			script: `# version 1
+#---
+{"a": 1}
+`,
+			wantOK:  true,
+			wantOut: "script: warning: format auto-detected as json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scriptPath := filepath.Join(t.TempDir(), "script")
+			if err := os.WriteFile(scriptPath, []byte(tt.script), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			var out strings.Builder
+			err := runValidate([]string{scriptPath}, &out)
+			if tt.wantOK && err != nil {
+				t.Errorf("runValidate() error = %v\n%s", err, out.String())
+			}
+			if !tt.wantOK && err == nil {
+				t.Errorf("runValidate() should fail\n%s", out.String())
+			}
+
+			got := strings.ReplaceAll(out.String(), scriptPath, "script")
+			if !strings.Contains(got, tt.wantOut) {
+				t.Errorf("output = %q, want it to contain %q", got, tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestValidateCommand_MultipleScripts(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good")
+	bad := filepath.Join(dir, "bad")
+	if err := os.WriteFile(good, []byte("# version 1\n# format json\n#---\n{}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bad, []byte("# version 1\n# format json\n#---\n{\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	err := runValidate([]string{good, bad, filepath.Join(dir, "missing")}, &out)
+	if err == nil || !strings.Contains(err.Error(), "2 of 3 scripts failed") {
+		t.Errorf("runValidate() error = %v, want 2 of 3 scripts failed", err)
+	}
+	if !strings.Contains(out.String(), good+": ok") {
+		t.Errorf("good script not reported ok:\n%s", out.String())
+	}
+
+	if err := runValidate(nil, &out); err == nil {
+		t.Error("runValidate() without scripts should fail")
+	}
+}
+
+func TestValidateCommand_UnsupportedVersion(t *testing.T) {
+	dir := t.TempDir()
+	scripts := map[string]string{
+		"v1":   "# version 1\n# format json\n#---\n{}\n",
+		"v2":   "#!/usr/bin/env chezmoi-split\n# version 2\n#---\n{}\n",
+		"v999": "# version 999\n# format json\n#---\n{}\n",
+	}
+	var paths []string
+	for _, name := range []string{"v1", "v2", "v999"} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(scripts[name]), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+
+	var out strings.Builder
+	if err := runValidate(paths, &out); err != nil {
+		t.Errorf("runValidate() error = %v, want newer scripts skipped\n%s", err, out.String())
+	}
+	got := strings.ReplaceAll(out.String(), dir+string(filepath.Separator), "")
+	for _, want := range []string{
+		"v1: ok (json)",
+		"v2:2: skipped: requires a chezmoi-split that supports script version 2",
+		"v999:1: skipped: requires a chezmoi-split that supports script version 999",
+		"2 of 3 scripts skipped: they need a chezmoi-split that supports script version 999 (this one supports up to 1)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output = %q, want it to contain %q", got, want)
+		}
+	}
+
+	out.Reset()
+	err := runValidate(append([]string{"--strict-version"}, paths...), &out)
+	if err == nil || !strings.Contains(err.Error(), "2 of 3 scripts failed") {
+		t.Errorf("runValidate(--strict-version) error = %v, want 2 of 3 scripts failed", err)
+	}
+	if !strings.Contains(out.String(), "error: unsupported version 999") {
+		t.Errorf("strict output = %q, want an unsupported version error", out.String())
+	}
+}
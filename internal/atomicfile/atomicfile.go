@@ -0,0 +1,42 @@
+// Package atomicfile writes files so that readers see either the old or the
+// new contents, never a partial write.
+package atomicfile
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile writes data to a temp file next to name and renames it into
+// place. The file gets perm; an existing file's permission bits are kept
+// instead.
+func WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if info, err := os.Stat(name); err == nil {
+		perm = info.Mode().Perm()
+	}
+	return WriteFileMode(name, data, perm)
+}
+
+// WriteFileMode is WriteFile, except that the file always gets perm, even
+// if it already exists with other permission bits.
+func WriteFileMode(name string, data []byte, perm fs.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
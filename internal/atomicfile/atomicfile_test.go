@@ -0,0 +1,70 @@
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "config.json")
+
+	if err := WriteFile(name, []byte("first\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("new file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// An existing file keeps its mode
+	if err := os.Chmod(name, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(name, []byte("second\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	data, _ := os.ReadFile(name)
+	if string(data) != "second\n" {
+		t.Errorf("contents = %q, want %q", data, "second\n")
+	}
+	info, _ = os.Stat(name)
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("rewritten file mode = %v, want 0640", info.Mode().Perm())
+	}
+
+	// No temp files are left behind
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want 1", len(entries))
+	}
+}
+
+func TestWriteFileMode(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(name, []byte("first\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFileMode(name, []byte("second\n"), 0o600); err != nil {
+		t.Fatalf("WriteFileMode() error = %v", err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v, want 0600 replacing the existing mode", info.Mode().Perm())
+	}
+}
+
+func TestWriteFile_MissingDir(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "missing", "config.json")
+	if err := WriteFile(name, []byte("x"), 0o644); err == nil {
+		t.Error("WriteFile() into a missing directory should fail")
+	}
+}
@@ -0,0 +1,120 @@
+// Package charset converts config files in legacy character encodings to
+// and from the UTF-8 text the format handlers work on.
+package charset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Supported encodings.
+const (
+	UTF8    = "utf-8"
+	UTF16LE = "utf-16le"
+	UTF16BE = "utf-16be"
+	Latin1  = "latin1" // ISO-8859-1
+)
+
+// Names lists the supported encodings.
+var Names = []string{UTF8, UTF16LE, UTF16BE, Latin1}
+
+// aliases maps other common spellings to the names in Names.
+var aliases = map[string]string{
+	"utf8":       UTF8,
+	"latin-1":    Latin1,
+	"iso-8859-1": Latin1,
+}
+
+// Lookup returns the supported encoding called name, accepting the aliases
+// "utf8", "latin-1", and "iso-8859-1".
+func Lookup(name string) (string, bool) {
+	if canonical, ok := aliases[name]; ok {
+		return canonical, true
+	}
+	for _, n := range Names {
+		if n == name {
+			return n, true
+		}
+	}
+	return "", false
+}
+
+// bom returns the byte order mark written at the start of UTF-16 files.
+func bom(encoding string) []byte {
+	switch encoding {
+	case UTF16LE:
+		return []byte{0xFF, 0xFE}
+	case UTF16BE:
+		return []byte{0xFE, 0xFF}
+	}
+	return nil
+}
+
+// Decode converts data from encoding to UTF-8. A UTF-16 byte order mark
+// matching the encoding is dropped. UTF-8 data is returned unchanged.
+func Decode(data []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case UTF8:
+		return data, nil
+	case Latin1:
+		// Latin-1 bytes are the first 256 code points
+		out := make([]byte, 0, len(data))
+		for _, b := range data {
+			out = utf8.AppendRune(out, rune(b))
+		}
+		return out, nil
+	case UTF16LE, UTF16BE:
+		data = bytes.TrimPrefix(data, bom(encoding))
+		if len(data)%2 != 0 {
+			return nil, fmt.Errorf("%s data has an odd number of bytes", encoding)
+		}
+		var order binary.ByteOrder = binary.LittleEndian
+		if encoding == UTF16BE {
+			order = binary.BigEndian
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			units[i] = order.Uint16(data[2*i:])
+		}
+		return []byte(string(utf16.Decode(units))), nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", encoding)
+}
+
+// Encode converts UTF-8 text to encoding. UTF-16 output starts with a byte
+// order mark, which Windows tools rely on to recognize it. Text that isn't
+// valid UTF-8, or that the encoding can't represent, is an error.
+func Encode(text []byte, encoding string) ([]byte, error) {
+	if encoding == UTF8 {
+		return text, nil
+	}
+	if !utf8.Valid(text) {
+		return nil, fmt.Errorf("text is not valid UTF-8")
+	}
+
+	switch encoding {
+	case Latin1:
+		out := make([]byte, 0, len(text))
+		for _, r := range string(text) {
+			if r > 0xFF {
+				return nil, fmt.Errorf("character %q can't be written in %s", r, encoding)
+			}
+			out = append(out, byte(r))
+		}
+		return out, nil
+	case UTF16LE, UTF16BE:
+		out := bom(encoding)
+		for _, unit := range utf16.Encode([]rune(string(text))) {
+			if encoding == UTF16LE {
+				out = binary.LittleEndian.AppendUint16(out, unit)
+			} else {
+				out = binary.BigEndian.AppendUint16(out, unit)
+			}
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", encoding)
+}
@@ -0,0 +1,108 @@
+package charset
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   string
+		wantOK bool
+	}{
+		{"utf-8", UTF8, true},
+		{"utf8", UTF8, true},
+		{"utf-16le", UTF16LE, true},
+		{"latin1", Latin1, true},
+		{"iso-8859-1", Latin1, true},
+		{"UTF-8", "", false},
+		{"cp1252", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := Lookup(tt.name)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("Lookup(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestDecodeEncode(t *testing.T) {
+	text := "[user]\nname = Zoë\n"
+
+	tests := []struct {
+		encoding string
+		data     []byte
+	}{
+		{UTF8, []byte(text)},
+		{Latin1, []byte("[user]\nname = Zo\xeb\n")},
+		{UTF16LE, append([]byte{0xFF, 0xFE}, utf16Bytes(text, false)...)},
+		{UTF16BE, append([]byte{0xFE, 0xFF}, utf16Bytes(text, true)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			decoded, err := Decode(tt.data, tt.encoding)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if string(decoded) != text {
+				t.Errorf("Decode() = %q, want %q", decoded, text)
+			}
+
+			encoded, err := Encode(decoded, tt.encoding)
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if !bytes.Equal(encoded, tt.data) {
+				t.Errorf("Encode() = %q, want %q", encoded, tt.data)
+			}
+		})
+	}
+
+	// UTF-16 without a byte order mark decodes too
+	if decoded, err := Decode(utf16Bytes(text, false), UTF16LE); err != nil || string(decoded) != text {
+		t.Errorf("Decode(no BOM) = %q, %v, want %q", decoded, err, text)
+	}
+	// Characters outside the BMP use surrogate pairs
+	encoded, err := Encode([]byte("🙂"), UTF16LE)
+	if err != nil || !bytes.Equal(encoded, []byte{0xFF, 0xFE, 0x3D, 0xD8, 0x42, 0xDE}) {
+		t.Errorf("Encode(emoji) = % x, %v", encoded, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"odd utf-16", second(Decode([]byte{0xFF, 0xFE, 'a'}, UTF16LE)), "odd number of bytes"},
+		{"latin1 range", second(Encode([]byte("€"), Latin1)), `character '€' can't be written in latin1`},
+		{"invalid utf-8", second(Encode([]byte("\xff"), UTF16BE)), "not valid UTF-8"},
+		{"unknown decode", second(Decode(nil, "cp1252")), `unsupported encoding "cp1252"`},
+		{"unknown encode", second(Encode(nil, "cp1252")), `unsupported encoding "cp1252"`},
+	}
+	for _, tt := range tests {
+		if tt.err == nil || !strings.Contains(tt.err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want containing %q", tt.name, tt.err, tt.want)
+		}
+	}
+}
+
+// utf16Bytes encodes ASCII and Latin-1 text as UTF-16 without a byte order mark.
+func utf16Bytes(text string, bigEndian bool) []byte {
+	var out []byte
+	for _, r := range text {
+		if bigEndian {
+			out = append(out, byte(r>>8), byte(r))
+		} else {
+			out = append(out, byte(r), byte(r>>8))
+		}
+	}
+	return out
+}
+
+func second(_ []byte, err error) error {
+	return err
+}
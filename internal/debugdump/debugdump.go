@@ -0,0 +1,174 @@
+// Package debugdump records how the interpreter was invoked, so a run under
+// chezmoi apply can be compared with a run by hand. Dumps hold hashes and
+// sizes instead of file contents, and only chezmoi's environment variables.
+package debugdump
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thirteen37/chezmoi-split/internal/atomicfile"
+)
+
+// DirEnv names the environment variable that enables dumps and sets the
+// directory they are written to.
+const DirEnv = "CHEZMOI_SPLIT_DEBUG_DUMP"
+
+// DefaultKeep is the number of dumps kept in the directory; older ones are
+// removed when a new one is written.
+const DefaultKeep = 20
+
+// redacted replaces the value of environment variables that look secret.
+const redacted = "<redacted>"
+
+// secretWords mark environment variable names whose values are redacted.
+var secretWords = []string{"TOKEN", "SECRET", "PASSWORD", "PASSPHRASE", "KEY"}
+
+// Input describes data the interpreter read, without its contents.
+type Input struct {
+	Bytes  int    `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// NewInput returns the size and hash of data.
+func NewInput(data []byte) *Input {
+	sum := sha256.Sum256(data)
+	return &Input{Bytes: len(data), SHA256: hex.EncodeToString(sum[:])}
+}
+
+// Dump is one interpreter invocation.
+type Dump struct {
+	Time             time.Time         `json:"time"`
+	Version          string            `json:"version"`           // chezmoi-split version
+	Args             []string          `json:"args"`              // argv, with the home directory shown as ~
+	Env              map[string]string `json:"env"`               // CHEZMOI* variables, secrets redacted
+	Script           string            `json:"script"`            // Script path, with the home directory shown as ~
+	ScriptFile       *Input            `json:"script_file"`       // Nil if the script couldn't be read
+	ScriptVersion    int               `json:"script_version"`    // Version the script declares, 0 if unknown
+	SupportedVersion int               `json:"supported_version"` // Highest script version this build supports
+	Stdin            *Input            `json:"stdin"`             // Nil if stdin wasn't read
+	Target           string            `json:"target"`            // Target name, relative to the home directory
+	TargetFile       *Input            `json:"target_file"`       // The target on disk; nil if it doesn't exist
+	ExitStatus       int               `json:"exit_status"`
+	Error            string            `json:"error,omitempty"`
+}
+
+// Environ returns the CHEZMOI* variables of environ ("NAME=value" entries)
+// for a dump. Values whose names look secret are redacted, and the home
+// directory is shown as ~.
+func Environ(environ []string, home string) map[string]string {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, "CHEZMOI") {
+			continue
+		}
+		for _, word := range secretWords {
+			if strings.Contains(name, word) {
+				value = redacted
+				break
+			}
+		}
+		env[name] = HidePath(value, home)
+	}
+	return env
+}
+
+// HidePath replaces the home directory at the start of s with ~.
+func HidePath(s, home string) string {
+	if home == "" {
+		return s
+	}
+	if s == home {
+		return "~"
+	}
+	if rest, ok := strings.CutPrefix(s, home+string(filepath.Separator)); ok {
+		return "~/" + filepath.ToSlash(rest)
+	}
+	return s
+}
+
+// Write saves d as a new JSON file in dir and removes the oldest dumps so at
+// most keep remain. It returns the path written.
+func Write(dir string, d *Dump, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create dump directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode dump: %w", err)
+	}
+
+	// Names sort in time order; the pid separates runs in the same instant
+	name := fmt.Sprintf("dump-%s-%d.json", d.Time.UTC().Format("20060102T150405.000000000Z"), os.Getpid())
+	p := filepath.Join(dir, name)
+	if err := atomicfile.WriteFile(p, append(data, '\n'), 0o600); err != nil {
+		return "", fmt.Errorf("failed to write dump: %w", err)
+	}
+
+	return p, prune(dir, keep)
+}
+
+// prune removes the oldest dumps in dir beyond the newest keep.
+func prune(dir string, keep int) error {
+	dumps, err := filepath.Glob(filepath.Join(dir, "dump-*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(dumps)
+	for len(dumps) > keep {
+		if err := os.Remove(dumps[0]); err != nil {
+			return fmt.Errorf("failed to remove old dump: %w", err)
+		}
+		dumps = dumps[1:]
+	}
+	return nil
+}
+
+// Load reads a dump written by Write.
+func Load(p string) (*Dump, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dump: %w", err)
+	}
+	d := &Dump{}
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, fmt.Errorf("failed to parse dump %s: %w", p, err)
+	}
+	return d, nil
+}
+
+// Analyze returns the anomalies in d that commonly explain a script that
+// works by hand but not under chezmoi, one sentence each.
+func Analyze(d *Dump) []string {
+	var findings []string
+
+	if d.Stdin != nil && d.Stdin.Bytes == 0 && d.TargetFile != nil && d.TargetFile.Bytes > 0 {
+		findings = append(findings, fmt.Sprintf(
+			"stdin was empty but the target %s exists with %d bytes; chezmoi passes the target's contents on stdin, so the merge ran without the current file",
+			d.Target, d.TargetFile.Bytes))
+	}
+
+	if _, ok := d.Env["CHEZMOI"]; !ok {
+		findings = append(findings, "CHEZMOI is not set, so this run wasn't started by chezmoi")
+	} else if _, ok := d.Env["CHEZMOI_SOURCE_FILE"]; !ok {
+		findings = append(findings, fmt.Sprintf(
+			"CHEZMOI_SOURCE_FILE is not set; the target name %s was derived from the script path, which can differ from chezmoi's", d.Target))
+	}
+
+	if d.ScriptVersion > d.SupportedVersion {
+		findings = append(findings, fmt.Sprintf(
+			"the script declares version %d but chezmoi-split %s supports up to version %d; the chezmoi-split on chezmoi's PATH may be older than the one you run by hand",
+			d.ScriptVersion, d.Version, d.SupportedVersion))
+	}
+
+	return findings
+}
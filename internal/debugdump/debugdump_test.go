@@ -0,0 +1,109 @@
+package debugdump
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrite_KeepsNewestDumps(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var written []string
+	for i := 0; i < 5; i++ {
+		d := &Dump{Time: base.Add(time.Duration(i) * time.Second), Script: "modify_settings.json", Stdin: NewInput([]byte("{}"))}
+		p, err := Write(dir, d, 3)
+		if err != nil {
+			t.Fatalf("Write() run %d error = %v", i, err)
+		}
+		written = append(written, p)
+	}
+
+	remaining, _ := filepath.Glob(filepath.Join(dir, "dump-*.json"))
+	if !reflect.DeepEqual(remaining, written[2:]) {
+		t.Errorf("remaining dumps = %v, want the newest 3 %v", remaining, written[2:])
+	}
+
+	d, err := Load(written[4])
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !d.Time.Equal(base.Add(4*time.Second)) || d.Stdin == nil || d.Stdin.Bytes != 2 {
+		t.Errorf("Load() = %+v, want the last dump with 2 stdin bytes", d)
+	}
+}
+
+func TestEnviron_Sanitizes(t *testing.T) {
+	env := Environ([]string{
+		"CHEZMOI=1",
+		"CHEZMOI_SOURCE_DIR=/home/me/.local/share/chezmoi",
+		"CHEZMOI_GITHUB_ACCESS_TOKEN=ghp_secret",
+		"HOME=/home/me",
+		"AWS_SECRET_ACCESS_KEY=x",
+	}, "/home/me")
+
+	want := map[string]string{
+		"CHEZMOI":                     "1",
+		"CHEZMOI_SOURCE_DIR":          "~/.local/share/chezmoi",
+		"CHEZMOI_GITHUB_ACCESS_TOKEN": redacted,
+	}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("Environ() = %v, want %v", env, want)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	chezmoiEnv := map[string]string{"CHEZMOI": "1", "CHEZMOI_SOURCE_FILE": "dot_app/modify_settings.json"}
+
+	tests := []struct {
+		name string
+		dump Dump
+		want []string // Substrings, one per expected finding
+	}{
+		{
+			name: "healthy run",
+			dump: Dump{Env: chezmoiEnv, Stdin: NewInput([]byte("{}")), TargetFile: NewInput([]byte("{}")), ScriptVersion: 1, SupportedVersion: 1},
+		},
+		{
+			name: "empty stdin on existing target",
+			dump: Dump{Env: chezmoiEnv, Target: ".app/settings.json", Stdin: NewInput(nil), TargetFile: NewInput([]byte(`{"a": 1}`)), ScriptVersion: 1, SupportedVersion: 1},
+			want: []string{"stdin was empty but the target .app/settings.json exists with 8 bytes"},
+		},
+		{
+			name: "empty stdin on missing target",
+			dump: Dump{Env: chezmoiEnv, Stdin: NewInput(nil), ScriptVersion: 1, SupportedVersion: 1},
+		},
+		{
+			name: "run by hand",
+			dump: Dump{Env: map[string]string{}, ScriptVersion: 1, SupportedVersion: 1},
+			want: []string{"CHEZMOI is not set"},
+		},
+		{
+			name: "missing source file",
+			dump: Dump{Env: map[string]string{"CHEZMOI": "1"}, ScriptVersion: 1, SupportedVersion: 1},
+			want: []string{"CHEZMOI_SOURCE_FILE is not set"},
+		},
+		{
+			name: "version mismatch",
+			dump: Dump{Env: chezmoiEnv, Version: "0.4.0", ScriptVersion: 2, SupportedVersion: 1},
+			want: []string{"declares version 2 but chezmoi-split 0.4.0 supports up to version 1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Analyze(&tt.dump)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Analyze() = %q, want %d findings", got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(got[i], want) {
+					t.Errorf("finding %d = %q, want it to contain %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+// Package fingerprint embeds and verifies a short hash of the managed template
+// in merged output, so stale targets can be told apart from fresh ones.
+package fingerprint
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	"github.com/thirteen37/chezmoi-split/internal/format"
+)
+
+// DefaultKey is the key used to store the fingerprint in structured formats.
+const DefaultKey = "_chezmoi_split"
+
+// marker identifies a fingerprint comment line in line-based formats.
+const marker = "chezmoi-split:fingerprint"
+
+// lineRegex matches a fingerprint comment line and captures the hash.
+var lineRegex = regexp.MustCompile(marker + `\s+([0-9a-f]+)`)
+
+// Compute returns a short hash identifying the rendered managed template.
+func Compute(template string) string {
+	sum := sha256.Sum256([]byte(template))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// commentPrefixes maps each format whose fingerprint is a comment line to
+// its comment syntax. Plaintext picks its prefix from the file's markers.
+var commentPrefixes = map[string]string{
+	"ini":        ";",
+	"phpini":     ";",
+	"reg":        ";",
+	"toml":       "#",
+	"yaml":       "#",
+	"dotenv":     "#",
+	"properties": "#",
+	"hcl":        "#",
+	"sshconfig":  "#",
+	"systemd":    "#",
+	"desktop":    "#",
+	"nginx":      "#",
+	"kdl":        "//",
+	"lua":        "--",
+	"plaintext":  "#",
+}
+
+// UsesComment reports whether the fingerprint for a format is stored as a
+// comment line rather than as a key in the tree. Only JSON and plist, which
+// have no comments, use a key.
+func UsesComment(formatName string) bool {
+	_, ok := commentPrefixes[formatName]
+	return ok
+}
+
+// CommentPrefix returns the comment syntax of a format's fingerprint line.
+func CommentPrefix(formatName string) string {
+	return commentPrefixes[formatName]
+}
+
+// AtEnd reports whether a format's fingerprint line goes after the output
+// rather than before it: a registry export must start with its header.
+func AtEnd(formatName string) bool {
+	return formatName == "reg"
+}
+
+// StripKey removes the fingerprint key from the root of a parsed tree.
+// Trees that aren't ordered maps are left untouched.
+func StripKey(tree any, key string) {
+	if om := format.ToOrderedMapPtr(tree); om != nil {
+		om.Delete(key)
+	}
+}
+
+// StripLine removes fingerprint comment lines from line-based content.
+func StripLine(data []byte) []byte {
+	if !strings.Contains(string(data), marker) {
+		return data
+	}
+	var out []string
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if !strings.Contains(line, marker) {
+			out = append(out, line)
+		}
+	}
+	return []byte(strings.Join(out, ""))
+}
+
+// CommentLine returns the fingerprint line for line-based formats,
+// using prefix as the comment syntax (e.g. "#" or ";").
+func CommentLine(prefix, hash string) string {
+	return prefix + " " + marker + " " + hash
+}
+
+// Extract returns the fingerprint embedded in serialized output.
+// For structured formats it reads the key from the parsed tree; for
+// line-based formats it scans for the comment line.
+func Extract(data []byte, formatName string, handler format.Handler, opts format.ParseOptions, key string) (string, bool) {
+	if UsesComment(formatName) {
+		scanner := bufio.NewScanner(strings.NewReader(string(data)))
+		for scanner.Scan() {
+			if m := lineRegex.FindStringSubmatch(scanner.Text()); m != nil {
+				return m[1], true
+			}
+		}
+		return "", false
+	}
+
+	tree, err := handler.Parse(data, opts)
+	if err != nil {
+		return "", false
+	}
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return "", false
+	}
+	val, ok := om.Get(key)
+	if !ok {
+		return "", false
+	}
+	hash, ok := val.(string)
+	return hash, ok
+}
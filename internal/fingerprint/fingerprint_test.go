@@ -0,0 +1,100 @@
+package fingerprint
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	formatjson "github.com/thirteen37/chezmoi-split/internal/format/json"
+)
+
+func TestCompute(t *testing.T) {
+	a := Compute(`{"key": "a"}`)
+	b := Compute(`{"key": "b"}`)
+
+	if len(a) != 12 {
+		t.Errorf("Compute() length = %d, want 12", len(a))
+	}
+	if a == b {
+		t.Error("Compute() should differ for different templates")
+	}
+	if a != Compute(`{"key": "a"}`) {
+		t.Error("Compute() should be deterministic")
+	}
+}
+
+func TestStripKey(t *testing.T) {
+	tree := orderedmap.New()
+	tree.Set("key", "value")
+	tree.Set(DefaultKey, "abc")
+
+	StripKey(tree, DefaultKey)
+
+	if _, ok := tree.Get(DefaultKey); ok {
+		t.Error("StripKey() left the fingerprint key in place")
+	}
+	if v, _ := tree.Get("key"); v != "value" {
+		t.Errorf("StripKey() removed unrelated key, got %v", v)
+	}
+
+	// Non-map trees are ignored
+	StripKey("scalar", DefaultKey)
+}
+
+func TestStripLine(t *testing.T) {
+	input := "; chezmoi-split:fingerprint 0123456789ab\n[section]\nkey = value\n"
+	got := string(StripLine([]byte(input)))
+	want := "[section]\nkey = value\n"
+	if got != want {
+		t.Errorf("StripLine() = %q, want %q", got, want)
+	}
+
+	unchanged := "[section]\nkey = value\n"
+	if got := string(StripLine([]byte(unchanged))); got != unchanged {
+		t.Errorf("StripLine() = %q, want unchanged", got)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	h := formatjson.New()
+
+	t.Run("structured", func(t *testing.T) {
+		data := []byte(`{"key": "value", "_chezmoi_split": "0123456789ab"}`)
+		hash, ok := Extract(data, "json", h, format.ParseOptions{}, DefaultKey)
+		if !ok || hash != "0123456789ab" {
+			t.Errorf("Extract() = %q, %v; want 0123456789ab, true", hash, ok)
+		}
+	})
+
+	t.Run("comment", func(t *testing.T) {
+		data := []byte(CommentLine(";", "0123456789ab") + "\n[section]\n")
+		hash, ok := Extract(data, "ini", nil, format.ParseOptions{}, DefaultKey)
+		if !ok || hash != "0123456789ab" {
+			t.Errorf("Extract() = %q, %v; want 0123456789ab, true", hash, ok)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		if _, ok := Extract([]byte(`{"key": "value"}`), "json", h, format.ParseOptions{}, DefaultKey); ok {
+			t.Error("Extract() found a fingerprint that isn't there")
+		}
+	})
+}
+
+func TestCommentLine(t *testing.T) {
+	for _, name := range []string{"json", "plist", "jsonl"} {
+		if UsesComment(name) {
+			t.Errorf("UsesComment(%q) = true, want a key", name)
+		}
+	}
+	if !UsesComment("nginx") || CommentPrefix("nginx") != "#" || CommentPrefix("lua") != "--" {
+		t.Error("nginx and lua should use their own comment syntax")
+	}
+	if line := CommentLine(CommentPrefix("kdl"), "abc"); line != "// chezmoi-split:fingerprint abc" {
+		t.Errorf("CommentLine() = %q", line)
+	}
+	if !strings.Contains(CommentLine("#", "x"), marker) {
+		t.Error("CommentLine() must contain the fingerprint marker")
+	}
+}
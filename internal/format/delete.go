@@ -0,0 +1,53 @@
+package format
+
+import (
+	"fmt"
+	"slices"
+)
+
+// DeleteMatches removes every value in tree that segments match. Wildcards
+// are resolved with ExpandPath, so "*" and "**" delete every match; a path
+// that matches nothing removes nothing.
+//
+// Map keys are deleted in place. An array element is removed from its array
+// and the shorter array is stored back in the parent, so elements of a root
+// array can't be deleted.
+func DeleteMatches(tree any, segments []string) error {
+	matches := ExpandPath(tree, segments)
+	if _, isArray := tree.([]any); isArray {
+		for _, keys := range matches {
+			if len(keys) == 1 {
+				return fmt.Errorf("cannot delete an element of the root array")
+			}
+		}
+	}
+
+	// Later matches first, so removing an array element doesn't shift the
+	// index of a match still to be deleted
+	for i := len(matches) - 1; i >= 0; i-- {
+		deleteKeys(tree, matches[i])
+	}
+	return nil
+}
+
+// deleteKeys removes the value at keys, which must exist, and returns
+// current with the value removed.
+func deleteKeys(current any, keys []string) any {
+	if arr, ok := current.([]any); ok {
+		i, _ := ArrayIndex(keys[0], len(arr))
+		if len(keys) == 1 {
+			return slices.Delete(arr, i, i+1)
+		}
+		arr[i] = deleteKeys(arr[i], keys[1:])
+		return arr
+	}
+
+	om := ToOrderedMapPtr(current)
+	if len(keys) == 1 {
+		om.Delete(keys[0])
+		return current
+	}
+	val, _ := om.Get(keys[0])
+	om.Set(keys[0], deleteKeys(val, keys[1:]))
+	return current
+}
@@ -0,0 +1,72 @@
+package format
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+func TestDeleteMatches(t *testing.T) {
+	// {"flags": {"exp_a": 1, "keep": 2, "exp_b": 3}, "list": ["a", "b", "c"],
+	//  "servers": [{"debug": true, "name": "x"}, {"debug": false}]}
+	newTree := func() *orderedmap.OrderedMap {
+		flags := orderedmap.New()
+		flags.Set("exp_a", 1)
+		flags.Set("keep", 2)
+		flags.Set("exp_b", 3)
+		first := orderedmap.New()
+		first.Set("debug", true)
+		first.Set("name", "x")
+		second := orderedmap.New()
+		second.Set("debug", false)
+		tree := orderedmap.New()
+		tree.Set("flags", flags)
+		tree.Set("list", []any{"a", "b", "c"})
+		tree.Set("servers", []any{first, second})
+		return tree
+	}
+
+	tests := []struct {
+		name     string
+		segments []string
+		path     []string
+		want     any
+	}{
+		{"map key", []string{"flags", "exp_a"}, []string{"flags"}, []string{"keep", "exp_b"}},
+		{"array element", []string{"list", "1"}, []string{"list"}, []any{"a", "c"}},
+		{"every array element", []string{"list", "*"}, []string{"list"}, []any{}},
+		{"key in every element", []string{"servers", "*", "debug"}, []string{"servers", "0"}, []string{"name"}},
+		{"recursive wildcard", []string{"**", "exp_b"}, []string{"flags"}, []string{"exp_a", "keep"}},
+		{"missing path", []string{"flags", "missing"}, []string{"flags"}, []string{"exp_a", "keep", "exp_b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := newTree()
+			if err := DeleteMatches(tree, tt.segments); err != nil {
+				t.Fatalf("DeleteMatches() error = %v", err)
+			}
+
+			var got any = tree
+			for _, key := range tt.path {
+				if arr, ok := got.([]any); ok {
+					i, _ := ArrayIndex(key, len(arr))
+					got = arr[i]
+					continue
+				}
+				got, _ = ToOrderedMapPtr(got).Get(key)
+			}
+			if om := ToOrderedMapPtr(got); om != nil {
+				got = om.Keys()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("after DeleteMatches(%q), %q = %#v, want %#v", tt.segments, tt.path, got, tt.want)
+			}
+		})
+	}
+
+	if err := DeleteMatches([]any{"a", "b"}, []string{"0"}); err == nil {
+		t.Error("DeleteMatches() should refuse to delete a root array element")
+	}
+}
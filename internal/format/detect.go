@@ -0,0 +1,218 @@
+package format
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// extensionFormats maps file extensions to format names.
+var extensionFormats = map[string]string{
+	".json":       "json",
+	".jsonc":      "json",
+	".toml":       "toml",
+	".ini":        "ini",
+	".yaml":       "yaml",
+	".yml":        "yaml",
+	".env":        "dotenv",
+	".properties": "properties",
+	".plist":      "plist",
+	".hcl":        "hcl",
+	".tf":         "hcl",
+	".service":    "systemd",
+	".socket":     "systemd",
+	".timer":      "systemd",
+	".mount":      "systemd",
+	".automount":  "systemd",
+	".path":       "systemd",
+	".slice":      "systemd",
+	".desktop":    "desktop",
+	".reg":        "reg",
+	".kdl":        "kdl",
+	".lua":        "lua",
+	".jsonl":      "jsonl",
+	".ndjson":     "jsonl",
+}
+
+var (
+	// sectionRegex matches a TOML/INI section header such as [server] or [[servers]].
+	sectionRegex = regexp.MustCompile(`^\[\[?[A-Za-z0-9_.\- ][A-Za-z0-9_.\-"' ]*\]\]?$`)
+	// keyValueRegex matches key = value lines, capturing the value.
+	keyValueRegex = regexp.MustCompile(`^[A-Za-z0-9_.\-"' ]+=\s*(.*)$`)
+	// yamlKeyRegex matches YAML mapping keys such as "key:" or "key: value".
+	yamlKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_.\-"']+:(\s|$)`)
+	// tomlValueRegex matches values that are valid TOML literals.
+	tomlValueRegex = regexp.MustCompile(`^("|'|\[|\{|true$|false$|[+-]?(\d|inf$|nan$))`)
+	// unitSectionRegex matches the section header that starts a systemd unit.
+	unitSectionRegex = regexp.MustCompile(`^\[(Unit|Service|Socket|Timer|Mount|Automount|Path|Slice|Install)\]$`)
+	// desktopSectionRegex matches the section header that starts a desktop entry.
+	desktopSectionRegex = regexp.MustCompile(`^\[Desktop (Entry|Action [^\]]+)\]$`)
+	// regHeaderRegex matches the header line of a Windows registry export.
+	regHeaderRegex = regexp.MustCompile(`^(Windows Registry Editor Version 5\.00|REGEDIT4)$`)
+	// nginxDirectiveRegex matches a directive of nginx's main context, ended
+	// by ";" or opening a block.
+	nginxDirectiveRegex = regexp.MustCompile(`^(user|worker_processes|pid|error_log|events|http|stream|load_module|upstream|server)(\s.*)?[;{]$`)
+	// luaReturnRegex matches the "return {" that starts a Lua table config.
+	luaReturnRegex = regexp.MustCompile(`^return\s*\{`)
+	// sshBlockRegex matches an ssh_config Host or Match line.
+	sshBlockRegex = regexp.MustCompile(`(?i)^(host|match)(\s+|\s*=\s*)\S`)
+)
+
+// Detect guesses the format of a config from its file name and content.
+// A recognized file extension wins (a chezmoi source name's ".tmpl" suffix
+// is ignored, so modify_settings.toml.tmpl is toml), then a file name that only one format
+// uses (an ssh client config, nginx.conf or a file in an nginx directory);
+// otherwise the content is sniffed:
+//   - chezmoi plaintext markers ⇒ plaintext
+//   - a whole document that is valid JSON ⇒ json, so a one-line array such
+//     as [1] or [ "a" ] isn't mistaken for a section header
+//   - a <plist> element ⇒ plist
+//   - a first line of "Windows Registry Editor Version 5.00" ⇒ reg
+//   - a first line that is an nginx main-context directive (worker_processes
+//     1;, http {, ...) ⇒ nginx
+//   - a first line of "return {" ⇒ lua
+//   - a first line of Host or Match ⇒ sshconfig
+//   - a first section of [Unit], [Service], ... ⇒ systemd
+//   - a first section of [Desktop Entry] ⇒ desktop
+//   - leading { or a JSON array ⇒ json
+//   - [section] headers or key = value lines ⇒ toml if every value is a TOML literal, else ini
+//   - key: value, "- item", or "---" ⇒ yaml
+//
+// Anything else is treated as plaintext.
+func Detect(content []byte, filename string) string {
+	ext := filepath.Ext(strings.TrimSuffix(filename, ".tmpl"))
+	if f, ok := extensionFormats[strings.ToLower(ext)]; ok {
+		return f
+	}
+	if isSSHConfigName(filename) {
+		return "sshconfig"
+	}
+	if isNginxConfigName(filename) {
+		return "nginx"
+	}
+	return detectContent(string(content))
+}
+
+// isSSHConfigName reports whether filename is an ssh client config: a file
+// named config in a .ssh directory, as a target or as a chezmoi source
+// ("private_dot_ssh/modify_private_config.tmpl").
+func isSSHConfigName(filename string) bool {
+	dir := filepath.Base(filepath.Dir(filename))
+	if dir != ".ssh" && !strings.HasSuffix(dir, "dot_ssh") {
+		return false
+	}
+	base := strings.TrimSuffix(filepath.Base(filename), ".tmpl")
+	return base == "config" || strings.HasSuffix(base, "_config")
+}
+
+// isNginxConfigName reports whether filename is an nginx config: nginx.conf,
+// or a .conf file in an nginx directory ("nginx/sites-available/app.conf"),
+// as a target or as a chezmoi source ("dot_config/nginx/modify_nginx.conf").
+func isNginxConfigName(filename string) bool {
+	base := strings.TrimSuffix(filepath.Base(filename), ".tmpl")
+	if base == "nginx.conf" || strings.HasSuffix(base, "_nginx.conf") {
+		return true
+	}
+	if filepath.Ext(base) != ".conf" {
+		return false
+	}
+	for _, dir := range strings.Split(filepath.ToSlash(filepath.Dir(filename)), "/") {
+		if dir == "nginx" || strings.HasSuffix(dir, "_nginx") {
+			return true
+		}
+	}
+	return false
+}
+
+// detectContent sniffs the format from content alone.
+func detectContent(content string) string {
+	if strings.Contains(content, "chezmoi:managed") ||
+		strings.Contains(content, "chezmoi:ignored") ||
+		strings.Contains(content, "chezmoi:end") {
+		return "plaintext"
+	}
+	// Checked before the line heuristics: when they disagree with a
+	// successful JSON parse, JSON is the likelier reading
+	if trimmed := strings.TrimSpace(content); (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)) {
+		return "json"
+	}
+	if strings.Contains(content, "<plist") {
+		return "plist"
+	}
+	if firstSignificantLine(content, regHeaderRegex) {
+		return "reg"
+	}
+	if firstSignificantLine(content, nginxDirectiveRegex) {
+		return "nginx"
+	}
+	if firstSignificantLine(content, luaReturnRegex) {
+		return "lua"
+	}
+	if firstSignificantLine(content, sshBlockRegex) {
+		return "sshconfig"
+	}
+	if firstSignificantLine(content, unitSectionRegex) {
+		return "systemd"
+	}
+	if firstSignificantLine(content, desktopSectionRegex) {
+		return "desktop"
+	}
+
+	sawSection := false
+	sawKeyValue := false
+	allTOMLValues := true
+
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || isCommentLine(trimmed) {
+			continue
+		}
+
+		switch {
+		case sectionRegex.MatchString(trimmed):
+			sawSection = true
+		case strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "["):
+			// Only the first significant line decides JSON
+			if !sawSection && !sawKeyValue {
+				return "json"
+			}
+		case keyValueRegex.MatchString(trimmed):
+			sawKeyValue = true
+			value := strings.TrimSpace(keyValueRegex.FindStringSubmatch(trimmed)[1])
+			if !tomlValueRegex.MatchString(value) {
+				allTOMLValues = false
+			}
+		case !sawSection && !sawKeyValue && (yamlKeyRegex.MatchString(trimmed) ||
+			strings.HasPrefix(trimmed, "- ") || trimmed == "---"):
+			return "yaml"
+		}
+	}
+
+	switch {
+	case sawKeyValue && allTOMLValues:
+		return "toml"
+	case sawSection || sawKeyValue:
+		return "ini"
+	default:
+		return "plaintext"
+	}
+}
+
+// firstSignificantLine reports whether the first line of content that isn't
+// blank or a comment matches re.
+func firstSignificantLine(content string, re *regexp.Regexp) bool {
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || isCommentLine(trimmed) {
+			continue
+		}
+		return re.MatchString(trimmed)
+	}
+	return false
+}
+
+// isCommentLine reports whether a trimmed line is a comment in any supported format.
+func isCommentLine(line string) bool {
+	return strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "//")
+}
@@ -0,0 +1,268 @@
+package format
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		filename string
+		want     string
+	}{
+		{
+			name:    "json object",
+			content: "{\n  \"key\": \"value\"\n}",
+			want:    "json",
+		},
+		{
+			name:    "json array",
+			content: "[\n  1,\n  2\n]",
+			want:    "json",
+		},
+		{
+			name:    "json after comment header",
+			content: "// settings\n{\"key\": true}",
+			want:    "json",
+		},
+		{
+			name:    "one-line json array that looks like a section",
+			content: "[ \"a\" ]",
+			want:    "json",
+		},
+		{
+			name:    "one-line json array of a number",
+			content: "[1]\n",
+			want:    "json",
+		},
+		{
+			name:    "json string holding a plist",
+			content: "{\"doc\": \"<plist version=\\\"1.0\\\"/>\"}",
+			want:    "json",
+		},
+		{
+			name:    "section that isn't json",
+			content: "[a]\n",
+			want:    "ini",
+		},
+		{
+			name:    "toml with typed values",
+			content: "title = \"example\"\n\n[server]\nport = 8080\nenabled = true",
+			want:    "toml",
+		},
+		{
+			name:    "toml array of tables",
+			content: "[[servers]]\nname = \"a\"",
+			want:    "toml",
+		},
+		{
+			name:    "ini with bare values",
+			content: "[database]\nhost = localhost\nport = 3306",
+			want:    "ini",
+		},
+		{
+			name:    "ini with semicolon comments",
+			content: "; comment\n[section]\nkey = some value",
+			want:    "ini",
+		},
+		{
+			name:    "yaml mapping",
+			content: "window:\n  opacity: 0.9",
+			want:    "yaml",
+		},
+		{
+			name:    "yaml document marker",
+			content: "---\n- a\n- b",
+			want:    "yaml",
+		},
+		{
+			name:    "xml plist",
+			content: "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<plist version=\"1.0\">\n<dict/>\n</plist>",
+			want:    "plist",
+		},
+		{
+			name:    "plaintext markers",
+			content: "# chezmoi:managed\nexport FOO=bar\n# chezmoi:end",
+			want:    "plaintext",
+		},
+		{
+			name:    "unrecognized content",
+			content: "set number\nset expandtab",
+			want:    "plaintext",
+		},
+		{
+			name:     "extension wins over content",
+			content:  "key = value",
+			filename: "config.toml",
+			want:     "toml",
+		},
+		{
+			name:     "toml template source",
+			content:  `{ "not": "json" }`,
+			filename: "dot_config/app/modify_settings.toml.tmpl",
+			want:     "toml",
+		},
+		{
+			name:     "ini template source",
+			content:  "key = 1",
+			filename: "modify_app.ini.tmpl",
+			want:     "ini",
+		},
+		{
+			name:     "json template source",
+			content:  "[section]\nkey = 1",
+			filename: "dot_config/zed/modify_private_settings.json.tmpl",
+			want:     "json",
+		},
+		{
+			name:     "template source without a known extension is sniffed",
+			content:  "[section]\nkey = \"value\"",
+			filename: "modify_app.conf.tmpl",
+			want:     "toml",
+		},
+		{
+			name:     "yml extension",
+			content:  "",
+			filename: "/home/me/.config/app/config.YML",
+			want:     "yaml",
+		},
+		{
+			name:     "dotenv file",
+			content:  "KEY=value",
+			filename: ".env",
+			want:     "dotenv",
+		},
+		{
+			name:     "properties file",
+			content:  "org.gradle.parallel=true",
+			filename: "gradle.properties",
+			want:     "properties",
+		},
+		{
+			name:     "terraform extension",
+			content:  "provider \"aws\" {\n}",
+			filename: "main.tf",
+			want:     "hcl",
+		},
+		{
+			name:     "ssh config target",
+			content:  "AddKeysToAgent yes",
+			filename: "/home/me/.ssh/config",
+			want:     "sshconfig",
+		},
+		{
+			name:     "ssh config chezmoi source",
+			content:  "",
+			filename: "private_dot_ssh/modify_private_config.tmpl",
+			want:     "sshconfig",
+		},
+		{
+			name:    "ssh config content",
+			content: "# generated\nHost github.com\n    User git",
+			want:    "sshconfig",
+		},
+		{
+			name:     "systemd unit extension",
+			content:  "[Unit]\nDescription=x",
+			filename: "app.service",
+			want:     "systemd",
+		},
+		{
+			name:    "systemd unit content",
+			content: "# app\n[Unit]\nDescription=App\n\n[Service]\nExecStart=/usr/bin/app",
+			want:    "systemd",
+		},
+		{
+			name:     "desktop entry extension",
+			content:  "",
+			filename: "org.gnome.Nautilus.desktop",
+			want:     "desktop",
+		},
+		{
+			name:    "desktop entry content",
+			content: "[Desktop Entry]\nType=Application\nName=Files",
+			want:    "desktop",
+		},
+		{
+			name:     "registry export extension",
+			content:  "",
+			filename: "modify_tweaks.reg",
+			want:     "reg",
+		},
+		{
+			name:    "registry export content",
+			content: "Windows Registry Editor Version 5.00\n\n[HKEY_CURRENT_USER\\Software\\App]\n\"Name\"=\"x\"",
+			want:    "reg",
+		},
+		{
+			name:     "nginx.conf",
+			content:  "",
+			filename: "dot_config/nginx/modify_nginx.conf.tmpl",
+			want:     "nginx",
+		},
+		{
+			name:     "conf file in an nginx directory",
+			content:  "",
+			filename: "/etc/nginx/sites-available/app.conf",
+			want:     "nginx",
+		},
+		{
+			name:    "nginx content",
+			content: "# Main\nworker_processes auto;\n\nhttp {\n    gzip on;\n}",
+			want:    "nginx",
+		},
+		{
+			name:    "nginx server block content",
+			content: "server {\n    listen 80;\n}",
+			want:    "nginx",
+		},
+		{
+			name:     "kdl extension",
+			content:  "",
+			filename: "dot_config/zellij/modify_config.kdl",
+			want:     "kdl",
+		},
+		{
+			name:     "lua extension",
+			content:  "",
+			filename: "dot_config/nvim/lua/plugins/modify_telescope.lua",
+			want:     "lua",
+		},
+		{
+			name:     "jsonl extension",
+			content:  "{\"id\":1}\n{\"id\":2}\n",
+			filename: "dot_local/state/app/modify_history.jsonl",
+			want:     "jsonl",
+		},
+		{
+			name:     "ndjson extension",
+			content:  "",
+			filename: "modify_events.ndjson",
+			want:     "jsonl",
+		},
+		{
+			name:    "lua content",
+			content: "return {\n  number = true,\n}",
+			want:    "lua",
+		},
+		{
+			name:     "config outside .ssh",
+			content:  "set number",
+			filename: "/home/me/.vim/config",
+			want:     "plaintext",
+		},
+		{
+			name:     "unknown extension falls back to content",
+			content:  "{}",
+			filename: "settings.conf",
+			want:     "json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect([]byte(tt.content), tt.filename); got != tt.want {
+				t.Errorf("Detect() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+// Package dotenv provides a handler for .env files for chezmoi-split.
+package dotenv
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// style records how a variable was written so Serialize can reproduce it.
+type style struct {
+	export bool // Line had an "export " prefix
+	quote  byte // '"', '\'', or 0 for unquoted
+}
+
+// Handler implements format.Handler for .env files.
+//
+// The tree is a flat *orderedmap.OrderedMap of variable name to string value.
+// The handler remembers each variable's quoting style and export prefix from
+// the first document that defines it, so parsing managed before current means
+// managed lines keep the template's style and preserved app-only lines keep
+// theirs.
+type Handler struct {
+	styles map[string]style
+}
+
+// New creates a new dotenv handler.
+func New() *Handler {
+	return &Handler{styles: make(map[string]style)}
+}
+
+// keyRegex matches valid variable names.
+var keyRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// Parse reads KEY=VALUE lines and returns an *orderedmap.OrderedMap.
+// Blank lines and # comments are skipped, an "export " prefix is accepted,
+// and single- or double-quoted values are unquoted.
+func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
+	if opts.StripComments {
+		return nil, fmt.Errorf("strip-comments is not supported for dotenv format")
+	}
+
+	result := orderedmap.New()
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var st style
+		if rest, ok := strings.CutPrefix(line, "export "); ok {
+			st.export = true
+			line = strings.TrimSpace(rest)
+		}
+
+		key, rawValue, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("failed to parse dotenv: line %d: expected KEY=VALUE, got %q", lineNum, line)
+		}
+		key = strings.TrimSpace(key)
+		if !keyRegex.MatchString(key) {
+			return nil, fmt.Errorf("failed to parse dotenv: line %d: invalid variable name %q", lineNum, key)
+		}
+
+		value, quote, err := parseValue(strings.TrimSpace(rawValue))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse dotenv: line %d: %w", lineNum, err)
+		}
+		st.quote = quote
+
+		result.Set(key, value)
+		if _, seen := h.styles[key]; !seen {
+			h.styles[key] = st
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse dotenv: %w", err)
+	}
+
+	return result, nil
+}
+
+// parseValue unquotes a raw value and reports the quote character used.
+func parseValue(raw string) (string, byte, error) {
+	if raw == "" {
+		return "", 0, nil
+	}
+
+	switch raw[0] {
+	case '\'':
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return "", 0, fmt.Errorf("unterminated single-quoted value")
+		}
+		return raw[1 : end+1], '\'', nil
+
+	case '"':
+		var sb strings.Builder
+		for i := 1; i < len(raw); i++ {
+			c := raw[i]
+			switch {
+			case c == '\\' && i+1 < len(raw):
+				i++
+				switch raw[i] {
+				case 'n':
+					sb.WriteByte('\n')
+				case 't':
+					sb.WriteByte('\t')
+				default:
+					sb.WriteByte(raw[i])
+				}
+			case c == '"':
+				return sb.String(), '"', nil
+			default:
+				sb.WriteByte(c)
+			}
+		}
+		return "", 0, fmt.Errorf("unterminated double-quoted value")
+
+	default:
+		// Unquoted: an inline comment starts at " #"
+		if idx := strings.Index(raw, " #"); idx >= 0 {
+			raw = strings.TrimSpace(raw[:idx])
+		}
+		return raw, 0, nil
+	}
+}
+
+// Serialize writes the tree as KEY=VALUE lines in key order, reproducing each
+// variable's recorded export prefix and quoting style.
+func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, error) {
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return nil, fmt.Errorf("tree is not an ordered map")
+	}
+
+	var buf bytes.Buffer
+	for _, key := range om.Keys() {
+		val, _ := om.Get(key)
+		st := h.styles[key]
+		if st.export {
+			buf.WriteString("export ")
+		}
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		buf.WriteString(formatValue(toString(val), st.quote))
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes(), nil
+}
+
+// formatValue quotes a value using the requested style, upgrading to double
+// quotes when the value can't be represented otherwise.
+func formatValue(value string, quote byte) string {
+	if quote == '\'' && !strings.ContainsAny(value, "'\n") {
+		return "'" + value + "'"
+	}
+	if quote == 0 && !needsQuoting(value) {
+		return value
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// needsQuoting reports whether an unquoted value would be misread.
+func needsQuoting(value string) bool {
+	if value != strings.TrimSpace(value) {
+		return true
+	}
+	return strings.ContainsAny(value, " \t\n#'\"\\")
+}
+
+// toString converts any value to its string representation.
+// Environment variables only hold strings.
+func toString(v any) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// GetPath extracts a variable's value. Paths must be a single segment
+// (the variable name) or "*" for the first variable.
+func (h *Handler) GetPath(tree any, p path.Path) (any, bool) {
+	segments := p.Segments()
+	if len(segments) != 1 {
+		return nil, false
+	}
+
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return nil, false
+	}
+
+	if segments[0] == "*" {
+		for _, key := range om.Keys() {
+			val, _ := om.Get(key)
+			return val, true
+		}
+		return nil, false
+	}
+
+	return om.Get(segments[0])
+}
+
+// SetPath sets a variable's value. Paths must be a single segment
+// (the variable name) or "*" to set every variable.
+func (h *Handler) SetPath(tree any, p path.Path, value any) error {
+	segments := p.Segments()
+	if len(segments) != 1 {
+		return fmt.Errorf("dotenv paths must have exactly 1 segment (the variable name), got %d", len(segments))
+	}
+
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return fmt.Errorf("tree is not an ordered map")
+	}
+
+	value, err := format.NormalizeForFormat(value, "dotenv")
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %w", p, err)
+	}
+
+	strVal := toString(value)
+	if segments[0] == "*" {
+		for _, key := range om.Keys() {
+			om.Set(key, strVal)
+		}
+		return nil
+	}
+
+	om.Set(segments[0], strVal)
+	return nil
+}
+
+// DeletePath removes a variable. Paths must be a single segment (the
+// variable name) or "*" to delete every variable.
+func (h *Handler) DeletePath(tree any, p path.Path) error {
+	segments := p.Segments()
+	if len(segments) != 1 {
+		return fmt.Errorf("dotenv paths must have exactly 1 segment (the variable name), got %d", len(segments))
+	}
+	return format.DeleteMatches(tree, segments)
+}
+
+// Ensure Handler implements format.Handler.
+var _ format.Handler = (*Handler)(nil)
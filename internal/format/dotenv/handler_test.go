@@ -0,0 +1,177 @@
+package dotenv
+
+import (
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/formattest"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+func TestHandler_Parse(t *testing.T) {
+	h := New()
+
+	input := `# Database settings
+DATABASE_URL=postgres://localhost/app
+export API_TOKEN="abc 123"
+SINGLE='literal $HOME'
+ESCAPED="line1\nline2 \"quoted\""
+INLINE=value # trailing comment
+EMPTY=
+`
+
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	om := tree.(*orderedmap.OrderedMap)
+	want := []struct {
+		key   string
+		value string
+	}{
+		{"DATABASE_URL", "postgres://localhost/app"},
+		{"API_TOKEN", "abc 123"},
+		{"SINGLE", "literal $HOME"},
+		{"ESCAPED", "line1\nline2 \"quoted\""},
+		{"INLINE", "value"},
+		{"EMPTY", ""},
+	}
+
+	keys := om.Keys()
+	if len(keys) != len(want) {
+		t.Fatalf("Parse() got keys %v, want %d keys", keys, len(want))
+	}
+	for i, w := range want {
+		if keys[i] != w.key {
+			t.Errorf("key[%d] = %q, want %q", i, keys[i], w.key)
+		}
+		if got, _ := om.Get(w.key); got != w.value {
+			t.Errorf("%s = %q, want %q", w.key, got, w.value)
+		}
+	}
+}
+
+func TestHandler_Parse_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"missing equals", "JUST_A_WORD\n"},
+		{"invalid name", "1BAD=value\n"},
+		{"unterminated double quote", "KEY=\"open\n"},
+		{"unterminated single quote", "KEY='open\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New().Parse([]byte(tt.input), format.ParseOptions{}); err == nil {
+				t.Error("Parse() expected error")
+			}
+		})
+	}
+}
+
+func TestHandler_Parse_StripCommentsError(t *testing.T) {
+	_, err := New().Parse([]byte("KEY=value\n"), format.ParseOptions{StripComments: true})
+	if err == nil {
+		t.Error("Parse() with StripComments should return error for dotenv")
+	}
+}
+
+func TestHandler_RoundTrip_PreservesStyle(t *testing.T) {
+	h := New()
+
+	input := `PLAIN=value
+export EXPORTED=value
+DOUBLE="two words"
+SINGLE='single'
+`
+
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	if string(data) != input {
+		t.Errorf("Serialize() = %q, want %q", string(data), input)
+	}
+}
+
+func TestHandler_Serialize_QuotesWhenNeeded(t *testing.T) {
+	h := New()
+
+	tree := orderedmap.New()
+	tree.Set("SPACES", "has spaces")
+	tree.Set("HASH", "a#b")
+	tree.Set("NUMBER", 42)
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	want := "SPACES=\"has spaces\"\nHASH=\"a#b\"\nNUMBER=42\n"
+	if string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", string(data), want)
+	}
+}
+
+func TestHandler_ManagedStyleWins(t *testing.T) {
+	h := New()
+
+	// Managed is parsed first, so its style applies to shared keys;
+	// keys only in current keep the current style.
+	managed, _ := h.Parse([]byte("export TOKEN=\"managed\"\n"), format.ParseOptions{})
+	current, _ := h.Parse([]byte("TOKEN=current\nexport EXTRA='x'\n"), format.ParseOptions{})
+
+	token, _ := h.GetPath(current, path.NewArrayPath([]string{"TOKEN"}))
+	extra, _ := h.GetPath(current, path.NewArrayPath([]string{"EXTRA"}))
+	_ = h.SetPath(managed, path.NewArrayPath([]string{"TOKEN"}), token)
+	_ = h.SetPath(managed, path.NewArrayPath([]string{"EXTRA"}), extra)
+
+	data, err := h.Serialize(managed, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	want := "export TOKEN=\"current\"\nexport EXTRA='x'\n"
+	if string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", string(data), want)
+	}
+}
+
+func TestHandler_PathDepth(t *testing.T) {
+	h := New()
+	tree := orderedmap.New()
+	tree.Set("KEY", "value")
+
+	if _, ok := h.GetPath(tree, path.NewArrayPath([]string{"KEY", "nested"})); ok {
+		t.Error("GetPath() should reject multi-segment paths")
+	}
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"KEY", "nested"}), "x"); err == nil {
+		t.Error("SetPath() should reject multi-segment paths")
+	}
+}
+
+func TestHandler_Conformance(t *testing.T) {
+	formattest.RunConformance(t, New(), formattest.Fixtures{
+		Document: `ZEBRA=z
+export TOKEN="secret value"
+APPLE=a
+`,
+		RoundTripExact:  true,
+		OrderedKeys:     []string{"ZEBRA", "TOKEN", "APPLE"},
+		LeafPath:        []string{"TOKEN"},
+		LeafValue:       "secret value",
+		WildcardPath:    []string{"*"},
+		WildcardMatches: [][]string{{"ZEBRA"}, {"TOKEN"}, {"APPLE"}},
+		DeepPath:        []string{"NEW_KEY"},
+	})
+}
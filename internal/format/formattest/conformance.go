@@ -0,0 +1,242 @@
+// Package formattest holds the conformance battery that every format
+// handler's tests run.
+package formattest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// Fixtures describes a handler-provided document and the paths within it that
+// the conformance battery exercises. Fields left empty skip the related checks.
+type Fixtures struct {
+	// Document is a representative document in the handler's format.
+	Document string
+
+	// ParseOptions are passed to every Parse call.
+	ParseOptions format.ParseOptions
+
+	// RoundTripExact requires Serialize(Parse(Document)) to equal Document byte-for-byte.
+	RoundTripExact bool
+
+	// OrderedKeys lists the top-level keys of Document in document order.
+	// Set only for handlers that claim to preserve key order.
+	OrderedKeys []string
+
+	// LeafPath addresses an existing scalar in Document, whose value is LeafValue.
+	LeafPath  []string
+	LeafValue any
+
+	// WildcardPath contains a "*" segment; WildcardMatches lists every concrete
+	// path in Document that it matches.
+	WildcardPath    []string
+	WildcardMatches [][]string
+
+	// DeepPath does not exist in Document; SetPath must create it.
+	DeepPath []string
+
+	// Unsupported marks handlers that do not support path access at all
+	// (GetPath always misses, SetPath always errors).
+	Unsupported bool
+}
+
+// RunConformance runs the canonical handler battery against h:
+// round-trip, order preservation, leaf and wildcard get/set/delete, deep
+// creation, empty and missing paths, and navigation through non-map values.
+func RunConformance(t *testing.T, h format.Handler, fx Fixtures) {
+	t.Helper()
+
+	parse := func(t *testing.T) any {
+		t.Helper()
+		tree, err := h.Parse([]byte(fx.Document), fx.ParseOptions)
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		return tree
+	}
+
+	t.Run("round-trip", func(t *testing.T) {
+		first, err := h.Serialize(parse(t), format.SerializeOptions{})
+		if err != nil {
+			t.Fatalf("Serialize() error = %v", err)
+		}
+		if fx.RoundTripExact && string(first) != fx.Document {
+			t.Errorf("Serialize() = %q, want %q", first, fx.Document)
+		}
+
+		reparsed, err := h.Parse(first, fx.ParseOptions)
+		if err != nil {
+			t.Fatalf("re-Parse() of serialized output error = %v\n%s", err, first)
+		}
+		second, err := h.Serialize(reparsed, format.SerializeOptions{})
+		if err != nil {
+			t.Fatalf("second Serialize() error = %v", err)
+		}
+		if string(first) != string(second) {
+			t.Errorf("serialization is not stable:\nfirst:\n%s\nsecond:\n%s", first, second)
+		}
+	})
+
+	if fx.OrderedKeys != nil {
+		t.Run("order", func(t *testing.T) {
+			om := format.ToOrderedMapPtr(parse(t))
+			if om == nil {
+				t.Fatal("Parse() did not return an ordered map")
+			}
+			if !reflect.DeepEqual(om.Keys(), fx.OrderedKeys) {
+				t.Errorf("keys = %v, want %v", om.Keys(), fx.OrderedKeys)
+			}
+		})
+	}
+
+	if fx.Unsupported {
+		t.Run("unsupported", func(t *testing.T) {
+			tree := parse(t)
+			if _, ok := h.GetPath(tree, path.NewArrayPath([]string{"any"})); ok {
+				t.Error("GetPath() should not find anything for a handler without path support")
+			}
+			if err := h.SetPath(tree, path.NewArrayPath([]string{"any"}), "x"); err == nil {
+				t.Error("SetPath() should return an error for a handler without path support")
+			}
+			if err := h.DeletePath(tree, path.NewArrayPath([]string{"any"})); err == nil {
+				t.Error("DeletePath() should return an error for a handler without path support")
+			}
+		})
+		return
+	}
+
+	t.Run("missing path", func(t *testing.T) {
+		tree := parse(t)
+		p := path.NewArrayPath([]string{"__chezmoi_split_missing__"})
+		if _, ok := h.GetPath(tree, p); ok {
+			t.Error("GetPath() found a key that does not exist")
+		}
+		before, _ := h.Serialize(tree, format.SerializeOptions{})
+		if err := h.DeletePath(tree, p); err != nil {
+			t.Errorf("DeletePath() of a missing path error = %v", err)
+		}
+		if after, _ := h.Serialize(tree, format.SerializeOptions{}); string(after) != string(before) {
+			t.Errorf("DeletePath() of a missing path changed the document:\n%s", after)
+		}
+	})
+
+	t.Run("empty path", func(t *testing.T) {
+		tree := parse(t)
+		// GetPath may or may not resolve the root, but it must not panic
+		h.GetPath(tree, path.NewArrayPath(nil))
+		if err := h.SetPath(tree, path.NewArrayPath(nil), "x"); err == nil {
+			t.Error("SetPath() with an empty path should return an error")
+		}
+		if err := h.DeletePath(tree, path.NewArrayPath(nil)); err == nil {
+			t.Error("DeletePath() with an empty path should return an error")
+		}
+	})
+
+	if fx.LeafPath != nil {
+		t.Run("leaf get/set", func(t *testing.T) {
+			tree := parse(t)
+			p := path.NewArrayPath(fx.LeafPath)
+
+			got, ok := h.GetPath(tree, p)
+			if !ok {
+				t.Fatalf("GetPath(%s) not found", p)
+			}
+			if !reflect.DeepEqual(got, fx.LeafValue) {
+				t.Errorf("GetPath(%s) = %#v, want %#v", p, got, fx.LeafValue)
+			}
+
+			if err := h.SetPath(tree, p, "conformance"); err != nil {
+				t.Fatalf("SetPath(%s) error = %v", p, err)
+			}
+			if got, _ := h.GetPath(tree, p); got != "conformance" {
+				t.Errorf("after SetPath(%s), GetPath() = %#v, want %q", p, got, "conformance")
+			}
+		})
+
+		t.Run("leaf delete", func(t *testing.T) {
+			tree := parse(t)
+			p := path.NewArrayPath(fx.LeafPath)
+
+			if err := h.DeletePath(tree, p); err != nil {
+				t.Fatalf("DeletePath(%s) error = %v", p, err)
+			}
+			if got, ok := h.GetPath(tree, p); ok {
+				t.Errorf("after DeletePath(%s), GetPath() = %#v, want not found", p, got)
+			}
+			if _, err := h.Serialize(tree, format.SerializeOptions{}); err != nil {
+				t.Errorf("Serialize() after DeletePath error = %v", err)
+			}
+		})
+
+		t.Run("non-map navigation", func(t *testing.T) {
+			tree := parse(t)
+			through := append(append([]string{}, fx.LeafPath...), "child")
+			p := path.NewArrayPath(through)
+			if _, ok := h.GetPath(tree, p); ok {
+				t.Errorf("GetPath(%s) should not navigate through a scalar", p)
+			}
+			if err := h.SetPath(tree, p, "x"); err == nil {
+				t.Errorf("SetPath(%s) should return an error when navigating through a scalar", p)
+			}
+		})
+	}
+
+	if fx.WildcardPath != nil {
+		t.Run("wildcard get/set", func(t *testing.T) {
+			tree := parse(t)
+			p := path.NewArrayPath(fx.WildcardPath)
+
+			if _, ok := h.GetPath(tree, p); !ok {
+				t.Fatalf("GetPath(%s) found no match", p)
+			}
+
+			if err := h.SetPath(tree, p, "conformance"); err != nil {
+				t.Fatalf("SetPath(%s) error = %v", p, err)
+			}
+			for _, match := range fx.WildcardMatches {
+				mp := path.NewArrayPath(match)
+				if got, _ := h.GetPath(tree, mp); got != "conformance" {
+					t.Errorf("after wildcard SetPath, GetPath(%s) = %#v, want %q", mp, got, "conformance")
+				}
+			}
+		})
+
+		t.Run("wildcard delete", func(t *testing.T) {
+			tree := parse(t)
+			p := path.NewArrayPath(fx.WildcardPath)
+
+			if err := h.DeletePath(tree, p); err != nil {
+				t.Fatalf("DeletePath(%s) error = %v", p, err)
+			}
+			for _, match := range fx.WildcardMatches {
+				mp := path.NewArrayPath(match)
+				if got, ok := h.GetPath(tree, mp); ok {
+					t.Errorf("after wildcard DeletePath, GetPath(%s) = %#v, want not found", mp, got)
+				}
+			}
+		})
+	}
+
+	if fx.DeepPath != nil {
+		t.Run("deep creation", func(t *testing.T) {
+			tree := parse(t)
+			p := path.NewArrayPath(fx.DeepPath)
+
+			if _, ok := h.GetPath(tree, p); ok {
+				t.Fatalf("DeepPath %s already exists in the fixture document", p)
+			}
+			if err := h.SetPath(tree, p, "conformance"); err != nil {
+				t.Fatalf("SetPath(%s) error = %v", p, err)
+			}
+			if got, _ := h.GetPath(tree, p); got != "conformance" {
+				t.Errorf("GetPath(%s) = %#v, want %q", p, got, "conformance")
+			}
+			if _, err := h.Serialize(tree, format.SerializeOptions{}); err != nil {
+				t.Errorf("Serialize() after deep creation error = %v", err)
+			}
+		})
+	}
+}
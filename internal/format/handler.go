@@ -5,12 +5,24 @@ import "github.com/thirteen37/chezmoi-split/internal/path"
 
 // ParseOptions configures parsing behavior.
 type ParseOptions struct {
-	StripComments bool // Strip comments (for JSON/JSONC)
+	StripComments bool   // Strip comments (for JSON/JSONC)
+	JSON5         bool   // Accept JSON5 comments, quoting, and trailing commas (for JSON)
+	KeepComments  bool   // Strip comments, remembering those above keys for Serialize (for JSON)
+	MatchKey      string // Record field that identifies a JSON Lines record; empty means "id"
+	StrictKeys    bool   // Fail on a key repeated in an object instead of warning (for JSON)
 }
 
 // SerializeOptions configures serialization behavior.
 type SerializeOptions struct {
-	Indent string // Indentation string (e.g., "  " or "\t")
+	Indent  string // Indentation string (e.g., "  " or "\t")
+	Compact bool   // Write everything on one line with no indentation, like json.Marshal (JSON)
+
+	OmitFinalNewline       bool // Do not end the output with a newline
+	OmitMarkers            bool // Drop chezmoi block marker lines (plaintext)
+	TrimTrailingWhitespace bool // Strip trailing spaces and tabs from each line (plaintext)
+
+	BoolStyle string // Word for true written for booleans: "true" (default), "yes", or "on" (INI, YAML)
+	NullStyle string // "null" (default) writes nulls, "omit" drops map entries holding null (JSON, YAML)
 }
 
 // Handler defines the interface for configuration file format handlers.
@@ -26,4 +38,31 @@ type Handler interface {
 
 	// SetPath sets a value at the given path.
 	SetPath(tree any, p path.Path, value any) error
+
+	// DeletePath removes the value at the given path. A path that doesn't
+	// exist is not an error.
+	DeletePath(tree any, p path.Path) error
+}
+
+// Warner is implemented by handlers whose Parse notes problems it recovered
+// from, such as a key repeated in a JSON object.
+type Warner interface {
+	// Warnings returns the warnings from the last call to Parse.
+	Warnings() []string
+}
+
+// PathResolver is implemented by handlers that accept a second spelling of
+// some paths, such as an INI subsection written as its flat section name.
+type PathResolver interface {
+	// ResolvePath returns segments as tree spells them.
+	ResolvePath(tree any, segments []string) []string
+}
+
+// ResolvePath returns segments as handler's tree spells them, for code that
+// walks the tree itself, as wildcard expansion does.
+func ResolvePath(handler Handler, tree any, segments []string) []string {
+	if r, ok := handler.(PathResolver); ok {
+		return r.ResolvePath(tree, segments)
+	}
+	return segments
 }
@@ -0,0 +1,1059 @@
+// Package hcl provides a handler for HCL (HashiCorp Configuration Language)
+// files such as Terraform, Packer, and consul-template configs.
+package hcl
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// indentUnit is the canonical HCL indentation.
+const indentUnit = "  "
+
+// Expression is an attribute value that isn't a literal: a reference, function
+// call, operator, template with interpolations, heredoc, and so on. It holds
+// the source text, which Serialize writes back verbatim.
+type Expression string
+
+// Handler implements format.Handler for HCL files.
+//
+// Attribute values map to Go types as follows: strings → string, numbers →
+// int64 or float64, true/false → bool, null → nil, tuples → []any, objects →
+// *orderedmap.OrderedMap, anything else → Expression.
+//
+// A block becomes a nested map keyed by its type and then by each label, so
+// the region in provider "aws" { region = "x" } is at ["provider", "aws",
+// "region"]. Blocks repeated with the same type and labels become a []any of
+// bodies, addressed by index. The handler records which keys are blocks, and
+// how many labels they take, from the first document that contains them
+// (managed is parsed first), so Serialize writes them back as blocks rather
+// than object attributes.
+type Handler struct {
+	blocks map[string]int // Tree path of a block type (joined by blockPathSep) → label count
+}
+
+// blockPathSep joins tree path segments into block registry keys.
+const blockPathSep = "\x00"
+
+// New creates a new HCL handler.
+func New() *Handler {
+	return &Handler{}
+}
+
+// Parse reads HCL bytes and returns an *orderedmap.OrderedMap of the body.
+// Comments are dropped.
+func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
+	if opts.StripComments {
+		return nil, fmt.Errorf("strip-comments is not supported for HCL format")
+	}
+
+	p := &parser{src: data, blocks: make(map[string]int)}
+	result := orderedmap.New()
+	if err := p.parseBody(result, nil, false); err != nil {
+		return nil, fmt.Errorf("failed to parse HCL: %w", err)
+	}
+
+	if h.blocks == nil {
+		h.blocks = make(map[string]int)
+	}
+	for key, labels := range p.blocks {
+		if _, seen := h.blocks[key]; !seen {
+			h.blocks[key] = labels
+		}
+	}
+	return result, nil
+}
+
+// parser reads the HCL native syntax.
+type parser struct {
+	src    []byte
+	pos    int
+	blocks map[string]int // Block types seen in this document
+}
+
+// errorf returns an error annotated with the current line number.
+func (p *parser) errorf(format string, args ...any) error {
+	line := bytes.Count(p.src[:p.pos], []byte("\n")) + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// peek returns the byte at the current position, or 0 at end of input.
+func (p *parser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// hasPrefix reports whether the input at the current position starts with s.
+func (p *parser) hasPrefix(s string) bool {
+	return bytes.HasPrefix(p.src[p.pos:], []byte(s))
+}
+
+// skipSpace skips blanks and comments, and newlines too when newlines is set.
+// A line comment stops before its newline.
+func (p *parser) skipSpace(newlines bool) {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '\n' && newlines:
+			p.pos++
+		case c == '#' || p.hasPrefix("//"):
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case p.hasPrefix("/*"):
+			end := bytes.Index(p.src[p.pos+2:], []byte("*/"))
+			if end < 0 {
+				p.pos = len(p.src)
+				return
+			}
+			p.pos += end + 4
+		default:
+			return
+		}
+	}
+}
+
+// parseBody reads attributes and blocks into om until end of input, or until
+// the closing brace when inBlock is set. treePath locates om in the tree.
+func (p *parser) parseBody(om *orderedmap.OrderedMap, treePath []string, inBlock bool) error {
+	for {
+		p.skipSpace(true)
+		switch {
+		case p.pos >= len(p.src):
+			if inBlock {
+				return p.errorf("unclosed block")
+			}
+			return nil
+		case p.peek() == '}' && inBlock:
+			p.pos++
+			return nil
+		}
+
+		name := p.readIdent()
+		if name == "" {
+			return p.errorf("expected attribute or block, found %q", p.peek())
+		}
+		p.skipSpace(false)
+
+		if p.peek() == '=' && !p.hasPrefix("==") {
+			p.pos++
+			raw, err := p.readExpression(false)
+			if err != nil {
+				return err
+			}
+			if raw == "" {
+				return p.errorf("missing value for attribute %q", name)
+			}
+			if _, exists := om.Get(name); exists {
+				return p.errorf("duplicate attribute %q", name)
+			}
+			om.Set(name, literal(raw))
+
+			p.skipSpace(false)
+			if c := p.peek(); c != '\n' && c != 0 && !(c == '}' && inBlock) {
+				return p.errorf("unexpected %q after attribute %q", c, name)
+			}
+			continue
+		}
+
+		if err := p.parseBlock(om, treePath, name); err != nil {
+			return err
+		}
+	}
+}
+
+// parseBlock reads the labels and body of a block of the given type and
+// stores the body in om under the type and labels.
+func (p *parser) parseBlock(om *orderedmap.OrderedMap, treePath []string, blockType string) error {
+	var labels []string
+	for p.skipSpace(false); p.peek() != '{'; p.skipSpace(false) {
+		switch c := p.peek(); {
+		case c == '"':
+			label, ok := p.readString()
+			if !ok {
+				return p.errorf("block labels must be plain strings")
+			}
+			labels = append(labels, label)
+		case isIdentStart(c):
+			labels = append(labels, p.readIdent())
+		default:
+			return p.errorf("expected block label or '{' after %q", blockType)
+		}
+	}
+	p.pos++ // opening brace
+
+	key := strings.Join(append(append([]string{}, treePath...), blockType), blockPathSep)
+	if n, seen := p.blocks[key]; seen && n != len(labels) {
+		return p.errorf("block %q has %d labels, but an earlier one has %d", blockType, len(labels), n)
+	}
+	p.blocks[key] = len(labels)
+
+	keys := append([]string{blockType}, labels...)
+	body := orderedmap.New()
+	if err := p.parseBody(body, append(append([]string{}, treePath...), keys...), true); err != nil {
+		return err
+	}
+
+	parent := om
+	for _, k := range keys[:len(keys)-1] {
+		next, exists := parent.Get(k)
+		if !exists {
+			m := orderedmap.New()
+			parent.Set(k, m)
+			parent = m
+			continue
+		}
+		if parent = format.ToOrderedMapPtr(next); parent == nil {
+			return p.errorf("block %q conflicts with attribute %q", blockType, k)
+		}
+	}
+
+	last := keys[len(keys)-1]
+	switch existing, exists := parent.Get(last); {
+	case !exists:
+		parent.Set(last, body)
+	case format.ToOrderedMapPtr(existing) != nil:
+		parent.Set(last, []any{existing, body})
+	default:
+		repeated, ok := existing.([]any)
+		if !ok {
+			return p.errorf("block %q conflicts with attribute %q", blockType, last)
+		}
+		parent.Set(last, append(repeated, body))
+	}
+	return nil
+}
+
+// readIdent reads an identifier, returning "" if there is none.
+func (p *parser) readIdent() string {
+	if !isIdentStart(p.peek()) {
+		return ""
+	}
+	start := p.pos
+	for p.pos < len(p.src) && isIdentChar(p.src[p.pos]) {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+// readString reads a quoted string literal and decodes its escapes. It
+// reports false for strings containing interpolations or directives, which
+// are not literals.
+func (p *parser) readString() (string, bool) {
+	p.pos++ // opening quote
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return sb.String(), true
+		case c == '\n':
+			return "", false
+		case c == '\\' && p.pos+1 < len(p.src):
+			p.pos++
+			switch e := p.src[p.pos]; e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case '"', '\\':
+				sb.WriteByte(e)
+			case 'u', 'U':
+				n := 4
+				if e == 'U' {
+					n = 8
+				}
+				if p.pos+n >= len(p.src) {
+					return "", false
+				}
+				r, err := strconv.ParseUint(string(p.src[p.pos+1:p.pos+1+n]), 16, 32)
+				if err != nil {
+					return "", false
+				}
+				sb.WriteRune(rune(r))
+				p.pos += n
+			default:
+				return "", false
+			}
+			p.pos++
+		case p.hasPrefix("$${") || p.hasPrefix("%%{"):
+			sb.WriteByte(c)
+			sb.WriteByte('{')
+			p.pos += 3
+		case p.hasPrefix("${") || p.hasPrefix("%{"):
+			return "", false
+		default:
+			sb.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", false
+}
+
+// readExpression returns the source text of the expression starting at the
+// current position. The expression ends at a newline outside brackets, a
+// line comment, an unmatched closing bracket (such as the '}' closing a
+// one-line block), or, for tuple and object elements, a comma.
+func (p *parser) readExpression(element bool) (string, error) {
+	p.skipSpace(false)
+	start := p.pos
+	depth := 0
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case (c == '\n' || (c == ',' && element)) && depth == 0:
+			return strings.TrimSpace(string(p.src[start:p.pos])), nil
+		case (c == '#' || p.hasPrefix("//")) && depth == 0:
+			end := p.pos
+			p.skipSpace(false)
+			return strings.TrimSpace(string(p.src[start:end])), nil
+		case c == '#' || p.hasPrefix("//") || p.hasPrefix("/*"):
+			p.skipSpace(false)
+		case c == '"':
+			if err := p.skipQuoted(); err != nil {
+				return "", err
+			}
+		case p.hasPrefix("<<"):
+			if err := p.skipHeredoc(); err != nil {
+				return "", err
+			}
+		case c == '(' || c == '[' || c == '{':
+			depth++
+			p.pos++
+		case c == ')' || c == ']' || c == '}':
+			if depth == 0 {
+				return strings.TrimSpace(string(p.src[start:p.pos])), nil
+			}
+			depth--
+			p.pos++
+		default:
+			p.pos++
+		}
+	}
+	if depth > 0 {
+		return "", p.errorf("unclosed bracket in expression")
+	}
+	return strings.TrimSpace(string(p.src[start:p.pos])), nil
+}
+
+// skipQuoted moves past a quoted template, including any interpolations.
+func (p *parser) skipQuoted() error {
+	p.pos++ // opening quote
+	for p.pos < len(p.src) {
+		switch {
+		case p.src[p.pos] == '"':
+			p.pos++
+			return nil
+		case p.src[p.pos] == '\n':
+			return p.errorf("unterminated string")
+		case p.src[p.pos] == '\\':
+			p.pos += 2
+		case p.hasPrefix("$${") || p.hasPrefix("%%{"):
+			p.pos += 3
+		case p.hasPrefix("${") || p.hasPrefix("%{"):
+			p.pos += 2
+			if err := p.skipTemplate(); err != nil {
+				return err
+			}
+		default:
+			p.pos++
+		}
+	}
+	return p.errorf("unterminated string")
+}
+
+// skipTemplate moves past the expression of an interpolation or directive
+// up to and including its closing brace.
+func (p *parser) skipTemplate() error {
+	depth := 0
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; c {
+		case '"':
+			if err := p.skipQuoted(); err != nil {
+				return err
+			}
+			continue
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				p.pos++
+				return nil
+			}
+			depth--
+		}
+		p.pos++
+	}
+	return p.errorf("unterminated template interpolation")
+}
+
+// skipHeredoc moves past a heredoc (<<EOT or <<-EOT) up to the end of its
+// closing marker line.
+func (p *parser) skipHeredoc() error {
+	p.pos += 2
+	if p.peek() == '-' {
+		p.pos++
+	}
+	marker := p.readIdent()
+	if marker == "" {
+		return p.errorf("invalid heredoc marker")
+	}
+	for {
+		nl := bytes.IndexByte(p.src[p.pos:], '\n')
+		if nl < 0 {
+			return p.errorf("heredoc %s is not terminated", marker)
+		}
+		p.pos += nl + 1
+		end := bytes.IndexByte(p.src[p.pos:], '\n')
+		if end < 0 {
+			end = len(p.src) - p.pos
+		}
+		if strings.TrimSpace(string(p.src[p.pos:p.pos+end])) == marker {
+			p.pos += end
+			return nil
+		}
+	}
+}
+
+// literal converts expression source text to a Go value when it is a
+// literal, or to an Expression otherwise. Tuples and objects are kept as
+// containers when only some of their elements are expressions.
+func literal(raw string) any {
+	lp := &parser{src: []byte(raw)}
+	v, ok := lp.parseLiteral()
+	lp.skipSpace(true)
+	if !ok || lp.pos != len(lp.src) {
+		return Expression(raw)
+	}
+	return v
+}
+
+// parseLiteral reads a literal value: a string without interpolations, a
+// number, a bool, null, or a tuple or object.
+func (p *parser) parseLiteral() (any, bool) {
+	p.skipSpace(true)
+	switch c := p.peek(); {
+	case c == '"':
+		return p.readString()
+	case c == '[':
+		return p.parseTuple()
+	case c == '{':
+		return p.parseObject()
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.parseNumber()
+	case isIdentStart(c):
+		switch p.readIdent() {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		case "null":
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+// parseTuple reads a [ ... ] tuple. A for expression is not a tuple.
+func (p *parser) parseTuple() (any, bool) {
+	p.pos++
+	elems := []any{}
+	for {
+		p.skipSpace(true)
+		if p.peek() == ']' {
+			p.pos++
+			return elems, true
+		}
+		if p.hasPrefix("for ") {
+			return nil, false
+		}
+		v, ok := p.parseElement()
+		if !ok {
+			return nil, false
+		}
+		elems = append(elems, v)
+		p.skipSpace(true)
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, false
+		}
+	}
+}
+
+// parseObject reads a { ... } object. Keys may be identifiers or quoted
+// strings, followed by '=' or ':'. A for expression is not an object.
+func (p *parser) parseObject() (any, bool) {
+	p.pos++
+	om := orderedmap.New()
+	for {
+		p.skipSpace(true)
+		if p.peek() == '}' {
+			p.pos++
+			return om, true
+		}
+
+		if p.hasPrefix("for ") {
+			return nil, false
+		}
+
+		var key string
+		if p.peek() == '"' {
+			k, ok := p.readString()
+			if !ok {
+				return nil, false
+			}
+			key = k
+		} else if key = p.readIdent(); key == "" {
+			return nil, false
+		}
+
+		p.skipSpace(false)
+		if c := p.peek(); c != '=' && c != ':' {
+			return nil, false
+		}
+		p.pos++
+
+		v, ok := p.parseElement()
+		if !ok {
+			return nil, false
+		}
+		om.Set(key, v)
+
+		p.skipSpace(false)
+		switch p.peek() {
+		case ',', '\n':
+			p.pos++
+		case '}':
+		default:
+			return nil, false
+		}
+	}
+}
+
+// parseElement reads a tuple element or object value: a literal, or else
+// the expression text up to the next separator.
+func (p *parser) parseElement() (any, bool) {
+	p.skipSpace(true)
+	start := p.pos
+	if v, ok := p.parseLiteral(); ok {
+		end := p.pos
+		p.skipSpace(false)
+		c := p.peek()
+		p.pos = end
+		if c == ',' || c == ']' || c == '}' || c == '\n' || c == 0 {
+			return v, true
+		}
+	}
+
+	p.pos = start
+	raw, err := p.readExpression(true)
+	if err != nil || raw == "" {
+		return nil, false
+	}
+	return Expression(raw), true
+}
+
+// parseNumber reads a number literal as int64, or float64 when it has a
+// fraction or exponent or doesn't fit in an int64.
+func (p *parser) parseNumber() (any, bool) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	isFloat := false
+scan:
+	for ; p.pos < len(p.src); p.pos++ {
+		switch c := p.src[p.pos]; {
+		case c >= '0' && c <= '9':
+		case c == '.' || c == 'e' || c == 'E':
+			isFloat = true
+		case (c == '+' || c == '-') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E'):
+		default:
+			break scan
+		}
+	}
+	text := string(p.src[start:p.pos])
+	if !isFloat {
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return i, true
+		}
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+// isIdentStart reports whether c can start an identifier.
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isIdentChar reports whether c can continue an identifier.
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c == '-' || (c >= '0' && c <= '9')
+}
+
+// isIdent reports whether s is a valid identifier.
+func isIdent(s string) bool {
+	if s == "" || !isIdentStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isIdentChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Serialize writes the tree in canonical HCL style: two-space indentation,
+// '=' aligned across adjacent attributes, and blocks set off by blank lines.
+func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, error) {
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return nil, fmt.Errorf("tree is not an ordered map")
+	}
+
+	var buf bytes.Buffer
+	if err := h.writeBody(&buf, om, nil, 0); err != nil {
+		return nil, fmt.Errorf("failed to serialize HCL: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// bodyItem is one attribute or block of a body, ready to be written.
+type bodyItem struct {
+	name  string
+	value string // Rendered attribute value; empty for blocks
+	block string // Rendered block(s); empty for attributes
+}
+
+// writeBody writes the attributes and blocks of om at the given depth.
+func (h *Handler) writeBody(buf *bytes.Buffer, om *orderedmap.OrderedMap, treePath []string, depth int) error {
+	var items []bodyItem
+	for _, key := range om.Keys() {
+		val, _ := om.Get(key)
+		keyPath := append(append([]string{}, treePath...), key)
+
+		if labels, isBlock := h.blocks[strings.Join(keyPath, blockPathSep)]; isBlock {
+			var block bytes.Buffer
+			if err := h.writeBlocks(&block, key, nil, val, labels, keyPath, depth); err != nil {
+				return err
+			}
+			items = append(items, bodyItem{name: key, block: block.String()})
+			continue
+		}
+
+		if !isIdent(key) {
+			return fmt.Errorf("invalid attribute name %q", key)
+		}
+		rendered, err := renderValue(val, depth)
+		if err != nil {
+			return fmt.Errorf("attribute %q: %w", key, err)
+		}
+		items = append(items, bodyItem{name: key, value: rendered})
+	}
+
+	writeItems(buf, items, depth)
+	return nil
+}
+
+// writeItems writes rendered body items, aligning '=' across each run of
+// adjacent single-line attributes. Multi-line attributes are not aligned and
+// end the run, as in terraform fmt.
+func writeItems(buf *bytes.Buffer, items []bodyItem, depth int) {
+	indent := strings.Repeat(indentUnit, depth)
+	width := 0
+	for i, item := range items {
+		if item.block != "" {
+			if i > 0 {
+				buf.WriteByte('\n')
+			}
+			buf.WriteString(item.block)
+			continue
+		}
+
+		if i > 0 && items[i-1].block != "" {
+			buf.WriteByte('\n')
+		}
+		if i == 0 || items[i-1].block != "" || items[i-1].multiline() {
+			width = runWidth(items[i:])
+		}
+		name := item.name
+		if !item.multiline() {
+			name += strings.Repeat(" ", width-len(name))
+		}
+		fmt.Fprintf(buf, "%s%s = %s\n", indent, name, item.value)
+	}
+}
+
+// multiline reports whether an attribute's value spans several lines.
+func (item bodyItem) multiline() bool {
+	return strings.Contains(item.value, "\n")
+}
+
+// runWidth returns the widest name in the run of single-line attributes
+// starting at items[0].
+func runWidth(items []bodyItem) int {
+	width := 0
+	for _, item := range items {
+		if item.block != "" || item.multiline() {
+			break
+		}
+		width = max(width, len(item.name))
+	}
+	return width
+}
+
+// writeBlocks writes the block(s) stored at val. Each remaining label level
+// is a map keyed by label; a []any holds repeated blocks.
+func (h *Handler) writeBlocks(buf *bytes.Buffer, blockType string, labels []string, val any, remaining int, treePath []string, depth int) error {
+	if repeated, ok := val.([]any); ok {
+		for i, elem := range repeated {
+			if i > 0 {
+				buf.WriteByte('\n')
+			}
+			if err := h.writeBlocks(buf, blockType, labels, elem, remaining, treePath, depth); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	om := format.ToOrderedMapPtr(val)
+	if om == nil {
+		return fmt.Errorf("block %q must be a map, got %T", blockType, val)
+	}
+
+	if remaining > 0 {
+		for i, label := range om.Keys() {
+			if i > 0 {
+				buf.WriteByte('\n')
+			}
+			next, _ := om.Get(label)
+			if err := h.writeBlocks(buf, blockType, append(append([]string{}, labels...), label), next, remaining-1,
+				append(append([]string{}, treePath...), label), depth); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	indent := strings.Repeat(indentUnit, depth)
+	buf.WriteString(indent + blockType)
+	for _, label := range labels {
+		buf.WriteString(" " + quote(label))
+	}
+	buf.WriteString(" {\n")
+	if err := h.writeBody(buf, om, treePath, depth+1); err != nil {
+		return err
+	}
+	buf.WriteString(indent + "}\n")
+	return nil
+}
+
+// renderValue renders an attribute value; nested lines are indented for depth.
+func renderValue(v any, depth int) (string, error) {
+	switch val := v.(type) {
+	case nil:
+		return "null", nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	case int64:
+		return strconv.FormatInt(val, 10), nil
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), nil
+	case string:
+		return quote(val), nil
+	case Expression:
+		return string(val), nil
+	case []any:
+		return renderTuple(val, depth)
+	}
+
+	if om := format.ToOrderedMapPtr(v); om != nil {
+		return renderObject(om, depth)
+	}
+	return "", fmt.Errorf("unsupported value type %T", v)
+}
+
+// renderTuple writes a tuple on one line, or one element per line with
+// trailing commas when any element spans several lines.
+func renderTuple(elems []any, depth int) (string, error) {
+	rendered := make([]string, len(elems))
+	multiline := false
+	for i, elem := range elems {
+		r, err := renderValue(elem, depth+1)
+		if err != nil {
+			return "", err
+		}
+		rendered[i] = r
+		multiline = multiline || strings.Contains(r, "\n")
+	}
+
+	if !multiline {
+		return "[" + strings.Join(rendered, ", ") + "]", nil
+	}
+	inner := strings.Repeat(indentUnit, depth+1)
+	var sb strings.Builder
+	sb.WriteString("[\n")
+	for _, r := range rendered {
+		sb.WriteString(inner + r + ",\n")
+	}
+	sb.WriteString(strings.Repeat(indentUnit, depth) + "]")
+	return sb.String(), nil
+}
+
+// renderObject writes an object with one aligned attribute per line.
+func renderObject(om *orderedmap.OrderedMap, depth int) (string, error) {
+	if len(om.Keys()) == 0 {
+		return "{}", nil
+	}
+
+	items := make([]bodyItem, 0, len(om.Keys()))
+	for _, key := range om.Keys() {
+		val, _ := om.Get(key)
+		rendered, err := renderValue(val, depth+1)
+		if err != nil {
+			return "", fmt.Errorf("key %q: %w", key, err)
+		}
+		name := key
+		if !isIdent(key) {
+			name = quote(key)
+		}
+		items = append(items, bodyItem{name: name, value: rendered})
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("{\n")
+	writeItems(&buf, items, depth+1)
+	buf.WriteString(strings.Repeat(indentUnit, depth) + "}")
+	return buf.String(), nil
+}
+
+// quote writes s as an HCL string literal, escaping template sequences.
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\r':
+			sb.WriteString(`\r`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case (c == '$' || c == '%') && i+1 < len(s) && s[i+1] == '{':
+			sb.WriteByte(c)
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// GetPath extracts a value at the given path. Maps are addressed by key
+// (block types, labels, and attribute names) and repeated blocks and tuples
+// by numeric index. "*" matches any key or element and returns the first
+// match; with "**", the first match in document order is returned.
+func (h *Handler) GetPath(tree any, p path.Path) (any, bool) {
+	return getPath(tree, p.Segments(), 0)
+}
+
+// getPath recursively navigates maps and arrays.
+func getPath(current any, segments []string, idx int) (any, bool) {
+	if idx >= len(segments) {
+		return current, true
+	}
+
+	segment := segments[idx]
+
+	if om := format.ToOrderedMapPtr(current); om != nil {
+		switch segment {
+		case path.RecursiveWildcard:
+			// Recursive wildcard: match at this level first, then at any depth below
+			if idx == len(segments)-1 {
+				return nil, false
+			}
+			if result, ok := getPath(om, segments, idx+1); ok {
+				return result, true
+			}
+			for _, key := range om.Keys() {
+				val, _ := om.Get(key)
+				if result, ok := getPath(val, segments, idx); ok {
+					return result, true
+				}
+			}
+			return nil, false
+		case path.Wildcard:
+			for _, key := range om.Keys() {
+				val, _ := om.Get(key)
+				if result, ok := getPath(val, segments, idx+1); ok {
+					return result, true
+				}
+			}
+			return nil, false
+		}
+		val, exists := om.Get(segment)
+		if !exists {
+			return nil, false
+		}
+		return getPath(val, segments, idx+1)
+	}
+
+	if arr, ok := current.([]any); ok {
+		if segment == path.Wildcard {
+			for _, elem := range arr {
+				if result, ok := getPath(elem, segments, idx+1); ok {
+					return result, true
+				}
+			}
+			return nil, false
+		}
+		i, ok := arrayIndex(segment, len(arr))
+		if !ok {
+			return nil, false
+		}
+		return getPath(arr[i], segments, idx+1)
+	}
+
+	return nil, false
+}
+
+// SetPath sets a value at the given path. "*" applies to every key or
+// element, and "**" to every existing match. Missing map keys along the path
+// are created as maps (written as objects unless the handler has seen a
+// block there); array indexes must already exist.
+func (h *Handler) SetPath(tree any, p path.Path, value any) error {
+	segments := p.Segments()
+	if len(segments) == 0 {
+		return fmt.Errorf("empty path")
+	}
+
+	value, err := format.NormalizeForFormat(value, "hcl")
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %w", p, err)
+	}
+
+	return setPath(tree, segments, 0, value)
+}
+
+// setPath recursively sets values in maps and arrays.
+func setPath(current any, segments []string, idx int, value any) error {
+	segment := segments[idx]
+	isLast := idx == len(segments)-1
+
+	if om := format.ToOrderedMapPtr(current); om != nil {
+		switch segment {
+		case path.RecursiveWildcard:
+			// Recursive wildcard: apply to every existing match, never create keys
+			for _, keys := range format.ExpandPath(om, segments[idx:]) {
+				// Matches already exist, so setting them can't fail
+				_ = setPath(om, keys, 0, value)
+			}
+			return nil
+		case path.Wildcard:
+			for _, key := range om.Keys() {
+				if isLast {
+					om.Set(key, value)
+					continue
+				}
+				val, _ := om.Get(key)
+				// Continue to other keys even if one fails
+				_ = setPath(val, segments, idx+1, value)
+			}
+			return nil
+		}
+
+		if isLast {
+			om.Set(segment, value)
+			return nil
+		}
+
+		next, exists := om.Get(segment)
+		if !exists {
+			next = orderedmap.New()
+			om.Set(segment, next)
+		}
+		if format.ToOrderedMapPtr(next) == nil {
+			if _, ok := next.([]any); !ok {
+				return fmt.Errorf("path segment %q is not a map or array", segment)
+			}
+		}
+		return setPath(next, segments, idx+1, value)
+	}
+
+	if arr, ok := current.([]any); ok {
+		if segment == path.Wildcard {
+			for i := range arr {
+				if isLast {
+					arr[i] = value
+					continue
+				}
+				_ = setPath(arr[i], segments, idx+1, value)
+			}
+			return nil
+		}
+
+		i, ok := arrayIndex(segment, len(arr))
+		if !ok {
+			return fmt.Errorf("array index %q out of range (length %d)", segment, len(arr))
+		}
+		if isLast {
+			arr[i] = value
+			return nil
+		}
+		if format.ToOrderedMapPtr(arr[i]) == nil {
+			if _, ok := arr[i].([]any); !ok {
+				return fmt.Errorf("array element %d is not a map or array", i)
+			}
+		}
+		return setPath(arr[i], segments, idx+1, value)
+	}
+
+	return fmt.Errorf("cannot navigate into non-container value")
+}
+
+// arrayIndex parses segment as an index into an array of length n.
+func arrayIndex(segment string, n int) (int, bool) {
+	i, err := strconv.Atoi(segment)
+	if err != nil || i < 0 || i >= n {
+		return 0, false
+	}
+	return i, true
+}
+
+// DeletePath removes the value at the given path. Wildcards delete every
+// match, and an array element is removed from its array.
+func (h *Handler) DeletePath(tree any, p path.Path) error {
+	segments := p.Segments()
+	if len(segments) == 0 {
+		return fmt.Errorf("empty path")
+	}
+	return format.DeleteMatches(tree, segments)
+}
+
+// Ensure Handler implements format.Handler.
+var _ format.Handler = (*Handler)(nil)
@@ -0,0 +1,343 @@
+package hcl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/formattest"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// terraformDoc is a Terraform-ish file in canonical formatting.
+const terraformDoc = `terraform {
+  required_version = ">= 1.5"
+}
+
+provider "aws" {
+  region  = "us-east-1"
+  profile = var.profile
+}
+
+variable "instance_count" {
+  type    = number
+  default = 2
+}
+
+resource "aws_instance" "web" {
+  ami           = "ami-123456"
+  instance_type = "t3.micro"
+  count         = var.instance_count
+  tags = {
+    Name = "web-${count.index}"
+    Team = "platform"
+  }
+
+  ebs_block_device {
+    device_name = "/dev/sdb"
+    volume_size = 20
+  }
+
+  ebs_block_device {
+    device_name = "/dev/sdc"
+    volume_size = 40
+  }
+}
+
+resource "aws_s3_bucket" "logs" {
+  bucket = "my-logs"
+}
+
+output "ip" {
+  value = aws_instance.web[0].public_ip
+}
+`
+
+func parse(t *testing.T, h *Handler, doc string) *orderedmap.OrderedMap {
+	t.Helper()
+	tree, err := h.Parse([]byte(doc), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	return tree.(*orderedmap.OrderedMap)
+}
+
+func TestHandler_Parse(t *testing.T) {
+	h := New()
+	tree := parse(t, h, terraformDoc)
+
+	if keys := tree.Keys(); !reflect.DeepEqual(keys, []string{"terraform", "provider", "variable", "resource", "output"}) {
+		t.Errorf("top-level keys = %v", keys)
+	}
+
+	tests := []struct {
+		path []string
+		want any
+	}{
+		{[]string{"terraform", "required_version"}, ">= 1.5"},
+		{[]string{"provider", "aws", "region"}, "us-east-1"},
+		{[]string{"provider", "aws", "profile"}, Expression("var.profile")},
+		{[]string{"variable", "instance_count", "type"}, Expression("number")},
+		{[]string{"variable", "instance_count", "default"}, int64(2)},
+		{[]string{"resource", "aws_instance", "web", "tags", "Team"}, "platform"},
+		{[]string{"resource", "aws_instance", "web", "tags", "Name"}, Expression(`"web-${count.index}"`)},
+		{[]string{"resource", "aws_instance", "web", "ebs_block_device", "1", "volume_size"}, int64(40)},
+		{[]string{"resource", "aws_s3_bucket", "logs", "bucket"}, "my-logs"},
+		{[]string{"output", "ip", "value"}, Expression("aws_instance.web[0].public_ip")},
+	}
+	for _, tt := range tests {
+		p := path.NewArrayPath(tt.path)
+		got, ok := h.GetPath(tree, p)
+		if !ok {
+			t.Errorf("GetPath(%s) not found", p)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetPath(%s) = %#v, want %#v", p, got, tt.want)
+		}
+	}
+}
+
+func TestHandler_Parse_Literals(t *testing.T) {
+	h := New()
+	tree := parse(t, h, `
+# comment
+// another comment
+/* block
+   comment */
+str     = "a \"quoted\" \\ value\n"
+escaped = "literal $${not_interpolated}"
+int     = -42
+float   = 1.5e3
+yes     = true
+nothing = null
+list    = ["a", 1, false] # trailing comment
+object  = { a = 1, "b-c": "x" }
+multi = [
+  1,
+  2, // inside
+]
+call    = upper("x")
+mixed   = [var.a, "b", { k = local.v }]
+for_tuple = [for s in var.list : upper(s)]
+for_object = { for k, v in var.map : k => v }
+math    = 1 + 2
+heredoc = <<EOT
+  keep ${this}
+EOT
+`)
+
+	want := map[string]any{
+		"str":        "a \"quoted\" \\ value\n",
+		"escaped":    "literal ${not_interpolated}",
+		"int":        int64(-42),
+		"float":      1500.0,
+		"yes":        true,
+		"nothing":    nil,
+		"list":       []any{"a", int64(1), false},
+		"multi":      []any{int64(1), int64(2)},
+		"call":       Expression(`upper("x")`),
+		"mixed":      []any{Expression("var.a"), "b", kv("k", Expression("local.v"))},
+		"for_tuple":  Expression("[for s in var.list : upper(s)]"),
+		"for_object": Expression("{ for k, v in var.map : k => v }"),
+		"math":       Expression("1 + 2"),
+		"heredoc":    Expression("<<EOT\n  keep ${this}\nEOT"),
+	}
+	for key, w := range want {
+		got, _ := tree.Get(key)
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("%s = %#v, want %#v", key, got, w)
+		}
+	}
+
+	obj, _ := tree.Get("object")
+	om, ok := obj.(*orderedmap.OrderedMap)
+	if !ok {
+		t.Fatalf("object = %T, want *orderedmap.OrderedMap", obj)
+	}
+	if keys := om.Keys(); !reflect.DeepEqual(keys, []string{"a", "b-c"}) {
+		t.Errorf("object keys = %v, want [a b-c]", keys)
+	}
+}
+
+// kv builds an ordered map from a single key-value pair.
+func kv(key string, value any) *orderedmap.OrderedMap {
+	om := orderedmap.New()
+	om.Set(key, value)
+	return om
+}
+
+func TestHandler_Parse_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"unclosed block", "a {\n  b = 1\n"},
+		{"missing value", "a =\n"},
+		{"duplicate attribute", "a = 1\na = 2\n"},
+		{"unterminated string", "a = \"x\n"},
+		{"unclosed bracket", "a = [1, 2\n"},
+		{"inconsistent labels", "provider \"aws\" {\n}\nprovider {\n}\n"},
+		{"garbage", "= 1\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New().Parse([]byte(tt.doc), format.ParseOptions{}); err == nil {
+				t.Error("Parse() should return an error")
+			}
+		})
+	}
+
+	if _, err := New().Parse([]byte("a = 1\n"), format.ParseOptions{StripComments: true}); err == nil {
+		t.Error("Parse() should reject strip-comments")
+	}
+}
+
+func TestHandler_RoundTrip_PreservesBlockOrder(t *testing.T) {
+	h := New()
+	tree := parse(t, h, terraformDoc)
+
+	out, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if string(out) != terraformDoc {
+		t.Errorf("round-trip mismatch:\ngot:\n%s\nwant:\n%s", out, terraformDoc)
+	}
+}
+
+func TestHandler_Serialize_Canonical(t *testing.T) {
+	h := New()
+	tree := parse(t, h, `
+a = 1
+long_name = "x"
+block "one" { inner = true }
+obj = {
+k = "v"
+}
+empty = []
+`)
+
+	want := `a         = 1
+long_name = "x"
+
+block "one" {
+  inner = true
+}
+
+obj = {
+  k = "v"
+}
+empty = []
+`
+	out, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if string(out) != want {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestHandler_Serialize_NewValues(t *testing.T) {
+	h := New()
+	tree := parse(t, h, "provider \"aws\" {\n  region = \"us-east-1\"\n}\n")
+
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"provider", "aws", "region"}), "eu-west-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"provider", "aws", "allowed"}), []string{"a", "${b}"}); err != nil {
+		t.Fatal(err)
+	}
+	// A map at a new key is written as an object, not a block
+	settings := orderedmap.New()
+	settings.Set("debug", true)
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"settings"}), settings); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `provider "aws" {
+  region  = "eu-west-1"
+  allowed = ["a", "$${b}"]
+}
+
+settings = {
+  debug = true
+}
+`
+	out, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if string(out) != want {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestHandler_BlocksFromCurrent(t *testing.T) {
+	// Blocks that appear only in the second document are still written as blocks
+	h := New()
+	managed := parse(t, h, "region = \"x\"\n")
+	current := parse(t, h, "backend \"s3\" {\n  bucket = \"state\"\n}\n")
+
+	backend, _ := current.Get("backend")
+	managed.Set("backend", backend)
+
+	out, err := h.Serialize(managed, format.SerializeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "backend \"s3\" {\n  bucket = \"state\"\n}\n") {
+		t.Errorf("Serialize() =\n%s\nwant backend written as a block", out)
+	}
+}
+
+func TestHandler_SetPath_Wildcards(t *testing.T) {
+	h := New()
+	tree := parse(t, h, terraformDoc)
+
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"resource", "aws_instance", "web", "ebs_block_device", "*", "volume_size"}), 100); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []string{"0", "1"} {
+		got, _ := h.GetPath(tree, path.NewArrayPath([]string{"resource", "aws_instance", "web", "ebs_block_device", i, "volume_size"}))
+		if got != int64(100) {
+			t.Errorf("ebs_block_device[%s].volume_size = %#v, want 100", i, got)
+		}
+	}
+
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"**", "bucket"}), "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := h.GetPath(tree, path.NewArrayPath([]string{"resource", "aws_s3_bucket", "logs", "bucket"})); got != "renamed" {
+		t.Errorf("bucket = %#v, want renamed", got)
+	}
+
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"resource", "aws_instance", "web", "ebs_block_device", "5", "x"}), 1); err == nil {
+		t.Error("SetPath() with an out-of-range index should fail")
+	}
+}
+
+func TestHandler_SetPath_Unsupported(t *testing.T) {
+	h := New()
+	tree := parse(t, h, "a = 1\n")
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"a"}), []byte("x")); err == nil {
+		t.Error("SetPath() should reject binary data")
+	}
+}
+
+func TestHandler_Conformance(t *testing.T) {
+	formattest.RunConformance(t, New(), formattest.Fixtures{
+		Document:        terraformDoc,
+		RoundTripExact:  true,
+		OrderedKeys:     []string{"terraform", "provider", "variable", "resource", "output"},
+		LeafPath:        []string{"provider", "aws", "region"},
+		LeafValue:       "us-east-1",
+		WildcardPath:    []string{"resource", "*", "*", "ebs_block_device", "*", "device_name"},
+		WildcardMatches: [][]string{{"resource", "aws_instance", "web", "ebs_block_device", "0", "device_name"}, {"resource", "aws_instance", "web", "ebs_block_device", "1", "device_name"}},
+		DeepPath:        []string{"new", "nested", "key"},
+	})
+}
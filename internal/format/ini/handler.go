@@ -3,7 +3,11 @@ package ini
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/iancoleman/orderedmap"
 	"github.com/thirteen37/chezmoi-split/internal/format"
@@ -12,27 +16,83 @@ import (
 )
 
 // Handler implements format.Handler for INI files.
-type Handler struct{}
+//
+// The comment block above each [section] header and each key is remembered
+// from the first document that defines the section or key, and written back
+// above it by Serialize. Parsing managed before current therefore keeps the
+// template's documentation, while sections and keys that only exist in the
+// current file keep the app's comments.
+//
+// Values are strings with any surrounding quotes removed. A key assigned
+// more than once in a section, such as a repeated include, holds a []any of
+// its values in order and is written back one line per value. The text each
+// value was written as (`"Jane Doe"`, `3.10`) is remembered from every
+// document parsed, so a value copied from the current file is written back
+// as the app wrote it.
+//
+// Subsections nest: a git-style [user "work"] or a dotted [a.b] section is
+// the map at ["user", "work"] or ["a", "b"], and is written back with the
+// header it was parsed from. A subsection that only the merge created is
+// written in the quoted style if any parsed document used it, and dotted
+// otherwise. A dotted name that would collide with a key of its parent
+// section stays a single section named "a.b". The layout-preserving handler
+// keeps every section flat.
+type Handler struct {
+	preserveLayout  bool
+	layout          *layout
+	sectionComments map[string]string
+	keyComments     map[string]map[string]string // Section name → key name → comment
+	spellings       map[spellingKey]string
+	headers         map[string]string // Subsection path → header it was parsed from
+	declared        map[string]bool   // Paths of sections that had a header of their own
+	quoted          bool              // Whether a parsed document had a quoted subsection
+}
+
+// spellingKey identifies the text a key's value was written as.
+type spellingKey struct {
+	section, key, value string
+}
 
 // New creates a new INI handler.
 func New() *Handler {
 	return &Handler{}
 }
 
+// NewWithLayout creates an INI handler that preserves the vertical layout of
+// the first document it parses (comments, blank lines, and key order), as
+// used for php.ini-style files. In the interpreter the managed template is
+// parsed first, so its layout shapes the output.
+func NewWithLayout() *Handler {
+	return &Handler{preserveLayout: true}
+}
+
+// loadOptions keeps every line of a repeated key, even when two lines have
+// the same value.
+var loadOptions = ini.LoadOptions{AllowShadows: true, AllowDuplicateShadowValues: true}
+
 // Parse reads INI bytes and returns an *orderedmap.OrderedMap.
-// Structure: {"section": {"key": "value"}}
+// Structure: {"section": {"key": "value", "repeated": []any{"a", "b"}}}
 // Global keys (before any section) are stored under the empty string key "".
 func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
 	if opts.StripComments {
 		return nil, fmt.Errorf("strip-comments is not supported for INI format")
 	}
 
-	cfg, err := ini.Load(data)
+	cfg, err := ini.LoadSources(loadOptions, data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse INI: %w", err)
 	}
 
 	result := orderedmap.New()
+	keyPaths := make(map[string]bool)
+	if !h.preserveLayout {
+		for _, section := range cfg.Sections() {
+			segments, _ := splitSectionName(section.Name())
+			for _, key := range section.KeyStrings() {
+				keyPaths[pathKey(append(segments, key))] = true
+			}
+		}
+	}
 
 	for _, section := range cfg.Sections() {
 		sectionName := section.Name()
@@ -41,28 +101,327 @@ func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
 			sectionName = ""
 		}
 
-		sectionMap := orderedmap.New()
+		// Only add section if it has keys (or is explicitly named)
+		if len(section.Keys()) == 0 && sectionName == "" {
+			continue
+		}
+
+		segments := []string{sectionName}
+		if !h.preserveLayout && sectionName != "" {
+			segments = h.nest(sectionName, keyPaths)
+		}
+		sectionMap := sectionAt(result, segments)
 		for _, key := range section.Keys() {
-			sectionMap.Set(key.Name(), key.Value())
+			sectionMap.Set(key.Name(), keyValue(key))
+			h.recordKeyComment(sectionName, key.Name(), key.Comment)
+		}
+		if h.declared == nil {
+			h.declared = make(map[string]bool)
 		}
+		h.declared[pathKey(segments)] = true
 
-		// Only add section if it has keys (or is explicitly named)
-		if len(sectionMap.Keys()) > 0 || sectionName != "" {
-			result.Set(sectionName, sectionMap)
+		if sectionName != "" {
+			if h.sectionComments == nil {
+				h.sectionComments = make(map[string]string)
+			}
+			if _, seen := h.sectionComments[sectionName]; !seen {
+				h.sectionComments[sectionName] = section.Comment
+			}
 		}
 	}
 
+	if h.preserveLayout && h.layout == nil {
+		h.layout = recordLayout(data, result)
+	}
+	h.recordSpellings(data, result)
+
 	return result, nil
 }
 
-// Serialize writes the tree to formatted INI bytes.
+// nest returns the path of the section named name: its subsection path,
+// unless a section of the same document has a key where a subsection along
+// that path would go (keyPaths), in which case the section stays flat. The
+// header is recorded for writing the subsection back.
+func (h *Handler) nest(name string, keyPaths map[string]bool) []string {
+	segments, quoted := splitSectionName(name)
+	for i := 2; i <= len(segments); i++ {
+		if keyPaths[pathKey(segments[:i])] {
+			return []string{name}
+		}
+	}
+	if len(segments) > 1 {
+		if h.headers == nil {
+			h.headers = make(map[string]string)
+		}
+		if _, seen := h.headers[pathKey(segments)]; !seen {
+			h.headers[pathKey(segments)] = name
+		}
+		h.quoted = h.quoted || quoted
+	}
+	return segments
+}
+
+// splitSectionName splits a section name into its subsection path: the
+// name and unescaped subsection of a git-style `user "work"`, or the parts
+// of a dotted `a.b`. It also reports whether the name was quoted. Any other
+// name is a single segment.
+func splitSectionName(name string) ([]string, bool) {
+	if base, sub, ok := strings.Cut(name, " "); ok {
+		sub = strings.TrimSpace(sub)
+		if base == "" || len(sub) < 2 || sub[0] != '"' || sub[len(sub)-1] != '"' {
+			return []string{name}, false
+		}
+		var b strings.Builder
+		escaped := false
+		for _, r := range sub[1 : len(sub)-1] {
+			if r == '\\' && !escaped {
+				escaped = true
+				continue
+			}
+			escaped = false
+			b.WriteRune(r)
+		}
+		return []string{base, b.String()}, true
+	}
+	parts := strings.Split(name, ".")
+	if len(parts) < 2 || slices.Contains(parts, "") {
+		return []string{name}, false
+	}
+	return parts, false
+}
+
+// sectionHeader returns the header written for the section at segments:
+// the one it was parsed from, or a new git-style or dotted one.
+func (h *Handler) sectionHeader(segments []string) string {
+	if len(segments) == 1 {
+		return segments[0]
+	}
+	if name, ok := h.headers[pathKey(segments)]; ok {
+		return name
+	}
+	last := segments[len(segments)-1]
+	if h.quoted || strings.ContainsAny(last, ". \"") {
+		base := strings.Join(segments[:len(segments)-1], ".")
+		return base + ` "` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(last) + `"`
+	}
+	return strings.Join(segments, ".")
+}
+
+// sectionAt returns the map at segments in tree, creating the sections
+// along the path that don't exist yet.
+func sectionAt(tree *orderedmap.OrderedMap, segments []string) *orderedmap.OrderedMap {
+	for _, segment := range segments {
+		val, _ := tree.Get(segment)
+		next := format.ToOrderedMapPtr(val)
+		if next == nil {
+			next = orderedmap.New()
+			tree.Set(segment, next)
+		}
+		tree = next
+	}
+	return tree
+}
+
+// sectionByName returns the map of the section named name in tree, or nil.
+func (h *Handler) sectionByName(tree *orderedmap.OrderedMap, name string) *orderedmap.OrderedMap {
+	if h.preserveLayout {
+		return sectionOf(tree, name)
+	}
+	segments, _ := splitSectionName(name)
+	current := tree
+	for _, segment := range segments {
+		if current = sectionOf(current, segment); current == nil {
+			return sectionOf(tree, name)
+		}
+	}
+	return current
+}
+
+// ResolvePath returns segments with a first segment that names a nested
+// section by its flat name, "a.b" or `remote "origin"`, replaced by the
+// subsection path, so paths written before subsections were nested keep
+// matching. A flat section of that name in tree, kept flat by nest, wins,
+// and a name whose parts hold a wildcard stays literal. The name is
+// recorded as the header of a subsection SetPath creates.
+func (h *Handler) ResolvePath(tree any, segments []string) []string {
+	om := format.ToOrderedMapPtr(tree)
+	if h.preserveLayout || om == nil || len(segments) == 0 {
+		return segments
+	}
+	if _, ok := om.Get(segments[0]); ok {
+		return segments
+	}
+	split, _ := splitSectionName(segments[0])
+	if len(split) < 2 || slices.Contains(split, path.Wildcard) || slices.Contains(split, path.RecursiveWildcard) {
+		return segments
+	}
+	if h.headers == nil {
+		h.headers = make(map[string]string)
+	}
+	if _, seen := h.headers[pathKey(split)]; !seen {
+		h.headers[pathKey(split)] = segments[0]
+	}
+	return append(split, segments[1:]...)
+}
+
+// pathKey returns the key recorded for a section path.
+func pathKey(segments []string) string {
+	return path.NewArrayPath(segments).String()
+}
+
+// ErrorPosition returns the 1-based line of data that an ini.v1 parse error
+// is about, and the column where the line's text starts, or 0, 0 if the
+// error doesn't quote a line of data. ini.v1 errors carry the offending
+// line's text rather than its number.
+func ErrorPosition(data []byte, err error) (line, col int) {
+	var text string
+	var delimErr ini.ErrDelimiterNotFound
+	msg := err.Error()
+	if errors.As(err, &delimErr) {
+		text = delimErr.Line
+	} else if _, rest, ok := strings.Cut(msg, "unclosed section: "); ok {
+		text = rest
+	} else if _, rest, ok := strings.Cut(msg, "missing closing key quote: "); ok {
+		text = rest
+	} else if _, rest, ok := strings.Cut(msg, "missing closing key quote from "); ok {
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return 0, 0
+		}
+		text, _ = strconv.Unquote(quoted)
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, 0
+	}
+
+	// Most errors quote the whole line, an unclosed """ value only the value
+	lines := strings.Split(string(data), "\n")
+	for i, raw := range lines {
+		if strings.TrimSpace(raw) == text {
+			return i + 1, len(raw) - len(strings.TrimLeft(raw, " \t")) + 1
+		}
+	}
+	for i, raw := range lines {
+		if j := strings.Index(raw, text); j >= 0 {
+			return i + 1, j + 1
+		}
+	}
+	return 0, 0
+}
+
+// keyValue returns the value of key, or a []any of its values when the key
+// is assigned more than once. ini.v1 leaves empty values out of a repeated
+// key.
+func keyValue(key *ini.Key) any {
+	values := key.ValueWithShadows()
+	if len(values) < 2 {
+		return key.Value()
+	}
+	result := make([]any, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
+}
+
+// values returns the values v is written as: the elements of a repeated
+// key, or v itself.
+func values(v any) []any {
+	if arr, ok := v.([]any); ok {
+		return arr
+	}
+	return []any{v}
+}
+
+// recordKeyComment remembers the comment above a key unless an earlier
+// document already defined the key.
+func (h *Handler) recordKeyComment(section, key, comment string) {
+	if h.keyComments == nil {
+		h.keyComments = make(map[string]map[string]string)
+	}
+	keys, ok := h.keyComments[section]
+	if !ok {
+		keys = make(map[string]string)
+		h.keyComments[section] = keys
+	}
+	if _, seen := keys[key]; !seen {
+		keys[key] = comment
+	}
+}
+
+// recordSpellings remembers the text after "=" on each key line of data
+// whose parsed value (from tree) differs from it, unless an earlier document
+// already spelled the same value of the key. The nth line of a repeated key
+// is matched with its nth value.
+func (h *Handler) recordSpellings(data []byte, tree *orderedmap.OrderedMap) {
+	section := ""
+	seen := make(map[[2]string]int) // Lines so far of each section and key
+	for _, raw := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(raw)
+		switch {
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			section = strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			continue
+		case trimmed == "" || strings.HasPrefix(trimmed, ";") || strings.HasPrefix(trimmed, "#"):
+			continue
+		}
+
+		key, text, ok := strings.Cut(trimmed, "=")
+		if !ok {
+			continue
+		}
+		key, text = strings.TrimSpace(key), strings.TrimSpace(text)
+		sectionMap := h.sectionByName(tree, section)
+		if sectionMap == nil {
+			continue
+		}
+		val, exists := sectionMap.Get(key)
+		if !exists {
+			continue
+		}
+		n := seen[[2]string{section, key}]
+		seen[[2]string{section, key}]++
+		if vals := values(val); n < len(vals) {
+			val = vals[n]
+		}
+		if toString(val) == text {
+			continue
+		}
+
+		if h.spellings == nil {
+			h.spellings = make(map[spellingKey]string)
+		}
+		sk := spellingKey{section, key, toString(val)}
+		if _, seen := h.spellings[sk]; !seen {
+			h.spellings[sk] = text
+		}
+	}
+}
+
+// spelling returns the text to write for value at section and key: the
+// text it was parsed from, or value itself.
+func (h *Handler) spelling(section, key, value string) string {
+	if text, ok := h.spellings[spellingKey{section, key, value}]; ok {
+		return text
+	}
+	return value
+}
+
+// Serialize writes the tree to formatted INI bytes, including the recorded
+// comment block above each section and key.
 func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, error) {
 	om := format.ToOrderedMapPtr(tree)
 	if om == nil {
 		return nil, fmt.Errorf("tree is not an ordered map")
 	}
 
-	cfg := ini.Empty()
+	if h.layout != nil {
+		text := func(v any) string { return valueText(v, opts.BoolStyle) }
+		return []byte(h.layout.render(om, text, h.spelling)), nil
+	}
+
+	cfg := ini.Empty(loadOptions)
 
 	for _, sectionName := range om.Keys() {
 		sectionVal, _ := om.Get(sectionName)
@@ -70,6 +429,37 @@ func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, err
 		if sectionMap == nil {
 			continue
 		}
+		if err := h.writeSection(cfg, []string{sectionName}, sectionMap, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	_, err := cfg.WriteTo(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize INI: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeSection adds the section at segments to cfg, followed by its
+// subsections. A section that only holds subsections gets no header of its
+// own unless a parsed document gave it one.
+func (h *Handler) writeSection(cfg *ini.File, segments []string, sectionMap *orderedmap.OrderedMap, opts format.SerializeOptions) error {
+	var subsections []string
+	hasKeys := false
+	for _, keyName := range sectionMap.Keys() {
+		keyVal, _ := sectionMap.Get(keyName)
+		if format.ToOrderedMapPtr(keyVal) != nil {
+			subsections = append(subsections, keyName)
+		} else {
+			hasKeys = true
+		}
+	}
+
+	if hasKeys || len(subsections) == 0 || h.declared[pathKey(segments)] {
+		sectionName := h.sectionHeader(segments)
 
 		// Get or create section
 		var section *ini.Section
@@ -79,27 +469,47 @@ func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, err
 			var err error
 			section, err = cfg.NewSection(sectionName)
 			if err != nil {
-				return nil, fmt.Errorf("failed to create section %q: %w", sectionName, err)
+				return fmt.Errorf("failed to create section %q: %w", sectionName, err)
 			}
+			section.Comment = h.sectionComments[sectionName]
 		}
 
 		for _, keyName := range sectionMap.Keys() {
 			keyVal, _ := sectionMap.Get(keyName)
-			strVal := toString(keyVal)
-			_, err := section.NewKey(keyName, strVal)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create key %q: %w", keyName, err)
+			if format.ToOrderedMapPtr(keyVal) != nil {
+				continue
+			}
+			var key *ini.Key
+			for _, v := range values(keyVal) {
+				strVal := valueText(v, opts.BoolStyle)
+				// ini.v1 quotes values with comment characters, backquotes, or
+				// outer spaces itself, so only other spellings can be kept
+				if text := h.spelling(sectionName, keyName, strVal); !strings.ContainsAny(text, "\n`#;") && strings.TrimSpace(text) == text {
+					strVal = text
+				}
+				if key != nil {
+					if err := key.AddShadow(strVal); err != nil {
+						return fmt.Errorf("failed to repeat key %q: %w", keyName, err)
+					}
+					continue
+				}
+				var err error
+				key, err = section.NewKey(keyName, strVal)
+				if err != nil {
+					return fmt.Errorf("failed to create key %q: %w", keyName, err)
+				}
+				key.Comment = h.keyComments[sectionName][keyName]
 			}
 		}
 	}
 
-	var buf bytes.Buffer
-	_, err := cfg.WriteTo(&buf)
-	if err != nil {
-		return nil, fmt.Errorf("failed to serialize INI: %w", err)
+	for _, name := range subsections {
+		subVal, _ := sectionMap.Get(name)
+		if err := h.writeSection(cfg, append(slices.Clip(segments), name), format.ToOrderedMapPtr(subVal), opts); err != nil {
+			return err
+		}
 	}
-
-	return buf.Bytes(), nil
+	return nil
 }
 
 // toString converts any value to its string representation.
@@ -114,92 +524,79 @@ func toString(v any) string {
 	return fmt.Sprintf("%v", v)
 }
 
+// valueText converts v to the text written for it. With a boolStyle,
+// booleans and the words true and false are spelled in that style.
+func valueText(v any, boolStyle string) string {
+	s := toString(v)
+	if boolStyle != "" && (s == "true" || s == "false") {
+		return format.BoolText(s == "true", boolStyle)
+	}
+	return s
+}
+
 // GetPath extracts a value at the given path, supporting wildcards.
-// INI paths are limited to ["section", "key"] format (max 2 segments).
-// Wildcard "*" can be used for section to match any section.
+// INI paths are ["section"] and ["section", "key"], with a segment per
+// subsection between them (["user", "work", "email"]); an index after a
+// repeated key, as in ["section", "key", "1"], selects one of its values.
+// Wildcard "*" matches the first section, key, or value that has the rest
+// of the path.
 func (h *Handler) GetPath(tree any, p path.Path) (any, bool) {
 	segments := p.Segments()
-	if len(segments) == 0 || len(segments) > 2 {
+	if len(segments) == 0 {
 		return nil, false
 	}
+	return getPath(tree, h.ResolvePath(tree, segments))
+}
 
-	om := format.ToOrderedMapPtr(tree)
-	if om == nil {
-		return nil, false
+// getPath walks sections by name and the values of a key by index.
+func getPath(current any, segments []string) (any, bool) {
+	if len(segments) == 0 {
+		return current, true
 	}
-
-	sectionSegment := segments[0]
-
-	// Handle wildcard for section
-	if sectionSegment == "*" {
-		// Try all sections
-		for _, sectionName := range om.Keys() {
-			sectionVal, _ := om.Get(sectionName)
-			if len(segments) == 1 {
-				return sectionVal, true
-			}
-			// Get key from section
-			sectionMap := format.ToOrderedMapPtr(sectionVal)
-			if sectionMap == nil {
-				continue
-			}
-			keySegment := segments[1]
-			if keySegment == "*" {
-				// Return first key from first section
-				for _, keyName := range sectionMap.Keys() {
-					val, _ := sectionMap.Get(keyName)
-					return val, true
-				}
-			} else {
-				if val, exists := sectionMap.Get(keySegment); exists {
-					return val, true
+	segment, rest := segments[0], segments[1:]
+
+	if om := format.ToOrderedMapPtr(current); om != nil {
+		if segment == path.Wildcard {
+			for _, key := range om.Keys() {
+				val, _ := om.Get(key)
+				if got, ok := getPath(val, rest); ok {
+					return got, true
 				}
 			}
+			return nil, false
 		}
-		return nil, false
+		val, exists := om.Get(segment)
+		if !exists {
+			return nil, false
+		}
+		return getPath(val, rest)
 	}
 
-	// Get specific section
-	sectionVal, exists := om.Get(sectionSegment)
-	if !exists {
+	// A value has no keys, and index 0 of a key assigned once is its value
+	if len(rest) > 0 {
 		return nil, false
 	}
-
-	// If only one segment, return the whole section
-	if len(segments) == 1 {
-		return sectionVal, true
+	vals := values(current)
+	if segment == path.Wildcard {
+		return vals[0], len(vals) > 0
 	}
-
-	// Get key from section
-	sectionMap := format.ToOrderedMapPtr(sectionVal)
-	if sectionMap == nil {
+	i, ok := format.ArrayIndex(segment, len(vals))
+	if !ok {
 		return nil, false
 	}
-
-	keySegment := segments[1]
-
-	// Handle wildcard for key
-	if keySegment == "*" {
-		// Return first key value
-		for _, keyName := range sectionMap.Keys() {
-			val, _ := sectionMap.Get(keyName)
-			return val, true
-		}
-		return nil, false
-	}
-
-	val, exists := sectionMap.Get(keySegment)
-	return val, exists
+	return vals[i], true
 }
 
-
-// SetPath sets a value at the given path, supporting wildcards.
-// INI paths are limited to ["section", "key"] format (max 2 segments).
-// Values are converted to strings (INI only supports strings).
+// SetPath sets a value at the given path, supporting wildcards. Paths are
+// those of GetPath: missing sections and subsections along the path are
+// created, while one value of a repeated key must already exist (index 0 of
+// a key assigned once is its value). Values are converted to strings (INI
+// only supports strings), a key may be set to an array of them to repeat
+// it, and a map below a section is a subsection.
 func (h *Handler) SetPath(tree any, p path.Path, value any) error {
 	segments := p.Segments()
-	if len(segments) == 0 || len(segments) > 2 {
-		return fmt.Errorf("INI paths must have 1 or 2 segments, got %d", len(segments))
+	if len(segments) == 0 {
+		return fmt.Errorf("empty path")
 	}
 
 	om := format.ToOrderedMapPtr(tree)
@@ -207,69 +604,120 @@ func (h *Handler) SetPath(tree any, p path.Path, value any) error {
 		return fmt.Errorf("tree is not an ordered map")
 	}
 
-	sectionSegment := segments[0]
+	// A whole section must be a map, and the layout-preserving handler
+	// has no subsections
+	value, err := format.NormalizeForFormat(value, "ini")
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %w", p, err)
+	}
+	section := format.ToOrderedMapPtr(value)
+	reason := ""
+	switch {
+	case len(segments) == 1 && section == nil:
+		reason = "a section value must be a map"
+	case h.preserveLayout && section != nil && (len(segments) > 1 || hasSubsection(section)):
+		reason = "sections cannot be nested when preserving the layout"
+	}
+	if reason != "" {
+		return fmt.Errorf("cannot set %s: %w", p, &format.UnsupportedValueError{Format: "ini", Type: fmt.Sprintf("%T", value), Reason: reason})
+	}
 
-	// Handle wildcard for section
-	if sectionSegment == "*" {
-		for _, sectionName := range om.Keys() {
-			sectionVal, _ := om.Get(sectionName)
-			sectionMap := format.ToOrderedMapPtr(sectionVal)
-			if sectionMap == nil {
-				continue
-			}
-			if len(segments) == 1 {
-				// Replace entire section - convert value to string map
-				om.Set(sectionName, value)
-			} else {
-				keySegment := segments[1]
-				if keySegment == "*" {
-					// Set all keys in section
-					strVal := toString(value)
-					for _, keyName := range sectionMap.Keys() {
-						sectionMap.Set(keyName, strVal)
+	if err := setPath(om, h.ResolvePath(om, segments), 0, value); err != nil {
+		return fmt.Errorf("cannot set %s: %w", p, err)
+	}
+	return nil
+}
+
+// hasSubsection reports whether section holds a map.
+func hasSubsection(section *orderedmap.OrderedMap) bool {
+	for _, key := range section.Keys() {
+		val, _ := section.Get(key)
+		if format.ToOrderedMapPtr(val) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// setPath recursively sets values in sections and repeated keys. A
+// wildcard that ends the path replaces only values of the same kind, so
+// setting every key of a section leaves its subsections alone.
+func setPath(current any, segments []string, idx int, value any) error {
+	segment := segments[idx]
+	isLast := idx == len(segments)-1
+	_, isSection := value.(*orderedmap.OrderedMap)
+
+	if om := format.ToOrderedMapPtr(current); om != nil {
+		if segment == path.Wildcard {
+			for _, key := range om.Keys() {
+				val, _ := om.Get(key)
+				if isLast {
+					if (format.ToOrderedMapPtr(val) != nil) == isSection {
+						om.Set(key, value)
 					}
-				} else {
-					sectionMap.Set(keySegment, toString(value))
+					continue
 				}
+				// Continue to other keys even if one fails
+				_ = setPath(val, segments, idx+1, value)
 			}
+			return nil
 		}
-		return nil
-	}
 
-	// Get or create section
-	sectionVal, exists := om.Get(sectionSegment)
-	var sectionMap *orderedmap.OrderedMap
-	if exists {
-		sectionMap = format.ToOrderedMapPtr(sectionVal)
-		if sectionMap == nil {
-			return fmt.Errorf("section %q is not a map", sectionSegment)
+		if isLast {
+			om.Set(segment, value)
+			return nil
 		}
-	} else {
-		sectionMap = orderedmap.New()
-		om.Set(sectionSegment, sectionMap)
-	}
 
-	// If only one segment, replace the whole section
-	if len(segments) == 1 {
-		om.Set(sectionSegment, value)
+		next, exists := om.Get(segment)
+		if !exists {
+			next = orderedmap.New()
+			om.Set(segment, next)
+		}
+		if _, isArray := next.([]any); isArray || format.ToOrderedMapPtr(next) != nil {
+			return setPath(next, segments, idx+1, value)
+		}
+		if idx+2 != len(segments) {
+			return fmt.Errorf("key %q is not a section", segment)
+		}
+		vals := []any{next}
+		if err := setPath(vals, segments, idx+1, value); err != nil {
+			return err
+		}
+		om.Set(segment, vals[0])
 		return nil
 	}
 
-	keySegment := segments[1]
-
-	// Handle wildcard for key
-	if keySegment == "*" {
-		strVal := toString(value)
-		for _, keyName := range sectionMap.Keys() {
-			sectionMap.Set(keyName, strVal)
+	arr, ok := current.([]any)
+	if !ok || !isLast {
+		return fmt.Errorf("cannot navigate into a value")
+	}
+	if _, isArray := value.([]any); isArray || isSection {
+		return &format.UnsupportedValueError{Format: "ini", Type: fmt.Sprintf("%T", value), Reason: "one value of a repeated key must be a string"}
+	}
+	if segment == path.Wildcard {
+		for i := range arr {
+			arr[i] = value
 		}
 		return nil
 	}
-
-	// Set key in section (convert to string)
-	sectionMap.Set(keySegment, toString(value))
+	i, ok := format.ArrayIndex(segment, len(arr))
+	if !ok {
+		return fmt.Errorf("index %q out of range (%d values)", segment, len(arr))
+	}
+	arr[i] = value
 	return nil
 }
 
+// DeletePath removes a whole section or subsection, one key with all its
+// lines, or one line of a repeated key (["section", "key", "1"]). "*"
+// deletes every section, key, or line it matches.
+func (h *Handler) DeletePath(tree any, p path.Path) error {
+	segments := p.Segments()
+	if len(segments) == 0 {
+		return fmt.Errorf("empty path")
+	}
+	return format.DeleteMatches(tree, h.ResolvePath(tree, segments))
+}
+
 // Ensure Handler implements format.Handler.
 var _ format.Handler = (*Handler)(nil)
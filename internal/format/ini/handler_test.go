@@ -1,11 +1,13 @@
 package ini
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/iancoleman/orderedmap"
 	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/formattest"
 	"github.com/thirteen37/chezmoi-split/internal/path"
 )
 
@@ -261,13 +263,15 @@ func TestHandler_SetPath(t *testing.T) {
 		}
 	})
 
-	t.Run("reject too deep path", func(t *testing.T) {
+	t.Run("three segments create a subsection", func(t *testing.T) {
 		tree := orderedmap.New()
 
 		p := path.NewArrayPath([]string{"a", "b", "c"})
-		err := h.SetPath(tree, p, "value")
-		if err == nil {
-			t.Error("SetPath() should reject 3-segment path for INI")
+		if err := h.SetPath(tree, p, "value"); err != nil {
+			t.Fatalf("SetPath() error = %v", err)
+		}
+		if got, _ := h.GetPath(tree, p); got != "value" {
+			t.Errorf("GetPath() = %v, want value", got)
 		}
 	})
 
@@ -327,6 +331,58 @@ func TestHandler_SetPath_TypeConversion(t *testing.T) {
 	})
 }
 
+func TestHandler_SetPath_SectionShape(t *testing.T) {
+	h := New()
+	section := orderedmap.New()
+	section.Set("key", "original")
+	tree := orderedmap.New()
+	tree.Set("section", section)
+
+	t.Run("map for a key is a subsection", func(t *testing.T) {
+		sub := orderedmap.New()
+		sub.Set("port", 8080)
+		if err := h.SetPath(tree, path.NewArrayPath([]string{"section", "sub"}), sub); err != nil {
+			t.Fatalf("SetPath() error = %v", err)
+		}
+		got, _ := h.GetPath(tree, path.NewArrayPath([]string{"section", "sub", "port"}))
+		if got != "8080" {
+			t.Errorf("port = %#v, want \"8080\"", got)
+		}
+	})
+
+	t.Run("layout handler rejects subsections", func(t *testing.T) {
+		err := NewWithLayout().SetPath(tree, path.NewArrayPath([]string{"section", "key"}), orderedmap.New())
+		if err == nil {
+			t.Error("SetPath() should reject a map as a key value")
+		}
+		nested := orderedmap.New()
+		nested.Set("nested", orderedmap.New())
+		err = NewWithLayout().SetPath(tree, path.NewArrayPath([]string{"section"}), nested)
+		if err == nil {
+			t.Error("SetPath() should reject a section containing a map")
+		}
+	})
+
+	t.Run("scalar for a section is rejected", func(t *testing.T) {
+		err := h.SetPath(tree, path.NewArrayPath([]string{"section"}), "flat")
+		if err == nil {
+			t.Error("SetPath() should reject a scalar as a section value")
+		}
+	})
+
+	t.Run("section values become strings", func(t *testing.T) {
+		replacement := orderedmap.New()
+		replacement.Set("port", 8080)
+		if err := h.SetPath(tree, path.NewArrayPath([]string{"section"}), replacement); err != nil {
+			t.Fatalf("SetPath() error = %v", err)
+		}
+		got, _ := h.GetPath(tree, path.NewArrayPath([]string{"section", "port"}))
+		if got != "8080" {
+			t.Errorf("port = %#v, want \"8080\"", got)
+		}
+	})
+}
+
 func TestHandler_SetPath_Wildcard(t *testing.T) {
 	h := New()
 
@@ -425,3 +481,641 @@ address = 0.0.0.0
 		t.Errorf("Round-trip port = %v, want '5432'", port)
 	}
 }
+
+func TestHandler_Subsections(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		path   []string
+		want   string
+		newSub string // Header written for a subsection the merge adds
+	}{
+		{
+			name: "git-style quoted",
+			input: `[user]
+name = Jane
+
+[user "work"]
+email = jane@work.example
+
+[remote "or\"ig"]
+url = git@example.com:repo.git
+`,
+			path:   []string{"user", "work", "email"},
+			want:   "jane@work.example",
+			newSub: `[user "home"]`,
+		},
+		{
+			name: "dotted",
+			input: `[tool.ruff]
+line-length = 100
+
+[tool.ruff.lint]
+select = E
+`,
+			path:   []string{"tool", "ruff", "lint", "select"},
+			want:   "E",
+			newSub: `[user.home]`,
+		},
+		{
+			name: "plain sections",
+			input: `[server]
+host = localhost
+`,
+			path:   []string{"server", "host"},
+			want:   "localhost",
+			newSub: `[user.home]`,
+		},
+		{
+			name: "dotted name that collides with a key stays flat",
+			input: `[a]
+b = 1
+
+[a.b]
+x = 2
+`,
+			path:   []string{"a.b", "x"},
+			want:   "2",
+			newSub: `[user.home]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			tree, err := h.Parse([]byte(tt.input), format.ParseOptions{})
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got, _ := h.GetPath(tree, path.NewArrayPath(tt.path)); got != tt.want {
+				t.Errorf("GetPath(%v) = %#v, want %q", tt.path, got, tt.want)
+			}
+
+			out, err := h.Serialize(tree, format.SerializeOptions{})
+			if err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+			if string(out) != tt.input {
+				t.Errorf("round trip:\n%s\nwant:\n%s", out, tt.input)
+			}
+
+			if err := h.SetPath(tree, path.NewArrayPath([]string{"user", "home", "email"}), "jane@home.example"); err != nil {
+				t.Fatalf("SetPath() error = %v", err)
+			}
+			out, err = h.Serialize(tree, format.SerializeOptions{})
+			if err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+			if want := tt.newSub + "\nemail = jane@home.example\n"; !strings.Contains(string(out), want) {
+				t.Errorf("new subsection:\n%s\nwant it to contain:\n%s", out, want)
+			}
+		})
+	}
+}
+
+// TestHandler_FlatSectionPaths checks that a path naming a nested section
+// by its flat name, as paths did before subsections, still resolves.
+func TestHandler_FlatSectionPaths(t *testing.T) {
+	h := New()
+	tree, err := h.Parse([]byte("[remote \"origin\"]\nurl = a\n\n[a.b]\nk = 1\n"), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	for _, p := range [][]string{{`remote "origin"`, "url"}, {"a.b", "k"}} {
+		if _, ok := h.GetPath(tree, path.NewArrayPath(p)); !ok {
+			t.Errorf("GetPath(%q) found nothing", p)
+		}
+	}
+	if got := format.ResolvePath(h, tree, []string{"a.b", "*"}); !reflect.DeepEqual(got, []string{"a", "b", "*"}) {
+		t.Errorf("ResolvePath() = %q, want the subsection path", got)
+	}
+	// A wildcard inside the name keeps it literal
+	if got := format.ResolvePath(h, tree, []string{"a.*", "k"}); !reflect.DeepEqual(got, []string{"a.*", "k"}) {
+		t.Errorf("ResolvePath() = %q, want it unchanged", got)
+	}
+
+	// A new section set by its flat name keeps that header
+	if err := h.SetPath(tree, path.NewArrayPath([]string{`remote "upstream"`, "url"}), "b"); err != nil {
+		t.Fatalf("SetPath() error = %v", err)
+	}
+	if err := h.DeletePath(tree, path.NewArrayPath([]string{"a.b", "k"})); err != nil {
+		t.Fatalf("DeletePath() error = %v", err)
+	}
+	out, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if !strings.Contains(string(out), "[remote \"upstream\"]\nurl = b\n") || strings.Contains(string(out), "k = 1") {
+		t.Errorf("Serialize() =\n%s\nwant the new remote and no a.b key", out)
+	}
+}
+
+func TestHandler_RepeatedKeys(t *testing.T) {
+	h := New()
+	input := `[main]
+include = base.conf
+name = app
+include = local.conf
+include = base.conf
+`
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	includes := path.NewArrayPath([]string{"main", "include"})
+	got, _ := h.GetPath(tree, includes)
+	if want := []any{"base.conf", "local.conf", "base.conf"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("include = %#v, want %#v", got, want)
+	}
+	if got, _ := h.GetPath(tree, path.NewArrayPath([]string{"main", "name"})); got != "app" {
+		t.Errorf("name = %#v, want \"app\"", got)
+	}
+
+	// One line per value, in order, and the same tree back
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := "[main]\ninclude = base.conf\ninclude = local.conf\ninclude = base.conf\nname    = app\n"
+	if string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", data, want)
+	}
+	again, err := h.Parse(data, format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(Serialize()) error = %v", err)
+	}
+	if !reflect.DeepEqual(again, tree) {
+		t.Errorf("round trip = %v, want %v", again, tree)
+	}
+
+	t.Run("index addresses one value", func(t *testing.T) {
+		tree, _ := h.Parse([]byte(input), format.ParseOptions{})
+		second := path.NewArrayPath([]string{"main", "include", "1"})
+		if got, ok := h.GetPath(tree, second); !ok || got != "local.conf" {
+			t.Errorf("GetPath(1) = %#v, %v; want \"local.conf\"", got, ok)
+		}
+		if _, ok := h.GetPath(tree, path.NewArrayPath([]string{"main", "include", "3"})); ok {
+			t.Error("GetPath(3) should not find a fourth value")
+		}
+		if got, ok := h.GetPath(tree, path.NewArrayPath([]string{"main", "name", "0"})); !ok || got != "app" {
+			t.Errorf("GetPath(name, 0) = %#v, %v; want \"app\"", got, ok)
+		}
+
+		if err := h.SetPath(tree, second, "other.conf"); err != nil {
+			t.Fatalf("SetPath() error = %v", err)
+		}
+		got, _ := h.GetPath(tree, includes)
+		if want := []any{"base.conf", "other.conf", "base.conf"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("include = %#v, want %#v", got, want)
+		}
+		if err := h.SetPath(tree, path.NewArrayPath([]string{"main", "include", "3"}), "x"); err == nil {
+			t.Error("SetPath() should reject an index past the last value")
+		}
+		if err := h.SetPath(tree, second, []any{"a"}); err == nil {
+			t.Error("SetPath() should reject an array for one value")
+		}
+
+		if err := h.DeletePath(tree, path.NewArrayPath([]string{"main", "include", "0"})); err != nil {
+			t.Fatalf("DeletePath() error = %v", err)
+		}
+		got, _ = h.GetPath(tree, includes)
+		if want := []any{"other.conf", "base.conf"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("include after delete = %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("a key can be set to several values", func(t *testing.T) {
+		tree, _ := h.Parse([]byte("[main]\ninclude = a.conf\n"), format.ParseOptions{})
+		if err := h.SetPath(tree, includes, []any{"a.conf", 2}); err != nil {
+			t.Fatalf("SetPath() error = %v", err)
+		}
+		data, err := h.Serialize(tree, format.SerializeOptions{})
+		if err != nil {
+			t.Fatalf("Serialize() error = %v", err)
+		}
+		if want := "[main]\ninclude = a.conf\ninclude = 2\n"; string(data) != want {
+			t.Errorf("Serialize() = %q, want %q", data, want)
+		}
+	})
+}
+
+func TestHandler_WithLayout_RepeatedKeys(t *testing.T) {
+	h := NewWithLayout()
+	tree, err := h.Parse([]byte(phpIniSnippet), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	extensions := path.NewArrayPath([]string{"PHP", "extension"})
+	tests := []struct {
+		name  string
+		value []any
+		want  string
+	}{
+		{
+			name:  "one value changed",
+			value: []any{"curl", "intl"},
+			want:  "extension=curl\nextension=intl\n",
+		},
+		{
+			name:  "value added after the last line",
+			value: []any{"curl", "mbstring", "intl"},
+			want:  "extension=curl\nextension=mbstring\nextension = intl\n",
+		},
+		{
+			name:  "value removed",
+			value: []any{"mbstring"},
+			want:  "extension=mbstring\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := deepCopyTree(t, h, tree)
+			if err := h.SetPath(tree, extensions, tt.value); err != nil {
+				t.Fatalf("SetPath() error = %v", err)
+			}
+			data, err := h.Serialize(tree, format.SerializeOptions{})
+			if err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+			want := strings.Replace(phpIniSnippet, "extension=curl\nextension=mbstring\n", tt.want, 1)
+			if string(data) != want {
+				t.Errorf("Serialize() =\n%s\nwant:\n%s", data, want)
+			}
+		})
+	}
+}
+
+// deepCopyTree returns a copy of tree made by a serialize and parse round
+// trip through h.
+func deepCopyTree(t *testing.T, h *Handler, tree any) any {
+	t.Helper()
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	copied, err := h.Parse(data, format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	return copied
+}
+
+func TestHandler_SectionComments(t *testing.T) {
+	h := New()
+
+	managed := `; Connection to the primary database
+; Credentials come from the environment
+[database]
+host = localhost
+port = 5432
+
+# HTTP server
+[server]
+port = 8080
+`
+	current := `[database]
+host = db.internal
+port = 5432
+
+[server]
+port = 8080
+
+; Added by the app
+[cache]
+size = 64
+`
+
+	managedTree, err := h.Parse([]byte(managed), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(managed) error = %v", err)
+	}
+	currentTree, err := h.Parse([]byte(current), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(current) error = %v", err)
+	}
+
+	// Unrelated key change, plus a section that only exists in current
+	host, _ := h.GetPath(currentTree, path.NewArrayPath([]string{"database", "host"}))
+	if err := h.SetPath(managedTree, path.NewArrayPath([]string{"database", "host"}), host); err != nil {
+		t.Fatal(err)
+	}
+	cache, _ := h.GetPath(currentTree, path.NewArrayPath([]string{"cache"}))
+	if err := h.SetPath(managedTree, path.NewArrayPath([]string{"cache"}), cache); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := h.Serialize(managedTree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	want := `; Connection to the primary database
+; Credentials come from the environment
+[database]
+host = db.internal
+port = 5432
+
+# HTTP server
+[server]
+port = 8080
+
+; Added by the app
+[cache]
+size = 64
+`
+	if string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", data, want)
+	}
+}
+
+func TestHandler_KeyComments(t *testing.T) {
+	h := New()
+
+	managed := `; Global settings
+editor = vim
+
+[user]
+; Shown in commit messages
+name = Jane
+# Must match the signing key
+email = jane@example.com
+; Dropped with its key
+legacy = true
+`
+	current := `editor = nano
+
+[user]
+name = Jane
+email = jane@work.example
+; Set by the app
+token = abc123
+`
+
+	managedTree, err := h.Parse([]byte(managed), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(managed) error = %v", err)
+	}
+	currentTree, err := h.Parse([]byte(current), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(current) error = %v", err)
+	}
+
+	for _, p := range [][]string{{"", "editor"}, {"user", "email"}, {"user", "token"}} {
+		val, _ := h.GetPath(currentTree, path.NewArrayPath(p))
+		if err := h.SetPath(managedTree, path.NewArrayPath(p), val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	user, _ := managedTree.(*orderedmap.OrderedMap).Get("user")
+	user.(*orderedmap.OrderedMap).Delete("legacy")
+
+	data, err := h.Serialize(managedTree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	want := `; Global settings
+editor = nano
+
+[user]
+; Shown in commit messages
+name  = Jane
+# Must match the signing key
+email = jane@work.example
+; Set by the app
+token = abc123
+`
+	if string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", data, want)
+	}
+}
+
+func TestHandler_Conformance(t *testing.T) {
+	formattest.RunConformance(t, New(), formattest.Fixtures{
+		Document: `[server]
+host = localhost
+
+[client]
+host = remote
+`,
+		OrderedKeys:     []string{"server", "client"},
+		LeafPath:        []string{"server", "host"},
+		LeafValue:       "localhost",
+		WildcardPath:    []string{"*", "host"},
+		WildcardMatches: [][]string{{"server", "host"}, {"client", "host"}},
+		DeepPath:        []string{"newsection", "key"},
+	})
+}
+
+const phpIniSnippet = `[PHP]
+
+;;;;;;;;;;;;;;;;;;;
+; Resource Limits ;
+;;;;;;;;;;;;;;;;;;;
+
+; Maximum amount of memory a script may consume
+memory_limit = 128M
+max_execution_time = 30
+
+; Dynamic extensions
+extension=curl
+extension=mbstring
+
+[Date]
+; Defines the default timezone used by the date functions
+date.timezone = UTC
+`
+
+func TestHandler_WithLayout_NoOpRoundTrip(t *testing.T) {
+	h := NewWithLayout()
+
+	tree, err := h.Parse([]byte(phpIniSnippet), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	if string(data) != phpIniSnippet {
+		t.Errorf("Serialize() changed layout:\ngot:\n%s\nwant:\n%s", data, phpIniSnippet)
+	}
+}
+
+func TestHandler_WithLayout_ChangedValueKeepsComments(t *testing.T) {
+	h := NewWithLayout()
+
+	managed, err := h.Parse([]byte(phpIniSnippet), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	current, err := h.Parse([]byte("[PHP]\nmemory_limit=512M\n"), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	p := path.NewArrayPath([]string{"PHP", "memory_limit"})
+	val, _ := h.GetPath(current, p)
+	if err := h.SetPath(managed, p, val); err != nil {
+		t.Fatalf("SetPath() error = %v", err)
+	}
+	if err := h.SetPath(managed, path.NewArrayPath([]string{"Date", "date.default_latitude"}), "31.7667"); err != nil {
+		t.Fatalf("SetPath() error = %v", err)
+	}
+
+	data, err := h.Serialize(managed, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	want := strings.Replace(phpIniSnippet, "memory_limit = 128M", "memory_limit = 512M", 1)
+	want = strings.Replace(want, "date.timezone = UTC\n", "date.timezone = UTC\ndate.default_latitude = 31.7667\n", 1)
+	if string(data) != want {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestHandler_WithLayout_NewAndRemovedSections(t *testing.T) {
+	h := NewWithLayout()
+
+	tree, err := h.Parse([]byte("; top comment\n[keep]\na = 1\n\n[drop]\n; about drop\nb = 2\n"), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	om := tree.(*orderedmap.OrderedMap)
+	om.Delete("drop")
+	added := orderedmap.New()
+	added.Set("c", "3")
+	om.Set("added", added)
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	want := "; top comment\n[keep]\na = 1\n\n[added]\nc = 3\n"
+	if string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", data, want)
+	}
+}
+
+func TestHandler_Serialize_BoolStyle(t *testing.T) {
+	input := "[app]\nenabled = true\ndebug = false\nname = truest\n"
+	for _, tt := range []struct {
+		style string
+		want  []string
+	}{
+		{"", []string{"enabled = true\n", "debug   = false\n"}},
+		{"yes", []string{"enabled = yes\n", "debug   = no\n", "name    = truest\n"}},
+		{"on", []string{"enabled = on\n", "debug   = off\n", "name    = truest\n"}},
+	} {
+		t.Run("plain "+tt.style, func(t *testing.T) {
+			h := New()
+			tree, err := h.Parse([]byte(input), format.ParseOptions{})
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if err := h.SetPath(tree, path.NewArrayPath([]string{"new", "flag"}), true); err != nil {
+				t.Fatalf("SetPath() error = %v", err)
+			}
+			data, err := h.Serialize(tree, format.SerializeOptions{BoolStyle: tt.style})
+			if err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+			want := append(tt.want, "flag = "+format.BoolText(true, tt.style)+"\n")
+			for _, w := range want {
+				if !strings.Contains(string(data), w) {
+					t.Errorf("Serialize() = %q, want containing %q", data, w)
+				}
+			}
+		})
+	}
+
+	// The layout keeps each line's spacing but respells its boolean
+	h := NewWithLayout()
+	tree, err := h.Parse([]byte("; App settings\n[app]\nenabled=true\ndebug = false\n"), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	data, err := h.Serialize(tree, format.SerializeOptions{BoolStyle: "yes"})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if want := "; App settings\n[app]\nenabled=yes\ndebug = no\n"; string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", data, want)
+	}
+}
+
+func TestHandler_KeepsCurrentSpelling(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		h    *Handler
+	}{
+		{"plain", New()},
+		{"layout", NewWithLayout()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			managed, err := tt.h.Parse([]byte("[app]\nname = default\nversion = 3.9\n"), format.ParseOptions{})
+			if err != nil {
+				t.Fatalf("Parse(managed) error = %v", err)
+			}
+			current, err := tt.h.Parse([]byte("[app]\nname = \"Jane Doe\"\nversion = 3.10\n"), format.ParseOptions{})
+			if err != nil {
+				t.Fatalf("Parse(current) error = %v", err)
+			}
+
+			for _, p := range [][]string{{"app", "name"}, {"app", "version"}} {
+				val, found := tt.h.GetPath(current, path.NewArrayPath(p))
+				if !found {
+					t.Fatalf("GetPath(%v) not found", p)
+				}
+				if err := tt.h.SetPath(managed, path.NewArrayPath(p), val); err != nil {
+					t.Fatalf("SetPath(%v) error = %v", p, err)
+				}
+			}
+			if val, _ := tt.h.GetPath(managed, path.NewArrayPath([]string{"app", "name"})); val != "Jane Doe" {
+				t.Errorf("GetPath(name) = %v, want unquoted %q", val, "Jane Doe")
+			}
+
+			data, err := tt.h.Serialize(managed, format.SerializeOptions{})
+			if err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+			for _, want := range []string{"= \"Jane Doe\"\n", "= 3.10\n"} {
+				if !strings.Contains(string(data), want) {
+					t.Errorf("Serialize() = %q, want containing %q", data, want)
+				}
+			}
+		})
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantLine int
+		wantCol  int
+	}{
+		{name: "unclosed section", input: "[a]\nx = 1\n  [broken\ny = 2\n", wantLine: 3, wantCol: 3},
+		{name: "missing delimiter", input: "[a]\nx = 1\njustakey\n", wantLine: 3, wantCol: 1},
+		{name: "unclosed key quote", input: "[a]\n\"x=1\n", wantLine: 2, wantCol: 1},
+		{name: "unclosed multi-line value", input: "[a]\nx = 1\ny = \"\"\"abc\n", wantLine: 3, wantCol: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Parse([]byte(tt.input), format.ParseOptions{})
+			if err == nil {
+				t.Fatal("Parse() expected error")
+			}
+			line, col := ErrorPosition([]byte(tt.input), err)
+			if line != tt.wantLine || col != tt.wantCol {
+				t.Errorf("ErrorPosition() = %d, %d; want %d, %d (error %v)", line, col, tt.wantLine, tt.wantCol, err)
+			}
+		})
+	}
+}
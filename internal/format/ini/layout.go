@@ -0,0 +1,209 @@
+package ini
+
+import (
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+)
+
+// layoutLine is one line of an original INI document.
+type layoutLine struct {
+	raw     string // Original text of the line
+	section string // Section the line belongs to ("" for global)
+	header  bool   // Line is a [section] header
+	key     string // Key name for key = value lines
+	value   string // Parsed value of the key when the layout was recorded
+	index   int    // Which line of a repeated key this is, from 0
+	last    bool   // Line is the key's last in its section
+}
+
+// layout records the vertical structure of an INI document (comments, blank
+// lines, key order) so Serialize can reproduce it.
+type layout struct {
+	lines []layoutLine
+}
+
+// recordLayout captures the line structure of data, using tree (the parsed
+// form of data) for key values.
+func recordLayout(data []byte, tree *orderedmap.OrderedMap) *layout {
+	l := &layout{}
+	section := ""
+	lastLine := make(map[[2]string]int) // Index in l.lines of each key's last line
+
+	for _, raw := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		raw = strings.TrimRight(raw, "\r")
+		line := layoutLine{raw: raw}
+		trimmed := strings.TrimSpace(raw)
+
+		switch {
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			section = strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			line.header = true
+		case trimmed == "" || strings.HasPrefix(trimmed, ";") || strings.HasPrefix(trimmed, "#"):
+			// Comment or blank line, kept verbatim
+		default:
+			if key, _, ok := strings.Cut(trimmed, "="); ok {
+				line.key = strings.TrimSpace(key)
+				if prev, ok := lastLine[[2]string{section, line.key}]; ok {
+					line.index = l.lines[prev].index + 1
+				}
+				lastLine[[2]string{section, line.key}] = len(l.lines)
+				if sectionMap := sectionOf(tree, section); sectionMap != nil {
+					if val, exists := sectionMap.Get(line.key); exists {
+						if vals := values(val); line.index < len(vals) {
+							line.value = toString(vals[line.index])
+						}
+					}
+				}
+			}
+		}
+
+		line.section = section
+		l.lines = append(l.lines, line)
+	}
+	for _, i := range lastLine {
+		l.lines[i].last = true
+	}
+
+	return l
+}
+
+// sectionOf returns the ordered map for a section, or nil.
+func sectionOf(tree *orderedmap.OrderedMap, name string) *orderedmap.OrderedMap {
+	val, ok := tree.Get(name)
+	if !ok {
+		return nil
+	}
+	return format.ToOrderedMapPtr(val)
+}
+
+// render writes tree following the recorded layout. Lines for keys and
+// sections still in tree are kept (verbatim when the value is unchanged),
+// lines for removed keys and sections are dropped, and keys or sections
+// not in the layout are appended to the end of their section or the file.
+// The nth line of a repeated key writes its nth value; values beyond the
+// recorded lines follow the key's last line, and lines beyond its values are
+// dropped. text converts a value to its text, and spell returns the text to
+// write for a changed or added value.
+func (l *layout) render(tree *orderedmap.OrderedMap, text func(v any) string, spell func(section, key, value string) string) string {
+	var out []string
+	written := make(map[string]map[string]bool)
+	sectionsSeen := make(map[string]bool)
+
+	markWritten := func(section, key string) {
+		if written[section] == nil {
+			written[section] = make(map[string]bool)
+		}
+		written[section][key] = true
+	}
+
+	// flushExtras appends keys of section that the layout didn't contain,
+	// placing them before the section's trailing blank lines.
+	flushExtras := func(section string) {
+		sectionMap := sectionOf(tree, section)
+		if sectionMap == nil {
+			return
+		}
+		var extras []string
+		for _, key := range sectionMap.Keys() {
+			if written[section][key] {
+				continue
+			}
+			val, _ := sectionMap.Get(key)
+			for _, v := range values(val) {
+				extras = append(extras, key+" = "+spell(section, key, text(v)))
+			}
+			markWritten(section, key)
+		}
+		if len(extras) == 0 {
+			return
+		}
+		insertAt := len(out)
+		for insertAt > 0 && strings.TrimSpace(out[insertAt-1]) == "" {
+			insertAt--
+		}
+		tail := append([]string{}, out[insertAt:]...)
+		out = append(append(out[:insertAt], extras...), tail...)
+	}
+
+	current := ""
+	for _, line := range l.lines {
+		if line.section != current {
+			flushExtras(current)
+			current = line.section
+		}
+
+		sectionMap := sectionOf(tree, line.section)
+		if sectionMap == nil && (line.section != "" || line.key != "") {
+			// Section was removed entirely
+			continue
+		}
+		if line.header {
+			sectionsSeen[line.section] = true
+			out = append(out, line.raw)
+			continue
+		}
+		if line.key == "" {
+			out = append(out, line.raw)
+			continue
+		}
+
+		val, exists := sectionMap.Get(line.key)
+		if !exists {
+			continue
+		}
+		vals := values(val)
+		if line.index < len(vals) {
+			if strVal := text(vals[line.index]); strVal == line.value {
+				// Unchanged (including repeated keys like php.ini's extension=)
+				out = append(out, line.raw)
+			} else {
+				out = append(out, rewriteValue(line.raw, spell(line.section, line.key, strVal)))
+			}
+		}
+		if line.last {
+			for _, v := range vals[min(line.index+1, len(vals)):] {
+				out = append(out, line.key+" = "+spell(line.section, line.key, text(v)))
+			}
+		}
+		markWritten(line.section, line.key)
+	}
+	flushExtras(current)
+
+	// Sections that weren't in the layout go at the end
+	for _, section := range tree.Keys() {
+		if section == "" || sectionsSeen[section] {
+			continue
+		}
+		sectionMap := sectionOf(tree, section)
+		if sectionMap == nil {
+			continue
+		}
+		if len(out) > 0 && strings.TrimSpace(out[len(out)-1]) != "" {
+			out = append(out, "")
+		}
+		out = append(out, "["+section+"]")
+		for _, key := range sectionMap.Keys() {
+			val, _ := sectionMap.Get(key)
+			for _, v := range values(val) {
+				out = append(out, key+" = "+spell(section, key, text(v)))
+			}
+		}
+	}
+
+	if len(out) == 0 {
+		return ""
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+// rewriteValue replaces the value part of a key = value line, keeping the
+// key and the spacing around "=".
+func rewriteValue(raw, value string) string {
+	idx := strings.Index(raw, "=")
+	prefix := raw[:idx+1]
+	rest := raw[idx+1:]
+	spacing := rest[:len(rest)-len(strings.TrimLeft(rest, " \t"))]
+	return prefix + spacing + value
+}
@@ -0,0 +1,211 @@
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// commentFrame is an open object or array while scanning for comments.
+type commentFrame struct {
+	object  bool
+	wantKey bool   // The next string in an object is a key
+	key     string // Last key read in an object
+	index   int    // Element index in an array
+}
+
+// recordComments remembers the comment lines directly above each object key
+// in data, keyed by the String() of the key's path. Comments after a value
+// on the same line, comments above array elements, and comments before a
+// closing } or ] are not kept. A path that already has comments keeps them,
+// so the first document parsed wins.
+func (h *Handler) recordComments(data []byte) {
+	var stack []*commentFrame
+	var pending []string
+	lineStart := true // Only whitespace since the last newline
+
+	segments := func() []string {
+		segs := make([]string, len(stack))
+		for i, f := range stack {
+			if f.object {
+				segs[i] = f.key
+			} else {
+				segs[i] = strconv.Itoa(f.index)
+			}
+		}
+		return segs
+	}
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '\n':
+			lineStart = true
+			continue
+
+		case c == ' ' || c == '\t' || c == '\r':
+			continue
+
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			end := bytes.IndexByte(data[i:], '\n')
+			if end < 0 {
+				end = len(data) - i
+			}
+			if lineStart {
+				pending = append(pending, string(bytes.TrimRight(data[i:i+end], " \t\r")))
+			}
+			i += end - 1
+			continue
+
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return
+			}
+			if lineStart {
+				for n, line := range strings.Split(string(data[i:i+2+end+2]), "\n") {
+					line = strings.TrimSpace(line)
+					if n > 0 && strings.HasPrefix(line, "*") {
+						// Keep the usual alignment of block comment continuations
+						line = " " + line
+					}
+					pending = append(pending, line)
+				}
+			}
+			i += 2 + end + 1
+			continue
+
+		case c == '"':
+			end := stringEnd(data, i)
+			var top *commentFrame
+			if len(stack) > 0 {
+				top = stack[len(stack)-1]
+			}
+			if top != nil && top.object && top.wantKey {
+				var key string
+				if err := json.Unmarshal(data[i:end], &key); err == nil {
+					top.key = key
+					if len(pending) > 0 {
+						h.addComment(path.NewArrayPath(segments()).String(), pending)
+					}
+				}
+				top.wantKey = false
+			}
+			i = end - 1
+
+		case c == '{' || c == '[':
+			stack = append(stack, &commentFrame{object: c == '{', wantKey: c == '{'})
+
+		case c == '}' || c == ']':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+
+		case c == ',':
+			if len(stack) > 0 {
+				if top := stack[len(stack)-1]; top.object {
+					top.wantKey = true
+				} else {
+					top.index++
+				}
+			}
+		}
+		pending = nil
+		lineStart = false
+	}
+}
+
+// stringEnd returns the index just past the string literal starting at
+// data[start], or len(data) if it isn't closed.
+func stringEnd(data []byte, start int) int {
+	for i := start + 1; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(data)
+}
+
+// addComment records lines as the comment above the key at pathKey, unless
+// it already has one.
+func (h *Handler) addComment(pathKey string, lines []string) {
+	if h.comments == nil {
+		h.comments = make(map[string][]string)
+	}
+	if _, seen := h.comments[pathKey]; !seen {
+		h.comments[pathKey] = lines
+	}
+}
+
+// writeWithComments writes tree as indented JSON like json.MarshalIndent,
+// with the recorded comments above their keys.
+func (h *Handler) writeWithComments(buf *bytes.Buffer, v any, segments []string, indent string) error {
+	prefix := strings.Repeat(indent, len(segments))
+
+	if om := format.ToOrderedMapPtr(v); om != nil {
+		keys := om.Keys()
+		if len(keys) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteString("{\n")
+		for n, key := range keys {
+			child := append(segments[:len(segments):len(segments)], key)
+			for _, line := range h.comments[path.NewArrayPath(child).String()] {
+				buf.WriteString(prefix + indent + line + "\n")
+			}
+			name, err := json.Marshal(key)
+			if err != nil {
+				return err
+			}
+			buf.WriteString(prefix + indent)
+			buf.Write(name)
+			buf.WriteString(": ")
+			val, _ := om.Get(key)
+			if err := h.writeWithComments(buf, val, child, indent); err != nil {
+				return err
+			}
+			if n < len(keys)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(prefix + "}")
+		return nil
+	}
+
+	if arr, ok := v.([]any); ok {
+		if len(arr) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[\n")
+		for n, elem := range arr {
+			buf.WriteString(prefix + indent)
+			child := append(segments[:len(segments):len(segments)], strconv.Itoa(n))
+			if err := h.writeWithComments(buf, elem, child, indent); err != nil {
+				return err
+			}
+			if n < len(arr)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(prefix + "]")
+		return nil
+	}
+
+	data, err := json.MarshalIndent(v, prefix, indent)
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
@@ -2,9 +2,11 @@
 package json
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"regexp"
+	"strconv"
 
 	"github.com/iancoleman/orderedmap"
 	"github.com/thirteen37/chezmoi-split/internal/format"
@@ -12,79 +14,258 @@ import (
 )
 
 // Handler implements format.Handler for JSON/JSONC files.
-type Handler struct{}
+//
+// With ParseOptions.KeepComments, Parse remembers the comment lines above
+// each object key, from the first document that has the key, and Serialize
+// writes them back. Parsing the managed template before the current file
+// makes the template's comments win for keys both have.
+type Handler struct {
+	comments map[string][]string // Key path's String() → comment lines
+	warnings []string            // Problems the last Parse recovered from
+}
 
 // New creates a new JSON handler.
 func New() *Handler {
 	return &Handler{}
 }
 
-// commentRegex matches single-line // comments.
-var commentRegex = regexp.MustCompile(`(?m)^\s*//.*$|//[^"]*$`)
-
-// StripComments removes single-line // comments from JSON.
-// This allows parsing JSONC (JSON with comments) files.
+// StripComments removes // and /* */ comments from JSON, leaving string
+// literals alone. This allows parsing JSONC (JSON with comments) files. A
+// line holding only a // comment keeps just its newline, and a block comment
+// keeps the newlines it spans, so line numbers don't change. An unterminated
+// block comment is left for the parser to reject.
 func StripComments(data []byte) []byte {
-	return commentRegex.ReplaceAll(data, nil)
+	out := make([]byte, 0, len(data))
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inString:
+			out = append(out, c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out = append(out, data[i])
+			} else if c == '"' {
+				inString = false
+			}
+
+		case c == '"':
+			inString = true
+			out = append(out, c)
+
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			// A comment alone on its line takes the indentation with it
+			lineStart := bytes.LastIndexByte(out, '\n') + 1
+			if len(bytes.TrimLeft(out[lineStart:], " \t")) == 0 {
+				out = out[:lineStart]
+			}
+			for i+1 < len(data) && data[i+1] != '\n' {
+				i++
+			}
+
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return append(out, data[i:]...)
+			}
+			out = append(out, bytes.Repeat([]byte("\n"), bytes.Count(data[i:i+2+end], []byte("\n")))...)
+			i += 2 + end + 1
+
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// stripTrailingCommas blanks out commas that only whitespace separates from
+// a closing } or ], as editors such as VS Code accept in JSONC. Commas in
+// string literals are left alone, and each removed comma becomes a space so
+// error offsets still match the input. Comments must already be stripped.
+func stripTrailingCommas(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inString := false
+	comma := -1 // Index in out of a comma not yet followed by a value
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inString:
+			if c == '\\' && i+1 < len(data) {
+				out = append(out, c)
+				i++
+				c = data[i]
+			} else if c == '"' {
+				inString = false
+			}
+
+		case c == '"':
+			inString = true
+			comma = -1
+
+		case c == ',':
+			comma = len(out)
+
+		case c == '}' || c == ']':
+			if comma >= 0 {
+				out[comma] = ' '
+			}
+			comma = -1
+
+		case c != ' ' && c != '\t' && c != '\r' && c != '\n':
+			comma = -1
+		}
+		out = append(out, c)
+	}
+	return out
 }
 
 // Parse reads JSON bytes and returns an *orderedmap.OrderedMap.
-// All nested objects are also converted to OrderedMaps to preserve key order.
+// All nested objects are also OrderedMaps to preserve key order, and numbers
+// are json.Number (see decodeValue).
+// A document that is a bare value (null, true, 42, "text") is returned as
+// that value; paths can't address into it. With opts.JSON5 the data is
+// rewritten by fromJSON5 first, which also handles comments. With
+// opts.StripComments, comments and trailing commas are removed.
+// opts.KeepComments implies StripComments, after recording the comments.
+// A key repeated in an object adds a warning naming its path, or fails the
+// parse with opts.StrictKeys.
 func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
-	if opts.StripComments {
-		data = StripComments(data)
+	h.warnings = nil
+	var offsets []int
+	switch {
+	case opts.JSON5:
+		var err error
+		if data, offsets, err = fromJSON5(data); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON5: %w", err)
+		}
+	case opts.KeepComments:
+		h.recordComments(data)
+		data = stripTrailingCommas(StripComments(data))
+	case opts.StripComments:
+		data = stripTrailingCommas(StripComments(data))
 	}
 
-	result := orderedmap.New()
-	if err := json.Unmarshal(data, result); err != nil {
+	// Syntax errors come from a full pass, which reports the offset
+	if err := json.Unmarshal(data, new(json.RawMessage)); err != nil {
+		// A JSON5 error points into the rewritten data; point it at the input
+		var syntaxErr *json.SyntaxError
+		if offsets != nil && errors.As(err, &syntaxErr) {
+			syntaxErr.Offset = sourceOffset(offsets, syntaxErr.Offset)
+		}
 		return nil, fmt.Errorf("failed to parse JSON: %w", err)
 	}
-	// Convert nested map[string]interface{} to *orderedmap.OrderedMap
-	return convertNestedMaps(result), nil
+	if trimmed := bytes.TrimSpace(data); trimmed[0] == '[' {
+		return nil, fmt.Errorf("failed to parse JSON: the document is an array, not an object")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	value, err := h.decodeValue(dec, nil, opts.StrictKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	return value, nil
 }
 
-// convertNestedMaps recursively processes nested maps to ensure they're all OrderedMaps.
-// The orderedmap library already handles this during unmarshal, but we process arrays too.
-func convertNestedMaps(v any) any {
-	switch val := v.(type) {
-	case *orderedmap.OrderedMap:
-		for _, k := range val.Keys() {
-			v, _ := val.Get(k)
-			val.Set(k, convertNestedMaps(v))
-		}
-		return val
-	case orderedmap.OrderedMap:
-		for _, k := range val.Keys() {
-			v, _ := val.Get(k)
-			val.Set(k, convertNestedMaps(v))
-		}
-		return val
-	case []interface{}:
-		for i, v := range val {
-			val[i] = convertNestedMaps(v)
-		}
-		return val
-	default:
-		return val
+// Warnings returns the repeated keys found by the last Parse.
+func (h *Handler) Warnings() []string {
+	return h.warnings
+}
+
+// decodeValue reads the next JSON value from dec, found at segments. Objects
+// become *orderedmap.OrderedMap, keeping key order; a repeated key takes the
+// position and value of its last occurrence, as with encoding/json, and is
+// reported as a warning, or as an error if strict. With dec.UseNumber,
+// numbers stay json.Number, so Serialize writes each one as it was written
+// (9007199254740993, 1e3, -0) rather than as a float64.
+func (h *Handler) decodeValue(dec *json.Decoder, segments []string, strict bool) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+
+	switch delim {
+	case '{':
+		om := orderedmap.New()
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, _ := keyTok.(string)
+			child := append(segments[:len(segments):len(segments)], key)
+			val, err := h.decodeValue(dec, child, strict)
+			if err != nil {
+				return nil, err
+			}
+			if _, exists := om.Get(key); exists {
+				msg := fmt.Sprintf("duplicate key %s", path.NewArrayPath(child))
+				if strict {
+					return nil, errors.New(msg)
+				}
+				h.warnings = append(h.warnings, msg+", keeping the last value")
+				om.Delete(key)
+			}
+			om.Set(key, val)
+		}
+		_, err := dec.Token() // }
+		return om, err
+	default: // [
+		arr := []any{}
+		for i := 0; dec.More(); i++ {
+			val, err := h.decodeValue(dec, append(segments[:len(segments):len(segments)], strconv.Itoa(i)), strict)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, val)
+		}
+		_, err := dec.Token() // ]
+		return arr, err
 	}
 }
 
-// Serialize writes the tree to formatted JSON bytes.
+// Serialize writes the tree to formatted JSON bytes, with any comments
+// recorded by Parse above their keys. With opts.Compact, the output is
+// json.Marshal's, on one line with no comments.
 func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, error) {
 	indent := opts.Indent
 	if indent == "" {
-		indent = "  "
+		indent = DefaultIndent
+	}
+
+	if opts.NullStyle == "omit" {
+		tree = format.OmitNulls(tree)
 	}
 
-	data, err := json.MarshalIndent(tree, "", indent)
+	var data []byte
+	var err error
+	switch {
+	case opts.Compact:
+		data, err = json.Marshal(tree)
+	case len(h.comments) > 0:
+		var buf bytes.Buffer
+		err = h.writeWithComments(&buf, tree, nil, indent)
+		data = buf.Bytes()
+	default:
+		data, err = json.MarshalIndent(tree, "", indent)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to serialize JSON: %w", err)
 	}
+	if opts.OmitFinalNewline {
+		return data, nil
+	}
 	// Add trailing newline
 	return append(data, '\n'), nil
 }
 
 // GetPath extracts a value at the given path, supporting wildcards.
+// With "**", the first match in document order is returned. A numeric
+// segment indexes into an array; an out-of-range index matches nothing.
 func (h *Handler) GetPath(tree any, p path.Path) (any, bool) {
 	return getPathWithWildcard(tree, p.Segments(), 0)
 }
@@ -96,11 +277,42 @@ func getPathWithWildcard(current any, segments []string, idx int) (any, bool) {
 	}
 
 	segment := segments[idx]
+
+	// A numeric segment indexes into an array. "**" only descends through
+	// maps, so at an array it matches zero levels.
+	if arr, ok := current.([]any); ok {
+		if segment == path.RecursiveWildcard && idx < len(segments)-1 {
+			return getPathWithWildcard(arr, segments, idx+1)
+		}
+		i, ok := format.ArrayIndex(segment, len(arr))
+		if !ok {
+			return nil, false
+		}
+		return getPathWithWildcard(arr[i], segments, idx+1)
+	}
+
 	om := format.ToOrderedMapPtr(current)
 	if om == nil {
 		return nil, false
 	}
 
+	if segment == path.RecursiveWildcard {
+		// Recursive wildcard: match at this level first, then at any depth below
+		if idx == len(segments)-1 {
+			return nil, false
+		}
+		if result, ok := getPathWithWildcard(om, segments, idx+1); ok {
+			return result, true
+		}
+		for _, key := range om.Keys() {
+			val, _ := om.Get(key)
+			if result, ok := getPathWithWildcard(val, segments, idx); ok {
+				return result, true
+			}
+		}
+		return nil, false
+	}
+
 	if segment == "*" {
 		// Wildcard: return first match from any key
 		for _, key := range om.Keys() {
@@ -120,13 +332,19 @@ func getPathWithWildcard(current any, segments []string, idx int) (any, bool) {
 }
 
 // SetPath sets a value at the given path, supporting wildcards.
-// Creates intermediate maps as needed.
+// With "**", every existing match is set.
+// Creates intermediate maps as needed; array indexes must already exist.
 func (h *Handler) SetPath(tree any, p path.Path, value any) error {
 	segments := p.Segments()
 	if len(segments) == 0 {
 		return fmt.Errorf("empty path")
 	}
 
+	value, err := format.NormalizeForFormat(value, "json")
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %w", p, err)
+	}
+
 	return setPathWithWildcard(tree, segments, 0, value)
 }
 
@@ -136,13 +354,41 @@ func setPathWithWildcard(current any, segments []string, idx int, value any) err
 		return nil
 	}
 
+	segment := segments[idx]
+	isLast := idx == len(segments)-1
+
+	// A numeric segment indexes into an existing array element
+	if arr, ok := current.([]any); ok {
+		if segment == path.RecursiveWildcard {
+			for _, keys := range format.ExpandPath(arr, segments[idx:]) {
+				_ = setPathWithWildcard(arr, keys, 0, value)
+			}
+			return nil
+		}
+		i, ok := format.ArrayIndex(segment, len(arr))
+		if !ok {
+			return fmt.Errorf("array index %q out of range (length %d)", segment, len(arr))
+		}
+		if isLast {
+			arr[i] = value
+			return nil
+		}
+		return setPathWithWildcard(arr[i], segments, idx+1, value)
+	}
+
 	om := format.ToOrderedMapPtr(current)
 	if om == nil {
 		return fmt.Errorf("cannot navigate into non-map value")
 	}
 
-	segment := segments[idx]
-	isLast := idx == len(segments)-1
+	if segment == path.RecursiveWildcard {
+		// Recursive wildcard: apply to every existing match, never create keys
+		for _, keys := range format.ExpandPath(om, segments[idx:]) {
+			// Matches already exist, so setting them can't fail
+			_ = setPathWithWildcard(om, keys, 0, value)
+		}
+		return nil
+	}
 
 	if segment == "*" {
 		// Wildcard: apply to all keys
@@ -172,13 +418,29 @@ func setPathWithWildcard(current any, segments []string, idx int, value any) err
 		om.Set(segment, next)
 	}
 
+	if _, isArr := next.([]any); isArr {
+		return setPathWithWildcard(next, segments, idx+1, value)
+	}
 	nextMap := format.ToOrderedMapPtr(next)
 	if nextMap == nil {
-		return fmt.Errorf("path segment %q is not a map", segment)
+		return fmt.Errorf("path segment %q is not a map or array", segment)
 	}
 
 	return setPathWithWildcard(nextMap, segments, idx+1, value)
 }
 
-// Ensure Handler implements format.Handler.
-var _ format.Handler = (*Handler)(nil)
+// DeletePath removes the value at the given path. Wildcards delete every
+// match, and an array element is removed from its array.
+func (h *Handler) DeletePath(tree any, p path.Path) error {
+	segments := p.Segments()
+	if len(segments) == 0 {
+		return fmt.Errorf("empty path")
+	}
+	return format.DeleteMatches(tree, segments)
+}
+
+// Ensure Handler implements format.Handler and format.Warner.
+var (
+	_ format.Handler = (*Handler)(nil)
+	_ format.Warner  = (*Handler)(nil)
+)
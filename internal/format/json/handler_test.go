@@ -1,10 +1,15 @@
 package json
 
 import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/iancoleman/orderedmap"
 	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/formattest"
 	"github.com/thirteen37/chezmoi-split/internal/path"
 )
 
@@ -34,6 +39,46 @@ func TestStripComments(t *testing.T) {
 			input: "  // comment\n{\"key\": \"value\"}",
 			want:  "\n{\"key\": \"value\"}",
 		},
+		{
+			name:  "block comment",
+			input: "{\"key\": /* inline */ \"value\"}",
+			want:  "{\"key\":  \"value\"}",
+		},
+		{
+			name:  "multi-line block comment",
+			input: "{\n  /*\n   * Editor settings\n   */\n  \"key\": \"value\"\n}",
+			want:  "{\n  \n\n\n  \"key\": \"value\"\n}",
+		},
+		{
+			name:  "url in string",
+			input: "{\"url\": \"http://example.com\"} // comment",
+			want:  "{\"url\": \"http://example.com\"} ",
+		},
+		{
+			name:  "url alone on its line",
+			input: "{\n  \"url\": \"https://x.com\"\n}",
+			want:  "{\n  \"url\": \"https://x.com\"\n}",
+		},
+		{
+			name:  "url with comment after the value",
+			input: "{\n  \"url\": \"https://x.com\", // homepage\n  \"api\": \"https://x.com/v1\" // no quote after\n}",
+			want:  "{\n  \"url\": \"https://x.com\", \n  \"api\": \"https://x.com/v1\" \n}",
+		},
+		{
+			name:  "comment markers in strings",
+			input: `{"glob": "src/**/*.go", "quote": "a \"// b\" /* c"}`,
+			want:  `{"glob": "src/**/*.go", "quote": "a \"// b\" /* c"}`,
+		},
+		{
+			name:  "block marker inside line comment",
+			input: "// old /* setting\n{\"key\": \"value\"}",
+			want:  "\n{\"key\": \"value\"}",
+		},
+		{
+			name:  "unterminated block comment",
+			input: "{} /* open",
+			want:  "{} /* open",
+		},
 	}
 
 	for _, tt := range tests {
@@ -46,6 +91,54 @@ func TestStripComments(t *testing.T) {
 	}
 }
 
+func TestStripTrailingCommas(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "object",
+			input: `{"a": 1, "b": 2,}`,
+			want:  `{"a": 1, "b": 2 }`,
+		},
+		{
+			name:  "array across lines",
+			input: "[\n  1,\n  2,\n]",
+			want:  "[\n  1,\n  2 \n]",
+		},
+		{
+			name:  "nested",
+			input: `{"a": [1, {"b": 2,},], }`,
+			want:  `{"a": [1, {"b": 2 } ]  }`,
+		},
+		{
+			name:  "comma in string",
+			input: `{"a": "x,}", "b": "y\",]"}`,
+			want:  `{"a": "x,}", "b": "y\",]"}`,
+		},
+		{
+			name:  "comma before string kept",
+			input: `["x", "}"]`,
+			want:  `["x", "}"]`,
+		},
+		{
+			name:  "no trailing comma",
+			input: `{"a": [1, 2]}`,
+			want:  `{"a": [1, 2]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(stripTrailingCommas([]byte(tt.input)))
+			if got != tt.want {
+				t.Errorf("stripTrailingCommas() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestHandler_Parse(t *testing.T) {
 	h := New()
 
@@ -72,6 +165,23 @@ func TestHandler_Parse(t *testing.T) {
 			opts:     format.ParseOptions{StripComments: true},
 			wantKeys: []string{"key"},
 		},
+		{
+			name:     "jsonc with trailing commas",
+			input:    "{\n  \"key\": \"value\", // last\n  \"list\": [1, 2,],\n}",
+			opts:     format.ParseOptions{StripComments: true},
+			wantKeys: []string{"key", "list"},
+		},
+		{
+			name:    "trailing comma without strip-comments",
+			input:   `{"key": "value",}`,
+			wantErr: true,
+		},
+		{
+			name:     "jsonc with block comments",
+			input:    "/*\n * Settings\n */\n{\n  \"glob\": \"src/*.go\", /* files */\n  \"note\": \"a /* b\"\n}",
+			opts:     format.ParseOptions{StripComments: true},
+			wantKeys: []string{"glob", "note"},
+		},
 		{
 			name:    "invalid json",
 			input:   `{invalid}`,
@@ -107,6 +217,223 @@ func TestHandler_Parse(t *testing.T) {
 	}
 }
 
+func TestHandler_Parse_BareValue(t *testing.T) {
+	h := New()
+
+	tests := []struct {
+		input string
+		want  any
+	}{
+		{"null", nil},
+		{"true\n", true},
+		{"  42\n", json.Number("42")},
+		{`"text"`, "text"},
+	}
+	for _, tt := range tests {
+		got, err := h.Parse([]byte(tt.input), format.ParseOptions{})
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %#v, want %#v", tt.input, got, tt.want)
+		}
+
+		// Paths can't address into a bare value
+		if _, ok := h.GetPath(got, path.NewArrayPath([]string{"key"})); ok {
+			t.Errorf("GetPath(%q, key) found a value", tt.input)
+		}
+		if err := h.SetPath(got, path.NewArrayPath([]string{"key"}), "v"); err == nil {
+			t.Errorf("SetPath(%q, key) should fail", tt.input)
+		}
+
+		data, err := h.Serialize(got, format.SerializeOptions{})
+		if err != nil {
+			t.Fatalf("Serialize(%#v) error = %v", got, err)
+		}
+		if want := strings.TrimSpace(tt.input) + "\n"; string(data) != want {
+			t.Errorf("Serialize(%#v) = %q, want %q", got, data, want)
+		}
+	}
+
+	if _, err := h.Parse([]byte("nul"), format.ParseOptions{}); err == nil {
+		t.Error("Parse(nul) should fail")
+	}
+}
+
+func TestHandler_Numbers(t *testing.T) {
+	h := New()
+	// Numbers come back exactly as written, not through float64
+	input := `{
+  "id": 9007199254740993,
+  "port": 8080,
+  "ratio": 1.50,
+  "zero": -0,
+  "big": 1e400,
+  "small": 2.5E-3,
+  "list": [
+    1,
+    1.0,
+    -12345678901234567890
+  ]
+}
+`
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if v, _ := h.GetPath(tree, path.NewArrayPath([]string{"id"})); v != json.Number("9007199254740993") {
+		t.Errorf("id = %#v, want json.Number", v)
+	}
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if string(data) != input {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, input)
+	}
+}
+
+func TestHandler_Parse_Errors(t *testing.T) {
+	h := New()
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`[1, 2]`, "the document is an array, not an object"},
+		{`{"a": 1} x`, "invalid character 'x' after top-level value"},
+		{`{"a": }`, "invalid character '}' looking for beginning of value"},
+		{``, "unexpected end of JSON input"},
+	}
+	for _, tt := range tests {
+		if _, err := h.Parse([]byte(tt.input), format.ParseOptions{}); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want containing %q", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestHandler_Parse_DuplicateKeys(t *testing.T) {
+	h := New()
+	data := []byte(`{
+  "servers": {"web": {"port": 80, "host": "a", "port": 8080}},
+  "list": [{}, {"x": 1, "x": 2}],
+  "name": "a",
+  "name": "b"
+}`)
+	tree, err := h.Parse(data, format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := []string{
+		`duplicate key ["servers","web","port"], keeping the last value`,
+		`duplicate key ["list","1","x"], keeping the last value`,
+		`duplicate key ["name"], keeping the last value`,
+	}
+	if !reflect.DeepEqual(h.Warnings(), want) {
+		t.Errorf("Warnings() = %q, want %q", h.Warnings(), want)
+	}
+
+	// The last value wins, in its own position
+	web, _ := h.GetPath(tree, path.NewArrayPath([]string{"servers", "web"}))
+	if keys := web.(*orderedmap.OrderedMap).Keys(); !reflect.DeepEqual(keys, []string{"host", "port"}) {
+		t.Errorf("web keys = %v, want [host port]", keys)
+	}
+	if v, _ := h.GetPath(tree, path.NewArrayPath([]string{"servers", "web", "port"})); v != json.Number("8080") {
+		t.Errorf("port = %v, want 8080", v)
+	}
+
+	// Each Parse starts over
+	if _, err := h.Parse([]byte(`{"a": 1}`), format.ParseOptions{}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(h.Warnings()) != 0 {
+		t.Errorf("Warnings() = %q, want none", h.Warnings())
+	}
+
+	_, err = h.Parse(data, format.ParseOptions{StrictKeys: true})
+	if err == nil || !strings.Contains(err.Error(), `duplicate key ["servers","web","port"]`) {
+		t.Errorf("Parse(StrictKeys) error = %v, want a duplicate key error", err)
+	}
+}
+
+func TestHandler_Parse_JSON5(t *testing.T) {
+	h := New()
+
+	input := `// Written by the app
+{
+  /* window
+     state */
+  window: {width: 800, height: 600,},
+  'theme': 'dark "solarized"',
+  url: "https://example.com", // not a comment inside the string
+  $recent: ['a.txt', 'it\'s.txt',],
+}
+`
+	tree, err := h.Parse([]byte(input), format.ParseOptions{JSON5: true})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := `{
+  "window": {
+    "width": 800,
+    "height": 600
+  },
+  "theme": "dark \"solarized\"",
+  "url": "https://example.com",
+  "$recent": [
+    "a.txt",
+    "it's.txt"
+  ]
+}
+`
+	if string(data) != want {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestHandler_Parse_JSON5Errors(t *testing.T) {
+	h := New()
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"unterminated comment", "{/* open", "unterminated /* comment"},
+		{"unterminated string", "{'key: 1}", "unterminated string"},
+		{"unsupported extension", "{size: 0x10}", "failed to parse JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := h.Parse([]byte(tt.input), format.ParseOptions{JSON5: true})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+
+	// Without the option, JSON5 syntax is rejected
+	if _, err := h.Parse([]byte("{a: 1,}"), format.ParseOptions{}); err == nil {
+		t.Error("Parse() without JSON5 should fail")
+	}
+
+	// Syntax error offsets point into the input, not the rewritten JSON
+	input := "{\n  // sizes\n  name: 'x',\n  size: +1.5,\n}\n"
+	_, err := h.Parse([]byte(input), format.ParseOptions{JSON5: true})
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("Parse() error = %v, want a *json.SyntaxError", err)
+	}
+	if want := int64(strings.Index(input, "+") + 1); syntaxErr.Offset != want {
+		t.Errorf("Offset = %d, want %d", syntaxErr.Offset, want)
+	}
+}
+
 func TestHandler_GetPath(t *testing.T) {
 	h := New()
 
@@ -226,6 +553,174 @@ func TestHandler_SetPath(t *testing.T) {
 	})
 }
 
+func TestHandler_ArrayIndex(t *testing.T) {
+	h := New()
+
+	tree, err := h.Parse([]byte(`{
+  "keybindings": [
+    {"keys": "ctrl-a", "command": "select_all"},
+    {"keys": "ctrl-s", "command": "save"}
+  ],
+  "0": {"key": "map key"}
+}`), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	getTests := []struct {
+		name      string
+		path      []string
+		wantVal   any
+		wantFound bool
+	}{
+		{"element field", []string{"keybindings", "1", "keys"}, "ctrl-s", true},
+		{"numeric map key", []string{"0", "key"}, "map key", true},
+		{"out of range", []string{"keybindings", "2", "keys"}, nil, false},
+		{"negative index", []string{"keybindings", "-1", "keys"}, nil, false},
+		{"not a number", []string{"keybindings", "first", "keys"}, nil, false},
+	}
+	for _, tt := range getTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := h.GetPath(tree, path.NewArrayPath(tt.path))
+			if found != tt.wantFound {
+				t.Errorf("GetPath() found = %v, want %v", found, tt.wantFound)
+			}
+			if tt.wantFound && got != tt.wantVal {
+				t.Errorf("GetPath() = %v, want %v", got, tt.wantVal)
+			}
+		})
+	}
+
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"keybindings", "0", "keys"}), "cmd-a"); err != nil {
+		t.Fatalf("SetPath() error = %v", err)
+	}
+	if got, _ := h.GetPath(tree, path.NewArrayPath([]string{"keybindings", "0", "keys"})); got != "cmd-a" {
+		t.Errorf("after SetPath() keys = %v, want cmd-a", got)
+	}
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"keybindings", "5", "keys"}), "x"); err == nil {
+		t.Error("SetPath() with an out-of-range index should return an error")
+	}
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"keybindings", "1"}), "replaced"); err != nil {
+		t.Fatalf("SetPath() on an element error = %v", err)
+	}
+	if got, _ := h.GetPath(tree, path.NewArrayPath([]string{"keybindings", "1"})); got != "replaced" {
+		t.Errorf("after SetPath() element = %v, want replaced", got)
+	}
+}
+
+func TestHandler_RecursiveWildcard(t *testing.T) {
+	h := New()
+
+	parse := func(t *testing.T) any {
+		t.Helper()
+		tree, err := h.Parse([]byte(`{
+  "telemetry": {"enabled": true},
+  "editor": {"fontSize": 12, "telemetry": {"enabled": false}},
+  "extensions": {"python": {"telemetry": {"enabled": true}}}
+}`), format.ParseOptions{})
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		return tree
+	}
+
+	t.Run("get returns first match", func(t *testing.T) {
+		tree := parse(t)
+		got, found := h.GetPath(tree, path.NewArrayPath([]string{"**", "telemetry", "enabled"}))
+		if !found || got != true {
+			t.Errorf("GetPath() = %v, %v, want true, true", got, found)
+		}
+	})
+
+	t.Run("get below a key", func(t *testing.T) {
+		tree := parse(t)
+		got, found := h.GetPath(tree, path.NewArrayPath([]string{"extensions", "**", "enabled"}))
+		if !found || got != true {
+			t.Errorf("GetPath() = %v, %v, want true, true", got, found)
+		}
+	})
+
+	t.Run("set applies to all depths", func(t *testing.T) {
+		tree := parse(t)
+		if err := h.SetPath(tree, path.NewArrayPath([]string{"**", "enabled"}), "off"); err != nil {
+			t.Fatalf("SetPath() error = %v", err)
+		}
+		for _, segs := range [][]string{
+			{"telemetry", "enabled"},
+			{"editor", "telemetry", "enabled"},
+			{"extensions", "python", "telemetry", "enabled"},
+		} {
+			got, _ := h.GetPath(tree, path.NewArrayPath(segs))
+			if got != "off" {
+				t.Errorf("%v = %v, want off", segs, got)
+			}
+		}
+		got, _ := h.GetPath(tree, path.NewArrayPath([]string{"editor", "fontSize"}))
+		if got != json.Number("12") {
+			t.Errorf("editor.fontSize = %v, want 12 (unchanged)", got)
+		}
+	})
+
+	t.Run("set replaces outermost match only", func(t *testing.T) {
+		tree := parse(t)
+		if err := h.SetPath(tree, path.NewArrayPath([]string{"**", "telemetry"}), "gone"); err != nil {
+			t.Fatalf("SetPath() error = %v", err)
+		}
+		for _, segs := range [][]string{
+			{"telemetry"},
+			{"editor", "telemetry"},
+			{"extensions", "python", "telemetry"},
+		} {
+			got, _ := h.GetPath(tree, path.NewArrayPath(segs))
+			if got != "gone" {
+				t.Errorf("%v = %v, want gone", segs, got)
+			}
+		}
+	})
+
+	t.Run("set does not create keys", func(t *testing.T) {
+		tree := parse(t)
+		if err := h.SetPath(tree, path.NewArrayPath([]string{"**", "missing"}), 1); err != nil {
+			t.Fatalf("SetPath() error = %v", err)
+		}
+		if _, found := h.GetPath(tree, path.NewArrayPath([]string{"missing"})); found {
+			t.Error("SetPath() with ** should not create keys")
+		}
+	})
+}
+
+func TestDetectIndent(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"two spaces", "{\n  \"a\": {\n    \"b\": 1\n  }\n}\n", "  "},
+		{"four spaces", "{\n    \"a\": {\n        \"b\": 1\n    }\n}\n", "    "},
+		{"tabs", "{\n\t\"a\": {\n\t\t\"b\": 1\n\t}\n}\n", "\t"},
+		{"block comment banner", "/*\n * settings\n */\n{\n    \"a\": 1\n}\n", "    "},
+		{"indented line comment", "{\n\t// note\n\t\"a\": 1\n}\n", "\t"},
+		{"minified", `{"a":{"b":[1,2]}}`, DefaultIndent},
+		{"empty", "", DefaultIndent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectIndent([]byte(tt.data)); got != tt.want {
+				t.Errorf("DetectIndent() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// The first indented document wins
+	minified, tabs, fourSpaces := []byte(`{"a":1}`), []byte("{\n\t\"a\": 1\n}"), []byte("{\n    \"a\": 1\n}")
+	if got := DetectIndent(minified, tabs, fourSpaces); got != "\t" {
+		t.Errorf("DetectIndent(minified, tabs, four spaces) = %q, want a tab", got)
+	}
+	if got := DetectIndent(fourSpaces, tabs); got != "    " {
+		t.Errorf("DetectIndent(four spaces, tabs) = %q, want four spaces", got)
+	}
+}
+
 func TestHandler_Serialize_PreservesOrder(t *testing.T) {
 	h := New()
 
@@ -247,6 +742,57 @@ func TestHandler_Serialize_PreservesOrder(t *testing.T) {
 	}
 }
 
+func TestHandler_Serialize_OmitNulls(t *testing.T) {
+	h := New()
+	tree, err := h.Parse([]byte(`{"a": null, "b": {"c": null, "d": 1}, "e": [null, 2]}`), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{NullStyle: "omit"})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	// Nulls in arrays hold their element's place, so they stay
+	want := "{\n  \"b\": {\n    \"d\": 1\n  },\n  \"e\": [\n    null,\n    2\n  ]\n}\n"
+	if string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", data, want)
+	}
+
+	// The tree itself is unchanged
+	data, _ = h.Serialize(tree, format.SerializeOptions{NullStyle: "null"})
+	if !strings.Contains(string(data), `"a": null`) {
+		t.Errorf("Serialize(null) = %q, want the null kept", data)
+	}
+}
+
+func TestHandler_Serialize_Compact(t *testing.T) {
+	h := New()
+	tree, err := h.Parse([]byte("{\n  // Theme\n  \"zebra\": [1, 2],\n  \"apple\": {\"b\": \"x y\", \"a\": null}\n}"), format.ParseOptions{KeepComments: true})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{Compact: true, Indent: "\t"})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	// Key order is kept; indentation and comments are not
+	want := `{"zebra":[1,2],"apple":{"b":"x y","a":null}}` + "\n"
+	if string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", data, want)
+	}
+
+	data, _ = h.Serialize(tree, format.SerializeOptions{Compact: true, OmitFinalNewline: true})
+	if string(data) != strings.TrimSuffix(want, "\n") {
+		t.Errorf("Serialize(OmitFinalNewline) = %q, want no final newline", data)
+	}
+	data, _ = h.Serialize(tree, format.SerializeOptions{OmitFinalNewline: true})
+	if !strings.HasSuffix(string(data), "}") {
+		t.Errorf("Serialize(OmitFinalNewline) = %q, want no final newline", data)
+	}
+}
+
 func TestHandler_ParseAndSerialize_PreservesOrder(t *testing.T) {
 	h := New()
 
@@ -269,3 +815,120 @@ func TestHandler_ParseAndSerialize_PreservesOrder(t *testing.T) {
 		t.Errorf("ParseAndSerialize() = %q, want %q", string(data), want)
 	}
 }
+
+func TestHandler_KeepComments_RoundTrip(t *testing.T) {
+	h := New()
+	input := `// File header, not attached to a key
+{
+  // Color theme
+  "theme": "dark",
+  "editor": {
+    "fontSize": 12, // trailing comments are dropped
+    /*
+     * Tab width in spaces
+     */
+    "tabSize": 2,
+    "rulers": [80, 120],
+  },
+  // Enabled plugins
+  "plugins": [
+    {
+      // Plugin id
+      "id": "git",
+    },
+  ],
+}
+`
+	tree, err := h.Parse([]byte(input), format.ParseOptions{KeepComments: true})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := `{
+  // Color theme
+  "theme": "dark",
+  "editor": {
+    "fontSize": 12,
+    /*
+     * Tab width in spaces
+     */
+    "tabSize": 2,
+    "rulers": [
+      80,
+      120
+    ]
+  },
+  // Enabled plugins
+  "plugins": [
+    {
+      // Plugin id
+      "id": "git"
+    }
+  ]
+}
+`
+	if string(data) != want {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, want)
+	}
+
+	// The output reads back to the same comments and document
+	again, err := New().Parse(data, format.ParseOptions{KeepComments: true})
+	if err != nil {
+		t.Fatalf("Parse(output) error = %v", err)
+	}
+	if data2, _ := h.Serialize(again, format.SerializeOptions{}); string(data2) != want {
+		t.Errorf("second Serialize() =\n%s\nwant:\n%s", data2, want)
+	}
+}
+
+func TestHandler_KeepComments_FirstDocumentWins(t *testing.T) {
+	h := New()
+	managed, err := h.Parse([]byte("{\n  // From the template\n  \"a\": 1\n}"), format.ParseOptions{KeepComments: true})
+	if err != nil {
+		t.Fatalf("Parse(managed) error = %v", err)
+	}
+	current, err := h.Parse([]byte("{\n  // From the app\n  \"a\": 2,\n  // Added by the app\n  \"b\": \"<x>\"\n}"), format.ParseOptions{KeepComments: true})
+	if err != nil {
+		t.Fatalf("Parse(current) error = %v", err)
+	}
+	val, _ := current.(*orderedmap.OrderedMap).Get("b")
+	managed.(*orderedmap.OrderedMap).Set("b", val)
+
+	data, err := h.Serialize(managed, format.SerializeOptions{Indent: "\t"})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := "{\n\t// From the template\n\t\"a\": 1,\n\t// Added by the app\n\t\"b\": \"\\u003cx\\u003e\"\n}\n"
+	if string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", data, want)
+	}
+}
+
+func TestHandler_Conformance(t *testing.T) {
+	formattest.RunConformance(t, New(), formattest.Fixtures{
+		Document: `{
+  "zebra": "z",
+  "servers": {
+    "a": {
+      "enabled": true
+    },
+    "b": {
+      "enabled": false
+    }
+  },
+  "apple": "a"
+}
+`,
+		RoundTripExact:  true,
+		OrderedKeys:     []string{"zebra", "servers", "apple"},
+		LeafPath:        []string{"servers", "a", "enabled"},
+		LeafValue:       true,
+		WildcardPath:    []string{"servers", "*", "enabled"},
+		WildcardMatches: [][]string{{"servers", "a", "enabled"}, {"servers", "b", "enabled"}},
+		DeepPath:        []string{"new", "nested", "key"},
+	})
+}
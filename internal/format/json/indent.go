@@ -0,0 +1,20 @@
+package json
+
+import "github.com/thirteen37/chezmoi-split/internal/format"
+
+// DefaultIndent is one level of indentation when Serialize isn't given one.
+const DefaultIndent = "  "
+
+// DetectIndent returns one level of the indentation used in the first of
+// docs that is indented: the leading whitespace of its first indented line,
+// usually two or four spaces or a tab. Comments are skipped, so the " * "
+// lines of a block comment banner don't count. If every document is empty
+// or minified, it returns DefaultIndent.
+func DetectIndent(docs ...[]byte) string {
+	for _, data := range docs {
+		if indent := format.DetectIndent(StripComments(data)); indent != "" {
+			return indent
+		}
+	}
+	return DefaultIndent
+}
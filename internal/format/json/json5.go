@@ -0,0 +1,156 @@
+package json
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// fromJSON5 rewrites the JSON5 subset that apps write by hand into standard
+// JSON: // and /* */ comments are removed, single-quoted strings are
+// double-quoted, unquoted object keys are quoted, and trailing commas before
+// } or ] are dropped. Other JSON5 extensions, such as hex numbers and
+// Infinity, are passed through and rejected by the JSON parser. The rewrite
+// moves bytes around, so it also returns, for each byte of the output, the
+// offset in data it came from (see sourceOffset).
+func fromJSON5(data []byte) ([]byte, []int, error) {
+	w := &json5Writer{out: make([]byte, 0, len(data)), offsets: make([]int, 0, len(data))}
+	comma := -1 // Index in out of a comma not yet followed by a value
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '"' || c == '\'':
+			n, err := w.appendString(data, i)
+			if err != nil {
+				return nil, nil, fmt.Errorf("offset %d: %w", i, err)
+			}
+			i += n - 1
+			comma = -1
+
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i+1 < len(data) && data[i+1] != '\n' {
+				i++
+			}
+
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, nil, fmt.Errorf("offset %d: unterminated /* comment", i)
+			}
+			for j, b := range data[i : i+2+end+2] {
+				if b == '\n' {
+					w.add(i+j, '\n')
+				} else {
+					w.add(i+j, ' ')
+				}
+			}
+			i += 2 + end + 1
+
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			w.add(i, c)
+
+		case c == ',':
+			comma = len(w.out)
+			w.add(i, c)
+
+		case c == '}' || c == ']':
+			if comma >= 0 {
+				w.out[comma] = ' '
+				comma = -1
+			}
+			w.add(i, c)
+
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(data) && (isIdentStart(data[j]) || data[j] >= '0' && data[j] <= '9') {
+				j++
+			}
+			if isKey(data[j:]) {
+				w.add(i, '"')
+				for k := i; k < j; k++ {
+					w.add(k, data[k])
+				}
+				w.add(j-1, '"')
+			} else {
+				for k := i; k < j; k++ {
+					w.add(k, data[k]) // true, false, null
+				}
+			}
+			i = j - 1
+			comma = -1
+
+		default:
+			w.add(i, c)
+			comma = -1
+		}
+	}
+	return w.out, w.offsets, nil
+}
+
+// json5Writer collects fromJSON5's output along with the input offset of
+// each output byte.
+type json5Writer struct {
+	out     []byte
+	offsets []int
+}
+
+// add appends bytes that stand for the input byte at offset from.
+func (w *json5Writer) add(from int, b ...byte) {
+	w.out = append(w.out, b...)
+	for range b {
+		w.offsets = append(w.offsets, from)
+	}
+}
+
+// appendString appends the quoted string at data[start:] as a double-quoted
+// JSON string and returns the number of bytes it took up.
+func (w *json5Writer) appendString(data []byte, start int) (int, error) {
+	quote := data[start]
+	w.add(start, '"')
+	for i := start + 1; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == quote:
+			w.add(i, '"')
+			return i + 1 - start, nil
+		case c == '\\' && i+1 < len(data):
+			i++
+			switch data[i] {
+			case '\'':
+				w.add(i, '\'')
+			case '\n':
+				// A line continuation adds nothing to the string
+			default:
+				w.add(i, '\\', data[i])
+			}
+		case c == '"':
+			w.add(i, '\\', '"') // Only reachable inside '...'
+		default:
+			w.add(i, c)
+		}
+	}
+	return 0, fmt.Errorf("unterminated string")
+}
+
+// sourceOffset maps n, an encoding/json error offset into fromJSON5's
+// output (the number of bytes read), to the same point in its input.
+func sourceOffset(offsets []int, n int64) int64 {
+	if n <= 0 || len(offsets) == 0 {
+		return 0
+	}
+	if n > int64(len(offsets)) {
+		n = int64(len(offsets))
+	}
+	return int64(offsets[n-1]) + 1
+}
+
+// isIdentStart reports whether c can start an unquoted JSON5 key.
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$'
+}
+
+// isKey reports whether rest, the input after an identifier, continues with
+// the ":" that makes the identifier an object key.
+func isKey(rest []byte) bool {
+	rest = bytes.TrimLeft(rest, " \t\r\n")
+	return len(rest) > 0 && rest[0] == ':'
+}
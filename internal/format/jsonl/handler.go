@@ -0,0 +1,166 @@
+// Package jsonl provides a JSON Lines (newline-delimited JSON) handler for
+// chezmoi-split.
+package jsonl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	formatjson "github.com/thirteen37/chezmoi-split/internal/format/json"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// DefaultMatchKey is the record field that identifies a record when no
+// match-key is given.
+const DefaultMatchKey = "id"
+
+// Handler implements format.Handler for JSON Lines files.
+//
+// Each non-blank line is a JSON object, a record. The tree is an
+// *orderedmap.OrderedMap of records in file order, keyed by
+// "<match-key>=<value>" (e.g. "id=user-added") for records whose match-key
+// field holds a string, number, or bool, and by the record's compact JSON
+// otherwise, so identical lines match between the template and the current
+// file. A key seen again in the same file gets "#2", "#3", ... appended.
+// Paths below the record key address fields as in JSON.
+type Handler struct {
+	json     *formatjson.Handler
+	matchKey string // Match key of the last parsed document
+}
+
+// New creates a new JSON Lines handler.
+func New() *Handler {
+	return &Handler{json: formatjson.New(), matchKey: DefaultMatchKey}
+}
+
+// Parse reads one JSON object per line, using opts.MatchKey (or
+// DefaultMatchKey) to key the records. Blank lines are skipped.
+func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
+	if opts.StripComments {
+		return nil, fmt.Errorf("strip-comments is not supported for JSON Lines files")
+	}
+	h.matchKey = opts.MatchKey
+	if h.matchKey == "" {
+		h.matchKey = DefaultMatchKey
+	}
+
+	tree := orderedmap.New()
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line[0] != '{' {
+			return nil, fmt.Errorf("line %d: expected a JSON object", i+1)
+		}
+		record, err := h.json.Parse([]byte(line), format.ParseOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		key, err := h.recordKey(record)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		unique := key
+		for n := 2; ; n++ {
+			if _, exists := tree.Get(unique); !exists {
+				break
+			}
+			unique = key + "#" + strconv.Itoa(n)
+		}
+		tree.Set(unique, record)
+	}
+	return tree, nil
+}
+
+// recordKey returns the tree key for record.
+func (h *Handler) recordKey(record any) (string, error) {
+	om := format.ToOrderedMapPtr(record)
+	if val, ok := om.Get(h.matchKey); ok {
+		switch v := val.(type) {
+		case string:
+			return h.matchKey + "=" + v, nil
+		case json.Number, float64, bool:
+			text, _ := json.Marshal(v)
+			return h.matchKey + "=" + string(text), nil
+		}
+	}
+	text, err := json.Marshal(om)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// Serialize writes each record as one line of compact JSON, in tree order.
+func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, error) {
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return nil, fmt.Errorf("tree is not an ordered map")
+	}
+
+	var buf bytes.Buffer
+	for _, key := range om.Keys() {
+		record, _ := om.Get(key)
+		if format.ToOrderedMapPtr(record) == nil {
+			return nil, fmt.Errorf("record %q is not a JSON object", key)
+		}
+		line, err := json.Marshal(record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize record %q: %w", key, err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// GetPath extracts a value at the given path. The first segment selects a
+// record by its key ("id=user-added") or "*" for every record.
+func (h *Handler) GetPath(tree any, p path.Path) (any, bool) {
+	return h.json.GetPath(tree, p)
+}
+
+// SetPath sets a value at the given path. A path below a record key that
+// doesn't exist yet starts a new record holding the match-key field, so
+// ["id=new", "name"] writes {"id":"new","name":...}.
+func (h *Handler) SetPath(tree any, p path.Path, value any) error {
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return fmt.Errorf("tree is not an ordered map")
+	}
+	segments := p.Segments()
+	if len(segments) > 1 && !path.HasWildcard(p) {
+		if _, exists := om.Get(segments[0]); !exists {
+			if field, val, ok := strings.Cut(segments[0], "="); ok && field == h.matchKey {
+				if len(segments) == 2 && segments[1] == field {
+					// The value being set is the match-key field itself
+					return h.json.SetPath(tree, p, value)
+				}
+				record := orderedmap.New()
+				record.Set(field, val)
+				om.Set(segments[0], record)
+				if err := h.json.SetPath(tree, p, value); err != nil {
+					om.Delete(segments[0])
+					return err
+				}
+				return nil
+			}
+		}
+	}
+	return h.json.SetPath(tree, p, value)
+}
+
+// DeletePath removes the value at the given path; a record key alone
+// removes the whole line.
+func (h *Handler) DeletePath(tree any, p path.Path) error {
+	return h.json.DeletePath(tree, p)
+}
+
+var _ format.Handler = (*Handler)(nil)
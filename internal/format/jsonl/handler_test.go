@@ -0,0 +1,166 @@
+package jsonl
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/formattest"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// historyDoc is a state file of records with ids.
+const historyDoc = `{"id":"theme","value":"dark","pinned":true}
+{"id":"font","value":{"family":"mono","size":12}}
+{"id":"user-added","value":"x"}
+`
+
+func TestHandler_Parse(t *testing.T) {
+	h := New()
+	tree, err := h.Parse([]byte("{\"id\":\"a\",\"n\":1}\r\n\n{\"id\":7}\n{\"id\":true}\n{\"cmd\":\"ls\"}\n{\"id\":{\"x\":1}}\n{\"cmd\":\"ls\"}\n"), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := []string{"id=a", "id=7", "id=true", `{"cmd":"ls"}`, `{"id":{"x":1}}`, `{"cmd":"ls"}#2`}
+	if keys := tree.(*orderedmap.OrderedMap).Keys(); !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys = %q, want %q", keys, want)
+	}
+	if v, _ := h.GetPath(tree, path.NewArrayPath([]string{"id=a", "n"})); v != json.Number("1") {
+		t.Errorf("GetPath(id=a, n) = %#v, want 1", v)
+	}
+}
+
+func TestHandler_Parse_MatchKey(t *testing.T) {
+	h := New()
+	tree, err := h.Parse([]byte(`{"name":"a","id":1}`+"\n"), format.ParseOptions{MatchKey: "name"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if keys := tree.(*orderedmap.OrderedMap).Keys(); !reflect.DeepEqual(keys, []string{"name=a"}) {
+		t.Errorf("keys = %q, want [name=a]", keys)
+	}
+}
+
+func TestHandler_Parse_Errors(t *testing.T) {
+	h := New()
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"array line", "{\"id\":1}\n[1, 2]\n", "line 2: expected a JSON object"},
+		{"bare value", "42\n", "line 1: expected a JSON object"},
+		{"invalid json", "{\"id\":1}\n\n{\"id\":\n", "line 3: failed to parse JSON"},
+		{"strip-comments", "{}", "strip-comments is not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := h.Parse([]byte(tt.input), format.ParseOptions{StripComments: tt.name == "strip-comments"})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_Serialize(t *testing.T) {
+	h := New()
+	tree, err := h.Parse([]byte(`{ "id": "a",  "list": [1, 2] }
+
+{"id": "b", "nested": {"k": null}}`), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if want := "{\"id\":\"a\",\"list\":[1,2]}\n{\"id\":\"b\",\"nested\":{\"k\":null}}\n"; string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", data, want)
+	}
+
+	// A value at the top level is not a record
+	tree.(*orderedmap.OrderedMap).Set("loose", "x")
+	if _, err := h.Serialize(tree, format.SerializeOptions{}); err == nil || !strings.Contains(err.Error(), `record "loose" is not a JSON object`) {
+		t.Errorf("Serialize() error = %v, want a record error", err)
+	}
+}
+
+func TestHandler_SetPath_NewRecord(t *testing.T) {
+	h := New()
+	tree, err := h.Parse([]byte(`{"id":"a"}`), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"id=b", "value"}), "x"); err != nil {
+		t.Fatalf("SetPath() error = %v", err)
+	}
+	// A key that isn't the match key creates a plain record
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"other", "value"}), "y"); err != nil {
+		t.Fatalf("SetPath() error = %v", err)
+	}
+	// A rejected value leaves no empty record behind
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"id=c", "value"}), make(chan int)); err == nil {
+		t.Error("SetPath(chan) expected error")
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if want := "{\"id\":\"a\"}\n{\"id\":\"b\",\"value\":\"x\"}\n{\"value\":\"y\"}\n"; string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", data, want)
+	}
+}
+
+// TestHandler_KeepsCurrentRecord checks the handler's part of an ignore rule
+// on a record key: the app's version of the record replaces the template's.
+func TestHandler_KeepsCurrentRecord(t *testing.T) {
+	h := New()
+	managed, err := h.Parse([]byte(historyDoc), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(managed) error = %v", err)
+	}
+	current, err := h.Parse([]byte(`{"id":"font","value":{"family":"serif"},"seen":3}`), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(current) error = %v", err)
+	}
+
+	p := path.NewArrayPath([]string{"id=font"})
+	val, ok := h.GetPath(current, p)
+	if !ok {
+		t.Fatal("GetPath(current) not found")
+	}
+	if err := h.SetPath(managed, p, val); err != nil {
+		t.Fatalf("SetPath() error = %v", err)
+	}
+
+	data, err := h.Serialize(managed, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := strings.Replace(historyDoc, `{"id":"font","value":{"family":"mono","size":12}}`, `{"id":"font","value":{"family":"serif"},"seen":3}`, 1)
+	if string(data) != want {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestHandler_Conformance(t *testing.T) {
+	formattest.RunConformance(t, New(), formattest.Fixtures{
+		Document:        historyDoc,
+		RoundTripExact:  true,
+		OrderedKeys:     []string{"id=theme", "id=font", "id=user-added"},
+		LeafPath:        []string{"id=font", "value", "family"},
+		LeafValue:       "mono",
+		WildcardPath:    []string{"*", "pinned"},
+		WildcardMatches: [][]string{{"id=theme", "pinned"}},
+		DeepPath:        []string{"id=new", "nested", "key"},
+	})
+}
@@ -0,0 +1,1011 @@
+// Package kdl provides a handler for KDL documents, such as zellij's
+// config.kdl, for chezmoi-split.
+package kdl
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// defaultIndent is used for nested nodes when no parsed document had an
+// indented line.
+const defaultIndent = "    "
+
+// Entries holds a node's arguments and properties as written, for a node
+// that isn't a single plain argument: `size=1 borderless=true`, `"a" "b"`,
+// `(u8)5`, or "" for a node with no entries. It is written back verbatim.
+type Entries string
+
+// entry records the layout around a node in a parsed document.
+type entry struct {
+	above      []string // Comment and blank lines before the node
+	comment    string   // Comment on the same line, after the node or its "{"
+	closing    []string // Nodes with children: comment and blank lines before the "}"
+	trailer    string   // Nodes with children: comment on the same line, after the "}"
+	inline     bool     // Nodes with children: written on one line ("a { b; c; }")
+	annotation string   // Type annotation of the node, such as "(author)"
+	raw        string   // A single argument as written
+	value      any      // The parsed single argument, to tell whether raw still applies
+}
+
+// Handler implements format.Handler for KDL documents.
+//
+// The tree is an *orderedmap.OrderedMap of nodes in order. A node without
+// children maps its name to its value: a node with a single argument and
+// nothing else maps to that argument (a string, int64, float64, bool, or nil
+// for null), any other node to its Entries. A node with children maps to an
+// *orderedmap.OrderedMap of them, keyed by the node name alone ("keybinds")
+// or, when the node also has entries, by the name and entries as written
+// (`bind "Ctrl g"`, `tab name="main"`). A key that appears more than once
+// among siblings becomes a []any with one element per node, so the second
+// of two "pane" nodes is ["layout", "pane", "1"].
+//
+// Serialize writes one node per line, indented consistently, except for
+// nodes whose children were written on one line. Type annotations, comments,
+// and each argument's original spelling (raw strings, hex numbers) are kept
+// from the first document that has the node. Nodes and entries commented
+// out with "/-" are kept as comments above the next node; an entry commented
+// out inside a node is dropped.
+type Handler struct {
+	entries map[string]entry
+	indent  string   // Indentation of the first indented line seen
+	footer  []string // Comment lines after the last node of the first document
+	parsed  bool
+}
+
+// New creates a new KDL handler.
+func New() *Handler {
+	return &Handler{entries: make(map[string]entry)}
+}
+
+// entryKey returns the entries key for the n-th occurrence of key among the
+// children of the node at prefix.
+func entryKey(prefix, key string, n int) string {
+	return prefix + "\x00" + key + "\x00" + strconv.Itoa(n)
+}
+
+// Parse reads a KDL document and returns an *orderedmap.OrderedMap.
+func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
+	if opts.StripComments {
+		return nil, fmt.Errorf("strip-comments is not supported for kdl format")
+	}
+
+	text := strings.TrimPrefix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\uFEFF")
+	if h.indent == "" {
+		h.indent = firstIndent(text)
+	}
+
+	p := &parser{h: h, src: text}
+	for i, c := range text {
+		if c == '\n' {
+			p.newlines = append(p.newlines, i)
+		}
+	}
+
+	result := orderedmap.New()
+	if err := p.parseNodes(result, "", false); err != nil {
+		return nil, fmt.Errorf("failed to parse kdl: %w", err)
+	}
+
+	if !h.parsed {
+		h.footer = trimBlank(p.pending)
+		h.parsed = true
+	}
+	return result, nil
+}
+
+// firstIndent returns the leading whitespace of the first indented line
+// that isn't blank.
+func firstIndent(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && len(trimmed) < len(line) {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+	return ""
+}
+
+// parser reads nodes from src, recording layout in the handler.
+type parser struct {
+	h        *Handler
+	src      string
+	pos      int
+	newlines []int    // Offsets of the newlines in src
+	pending  []string // Comment and blank lines not yet attached to a node
+	lastKey  string   // Entries key of the node that ended last
+	closed   bool     // Whether the last node ended with its "}"
+	endLine  int      // Line the last node, comment, or "}" ended on
+}
+
+// lineAt returns the 1-based line number of offset i.
+func (p *parser) lineAt(i int) int {
+	return sort.SearchInts(p.newlines, i) + 1
+}
+
+// errorf returns an error prefixed with the current line number.
+func (p *parser) errorf(msg string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.lineAt(p.pos), fmt.Sprintf(msg, args...))
+}
+
+// discard runs parse, which reads something commented out with "/-",
+// without recording anything it reads.
+func (p *parser) discard(parse func() error) error {
+	saved := *p
+	p.h = &Handler{entries: make(map[string]entry)}
+	p.pending = nil
+	err := parse()
+	pos := p.pos
+	*p = saved
+	p.pos = pos
+	return err
+}
+
+// peek reports whether src continues with s at the current position.
+func (p *parser) peek(s string) bool {
+	return strings.HasPrefix(p.src[p.pos:], s)
+}
+
+// note adds a blank line to the pending lines when line starts after a gap.
+func (p *parser) note(line int) {
+	if p.endLine > 0 && line > p.endLine+1 {
+		p.pending = append(p.pending, "")
+	}
+}
+
+// addComment attaches a comment that starts on line: to the node that ended
+// on the same line, or else to the pending lines above the next node.
+func (p *parser) addComment(text string, line int) {
+	if line == p.endLine && p.lastKey != "" {
+		if e, ok := p.h.entries[p.lastKey]; ok {
+			if p.closed && e.trailer == "" {
+				e.trailer = text
+			} else if !p.closed && e.comment == "" {
+				e.comment = text
+			}
+			p.h.entries[p.lastKey] = e
+		}
+		p.endLine = p.lineAt(p.pos)
+		return
+	}
+	p.note(line)
+	p.pending = append(p.pending, text)
+	p.endLine = p.lineAt(p.pos)
+}
+
+// parseNodes reads nodes into om until the "}" that closes the children
+// block (nested) or the end of input (top level).
+func (p *parser) parseNodes(om *orderedmap.OrderedMap, prefix string, nested bool) error {
+	for {
+		if p.pos >= len(p.src) {
+			if nested {
+				return p.errorf("unexpected end of file, expecting \"}\"")
+			}
+			return nil
+		}
+
+		start := p.pos
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == ';':
+			p.pos++
+		case c == '}':
+			if !nested {
+				return p.errorf("unexpected \"}\"")
+			}
+			p.note(p.lineAt(p.pos))
+			p.pos++
+			p.endLine = p.lineAt(start)
+			return nil
+		case p.peek("//"):
+			end := strings.IndexByte(p.src[p.pos:], '\n')
+			if end < 0 {
+				end = len(p.src) - p.pos
+			}
+			p.pos += end
+			p.addComment(strings.TrimRight(p.src[start:p.pos], " \t"), p.lineAt(start))
+		case p.peek("/*"):
+			if err := p.skipBlockComment(); err != nil {
+				return err
+			}
+			p.addComment(p.src[start:p.pos], p.lineAt(start))
+		case p.peek("/-"):
+			// A commented-out node is kept as written, like a comment
+			p.pos += 2
+			p.skipNodeSpace()
+			if err := p.discard(func() error { return p.parseNode(orderedmap.New(), "") }); err != nil {
+				return err
+			}
+			p.addComment(p.src[start:p.pos], p.lineAt(start))
+		default:
+			if err := p.parseNode(om, prefix); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// parseNode reads one node, with its entries and children, into om.
+func (p *parser) parseNode(om *orderedmap.OrderedMap, prefix string) error {
+	startLine := p.lineAt(p.pos)
+	p.note(startLine)
+	above := p.pending
+	p.pending = nil
+
+	annotation, err := p.parseAnnotation()
+	if err != nil {
+		return err
+	}
+	name, _, err := p.parseIdentifier()
+	if err != nil {
+		return err
+	}
+
+	var raws []string
+	var args []any
+	single := true // Whether the entries are one argument without annotation
+	for {
+		p.skipNodeSpace()
+		if p.pos >= len(p.src) || p.peek("//") || strings.ContainsRune("\n;}{", rune(p.src[p.pos])) {
+			break
+		}
+		if p.peek("/-") {
+			p.pos += 2
+			p.skipNodeSpace()
+			err := p.discard(func() error {
+				if p.peek("{") {
+					p.pos++
+					return p.parseNodes(orderedmap.New(), "", true)
+				}
+				_, _, _, err := p.parseEntry()
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, val, isArg, err := p.parseEntry()
+		if err != nil {
+			return err
+		}
+		raws = append(raws, raw)
+		if isArg && !strings.HasPrefix(raw, "(") {
+			args = append(args, val)
+		} else {
+			single = false
+		}
+	}
+
+	key := formatIdentifier(name)
+	var value any = Entries(strings.Join(raws, " "))
+	var children *orderedmap.OrderedMap
+	if p.peek("{") {
+		if len(raws) > 0 {
+			key += " " + strings.Join(raws, " ")
+		}
+		children = orderedmap.New()
+		value = children
+	} else if single && len(args) == 1 {
+		value = args[0]
+	}
+
+	n := addValue(om, key, value)
+	ek := entryKey(prefix, key, n)
+	_, seen := p.h.entries[ek]
+	if !seen {
+		e := entry{above: above, annotation: annotation}
+		if children == nil && single && len(args) == 1 {
+			e.raw, e.value = raws[0], args[0]
+		}
+		p.h.entries[ek] = e
+	}
+	p.lastKey = ek
+	p.closed = false
+	p.endLine = p.lineAt(p.pos)
+
+	if children != nil {
+		p.pos++ // "{"
+		if err := p.parseNodes(children, ek, true); err != nil {
+			return err
+		}
+		if !seen {
+			e := p.h.entries[ek]
+			e.closing = trimBlank(p.pending)
+			e.inline = p.lineAt(p.pos-1) == startLine
+			p.h.entries[ek] = e
+		}
+		p.pending = nil
+		p.lastKey = ek
+		p.closed = true
+	}
+	return nil
+}
+
+// skipNodeSpace skips spaces, block comments, and line continuations
+// between the parts of a node.
+func (p *parser) skipNodeSpace() {
+	for p.pos < len(p.src) {
+		switch {
+		case p.src[p.pos] == ' ' || p.src[p.pos] == '\t':
+			p.pos++
+		case p.peek("/*"):
+			if p.skipBlockComment() != nil {
+				return
+			}
+		case p.src[p.pos] == '\\':
+			// A line continuation: "\", optional space and comment, newline
+			end := strings.IndexByte(p.src[p.pos:], '\n')
+			if end < 0 {
+				p.pos = len(p.src)
+				return
+			}
+			rest := strings.TrimSpace(p.src[p.pos+1 : p.pos+end])
+			if rest != "" && !strings.HasPrefix(rest, "//") {
+				return
+			}
+			p.pos += end + 1
+		default:
+			return
+		}
+	}
+}
+
+// skipBlockComment skips a /* */ comment, which may nest.
+func (p *parser) skipBlockComment() error {
+	start := p.pos
+	depth := 0
+	for p.pos < len(p.src) {
+		switch {
+		case p.peek("/*"):
+			depth++
+			p.pos += 2
+		case p.peek("*/"):
+			depth--
+			p.pos += 2
+			if depth == 0 {
+				return nil
+			}
+		default:
+			p.pos++
+		}
+	}
+	p.pos = start
+	return p.errorf("unterminated block comment")
+}
+
+// parseAnnotation reads an optional type annotation and returns it as
+// written, parentheses included.
+func (p *parser) parseAnnotation() (string, error) {
+	if !p.peek("(") {
+		return "", nil
+	}
+	start := p.pos
+	p.pos++
+	if _, _, err := p.parseIdentifier(); err != nil {
+		return "", err
+	}
+	if !p.peek(")") {
+		return "", p.errorf("expected \")\" after type annotation")
+	}
+	p.pos++
+	return p.src[start:p.pos], nil
+}
+
+// parseEntry reads an argument or a property and returns it as written,
+// the argument's value, and whether it is an argument.
+func (p *parser) parseEntry() (raw string, value any, isArg bool, err error) {
+	start := p.pos
+
+	if !p.peek("(") && p.pos < len(p.src) && !isNumberStart(p.src[p.pos:]) {
+		// A bare identifier or string may be a property name
+		save := p.pos
+		name, quoted, err := p.parseIdentifier()
+		if err != nil {
+			return "", nil, false, err
+		}
+		if p.peek("=") {
+			p.pos++
+			if _, err := p.parseAnnotation(); err != nil {
+				return "", nil, false, err
+			}
+			if _, err := p.parseValue(); err != nil {
+				return "", nil, false, err
+			}
+			return p.src[start:p.pos], nil, false, nil
+		}
+		if quoted {
+			return p.src[start:p.pos], name, true, nil
+		}
+		p.pos = save
+	}
+
+	if _, err := p.parseAnnotation(); err != nil {
+		return "", nil, false, err
+	}
+	value, err = p.parseValue()
+	if err != nil {
+		return "", nil, false, err
+	}
+	return p.src[start:p.pos], value, true, nil
+}
+
+// parseValue reads a string, number, or keyword.
+func (p *parser) parseValue() (any, error) {
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("expected a value")
+	}
+	if isNumberStart(p.src[p.pos:]) {
+		return p.parseNumber()
+	}
+	if p.peek("\"") || isRawStringStart(p.src[p.pos:]) {
+		s, _, err := p.parseIdentifier()
+		return s, err
+	}
+
+	start := p.pos
+	word, _, err := p.parseIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	p.pos = start
+	return nil, p.errorf("unexpected identifier %q; strings must be quoted", word)
+}
+
+// parseNumber reads a decimal, hexadecimal, octal, or binary number.
+func (p *parser) parseNumber() (any, error) {
+	start := p.pos
+	for p.pos < len(p.src) && isIdentChar(p.src[p.pos]) {
+		p.pos++
+	}
+	text := p.src[start:p.pos]
+	clean := strings.ReplaceAll(text, "_", "")
+
+	sign := ""
+	digits := clean
+	if clean[0] == '+' || clean[0] == '-' {
+		sign, digits = clean[:1], clean[1:]
+	}
+	for prefix, base := range map[string]int{"0x": 16, "0o": 8, "0b": 2} {
+		if strings.HasPrefix(digits, prefix) {
+			n, err := strconv.ParseInt(sign+digits[2:], base, 64)
+			if err != nil {
+				return nil, p.errorf("invalid number %q", text)
+			}
+			return n, nil
+		}
+	}
+	if !strings.ContainsAny(digits, ".eE") {
+		if n, err := strconv.ParseInt(clean, 10, 64); err == nil {
+			return n, nil
+		}
+	}
+	f, err := strconv.ParseFloat(clean, 64)
+	if err != nil {
+		return nil, p.errorf("invalid number %q", text)
+	}
+	return f, nil
+}
+
+// parseIdentifier reads a bare identifier or a string and returns its
+// value and whether it was quoted.
+func (p *parser) parseIdentifier() (string, bool, error) {
+	if p.peek("\"") {
+		s, err := p.parseString()
+		return s, true, err
+	}
+	if isRawStringStart(p.src[p.pos:]) {
+		s, err := p.parseRawString()
+		return s, true, err
+	}
+
+	start := p.pos
+	for p.pos < len(p.src) && isIdentChar(p.src[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		if p.pos >= len(p.src) {
+			return "", false, p.errorf("unexpected end of file")
+		}
+		return "", false, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	return p.src[start:p.pos], false, nil
+}
+
+// parseString reads a quoted string with escapes.
+func (p *parser) parseString() (string, error) {
+	start := p.pos
+	p.pos++
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch c {
+		case '"':
+			p.pos++
+			return sb.String(), nil
+		case '\\':
+			if p.pos+1 >= len(p.src) {
+				break
+			}
+			p.pos++
+			switch e := p.src[p.pos]; e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'b':
+				sb.WriteByte('\b')
+			case 'f':
+				sb.WriteByte('\f')
+			case '\\', '/', '"':
+				sb.WriteByte(e)
+			case 'u':
+				end := strings.IndexByte(p.src[p.pos:], '}')
+				if !p.peek("u{") || end < 0 {
+					return "", p.errorf("invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(p.src[p.pos+2:p.pos+end], 16, 32)
+				if err != nil {
+					return "", p.errorf("invalid unicode escape")
+				}
+				sb.WriteRune(rune(r))
+				p.pos += end
+			default:
+				return "", p.errorf("invalid escape \"\\%c\"", e)
+			}
+			p.pos++
+			continue
+		}
+		sb.WriteByte(c)
+		p.pos++
+	}
+	p.pos = start
+	return "", p.errorf("unterminated string")
+}
+
+// parseRawString reads r"..." or r#"..."#, with any number of hashes.
+func (p *parser) parseRawString() (string, error) {
+	start := p.pos
+	p.pos++ // "r"
+	hashes := 0
+	for p.peek("#") {
+		hashes++
+		p.pos++
+	}
+	p.pos++ // The opening quote
+	closing := "\"" + strings.Repeat("#", hashes)
+	end := strings.Index(p.src[p.pos:], closing)
+	if end < 0 {
+		p.pos = start
+		return "", p.errorf("unterminated raw string")
+	}
+	s := p.src[p.pos : p.pos+end]
+	p.pos += end + len(closing)
+	return s, nil
+}
+
+// isIdentChar reports whether c can appear in a bare identifier or number.
+func isIdentChar(c byte) bool {
+	return c > ' ' && !strings.ContainsRune(`\/(){}<>;[]=,"`, rune(c))
+}
+
+// isNumberStart reports whether s starts with a number: a digit, or a sign
+// followed by a digit.
+func isNumberStart(s string) bool {
+	if s == "" {
+		return false
+	}
+	if s[0] == '+' || s[0] == '-' {
+		s = s[1:]
+	}
+	return s != "" && s[0] >= '0' && s[0] <= '9'
+}
+
+// isRawStringStart reports whether s starts with a raw string.
+func isRawStringStart(s string) bool {
+	if !strings.HasPrefix(s, "r") {
+		return false
+	}
+	rest := strings.TrimLeft(s[1:], "#")
+	return strings.HasPrefix(rest, "\"")
+}
+
+// addValue adds a node to its siblings, turning a repeated key into a
+// []any. It returns the occurrence's index among the key's values.
+func addValue(om *orderedmap.OrderedMap, key string, value any) int {
+	existing, exists := om.Get(key)
+	if !exists {
+		om.Set(key, value)
+		return 0
+	}
+
+	values, ok := existing.([]any)
+	if !ok {
+		values = []any{existing}
+	}
+	om.Set(key, append(values, value))
+	return len(values)
+}
+
+// trimBlank drops trailing blank lines.
+func trimBlank(lines []string) []string {
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Serialize writes each node on its own line, children indented one level
+// per depth, with the recorded comments and annotations.
+func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, error) {
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return nil, fmt.Errorf("tree is not an ordered map")
+	}
+
+	indent := h.indent
+	if indent == "" {
+		indent = defaultIndent
+	}
+
+	var sb strings.Builder
+	if err := h.writeNodes(&sb, om, "", "", indent); err != nil {
+		return nil, err
+	}
+	writeComments(&sb, h.footer, "")
+	return []byte(sb.String()), nil
+}
+
+// writeNodes writes the nodes of om at the given depth.
+func (h *Handler) writeNodes(sb *strings.Builder, om *orderedmap.OrderedMap, prefix, pad, indent string) error {
+	for _, key := range om.Keys() {
+		val, _ := om.Get(key)
+		values, ok := val.([]any)
+		if !ok {
+			values = []any{val}
+		}
+
+		for n, v := range values {
+			ek := entryKey(prefix, key, n)
+			ent := h.entries[ek]
+			writeComments(sb, ent.above, pad)
+
+			comment := ""
+			if ent.comment != "" {
+				comment = " " + ent.comment
+			}
+
+			if children := format.ToOrderedMapPtr(v); children != nil {
+				trailer := ""
+				if ent.trailer != "" {
+					trailer = " " + ent.trailer
+				}
+				if ent.inline {
+					if line, ok := h.inlineChildren(children, ek); ok {
+						sb.WriteString(pad + ent.annotation + key + " {" + line + "}" + trailer + comment + "\n")
+						continue
+					}
+				}
+				sb.WriteString(pad + ent.annotation + key + " {" + comment + "\n")
+				if err := h.writeNodes(sb, children, ek, pad+indent, indent); err != nil {
+					return err
+				}
+				writeComments(sb, ent.closing, pad+indent)
+				sb.WriteString(pad + "}" + trailer + "\n")
+				continue
+			}
+
+			text, err := h.formatEntries(v, ent)
+			if err != nil {
+				return fmt.Errorf("node %q: %w", key, err)
+			}
+			sb.WriteString(pad + ent.annotation + key + text + comment + "\n")
+		}
+	}
+	return nil
+}
+
+// inlineChildren formats children on one line (" a 1; b; "), if none of
+// them has children of its own.
+func (h *Handler) inlineChildren(children *orderedmap.OrderedMap, prefix string) (string, bool) {
+	if len(children.Keys()) == 0 {
+		return "", true
+	}
+
+	var sb strings.Builder
+	sb.WriteString(" ")
+	for _, key := range children.Keys() {
+		val, _ := children.Get(key)
+		values, ok := val.([]any)
+		if !ok {
+			values = []any{val}
+		}
+		for n, v := range values {
+			if format.ToOrderedMapPtr(v) != nil {
+				return "", false
+			}
+			ent := h.entries[entryKey(prefix, key, n)]
+			text, err := h.formatEntries(v, ent)
+			if err != nil {
+				return "", false
+			}
+			sb.WriteString(ent.annotation + key + text + "; ")
+		}
+	}
+	return sb.String(), true
+}
+
+// formatEntries returns the text after a node's name for a value without
+// children: the entries as written, the original spelling of an unchanged
+// argument, or a newly formatted argument.
+func (h *Handler) formatEntries(v any, ent entry) (string, error) {
+	if e, ok := v.(Entries); ok {
+		if e == "" {
+			return "", nil
+		}
+		return " " + string(e), nil
+	}
+	if ent.raw != "" && reflect.DeepEqual(ent.value, v) {
+		return " " + ent.raw, nil
+	}
+	s, err := formatValue(v)
+	if err != nil {
+		return "", err
+	}
+	return " " + s, nil
+}
+
+// formatValue formats a scalar as a KDL value.
+func formatValue(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "null", nil
+	case string:
+		return quote(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s, nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.String {
+		return quote(rv.String()), nil
+	}
+	return "", fmt.Errorf("unsupported value type %T", v)
+}
+
+// quote returns s as a KDL string with escapes.
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\f':
+			sb.WriteString(`\f`)
+		default:
+			if r < ' ' {
+				fmt.Fprintf(&sb, `\u{%x}`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// formatIdentifier returns name bare if it is a valid bare identifier, or
+// quoted otherwise.
+func formatIdentifier(name string) string {
+	if name == "" || name == "true" || name == "false" || name == "null" ||
+		isNumberStart(name) || isRawStringStart(name) {
+		return quote(name)
+	}
+	for i := 0; i < len(name); i++ {
+		if !isIdentChar(name[i]) {
+			return quote(name)
+		}
+	}
+	return name
+}
+
+// writeComments writes recorded comment and blank lines at the given
+// indentation. A blank line at the start of the output or of a block is
+// dropped, and runs of blank lines are collapsed.
+func writeComments(sb *strings.Builder, lines []string, pad string) {
+	for i, line := range lines {
+		if line == "" {
+			out := sb.String()
+			if i > 0 && lines[i-1] == "" || out == "" || strings.HasSuffix(out, "{\n") || strings.HasSuffix(out, "\n\n") {
+				continue
+			}
+			sb.WriteByte('\n')
+			continue
+		}
+		sb.WriteString(pad + line + "\n")
+	}
+}
+
+// GetPath extracts a value at the given path. Children are addressed by key
+// and repeated nodes by numeric index; "*" matches any key or element and
+// returns the first match.
+func (h *Handler) GetPath(tree any, p path.Path) (any, bool) {
+	return getPath(tree, p.Segments(), 0)
+}
+
+// getPath recursively navigates children and repeated nodes.
+func getPath(current any, segments []string, idx int) (any, bool) {
+	if idx >= len(segments) {
+		return current, true
+	}
+
+	segment := segments[idx]
+
+	if om := format.ToOrderedMapPtr(current); om != nil {
+		if segment == path.Wildcard {
+			for _, key := range om.Keys() {
+				val, _ := om.Get(key)
+				if result, ok := getPath(val, segments, idx+1); ok {
+					return result, true
+				}
+			}
+			return nil, false
+		}
+		val, exists := om.Get(segment)
+		if !exists {
+			return nil, false
+		}
+		return getPath(val, segments, idx+1)
+	}
+
+	if arr, ok := current.([]any); ok {
+		if segment == path.Wildcard {
+			for _, elem := range arr {
+				if result, ok := getPath(elem, segments, idx+1); ok {
+					return result, true
+				}
+			}
+			return nil, false
+		}
+		i, ok := format.ArrayIndex(segment, len(arr))
+		if !ok {
+			return nil, false
+		}
+		return getPath(arr[i], segments, idx+1)
+	}
+
+	return nil, false
+}
+
+// SetPath sets a value at the given path. A value is a scalar (a node with
+// one argument), Entries, a map (a node with children), or an array of
+// those (a repeated node). "*" applies to every key or element. Missing
+// nodes along the path are created; indexes must already exist.
+func (h *Handler) SetPath(tree any, p path.Path, value any) error {
+	segments := p.Segments()
+	if len(segments) == 0 {
+		return fmt.Errorf("empty path")
+	}
+
+	value, err := format.NormalizeForFormat(value, "kdl")
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %w", p, err)
+	}
+
+	return setPath(tree, segments, 0, value)
+}
+
+// setPath recursively sets values in children and repeated nodes.
+func setPath(current any, segments []string, idx int, value any) error {
+	segment := segments[idx]
+	isLast := idx == len(segments)-1
+
+	if om := format.ToOrderedMapPtr(current); om != nil {
+		if segment == path.Wildcard {
+			for _, key := range om.Keys() {
+				if isLast {
+					om.Set(key, value)
+					continue
+				}
+				val, _ := om.Get(key)
+				// Continue to other keys even if one fails
+				_ = setPath(val, segments, idx+1, value)
+			}
+			return nil
+		}
+
+		if isLast {
+			om.Set(segment, value)
+			return nil
+		}
+
+		next, exists := om.Get(segment)
+		if !exists {
+			next = orderedmap.New()
+			om.Set(segment, next)
+		}
+		if format.ToOrderedMapPtr(next) == nil {
+			if _, ok := next.([]any); !ok {
+				return fmt.Errorf("node %q has no children", segment)
+			}
+		}
+		return setPath(next, segments, idx+1, value)
+	}
+
+	if arr, ok := current.([]any); ok {
+		if segment == path.Wildcard {
+			for i := range arr {
+				if isLast {
+					arr[i] = value
+					continue
+				}
+				_ = setPath(arr[i], segments, idx+1, value)
+			}
+			return nil
+		}
+
+		i, ok := format.ArrayIndex(segment, len(arr))
+		if !ok {
+			return fmt.Errorf("index %q out of range (%d occurrences)", segment, len(arr))
+		}
+		if isLast {
+			arr[i] = value
+			return nil
+		}
+		if format.ToOrderedMapPtr(arr[i]) == nil {
+			return fmt.Errorf("occurrence %d of the node has no children", i)
+		}
+		return setPath(arr[i], segments, idx+1, value)
+	}
+
+	return fmt.Errorf("cannot navigate into a node without children")
+}
+
+// DeletePath removes the value at the given path. "*" deletes every key or
+// element it matches, and an index removes one occurrence of a repeated
+// node.
+func (h *Handler) DeletePath(tree any, p path.Path) error {
+	segments := p.Segments()
+	if len(segments) == 0 {
+		return fmt.Errorf("empty path")
+	}
+	return format.DeleteMatches(tree, segments)
+}
+
+// Ensure Handler implements format.Handler.
+var _ format.Handler = (*Handler)(nil)
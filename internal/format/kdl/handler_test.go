@@ -0,0 +1,336 @@
+package kdl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/formattest"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+func TestHandler_Parse(t *testing.T) {
+	h := New()
+
+	input := `theme "dracula"
+pane_frames false
+scroll_buffer_size 0x2710
+mirror_session true
+default_layout r"compact"
+copy_command null
+ratio 0.5
+
+keybinds clear-defaults=true {
+    normal {
+        bind "Ctrl g" { SwitchToMode "Locked"; }
+        bind "Alt n" { NewPane; }
+    }
+}
+
+layout {
+    pane size=1 borderless=true
+    pane split_direction="vertical"
+    (author)pane "main"
+}
+`
+
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	om := tree.(*orderedmap.OrderedMap)
+	wantKeys := []string{"theme", "pane_frames", "scroll_buffer_size", "mirror_session", "default_layout",
+		"copy_command", "ratio", "keybinds clear-defaults=true", "layout"}
+	if !reflect.DeepEqual(om.Keys(), wantKeys) {
+		t.Fatalf("keys = %q, want %q", om.Keys(), wantKeys)
+	}
+
+	tests := []struct {
+		path []string
+		want any
+	}{
+		{[]string{"theme"}, "dracula"},
+		{[]string{"pane_frames"}, false},
+		{[]string{"scroll_buffer_size"}, int64(10000)},
+		{[]string{"default_layout"}, "compact"},
+		{[]string{"copy_command"}, nil},
+		{[]string{"ratio"}, 0.5},
+		{[]string{"keybinds clear-defaults=true", "normal", `bind "Ctrl g"`, "SwitchToMode"}, "Locked"},
+		{[]string{"keybinds clear-defaults=true", "normal", `bind "Alt n"`, "NewPane"}, Entries("")},
+		{[]string{"layout", "pane", "0"}, Entries("size=1 borderless=true")},
+		{[]string{"layout", "pane", "1"}, Entries(`split_direction="vertical"`)},
+		{[]string{"layout", "pane", "2"}, "main"},
+		{[]string{"layout", "pane", "*"}, Entries("size=1 borderless=true")},
+	}
+	for _, tt := range tests {
+		got, ok := h.GetPath(tree, path.NewArrayPath(tt.path))
+		if !ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetPath(%q) = %#v, %v; want %#v", tt.path, got, ok, tt.want)
+		}
+	}
+}
+
+func TestHandler_Parse_Syntax(t *testing.T) {
+	input := `"quoted name" "a\tb\u{1F600}" // trailing
+node \
+    1 2 /* inline */ key="v"
+/-disabled 1
+skipped /-1 2 /-{
+    child
+}
+(date)when (date)"2024-01-01"
+`
+	h := New()
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		path []string
+		want any
+	}{
+		{[]string{`"quoted name"`}, "a\tb\U0001F600"},
+		{[]string{"node"}, Entries(`1 2 key="v"`)},
+		{[]string{"skipped"}, int64(2)},
+		{[]string{"when"}, Entries(`(date)"2024-01-01"`)},
+	}
+	for _, tt := range tests {
+		got, ok := h.GetPath(tree, path.NewArrayPath(tt.path))
+		if !ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetPath(%q) = %#v, %v; want %#v", tt.path, got, ok, tt.want)
+		}
+	}
+	if _, ok := h.GetPath(tree, path.NewArrayPath([]string{"disabled"})); ok {
+		t.Error("a node commented out with /- should not be parsed")
+	}
+}
+
+func TestHandler_Parse_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"unclosed block", "keybinds {\n    normal {\n}\n", "unexpected end of file"},
+		{"stray brace", "theme \"x\"\n}\n", `line 2: unexpected "}"`},
+		{"bare string value", "theme dracula\n", `line 1: unexpected identifier "dracula"`},
+		{"unterminated string", "theme \"x\n", "line 1: unterminated string"},
+		{"unterminated comment", "/* theme\n", "line 1: unterminated block comment"},
+		{"bad number", "size 1x\n", `invalid number "1x"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Parse([]byte(tt.input), format.ParseOptions{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := New().Parse([]byte("theme \"x\"\n"), format.ParseOptions{StripComments: true}); err == nil {
+		t.Error("Parse() with StripComments should fail")
+	}
+}
+
+func TestHandler_RoundTrip_PreservesLayout(t *testing.T) {
+	input := `// Zellij config
+theme "dracula"
+scroll_buffer_size 0x2710 // hex stays hex
+default_layout r#"compact"#
+
+keybinds {
+  normal {
+    // Lock
+    bind "Ctrl g" { SwitchToMode "Locked"; }
+    bind "Alt h" "Alt Left" { MoveFocusOrTab "Left"; }
+  }
+  /-locked {
+    bind "Ctrl g" { SwitchToMode "Normal"; }
+  }
+} // end keybinds
+
+(author)plugins {
+  tab-bar location=(url)"zellij:tab-bar"
+  size (u8)5
+}
+`
+	h := New()
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	out, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if string(out) != input {
+		t.Errorf("round trip =\n%s\nwant\n%s", out, input)
+	}
+}
+
+func TestHandler_Serialize_NewValues(t *testing.T) {
+	h := New()
+	tree, err := h.Parse([]byte("theme \"dracula\"\nsize 0x10\n"), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	for _, set := range []struct {
+		path  []string
+		value any
+	}{
+		{[]string{"size"}, 32},
+		{[]string{"ratio"}, 2.0},
+		{[]string{"name"}, "say \"hi\"\n"},
+		{[]string{"ui", "pane_frames", "rounded_corners"}, true},
+		{[]string{"ui", "copy_command"}, nil},
+		{[]string{"layout", "pane"}, []any{Entries("size=1"), Entries("")}},
+	} {
+		if err := h.SetPath(tree, path.NewArrayPath(set.path), set.value); err != nil {
+			t.Fatalf("SetPath(%q) error = %v", set.path, err)
+		}
+	}
+
+	out, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := `theme "dracula"
+size 32
+ratio 2.0
+name "say \"hi\"\n"
+ui {
+    pane_frames {
+        rounded_corners true
+    }
+    copy_command null
+}
+layout {
+    pane size=1
+    pane
+}
+`
+	if string(out) != want {
+		t.Errorf("Serialize() =\n%s\nwant\n%s", out, want)
+	}
+}
+
+// TestHandler_KeepsCurrentSubtree merges the way an ignore rule on
+// ["keybinds", "normal"] does: the whole normal block comes from current,
+// binds and all, while the managed document keeps its own order.
+func TestHandler_KeepsCurrentSubtree(t *testing.T) {
+	managed := `theme "dracula"
+keybinds {
+    normal {
+        bind "Ctrl g" { SwitchToMode "Locked"; }
+    }
+    locked {
+        bind "Ctrl g" { SwitchToMode "Normal"; }
+    }
+}
+`
+	current := `keybinds {
+    locked {
+        bind "Ctrl x" { SwitchToMode "Normal"; }
+    }
+    normal {
+        bind "Alt n" { NewPane; }
+        bind "Ctrl g" {
+            SwitchToMode "Locked"
+        }
+    }
+}
+theme "nord"
+`
+	h := New()
+	mTree, err := h.Parse([]byte(managed), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(managed) error = %v", err)
+	}
+	cTree, err := h.Parse([]byte(current), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(current) error = %v", err)
+	}
+
+	p := path.NewArrayPath([]string{"keybinds", "normal"})
+	v, ok := h.GetPath(cTree, p)
+	if !ok {
+		t.Fatalf("GetPath(current, %s) not found", p)
+	}
+	if err := h.SetPath(mTree, p, v); err != nil {
+		t.Fatalf("SetPath(%s) error = %v", p, err)
+	}
+
+	out, err := h.Serialize(mTree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := `theme "dracula"
+keybinds {
+    normal {
+        bind "Alt n" { NewPane; }
+        bind "Ctrl g" { SwitchToMode "Locked"; }
+    }
+    locked {
+        bind "Ctrl g" { SwitchToMode "Normal"; }
+    }
+}
+`
+	if string(out) != want {
+		t.Errorf("Serialize() =\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestHandler_SetPath_Errors(t *testing.T) {
+	h := New()
+	tree, err := h.Parse([]byte("theme \"x\"\nlayout {\n    pane\n    pane\n}\n"), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		path  []string
+		value any
+		want  string
+	}{
+		{"into a leaf", []string{"theme", "name"}, "1", `"theme" has no children`},
+		{"index out of range", []string{"layout", "pane", "2", "size"}, 1, "out of range"},
+		{"nested array", []string{"layout", "tab"}, []any{[]any{"a"}}, "nested arrays are not supported"},
+		{"binary", []string{"data"}, []byte{1}, "binary data is not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := h.SetPath(tree, path.NewArrayPath(tt.path), tt.value)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("SetPath() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_Conformance(t *testing.T) {
+	formattest.RunConformance(t, New(), formattest.Fixtures{
+		Document: `zebra "z"
+
+apple {
+    size 16
+}
+
+mango {
+    size 4
+}
+`,
+		RoundTripExact:  true,
+		OrderedKeys:     []string{"zebra", "apple", "mango"},
+		LeafPath:        []string{"apple", "size"},
+		LeafValue:       int64(16),
+		WildcardPath:    []string{"*", "size"},
+		WildcardMatches: [][]string{{"apple", "size"}, {"mango", "size"}},
+		DeepPath:        []string{"new", "block", "size"},
+	})
+}
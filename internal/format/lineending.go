@@ -0,0 +1,24 @@
+package format
+
+import "bytes"
+
+// LineEnding returns "\r\n" when most line breaks in data are CRLF, as
+// Windows apps write them, and "\n" otherwise, including when data has no
+// line breaks.
+func LineEnding(data []byte) string {
+	crlf := bytes.Count(data, []byte("\r\n"))
+	if lf := bytes.Count(data, []byte("\n")) - crlf; crlf > lf {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// WithLineEnding returns data with every line break, CRLF or LF, written as
+// ending. A lone "\r" is not a line break and is left alone.
+func WithLineEnding(data []byte, ending string) []byte {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	if ending == "\n" {
+		return data
+	}
+	return bytes.ReplaceAll(data, []byte("\n"), []byte(ending))
+}
@@ -0,0 +1,43 @@
+package format
+
+import "testing"
+
+func TestLineEnding(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"", "\n"},
+		{"one line", "\n"},
+		{"a\nb\n", "\n"},
+		{"a\r\nb\r\n", "\r\n"},
+		{"a\r\nb\r\nc\n", "\r\n"},
+		{"a\r\nb\nc\n", "\n"},
+		{"a\r\nb\n", "\n"}, // A tie keeps LF
+		{"a\rb\rc\n", "\n"},
+	}
+	for _, tt := range tests {
+		if got := LineEnding([]byte(tt.data)); got != tt.want {
+			t.Errorf("LineEnding(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestWithLineEnding(t *testing.T) {
+	tests := []struct {
+		data   string
+		ending string
+		want   string
+	}{
+		{"a\nb\n", "\r\n", "a\r\nb\r\n"},
+		{"a\r\nb\n", "\r\n", "a\r\nb\r\n"},
+		{"a\r\nb\r\n", "\n", "a\nb\n"},
+		{"a\rb\n", "\r\n", "a\rb\r\n"},
+		{"", "\r\n", ""},
+	}
+	for _, tt := range tests {
+		if got := string(WithLineEnding([]byte(tt.data), tt.ending)); got != tt.want {
+			t.Errorf("WithLineEnding(%q, %q) = %q, want %q", tt.data, tt.ending, got, tt.want)
+		}
+	}
+}
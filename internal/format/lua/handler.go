@@ -0,0 +1,899 @@
+// Package lua provides a handler for Lua config files that return a table
+// literal, such as Neovim plugin settings, for chezmoi-split.
+package lua
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// indentUnit is the indentation Serialize writes for each table level.
+const indentUnit = "  "
+
+// Expression is a table value that isn't a literal: a function call or
+// definition, a variable reference, an operator, a hexadecimal number, and so
+// on. It holds the source text, which Serialize writes back verbatim.
+type Expression string
+
+// Handler implements format.Handler for Lua files of the form
+// "return { ... }".
+//
+// Every table becomes an *orderedmap.OrderedMap in source order. A field
+// written name = v or ["name"] = v is keyed by its name; a list entry, or a
+// field with an integer key ([3] = v), is keyed by its index as a decimal
+// string, so the first list entry is at ["1"]. An integer key and a string key
+// with the same digits are therefore the same tree key. Values map to Go
+// types as follows: strings → string, decimal integers → int64, other
+// decimal numbers → float64, true/false → bool, nil → nil, tables →
+// *orderedmap.OrderedMap, anything else → Expression. Comments are dropped.
+type Handler struct{}
+
+// New creates a new Lua handler.
+func New() *Handler {
+	return &Handler{}
+}
+
+// Parse reads a Lua chunk consisting of "return" and a table constructor and
+// returns the table as an *orderedmap.OrderedMap.
+func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
+	if opts.StripComments {
+		return nil, fmt.Errorf("strip-comments is not supported for Lua format")
+	}
+
+	p := &parser{src: data}
+	p.skipSpace()
+	if !p.keyword("return") {
+		return nil, fmt.Errorf("failed to parse Lua: %w", p.errorf("expected \"return {\""))
+	}
+	p.skipSpace()
+	if p.peek() != '{' {
+		return nil, fmt.Errorf("failed to parse Lua: %w", p.errorf("expected a table after return"))
+	}
+	table, err := p.parseTable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Lua: %w", err)
+	}
+	p.skipSpace()
+	if p.peek() == ';' {
+		p.pos++
+		p.skipSpace()
+	}
+	if p.pos < len(p.src) {
+		return nil, fmt.Errorf("failed to parse Lua: %w", p.errorf("unexpected %q after the returned table", p.peek()))
+	}
+	return table, nil
+}
+
+// parser reads the subset of Lua a returned table literal needs.
+type parser struct {
+	src []byte
+	pos int
+}
+
+// errorf returns an error annotated with the current line number.
+func (p *parser) errorf(format string, args ...any) error {
+	line := bytes.Count(p.src[:min(p.pos, len(p.src))], []byte("\n")) + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// peek returns the byte at the current position, or 0 at end of input.
+func (p *parser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// hasPrefix reports whether the input at the current position starts with s.
+func (p *parser) hasPrefix(s string) bool {
+	return bytes.HasPrefix(p.src[p.pos:], []byte(s))
+}
+
+// keyword moves past word if the input continues with it as a whole word.
+func (p *parser) keyword(word string) bool {
+	end := p.pos + len(word)
+	if !p.hasPrefix(word) || (end < len(p.src) && isIdentChar(p.src[end])) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+// skipSpace skips whitespace and comments. An unterminated long comment runs
+// to the end of the input.
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == '\v':
+			p.pos++
+		case p.hasPrefix("--"):
+			p.pos += 2
+			if level, ok := p.longBracket(); ok {
+				if !p.skipLong(level) {
+					p.pos = len(p.src)
+				}
+				continue
+			}
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// longBracket reports whether the input at the current position opens a
+// long bracket ([[ or [==[), and its level (the number of '=').
+func (p *parser) longBracket() (int, bool) {
+	if p.peek() != '[' {
+		return 0, false
+	}
+	i := p.pos + 1
+	for i < len(p.src) && p.src[i] == '=' {
+		i++
+	}
+	if i >= len(p.src) || p.src[i] != '[' {
+		return 0, false
+	}
+	return i - p.pos - 1, true
+}
+
+// skipLong moves past a long bracket of the given level, opening and closing
+// brackets included. It reports false if the bracket is never closed.
+func (p *parser) skipLong(level int) bool {
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := bytes.Index(p.src[p.pos+level+2:], []byte(closing))
+	if end < 0 {
+		return false
+	}
+	p.pos += level + 2 + end + len(closing)
+	return true
+}
+
+// parseTable reads a table constructor into an ordered map.
+func (p *parser) parseTable() (*orderedmap.OrderedMap, error) {
+	p.pos++ // opening brace
+	om := orderedmap.New()
+	next := 1 // Index of the next list entry
+	for {
+		p.skipSpace()
+		switch {
+		case p.pos >= len(p.src):
+			return nil, p.errorf("unclosed table")
+		case p.peek() == '}':
+			p.pos++
+			return om, nil
+		}
+
+		key, keyed, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		if !keyed {
+			key = strconv.Itoa(next)
+			next++
+		}
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := om.Get(key); exists {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		om.Set(key, value)
+
+		p.skipSpace()
+		switch p.peek() {
+		case ',', ';':
+			p.pos++
+		case '}':
+		default:
+			return nil, p.errorf("expected ',' or '}' after table entry, found %q", p.peek())
+		}
+	}
+}
+
+// parseKey reads the "name =" or "[key] =" of a table field. It reports
+// false, without moving, for a list entry.
+func (p *parser) parseKey() (string, bool, error) {
+	start := p.pos
+	if _, long := p.longBracket(); p.peek() == '[' && !long {
+		p.pos++
+		p.skipSpace()
+		var key string
+		switch c := p.peek(); {
+		case c == '"' || c == '\'':
+			s, err := p.readString()
+			if err != nil {
+				return "", false, err
+			}
+			key = s
+		default:
+			n, ok := p.parseNumber()
+			i, isInt := n.(int64)
+			if !ok || !isInt {
+				return "", false, p.errorf("only string and integer keys are supported in [ ]")
+			}
+			key = strconv.FormatInt(i, 10)
+		}
+		p.skipSpace()
+		if p.peek() != ']' {
+			return "", false, p.errorf("expected ']' after table key")
+		}
+		p.pos++
+		p.skipSpace()
+		if p.peek() != '=' || p.hasPrefix("==") {
+			return "", false, p.errorf("expected '=' after table key")
+		}
+		p.pos++
+		return key, true, nil
+	}
+
+	if name := p.readIdent(); name != "" && !isReserved(name) {
+		p.skipSpace()
+		if p.peek() == '=' && !p.hasPrefix("==") {
+			p.pos++
+			return name, true, nil
+		}
+	}
+	p.pos = start
+	return "", false, nil
+}
+
+// parseValue reads a table entry's value: a literal if the entry is just a
+// literal, otherwise an Expression with the source text.
+func (p *parser) parseValue() (any, error) {
+	p.skipSpace()
+	start := p.pos
+	v, ok, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		end := p.pos
+		p.skipSpace()
+		if c := p.peek(); c == ',' || c == ';' || c == '}' {
+			p.pos = end
+			return v, nil
+		}
+	}
+
+	p.pos = start
+	raw, err := p.readExpression()
+	if err != nil {
+		return nil, err
+	}
+	if raw == "" {
+		return nil, p.errorf("missing table value")
+	}
+	return Expression(raw), nil
+}
+
+// parseLiteral reads a string, number, boolean, nil, or table. It reports
+// false for anything else; errors are for malformed strings and tables.
+func (p *parser) parseLiteral() (any, bool, error) {
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		s, err := p.readString()
+		return s, err == nil, err
+	case c == '[':
+		if _, long := p.longBracket(); long {
+			s, err := p.readLongString()
+			return s, err == nil, err
+		}
+	case c == '{':
+		t, err := p.parseTable()
+		return t, err == nil, err
+	case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		v, ok := p.parseNumber()
+		return v, ok, nil
+	case isIdentStart(c):
+		switch p.readIdent() {
+		case "true":
+			return true, true, nil
+		case "false":
+			return false, true, nil
+		case "nil":
+			return nil, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// parseNumber reads a decimal number literal as int64, or float64 when it
+// has a fraction or exponent or doesn't fit in an int64. Hexadecimal numbers
+// are not read, so they keep their spelling as an Expression.
+func (p *parser) parseNumber() (any, bool) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	isFloat := false
+scan:
+	for ; p.pos < len(p.src); p.pos++ {
+		switch c := p.src[p.pos]; {
+		case c >= '0' && c <= '9':
+		case c == '.' || c == 'e' || c == 'E':
+			isFloat = true
+		case (c == '+' || c == '-') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E'):
+		default:
+			break scan
+		}
+	}
+	if p.pos < len(p.src) && isIdentChar(p.src[p.pos]) {
+		return nil, false
+	}
+	text := string(p.src[start:p.pos])
+	if !isFloat {
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return i, true
+		}
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+// readIdent reads a name, returning "" if there is none.
+func (p *parser) readIdent() string {
+	if !isIdentStart(p.peek()) {
+		return ""
+	}
+	start := p.pos
+	for p.pos < len(p.src) && isIdentChar(p.src[p.pos]) {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+// readString reads a short string in single or double quotes and decodes
+// its escapes.
+func (p *parser) readString() (string, error) {
+	quote := p.src[p.pos]
+	p.pos++
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return sb.String(), nil
+		case c == '\n':
+			return "", p.errorf("unterminated string")
+		case c == '\\':
+			if err := p.readEscape(&sb); err != nil {
+				return "", err
+			}
+		default:
+			sb.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// simpleEscapes maps the letter after a backslash to the byte it stands
+// for. A backslash before a newline stands for the newline.
+var simpleEscapes = map[byte]byte{
+	'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v',
+	'\\': '\\', '"': '"', '\'': '\'', '\n': '\n',
+}
+
+// readEscape decodes the escape sequence at the current position into sb.
+func (p *parser) readEscape(sb *strings.Builder) error {
+	p.pos++ // backslash
+	if p.pos >= len(p.src) {
+		return p.errorf("unterminated string")
+	}
+	e := p.src[p.pos]
+	if b, ok := simpleEscapes[e]; ok {
+		sb.WriteByte(b)
+		p.pos++
+		return nil
+	}
+
+	switch {
+	case e == 'z':
+		p.pos++
+		for p.pos < len(p.src) && strings.IndexByte(" \t\r\n\f\v", p.src[p.pos]) >= 0 {
+			p.pos++
+		}
+	case e == 'x':
+		if p.pos+3 > len(p.src) {
+			return p.errorf("invalid \\x escape")
+		}
+		b, err := strconv.ParseUint(string(p.src[p.pos+1:p.pos+3]), 16, 8)
+		if err != nil {
+			return p.errorf("invalid \\x escape")
+		}
+		sb.WriteByte(byte(b))
+		p.pos += 3
+	case e >= '0' && e <= '9':
+		end := p.pos
+		for end < len(p.src) && end < p.pos+3 && p.src[end] >= '0' && p.src[end] <= '9' {
+			end++
+		}
+		b, err := strconv.ParseUint(string(p.src[p.pos:end]), 10, 8)
+		if err != nil {
+			return p.errorf("decimal escape too large")
+		}
+		sb.WriteByte(byte(b))
+		p.pos = end
+	case e == 'u' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '{':
+		end := bytes.IndexByte(p.src[p.pos:], '}')
+		if end < 0 {
+			return p.errorf("invalid \\u escape")
+		}
+		r, err := strconv.ParseUint(string(p.src[p.pos+2:p.pos+end]), 16, 31)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return p.errorf("invalid \\u escape")
+		}
+		sb.WriteRune(rune(r))
+		p.pos += end + 1
+	default:
+		return p.errorf("invalid escape \\%c", e)
+	}
+	return nil
+}
+
+// readLongString reads a long string ([[...]] or [==[...]==]). A newline
+// right after the opening bracket is not part of the string.
+func (p *parser) readLongString() (string, error) {
+	level, _ := p.longBracket()
+	start := p.pos
+	if !p.skipLong(level) {
+		p.pos = start
+		return "", p.errorf("unterminated long string")
+	}
+	s := string(p.src[start+level+2 : p.pos-level-2])
+	if strings.HasPrefix(s, "\r\n") {
+		return s[2:], nil
+	}
+	return strings.TrimPrefix(s, "\n"), nil
+}
+
+// readExpression returns the source text of the expression starting at the
+// current position. It ends at a ',' or ';' outside brackets and function
+// bodies, or at an unmatched closing bracket such as the table's '}';
+// comments after the expression are left out.
+func (p *parser) readExpression() (string, error) {
+	start, end := p.pos, p.pos
+	depth := 0 // Open brackets and blocks (function, if, do, repeat)
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case (c == ',' || c == ';') && depth == 0:
+			return string(p.src[start:end]), nil
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || p.hasPrefix("--"):
+			p.skipSpace()
+			continue
+		case c == '"' || c == '\'':
+			if _, err := p.readString(); err != nil {
+				return "", err
+			}
+		case c == '[':
+			if level, long := p.longBracket(); long {
+				if !p.skipLong(level) {
+					return "", p.errorf("unterminated long string")
+				}
+			} else {
+				depth++
+				p.pos++
+			}
+		case c == '(' || c == '{':
+			depth++
+			p.pos++
+		case c == ')' || c == ']' || c == '}':
+			if depth == 0 {
+				return string(p.src[start:end]), nil
+			}
+			depth--
+			p.pos++
+		case isIdentStart(c):
+			switch p.readIdent() {
+			case "function", "if", "do", "repeat":
+				depth++
+			case "end", "until":
+				depth--
+			}
+		default:
+			p.pos++
+		}
+		end = p.pos
+	}
+	if depth > 0 {
+		return "", p.errorf("unclosed bracket or block in expression")
+	}
+	return string(p.src[start:end]), nil
+}
+
+// isIdentStart reports whether c can start a name.
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isIdentChar reports whether c can continue a name.
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+// reserved lists the Lua keywords, which can't be written as bare keys.
+var reserved = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true,
+	"false": true, "for": true, "function": true, "goto": true, "if": true, "in": true,
+	"local": true, "nil": true, "not": true, "or": true, "repeat": true, "return": true,
+	"then": true, "true": true, "until": true, "while": true,
+}
+
+// isReserved reports whether name is a Lua keyword.
+func isReserved(name string) bool {
+	return reserved[name]
+}
+
+// isName reports whether s can be written as a bare key.
+func isName(s string) bool {
+	if s == "" || !isIdentStart(s[0]) || isReserved(s) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isIdentChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Serialize writes the tree as "return { ... }" in tree order: one entry per
+// line with two-space indentation and trailing commas. A table holding only
+// list entries of scalars is written on one line.
+func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, error) {
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return nil, fmt.Errorf("tree is not an ordered map")
+	}
+
+	table, err := renderTable(om, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize Lua: %w", err)
+	}
+	return []byte("return " + table + "\n"), nil
+}
+
+// field is one rendered table entry: its key prefix ("name = ", "[2] = ",
+// or "" for a list entry) and value.
+type field struct {
+	key   string
+	value string
+	inner bool // The value is a non-empty table
+}
+
+// renderTable writes a table. A key equal to the next list index is written
+// as a list entry, a name as name = v, and anything else in brackets.
+func renderTable(om *orderedmap.OrderedMap, depth int) (string, error) {
+	fields := make([]field, 0, len(om.Keys()))
+	next := 1
+	for _, key := range om.Keys() {
+		val, _ := om.Get(key)
+		prefix := ""
+		switch n, err := strconv.Atoi(key); {
+		case err == nil && key == strconv.Itoa(next):
+			next++
+		case err == nil && key == strconv.Itoa(n):
+			prefix = "[" + key + "] = "
+		case isName(key):
+			prefix = key + " = "
+		default:
+			prefix = "[" + quote(key) + "] = "
+		}
+		f, err := renderField(prefix, val, depth)
+		if err != nil {
+			return "", fmt.Errorf("key %q: %w", key, err)
+		}
+		fields = append(fields, f)
+	}
+	return writeFields(fields, depth), nil
+}
+
+// renderList writes an array as a table of list entries.
+func renderList(elems []any, depth int) (string, error) {
+	fields := make([]field, 0, len(elems))
+	for i, elem := range elems {
+		f, err := renderField("", elem, depth)
+		if err != nil {
+			return "", fmt.Errorf("element %d: %w", i, err)
+		}
+		fields = append(fields, f)
+	}
+	return writeFields(fields, depth), nil
+}
+
+// renderField renders one table entry's value for a table at depth.
+func renderField(prefix string, val any, depth int) (field, error) {
+	rendered, err := renderValue(val, depth+1)
+	if err != nil {
+		return field{}, err
+	}
+	inner := rendered != "{}" && (format.ToOrderedMapPtr(val) != nil || isList(val))
+	return field{key: prefix, value: rendered, inner: inner}, nil
+}
+
+// isList reports whether v is an array.
+func isList(v any) bool {
+	_, ok := v.([]any)
+	return ok
+}
+
+// writeFields joins rendered entries into a table at depth: on one line when
+// they are all single-line list entries that aren't tables, otherwise one
+// per line.
+func writeFields(fields []field, depth int) string {
+	if len(fields) == 0 {
+		return "{}"
+	}
+
+	oneLine := true
+	for _, f := range fields {
+		if f.key != "" || f.inner || strings.Contains(f.value, "\n") {
+			oneLine = false
+			break
+		}
+	}
+	if oneLine {
+		values := make([]string, len(fields))
+		for i, f := range fields {
+			values[i] = f.value
+		}
+		return "{ " + strings.Join(values, ", ") + " }"
+	}
+
+	inner := strings.Repeat(indentUnit, depth+1)
+	var sb strings.Builder
+	sb.WriteString("{\n")
+	for _, f := range fields {
+		sb.WriteString(inner + f.key + f.value + ",\n")
+	}
+	sb.WriteString(strings.Repeat(indentUnit, depth) + "}")
+	return sb.String()
+}
+
+// renderValue renders a value; nested tables are indented for depth.
+func renderValue(v any, depth int) (string, error) {
+	switch val := v.(type) {
+	case nil:
+		return "nil", nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	case int64:
+		return strconv.FormatInt(val, 10), nil
+	case float64:
+		return formatFloat(val), nil
+	case string:
+		return quote(val), nil
+	case Expression:
+		return string(val), nil
+	case []any:
+		return renderList(val, depth)
+	}
+
+	if om := format.ToOrderedMapPtr(v); om != nil {
+		return renderTable(om, depth)
+	}
+	return "", fmt.Errorf("unsupported value type %T", v)
+}
+
+// formatFloat writes f so that Lua reads it back as a float: whole numbers
+// get a ".0".
+func formatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+	return s
+}
+
+// quote writes s as a double-quoted Lua string. Control characters use
+// decimal escapes.
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\r':
+			sb.WriteString(`\r`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&sb, "\\%03d", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// GetPath extracts a value at the given path. Tables are addressed by key,
+// list entries by their index ("1" for the first), and arrays set through
+// SetPath by numeric index from 0. "*" matches any key or element and returns
+// the first match; with "**", the first match in document order is returned.
+func (h *Handler) GetPath(tree any, p path.Path) (any, bool) {
+	return getPath(tree, p.Segments(), 0)
+}
+
+// getPath recursively navigates maps and arrays.
+func getPath(current any, segments []string, idx int) (any, bool) {
+	if idx >= len(segments) {
+		return current, true
+	}
+
+	segment := segments[idx]
+
+	if om := format.ToOrderedMapPtr(current); om != nil {
+		switch segment {
+		case path.RecursiveWildcard:
+			// Recursive wildcard: match at this level first, then at any depth below
+			if idx == len(segments)-1 {
+				return nil, false
+			}
+			if result, ok := getPath(om, segments, idx+1); ok {
+				return result, true
+			}
+			for _, key := range om.Keys() {
+				val, _ := om.Get(key)
+				if result, ok := getPath(val, segments, idx); ok {
+					return result, true
+				}
+			}
+			return nil, false
+		case path.Wildcard:
+			for _, key := range om.Keys() {
+				val, _ := om.Get(key)
+				if result, ok := getPath(val, segments, idx+1); ok {
+					return result, true
+				}
+			}
+			return nil, false
+		}
+		val, exists := om.Get(segment)
+		if !exists {
+			return nil, false
+		}
+		return getPath(val, segments, idx+1)
+	}
+
+	if arr, ok := current.([]any); ok {
+		if segment == path.Wildcard {
+			for _, elem := range arr {
+				if result, ok := getPath(elem, segments, idx+1); ok {
+					return result, true
+				}
+			}
+			return nil, false
+		}
+		i, ok := format.ArrayIndex(segment, len(arr))
+		if !ok {
+			return nil, false
+		}
+		return getPath(arr[i], segments, idx+1)
+	}
+
+	return nil, false
+}
+
+// SetPath sets a value at the given path. "*" applies to every key or
+// element, and "**" to every existing match. Missing keys along the path are
+// created as tables; array indexes must already exist.
+func (h *Handler) SetPath(tree any, p path.Path, value any) error {
+	segments := p.Segments()
+	if len(segments) == 0 {
+		return fmt.Errorf("empty path")
+	}
+
+	value, err := format.NormalizeForFormat(value, "lua")
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %w", p, err)
+	}
+
+	return setPath(tree, segments, 0, value)
+}
+
+// setPath recursively sets values in maps and arrays.
+func setPath(current any, segments []string, idx int, value any) error {
+	segment := segments[idx]
+	isLast := idx == len(segments)-1
+
+	if om := format.ToOrderedMapPtr(current); om != nil {
+		switch segment {
+		case path.RecursiveWildcard:
+			// Recursive wildcard: apply to every existing match, never create keys
+			for _, keys := range format.ExpandPath(om, segments[idx:]) {
+				// Matches already exist, so setting them can't fail
+				_ = setPath(om, keys, 0, value)
+			}
+			return nil
+		case path.Wildcard:
+			for _, key := range om.Keys() {
+				if isLast {
+					om.Set(key, value)
+					continue
+				}
+				val, _ := om.Get(key)
+				// Continue to other keys even if one fails
+				_ = setPath(val, segments, idx+1, value)
+			}
+			return nil
+		}
+
+		if isLast {
+			om.Set(segment, value)
+			return nil
+		}
+
+		next, exists := om.Get(segment)
+		if !exists {
+			next = orderedmap.New()
+			om.Set(segment, next)
+		}
+		if format.ToOrderedMapPtr(next) == nil && !isList(next) {
+			return fmt.Errorf("path segment %q is not a table", segment)
+		}
+		return setPath(next, segments, idx+1, value)
+	}
+
+	if arr, ok := current.([]any); ok {
+		if segment == path.Wildcard {
+			for i := range arr {
+				if isLast {
+					arr[i] = value
+					continue
+				}
+				_ = setPath(arr[i], segments, idx+1, value)
+			}
+			return nil
+		}
+
+		i, ok := format.ArrayIndex(segment, len(arr))
+		if !ok {
+			return fmt.Errorf("array index %q out of range (length %d)", segment, len(arr))
+		}
+		if isLast {
+			arr[i] = value
+			return nil
+		}
+		if format.ToOrderedMapPtr(arr[i]) == nil && !isList(arr[i]) {
+			return fmt.Errorf("array element %d is not a table", i)
+		}
+		return setPath(arr[i], segments, idx+1, value)
+	}
+
+	return fmt.Errorf("cannot navigate into non-table value")
+}
+
+// DeletePath removes the value at the given path. Wildcards delete every
+// match, and an array element is removed from its array.
+func (h *Handler) DeletePath(tree any, p path.Path) error {
+	segments := p.Segments()
+	if len(segments) == 0 {
+		return fmt.Errorf("empty path")
+	}
+	return format.DeleteMatches(tree, segments)
+}
+
+// Ensure Handler implements format.Handler.
+var _ format.Handler = (*Handler)(nil)
@@ -0,0 +1,357 @@
+package lua
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/formattest"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// pluginDoc is a Neovim plugin config in canonical form.
+const pluginDoc = `return {
+  colorscheme = "tokyonight",
+  number = true,
+  tabstop = 4,
+  scrolloff = 0.5,
+  ensure_installed = { "lua", "vim", "go" },
+  plugins = {
+    telescope = {
+      defaults = {
+        prompt_prefix = "> ",
+        layout_strategy = "horizontal",
+      },
+      on_attach = function(client, bufnr)
+        vim.keymap.set("n", "gd", vim.lsp.buf.definition, { buffer = bufnr })
+      end,
+    },
+    treesitter = {
+      highlight = {
+        enable = true,
+      },
+    },
+  },
+  data_dir = vim.fn.stdpath("data") .. "/site",
+}
+`
+
+func TestHandler_Parse(t *testing.T) {
+	h := New()
+	tree, err := h.Parse([]byte(pluginDoc), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	om := tree.(*orderedmap.OrderedMap)
+	wantKeys := []string{"colorscheme", "number", "tabstop", "scrolloff", "ensure_installed", "plugins", "data_dir"}
+	if !reflect.DeepEqual(om.Keys(), wantKeys) {
+		t.Fatalf("keys = %q, want %q", om.Keys(), wantKeys)
+	}
+
+	tests := []struct {
+		path []string
+		want any
+	}{
+		{[]string{"colorscheme"}, "tokyonight"},
+		{[]string{"number"}, true},
+		{[]string{"tabstop"}, int64(4)},
+		{[]string{"scrolloff"}, 0.5},
+		{[]string{"ensure_installed", "2"}, "vim"},
+		{[]string{"plugins", "telescope", "defaults", "prompt_prefix"}, "> "},
+		{[]string{"plugins", "treesitter", "highlight", "enable"}, true},
+		{[]string{"plugins", "telescope", "on_attach"}, Expression(`function(client, bufnr)
+        vim.keymap.set("n", "gd", vim.lsp.buf.definition, { buffer = bufnr })
+      end`)},
+		{[]string{"data_dir"}, Expression(`vim.fn.stdpath("data") .. "/site"`)},
+	}
+	for _, tt := range tests {
+		got, ok := h.GetPath(tree, path.NewArrayPath(tt.path))
+		if !ok {
+			t.Errorf("GetPath(%v) not found", tt.path)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetPath(%v) = %#v, want %#v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestHandler_Parse_Syntax(t *testing.T) {
+	h := New()
+
+	input := `-- Options for the plugin
+--[[ a long
+comment ]]
+return {
+  'single', "double";
+  [[long
+string]],
+  [==[with ]] inside]==],
+  ["key with spaces"] = "a\tb\65\x42\u{263A}\z
+                          c",
+  [10] = "ten",
+  ["and"] = 1,
+  hex = 0x1F,
+  neg = -2,
+  exp = 1e3,
+  big = 2^53,
+  nothing = nil,
+  call = require("x").setup { a = 1, b = { 2, 3 } },
+  cond = (function() if x then return 1 else return 2 end end)(),
+  empty = {},
+}
+`
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	om := tree.(*orderedmap.OrderedMap)
+	want := []struct {
+		key  string
+		want any
+	}{
+		{"1", "single"},
+		{"2", "double"},
+		{"3", "long\nstring"},
+		{"4", "with ]] inside"},
+		{"key with spaces", "a\tbAB☺c"},
+		{"10", "ten"},
+		{"and", int64(1)},
+		{"hex", Expression("0x1F")},
+		{"neg", int64(-2)},
+		{"exp", 1000.0},
+		{"big", Expression("2^53")},
+		{"nothing", nil},
+		{"call", Expression(`require("x").setup { a = 1, b = { 2, 3 } }`)},
+		{"cond", Expression(`(function() if x then return 1 else return 2 end end)()`)},
+		{"empty", orderedmap.New()},
+	}
+	if len(om.Keys()) != len(want) {
+		t.Fatalf("keys = %q, want %d keys", om.Keys(), len(want))
+	}
+	for _, tt := range want {
+		got, _ := om.Get(tt.key)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %#v, want %#v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestHandler_Parse_Errors(t *testing.T) {
+	h := New()
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"no return", "local M = {}\nreturn M\n", `expected "return {"`},
+		{"not a table", "return 42\n", "expected a table after return"},
+		{"trailing code", "return {}\nprint(1)\n", "unexpected 'p' after the returned table"},
+		{"unclosed table", "return {\n  a = 1,\n", "line 3: unclosed table"},
+		{"unbalanced", "return { a = f(1)) }", "expected ',' or '}' after table entry, found ')'"},
+		{"duplicate key", "return { a = 1, a = 2 }", `duplicate key "a"`},
+		{"list index taken", "return { [1] = 'x', 'y' }", `duplicate key "1"`},
+		{"unterminated string", "return { a = 'x }", "unterminated string"},
+		{"invalid escape", `return { a = "\q" }`, `invalid escape \q`},
+		{"unsupported key", "return { [true] = 1 }", "only string and integer keys"},
+		{"unclosed call", "return { a = f(1,\n", "unclosed bracket"},
+		{"strip-comments", "return {}", "strip-comments is not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := h.Parse([]byte(tt.input), format.ParseOptions{StripComments: tt.name == "strip-comments"})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_Serialize_Canonical(t *testing.T) {
+	h := New()
+
+	input := `-- Plugin options
+return {
+  plugins={telescope={defaults={prompt_prefix='> '}}},
+  'first'; ['with space']=1, [5]=true,
+  second_list = {'a',
+    'b'},
+  ["end"] = 2.0,
+  on_attach = function() return 1, 2 end, -- keymaps
+}`
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := `return {
+  plugins = {
+    telescope = {
+      defaults = {
+        prompt_prefix = "> ",
+      },
+    },
+  },
+  "first",
+  ["with space"] = 1,
+  [5] = true,
+  second_list = { "a", "b" },
+  ["end"] = 2.0,
+  on_attach = function() return 1, 2 end,
+}
+`
+	if string(data) != want {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestHandler_Serialize_NewValues(t *testing.T) {
+	h := New()
+	tree, _ := h.Parse([]byte("return {}"), format.ParseOptions{})
+
+	values := []struct {
+		path  []string
+		value any
+	}{
+		{[]string{"name"}, "tab\there \"q\" \x01"},
+		{[]string{"count"}, 3},
+		{[]string{"ratio"}, float32(1)},
+		{[]string{"list"}, []any{"a", 1, map[string]any{"x": true}}},
+		{[]string{"opts", "enabled"}, false},
+		{[]string{"callback"}, Expression("function() end")},
+	}
+	for _, v := range values {
+		if err := h.SetPath(tree, path.NewArrayPath(v.path), v.value); err != nil {
+			t.Fatalf("SetPath(%v) error = %v", v.path, err)
+		}
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := `return {
+  name = "tab\there \"q\" \001",
+  count = 3,
+  ratio = 1.0,
+  list = {
+    "a",
+    1,
+    {
+      x = true,
+    },
+  },
+  opts = {
+    enabled = false,
+  },
+  callback = function() end,
+}
+`
+	if string(data) != want {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, want)
+	}
+
+	// The output is valid input
+	if _, err := h.Parse(data, format.ParseOptions{}); err != nil {
+		t.Errorf("Parse(Serialize()) error = %v", err)
+	}
+}
+
+// TestHandler_KeepsCurrentSubtree checks the handler's part of an ignore
+// rule: a user-tweaked subtable, expressions included, moves from the
+// current file into the managed tree intact.
+func TestHandler_KeepsCurrentSubtree(t *testing.T) {
+	h := New()
+	managed, err := h.Parse([]byte(pluginDoc), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(managed) error = %v", err)
+	}
+	current, err := h.Parse([]byte(`return {
+  colorscheme = "gruvbox",
+  plugins = {
+    telescope = {
+      defaults = {
+        prompt_prefix = "🔍 ",
+        mappings = { i = { ["<C-j>"] = require("telescope.actions").move_selection_next } },
+      },
+    },
+  },
+}`), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(current) error = %v", err)
+	}
+
+	p := path.NewArrayPath([]string{"plugins", "telescope", "defaults"})
+	val, ok := h.GetPath(current, p)
+	if !ok {
+		t.Fatal("GetPath(current) not found")
+	}
+	if err := h.SetPath(managed, p, val); err != nil {
+		t.Fatalf("SetPath() error = %v", err)
+	}
+
+	data, err := h.Serialize(managed, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := strings.Replace(pluginDoc, `      defaults = {
+        prompt_prefix = "> ",
+        layout_strategy = "horizontal",
+      },`, `      defaults = {
+        prompt_prefix = "🔍 ",
+        mappings = {
+          i = {
+            ["<C-j>"] = require("telescope.actions").move_selection_next,
+          },
+        },
+      },`, 1)
+	if string(data) != want {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestHandler_SetPath_Errors(t *testing.T) {
+	h := New()
+	tree, _ := h.Parse([]byte(`return { name = "x", list = { 1 } }`), format.ParseOptions{})
+
+	tests := []struct {
+		name  string
+		path  []string
+		value any
+	}{
+		{"through a scalar", []string{"name", "sub"}, 1},
+		{"bytes", []string{"blob"}, []byte("x")},
+		{"NaN", []string{"ratio"}, math.NaN()},
+		{"empty path", nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := h.SetPath(tree, path.NewArrayPath(tt.path), tt.value); err == nil {
+				t.Error("SetPath() expected error")
+			}
+		})
+	}
+}
+
+func TestHandler_Conformance(t *testing.T) {
+	formattest.RunConformance(t, New(), formattest.Fixtures{
+		Document:        pluginDoc,
+		RoundTripExact:  true,
+		OrderedKeys:     []string{"colorscheme", "number", "tabstop", "scrolloff", "ensure_installed", "plugins", "data_dir"},
+		LeafPath:        []string{"plugins", "telescope", "defaults", "layout_strategy"},
+		LeafValue:       "horizontal",
+		WildcardPath:    []string{"plugins", "*", "highlight", "enable"},
+		WildcardMatches: [][]string{{"plugins", "treesitter", "highlight", "enable"}},
+		DeepPath:        []string{"new", "nested", "key"},
+	})
+}
@@ -0,0 +1,537 @@
+// Package nginx provides a handler for nginx-style configs, with directives
+// ended by ";" and nested "name { ... }" blocks, for chezmoi-split.
+package nginx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// defaultIndent is used for nested lines when no parsed document had an
+// indented directive.
+const defaultIndent = "    "
+
+// entry records the comments around a directive in a parsed document.
+type entry struct {
+	above   []string // Comment and blank lines before the directive
+	comment string   // Comment on the same line, after the ";" or "{"
+	closing []string // Blocks: comment and blank lines before the "}"
+	trailer string   // Blocks: comment on the same line, after the "}"
+}
+
+// token is a word, one of ";", "{", "}", or a "#" comment.
+type token struct {
+	text string
+	line int
+}
+
+// Handler implements format.Handler for nginx-style configs.
+//
+// The tree is an *orderedmap.OrderedMap of directives in order. A simple
+// directive ("listen 80;") maps its name to its arguments as written, quotes
+// included ("80"); a directive without arguments maps to "". A block maps to
+// an *orderedmap.OrderedMap of its directives, keyed by the block name alone
+// ("http", "server") or, when the block has arguments, by the name and
+// arguments ("location /api", "upstream backend"). A name that appears more
+// than once in a block becomes a []any with one element per occurrence, so
+// a second server block is ["http", "server", "1"].
+//
+// Serialize writes one directive per line with consistent indentation, taken
+// from the first indented line the handler has seen. Comments are kept from
+// the first document that has the directive.
+type Handler struct {
+	entries map[string]entry
+	indent  string   // Indentation of the first indented directive seen
+	footer  []string // Comment lines after the last directive of the first document
+	parsed  bool
+}
+
+// New creates a new nginx handler.
+func New() *Handler {
+	return &Handler{entries: make(map[string]entry)}
+}
+
+// entryKey returns the entries key for the n-th occurrence of key inside the
+// block at prefix.
+func entryKey(prefix, key string, n int) string {
+	return prefix + "\x00" + key + "\x00" + strconv.Itoa(n)
+}
+
+// Parse reads an nginx-style config and returns an *orderedmap.OrderedMap.
+func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
+	if opts.StripComments {
+		return nil, fmt.Errorf("strip-comments is not supported for nginx format")
+	}
+
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse nginx: %w", err)
+	}
+	if h.indent == "" {
+		h.indent = firstIndent(text)
+	}
+
+	p := &parser{h: h, tokens: tokens}
+	result := orderedmap.New()
+	if err := p.parseBlock(result, "", false); err != nil {
+		return nil, fmt.Errorf("failed to parse nginx: %w", err)
+	}
+
+	if !h.parsed {
+		h.footer = trimBlank(p.pending)
+		h.parsed = true
+	}
+	return result, nil
+}
+
+// tokenize splits text into words, ";", "{", "}", and comments. Quoted
+// strings and ${variable} references are part of the word they appear in.
+func tokenize(text string) ([]token, error) {
+	var tokens []token
+	line := 1
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			end := strings.IndexByte(text[i:], '\n')
+			if end < 0 {
+				end = len(text) - i
+			}
+			tokens = append(tokens, token{strings.TrimRight(text[i:i+end], " \t\r"), line})
+			i += end
+		case c == ';' || c == '{' || c == '}':
+			tokens = append(tokens, token{string(c), line})
+			i++
+		default:
+			start, startLine := i, line
+			for i < len(text) && !strings.ContainsRune(" \t\r\n;{}#", rune(text[i])) {
+				switch {
+				case text[i] == '"' || text[i] == '\'':
+					quote := text[i]
+					i++
+					for i < len(text) && text[i] != quote {
+						if text[i] == '\\' {
+							i++
+						} else if text[i] == '\n' {
+							line++
+						}
+						i++
+					}
+					if i >= len(text) {
+						return nil, fmt.Errorf("line %d: unterminated string", startLine)
+					}
+					i++
+				case text[i] == '$' && i+1 < len(text) && text[i+1] == '{':
+					end := strings.IndexByte(text[i:], '}')
+					if end < 0 {
+						return nil, fmt.Errorf("line %d: unterminated variable", line)
+					}
+					i += end + 1
+				default:
+					i++
+				}
+			}
+			tokens = append(tokens, token{text[start:i], startLine})
+		}
+	}
+	return tokens, nil
+}
+
+// firstIndent returns the leading whitespace of the first indented line
+// that isn't blank.
+func firstIndent(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && len(trimmed) < len(line) {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+	return ""
+}
+
+// parser builds the tree from tokens, recording comments in the handler.
+type parser struct {
+	h       *Handler
+	tokens  []token
+	pos     int
+	pending []string // Comment and blank lines not yet attached to a directive
+	lastKey string   // Entries key of the directive that ended last
+	closed  bool     // Whether the last directive ended with its block's "}"
+	endLine int      // Line of the last ";", "{", or "}"
+}
+
+// note adds a blank line to the pending lines when tok starts after a gap.
+func (p *parser) note(tok token) {
+	if p.endLine > 0 && tok.line > p.endLine+1 {
+		p.pending = append(p.pending, "")
+	}
+}
+
+// parseBlock reads directives into om until the "}" that closes the block
+// (nested) or the end of input (top level).
+func (p *parser) parseBlock(om *orderedmap.OrderedMap, prefix string, nested bool) error {
+	for p.pos < len(p.tokens) {
+		tok := p.tokens[p.pos]
+		p.pos++
+
+		switch tok.text {
+		case ";", "{":
+			return fmt.Errorf("line %d: unexpected %q", tok.line, tok.text)
+		case "}":
+			if !nested {
+				return fmt.Errorf("line %d: unexpected \"}\"", tok.line)
+			}
+			p.note(tok)
+			p.endLine = tok.line
+			return nil
+		}
+
+		if strings.HasPrefix(tok.text, "#") {
+			if tok.line == p.endLine && p.lastKey != "" {
+				if e, ok := p.h.entries[p.lastKey]; ok {
+					if p.closed && e.trailer == "" {
+						e.trailer = tok.text
+					} else if !p.closed && e.comment == "" {
+						e.comment = tok.text
+					}
+					p.h.entries[p.lastKey] = e
+				}
+				continue
+			}
+			p.note(tok)
+			p.pending = append(p.pending, tok.text)
+			p.endLine = tok.line
+			continue
+		}
+
+		// A directive: the name, then arguments up to ";" or "{"
+		p.note(tok)
+		above := p.pending
+		p.pending = nil
+		name := tok.text
+		var args []string
+		var end token
+		for {
+			if p.pos >= len(p.tokens) {
+				return fmt.Errorf("line %d: directive %q has no terminating \";\"", tok.line, name)
+			}
+			end = p.tokens[p.pos]
+			p.pos++
+			if end.text == ";" || end.text == "{" {
+				break
+			}
+			if end.text == "}" {
+				return fmt.Errorf("line %d: directive %q has no terminating \";\"", tok.line, name)
+			}
+			if !strings.HasPrefix(end.text, "#") {
+				args = append(args, end.text)
+			}
+		}
+		p.endLine = end.line
+
+		key, value := name, any(strings.Join(args, " "))
+		var block *orderedmap.OrderedMap
+		if end.text == "{" {
+			if len(args) > 0 {
+				key = name + " " + strings.Join(args, " ")
+			}
+			block = orderedmap.New()
+			value = block
+		}
+
+		n := addValue(om, key, value)
+		ek := entryKey(prefix, key, n)
+		if _, seen := p.h.entries[ek]; !seen {
+			p.h.entries[ek] = entry{above: above}
+		}
+		p.lastKey = ek
+		p.closed = false
+
+		if block != nil {
+			if err := p.parseBlock(block, ek, true); err != nil {
+				return err
+			}
+			if e := p.h.entries[ek]; e.closing == nil {
+				e.closing = trimBlank(p.pending)
+				p.h.entries[ek] = e
+			}
+			p.pending = nil
+			p.lastKey = ek
+			p.closed = true
+		}
+	}
+
+	if nested {
+		return fmt.Errorf("unexpected end of file, expecting \"}\"")
+	}
+	return nil
+}
+
+// addValue adds a directive to a block, turning a repeated key into a []any.
+// It returns the occurrence's index among the key's values.
+func addValue(om *orderedmap.OrderedMap, key string, value any) int {
+	existing, exists := om.Get(key)
+	if !exists {
+		om.Set(key, value)
+		return 0
+	}
+
+	values, ok := existing.([]any)
+	if !ok {
+		values = []any{existing}
+	}
+	om.Set(key, append(values, value))
+	return len(values)
+}
+
+// trimBlank drops trailing blank lines.
+func trimBlank(lines []string) []string {
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Serialize writes each directive on its own line, nested blocks indented
+// one level per depth, with the recorded comments.
+func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, error) {
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return nil, fmt.Errorf("tree is not an ordered map")
+	}
+
+	indent := h.indent
+	if indent == "" {
+		indent = defaultIndent
+	}
+
+	var sb strings.Builder
+	if err := h.writeBlock(&sb, om, "", "", indent); err != nil {
+		return nil, err
+	}
+	writeComments(&sb, h.footer, "")
+	return []byte(sb.String()), nil
+}
+
+// writeBlock writes the directives of om at the given depth prefix.
+func (h *Handler) writeBlock(sb *strings.Builder, om *orderedmap.OrderedMap, prefix, pad, indent string) error {
+	for _, key := range om.Keys() {
+		val, _ := om.Get(key)
+		values, ok := val.([]any)
+		if !ok {
+			values = []any{val}
+		}
+
+		for n, v := range values {
+			ek := entryKey(prefix, key, n)
+			ent := h.entries[ek]
+			writeComments(sb, ent.above, pad)
+
+			comment := ""
+			if ent.comment != "" {
+				comment = " " + ent.comment
+			}
+
+			if block := format.ToOrderedMapPtr(v); block != nil {
+				sb.WriteString(pad + key + " {" + comment + "\n")
+				if err := h.writeBlock(sb, block, ek, pad+indent, indent); err != nil {
+					return err
+				}
+				writeComments(sb, ent.closing, pad+indent)
+				trailer := ""
+				if ent.trailer != "" {
+					trailer = " " + ent.trailer
+				}
+				sb.WriteString(pad + "}" + trailer + "\n")
+				continue
+			}
+
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("directive %q has unsupported value type %T", key, v)
+			}
+			if s != "" {
+				s = " " + s
+			}
+			sb.WriteString(pad + key + s + ";" + comment + "\n")
+		}
+	}
+	return nil
+}
+
+// writeComments writes recorded comment and blank lines at the given
+// indentation. A blank line at the start of the output or of a block is
+// dropped, and runs of blank lines are collapsed.
+func writeComments(sb *strings.Builder, lines []string, pad string) {
+	for i, line := range lines {
+		if line == "" {
+			out := sb.String()
+			if i > 0 && lines[i-1] == "" || out == "" || strings.HasSuffix(out, "{\n") || strings.HasSuffix(out, "\n\n") {
+				continue
+			}
+			sb.WriteByte('\n')
+			continue
+		}
+		sb.WriteString(pad + line + "\n")
+	}
+}
+
+// GetPath extracts a value at the given path. Blocks are addressed by key
+// and repeated directives by numeric index; "*" matches any key or element
+// and returns the first match.
+func (h *Handler) GetPath(tree any, p path.Path) (any, bool) {
+	return getPath(tree, p.Segments(), 0)
+}
+
+// getPath recursively navigates blocks and repeated directives.
+func getPath(current any, segments []string, idx int) (any, bool) {
+	if idx >= len(segments) {
+		return current, true
+	}
+
+	segment := segments[idx]
+
+	if om := format.ToOrderedMapPtr(current); om != nil {
+		if segment == path.Wildcard {
+			for _, key := range om.Keys() {
+				val, _ := om.Get(key)
+				if result, ok := getPath(val, segments, idx+1); ok {
+					return result, true
+				}
+			}
+			return nil, false
+		}
+		val, exists := om.Get(segment)
+		if !exists {
+			return nil, false
+		}
+		return getPath(val, segments, idx+1)
+	}
+
+	if arr, ok := current.([]any); ok {
+		if segment == path.Wildcard {
+			for _, elem := range arr {
+				if result, ok := getPath(elem, segments, idx+1); ok {
+					return result, true
+				}
+			}
+			return nil, false
+		}
+		i, ok := format.ArrayIndex(segment, len(arr))
+		if !ok {
+			return nil, false
+		}
+		return getPath(arr[i], segments, idx+1)
+	}
+
+	return nil, false
+}
+
+// SetPath sets a value at the given path. A value is a string of arguments,
+// a map (a block), or an array of either (a repeated directive). "*"
+// applies to every key or element. Missing blocks along the path are
+// created; indexes must already exist.
+func (h *Handler) SetPath(tree any, p path.Path, value any) error {
+	segments := p.Segments()
+	if len(segments) == 0 {
+		return fmt.Errorf("empty path")
+	}
+
+	value, err := format.NormalizeForFormat(value, "nginx")
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %w", p, err)
+	}
+
+	return setPath(tree, segments, 0, value)
+}
+
+// setPath recursively sets values in blocks and repeated directives.
+func setPath(current any, segments []string, idx int, value any) error {
+	segment := segments[idx]
+	isLast := idx == len(segments)-1
+
+	if om := format.ToOrderedMapPtr(current); om != nil {
+		if segment == path.Wildcard {
+			for _, key := range om.Keys() {
+				if isLast {
+					om.Set(key, value)
+					continue
+				}
+				val, _ := om.Get(key)
+				// Continue to other keys even if one fails
+				_ = setPath(val, segments, idx+1, value)
+			}
+			return nil
+		}
+
+		if isLast {
+			om.Set(segment, value)
+			return nil
+		}
+
+		next, exists := om.Get(segment)
+		if !exists {
+			next = orderedmap.New()
+			om.Set(segment, next)
+		}
+		if format.ToOrderedMapPtr(next) == nil {
+			if _, ok := next.([]any); !ok {
+				return fmt.Errorf("directive %q is not a block", segment)
+			}
+		}
+		return setPath(next, segments, idx+1, value)
+	}
+
+	if arr, ok := current.([]any); ok {
+		if segment == path.Wildcard {
+			for i := range arr {
+				if isLast {
+					arr[i] = value
+					continue
+				}
+				_ = setPath(arr[i], segments, idx+1, value)
+			}
+			return nil
+		}
+
+		i, ok := format.ArrayIndex(segment, len(arr))
+		if !ok {
+			return fmt.Errorf("index %q out of range (%d occurrences)", segment, len(arr))
+		}
+		if isLast {
+			arr[i] = value
+			return nil
+		}
+		if format.ToOrderedMapPtr(arr[i]) == nil {
+			return fmt.Errorf("occurrence %d of the directive is not a block", i)
+		}
+		return setPath(arr[i], segments, idx+1, value)
+	}
+
+	return fmt.Errorf("cannot navigate into a simple directive")
+}
+
+// DeletePath removes the value at the given path. "*" deletes every key or
+// element it matches, and an index removes one occurrence of a repeated
+// directive.
+func (h *Handler) DeletePath(tree any, p path.Path) error {
+	segments := p.Segments()
+	if len(segments) == 0 {
+		return fmt.Errorf("empty path")
+	}
+	return format.DeleteMatches(tree, segments)
+}
+
+// Ensure Handler implements format.Handler.
+var _ format.Handler = (*Handler)(nil)
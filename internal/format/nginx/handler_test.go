@@ -0,0 +1,293 @@
+package nginx
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/formattest"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+func TestHandler_Parse(t *testing.T) {
+	h := New()
+
+	input := `worker_processes auto;
+
+events {
+    worker_connections 1024;
+}
+
+http {
+    include mime.types;
+    gzip on;
+
+    server {
+        listen 80;
+        server_name example.com;
+        location / {
+            return 301 https://$host$request_uri;
+        }
+    }
+
+    server {
+        listen 443 ssl;
+        add_header X-Frame-Options "SAMEORIGIN";
+        location /api {
+            proxy_pass http://backend;
+        }
+    }
+}
+`
+
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	om := tree.(*orderedmap.OrderedMap)
+	wantKeys := []string{"worker_processes", "events", "http"}
+	if !reflect.DeepEqual(om.Keys(), wantKeys) {
+		t.Fatalf("keys = %q, want %q", om.Keys(), wantKeys)
+	}
+
+	tests := []struct {
+		path []string
+		want any
+	}{
+		{[]string{"worker_processes"}, "auto"},
+		{[]string{"events", "worker_connections"}, "1024"},
+		{[]string{"http", "gzip"}, "on"},
+		{[]string{"http", "server", "0", "listen"}, "80"},
+		{[]string{"http", "server", "0", "location /", "return"}, "301 https://$host$request_uri"},
+		{[]string{"http", "server", "1", "listen"}, "443 ssl"},
+		{[]string{"http", "server", "1", "add_header"}, `X-Frame-Options "SAMEORIGIN"`},
+		{[]string{"http", "server", "1", "location /api", "proxy_pass"}, "http://backend"},
+		{[]string{"http", "server", "*", "listen"}, "80"},
+	}
+	for _, tt := range tests {
+		got, ok := h.GetPath(tree, path.NewArrayPath(tt.path))
+		if !ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetPath(%q) = %#v, %v; want %#v", tt.path, got, ok, tt.want)
+		}
+	}
+
+	servers, _ := h.GetPath(tree, path.NewArrayPath([]string{"http", "server"}))
+	if arr, ok := servers.([]any); !ok || len(arr) != 2 {
+		t.Errorf("server = %#v, want a list of 2 blocks", servers)
+	}
+}
+
+func TestHandler_Parse_Quoting(t *testing.T) {
+	input := `log_format main '$remote_addr - "$request"; {x}';
+set $path "${root}/a b";
+`
+	h := New()
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	got, _ := h.GetPath(tree, path.NewArrayPath([]string{"log_format"}))
+	if want := `main '$remote_addr - "$request"; {x}'`; got != want {
+		t.Errorf("log_format = %q, want %q", got, want)
+	}
+	got, _ = h.GetPath(tree, path.NewArrayPath([]string{"set"}))
+	if want := `$path "${root}/a b"`; got != want {
+		t.Errorf("set = %q, want %q", got, want)
+	}
+}
+
+func TestHandler_Parse_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"unclosed block", "http {\n    gzip on;\n", "unexpected end of file"},
+		{"stray brace", "gzip on;\n}\n", `line 2: unexpected "}"`},
+		{"missing semicolon", "gzip on\n", `directive "gzip" has no terminating ";"`},
+		{"missing semicolon in block", "http {\n    gzip on\n}\n", `line 2: directive "gzip"`},
+		{"unterminated string", "add_header X \"oops;\n", "line 1: unterminated string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Parse([]byte(tt.input), format.ParseOptions{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := New().Parse([]byte("gzip on;\n"), format.ParseOptions{StripComments: true}); err == nil {
+		t.Error("Parse() with StripComments should fail")
+	}
+}
+
+func TestHandler_RoundTrip_PreservesLayout(t *testing.T) {
+	input := `# Main config
+user www-data;
+
+http {
+  # Compression
+  gzip on; # saves bandwidth
+
+  server {
+    listen 80;
+    # TODO: redirect
+  } # plain http
+}
+
+# end
+`
+	h := New()
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	out, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if string(out) != input {
+		t.Errorf("round trip =\n%s\nwant\n%s", out, input)
+	}
+}
+
+func TestHandler_Serialize_NormalizesIndentation(t *testing.T) {
+	input := `http {
+    server {
+listen 80;
+          location / { root /srv; }
+    }
+}
+`
+	want := `http {
+    server {
+        listen 80;
+        location / {
+            root /srv;
+        }
+    }
+}
+`
+	h := New()
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	out, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if string(out) != want {
+		t.Errorf("Serialize() =\n%s\nwant\n%s", out, want)
+	}
+}
+
+// TestHandler_KeepsCertbotLines merges the way an ignore rule does: the
+// ssl_certificate lines certbot added to the current file are copied into
+// the managed tree, which keeps its own directive order.
+func TestHandler_KeepsCertbotLines(t *testing.T) {
+	managed := `server {
+    listen 443 ssl;
+    server_name example.com;
+    root /srv/www;
+}
+`
+	current := `server {
+    server_name example.com;
+    listen 443 ssl;
+    root /var/www;
+    ssl_certificate /etc/letsencrypt/live/example.com/fullchain.pem; # managed by Certbot
+    ssl_certificate_key /etc/letsencrypt/live/example.com/privkey.pem; # managed by Certbot
+}
+`
+	h := New()
+	mTree, err := h.Parse([]byte(managed), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(managed) error = %v", err)
+	}
+	cTree, err := h.Parse([]byte(current), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(current) error = %v", err)
+	}
+
+	for _, key := range []string{"ssl_certificate", "ssl_certificate_key"} {
+		p := path.NewArrayPath([]string{"server", key})
+		v, ok := h.GetPath(cTree, p)
+		if !ok {
+			t.Fatalf("GetPath(current, %s) not found", p)
+		}
+		if err := h.SetPath(mTree, p, v); err != nil {
+			t.Fatalf("SetPath(%s) error = %v", p, err)
+		}
+	}
+
+	out, err := h.Serialize(mTree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := `server {
+    listen 443 ssl;
+    server_name example.com;
+    root /srv/www;
+    ssl_certificate /etc/letsencrypt/live/example.com/fullchain.pem; # managed by Certbot
+    ssl_certificate_key /etc/letsencrypt/live/example.com/privkey.pem; # managed by Certbot
+}
+`
+	if string(out) != want {
+		t.Errorf("Serialize() =\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestHandler_SetPath_Errors(t *testing.T) {
+	h := New()
+	tree, err := h.Parse([]byte("gzip on;\nhttp {\n    server { listen 80; }\n    server { listen 443; }\n}\n"), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		path  []string
+		value any
+		want  string
+	}{
+		{"into simple directive", []string{"gzip", "level"}, "1", `"gzip" is not a block`},
+		{"index out of range", []string{"http", "server", "2", "listen"}, "8080", "out of range"},
+		{"nested array", []string{"http", "include"}, []any{[]any{"a"}}, "nested arrays are not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := h.SetPath(tree, path.NewArrayPath(tt.path), tt.value)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("SetPath() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_Conformance(t *testing.T) {
+	formattest.RunConformance(t, New(), formattest.Fixtures{
+		Document: `zebra on;
+
+apple {
+    size 16;
+}
+
+mango {
+    size 4;
+}
+`,
+		RoundTripExact:  true,
+		OrderedKeys:     []string{"zebra", "apple", "mango"},
+		LeafPath:        []string{"apple", "size"},
+		LeafValue:       "16",
+		WildcardPath:    []string{"*", "size"},
+		WildcardMatches: [][]string{{"apple", "size"}, {"mango", "size"}},
+		DeepPath:        []string{"new", "block", "size"},
+	})
+}
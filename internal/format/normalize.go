@@ -0,0 +1,424 @@
+package format
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+// UnsupportedValueError reports a value that a format cannot represent.
+type UnsupportedValueError struct {
+	Format string   // Target format name
+	Type   string   // Go type of the offending value
+	Keys   []string // Location of the value inside the value being set, if nested
+	Reason string   // Why the value was rejected
+}
+
+func (e *UnsupportedValueError) Error() string {
+	where := ""
+	if len(e.Keys) > 0 {
+		where = " at " + strings.Join(e.Keys, ".")
+	}
+	return fmt.Sprintf("%s cannot represent %s%s: %s", e.Format, e.Type, where, e.Reason)
+}
+
+// NormalizeForFormat converts a value about to be stored in a tree into a
+// form the target format's serializer handles, or returns an
+// *UnsupportedValueError. Containers are copied and normalized recursively.
+//
+// Conversion rules:
+//   - Maps (ordered or map[string]any, whose keys are sorted) become
+//     *orderedmap.OrderedMap; other slices become []any
+//   - Integers become int64 and floats float64; json.Number becomes int64 or
+//     float64 except for json, which keeps it
+//   - json: time.Time becomes an RFC 3339 string, []byte a base64 string;
+//     NaN and ±Inf are rejected
+//   - yaml: []byte becomes a base64 string; time.Time is kept
+//   - toml: nil and []byte are rejected
+//   - hcl and lua: time.Time becomes an RFC 3339 string; []byte, NaN, and
+//     ±Inf are rejected; named string types (hcl.Expression, lua.Expression)
+//     are kept
+//   - plist: nil, NaN, and ±Inf are rejected; time.Time and []byte are kept
+//   - dotenv and properties: scalars become strings (nil becomes "");
+//     arrays and []byte are rejected
+//   - ini, sshconfig, systemd, desktop: as dotenv, but a map of values is a
+//     whole section, and a value may also be an array of scalars (a key on
+//     several lines); for ini a map within a section is a subsection,
+//     normalized recursively
+//   - reg: strings, []byte, and named string types (reg.Data) are kept;
+//     integers become int64 and must fit a dword; a map of values is a whole
+//     registry key
+//   - kdl: scalars follow the tree rules (time.Time becomes an RFC 3339
+//     string; []byte, NaN, and ±Inf are rejected; named string types such
+//     as kdl.Entries are kept); maps are children, normalized recursively;
+//     an array of scalars or maps is a repeated node; nested arrays are
+//     rejected
+//   - nginx: scalars become strings (a directive's arguments); maps are
+//     blocks, normalized recursively; an array of strings or maps is a
+//     repeated directive; nested arrays are rejected
+//
+// Any other type, including channels, functions, and structs, is rejected.
+func NormalizeForFormat(value any, target string) (any, error) {
+	switch target {
+	case "json", "yaml", "toml", "plist", "hcl", "lua":
+		return normalizeTree(value, target, nil)
+	case "dotenv", "properties":
+		return normalizeString(value, target, nil)
+	case "ini":
+		return normalizeINI(value, nil)
+	case "sshconfig", "systemd", "desktop":
+		if om, ok := toOrderedMap(value); ok {
+			result := orderedmap.New()
+			for _, k := range om.Keys() {
+				v, _ := om.Get(k)
+				n, err := normalizeStrings(v, target, []string{k})
+				if err != nil {
+					return nil, err
+				}
+				result.Set(k, n)
+			}
+			return result, nil
+		}
+		return normalizeStrings(value, target, nil)
+	case "reg":
+		if om, ok := toOrderedMap(value); ok {
+			result := orderedmap.New()
+			for _, k := range om.Keys() {
+				v, _ := om.Get(k)
+				n, err := normalizeRegValue(v, []string{k})
+				if err != nil {
+					return nil, err
+				}
+				result.Set(k, n)
+			}
+			return result, nil
+		}
+		return normalizeRegValue(value, nil)
+	case "nginx":
+		return normalizeNginx(value, nil, false)
+	case "kdl":
+		return normalizeKDL(value, nil, false)
+	default:
+		return nil, fmt.Errorf("unknown format %q", target)
+	}
+}
+
+// normalizeTree applies the rules for tree-shaped formats.
+func normalizeTree(value any, target string, keys []string) (any, error) {
+	reject := func(reason string) error {
+		return &UnsupportedValueError{Format: target, Type: typeName(value), Keys: keys, Reason: reason}
+	}
+
+	if om, ok := toOrderedMap(value); ok {
+		result := orderedmap.New()
+		for _, k := range om.Keys() {
+			v, _ := om.Get(k)
+			n, err := normalizeTree(v, target, append(append([]string{}, keys...), k))
+			if err != nil {
+				return nil, err
+			}
+			result.Set(k, n)
+		}
+		return result, nil
+	}
+
+	switch v := value.(type) {
+	case nil:
+		if target == "toml" || target == "plist" {
+			return nil, reject("null values are not supported")
+		}
+		return nil, nil
+	case string, bool:
+		return v, nil
+	case json.Number:
+		if target == "json" {
+			return v, nil
+		}
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, reject(fmt.Sprintf("number %s is out of range", v))
+		}
+		return f, nil
+	case time.Time:
+		if target == "json" || target == "hcl" || target == "kdl" || target == "lua" {
+			return v.Format(time.RFC3339Nano), nil
+		}
+		return v, nil
+	case []byte:
+		switch target {
+		case "json", "yaml":
+			return base64.StdEncoding.EncodeToString(v), nil
+		case "plist":
+			return v, nil
+		default:
+			return nil, reject("binary data is not supported")
+		}
+	case []any:
+		result := make([]any, len(v))
+		for i, elem := range v {
+			n, err := normalizeTree(elem, target, append(append([]string{}, keys...), strconv.Itoa(i)))
+			if err != nil {
+				return nil, err
+			}
+			result[i] = n
+		}
+		return result, nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxInt64 {
+			return nil, reject("integer overflows int64")
+		}
+		return int64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if (math.IsNaN(f) || math.IsInf(f, 0)) && (target == "json" || target == "plist" || target == "hcl" || target == "kdl" || target == "lua") {
+			return nil, reject("NaN and infinity are not supported")
+		}
+		return f, nil
+	case reflect.String:
+		if target == "hcl" || target == "kdl" || target == "lua" {
+			return value, nil
+		}
+		return rv.String(), nil
+	case reflect.Slice, reflect.Array:
+		elems := make([]any, rv.Len())
+		for i := range elems {
+			elems[i] = rv.Index(i).Interface()
+		}
+		return normalizeTree(elems, target, keys)
+	}
+
+	return nil, reject("unsupported type")
+}
+
+// normalizeINI applies the ini rules: maps are sections and subsections,
+// normalized recursively, and other values become strings.
+func normalizeINI(value any, keys []string) (any, error) {
+	if om, ok := toOrderedMap(value); ok {
+		result := orderedmap.New()
+		for _, k := range om.Keys() {
+			v, _ := om.Get(k)
+			n, err := normalizeINI(v, append(append([]string{}, keys...), k))
+			if err != nil {
+				return nil, err
+			}
+			result.Set(k, n)
+		}
+		return result, nil
+	}
+	return normalizeStrings(value, "ini", keys)
+}
+
+// normalizeStrings converts a scalar to its string form, or an array of
+// scalars to a []any of strings, for ini, sshconfig, systemd, and desktop keys that repeat.
+func normalizeStrings(value any, target string, keys []string) (any, error) {
+	if arr, ok := value.([]any); ok {
+		result := make([]any, len(arr))
+		for i, elem := range arr {
+			s, err := normalizeString(elem, target, append(append([]string{}, keys...), strconv.Itoa(i)))
+			if err != nil {
+				return nil, err
+			}
+			result[i] = s
+		}
+		return result, nil
+	}
+	return normalizeString(value, target, keys)
+}
+
+// normalizeString converts a scalar to its string form for ini and dotenv.
+func normalizeString(value any, target string, keys []string) (string, error) {
+	reject := func(reason string) error {
+		return &UnsupportedValueError{Format: target, Type: typeName(value), Keys: keys, Reason: reason}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case json.Number:
+		return v.String(), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case []byte:
+		return "", reject("binary data is not supported")
+	}
+
+	if _, ok := toOrderedMap(value); ok {
+		return "", reject("nested maps are not supported")
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, 64), nil
+	case reflect.Slice, reflect.Array:
+		return "", reject("arrays are not supported")
+	}
+
+	return "", reject("unsupported type")
+}
+
+// normalizeKDL converts a node value for kdl: a scalar, children, or, unless
+// inArray, an array of either.
+func normalizeKDL(value any, keys []string, inArray bool) (any, error) {
+	if om, ok := toOrderedMap(value); ok {
+		result := orderedmap.New()
+		for _, k := range om.Keys() {
+			v, _ := om.Get(k)
+			n, err := normalizeKDL(v, append(append([]string{}, keys...), k), false)
+			if err != nil {
+				return nil, err
+			}
+			result.Set(k, n)
+		}
+		return result, nil
+	}
+
+	if arr, ok := value.([]any); ok {
+		if inArray {
+			return nil, &UnsupportedValueError{Format: "kdl", Type: typeName(value), Keys: keys, Reason: "nested arrays are not supported"}
+		}
+		result := make([]any, len(arr))
+		for i, elem := range arr {
+			n, err := normalizeKDL(elem, append(append([]string{}, keys...), strconv.Itoa(i)), true)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = n
+		}
+		return result, nil
+	}
+
+	return normalizeTree(value, "kdl", keys)
+}
+
+// normalizeNginx converts a directive value for nginx: a string of
+// arguments, a block, or, unless inArray, an array of either.
+func normalizeNginx(value any, keys []string, inArray bool) (any, error) {
+	if om, ok := toOrderedMap(value); ok {
+		result := orderedmap.New()
+		for _, k := range om.Keys() {
+			v, _ := om.Get(k)
+			n, err := normalizeNginx(v, append(append([]string{}, keys...), k), false)
+			if err != nil {
+				return nil, err
+			}
+			result.Set(k, n)
+		}
+		return result, nil
+	}
+
+	if arr, ok := value.([]any); ok {
+		if inArray {
+			return nil, &UnsupportedValueError{Format: "nginx", Type: typeName(value), Keys: keys, Reason: "nested arrays are not supported"}
+		}
+		result := make([]any, len(arr))
+		for i, elem := range arr {
+			n, err := normalizeNginx(elem, append(append([]string{}, keys...), strconv.Itoa(i)), true)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = n
+		}
+		return result, nil
+	}
+
+	return normalizeString(value, "nginx", keys)
+}
+
+// normalizeRegValue converts a registry value for reg: a string, a dword,
+// binary data, or a named string type holding data as written.
+func normalizeRegValue(value any, keys []string) (any, error) {
+	reject := func(reason string) error {
+		return &UnsupportedValueError{Format: "reg", Type: typeName(value), Keys: keys, Reason: reason}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return append([]byte{}, v...), nil
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil || i < 0 || i > math.MaxUint32 {
+			return nil, reject(fmt.Sprintf("number %s does not fit a dword", v))
+		}
+		return i, nil
+	}
+
+	if _, ok := toOrderedMap(value); ok {
+		return nil, reject("nested keys are not supported")
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if rv.Int() < 0 || rv.Int() > math.MaxUint32 {
+			return nil, reject("integer does not fit a dword")
+		}
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxUint32 {
+			return nil, reject("integer does not fit a dword")
+		}
+		return int64(rv.Uint()), nil
+	case reflect.String:
+		return value, nil
+	}
+
+	return nil, reject("unsupported type")
+}
+
+// toOrderedMap returns value as an ordered map, converting map[string]any
+// with sorted keys.
+func toOrderedMap(value any) (*orderedmap.OrderedMap, bool) {
+	if om := ToOrderedMapPtr(value); om != nil {
+		return om, true
+	}
+	m, ok := value.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	om := orderedmap.New()
+	for _, k := range keys {
+		om.Set(k, m[k])
+	}
+	return om, true
+}
+
+// typeName returns the Go type of v for error messages.
+func typeName(v any) string {
+	if v == nil {
+		return "null"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,347 @@
+package format
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+// namedString stands in for string types such as hcl.Expression.
+type namedString string
+
+func TestNormalizeForFormat(t *testing.T) {
+	when := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+	reject := "reject"
+
+	// Each source value maps target format to the expected result, or to
+	// reject when the format must refuse it
+	tests := []struct {
+		name  string
+		value any
+		want  map[string]any
+	}{
+		{
+			name:  "string",
+			value: "hello",
+			want:  map[string]any{"json": "hello", "yaml": "hello", "toml": "hello", "plist": "hello", "hcl": "hello", "ini": "hello", "dotenv": "hello"},
+		},
+		{
+			name:  "bool",
+			value: true,
+			want:  map[string]any{"json": true, "yaml": true, "toml": true, "plist": true, "hcl": true, "ini": "true", "dotenv": "true"},
+		},
+		{
+			name:  "int",
+			value: 42,
+			want:  map[string]any{"json": int64(42), "yaml": int64(42), "toml": int64(42), "plist": int64(42), "hcl": int64(42), "ini": "42", "dotenv": "42"},
+		},
+		{
+			name:  "uint8",
+			value: uint8(7),
+			want:  map[string]any{"json": int64(7), "yaml": int64(7), "toml": int64(7), "plist": int64(7), "hcl": int64(7), "ini": "7", "dotenv": "7"},
+		},
+		{
+			name:  "uint64 overflow",
+			value: uint64(math.MaxUint64),
+			want:  map[string]any{"json": reject, "yaml": reject, "toml": reject, "plist": reject, "hcl": reject, "ini": "18446744073709551615", "dotenv": "18446744073709551615"},
+		},
+		{
+			name:  "float32",
+			value: float32(1.5),
+			want:  map[string]any{"json": 1.5, "yaml": 1.5, "toml": 1.5, "plist": 1.5, "hcl": 1.5, "ini": "1.5", "dotenv": "1.5"},
+		},
+		{
+			name:  "NaN",
+			value: math.NaN(),
+			want:  map[string]any{"json": reject, "yaml": math.NaN(), "toml": math.NaN(), "plist": reject, "hcl": reject, "ini": "NaN", "dotenv": "NaN"},
+		},
+		{
+			name:  "infinity",
+			value: math.Inf(1),
+			want:  map[string]any{"json": reject, "yaml": math.Inf(1), "toml": math.Inf(1), "plist": reject, "hcl": reject, "ini": "+Inf", "dotenv": "+Inf"},
+		},
+		{
+			name:  "json.Number integer",
+			value: json.Number("12"),
+			want:  map[string]any{"json": json.Number("12"), "yaml": int64(12), "toml": int64(12), "plist": int64(12), "hcl": int64(12), "ini": "12", "dotenv": "12"},
+		},
+		{
+			name:  "json.Number float",
+			value: json.Number("0.25"),
+			want:  map[string]any{"json": json.Number("0.25"), "yaml": 0.25, "toml": 0.25, "plist": 0.25, "hcl": 0.25, "ini": "0.25", "dotenv": "0.25"},
+		},
+		{
+			name:  "time",
+			value: when,
+			want:  map[string]any{"json": "2026-03-01T12:30:00Z", "yaml": when, "toml": when, "plist": when, "hcl": "2026-03-01T12:30:00Z", "ini": "2026-03-01T12:30:00Z", "dotenv": "2026-03-01T12:30:00Z"},
+		},
+		{
+			name:  "bytes",
+			value: []byte("hi"),
+			want:  map[string]any{"json": "aGk=", "yaml": "aGk=", "toml": reject, "plist": []byte("hi"), "hcl": reject, "ini": reject, "dotenv": reject},
+		},
+		{
+			name:  "nil",
+			value: nil,
+			want:  map[string]any{"json": nil, "yaml": nil, "toml": reject, "plist": reject, "hcl": nil, "ini": "", "dotenv": ""},
+		},
+		{
+			name:  "typed slice",
+			value: []string{"a", "b"},
+			want:  map[string]any{"json": []any{"a", "b"}, "yaml": []any{"a", "b"}, "toml": []any{"a", "b"}, "plist": []any{"a", "b"}, "hcl": []any{"a", "b"}, "ini": reject, "dotenv": reject},
+		},
+		{
+			name:  "slice with null element",
+			value: []any{"a", nil},
+			want:  map[string]any{"json": []any{"a", nil}, "yaml": []any{"a", nil}, "toml": reject, "plist": reject, "hcl": []any{"a", nil}, "ini": []any{"a", ""}, "dotenv": reject},
+		},
+		{
+			name:  "named string",
+			value: namedString("x"),
+			want:  map[string]any{"json": "x", "yaml": "x", "toml": "x", "plist": "x", "hcl": namedString("x"), "ini": reject, "dotenv": reject},
+		},
+		{
+			name:  "struct",
+			value: struct{ A int }{1},
+			want:  map[string]any{"json": reject, "yaml": reject, "toml": reject, "plist": reject, "hcl": reject, "ini": reject, "dotenv": reject},
+		},
+		{
+			name:  "channel",
+			value: make(chan int),
+			want:  map[string]any{"json": reject, "yaml": reject, "toml": reject, "plist": reject, "hcl": reject, "ini": reject, "dotenv": reject},
+		},
+	}
+
+	for _, tt := range tests {
+		for target, want := range tt.want {
+			t.Run(tt.name+"/"+target, func(t *testing.T) {
+				got, err := NormalizeForFormat(tt.value, target)
+				if want == reject {
+					var uerr *UnsupportedValueError
+					if !errors.As(err, &uerr) {
+						t.Fatalf("NormalizeForFormat() = %#v, %v; want *UnsupportedValueError", got, err)
+					}
+					if uerr.Format != target {
+						t.Errorf("error Format = %q, want %q", uerr.Format, target)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("NormalizeForFormat() error = %v", err)
+				}
+				if f, isFloat := want.(float64); isFloat && math.IsNaN(f) {
+					if g, _ := got.(float64); !math.IsNaN(g) {
+						t.Errorf("NormalizeForFormat() = %#v, want NaN", got)
+					}
+					return
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("NormalizeForFormat() = %#v (%T), want %#v (%T)", got, got, want, want)
+				}
+			})
+		}
+	}
+}
+
+func TestNormalizeForFormat_Maps(t *testing.T) {
+	t.Run("map[string]any becomes sorted ordered map", func(t *testing.T) {
+		got, err := NormalizeForFormat(map[string]any{"b": 2, "a": []int{1}}, "toml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		om, ok := got.(*orderedmap.OrderedMap)
+		if !ok {
+			t.Fatalf("got %T, want *orderedmap.OrderedMap", got)
+		}
+		if keys := om.Keys(); !reflect.DeepEqual(keys, []string{"a", "b"}) {
+			t.Errorf("keys = %v, want [a b]", keys)
+		}
+		a, _ := om.Get("a")
+		b, _ := om.Get("b")
+		if !reflect.DeepEqual(a, []any{int64(1)}) || b != int64(2) {
+			t.Errorf("values = %#v, %#v; want [1], 2 as int64", a, b)
+		}
+	})
+
+	t.Run("ordered map is copied", func(t *testing.T) {
+		src := orderedmap.New()
+		src.Set("z", 1)
+		src.Set("y", 2)
+		got, err := NormalizeForFormat(src, "json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		om := got.(*orderedmap.OrderedMap)
+		if om == src {
+			t.Error("ordered map should be copied, not shared")
+		}
+		if keys := om.Keys(); !reflect.DeepEqual(keys, []string{"z", "y"}) {
+			t.Errorf("keys = %v, want insertion order [z y]", keys)
+		}
+	})
+
+	t.Run("ini section of scalars", func(t *testing.T) {
+		section := orderedmap.New()
+		section.Set("port", 8080)
+		section.Set("debug", false)
+		got, err := NormalizeForFormat(section, "ini")
+		if err != nil {
+			t.Fatal(err)
+		}
+		om := got.(*orderedmap.OrderedMap)
+		port, _ := om.Get("port")
+		debug, _ := om.Get("debug")
+		if port != "8080" || debug != "false" {
+			t.Errorf("section = %v, %v; want \"8080\", \"false\"", port, debug)
+		}
+	})
+
+	t.Run("dotenv rejects maps", func(t *testing.T) {
+		if _, err := NormalizeForFormat(orderedmap.New(), "dotenv"); err == nil {
+			t.Error("dotenv should reject a map value")
+		}
+	})
+
+	t.Run("properties converts scalars to strings", func(t *testing.T) {
+		got, err := NormalizeForFormat(int64(8080), "properties")
+		if err != nil || got != "8080" {
+			t.Errorf("NormalizeForFormat() = %#v, %v; want \"8080\"", got, err)
+		}
+		if _, err := NormalizeForFormat([]any{"a"}, "properties"); err == nil {
+			t.Error("properties should reject an array value")
+		}
+	})
+
+	t.Run("sshconfig converts scalars and arrays to strings", func(t *testing.T) {
+		got, err := NormalizeForFormat([]any{int64(22), true}, "sshconfig")
+		if err != nil || !reflect.DeepEqual(got, []any{"22", "true"}) {
+			t.Errorf("NormalizeForFormat() = %#v, %v; want [\"22\" \"true\"]", got, err)
+		}
+		if _, err := NormalizeForFormat([]any{[]any{"a"}}, "sshconfig"); err == nil {
+			t.Error("sshconfig should reject a nested array")
+		}
+	})
+
+	t.Run("systemd converts scalars and arrays to strings", func(t *testing.T) {
+		got, err := NormalizeForFormat([]any{"", int64(1)}, "systemd")
+		if err != nil || !reflect.DeepEqual(got, []any{"", "1"}) {
+			t.Errorf("NormalizeForFormat() = %#v, %v; want [\"\" \"1\"]", got, err)
+		}
+	})
+
+	t.Run("reg keeps typed values and checks dword range", func(t *testing.T) {
+		type data string
+		for _, v := range []any{"x", []byte{1}, data("hex(2):00,00")} {
+			if got, err := NormalizeForFormat(v, "reg"); err != nil || !reflect.DeepEqual(got, v) {
+				t.Errorf("NormalizeForFormat(%#v) = %#v, %v; want it unchanged", v, got, err)
+			}
+		}
+		if got, err := NormalizeForFormat(uint32(math.MaxUint32), "reg"); err != nil || got != int64(math.MaxUint32) {
+			t.Errorf("NormalizeForFormat(MaxUint32) = %#v, %v; want int64", got, err)
+		}
+		for _, v := range []any{-1, int64(math.MaxUint32) + 1, 1.5, true, nil, []any{"a"}} {
+			if _, err := NormalizeForFormat(v, "reg"); err == nil {
+				t.Errorf("reg should reject %#v", v)
+			}
+		}
+	})
+
+	t.Run("nginx converts scalars to strings inside blocks and lists", func(t *testing.T) {
+		block := orderedmap.New()
+		block.Set("listen", []any{int64(80), "443 ssl"})
+		block.Set("gzip", true)
+		got, err := NormalizeForFormat(map[string]any{"server": block}, "nginx")
+		if err != nil {
+			t.Fatalf("NormalizeForFormat() error = %v", err)
+		}
+		server, _ := got.(*orderedmap.OrderedMap).Get("server")
+		listen, _ := server.(*orderedmap.OrderedMap).Get("listen")
+		gzip, _ := server.(*orderedmap.OrderedMap).Get("gzip")
+		if !reflect.DeepEqual(listen, []any{"80", "443 ssl"}) || gzip != "true" {
+			t.Errorf("server = listen %#v, gzip %#v; want strings", listen, gzip)
+		}
+		for _, v := range []any{[]any{[]any{"a"}}, []byte{1}} {
+			if _, err := NormalizeForFormat(v, "nginx"); err == nil {
+				t.Errorf("nginx should reject %#v", v)
+			}
+		}
+	})
+
+	t.Run("kdl keeps scalar types and entries in nodes and lists", func(t *testing.T) {
+		type entries string
+		layout := orderedmap.New()
+		layout.Set("pane", []any{entries("size=1"), int32(2)})
+		layout.Set("when", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+		got, err := NormalizeForFormat(map[string]any{"layout": layout}, "kdl")
+		if err != nil {
+			t.Fatalf("NormalizeForFormat() error = %v", err)
+		}
+		l, _ := got.(*orderedmap.OrderedMap).Get("layout")
+		pane, _ := l.(*orderedmap.OrderedMap).Get("pane")
+		when, _ := l.(*orderedmap.OrderedMap).Get("when")
+		if !reflect.DeepEqual(pane, []any{entries("size=1"), int64(2)}) || when != "2024-01-02T03:04:05Z" {
+			t.Errorf("layout = pane %#v, when %#v", pane, when)
+		}
+		for _, v := range []any{[]any{[]any{"a"}}, []byte{1}, math.NaN()} {
+			if _, err := NormalizeForFormat(v, "kdl"); err == nil {
+				t.Errorf("kdl should reject %#v", v)
+			}
+		}
+	})
+
+	t.Run("lua keeps expressions and converts times", func(t *testing.T) {
+		type expression string
+		got, err := NormalizeForFormat(map[string]any{
+			"on_attach": expression("function() end"),
+			"when":      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			"list":      []int{1, 2},
+		}, "lua")
+		if err != nil {
+			t.Fatalf("NormalizeForFormat() error = %v", err)
+		}
+		om := got.(*orderedmap.OrderedMap)
+		onAttach, _ := om.Get("on_attach")
+		when, _ := om.Get("when")
+		list, _ := om.Get("list")
+		if onAttach != expression("function() end") || when != "2024-01-02T03:04:05Z" || !reflect.DeepEqual(list, []any{int64(1), int64(2)}) {
+			t.Errorf("NormalizeForFormat() = on_attach %#v, when %#v, list %#v", onAttach, when, list)
+		}
+		for _, v := range []any{[]byte{1}, math.Inf(1)} {
+			if _, err := NormalizeForFormat(v, "lua"); err == nil {
+				t.Errorf("lua should reject %#v", v)
+			}
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		if _, err := NormalizeForFormat("x", "xml"); err == nil {
+			t.Error("unknown format should be an error")
+		}
+	})
+}
+
+func TestNormalizeForFormat_ErrorNamesLocation(t *testing.T) {
+	inner := orderedmap.New()
+	inner.Set("blob", []byte{1})
+	outer := orderedmap.New()
+	outer.Set("items", []any{"ok", inner})
+
+	_, err := NormalizeForFormat(outer, "toml")
+	var uerr *UnsupportedValueError
+	if !errors.As(err, &uerr) {
+		t.Fatalf("error = %v, want *UnsupportedValueError", err)
+	}
+	if got := strings.Join(uerr.Keys, "."); got != "items.1.blob" {
+		t.Errorf("Keys = %q, want items.1.blob", got)
+	}
+	want := "toml cannot represent []uint8 at items.1.blob: binary data is not supported"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
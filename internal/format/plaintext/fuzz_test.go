@@ -0,0 +1,101 @@
+package plaintext
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thirteen37/chezmoi-split/internal/format"
+)
+
+// FuzzPlaintextRoundTrip checks that parse → serialize → parse is stable:
+// serializing the reparsed config gives the same bytes. Unless a block sorts
+// or dedupes, serializing also gives back the input, and the reparsed config
+// has the same structure. Input with escaped markers is skipped, since
+// unescaping a mention that isn't a marker line changes the output. Line breaks come back as
+// the ones most of the input uses, and input with a lone "\r" is skipped,
+// since one at the end of a line reads as CRLF once a line break follows.
+func FuzzPlaintextRoundTrip(f *testing.F) {
+	f.Add(`# chezmoi:managed
+export PATH="$HOME/bin:$PATH"
+export EDITOR="vim"
+
+# chezmoi:ignored
+# User's custom exports go here
+
+# chezmoi:end
+`)
+	f.Add(`preamble line
+// chezmoi:managed
+set number
+" chezmoi:ignored name=colors sort dedupe
+colorscheme desert
+<!-- chezmoi:end -->
+after the end
+`)
+	f.Add("no markers at all\nsecond line")
+	f.Add("# chezmoi:ignoredsort\nb\na\nhost=chezmoi:endpoint\n")
+	f.Add("# chezmoi:end\n# chezmoi:managed\nx\n# chezmoi:end\n")
+	f.Add("# chezmoi:managed\r\nx\r\n")
+	f.Add("# chezmoi:managed\r\nx\r\ny\n# chezmoi:end\r\n")
+	f.Add("# chezmoi:managed\n# see chezmoi:ignored blocks\nchezmoi:end\n")
+	f.Add("\n\n")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		if strings.Contains(input, "chezmoi:\\") {
+			t.Skip("escaped marker")
+		}
+		if strings.Contains(strings.ReplaceAll(input, "\r\n", ""), "\r") {
+			t.Skip("lone carriage return")
+		}
+		h := New()
+
+		first, err := h.Parse([]byte(input), format.ParseOptions{})
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		data, err := h.Serialize(first, format.SerializeOptions{})
+		if err != nil {
+			t.Fatalf("Serialize() error = %v", err)
+		}
+
+		// Without sort or dedupe, nothing is lost or moved
+		if !reordersLines(first.(*ParsedConfig)) {
+			ending := format.LineEnding([]byte(input))
+			want := string(format.WithLineEnding([]byte(input), ending))
+			if want != "" && !strings.HasSuffix(want, "\n") {
+				want += ending
+			}
+			if string(data) != want {
+				t.Errorf("Serialize() = %q, want the input %q", data, want)
+			}
+		}
+
+		second, err := h.Parse(data, format.ParseOptions{})
+		if err != nil {
+			t.Fatalf("re-Parse() error = %v", err)
+		}
+		if !reordersLines(first.(*ParsedConfig)) && !reflect.DeepEqual(first, second) {
+			t.Errorf("round trip changed the structure\ninput: %q\nfirst:  %+v\nsecond: %+v", input, first, second)
+		}
+
+		again, err := h.Serialize(second, format.SerializeOptions{})
+		if err != nil {
+			t.Fatalf("second Serialize() error = %v", err)
+		}
+		if string(again) != string(data) {
+			t.Errorf("Serialize() not stable: %q then %q", data, again)
+		}
+	})
+}
+
+// reordersLines reports whether any block sorts or dedupes its lines.
+func reordersLines(config *ParsedConfig) bool {
+	for _, block := range config.Blocks {
+		if block.Attrs.Sort || block.Attrs.Dedupe {
+			return true
+		}
+	}
+	return false
+}
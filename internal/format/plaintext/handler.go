@@ -3,7 +3,11 @@ package plaintext
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/thirteen37/chezmoi-split/internal/format"
 	"github.com/thirteen37/chezmoi-split/internal/path"
@@ -17,22 +21,57 @@ const (
 	BlockManaged BlockType = iota
 	// BlockIgnored indicates content preserved from current config (app/user-managed).
 	BlockIgnored
-	// BlockEnd is used internally when generating end markers.
+	// BlockEnd is a chezmoi:end marker that more markers follow, holding the
+	// unmarked lines after it. Like a managed block, it is kept as written.
 	BlockEnd BlockType = -1
 )
 
+// BlockAttrs holds the attributes parsed once from a block's marker line.
+// Attributes follow the marker as space-separated words, e.g.
+// "# chezmoi:ignored name=aliases sort dedupe"; unknown words (such as a
+// closing "-->") are ignored.
+type BlockAttrs struct {
+	Prefix string // Comment syntax before "chezmoi:" (e.g. "#", "//", "\""), empty if none
+	Name   string // Block name from name=<value>
+	Sort   bool   // Sort the block's lines on output
+	Dedupe bool   // Drop repeated lines on output, keeping the first
+}
+
+// Sources of a merged block's lines, recorded by MergeBlocks.
+const (
+	SourceTemplate = "template" // The managed template
+	SourceCurrent  = "current"  // The current file on disk
+)
+
 // Block represents a section of the config file.
 type Block struct {
 	Type       BlockType
 	Lines      []string
-	MarkerLine string // The original marker line (preserved for output)
+	MarkerLine string     // The original marker line (preserved for output)
+	Attrs      BlockAttrs // Attributes parsed from MarkerLine
+	Line       int        // Input line of the first of Lines, from 1; 0 if unknown
+	Source     string     // Input Lines came from (SourceTemplate, SourceCurrent); empty if not merged
 }
 
 // ParsedConfig holds the structured representation of a plaintext config.
 type ParsedConfig struct {
-	Blocks        []Block
-	EndMarkerLine string   // The original end marker line (preserved for output)
-	TrailingLines []string // Lines after the last chezmoi:end marker
+	Blocks         []Block
+	EndMarkerLine  string   // The original end marker line (preserved for output)
+	TrailingLines  []string // Lines after the last chezmoi:end marker
+	TrailingLine   int      // Input line of the first of TrailingLines, from 1; 0 if unknown
+	TrailingSource string   // Input TrailingLines came from; empty if not merged
+	LineEnding     string   // Line break Serialize writes ("\n" or "\r\n"); empty means "\n"
+}
+
+// Span maps a range of output lines to the input lines they came from.
+// The source range covers the block as read; a sort or dedupe block writes
+// its lines in another order or fewer of them.
+type Span struct {
+	Start, End             int    // Output lines, from 1, inclusive
+	Source                 string // SourceTemplate or SourceCurrent; empty if not merged
+	SourceStart, SourceEnd int    // Input lines in Source, inclusive
+	Block                  int    // Index into ParsedConfig.Blocks; -1 for the trailing lines
+	Ignored                int    // Position of the block among the ignored ones, from 1; 0 if not ignored
 }
 
 // Handler implements format.Handler for plaintext files.
@@ -44,20 +83,48 @@ func New() *Handler {
 }
 
 // Parse reads plaintext bytes and returns a *ParsedConfig.
-// It scans for chezmoi:managed, chezmoi:ignored, and chezmoi:end markers anywhere in lines.
+// It scans for chezmoi:managed, chezmoi:ignored, and chezmoi:end markers in
+// comment lines (see parseMarker).
 //
-// NOTE: Marker detection is substring-based. If your config contains the literal
-// string "chezmoi:managed" as data (e.g., in a comment about chezmoi-split),
-// it will be incorrectly treated as a marker. There is no escaping mechanism.
+// A line that has to mention a marker as data can escape it with a
+// backslash, "chezmoi:\managed"; the line is content and is stored without
+// the backslash (see unescape). Serialize escapes it again (see escape).
 func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
-	lines := strings.Split(string(data), "\n")
 	config := &ParsedConfig{}
+	if len(data) == 0 {
+		return config, nil
+	}
+	// Lines are stored without their line breaks, which are written back
+	// as the ones most of the input uses
+	config.LineEnding = format.LineEnding(data)
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	// A final newline ends the last line rather than starting an empty one
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
 
 	var currentBlock *Block
+	var endAttrs BlockAttrs
 	afterEnd := false
 
-	for _, line := range lines {
-		markerType := detectMarker(line)
+	for i, line := range lines {
+		// Content after a marker on this line starts on the next one
+		next := i + 2
+
+		markerType, attrs := parseMarker(line)
+
+		// An end marker that another marker follows isn't the last one, so
+		// it and the lines after it become a block of their own
+		if markerType != "" && afterEnd {
+			config.Blocks = append(config.Blocks, Block{
+				Type:       BlockEnd,
+				Lines:      config.TrailingLines,
+				MarkerLine: config.EndMarkerLine,
+				Attrs:      endAttrs,
+				Line:       config.TrailingLine,
+			})
+			config.EndMarkerLine = ""
+			config.TrailingLines = nil
+			config.TrailingLine = 0
+		}
 
 		switch markerType {
 		case "managed":
@@ -67,6 +134,8 @@ func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
 			currentBlock = &Block{
 				Type:       BlockManaged,
 				MarkerLine: line,
+				Attrs:      attrs,
+				Line:       next,
 			}
 			afterEnd = false
 
@@ -77,6 +146,8 @@ func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
 			currentBlock = &Block{
 				Type:       BlockIgnored,
 				MarkerLine: line,
+				Attrs:      attrs,
+				Line:       next,
 			}
 			afterEnd = false
 
@@ -86,10 +157,14 @@ func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
 				currentBlock = nil
 			}
 			config.EndMarkerLine = line // Store the original end marker line
+			// End markers take no attributes
+			endAttrs = BlockAttrs{Prefix: attrs.Prefix}
+			config.TrailingLine = next
 			afterEnd = true
 
 		default:
 			// Regular content line
+			line = unescape(line)
 			if afterEnd {
 				config.TrailingLines = append(config.TrailingLines, line)
 			} else if currentBlock != nil {
@@ -98,6 +173,7 @@ func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
 				// Content before any marker - treat as implicit ignored block
 				currentBlock = &Block{
 					Type: BlockIgnored,
+					Line: i + 1,
 				}
 				currentBlock.Lines = append(currentBlock.Lines, line)
 			}
@@ -112,22 +188,184 @@ func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
 	return config, nil
 }
 
-// detectMarker checks if a line contains a chezmoi marker and returns its type.
-// Returns "managed", "ignored", "end", or "" for no marker.
-func detectMarker(line string) string {
-	if strings.Contains(line, "chezmoi:managed") {
-		return "managed"
+// markerTypes lists the marker keywords in detection order.
+var markerTypes = []string{"managed", "ignored", "end"}
+
+// parseMarker checks if a line contains a chezmoi marker and returns its type
+// ("managed", "ignored", "end", or "" for no marker) and its attributes.
+// The marker must follow comment syntax at the start of the line: the text
+// before it has to be non-empty and free of letters and digits, so "#",
+// "//", "<!--", and "# ---" qualify, but a line that only mentions a marker
+// ("# see chezmoi:managed") does not.
+func parseMarker(line string) (string, BlockAttrs) {
+	for _, kind := range markerTypes {
+		token := "chezmoi:" + kind
+		idx := markerIndex(line, token)
+		if idx < 0 {
+			continue
+		}
+		prefix := strings.TrimSpace(line[:idx])
+		if !isCommentPrefix(prefix) {
+			continue
+		}
+		attrs := BlockAttrs{Prefix: prefix}
+		for _, word := range strings.Fields(line[idx+len(token):]) {
+			switch {
+			case word == "sort":
+				attrs.Sort = true
+			case word == "dedupe":
+				attrs.Dedupe = true
+			case strings.HasPrefix(word, "name="):
+				attrs.Name = strings.TrimPrefix(word, "name=")
+			}
+		}
+		return kind, attrs
+	}
+	return "", BlockAttrs{}
+}
+
+// markerIndex returns the index of the first occurrence of token in line
+// that ends at a word boundary, or -1. This keeps "chezmoi:endpoint" from
+// reading as an end marker.
+func markerIndex(line, token string) int {
+	offset := 0
+	for {
+		idx := strings.Index(line[offset:], token)
+		if idx < 0 {
+			return -1
+		}
+		end := offset + idx + len(token)
+		if end == len(line) || !isWordByte(line[end]) {
+			return offset + idx
+		}
+		offset = end
+	}
+}
+
+// isCommentPrefix reports whether prefix, the text before a marker, is
+// comment syntax: non-empty, with no letters or digits.
+func isCommentPrefix(prefix string) bool {
+	if prefix == "" {
+		return false
 	}
-	if strings.Contains(line, "chezmoi:ignored") {
-		return "ignored"
+	return strings.IndexFunc(prefix, func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	}) < 0
+}
+
+// escapedMarker matches an escaped marker keyword, "chezmoi:\managed". Each
+// further backslash stands for a literal one.
+var escapedMarker = regexp.MustCompile(`chezmoi:\\(\\*)(managed|ignored|end)\b`)
+
+// unescape returns a content line with one backslash removed from each
+// escaped marker, so "chezmoi:\managed" reads "chezmoi:managed" and
+// "chezmoi:\\managed" reads "chezmoi:\managed".
+func unescape(line string) string {
+	if !strings.Contains(line, "chezmoi:\\") {
+		return line
 	}
-	if strings.Contains(line, "chezmoi:end") {
-		return "end"
+	return escapedMarker.ReplaceAllString(line, "chezmoi:$1$2")
+}
+
+// markerKeyword matches a marker keyword and any backslashes escaping it.
+var markerKeyword = regexp.MustCompile(`chezmoi:(\\*)(managed|ignored|end)\b`)
+
+// escape reverses unescape for a content line that would otherwise be read
+// back as a marker, or that holds an escaped marker, by adding a backslash
+// to each marker keyword. Other lines are written as they are, so a mention
+// such as "see chezmoi:managed" stays unescaped.
+func escape(line string) string {
+	if kind, _ := parseMarker(line); kind == "" && !escapedMarker.MatchString(line) {
+		return line
 	}
-	return ""
+	return markerKeyword.ReplaceAllString(line, `chezmoi:\$1$2`)
+}
+
+// isWordByte reports whether c can continue a marker keyword.
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+// CommentPresets maps the names accepted by the comment-prefix directive to
+// their comment syntax.
+var CommentPresets = map[string]string{
+	"shell": "#",
+	"vim":   "\"",
+	"lua":   "--",
+	"sql":   "--",
+	"c":     "//",
+	"ini":   ";",
+	"tex":   "%",
+}
+
+// ResolveCommentPrefix returns the comment syntax for a comment-prefix
+// value: a preset name, a Go-quoted string ("\"//\""), or the prefix itself.
+// The prefix must be one that marker lines can use: non-empty, without
+// letters or digits.
+func ResolveCommentPrefix(value string) (string, error) {
+	if prefix, ok := CommentPresets[value]; ok {
+		return prefix, nil
+	}
+	prefix := value
+	if strings.HasPrefix(value, "\"") {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted comment prefix %s", value)
+		}
+		prefix = unquoted
+	}
+	if strings.ContainsAny(prefix, " \t") || !isCommentPrefix(prefix) {
+		return "", fmt.Errorf("comment prefix %q must be a preset (%s) or comment syntax without letters, digits, or spaces", value, strings.Join(presetNames(), ", "))
+	}
+	return prefix, nil
+}
+
+// presetNames returns the CommentPresets names in order.
+func presetNames() []string {
+	names := make([]string, 0, len(CommentPresets))
+	for name := range CommentPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CommentPrefix returns the comment syntax used by the first marker line in
+// config, defaulting to "#" when there are no markers.
+func CommentPrefix(config *ParsedConfig) string {
+	for _, block := range config.Blocks {
+		if block.MarkerLine != "" && block.Attrs.Prefix != "" {
+			return block.Attrs.Prefix
+		}
+	}
+	return "#"
+}
+
+// renderLines returns the block's lines with its Dedupe and Sort attributes applied.
+func (b Block) renderLines() []string {
+	lines := b.Lines
+	if b.Attrs.Dedupe {
+		seen := make(map[string]bool, len(lines))
+		deduped := make([]string, 0, len(lines))
+		for _, line := range lines {
+			if !seen[line] {
+				seen[line] = true
+				deduped = append(deduped, line)
+			}
+		}
+		lines = deduped
+	}
+	if b.Attrs.Sort {
+		lines = append([]string{}, lines...)
+		sort.Strings(lines)
+	}
+	return lines
 }
 
 // Serialize writes the ParsedConfig back to bytes.
+// It honors OmitMarkers, TrimTrailingWhitespace, and OmitFinalNewline from opts.
+// Lines end with config.LineEnding. Unless markers are omitted, content lines
+// that read as markers are escaped, so the next Parse keeps them as content.
 func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, error) {
 	config, ok := tree.(*ParsedConfig)
 	if !ok {
@@ -137,33 +375,93 @@ func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, err
 	var lines []string
 	for _, block := range config.Blocks {
 		// Add marker line if block has one
-		if block.MarkerLine != "" {
+		if block.MarkerLine != "" && !opts.OmitMarkers {
 			lines = append(lines, block.MarkerLine)
 		}
 		// Add content lines
-		lines = append(lines, block.Lines...)
+		lines = append(lines, escapeLines(block.renderLines(), opts)...)
 	}
 
 	// Add end marker if it was present in the template
-	if config.EndMarkerLine != "" {
+	if config.EndMarkerLine != "" && !opts.OmitMarkers {
 		lines = append(lines, config.EndMarkerLine)
 	}
 
 	// Add trailing lines
-	lines = append(lines, config.TrailingLines...)
+	lines = append(lines, escapeLines(config.TrailingLines, opts)...)
 
-	// Remove empty trailing element caused by splitting input that ended with \n
-	if len(lines) > 0 && lines[len(lines)-1] == "" {
-		lines = lines[:len(lines)-1]
+	if opts.TrimTrailingWhitespace {
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(line, " \t")
+		}
 	}
 
-	result := strings.Join(lines, "\n")
-	if result != "" {
-		result += "\n"
+	ending := config.LineEnding
+	if ending == "" {
+		ending = "\n"
+	}
+	result := strings.Join(lines, ending)
+	if len(lines) > 0 && !opts.OmitFinalNewline {
+		result += ending
 	}
 	return []byte(result), nil
 }
 
+// escapeLines returns lines with escape applied, or lines itself when
+// opts omits markers and nothing will read the output as markers.
+func escapeLines(lines []string, opts format.SerializeOptions) []string {
+	if opts.OmitMarkers {
+		return lines
+	}
+	escaped := make([]string, len(lines))
+	for i, line := range lines {
+		escaped[i] = escape(line)
+	}
+	return escaped
+}
+
+// Spans returns where each run of content lines that Serialize writes with
+// opts came from: one span per non-empty block, and one for the trailing
+// lines. Marker lines, written from the template, have no span but move
+// the lines after them.
+func (config *ParsedConfig) Spans(opts format.SerializeOptions) []Span {
+	var spans []Span
+	line := 1
+	ignored := 0
+	for i, block := range config.Blocks {
+		if block.MarkerLine != "" && !opts.OmitMarkers {
+			line++
+		}
+		span := Span{Source: block.Source, SourceStart: block.Line, Block: i}
+		if block.Type == BlockIgnored {
+			ignored++
+			span.Ignored = ignored
+		}
+		n := len(block.renderLines())
+		if n > 0 {
+			span.Start, span.End = line, line+n-1
+			span.SourceEnd = block.Line + len(block.Lines) - 1
+			spans = append(spans, span)
+		}
+		line += n
+	}
+
+	if config.EndMarkerLine != "" && !opts.OmitMarkers {
+		line++
+	}
+	if n := len(config.TrailingLines); n > 0 {
+		spans = append(spans, Span{
+			Start:       line,
+			End:         line + n - 1,
+			Source:      config.TrailingSource,
+			SourceStart: config.TrailingLine,
+			SourceEnd:   config.TrailingLine + n - 1,
+			Block:       -1,
+		})
+	}
+	return spans
+}
+
 // GetPath is not supported for plaintext configs.
 // Plaintext uses block-based merging instead of path-based access.
 func (h *Handler) GetPath(tree any, p path.Path) (any, bool) {
@@ -180,38 +478,54 @@ func (h *Handler) SetPath(tree any, p path.Path, value any) error {
 //   - Managed blocks: content from managed (template)
 //   - Ignored blocks: content from current config (if available), otherwise from managed
 //
-// Ignored blocks are matched by index (1st ignored in managed ↔ 1st ignored in current).
+// Each result block records in Source and Line where its lines came from,
+// for Spans.
+//
+// Ignored blocks with a name= attribute are matched by name, so blocks can be
+// added or reordered in the template without moving user content. The
+// remaining ignored blocks are matched by index (1st ignored in managed ↔
+// 1st unmatched ignored in current). Lines end the way current's do, or
+// managed's without a current config.
 func (h *Handler) MergeBlocks(managed, current *ParsedConfig) *ParsedConfig {
 	if managed == nil {
 		return current
 	}
 
 	result := &ParsedConfig{
-		EndMarkerLine: managed.EndMarkerLine, // Preserve from template
+		EndMarkerLine:  managed.EndMarkerLine, // Preserve from template
+		TrailingLines:  managed.TrailingLines, // Like lines after an inner end marker
+		TrailingLine:   managed.TrailingLine,
+		TrailingSource: SourceTemplate,
+		LineEnding:     managed.LineEnding,
+	}
+	// The file on disk decides how lines end, so the app can keep its own
+	if current != nil {
+		result.LineEnding = current.LineEnding
 	}
 
-	// Extract ignored blocks from current config for index-based matching
-	currentIgnoredBlocks := extractIgnoredBlocks(current)
+	named, unnamed := matchIgnoredBlocks(managed, extractIgnoredBlocks(current))
 
 	ignoredIndex := 0
 	for _, block := range managed.Blocks {
 		resultBlock := Block{
 			Type:       block.Type,
 			MarkerLine: block.MarkerLine,
+			Attrs:      block.Attrs,
 		}
 
-		if block.Type == BlockManaged {
-			// Managed blocks always use template content
-			resultBlock.Lines = block.Lines
-		} else {
+		source, from := SourceTemplate, block
+		match, isNamed := named[block.Attrs.Name]
+		switch {
+		case block.Type != BlockIgnored:
+			// Managed blocks, and end markers within the file, always use template content
+		case isNamed:
+			source, from = SourceCurrent, match
+		case ignoredIndex < len(unnamed):
 			// Ignored blocks: use current content if available, otherwise template defaults
-			if ignoredIndex < len(currentIgnoredBlocks) {
-				resultBlock.Lines = currentIgnoredBlocks[ignoredIndex].Lines
-				ignoredIndex++
-			} else {
-				resultBlock.Lines = block.Lines
-			}
+			source, from = SourceCurrent, unnamed[ignoredIndex]
+			ignoredIndex++
 		}
+		resultBlock.Lines, resultBlock.Line, resultBlock.Source = from.Lines, from.Line, source
 
 		result.Blocks = append(result.Blocks, resultBlock)
 	}
@@ -219,6 +533,31 @@ func (h *Handler) MergeBlocks(managed, current *ParsedConfig) *ParsedConfig {
 	return result
 }
 
+// matchIgnoredBlocks splits the ignored blocks from current into those
+// matched by name to an ignored block in managed, keyed by name, and the
+// rest in order for index matching. If a name repeats, its first block is
+// the match.
+func matchIgnoredBlocks(managed *ParsedConfig, currentIgnored []Block) (map[string]Block, []Block) {
+	wanted := make(map[string]bool)
+	for _, block := range managed.Blocks {
+		if block.Type == BlockIgnored && block.Attrs.Name != "" {
+			wanted[block.Attrs.Name] = true
+		}
+	}
+
+	named := make(map[string]Block)
+	var unnamed []Block
+	for _, block := range currentIgnored {
+		name := block.Attrs.Name
+		if _, seen := named[name]; wanted[name] && !seen {
+			named[name] = block
+			continue
+		}
+		unnamed = append(unnamed, block)
+	}
+	return named, unnamed
+}
+
 // extractIgnoredBlocks returns the ignored blocks from current config.
 // If current has no markers (all implicit), all content is combined into one block.
 func extractIgnoredBlocks(current *ParsedConfig) []Block {
@@ -232,7 +571,7 @@ func extractIgnoredBlocks(current *ParsedConfig) []Block {
 		for _, block := range current.Blocks {
 			allLines = append(allLines, block.Lines...)
 		}
-		return []Block{{Type: BlockIgnored, Lines: allLines}}
+		return []Block{{Type: BlockIgnored, Lines: allLines, Line: current.Blocks[0].Line}}
 	}
 
 	// Otherwise, collect only the explicitly ignored blocks
@@ -255,5 +594,11 @@ func allBlocksImplicit(config *ParsedConfig) bool {
 	return true
 }
 
+// DeletePath is not supported for plaintext configs.
+// Plaintext uses block-based merging instead of path-based access.
+func (h *Handler) DeletePath(tree any, p path.Path) error {
+	return fmt.Errorf("DeletePath is not supported for plaintext format; use block-based merging")
+}
+
 // Ensure Handler implements format.Handler.
 var _ format.Handler = (*Handler)(nil)
@@ -1,10 +1,12 @@
 package plaintext
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/formattest"
 	"github.com/thirteen37/chezmoi-split/internal/path"
 )
 
@@ -88,18 +90,157 @@ func TestHandler_Parse_MarkerAnywhereInLine(t *testing.T) {
 		{"decorated", "# --- chezmoi:managed ---", "managed"},
 		{"with padding", "   # chezmoi:ignored   ", "ignored"},
 		{"end marker", "# chezmoi:end", "end"},
+		{"html comment", "<!-- chezmoi:ignored -->", "ignored"},
+		{"no comment prefix", "chezmoi:managed", ""},
+		{"mention in a comment", "# Blocks start at chezmoi:managed lines", ""},
+		{"mention in content", "url=chezmoi:endpoint # chezmoi:end", ""},
+		{"escaped", "# chezmoi:\\managed", ""},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := detectMarker(tt.line)
+			got, _ := parseMarker(tt.line)
 			if got != tt.wantType {
-				t.Errorf("detectMarker(%q) = %q, want %q", tt.line, got, tt.wantType)
+				t.Errorf("parseMarker(%q) = %q, want %q", tt.line, got, tt.wantType)
 			}
 		})
 	}
 }
 
+func TestParseMarker_Attrs(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want BlockAttrs
+	}{
+		{"no attributes", "# chezmoi:ignored", BlockAttrs{Prefix: "#"}},
+		{"name", "# chezmoi:ignored name=aliases", BlockAttrs{Prefix: "#", Name: "aliases"}},
+		{"flags", "// chezmoi:managed sort dedupe", BlockAttrs{Prefix: "//", Sort: true, Dedupe: true}},
+		{"html comment suffix", "<!-- chezmoi:ignored name=nav -->", BlockAttrs{Prefix: "<!--", Name: "nav"}},
+		{"not a marker", "set number sort", BlockAttrs{}},
+		{"longer word", "# chezmoi:ignoredsort", BlockAttrs{}},
+		{"decorated", "# --- chezmoi:ignored name=keys ---", BlockAttrs{Prefix: "# ---", Name: "keys"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got := parseMarker(tt.line)
+			if got != tt.want {
+				t.Errorf("parseMarker(%q) attrs = %+v, want %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_Serialize_OptionsMatrix(t *testing.T) {
+	h := New()
+
+	input := "# chezmoi:managed\nset number  \n\n# chezmoi:ignored\ncolorscheme desert\n# chezmoi:end\ntrailing\n"
+
+	tests := []struct {
+		name string
+		opts format.SerializeOptions
+		want string
+	}{
+		{
+			name: "defaults round-trip exactly",
+			want: input,
+		},
+		{
+			name: "omit markers",
+			opts: format.SerializeOptions{OmitMarkers: true},
+			want: "set number  \n\ncolorscheme desert\ntrailing\n",
+		},
+		{
+			name: "omit final newline",
+			opts: format.SerializeOptions{OmitFinalNewline: true},
+			want: strings.TrimSuffix(input, "\n"),
+		},
+		{
+			name: "trim trailing whitespace",
+			opts: format.SerializeOptions{TrimTrailingWhitespace: true},
+			want: strings.Replace(input, "set number  ", "set number", 1),
+		},
+		{
+			name: "all options",
+			opts: format.SerializeOptions{OmitMarkers: true, OmitFinalNewline: true, TrimTrailingWhitespace: true},
+			want: "set number\n\ncolorscheme desert\ntrailing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := h.Parse([]byte(input), format.ParseOptions{})
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got, err := h.Serialize(tree, tt.opts)
+			if err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Serialize() = %q, want %q", got, tt.want)
+			}
+
+			// Output with markers must parse back to the same blocks
+			if tt.opts.OmitMarkers {
+				return
+			}
+			reparsed, err := h.Parse(got, format.ParseOptions{})
+			if err != nil {
+				t.Fatalf("re-Parse() error = %v", err)
+			}
+			if n := len(reparsed.(*ParsedConfig).Blocks); n != 2 {
+				t.Errorf("re-Parse() got %d blocks, want 2", n)
+			}
+		})
+	}
+}
+
+func TestHandler_Serialize_BlockAttrs(t *testing.T) {
+	h := New()
+
+	managed := `# chezmoi:managed
+export EDITOR=vim
+# chezmoi:ignored name=aliases sort dedupe
+alias a=b
+# chezmoi:end
+`
+	current := `# chezmoi:managed
+export EDITOR=nano
+# chezmoi:ignored
+alias zz=top
+alias gs=git status
+alias zz=top
+alias ll=ls -l
+# chezmoi:end
+`
+	m, _ := h.Parse([]byte(managed), format.ParseOptions{})
+	c, _ := h.Parse([]byte(current), format.ParseOptions{})
+	result := h.MergeBlocks(m.(*ParsedConfig), c.(*ParsedConfig))
+
+	// Attributes come from the template's marker
+	if got := result.Blocks[1].Attrs; got.Name != "aliases" || !got.Sort || !got.Dedupe {
+		t.Errorf("merged block attrs = %+v, want name=aliases sort dedupe", got)
+	}
+
+	got, err := h.Serialize(result, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := `# chezmoi:managed
+export EDITOR=vim
+# chezmoi:ignored name=aliases sort dedupe
+alias gs=git status
+alias ll=ls -l
+alias zz=top
+# chezmoi:end
+`
+	if string(got) != want {
+		t.Errorf("Serialize() =\n%s\nwant\n%s", got, want)
+	}
+}
+
 func TestHandler_Serialize(t *testing.T) {
 	h := New()
 
@@ -193,6 +334,63 @@ func TestHandler_MergeBlocks_Basic(t *testing.T) {
 	}
 }
 
+func TestHandler_Parse_CRLF(t *testing.T) {
+	h := New()
+	input := "# chezmoi:managed\r\nset number\r\n# chezmoi:ignored name=colors sort\r\ncolorscheme desert\r\n# chezmoi:end\r\n"
+
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	config := tree.(*ParsedConfig)
+	if len(config.Blocks) != 2 || config.Blocks[0].Type != BlockManaged || config.Blocks[1].Type != BlockIgnored {
+		t.Fatalf("Blocks = %+v, want a managed and an ignored block", config.Blocks)
+	}
+	// Nothing keeps a trailing \r: markers, attributes, or lines
+	ignored := config.Blocks[1]
+	if ignored.MarkerLine != "# chezmoi:ignored name=colors sort" || ignored.Attrs.Name != "colors" || !ignored.Attrs.Sort {
+		t.Errorf("ignored block = %+v, want marker and attributes without \\r", ignored)
+	}
+	if ignored.Lines[0] != "colorscheme desert" || config.EndMarkerLine != "# chezmoi:end" {
+		t.Errorf("Lines = %q, EndMarkerLine = %q, want them without \\r", ignored.Lines, config.EndMarkerLine)
+	}
+
+	data, err := h.Serialize(config, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if string(data) != input {
+		t.Errorf("Serialize() = %q, want the CRLF input %q", data, input)
+	}
+}
+
+func TestHandler_MergeBlocks_LineEnding(t *testing.T) {
+	h := New()
+	managedAny, _ := h.Parse([]byte("# chezmoi:managed\nset number\n# chezmoi:ignored\n# chezmoi:end\n"), format.ParseOptions{})
+	managed := managedAny.(*ParsedConfig)
+	currentAny, _ := h.Parse([]byte("# chezmoi:ignored\r\nset mouse=a\r\n# chezmoi:end\r\n"), format.ParseOptions{})
+	current := currentAny.(*ParsedConfig)
+
+	if current.LineEnding != "\r\n" || current.Blocks[0].Lines[0] != "set mouse=a" {
+		t.Fatalf("Parse() = %+v, want CRLF line ending and lines without \\r", current)
+	}
+
+	// The current file's line endings win
+	data, err := h.Serialize(h.MergeBlocks(managed, current), format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if want := "# chezmoi:managed\r\nset number\r\n# chezmoi:ignored\r\nset mouse=a\r\n# chezmoi:end\r\n"; string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", data, want)
+	}
+
+	// Without a current file, the template's
+	data, _ = h.Serialize(h.MergeBlocks(managed, nil), format.SerializeOptions{})
+	if want := "# chezmoi:managed\nset number\n# chezmoi:ignored\n# chezmoi:end\n"; string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", data, want)
+	}
+}
+
 func TestHandler_MergeBlocks_CurrentNoMarkers(t *testing.T) {
 	h := New()
 
@@ -297,6 +495,168 @@ func TestHandler_MergeBlocks_MultipleIgnored(t *testing.T) {
 	}
 }
 
+func TestHandler_MergeBlocks_NamedBlocks(t *testing.T) {
+	h := New()
+
+	// The template added a "paths" block before "colors" and reordered the
+	// named blocks; user content follows the names, not the positions.
+	managed := `# chezmoi:managed
+m1
+# chezmoi:ignored name=paths
+default-paths
+# chezmoi:ignored name=aliases
+default-aliases
+# chezmoi:ignored name=colors
+default-colors
+# chezmoi:ignored
+default-extra
+# chezmoi:end
+`
+	current := `# chezmoi:managed
+old-m1
+# chezmoi:ignored name=colors
+user-colors
+# chezmoi:ignored
+user-extra
+# chezmoi:ignored name=aliases
+user-aliases
+# chezmoi:end
+`
+	m, _ := h.Parse([]byte(managed), format.ParseOptions{})
+	c, _ := h.Parse([]byte(current), format.ParseOptions{})
+	result := h.MergeBlocks(m.(*ParsedConfig), c.(*ParsedConfig))
+
+	got, err := h.Serialize(result, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	// "paths" has no match by name, so it takes the first unmatched block by
+	// index; the unnamed block then falls back to its template default
+	want := `# chezmoi:managed
+m1
+# chezmoi:ignored name=paths
+user-extra
+# chezmoi:ignored name=aliases
+user-aliases
+# chezmoi:ignored name=colors
+user-colors
+# chezmoi:ignored
+default-extra
+# chezmoi:end
+`
+	if string(got) != want {
+		t.Errorf("Serialize() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHandler_MergeBlocks_NamedBlocksNewManagedBlock(t *testing.T) {
+	h := New()
+
+	// A managed block added between the ignored ones, which the user has
+	// also swapped, doesn't shift their content
+	managed := `# chezmoi:ignored name=colors
+default-colors
+# chezmoi:managed
+new-managed
+# chezmoi:ignored name=keys
+default-keys
+`
+	current := `# chezmoi:ignored name=keys
+user-keys
+# chezmoi:ignored name=colors
+user-colors
+`
+	m, _ := h.Parse([]byte(managed), format.ParseOptions{})
+	c, _ := h.Parse([]byte(current), format.ParseOptions{})
+	got, err := h.Serialize(h.MergeBlocks(m.(*ParsedConfig), c.(*ParsedConfig)), format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	want := `# chezmoi:ignored name=colors
+user-colors
+# chezmoi:managed
+new-managed
+# chezmoi:ignored name=keys
+user-keys
+`
+	if string(got) != want {
+		t.Errorf("Serialize() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHandler_MergeBlocks_NamedFallsBackToIndex(t *testing.T) {
+	h := New()
+
+	// Names added to the template before the target has them still pick up
+	// the existing content by position
+	managed := &ParsedConfig{
+		Blocks: []Block{
+			{Type: BlockIgnored, MarkerLine: "# chezmoi:ignored name=a", Attrs: BlockAttrs{Name: "a"}, Lines: []string{"default-a"}},
+			{Type: BlockIgnored, MarkerLine: "# chezmoi:ignored name=b", Attrs: BlockAttrs{Name: "b"}, Lines: []string{"default-b"}},
+		},
+	}
+	current := &ParsedConfig{
+		Blocks: []Block{
+			{Type: BlockIgnored, MarkerLine: "# chezmoi:ignored", Lines: []string{"user1"}},
+			{Type: BlockIgnored, MarkerLine: "# chezmoi:ignored name=b", Attrs: BlockAttrs{Name: "b"}, Lines: []string{"user-b"}},
+		},
+	}
+
+	result := h.MergeBlocks(managed, current)
+	if result.Blocks[0].Lines[0] != "user1" {
+		t.Errorf("Block 0 should be 'user1', got %v", result.Blocks[0].Lines)
+	}
+	if result.Blocks[1].Lines[0] != "user-b" {
+		t.Errorf("Block 1 should be 'user-b', got %v", result.Blocks[1].Lines)
+	}
+}
+
+func TestHandler_EndMarkerBeforeMoreBlocks(t *testing.T) {
+	h := New()
+
+	managed := `# chezmoi:managed
+set number
+# chezmoi:end
+unmarked template line
+# chezmoi:ignored
+default
+# chezmoi:end
+`
+	current := `# chezmoi:managed
+set nonumber
+# chezmoi:end
+old unmarked line
+# chezmoi:ignored
+user
+# chezmoi:end
+`
+	m, _ := h.Parse([]byte(managed), format.ParseOptions{})
+	if blocks := m.(*ParsedConfig).Blocks; len(blocks) != 3 || blocks[1].Type != BlockEnd {
+		t.Fatalf("Parse() blocks = %+v, want managed, end, ignored", blocks)
+	}
+
+	// The earlier end marker and the lines after it come from the template
+	c, _ := h.Parse([]byte(current), format.ParseOptions{})
+	result := h.MergeBlocks(m.(*ParsedConfig), c.(*ParsedConfig))
+	got, err := h.Serialize(result, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := `# chezmoi:managed
+set number
+# chezmoi:end
+unmarked template line
+# chezmoi:ignored
+user
+# chezmoi:end
+`
+	if string(got) != want {
+		t.Errorf("Serialize() =\n%s\nwant\n%s", got, want)
+	}
+}
+
 func TestHandler_GetPath_NotSupported(t *testing.T) {
 	h := New()
 
@@ -321,6 +681,167 @@ func TestHandler_SetPath_NotSupported(t *testing.T) {
 	}
 }
 
+func TestResolveCommentPrefix(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"vim", "\"", false},
+		{"lua", "--", false},
+		{"c", "//", false},
+		{"//", "//", false},
+		{";;", ";;", false},
+		{`"\""`, "\"", false},
+		{`"//"`, "//", false},
+		{"rem", "", true},
+		{`"# "`, "", true},
+		{`"unterminated`, "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ResolveCommentPrefix(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ResolveCommentPrefix(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ResolveCommentPrefix(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"set number", "set number"},
+		{"# chezmoi:\\managed", "# chezmoi:managed"},
+		{"see chezmoi:\\ignored and chezmoi:\\end", "see chezmoi:ignored and chezmoi:end"},
+		{"# chezmoi:\\\\managed", "# chezmoi:\\managed"},
+		{"C:\\chezmoi:\\endpoint", "C:\\chezmoi:\\endpoint"},
+	}
+
+	for _, tt := range tests {
+		if got := unescape(tt.line); got != tt.want {
+			t.Errorf("unescape(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+// TestHandler_MarkerMentions checks that lines which only mention a marker,
+// or escape one, stay content of the block they are in.
+func TestHandler_MarkerMentions(t *testing.T) {
+	h := New()
+
+	template := `# chezmoi:managed
+# Lines between chezmoi:managed and chezmoi:ignored markers come from here
+# chezmoi:\managed
+# chezmoi:ignored
+user line
+# chezmoi:end
+`
+	current := `# chezmoi:managed
+old
+# chezmoi:ignored
+# The app writes below chezmoi:ignored
+kept
+# chezmoi:end
+`
+
+	managedTree, err := h.Parse([]byte(template), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(template) error = %v", err)
+	}
+	managed := managedTree.(*ParsedConfig)
+	if len(managed.Blocks) != 2 {
+		t.Fatalf("Parse(template) got %d blocks, want 2", len(managed.Blocks))
+	}
+	wantLines := []string{
+		"# Lines between chezmoi:managed and chezmoi:ignored markers come from here",
+		"# chezmoi:managed",
+	}
+	if got := managed.Blocks[0].Lines; strings.Join(got, "\n") != strings.Join(wantLines, "\n") {
+		t.Errorf("managed lines = %q, want %q", got, wantLines)
+	}
+
+	currentTree, err := h.Parse([]byte(current), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(current) error = %v", err)
+	}
+	output, err := h.Serialize(h.MergeBlocks(managed, currentTree.(*ParsedConfig)), format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	// The escaped marker stays escaped, so the output parses the same way
+	want := `# chezmoi:managed
+# Lines between chezmoi:managed and chezmoi:ignored markers come from here
+# chezmoi:\managed
+# chezmoi:ignored
+# The app writes below chezmoi:ignored
+kept
+# chezmoi:end
+`
+	if string(output) != want {
+		t.Errorf("merged output =\n%s\nwant:\n%s", output, want)
+	}
+}
+
+// TestHandler_EscapedMarkerTwoRuns checks that an escaped marker written to
+// the target is still content when the target is merged again, so the
+// ignored block after it keeps the user's lines.
+func TestHandler_EscapedMarkerTwoRuns(t *testing.T) {
+	h := New()
+	template := "# chezmoi:managed\n# chezmoi:\\ignored\n# chezmoi:ignored\ndefault\n# chezmoi:end\n"
+	merge := func(current string) string {
+		t.Helper()
+		managed, err := h.Parse([]byte(template), format.ParseOptions{})
+		if err != nil {
+			t.Fatalf("Parse(template) error = %v", err)
+		}
+		parsed, err := h.Parse([]byte(current), format.ParseOptions{})
+		if err != nil {
+			t.Fatalf("Parse(current) error = %v", err)
+		}
+		out, err := h.Serialize(h.MergeBlocks(managed.(*ParsedConfig), parsed.(*ParsedConfig)), format.SerializeOptions{})
+		if err != nil {
+			t.Fatalf("Serialize() error = %v", err)
+		}
+		return string(out)
+	}
+
+	first := merge("# chezmoi:managed\n# chezmoi:ignored\nuser line\n# chezmoi:end\n")
+	want := "# chezmoi:managed\n# chezmoi:\\ignored\n# chezmoi:ignored\nuser line\n# chezmoi:end\n"
+	if first != want {
+		t.Fatalf("first run = %q, want %q", first, want)
+	}
+	if second := merge(first); second != want {
+		t.Errorf("second run = %q, want %q", second, want)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"# chezmoi:managed", "# chezmoi:\\managed"},
+		{"# chezmoi:\\managed", "# chezmoi:\\\\managed"},
+		{"see chezmoi:ignored and chezmoi:end", "see chezmoi:ignored and chezmoi:end"},
+		{"host=chezmoi:endpoint", "host=chezmoi:endpoint"},
+	}
+	for _, tt := range tests {
+		if got := escape(tt.line); got != tt.want {
+			t.Errorf("escape(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+		if got := unescape(escape(tt.line)); got != tt.line {
+			t.Errorf("unescape(escape(%q)) = %q", tt.line, got)
+		}
+	}
+}
+
 func TestHandler_RoundTrip(t *testing.T) {
 	h := New()
 
@@ -587,6 +1108,48 @@ more content
 	}
 }
 
+// TestHandler_MergeBlocks_IndentedMarkers checks that indented marker lines,
+// the end marker included, come from the template as written, whatever
+// indentation current's markers have.
+func TestHandler_MergeBlocks_IndentedMarkers(t *testing.T) {
+	h := New()
+
+	managed := `server {
+    # chezmoi:managed
+    listen 80
+    # chezmoi:ignored
+    root /srv
+    # chezmoi:end
+}
+`
+	current := `server {
+# chezmoi:managed
+listen 8080
+# chezmoi:ignored
+    root /var/www
+# chezmoi:end
+}
+`
+	m, _ := h.Parse([]byte(managed), format.ParseOptions{})
+	c, _ := h.Parse([]byte(current), format.ParseOptions{})
+	result := h.MergeBlocks(m.(*ParsedConfig), c.(*ParsedConfig))
+	got, err := h.Serialize(result, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := `server {
+    # chezmoi:managed
+    listen 80
+    # chezmoi:ignored
+    root /var/www
+    # chezmoi:end
+}
+`
+	if string(got) != want {
+		t.Errorf("Serialize() =\n%s\nwant\n%s", got, want)
+	}
+}
+
 func TestHandler_NoEndMarker_NotGenerated(t *testing.T) {
 	h := New()
 
@@ -613,3 +1176,97 @@ ignored content
 			string(output))
 	}
 }
+
+func TestParsedConfig_Spans(t *testing.T) {
+	tests := []struct {
+		name    string
+		managed string
+		current string
+		opts    format.SerializeOptions
+		want    []Span
+	}{
+		{
+			name:    "extra current block and trailing lines",
+			managed: "# chezmoi:managed\nset number\n# chezmoi:ignored\ncolorscheme default\n# chezmoi:end\n\" trailing\n",
+			current: "# chezmoi:managed\nset nonumber\n# chezmoi:ignored\ncolorscheme gruvbox\nset wrap\n# chezmoi:ignored\nextra\n# chezmoi:end\n",
+			want: []Span{
+				{Start: 2, End: 2, Source: SourceTemplate, SourceStart: 2, SourceEnd: 2, Block: 0},
+				{Start: 4, End: 5, Source: SourceCurrent, SourceStart: 4, SourceEnd: 5, Block: 1, Ignored: 1},
+				{Start: 7, End: 7, Source: SourceTemplate, SourceStart: 6, SourceEnd: 6, Block: -1},
+			},
+		},
+		{
+			name:    "template default for a block current lacks",
+			managed: "# chezmoi:managed\nset number\nset ruler\n# chezmoi:ignored name=colors\ncolorscheme default\n# chezmoi:ignored name=plugins\nplug default\n# chezmoi:end\n",
+			current: "# chezmoi:managed\nold\n# chezmoi:ignored name=colors\ncolorscheme gruvbox\n# chezmoi:end\n",
+			want: []Span{
+				{Start: 2, End: 3, Source: SourceTemplate, SourceStart: 2, SourceEnd: 3, Block: 0},
+				{Start: 5, End: 5, Source: SourceCurrent, SourceStart: 4, SourceEnd: 4, Block: 1, Ignored: 1},
+				{Start: 7, End: 7, Source: SourceTemplate, SourceStart: 7, SourceEnd: 7, Block: 2, Ignored: 2},
+			},
+		},
+		{
+			name:    "without markers",
+			managed: "# chezmoi:managed\nset number\nset ruler\n# chezmoi:ignored name=colors\ncolorscheme default\n# chezmoi:ignored name=plugins\nplug default\n# chezmoi:end\n",
+			current: "# chezmoi:managed\nold\n# chezmoi:ignored name=colors\ncolorscheme gruvbox\n# chezmoi:end\n",
+			opts:    format.SerializeOptions{OmitMarkers: true},
+			want: []Span{
+				{Start: 1, End: 2, Source: SourceTemplate, SourceStart: 2, SourceEnd: 3, Block: 0},
+				{Start: 3, End: 3, Source: SourceCurrent, SourceStart: 4, SourceEnd: 4, Block: 1, Ignored: 1},
+				{Start: 4, End: 4, Source: SourceTemplate, SourceStart: 7, SourceEnd: 7, Block: 2, Ignored: 2},
+			},
+		},
+		{
+			name:    "current without markers",
+			managed: "# chezmoi:managed\nset number\n# chezmoi:ignored\n",
+			current: "set wrap\nset list\n",
+			want: []Span{
+				{Start: 2, End: 2, Source: SourceTemplate, SourceStart: 2, SourceEnd: 2, Block: 0},
+				{Start: 4, End: 5, Source: SourceCurrent, SourceStart: 1, SourceEnd: 2, Block: 1, Ignored: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			managed, _ := h.Parse([]byte(tt.managed), format.ParseOptions{})
+			current, _ := h.Parse([]byte(tt.current), format.ParseOptions{})
+			result := h.MergeBlocks(managed.(*ParsedConfig), current.(*ParsedConfig))
+
+			spans := result.Spans(tt.opts)
+			if !reflect.DeepEqual(spans, tt.want) {
+				t.Errorf("Spans() =\n%+v\nwant:\n%+v", spans, tt.want)
+			}
+
+			// Each span covers exactly its block's lines in the output
+			data, err := h.Serialize(result, tt.opts)
+			if err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+			lines := strings.Split(string(data), "\n")
+			for _, s := range spans {
+				want := result.TrailingLines
+				if s.Block >= 0 {
+					want = result.Blocks[s.Block].Lines
+				}
+				if got := lines[s.Start-1 : s.End]; !reflect.DeepEqual(got, want) {
+					t.Errorf("output lines %d-%d = %q, want %q", s.Start, s.End, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_Conformance(t *testing.T) {
+	formattest.RunConformance(t, New(), formattest.Fixtures{
+		Document: `# chezmoi:managed
+set number
+# chezmoi:ignored
+colorscheme gruvbox
+# chezmoi:end
+`,
+		RoundTripExact: true,
+		Unsupported:    true,
+	})
+}
@@ -0,0 +1,449 @@
+// Package plist provides a handler for XML property lists for chezmoi-split.
+package plist
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// header is the XML declaration and DOCTYPE Apple tools write and expect.
+const header = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+`
+
+// dateLayout is the plist <date> format (ISO 8601, always UTC).
+const dateLayout = "2006-01-02T15:04:05Z"
+
+// Handler implements format.Handler for XML plist files.
+//
+// Values map to Go types as follows: <dict> → *orderedmap.OrderedMap,
+// <array> → []any, <string> → string, <integer> → int64, <real> → float64,
+// <true/>/<false/> → bool, <data> → []byte, <date> → time.Time.
+type Handler struct{}
+
+// New creates a new plist handler.
+func New() *Handler {
+	return &Handler{}
+}
+
+// Parse reads an XML plist and returns an *orderedmap.OrderedMap.
+// The root value must be a <dict>. Binary plists are rejected.
+func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
+	if opts.StripComments {
+		return nil, fmt.Errorf("strip-comments is not supported for plist format")
+	}
+	if bytes.HasPrefix(data, []byte("bplist")) {
+		return nil, fmt.Errorf("binary plists are not supported; convert with 'plutil -convert xml1'")
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+
+	start, ok, err := nextStart(dec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse plist: %w", err)
+	}
+	if !ok || start.Name.Local != "plist" {
+		return nil, fmt.Errorf("failed to parse plist: expected <plist> element")
+	}
+
+	start, ok, err = nextStart(dec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse plist: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("failed to parse plist: empty <plist> element")
+	}
+
+	root, err := decodeValue(dec, start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse plist: %w", err)
+	}
+
+	om := format.ToOrderedMapPtr(root)
+	if om == nil {
+		return nil, fmt.Errorf("plist root must be a <dict>, got <%s>", start.Name.Local)
+	}
+	return om, nil
+}
+
+// nextStart advances to the next start element, skipping text, comments, and
+// directives. It returns false when an end element is reached first.
+func nextStart(dec *xml.Decoder) (xml.StartElement, bool, error) {
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return xml.StartElement{}, false, fmt.Errorf("unexpected end of document")
+		}
+		if err != nil {
+			return xml.StartElement{}, false, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			return t, true, nil
+		case xml.EndElement:
+			return xml.StartElement{}, false, nil
+		}
+	}
+}
+
+// readText returns the character data of the current element and consumes
+// its end tag.
+func readText(dec *xml.Decoder) (string, error) {
+	var sb strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			sb.Write(t)
+		case xml.EndElement:
+			return sb.String(), nil
+		case xml.StartElement:
+			return "", fmt.Errorf("unexpected <%s> inside text element", t.Name.Local)
+		}
+	}
+}
+
+// decodeValue decodes the element opened by start into its Go value.
+func decodeValue(dec *xml.Decoder, start xml.StartElement) (any, error) {
+	switch start.Name.Local {
+	case "dict":
+		om := orderedmap.New()
+		for {
+			keyStart, ok, err := nextStart(dec)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return om, nil
+			}
+			if keyStart.Name.Local != "key" {
+				return nil, fmt.Errorf("expected <key> in <dict>, got <%s>", keyStart.Name.Local)
+			}
+			key, err := readText(dec)
+			if err != nil {
+				return nil, err
+			}
+
+			valStart, ok, err := nextStart(dec)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, fmt.Errorf("missing value for key %q", key)
+			}
+			val, err := decodeValue(dec, valStart)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", key, err)
+			}
+			om.Set(key, val)
+		}
+
+	case "array":
+		arr := []any{}
+		for {
+			elemStart, ok, err := nextStart(dec)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return arr, nil
+			}
+			val, err := decodeValue(dec, elemStart)
+			if err != nil {
+				return nil, fmt.Errorf("index %d: %w", len(arr), err)
+			}
+			arr = append(arr, val)
+		}
+
+	case "true", "false":
+		if err := dec.Skip(); err != nil {
+			return nil, err
+		}
+		return start.Name.Local == "true", nil
+	}
+
+	text, err := readText(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	switch start.Name.Local {
+	case "string":
+		return text, nil
+	case "integer":
+		n, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid <integer> %q", text)
+		}
+		return n, nil
+	case "real":
+		f, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid <real> %q", text)
+		}
+		return f, nil
+	case "data":
+		b, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid <data>: %w", err)
+		}
+		return b, nil
+	case "date":
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("invalid <date> %q", text)
+		}
+		return t.UTC(), nil
+	default:
+		return nil, fmt.Errorf("unsupported element <%s>", start.Name.Local)
+	}
+}
+
+// Serialize writes the tree as an XML plist with the standard DOCTYPE.
+// Nested values are indented with tabs (or opts.Indent), as Apple tools do.
+func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, error) {
+	indent := opts.Indent
+	if indent == "" {
+		indent = "\t"
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(header)
+	buf.WriteString("<plist version=\"1.0\">\n")
+	if err := writeValue(&buf, tree, 0, indent); err != nil {
+		return nil, fmt.Errorf("failed to serialize plist: %w", err)
+	}
+	buf.WriteString("</plist>\n")
+	return buf.Bytes(), nil
+}
+
+// textEscaper escapes character data the way Apple's serializer does,
+// leaving quotes and newlines alone.
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// writeValue writes v as a plist element at the given depth.
+func writeValue(buf *bytes.Buffer, v any, depth int, indent string) error {
+	pad := strings.Repeat(indent, depth)
+
+	if om := format.ToOrderedMapPtr(v); om != nil {
+		if len(om.Keys()) == 0 {
+			buf.WriteString(pad + "<dict/>\n")
+			return nil
+		}
+		buf.WriteString(pad + "<dict>\n")
+		for _, key := range om.Keys() {
+			val, _ := om.Get(key)
+			buf.WriteString(pad + indent + "<key>" + textEscaper.Replace(key) + "</key>\n")
+			if err := writeValue(buf, val, depth+1, indent); err != nil {
+				return fmt.Errorf("key %q: %w", key, err)
+			}
+		}
+		buf.WriteString(pad + "</dict>\n")
+		return nil
+	}
+
+	switch val := v.(type) {
+	case []any:
+		if len(val) == 0 {
+			buf.WriteString(pad + "<array/>\n")
+			return nil
+		}
+		buf.WriteString(pad + "<array>\n")
+		for i, elem := range val {
+			if err := writeValue(buf, elem, depth+1, indent); err != nil {
+				return fmt.Errorf("index %d: %w", i, err)
+			}
+		}
+		buf.WriteString(pad + "</array>\n")
+	case string:
+		buf.WriteString(pad + "<string>" + textEscaper.Replace(val) + "</string>\n")
+	case bool:
+		if val {
+			buf.WriteString(pad + "<true/>\n")
+		} else {
+			buf.WriteString(pad + "<false/>\n")
+		}
+	case int:
+		buf.WriteString(pad + "<integer>" + strconv.Itoa(val) + "</integer>\n")
+	case int64:
+		buf.WriteString(pad + "<integer>" + strconv.FormatInt(val, 10) + "</integer>\n")
+	case float64:
+		if math.IsInf(val, 0) || math.IsNaN(val) {
+			return fmt.Errorf("cannot represent %v as <real>", val)
+		}
+		buf.WriteString(pad + "<real>" + strconv.FormatFloat(val, 'g', -1, 64) + "</real>\n")
+	case []byte:
+		buf.WriteString(pad + "<data>" + base64.StdEncoding.EncodeToString(val) + "</data>\n")
+	case time.Time:
+		buf.WriteString(pad + "<date>" + val.UTC().Format(dateLayout) + "</date>\n")
+	case nil:
+		return fmt.Errorf("plist cannot represent a null value")
+	default:
+		return fmt.Errorf("unsupported value type %T", v)
+	}
+	return nil
+}
+
+// GetPath extracts a value at the given path. Dict entries are addressed by
+// key and array elements by numeric index; "*" matches any dict key or array
+// element and returns the first match.
+func (h *Handler) GetPath(tree any, p path.Path) (any, bool) {
+	return getPath(tree, p.Segments(), 0)
+}
+
+// getPath recursively navigates dicts and arrays.
+func getPath(current any, segments []string, idx int) (any, bool) {
+	if idx >= len(segments) {
+		return current, true
+	}
+
+	segment := segments[idx]
+
+	if om := format.ToOrderedMapPtr(current); om != nil {
+		if segment == path.Wildcard {
+			for _, key := range om.Keys() {
+				val, _ := om.Get(key)
+				if result, ok := getPath(val, segments, idx+1); ok {
+					return result, true
+				}
+			}
+			return nil, false
+		}
+		val, exists := om.Get(segment)
+		if !exists {
+			return nil, false
+		}
+		return getPath(val, segments, idx+1)
+	}
+
+	if arr, ok := current.([]any); ok {
+		if segment == path.Wildcard {
+			for _, elem := range arr {
+				if result, ok := getPath(elem, segments, idx+1); ok {
+					return result, true
+				}
+			}
+			return nil, false
+		}
+		i, ok := format.ArrayIndex(segment, len(arr))
+		if !ok {
+			return nil, false
+		}
+		return getPath(arr[i], segments, idx+1)
+	}
+
+	return nil, false
+}
+
+// SetPath sets a value at the given path. "*" applies to every dict key or
+// array element. Missing dict keys along the path are created as dicts;
+// array indexes must already exist.
+func (h *Handler) SetPath(tree any, p path.Path, value any) error {
+	segments := p.Segments()
+	if len(segments) == 0 {
+		return fmt.Errorf("empty path")
+	}
+
+	value, err := format.NormalizeForFormat(value, "plist")
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %w", p, err)
+	}
+
+	return setPath(tree, segments, 0, value)
+}
+
+// setPath recursively sets values in dicts and arrays.
+func setPath(current any, segments []string, idx int, value any) error {
+	segment := segments[idx]
+	isLast := idx == len(segments)-1
+
+	if om := format.ToOrderedMapPtr(current); om != nil {
+		if segment == path.Wildcard {
+			for _, key := range om.Keys() {
+				if isLast {
+					om.Set(key, value)
+					continue
+				}
+				val, _ := om.Get(key)
+				// Continue to other keys even if one fails
+				_ = setPath(val, segments, idx+1, value)
+			}
+			return nil
+		}
+
+		if isLast {
+			om.Set(segment, value)
+			return nil
+		}
+
+		next, exists := om.Get(segment)
+		if !exists {
+			next = orderedmap.New()
+			om.Set(segment, next)
+		}
+		if format.ToOrderedMapPtr(next) == nil {
+			if _, ok := next.([]any); !ok {
+				return fmt.Errorf("path segment %q is not a dict or array", segment)
+			}
+		}
+		return setPath(next, segments, idx+1, value)
+	}
+
+	if arr, ok := current.([]any); ok {
+		if segment == path.Wildcard {
+			for i := range arr {
+				if isLast {
+					arr[i] = value
+					continue
+				}
+				_ = setPath(arr[i], segments, idx+1, value)
+			}
+			return nil
+		}
+
+		i, ok := format.ArrayIndex(segment, len(arr))
+		if !ok {
+			return fmt.Errorf("array index %q out of range (length %d)", segment, len(arr))
+		}
+		if isLast {
+			arr[i] = value
+			return nil
+		}
+		if format.ToOrderedMapPtr(arr[i]) == nil {
+			if _, ok := arr[i].([]any); !ok {
+				return fmt.Errorf("array element %d is not a dict or array", i)
+			}
+		}
+		return setPath(arr[i], segments, idx+1, value)
+	}
+
+	return fmt.Errorf("cannot navigate into non-container value")
+}
+
+// DeletePath removes the value at the given path. "*" deletes every dict
+// key or array element it matches, and an array element is removed from its
+// array.
+func (h *Handler) DeletePath(tree any, p path.Path) error {
+	segments := p.Segments()
+	if len(segments) == 0 {
+		return fmt.Errorf("empty path")
+	}
+	return format.DeleteMatches(tree, segments)
+}
+
+// Ensure Handler implements format.Handler.
+var _ format.Handler = (*Handler)(nil)
@@ -0,0 +1,253 @@
+package plist
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/formattest"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+const samplePlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>AppleShowAllFiles</key>
+	<true/>
+	<key>FXPreferredViewStyle</key>
+	<string>Nlsv</string>
+	<key>RecentCount</key>
+	<integer>10</integer>
+	<key>Scale</key>
+	<real>1.5</real>
+	<key>Token</key>
+	<data>aGVsbG8=</data>
+	<key>LastOpened</key>
+	<date>2024-03-01T12:30:00Z</date>
+	<key>Windows</key>
+	<array>
+		<dict>
+			<key>Width</key>
+			<integer>800</integer>
+		</dict>
+		<dict>
+			<key>Width</key>
+			<integer>1024</integer>
+		</dict>
+	</array>
+	<key>Empty</key>
+	<dict/>
+</dict>
+</plist>
+`
+
+func TestHandler_Parse(t *testing.T) {
+	h := New()
+
+	tree, err := h.Parse([]byte(samplePlist), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	om := tree.(*orderedmap.OrderedMap)
+
+	tests := []struct {
+		key  string
+		want any
+	}{
+		{"AppleShowAllFiles", true},
+		{"FXPreferredViewStyle", "Nlsv"},
+		{"RecentCount", int64(10)},
+		{"Scale", 1.5},
+	}
+	for _, tt := range tests {
+		got, _ := om.Get(tt.key)
+		if got != tt.want {
+			t.Errorf("%s = %#v, want %#v", tt.key, got, tt.want)
+		}
+	}
+
+	token, _ := om.Get("Token")
+	if b, ok := token.([]byte); !ok || !bytes.Equal(b, []byte("hello")) {
+		t.Errorf("Token = %#v, want []byte(\"hello\")", token)
+	}
+
+	lastOpened, _ := om.Get("LastOpened")
+	want := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	if d, ok := lastOpened.(time.Time); !ok || !d.Equal(want) {
+		t.Errorf("LastOpened = %#v, want %v", lastOpened, want)
+	}
+
+	windows, _ := om.Get("Windows")
+	if arr, ok := windows.([]any); !ok || len(arr) != 2 {
+		t.Errorf("Windows = %#v, want 2-element array", windows)
+	}
+
+	if !reflect.DeepEqual(om.Keys(), []string{"AppleShowAllFiles", "FXPreferredViewStyle", "RecentCount", "Scale", "Token", "LastOpened", "Windows", "Empty"}) {
+		t.Errorf("keys = %v, want document order", om.Keys())
+	}
+}
+
+func TestHandler_Parse_Errors(t *testing.T) {
+	h := New()
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{
+			name:    "binary plist",
+			input:   "bplist00\x00\x01",
+			wantErr: "binary plists are not supported",
+		},
+		{
+			name:    "not a plist",
+			input:   `<?xml version="1.0"?><config/>`,
+			wantErr: "expected <plist> element",
+		},
+		{
+			name:    "array root",
+			input:   `<plist version="1.0"><array/></plist>`,
+			wantErr: "plist root must be a <dict>",
+		},
+		{
+			name:    "bad integer",
+			input:   `<plist version="1.0"><dict><key>n</key><integer>ten</integer></dict></plist>`,
+			wantErr: "invalid <integer>",
+		},
+		{
+			name:    "value without key",
+			input:   `<plist version="1.0"><dict><string>x</string></dict></plist>`,
+			wantErr: "expected <key>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := h.Parse([]byte(tt.input), format.ParseOptions{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Parse() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandler_Parse_StripCommentsError(t *testing.T) {
+	h := New()
+	_, err := h.Parse([]byte(samplePlist), format.ParseOptions{StripComments: true})
+	if err == nil {
+		t.Error("Parse() with StripComments should return error for plist")
+	}
+}
+
+func TestHandler_Serialize_RoundTrip(t *testing.T) {
+	h := New()
+
+	tree, err := h.Parse([]byte(samplePlist), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if string(data) != samplePlist {
+		t.Errorf("Serialize() =\n%s\nwant\n%s", data, samplePlist)
+	}
+}
+
+func TestHandler_Serialize_EscapesText(t *testing.T) {
+	h := New()
+
+	tree := orderedmap.New()
+	tree.Set("a<b", "x & y > z")
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if !strings.Contains(string(data), "<key>a&lt;b</key>") ||
+		!strings.Contains(string(data), "<string>x &amp; y &gt; z</string>") {
+		t.Errorf("Serialize() did not escape text:\n%s", data)
+	}
+
+	reparsed, err := h.Parse(data, format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("re-Parse() error = %v", err)
+	}
+	got, _ := reparsed.(*orderedmap.OrderedMap).Get("a<b")
+	if got != "x & y > z" {
+		t.Errorf("round-tripped value = %q", got)
+	}
+}
+
+func TestHandler_Serialize_RejectsNull(t *testing.T) {
+	h := New()
+
+	tree := orderedmap.New()
+	tree.Set("key", nil)
+
+	if _, err := h.Serialize(tree, format.SerializeOptions{}); err == nil {
+		t.Error("Serialize() should reject null values")
+	}
+}
+
+func TestHandler_ArrayPaths(t *testing.T) {
+	h := New()
+
+	tree, err := h.Parse([]byte(samplePlist), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	got, found := h.GetPath(tree, path.NewArrayPath([]string{"Windows", "1", "Width"}))
+	if !found || got != int64(1024) {
+		t.Errorf("GetPath(Windows.1.Width) = %v, %v; want 1024, true", got, found)
+	}
+	if _, found := h.GetPath(tree, path.NewArrayPath([]string{"Windows", "2", "Width"})); found {
+		t.Error("GetPath() should not find an out-of-range index")
+	}
+	if _, found := h.GetPath(tree, path.NewArrayPath([]string{"Windows", "first"})); found {
+		t.Error("GetPath() should not find a non-numeric index")
+	}
+
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"Windows", "0", "Width"}), int64(640)); err != nil {
+		t.Fatalf("SetPath() error = %v", err)
+	}
+	got, _ = h.GetPath(tree, path.NewArrayPath([]string{"Windows", "0", "Width"}))
+	if got != int64(640) {
+		t.Errorf("after SetPath, Windows.0.Width = %v, want 640", got)
+	}
+
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"Windows", "5", "Width"}), int64(1)); err == nil {
+		t.Error("SetPath() should fail for an out-of-range index")
+	}
+
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"Windows", "*", "Width"}), int64(0)); err != nil {
+		t.Fatalf("SetPath() with wildcard error = %v", err)
+	}
+	for _, i := range []string{"0", "1"} {
+		got, _ := h.GetPath(tree, path.NewArrayPath([]string{"Windows", i, "Width"}))
+		if got != int64(0) {
+			t.Errorf("Windows.%s.Width = %v, want 0", i, got)
+		}
+	}
+}
+
+func TestHandler_Conformance(t *testing.T) {
+	formattest.RunConformance(t, New(), formattest.Fixtures{
+		Document:        samplePlist,
+		RoundTripExact:  true,
+		OrderedKeys:     []string{"AppleShowAllFiles", "FXPreferredViewStyle", "RecentCount", "Scale", "Token", "LastOpened", "Windows", "Empty"},
+		LeafPath:        []string{"FXPreferredViewStyle"},
+		LeafValue:       "Nlsv",
+		WildcardPath:    []string{"Windows", "*", "Width"},
+		WildcardMatches: [][]string{{"Windows", "0", "Width"}, {"Windows", "1", "Width"}},
+		DeepPath:        []string{"new", "nested", "key"},
+	})
+}
@@ -0,0 +1,319 @@
+// Package properties provides a handler for Java .properties files for chezmoi-split.
+package properties
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// defaultSeparator is used for keys that no parsed document defined.
+const defaultSeparator = "="
+
+// Handler implements format.Handler for .properties files.
+//
+// The tree is a flat *orderedmap.OrderedMap of key to string value. Parsing
+// follows java.util.Properties.load: "key=value", "key: value", and
+// "key value" lines, # and ! comments, backslash line continuations, and
+// \uXXXX escapes. The handler remembers the separator each key was written
+// with from the first document that defines it, so managed lines keep the
+// template's style and preserved app-only lines keep theirs.
+type Handler struct {
+	separators map[string]string
+}
+
+// New creates a new properties handler.
+func New() *Handler {
+	return &Handler{separators: make(map[string]string)}
+}
+
+// Parse reads the logical lines of a .properties file and returns an
+// *orderedmap.OrderedMap. Comments and blank lines are skipped; if a key
+// appears twice, the last value wins.
+func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
+	if opts.StripComments {
+		return nil, fmt.Errorf("strip-comments is not supported for properties format")
+	}
+
+	result := orderedmap.New()
+	for _, line := range logicalLines(string(data)) {
+		rawKey, sep, rawValue := splitEntry(line.text)
+
+		key, err := unescape(rawKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse properties: line %d: %w", line.num, err)
+		}
+		value, err := unescape(rawValue)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse properties: line %d: %w", line.num, err)
+		}
+
+		result.Set(key, value)
+		if _, seen := h.separators[key]; !seen {
+			h.separators[key] = sep
+		}
+	}
+
+	return result, nil
+}
+
+// logicalLine is one key-value entry, possibly joined from several natural lines.
+type logicalLine struct {
+	text string
+	num  int // Line number of the first natural line
+}
+
+// logicalLines splits data into logical lines, dropping comments and blank
+// lines and joining lines that end in an odd number of backslashes with the
+// next line, whose leading whitespace is skipped.
+func logicalLines(data string) []logicalLine {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+
+	var lines []logicalLine
+	var sb strings.Builder
+	start := 0
+	continuing := false
+
+	for i, natural := range strings.Split(data, "\n") {
+		trimmed := strings.TrimLeft(natural, " \t\f")
+		if !continuing {
+			if trimmed == "" || trimmed[0] == '#' || trimmed[0] == '!' {
+				continue
+			}
+			start = i + 1
+		}
+
+		trailing := len(trimmed) - len(strings.TrimRight(trimmed, `\`))
+		if trailing%2 == 1 {
+			sb.WriteString(trimmed[:len(trimmed)-1])
+			continuing = true
+			continue
+		}
+
+		sb.WriteString(trimmed)
+		lines = append(lines, logicalLine{text: sb.String(), num: start})
+		sb.Reset()
+		continuing = false
+	}
+
+	// A continuation on the last line joins with nothing
+	if continuing {
+		lines = append(lines, logicalLine{text: sb.String(), num: start})
+	}
+
+	return lines
+}
+
+// splitEntry splits a logical line into its raw key, the separator as
+// written, and the raw value. The key ends at the first unescaped '=', ':',
+// or whitespace; the separator is surrounding whitespace with at most one
+// '=' or ':'.
+func splitEntry(line string) (key, sep, value string) {
+	end := 0
+	for end < len(line) {
+		c := line[end]
+		if c == '\\' {
+			end += 2
+			continue
+		}
+		if c == '=' || c == ':' || c == ' ' || c == '\t' || c == '\f' {
+			break
+		}
+		end++
+	}
+	end = min(end, len(line))
+
+	rest := line[end:]
+	i := len(rest) - len(strings.TrimLeft(rest, " \t\f"))
+	if i < len(rest) && (rest[i] == '=' || rest[i] == ':') {
+		i++
+		i += len(rest[i:]) - len(strings.TrimLeft(rest[i:], " \t\f"))
+	}
+
+	return line[:end], rest[:i], rest[i:]
+}
+
+// unescape resolves backslash escapes: \t, \n, \r, \f, \uXXXX, and \c for
+// any other character c.
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 == len(s) {
+			sb.WriteByte(c)
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 't':
+			sb.WriteByte('\t')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'u':
+			if i+5 > len(s) {
+				return "", fmt.Errorf("malformed \\uXXXX escape %q", s[i-1:])
+			}
+			r, err := strconv.ParseUint(s[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("malformed \\uXXXX escape %q", s[i-1:i+5])
+			}
+			sb.WriteRune(rune(r))
+			i += 4
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// Serialize writes the tree as one key-value line per entry in key order,
+// using each key's recorded separator.
+func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, error) {
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return nil, fmt.Errorf("tree is not an ordered map")
+	}
+
+	var sb strings.Builder
+	for _, key := range om.Keys() {
+		val, _ := om.Get(key)
+		strVal := toString(val)
+		// A bare "key" line has no separator, which a value would run into
+		sep, ok := h.separators[key]
+		if !ok || (sep == "" && strVal != "") {
+			sep = defaultSeparator
+		}
+		sb.WriteString(escape(key, true))
+		sb.WriteString(sep)
+		sb.WriteString(escape(strVal, false))
+		sb.WriteByte('\n')
+	}
+
+	return []byte(sb.String()), nil
+}
+
+// escape writes s so that the parser reads it back unchanged. Keys also
+// escape the separator characters, whitespace, and comment markers; values
+// only need leading whitespace escaped. Non-ASCII text is written as is,
+// since properties files are read as UTF-8 by current JVMs.
+func escape(s string, isKey bool) string {
+	var sb strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			sb.WriteString(`\\`)
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\r':
+			sb.WriteString(`\r`)
+		case r == '\f':
+			sb.WriteString(`\f`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&sb, `\u%04X`, r)
+		case r == ' ' && (isKey || i == 0):
+			sb.WriteString(`\ `)
+		case isKey && strings.ContainsRune("=:#!", r):
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// toString converts any value to its string representation.
+// Properties only hold strings.
+func toString(v any) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// GetPath extracts a property's value. Paths must be a single segment
+// (the key) or "*" for the first property.
+func (h *Handler) GetPath(tree any, p path.Path) (any, bool) {
+	segments := p.Segments()
+	if len(segments) != 1 {
+		return nil, false
+	}
+
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return nil, false
+	}
+
+	if segments[0] == "*" {
+		for _, key := range om.Keys() {
+			val, _ := om.Get(key)
+			return val, true
+		}
+		return nil, false
+	}
+
+	return om.Get(segments[0])
+}
+
+// SetPath sets a property's value. Paths must be a single segment
+// (the key) or "*" to set every property.
+func (h *Handler) SetPath(tree any, p path.Path, value any) error {
+	segments := p.Segments()
+	if len(segments) != 1 {
+		return fmt.Errorf("properties paths must have exactly 1 segment (the key), got %d", len(segments))
+	}
+
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return fmt.Errorf("tree is not an ordered map")
+	}
+
+	value, err := format.NormalizeForFormat(value, "properties")
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %w", p, err)
+	}
+
+	strVal := toString(value)
+	if segments[0] == "*" {
+		for _, key := range om.Keys() {
+			om.Set(key, strVal)
+		}
+		return nil
+	}
+
+	om.Set(segments[0], strVal)
+	return nil
+}
+
+// DeletePath removes a property. Paths must be a single segment (the key)
+// or "*" to delete every property.
+func (h *Handler) DeletePath(tree any, p path.Path) error {
+	segments := p.Segments()
+	if len(segments) != 1 {
+		return fmt.Errorf("properties paths must have exactly 1 segment (the key), got %d", len(segments))
+	}
+	return format.DeleteMatches(tree, segments)
+}
+
+// Ensure Handler implements format.Handler.
+var _ format.Handler = (*Handler)(nil)
@@ -0,0 +1,245 @@
+package properties
+
+import (
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/formattest"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+func TestHandler_Parse(t *testing.T) {
+	h := New()
+
+	input := `# Gradle settings
+! also a comment
+org.gradle.jvmargs=-Xmx2g -Dfile.encoding=UTF-8
+org.gradle.parallel = true
+kotlin.code.style: official
+spaced   value with spaces
+url=https://example.com:8443/path
+multi = first, \
+        second, \
+        third
+escaped\ key\=x = a\tb\\c
+unicode=caf\u00e9 \u2603
+emptyValue=
+bareKey
+trailing=ends with backslash\\
+`
+
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	om := tree.(*orderedmap.OrderedMap)
+	want := []struct {
+		key   string
+		value string
+	}{
+		{"org.gradle.jvmargs", "-Xmx2g -Dfile.encoding=UTF-8"},
+		{"org.gradle.parallel", "true"},
+		{"kotlin.code.style", "official"},
+		{"spaced", "value with spaces"},
+		{"url", "https://example.com:8443/path"},
+		{"multi", "first, second, third"},
+		{"escaped key=x", "a\tb\\c"},
+		{"unicode", "café ☃"},
+		{"emptyValue", ""},
+		{"bareKey", ""},
+		{"trailing", `ends with backslash\`},
+	}
+
+	keys := om.Keys()
+	if len(keys) != len(want) {
+		t.Fatalf("Parse() got keys %v, want %d keys", keys, len(want))
+	}
+	for i, w := range want {
+		if keys[i] != w.key {
+			t.Errorf("key[%d] = %q, want %q", i, keys[i], w.key)
+		}
+		if got, _ := om.Get(w.key); got != w.value {
+			t.Errorf("%s = %q, want %q", w.key, got, w.value)
+		}
+	}
+}
+
+func TestHandler_Parse_CommentsDoNotContinue(t *testing.T) {
+	// A comment ending in a backslash doesn't swallow the next line, and
+	// CRLF line endings are accepted
+	tree, err := New().Parse([]byte("# comment \\\r\nkey=value\r\n"), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	om := tree.(*orderedmap.OrderedMap)
+	if got, _ := om.Get("key"); got != "value" {
+		t.Errorf("key = %q, want value (keys %v)", got, om.Keys())
+	}
+}
+
+func TestHandler_Parse_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"short unicode escape", "key=\\u12\n"},
+		{"bad unicode escape", "key=\\uzzzz\n"},
+		{"bad unicode in key", "k\\uXYZW=v\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New().Parse([]byte(tt.input), format.ParseOptions{}); err == nil {
+				t.Error("Parse() expected error")
+			}
+		})
+	}
+
+	if _, err := New().Parse([]byte("key=value\n"), format.ParseOptions{StripComments: true}); err == nil {
+		t.Error("Parse() with StripComments should return error for properties")
+	}
+}
+
+func TestHandler_RoundTrip_PreservesSeparators(t *testing.T) {
+	h := New()
+
+	input := `a=1
+b = 2
+c: 3
+d 4
+url=https://example.com:8443/#top
+`
+
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if string(data) != input {
+		t.Errorf("Serialize() = %q, want %q", string(data), input)
+	}
+}
+
+func TestHandler_Serialize_Escapes(t *testing.T) {
+	h := New()
+
+	tree := orderedmap.New()
+	tree.Set("key with spaces", " leading space")
+	tree.Set("a=b:c#d!e", "x=y:z #!")
+	tree.Set("multi", "line1\nline2\ttab")
+	tree.Set("path", `C:\Users\me`)
+	tree.Set("unicode", "café")
+	tree.Set("control", "bell\a")
+	tree.Set("number", 42)
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	want := `key\ with\ spaces=\ leading space
+a\=b\:c\#d\!e=x=y:z #!
+multi=line1\nline2\ttab
+path=C:\\Users\\me
+unicode=café
+control=bell\u0007
+number=42
+`
+	if string(data) != want {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, want)
+	}
+
+	// Everything written reads back unchanged
+	parsed, err := New().Parse(data, format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	for _, key := range tree.Keys() {
+		orig, _ := tree.Get(key)
+		got, _ := parsed.(*orderedmap.OrderedMap).Get(key)
+		if got != toString(orig) {
+			t.Errorf("%q read back as %q, want %q", key, got, toString(orig))
+		}
+	}
+}
+
+func TestHandler_BareKeyGetsSeparator(t *testing.T) {
+	h := New()
+	tree, err := h.Parse([]byte("flag\n"), format.ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"flag"}), "on"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "flag=on\n" {
+		t.Errorf("Serialize() = %q, want %q", data, "flag=on\n")
+	}
+}
+
+func TestHandler_ManagedStyleWins(t *testing.T) {
+	h := New()
+
+	// Managed is parsed first, so its separator applies to shared keys;
+	// keys only in current keep the current separator.
+	managed, _ := h.Parse([]byte("token = managed\n"), format.ParseOptions{})
+	current, _ := h.Parse([]byte("token:current\nextra: x\n"), format.ParseOptions{})
+
+	token, _ := h.GetPath(current, path.NewArrayPath([]string{"token"}))
+	extra, _ := h.GetPath(current, path.NewArrayPath([]string{"extra"}))
+	_ = h.SetPath(managed, path.NewArrayPath([]string{"token"}), token)
+	_ = h.SetPath(managed, path.NewArrayPath([]string{"extra"}), extra)
+
+	data, err := h.Serialize(managed, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	want := "token = current\nextra: x\n"
+	if string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", string(data), want)
+	}
+}
+
+func TestHandler_PathDepth(t *testing.T) {
+	h := New()
+	tree := orderedmap.New()
+	tree.Set("key", "value")
+
+	if _, ok := h.GetPath(tree, path.NewArrayPath([]string{"key", "nested"})); ok {
+		t.Error("GetPath() should reject multi-segment paths")
+	}
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"key", "nested"}), "x"); err == nil {
+		t.Error("SetPath() should reject multi-segment paths")
+	}
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"key"}), orderedmap.New()); err == nil {
+		t.Error("SetPath() should reject a map value")
+	}
+}
+
+func TestHandler_Conformance(t *testing.T) {
+	formattest.RunConformance(t, New(), formattest.Fixtures{
+		Document: `zebra=z
+org.gradle.jvmargs = -Xmx2g
+apple: a
+`,
+		RoundTripExact:  true,
+		OrderedKeys:     []string{"zebra", "org.gradle.jvmargs", "apple"},
+		LeafPath:        []string{"org.gradle.jvmargs"},
+		LeafValue:       "-Xmx2g",
+		WildcardPath:    []string{"*"},
+		WildcardMatches: [][]string{{"zebra"}, {"org.gradle.jvmargs"}, {"apple"}},
+		DeepPath:        []string{"new.key"},
+	})
+}
@@ -0,0 +1,529 @@
+// Package reg provides a handler for Windows registry export files (.reg)
+// for chezmoi-split.
+package reg
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// DefaultHeader is written when no parsed document had a header line.
+const DefaultHeader = "Windows Registry Editor Version 5.00"
+
+// Data is a registry value kept as written after the "=", for the types the
+// tree has no Go type for: hex(n): values such as REG_EXPAND_SZ or
+// REG_MULTI_SZ, and "-", which deletes the value on import. Line
+// continuations are removed.
+type Data string
+
+// entry records how a key header or value line was written in a parsed
+// document.
+type entry struct {
+	above     []string   // Comment and blank lines before the line
+	line      string     // Key headers: the line as written
+	spellings []spelling // Values: each distinct data written, first document first
+}
+
+// spelling is value data as written, continuation lines included, with the
+// parsed data it stands for.
+type spelling struct {
+	raw   string
+	value any
+}
+
+// Handler implements format.Handler for .reg files.
+//
+// The tree is an *orderedmap.OrderedMap of registry keys, named as written
+// between the brackets ("HKEY_CURRENT_USER\Software\App", or with a leading
+// "-" for a key deleted on import), each an *orderedmap.OrderedMap of value
+// name to data. The default value is named "@". Data is typed:
+//   - "string" → string
+//   - dword:0000000a → int64
+//   - hex:01,02 (REG_BINARY) → []byte
+//   - hex(n):... and "-" → Data
+//
+// Key and value names are matched as written. The handler remembers the
+// header line from the first document, and the comments and layout of every
+// line from the first document that defines it. Value data is remembered as
+// written in every document, so hex data copied from the current file keeps
+// its line wrapping.
+type Handler struct {
+	header  string
+	entries map[string]entry
+	footer  []string // Comment lines after the last line of the first document
+	parsed  bool
+}
+
+// New creates a new .reg handler.
+func New() *Handler {
+	return &Handler{entries: make(map[string]entry)}
+}
+
+// entryKey returns the entries key for a registry key header (name "") or
+// for a value within a key.
+func entryKey(key, name string) string {
+	return key + "\x00" + name
+}
+
+// Parse reads a .reg file and returns an *orderedmap.OrderedMap of registry
+// keys. UTF-16 files, as written by regedit, are decoded. A key that appears
+// twice is merged into its first occurrence.
+func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
+	if opts.StripComments {
+		return nil, fmt.Errorf("strip-comments is not supported for reg format")
+	}
+
+	result := orderedmap.New()
+	record := func(key string, e entry) {
+		prev, seen := h.entries[key]
+		if !seen {
+			h.entries[key] = e
+			return
+		}
+		// Later documents only add how they spelled other data
+		for _, sp := range e.spellings {
+			if prev.spelling(sp.value) == "" {
+				prev.spellings = append(prev.spellings, sp)
+			}
+		}
+		h.entries[key] = prev
+	}
+
+	var pending []string
+	var values *orderedmap.OrderedMap
+	keyName := ""
+	lines := strings.Split(strings.ReplaceAll(decode(data), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNum := i + 1
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" {
+			if i < len(lines)-1 {
+				pending = append(pending, "")
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, ";") {
+			pending = append(pending, trimmed)
+			continue
+		}
+
+		if trimmed == DefaultHeader || trimmed == "REGEDIT4" {
+			if values != nil {
+				return nil, fmt.Errorf("failed to parse reg: line %d: header after the first key", lineNum)
+			}
+			if h.header == "" {
+				h.header = trimmed
+			}
+			pending = nil
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "[") {
+			if !strings.HasSuffix(trimmed, "]") || len(trimmed) < 3 {
+				return nil, fmt.Errorf("failed to parse reg: line %d: invalid key header %q", lineNum, trimmed)
+			}
+			keyName = trimmed[1 : len(trimmed)-1]
+			val, exists := result.Get(keyName)
+			if !exists {
+				val = orderedmap.New()
+				result.Set(keyName, val)
+			}
+			values = val.(*orderedmap.OrderedMap)
+			record(entryKey(keyName, ""), entry{above: pending, line: trimmed})
+			pending = nil
+			continue
+		}
+
+		if values == nil {
+			return nil, fmt.Errorf("failed to parse reg: line %d: value outside of a key", lineNum)
+		}
+		name, rest, err := parseName(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse reg: line %d: %w", lineNum, err)
+		}
+
+		// A trailing backslash continues hex data on the next line
+		raw := rest
+		for strings.HasSuffix(strings.TrimRight(rest, " \t"), "\\") && i+1 < len(lines) {
+			i++
+			raw += "\n" + lines[i]
+			rest = strings.TrimSuffix(strings.TrimRight(rest, " \t"), "\\") + strings.TrimSpace(lines[i])
+		}
+
+		value, err := parseData(rest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse reg: line %d: %w", lineNum, err)
+		}
+		values.Set(name, value)
+		record(entryKey(keyName, name), entry{above: pending, spellings: []spelling{{raw, value}}})
+		pending = nil
+	}
+
+	if !h.parsed {
+		for len(pending) > 0 && pending[len(pending)-1] == "" {
+			pending = pending[:len(pending)-1]
+		}
+		h.footer = pending
+		h.parsed = true
+	}
+
+	return result, nil
+}
+
+// spelling returns the recorded data written for value, or "".
+func (e entry) spelling(value any) string {
+	for _, sp := range e.spellings {
+		if reflect.DeepEqual(sp.value, value) {
+			return sp.raw
+		}
+	}
+	return ""
+}
+
+// decode returns data as a string, decoding UTF-16 with a byte order mark
+// and dropping a UTF-8 one.
+func decode(data []byte) string {
+	var order func([]byte) uint16
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		order = func(b []byte) uint16 { return uint16(b[0]) | uint16(b[1])<<8 }
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		order = func(b []byte) uint16 { return uint16(b[0])<<8 | uint16(b[1]) }
+	default:
+		return string(bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF}))
+	}
+
+	units := make([]uint16, 0, len(data)/2)
+	for i := 2; i+1 < len(data); i += 2 {
+		units = append(units, order(data[i:i+2]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// parseName splits a value line into the value name and the data after the
+// "=". The name is "@" or a quoted string with \\ and \" escapes.
+func parseName(line string) (name, rest string, err error) {
+	if strings.HasPrefix(line, "@") {
+		name, rest = "@", line[1:]
+	} else {
+		if !strings.HasPrefix(line, `"`) {
+			return "", "", fmt.Errorf("expected \"name\"=data or @=data, got %q", line)
+		}
+		var n int
+		name, n, err = unquote(line)
+		if err != nil {
+			return "", "", err
+		}
+		rest = line[n:]
+	}
+
+	rest = strings.TrimLeft(rest, " \t")
+	if !strings.HasPrefix(rest, "=") {
+		return "", "", fmt.Errorf("expected = after value name %q", name)
+	}
+	return name, strings.TrimLeft(rest[1:], " \t"), nil
+}
+
+// unquote reads the quoted string at the start of s and returns its contents
+// and the number of bytes it spans, quotes included.
+func unquote(s string) (string, int, error) {
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+			}
+			sb.WriteByte(s[i])
+		case '"':
+			return sb.String(), i + 1, nil
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string %q", s)
+}
+
+// parseData converts the data after the "=" of a value line, continuation
+// lines already joined, to its tree value.
+func parseData(s string) (any, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		value, n, err := unquote(s)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(s[n:]) != "" {
+			return nil, fmt.Errorf("unexpected text after string %q", s)
+		}
+		return value, nil
+	case strings.HasPrefix(strings.ToLower(s), "dword:"):
+		n, err := strconv.ParseUint(strings.TrimSpace(s[len("dword:"):]), 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dword %q", s)
+		}
+		return int64(n), nil
+	case strings.HasPrefix(strings.ToLower(s), "hex:"):
+		return parseHex(s[len("hex:"):])
+	case strings.HasPrefix(strings.ToLower(s), "hex("):
+		kind, bytesText, ok := strings.Cut(s, ":")
+		if !ok || !strings.HasSuffix(kind, ")") {
+			return nil, fmt.Errorf("invalid hex data %q", s)
+		}
+		if _, err := parseHex(bytesText); err != nil {
+			return nil, err
+		}
+		return Data(strings.ToLower(kind) + ":" + strings.Join(strings.Fields(bytesText), "")), nil
+	case s == "-":
+		return Data(s), nil
+	default:
+		return nil, fmt.Errorf("unsupported data %q", s)
+	}
+}
+
+// parseHex parses comma-separated hex bytes ("01,ff,00").
+func parseHex(s string) ([]byte, error) {
+	s = strings.Join(strings.Fields(s), "")
+	result := []byte{}
+	if s == "" {
+		return result, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		b, err := hex.DecodeString(part)
+		if err != nil || len(b) != 1 {
+			return nil, fmt.Errorf("invalid hex byte %q", part)
+		}
+		result = append(result, b[0])
+	}
+	return result, nil
+}
+
+// Serialize writes the header line, then each registry key in order with a
+// blank line before it, and each of its values, with CRLF line endings. A
+// value whose data matches data in a parsed document keeps the layout it was
+// written with there, hex line wrapping included; others are written on one
+// line.
+func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, error) {
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return nil, fmt.Errorf("tree is not an ordered map")
+	}
+
+	var sb strings.Builder
+	header := h.header
+	if header == "" {
+		header = DefaultHeader
+	}
+	sb.WriteString(header + "\n")
+
+	for _, name := range om.Keys() {
+		val, _ := om.Get(name)
+		values := format.ToOrderedMapPtr(val)
+		if values == nil {
+			return nil, fmt.Errorf("key %q is not a map", name)
+		}
+
+		ent := h.entries[entryKey(name, "")]
+		sb.WriteByte('\n')
+		writeComments(&sb, ent.above, true)
+		if ent.line != "" {
+			sb.WriteString(ent.line)
+		} else {
+			sb.WriteString("[" + name + "]")
+		}
+		sb.WriteByte('\n')
+
+		for _, valueName := range values.Keys() {
+			v, _ := values.Get(valueName)
+			data, err := formatData(v)
+			if err != nil {
+				return nil, fmt.Errorf("key %q value %q: %w", name, valueName, err)
+			}
+			ent := h.entries[entryKey(name, valueName)]
+			if raw := ent.spelling(v); raw != "" {
+				data = raw
+			}
+			writeComments(&sb, ent.above, false)
+			sb.WriteString(formatName(valueName) + "=" + data + "\n")
+		}
+	}
+
+	writeComments(&sb, h.footer, false)
+	// regedit writes and expects CRLF line endings
+	return []byte(strings.ReplaceAll(sb.String(), "\n", "\r\n")), nil
+}
+
+// writeComments writes the lines recorded above an entry. A blank line
+// before a key header is dropped, since Serialize separates keys itself.
+func writeComments(sb *strings.Builder, lines []string, header bool) {
+	for i, line := range lines {
+		if line == "" && header && i == 0 {
+			continue
+		}
+		if line == "" && i > 0 && lines[i-1] == "" {
+			continue
+		}
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+}
+
+// formatName writes a value name: "@" for the default value, otherwise a
+// quoted string.
+func formatName(name string) string {
+	if name == "@" {
+		return name
+	}
+	return quote(name)
+}
+
+// quote writes s as a quoted .reg string, escaping backslashes and quotes.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// formatData writes a tree value as .reg data.
+func formatData(v any) (string, error) {
+	switch val := v.(type) {
+	case string:
+		return quote(val), nil
+	case int64:
+		return fmt.Sprintf("dword:%08x", val), nil
+	case []byte:
+		parts := make([]string, len(val))
+		for i, b := range val {
+			parts[i] = fmt.Sprintf("%02x", b)
+		}
+		return "hex:" + strings.Join(parts, ","), nil
+	case Data:
+		return string(val), nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", v)
+	}
+}
+
+// GetPath extracts a value at the given path. Paths are ["Key"] for a whole
+// registry key or ["Key", "Name"] for one value. "*" matches any key or
+// value name and returns the first match.
+func (h *Handler) GetPath(tree any, p path.Path) (any, bool) {
+	segments := p.Segments()
+	if len(segments) == 0 || len(segments) > 2 {
+		return nil, false
+	}
+
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return nil, false
+	}
+
+	keys := []string{segments[0]}
+	if segments[0] == path.Wildcard {
+		keys = om.Keys()
+	}
+
+	for _, name := range keys {
+		val, exists := om.Get(name)
+		if !exists {
+			continue
+		}
+		if len(segments) == 1 {
+			return val, true
+		}
+		values := format.ToOrderedMapPtr(val)
+		if values == nil {
+			continue
+		}
+		if segments[1] == path.Wildcard {
+			for _, valueName := range values.Keys() {
+				v, _ := values.Get(valueName)
+				return v, true
+			}
+			continue
+		}
+		if v, ok := values.Get(segments[1]); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// SetPath sets a value at the given path. A whole registry key must be a
+// map of values; a value is a string, an integer that fits a dword, []byte,
+// or Data. Missing keys are created, and "*" applies to every key or value.
+func (h *Handler) SetPath(tree any, p path.Path, value any) error {
+	segments := p.Segments()
+	if len(segments) == 0 || len(segments) > 2 {
+		return fmt.Errorf("reg paths must have 1 or 2 segments, got %d", len(segments))
+	}
+
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return fmt.Errorf("tree is not an ordered map")
+	}
+
+	value, err := format.NormalizeForFormat(value, "reg")
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %w", p, err)
+	}
+	if _, isKey := value.(*orderedmap.OrderedMap); isKey != (len(segments) == 1) {
+		reason := "a registry value must be a string, dword, or binary data"
+		if len(segments) == 1 {
+			reason = "a registry key must be a map of values"
+		}
+		return fmt.Errorf("cannot set %s: %w", p, &format.UnsupportedValueError{Format: "reg", Type: fmt.Sprintf("%T", value), Reason: reason})
+	}
+
+	keys := []string{segments[0]}
+	if segments[0] == path.Wildcard {
+		keys = om.Keys()
+	}
+
+	for _, name := range keys {
+		if len(segments) == 1 {
+			om.Set(name, value)
+			continue
+		}
+
+		val, exists := om.Get(name)
+		if !exists {
+			val = orderedmap.New()
+			om.Set(name, val)
+		}
+		values := format.ToOrderedMapPtr(val)
+		if values == nil {
+			return fmt.Errorf("key %q is not a map", name)
+		}
+
+		if segments[1] == path.Wildcard {
+			for _, valueName := range values.Keys() {
+				values.Set(valueName, value)
+			}
+			continue
+		}
+		values.Set(segments[1], value)
+	}
+
+	return nil
+}
+
+// DeletePath removes a whole registry key or one value. "*" deletes every
+// key or value it matches.
+func (h *Handler) DeletePath(tree any, p path.Path) error {
+	segments := p.Segments()
+	if len(segments) == 0 || len(segments) > 2 {
+		return fmt.Errorf("reg paths must have 1 or 2 segments, got %d", len(segments))
+	}
+	return format.DeleteMatches(tree, segments)
+}
+
+// Ensure Handler implements format.Handler.
+var _ format.Handler = (*Handler)(nil)
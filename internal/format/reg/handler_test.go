@@ -0,0 +1,252 @@
+package reg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/formattest"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+func TestHandler_Parse(t *testing.T) {
+	h := New()
+
+	input := `Windows Registry Editor Version 5.00
+
+[HKEY_CURRENT_USER\Software\App]
+@="default"
+"Path"="C:\\Program Files\\App"
+"Quote"="say \"hi\""
+"Count"=dword:0000000a
+"Blob"=hex:01,ff,00
+"Expand"=hex(2):25,00,50,00,\
+  41,00,00,00
+"Old"=-
+
+[-HKEY_CURRENT_USER\Software\Stale]
+`
+
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	om := tree.(*orderedmap.OrderedMap)
+	wantKeys := []string{`HKEY_CURRENT_USER\Software\App`, `-HKEY_CURRENT_USER\Software\Stale`}
+	if !reflect.DeepEqual(om.Keys(), wantKeys) {
+		t.Fatalf("keys = %q, want %q", om.Keys(), wantKeys)
+	}
+
+	app := `HKEY_CURRENT_USER\Software\App`
+	tests := []struct {
+		name string
+		want any
+	}{
+		{"@", "default"},
+		{"Path", `C:\Program Files\App`},
+		{"Quote", `say "hi"`},
+		{"Count", int64(10)},
+		{"Blob", []byte{0x01, 0xff, 0x00}},
+		{"Expand", Data("hex(2):25,00,50,00,41,00,00,00")},
+		{"Old", Data("-")},
+	}
+	for _, tt := range tests {
+		got, ok := h.GetPath(tree, path.NewArrayPath([]string{app, tt.name}))
+		if !ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetPath(%q) = %#v, %v; want %#v", tt.name, got, ok, tt.want)
+		}
+	}
+}
+
+func TestHandler_Parse_UTF16(t *testing.T) {
+	text := "Windows Registry Editor Version 5.00\r\n\r\n[HKEY_CURRENT_USER\\Software\\App]\r\n\"Name\"=\"Grüße\"\r\n"
+	data := []byte{0xFF, 0xFE}
+	for _, u := range utf16.Encode([]rune(text)) {
+		data = append(data, byte(u), byte(u>>8))
+	}
+
+	tree, err := New().Parse(data, format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	got, _ := New().GetPath(tree, path.NewArrayPath([]string{`HKEY_CURRENT_USER\Software\App`, "Name"}))
+	if got != "Grüße" {
+		t.Errorf("Name = %#v, want %q", got, "Grüße")
+	}
+}
+
+func TestHandler_Parse_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"value outside key", "Windows Registry Editor Version 5.00\n\n\"Name\"=\"x\"\n"},
+		{"unquoted name", "[HKEY_CURRENT_USER\\App]\nName=\"x\"\n"},
+		{"unterminated string", "[HKEY_CURRENT_USER\\App]\n\"Name\"=\"x\n"},
+		{"invalid dword", "[HKEY_CURRENT_USER\\App]\n\"Count\"=dword:xyz\n"},
+		{"invalid hex", "[HKEY_CURRENT_USER\\App]\n\"Blob\"=hex:1g\n"},
+		{"unknown data", "[HKEY_CURRENT_USER\\App]\n\"Name\"=qword:1\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New().Parse([]byte(tt.input), format.ParseOptions{}); err == nil {
+				t.Error("Parse() expected error")
+			}
+		})
+	}
+}
+
+func TestHandler_RoundTrip_PreservesLayout(t *testing.T) {
+	h := New()
+
+	input := `Windows Registry Editor Version 5.00
+
+; Editor settings
+[HKEY_CURRENT_USER\Software\Editor]
+"FontSize"=dword:0000000e
+"Recent"=hex(7):61,00,2e,00,74,00,78,00,74,00,00,00,62,00,2e,00,74,00,78,00,\
+  74,00,00,00,00,00
+
+[HKEY_CURRENT_USER\Software\Editor\Window]
+"Maximized"=dword:00000001
+`
+	input = strings.ReplaceAll(input, "\n", "\r\n") // As regedit writes it
+
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if string(data) != input {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, input)
+	}
+}
+
+func TestHandler_KeepsCurrentHexLayout(t *testing.T) {
+	h := New()
+	key := `HKEY_CURRENT_USER\Software\App`
+	managed, err := h.Parse([]byte("[HKEY_CURRENT_USER\\Software\\App]\r\n\"WindowPos\"=hex:00,00\r\n"), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(managed) error = %v", err)
+	}
+	blob := "hex:2c,00,00,00,00,00,00,00,01,00,00,00,ff,ff,ff,ff,ff,ff,ff,ff,ff,ff,ff,ff,\\\r\n" +
+		"  ff,ff,ff,ff,64,00,00,00,32,00,00,00,20,03,00,00,58,02,00,00"
+	current, err := h.Parse([]byte("[HKEY_CURRENT_USER\\Software\\App]\r\n\"WindowPos\"="+blob+"\r\n"), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(current) error = %v", err)
+	}
+
+	p := path.NewArrayPath([]string{key, "WindowPos"})
+	val, found := h.GetPath(current, p)
+	if !found {
+		t.Fatal("GetPath() not found")
+	}
+	if err := h.SetPath(managed, p, val); err != nil {
+		t.Fatalf("SetPath() error = %v", err)
+	}
+
+	data, err := h.Serialize(managed, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := "Windows Registry Editor Version 5.00\r\n\r\n[HKEY_CURRENT_USER\\Software\\App]\r\n\"WindowPos\"=" + blob + "\r\n"
+	if string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", data, want)
+	}
+}
+
+func TestHandler_SetPath_TypedValues(t *testing.T) {
+	h := New()
+	tree, _ := h.Parse([]byte("[HKEY_CURRENT_USER\\Software\\App]\n\"Count\"=dword:00000001\n"), format.ParseOptions{})
+
+	key := `HKEY_CURRENT_USER\Software\App`
+	values := []struct {
+		name  string
+		value any
+	}{
+		{"Count", 255},
+		{"Path", `C:\Temp "x"`},
+		{"Blob", []byte{0xde, 0xad}},
+		{"Old", Data("-")},
+	}
+	for _, v := range values {
+		if err := h.SetPath(tree, path.NewArrayPath([]string{key, v.name}), v.value); err != nil {
+			t.Fatalf("SetPath(%q) error = %v", v.name, err)
+		}
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := `Windows Registry Editor Version 5.00
+
+[HKEY_CURRENT_USER\Software\App]
+"Count"=dword:000000ff
+"Path"="C:\\Temp \"x\""
+"Blob"=hex:de,ad
+"Old"=-
+`
+	if string(data) != strings.ReplaceAll(want, "\n", "\r\n") {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestHandler_SetPath_Errors(t *testing.T) {
+	h := New()
+	tree, _ := h.Parse([]byte("[HKEY_CURRENT_USER\\App]\n\"Name\"=\"x\"\n"), format.ParseOptions{})
+
+	tests := []struct {
+		name  string
+		path  []string
+		value any
+	}{
+		{"too deep", []string{`HKEY_CURRENT_USER\App`, "Name", "x"}, "x"},
+		{"key needs a map", []string{`HKEY_CURRENT_USER\App`}, "x"},
+		{"value rejects a map", []string{`HKEY_CURRENT_USER\App`, "Name"}, orderedmap.New()},
+		{"dword overflow", []string{`HKEY_CURRENT_USER\App`, "Name"}, int64(1) << 32},
+		{"negative dword", []string{`HKEY_CURRENT_USER\App`, "Name"}, -1},
+		{"bool", []string{`HKEY_CURRENT_USER\App`, "Name"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := h.SetPath(tree, path.NewArrayPath(tt.path), tt.value)
+			if err == nil {
+				t.Fatal("SetPath() expected error")
+			}
+			if strings.Contains(tt.name, "dword") && !strings.Contains(err.Error(), "dword") {
+				t.Errorf("SetPath() error = %v, want a dword range error", err)
+			}
+		})
+	}
+}
+
+func TestHandler_Conformance(t *testing.T) {
+	formattest.RunConformance(t, New(), formattest.Fixtures{
+		Document: strings.ReplaceAll(`Windows Registry Editor Version 5.00
+
+[HKEY_CURRENT_USER\Software\Zebra]
+"Name"="zebra"
+
+[HKEY_CURRENT_USER\Software\Apple]
+"Name"="apple"
+"Size"=dword:00000010
+`, "\n", "\r\n"),
+		RoundTripExact:  true,
+		OrderedKeys:     []string{`HKEY_CURRENT_USER\Software\Zebra`, `HKEY_CURRENT_USER\Software\Apple`},
+		LeafPath:        []string{`HKEY_CURRENT_USER\Software\Zebra`, "Name"},
+		LeafValue:       "zebra",
+		WildcardPath:    []string{"*", "Name"},
+		WildcardMatches: [][]string{{`HKEY_CURRENT_USER\Software\Zebra`, "Name"}, {`HKEY_CURRENT_USER\Software\Apple`, "Name"}},
+		DeepPath:        []string{`HKEY_CURRENT_USER\Software\New`, "Name"},
+	})
+}
@@ -0,0 +1,465 @@
+// Package sshconfig provides a handler for OpenSSH client config files
+// (~/.ssh/config) for chezmoi-split.
+package sshconfig
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// defaultIndent is used for option lines when no parsed document had an
+// indented option.
+const defaultIndent = "    "
+
+// entry records how a block header or option line was written in a parsed
+// document.
+type entry struct {
+	above  []string // Comment and blank lines before the line
+	line   string   // Header lines: the line as written
+	indent string   // Option lines: leading whitespace
+	sep    string   // Option lines: text between keyword and arguments
+}
+
+// Handler implements format.Handler for ssh_config files.
+//
+// The tree is an *orderedmap.OrderedMap of blocks, keyed by their header
+// ("Host github.com", "Match host *.corp"), each an *orderedmap.OrderedMap
+// of option keyword to arguments. Options before the first block are stored
+// under the empty block name "", as INI does for global keys. An option
+// repeated within a block (IdentityFile, LocalForward, ...) becomes a []any
+// of strings, one per line.
+//
+// Keywords are case-insensitive, as in ssh: an option is found whatever its
+// case, and keeps the spelling it was first written with. The handler
+// remembers the comments, indentation, and separator of every line from the
+// first document that defines it, so managed lines keep the template's
+// layout and preserved app-only lines keep theirs.
+type Handler struct {
+	entries map[string]entry
+	indent  string   // Indentation of the first indented option seen
+	footer  []string // Comment lines after the last line of the first document
+	parsed  bool
+}
+
+// New creates a new ssh_config handler.
+func New() *Handler {
+	return &Handler{entries: make(map[string]entry)}
+}
+
+// entryKey returns the entries key for a block header (option "") or for
+// the n-th line of an option within a block.
+func entryKey(block, option string, n int) string {
+	if n > 0 {
+		option += "\x00" + strconv.Itoa(n)
+	}
+	return block + "\x00" + option
+}
+
+// Parse reads an ssh_config file and returns an *orderedmap.OrderedMap of
+// blocks. Lines are "Keyword arguments" or "Keyword=arguments"; Host and
+// Match lines start a new block.
+func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
+	if opts.StripComments {
+		return nil, fmt.Errorf("strip-comments is not supported for sshconfig format")
+	}
+
+	result := orderedmap.New()
+	record := func(key string, e entry) {
+		if _, seen := h.entries[key]; !seen {
+			h.entries[key] = e
+		}
+	}
+
+	var pending []string
+	block := ""
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			if i < len(lines)-1 {
+				pending = append(pending, "")
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "#") {
+			pending = append(pending, strings.TrimRight(line, " \t"))
+			continue
+		}
+
+		keyword, sep, args := splitLine(trimmed)
+		if keyword == "" {
+			return nil, fmt.Errorf("failed to parse sshconfig: line %d: missing keyword", i+1)
+		}
+
+		if name, ok := blockName(keyword, args); ok {
+			if args == "" {
+				return nil, fmt.Errorf("failed to parse sshconfig: line %d: %s needs an argument", i+1, keyword)
+			}
+			block = name
+			if _, exists := result.Get(block); !exists {
+				result.Set(block, orderedmap.New())
+			}
+			record(entryKey(block, "", 0), entry{above: pending, line: trimmed})
+			pending = nil
+			continue
+		}
+
+		val, exists := result.Get(block)
+		if !exists {
+			val = orderedmap.New()
+			result.Set(block, val)
+		}
+		options := val.(*orderedmap.OrderedMap)
+
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if h.indent == "" && indent != "" {
+			h.indent = indent
+		}
+
+		key, n := addOption(options, keyword, args)
+		record(entryKey(block, key, n), entry{above: pending, indent: indent, sep: sep})
+		pending = nil
+	}
+
+	if !h.parsed {
+		for len(pending) > 0 && pending[len(pending)-1] == "" {
+			pending = pending[:len(pending)-1]
+		}
+		h.footer = pending
+		h.parsed = true
+	}
+
+	return result, nil
+}
+
+// splitLine splits a trimmed line into its keyword, the separator as written
+// (whitespace with at most one "="), and the arguments.
+func splitLine(line string) (keyword, sep, args string) {
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return line, "", ""
+	}
+	rest := line[end:]
+	i := len(rest) - len(strings.TrimLeft(rest, " \t"))
+	if i < len(rest) && rest[i] == '=' {
+		i++
+		i += len(rest[i:]) - len(strings.TrimLeft(rest[i:], " \t"))
+	}
+	return line[:end], rest[:i], rest[i:]
+}
+
+// blockName returns the tree key for a Host or Match line, with the keyword
+// spelled canonically so paths don't depend on the file's case.
+func blockName(keyword, args string) (string, bool) {
+	for _, kw := range []string{"Host", "Match"} {
+		if strings.EqualFold(keyword, kw) {
+			return kw + " " + args, true
+		}
+	}
+	return "", false
+}
+
+// findKey returns the key in om that equals keyword ignoring case.
+func findKey(om *orderedmap.OrderedMap, keyword string) (string, bool) {
+	if _, ok := om.Get(keyword); ok {
+		return keyword, true
+	}
+	for _, k := range om.Keys() {
+		if strings.EqualFold(k, keyword) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// addOption adds a parsed option line to a block, turning a repeated option
+// into a []any. It returns the key used and the line's index among the
+// option's lines.
+func addOption(options *orderedmap.OrderedMap, keyword, args string) (string, int) {
+	key, exists := findKey(options, keyword)
+	if !exists {
+		options.Set(keyword, args)
+		return keyword, 0
+	}
+
+	existing, _ := options.Get(key)
+	values, ok := existing.([]any)
+	if !ok {
+		values = []any{existing}
+	}
+	options.Set(key, append(values, args))
+	return key, len(values)
+}
+
+// Serialize writes the global options, then each block in key order, using
+// the recorded comments and layout of each line. Options and blocks the
+// handler hasn't seen get the first document's indentation, a space as
+// separator, and a blank line before each block.
+func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, error) {
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return nil, fmt.Errorf("tree is not an ordered map")
+	}
+
+	var sb strings.Builder
+	writeBlock := func(name string) error {
+		val, _ := om.Get(name)
+		options := format.ToOrderedMapPtr(val)
+		if options == nil {
+			return fmt.Errorf("block %q is not a map", name)
+		}
+
+		if name != "" {
+			ent := h.entries[entryKey(name, "", 0)]
+			if sb.Len() > 0 {
+				sb.WriteByte('\n')
+			}
+			writeComments(&sb, ent.above, true)
+			if ent.line != "" {
+				sb.WriteString(ent.line)
+			} else {
+				sb.WriteString(name)
+			}
+			sb.WriteByte('\n')
+		}
+
+		for _, key := range options.Keys() {
+			val, _ := options.Get(key)
+			values, ok := val.([]any)
+			if !ok {
+				values = []any{val}
+			}
+			first, seen := h.entries[entryKey(name, key, 0)]
+			if !seen && name != "" {
+				first.indent = h.defaultIndent()
+			}
+			for n, v := range values {
+				// Later lines of a repeated option keep their comments but
+				// follow the first one's layout, which may be from another document
+				ent := h.entries[entryKey(name, key, n)]
+				ent.indent, ent.sep = first.indent, first.sep
+				str := toString(v)
+				if ent.sep == "" && str != "" {
+					ent.sep = " "
+				}
+				writeComments(&sb, ent.above, false)
+				sb.WriteString(ent.indent + key + ent.sep + str + "\n")
+			}
+		}
+		return nil
+	}
+
+	if _, ok := om.Get(""); ok {
+		if err := writeBlock(""); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range om.Keys() {
+		if name == "" {
+			continue
+		}
+		if err := writeBlock(name); err != nil {
+			return nil, err
+		}
+	}
+
+	writeComments(&sb, h.footer, false)
+	return []byte(sb.String()), nil
+}
+
+// defaultIndent returns the indentation for option lines the handler hasn't seen.
+func (h *Handler) defaultIndent() string {
+	if h.indent != "" {
+		return h.indent
+	}
+	return defaultIndent
+}
+
+// writeComments writes the lines recorded above an entry. Blank lines before
+// a block header are dropped, since Serialize separates blocks itself.
+func writeComments(sb *strings.Builder, lines []string, header bool) {
+	for i, line := range lines {
+		if line == "" && (header || sb.Len() == 0) && i == 0 {
+			continue
+		}
+		if line == "" && i > 0 && lines[i-1] == "" {
+			continue
+		}
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+}
+
+// toString converts any value to its string representation.
+func toString(v any) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// GetPath extracts a value at the given path. Paths are ["Host pattern"] for
+// a whole block or ["Host pattern", "Option"] for one option, with "" as the
+// block name for global options. "*" matches any block or option and
+// returns the first match. Option keywords match ignoring case.
+func (h *Handler) GetPath(tree any, p path.Path) (any, bool) {
+	segments := p.Segments()
+	if len(segments) == 0 || len(segments) > 2 {
+		return nil, false
+	}
+
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return nil, false
+	}
+
+	blocks := []string{segments[0]}
+	if segments[0] == path.Wildcard {
+		blocks = om.Keys()
+	}
+
+	for _, name := range blocks {
+		val, exists := om.Get(name)
+		if !exists {
+			continue
+		}
+		if len(segments) == 1 {
+			return val, true
+		}
+		options := format.ToOrderedMapPtr(val)
+		if options == nil {
+			continue
+		}
+		if segments[1] == path.Wildcard {
+			for _, key := range options.Keys() {
+				v, _ := options.Get(key)
+				return v, true
+			}
+			continue
+		}
+		if key, ok := findKey(options, segments[1]); ok {
+			v, _ := options.Get(key)
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// SetPath sets a value at the given path. A whole block must be a map of
+// options; an option's value is a string, or an array of strings for an
+// option written on several lines. Missing blocks are created, and "*"
+// applies to every block or option.
+func (h *Handler) SetPath(tree any, p path.Path, value any) error {
+	segments := p.Segments()
+	if len(segments) == 0 || len(segments) > 2 {
+		return fmt.Errorf("sshconfig paths must have 1 or 2 segments, got %d", len(segments))
+	}
+
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return fmt.Errorf("tree is not an ordered map")
+	}
+
+	value, err := format.NormalizeForFormat(value, "sshconfig")
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %w", p, err)
+	}
+	if _, isBlock := value.(*orderedmap.OrderedMap); isBlock != (len(segments) == 1) {
+		reason := "an option value must be a string or an array of strings"
+		if len(segments) == 1 {
+			reason = "a block value must be a map of options"
+		}
+		return fmt.Errorf("cannot set %s: %w", p, &format.UnsupportedValueError{Format: "sshconfig", Type: fmt.Sprintf("%T", value), Reason: reason})
+	}
+
+	blocks := []string{segments[0]}
+	if segments[0] == path.Wildcard {
+		blocks = om.Keys()
+	}
+
+	for _, name := range blocks {
+		if len(segments) == 1 {
+			om.Set(name, value)
+			continue
+		}
+
+		val, exists := om.Get(name)
+		if !exists {
+			val = orderedmap.New()
+			om.Set(name, val)
+		}
+		options := format.ToOrderedMapPtr(val)
+		if options == nil {
+			return fmt.Errorf("block %q is not a map", name)
+		}
+
+		if segments[1] == path.Wildcard {
+			for _, key := range options.Keys() {
+				options.Set(key, value)
+			}
+			continue
+		}
+		key, ok := findKey(options, segments[1])
+		if !ok {
+			key = segments[1]
+		}
+		options.Set(key, value)
+	}
+
+	return nil
+}
+
+// DeletePath removes a whole block or every line of one option, with "" as
+// the block name for global options. "*" deletes every block or option it
+// matches. Option keywords match ignoring case.
+func (h *Handler) DeletePath(tree any, p path.Path) error {
+	segments := p.Segments()
+	if len(segments) == 0 || len(segments) > 2 {
+		return fmt.Errorf("sshconfig paths must have 1 or 2 segments, got %d", len(segments))
+	}
+
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return fmt.Errorf("tree is not an ordered map")
+	}
+
+	blocks := []string{segments[0]}
+	if segments[0] == path.Wildcard {
+		blocks = om.Keys()
+	}
+
+	for _, name := range blocks {
+		if len(segments) == 1 {
+			om.Delete(name)
+			continue
+		}
+
+		val, _ := om.Get(name)
+		options := format.ToOrderedMapPtr(val)
+		if options == nil {
+			continue
+		}
+		if segments[1] == path.Wildcard {
+			for _, key := range options.Keys() {
+				options.Delete(key)
+			}
+			continue
+		}
+		if key, ok := findKey(options, segments[1]); ok {
+			options.Delete(key)
+		}
+	}
+
+	return nil
+}
+
+// Ensure Handler implements format.Handler.
+var _ format.Handler = (*Handler)(nil)
@@ -0,0 +1,211 @@
+package sshconfig
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/formattest"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+func TestHandler_Parse(t *testing.T) {
+	h := New()
+
+	input := `AddKeysToAgent yes
+
+# Work
+Host github.com gitlab.com
+    User git
+    IdentityFile ~/.ssh/id_work
+    IdentityFile ~/.ssh/id_personal
+
+host *bastion*
+	hostname=bastion.example.com
+	ProxyJump none
+
+Match host *.corp exec "true"
+  ForwardAgent yes
+`
+
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	om := tree.(*orderedmap.OrderedMap)
+	wantBlocks := []string{"", "Host github.com gitlab.com", "Host *bastion*", `Match host *.corp exec "true"`}
+	if !reflect.DeepEqual(om.Keys(), wantBlocks) {
+		t.Fatalf("blocks = %q, want %q", om.Keys(), wantBlocks)
+	}
+
+	tests := []struct {
+		path []string
+		want any
+	}{
+		{[]string{"", "AddKeysToAgent"}, "yes"},
+		{[]string{"Host github.com gitlab.com", "User"}, "git"},
+		{[]string{"Host github.com gitlab.com", "IdentityFile"}, []any{"~/.ssh/id_work", "~/.ssh/id_personal"}},
+		{[]string{"Host *bastion*", "HostName"}, "bastion.example.com"},
+		{[]string{"Host *bastion*", "proxyjump"}, "none"},
+		{[]string{`Match host *.corp exec "true"`, "ForwardAgent"}, "yes"},
+	}
+	for _, tt := range tests {
+		got, ok := h.GetPath(tree, path.NewArrayPath(tt.path))
+		if !ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetPath(%q) = %#v, %v; want %#v", tt.path, got, ok, tt.want)
+		}
+	}
+}
+
+func TestHandler_Parse_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"host without pattern", "Host\n  User git\n"},
+		{"missing keyword", "=value\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New().Parse([]byte(tt.input), format.ParseOptions{}); err == nil {
+				t.Error("Parse() expected error")
+			}
+		})
+	}
+
+	if _, err := New().Parse([]byte("Host a\n"), format.ParseOptions{StripComments: true}); err == nil {
+		t.Error("Parse() with StripComments should return error for sshconfig")
+	}
+}
+
+func TestHandler_RoundTrip_PreservesLayout(t *testing.T) {
+	h := New()
+
+	input := `# ~/.ssh/config
+Include ~/.ssh/config.d/*
+
+Host github.com
+    User git
+    # Two keys, tried in order
+    IdentityFile ~/.ssh/id_work
+    IdentityFile ~/.ssh/id_personal
+
+host *bastion*
+	HostName=bastion.example.com
+	Port = 2222
+
+Host *
+  ServerAliveInterval 60
+# end
+`
+
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if string(data) != input {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, input)
+	}
+}
+
+func TestHandler_ManagedLayoutWins(t *testing.T) {
+	h := New()
+
+	// Managed is parsed first: its block order and indentation apply, and
+	// blocks only in current keep the current file's layout.
+	managed, _ := h.Parse([]byte("Host b\n  User managed\n\nHost a\n  User managed\n"), format.ParseOptions{})
+	current, _ := h.Parse([]byte("Host a\n\tUser current\n\nHost extra\n\tUser x\n\nHost b\n\tUser current\n"), format.ParseOptions{})
+
+	for _, p := range [][]string{{"Host a", "User"}, {"Host extra"}} {
+		v, _ := h.GetPath(current, path.NewArrayPath(p))
+		if err := h.SetPath(managed, path.NewArrayPath(p), v); err != nil {
+			t.Fatalf("SetPath(%q) error = %v", p, err)
+		}
+	}
+
+	data, err := h.Serialize(managed, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	want := "Host b\n  User managed\n\nHost a\n  User current\n\nHost extra\n\tUser x\n"
+	if string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", string(data), want)
+	}
+}
+
+func TestHandler_NewOptionsUseDocumentIndent(t *testing.T) {
+	h := New()
+	tree, err := h.Parse([]byte("Host a\n\tUser git\n"), format.ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"Host a", "LocalForward"}), []any{"8080 localhost:80", "8443 localhost:443"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"Host b", "Port"}), int64(22)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Host a\n\tUser git\n\tLocalForward 8080 localhost:80\n\tLocalForward 8443 localhost:443\n\nHost b\n\tPort 22\n"
+	if string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", string(data), want)
+	}
+}
+
+func TestHandler_SetPath_Errors(t *testing.T) {
+	h := New()
+	tree, _ := h.Parse([]byte("Host a\n  User git\n"), format.ParseOptions{})
+
+	tests := []struct {
+		name  string
+		path  []string
+		value any
+	}{
+		{"too deep", []string{"Host a", "User", "x"}, "x"},
+		{"block needs a map", []string{"Host a"}, "x"},
+		{"option rejects a map", []string{"Host a", "User"}, orderedmap.New()},
+		{"option rejects nested arrays", []string{"Host a", "User"}, []any{[]any{"x"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := h.SetPath(tree, path.NewArrayPath(tt.path), tt.value); err == nil {
+				t.Error("SetPath() expected error")
+			}
+		})
+	}
+}
+
+func TestHandler_Conformance(t *testing.T) {
+	formattest.RunConformance(t, New(), formattest.Fixtures{
+		Document: `Host zebra
+    User z
+
+Host github.com
+    IdentityFile ~/.ssh/id_github
+
+Host apple
+    User a
+`,
+		RoundTripExact:  true,
+		OrderedKeys:     []string{"Host zebra", "Host github.com", "Host apple"},
+		LeafPath:        []string{"Host github.com", "IdentityFile"},
+		LeafValue:       "~/.ssh/id_github",
+		WildcardPath:    []string{"*", "User"},
+		WildcardMatches: [][]string{{"Host zebra", "User"}, {"Host apple", "User"}},
+		DeepPath:        []string{"Host new", "User"},
+	})
+}
@@ -0,0 +1,91 @@
+package format
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+// BoolStyles lists the boolean spellings accepted for
+// SerializeOptions.BoolStyle, each naming its word for true.
+var BoolStyles = []string{"true", "yes", "on"}
+
+// NullStyles lists the accepted SerializeOptions.NullStyle values: "null"
+// writes null values, "omit" drops map entries holding null.
+var NullStyles = []string{"null", "omit"}
+
+// OutputStyles lists the accepted output directive values: "pretty"
+// indents the output, "compact" sets SerializeOptions.Compact.
+var OutputStyles = []string{"pretty", "compact"}
+
+// BoolText returns the word style uses for b: true/false, yes/no, or on/off.
+// An empty or unknown style writes true/false.
+func BoolText(b bool, style string) string {
+	words := map[string][2]string{"yes": {"yes", "no"}, "on": {"on", "off"}}[style]
+	if words == [2]string{} {
+		words = [2]string{"true", "false"}
+	}
+	if b {
+		return words[0]
+	}
+	return words[1]
+}
+
+// ParseIndent returns the SerializeOptions.Indent for an indent directive
+// value: a number of spaces (1 to 16), "tab", or a Go-quoted string of
+// spaces and tabs such as "\"\\t\"".
+func ParseIndent(value string) (string, error) {
+	if value == "tab" {
+		return "\t", nil
+	}
+	if n, err := strconv.Atoi(value); err == nil {
+		if n < 1 || n > 16 {
+			return "", fmt.Errorf("indent width must be between 1 and 16, got %d", n)
+		}
+		return strings.Repeat(" ", n), nil
+	}
+	if unquoted, err := strconv.Unquote(value); err == nil && unquoted != "" && strings.Trim(unquoted, " \t") == "" {
+		return unquoted, nil
+	}
+	return "", fmt.Errorf("indent must be a number of spaces, tab, or a quoted string of spaces and tabs, got %s", value)
+}
+
+// DetectIndent returns the leading whitespace of the first indented line in
+// data, which is one level of indentation in the files the handlers write
+// (JSON, YAML, plist). It returns "" when no line is indented, as in
+// minified JSON.
+func DetectIndent(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		content := strings.TrimLeft(line, " \t")
+		if content != line && strings.TrimSpace(content) != "" {
+			return line[:len(line)-len(content)]
+		}
+	}
+	return ""
+}
+
+// OmitNulls returns a copy of tree without the map entries whose value is
+// nil, at any depth. Nulls in arrays are kept, since dropping them would
+// shift the elements after them.
+func OmitNulls(tree any) any {
+	if om := ToOrderedMapPtr(tree); om != nil {
+		result := orderedmap.New()
+		for _, k := range om.Keys() {
+			v, _ := om.Get(k)
+			if v != nil {
+				result.Set(k, OmitNulls(v))
+			}
+		}
+		return result
+	}
+	if arr, ok := tree.([]any); ok {
+		result := make([]any, len(arr))
+		for i, v := range arr {
+			result[i] = OmitNulls(v)
+		}
+		return result
+	}
+	return tree
+}
@@ -0,0 +1,98 @@
+package format
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+func TestBoolText(t *testing.T) {
+	tests := []struct {
+		style string
+		want  [2]string
+	}{
+		{"", [2]string{"true", "false"}},
+		{"true", [2]string{"true", "false"}},
+		{"yes", [2]string{"yes", "no"}},
+		{"on", [2]string{"on", "off"}},
+	}
+	for _, tt := range tests {
+		if got := [2]string{BoolText(true, tt.style), BoolText(false, tt.style)}; got != tt.want {
+			t.Errorf("BoolText(%q) = %v, want %v", tt.style, got, tt.want)
+		}
+	}
+}
+
+func TestParseIndent(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"2", "  ", false},
+		{"4", "    ", false},
+		{"tab", "\t", false},
+		{`"\t"`, "\t", false},
+		{`"   "`, "   ", false},
+		{"0", "", true},
+		{"17", "", true},
+		{"tabs", "", true},
+		{`""`, "", true},
+		{`"--"`, "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseIndent(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseIndent(%q) = %q, %v, want %q (error %v)", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDetectIndent(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"{\n    \"a\": {\n        \"b\": 1\n    }\n}\n", "    "},
+		{"{\r\n\t\"a\": 1\r\n}\r\n", "\t"},
+		{"a:\n  b: 1\n", "  "},
+		{"{\"a\": 1}", ""},
+		{"{\n   \n\n  \"a\": 1\n}", "  "}, // Blank lines don't count
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := DetectIndent([]byte(tt.data)); got != tt.want {
+			t.Errorf("DetectIndent(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestOmitNulls(t *testing.T) {
+	inner := orderedmap.New()
+	inner.Set("gone", nil)
+	inner.Set("kept", "x")
+	tree := orderedmap.New()
+	tree.Set("a", nil)
+	tree.Set("b", *inner)
+	tree.Set("c", []any{nil, inner})
+
+	got := OmitNulls(tree).(*orderedmap.OrderedMap)
+	if !reflect.DeepEqual(got.Keys(), []string{"b", "c"}) {
+		t.Fatalf("keys = %v, want [b c]", got.Keys())
+	}
+	b, _ := got.Get("b")
+	if keys := ToOrderedMapPtr(b).Keys(); !reflect.DeepEqual(keys, []string{"kept"}) {
+		t.Errorf("b keys = %v, want [kept]", keys)
+	}
+	c, _ := got.Get("c")
+	arr := c.([]any)
+	if len(arr) != 2 || arr[0] != nil || !reflect.DeepEqual(ToOrderedMapPtr(arr[1]).Keys(), []string{"kept"}) {
+		t.Errorf("c = %v, want the null element kept and the map's null dropped", arr)
+	}
+
+	// The input is not modified
+	if _, ok := tree.Get("a"); !ok {
+		t.Error("OmitNulls() modified its input")
+	}
+}
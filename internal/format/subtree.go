@@ -0,0 +1,18 @@
+package format
+
+import (
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// SerializeSubtree writes a document that holds only value at p, in the
+// handler's syntax, so one part of a large config can be shown on its own.
+// Parents of p are created as the handler's SetPath creates them; the handler
+// must use an ordered map as its root.
+func SerializeSubtree(h Handler, p path.Path, value any) ([]byte, error) {
+	tree := orderedmap.New()
+	if err := h.SetPath(tree, p, value); err != nil {
+		return nil, err
+	}
+	return h.Serialize(tree, SerializeOptions{})
+}
@@ -0,0 +1,361 @@
+// Package systemd provides a handler for systemd unit files (.service,
+// .timer, ...) and XDG desktop entries (.desktop) for chezmoi-split.
+package systemd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// entry records how a section header or assignment line was written in a
+// parsed document.
+type entry struct {
+	above []string // Comment and blank lines before the line
+	line  string   // Header lines: the line as written
+	sep   string   // Assignment lines: "=" with the spacing around it
+}
+
+// Handler implements format.Handler for systemd unit files.
+//
+// The tree is an *orderedmap.OrderedMap of sections ("Unit", "Service", ...),
+// each an *orderedmap.OrderedMap of key to value. A key assigned more than
+// once in a section (ExecStartPre=, Environment=, ...) becomes a []any of
+// strings, one per line and in order, so the empty assignment that resets a
+// list is kept in place. Section and key names are case-sensitive, as in
+// systemd.
+//
+// Unlike the INI handler, values are taken literally: quotes and "#" or ";"
+// inside a value are part of it. Lines ending in a backslash continue on the
+// next line and are joined with a space.
+//
+// The handler remembers the comments and "=" spacing of every line from the
+// first document that defines it, so managed lines keep the template's
+// layout and preserved app-only lines keep theirs.
+type Handler struct {
+	format       string // Format name for errors and value normalization
+	continuation bool   // A trailing backslash continues the value
+	entries      map[string]entry
+	footer       []string // Comment lines after the last line of the first document
+	parsed       bool
+}
+
+// New creates a new systemd unit handler.
+func New() *Handler {
+	return &Handler{format: "systemd", continuation: true, entries: make(map[string]entry)}
+}
+
+// NewDesktopEntry creates a handler for XDG desktop entries, which share the
+// unit file syntax but have no line continuations: a value may end in an
+// escaped backslash. Localized keys such as Name[de] are keys of their own.
+func NewDesktopEntry() *Handler {
+	return &Handler{format: "desktop", entries: make(map[string]entry)}
+}
+
+// entryKey returns the entries key for a section header (key "") or for the
+// n-th assignment of a key within a section.
+func entryKey(section, key string, n int) string {
+	if n > 0 {
+		key += "\x00" + strconv.Itoa(n)
+	}
+	return section + "\x00" + key
+}
+
+// Parse reads a unit file and returns an *orderedmap.OrderedMap of sections.
+// A section that appears twice is merged into its first occurrence.
+func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
+	if opts.StripComments {
+		return nil, fmt.Errorf("strip-comments is not supported for %s format", h.format)
+	}
+
+	result := orderedmap.New()
+	record := func(key string, e entry) {
+		if _, seen := h.entries[key]; !seen {
+			h.entries[key] = e
+		}
+	}
+
+	var pending []string
+	var section *orderedmap.OrderedMap
+	sectionName := ""
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNum := i + 1
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" {
+			if i < len(lines)-1 {
+				pending = append(pending, "")
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+			pending = append(pending, trimmed)
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "[") {
+			if !strings.HasSuffix(trimmed, "]") || len(trimmed) < 3 {
+				return nil, fmt.Errorf("failed to parse %s: line %d: invalid section header %q", h.format, lineNum, trimmed)
+			}
+			sectionName = trimmed[1 : len(trimmed)-1]
+			val, exists := result.Get(sectionName)
+			if !exists {
+				val = orderedmap.New()
+				result.Set(sectionName, val)
+			}
+			section = val.(*orderedmap.OrderedMap)
+			record(entryKey(sectionName, "", 0), entry{above: pending, line: trimmed})
+			pending = nil
+			continue
+		}
+
+		key, value, ok := strings.Cut(trimmed, "=")
+		if !ok {
+			return nil, fmt.Errorf("failed to parse %s: line %d: expected Key=Value, got %q", h.format, lineNum, trimmed)
+		}
+		if section == nil {
+			return nil, fmt.Errorf("failed to parse %s: line %d: assignment outside of a section", h.format, lineNum)
+		}
+		sep := key[len(strings.TrimRight(key, " \t")):] + "=" + value[:len(value)-len(strings.TrimLeft(value, " \t"))]
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		// A trailing backslash continues the value on the next line
+		for h.continuation && strings.HasSuffix(value, "\\") && i+1 < len(lines) {
+			i++
+			value = strings.TrimSpace(strings.TrimRight(value[:len(value)-1], " \t") + " " + strings.TrimSpace(lines[i]))
+		}
+
+		n := addValue(section, key, value)
+		record(entryKey(sectionName, key, n), entry{above: pending, sep: sep})
+		pending = nil
+	}
+
+	if !h.parsed {
+		for len(pending) > 0 && pending[len(pending)-1] == "" {
+			pending = pending[:len(pending)-1]
+		}
+		h.footer = pending
+		h.parsed = true
+	}
+
+	return result, nil
+}
+
+// addValue adds an assignment to a section, turning a repeated key into a
+// []any. It returns the line's index among the key's assignments.
+func addValue(section *orderedmap.OrderedMap, key, value string) int {
+	existing, exists := section.Get(key)
+	if !exists {
+		section.Set(key, value)
+		return 0
+	}
+
+	values, ok := existing.([]any)
+	if !ok {
+		values = []any{existing}
+	}
+	section.Set(key, append(values, value))
+	return len(values)
+}
+
+// Serialize writes each section in key order with a blank line between
+// sections, and each key once per value, using the recorded comments and
+// "=" spacing of each line. Keys the handler hasn't seen are written as
+// Key=Value.
+func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, error) {
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return nil, fmt.Errorf("tree is not an ordered map")
+	}
+
+	var sb strings.Builder
+	for _, name := range om.Keys() {
+		val, _ := om.Get(name)
+		section := format.ToOrderedMapPtr(val)
+		if section == nil {
+			return nil, fmt.Errorf("section %q is not a map", name)
+		}
+
+		ent := h.entries[entryKey(name, "", 0)]
+		if sb.Len() > 0 {
+			sb.WriteByte('\n')
+		}
+		writeComments(&sb, ent.above, true)
+		if ent.line != "" {
+			sb.WriteString(ent.line)
+		} else {
+			sb.WriteString("[" + name + "]")
+		}
+		sb.WriteByte('\n')
+
+		for _, key := range section.Keys() {
+			val, _ := section.Get(key)
+			values, ok := val.([]any)
+			if !ok {
+				values = []any{val}
+			}
+			sep := "="
+			if first, seen := h.entries[entryKey(name, key, 0)]; seen {
+				sep = first.sep
+			}
+			for n, v := range values {
+				// Every line of a key uses the first line's spacing
+				writeComments(&sb, h.entries[entryKey(name, key, n)].above, false)
+				sb.WriteString(key + sep + toString(v) + "\n")
+			}
+		}
+	}
+
+	writeComments(&sb, h.footer, false)
+	return []byte(sb.String()), nil
+}
+
+// writeComments writes the lines recorded above an entry. Blank lines before
+// a section header are dropped, since Serialize separates sections itself.
+func writeComments(sb *strings.Builder, lines []string, header bool) {
+	for i, line := range lines {
+		if line == "" && (header || sb.Len() == 0) && i == 0 {
+			continue
+		}
+		if line == "" && i > 0 && lines[i-1] == "" {
+			continue
+		}
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+}
+
+// toString converts any value to its string representation.
+func toString(v any) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// GetPath extracts a value at the given path. Paths are ["Section"] for a
+// whole section or ["Section", "Key"] for one key, whose value is a []any
+// when the key is assigned more than once. "*" matches any section or key
+// and returns the first match.
+func (h *Handler) GetPath(tree any, p path.Path) (any, bool) {
+	segments := p.Segments()
+	if len(segments) == 0 || len(segments) > 2 {
+		return nil, false
+	}
+
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return nil, false
+	}
+
+	sections := []string{segments[0]}
+	if segments[0] == path.Wildcard {
+		sections = om.Keys()
+	}
+
+	for _, name := range sections {
+		val, exists := om.Get(name)
+		if !exists {
+			continue
+		}
+		if len(segments) == 1 {
+			return val, true
+		}
+		section := format.ToOrderedMapPtr(val)
+		if section == nil {
+			continue
+		}
+		if segments[1] == path.Wildcard {
+			for _, key := range section.Keys() {
+				v, _ := section.Get(key)
+				return v, true
+			}
+			continue
+		}
+		if v, ok := section.Get(segments[1]); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// SetPath sets a value at the given path. A whole section must be a map;
+// a key's value is a string, or an array of strings for a key assigned on
+// several lines. Missing sections are created, and "*" applies to every
+// section or key.
+func (h *Handler) SetPath(tree any, p path.Path, value any) error {
+	segments := p.Segments()
+	if len(segments) == 0 || len(segments) > 2 {
+		return fmt.Errorf("%s paths must have 1 or 2 segments, got %d", h.format, len(segments))
+	}
+
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return fmt.Errorf("tree is not an ordered map")
+	}
+
+	value, err := format.NormalizeForFormat(value, h.format)
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %w", p, err)
+	}
+	if _, isSection := value.(*orderedmap.OrderedMap); isSection != (len(segments) == 1) {
+		reason := "a key value must be a string or an array of strings"
+		if len(segments) == 1 {
+			reason = "a section value must be a map"
+		}
+		return fmt.Errorf("cannot set %s: %w", p, &format.UnsupportedValueError{Format: h.format, Type: fmt.Sprintf("%T", value), Reason: reason})
+	}
+
+	sections := []string{segments[0]}
+	if segments[0] == path.Wildcard {
+		sections = om.Keys()
+	}
+
+	for _, name := range sections {
+		if len(segments) == 1 {
+			om.Set(name, value)
+			continue
+		}
+
+		val, exists := om.Get(name)
+		if !exists {
+			val = orderedmap.New()
+			om.Set(name, val)
+		}
+		section := format.ToOrderedMapPtr(val)
+		if section == nil {
+			return fmt.Errorf("section %q is not a map", name)
+		}
+
+		if segments[1] == path.Wildcard {
+			for _, key := range section.Keys() {
+				section.Set(key, value)
+			}
+			continue
+		}
+		section.Set(segments[1], value)
+	}
+
+	return nil
+}
+
+// DeletePath removes a whole section or every line of one key. "*" deletes
+// every section or key it matches.
+func (h *Handler) DeletePath(tree any, p path.Path) error {
+	segments := p.Segments()
+	if len(segments) == 0 || len(segments) > 2 {
+		return fmt.Errorf("%s paths must have 1 or 2 segments, got %d", h.format, len(segments))
+	}
+	return format.DeleteMatches(tree, segments)
+}
+
+// Ensure Handler implements format.Handler.
+var _ format.Handler = (*Handler)(nil)
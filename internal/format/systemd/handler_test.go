@@ -0,0 +1,265 @@
+package systemd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/formattest"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+func TestHandler_Parse(t *testing.T) {
+	h := New()
+
+	input := `[Unit]
+Description=My "quoted" service ; not a comment
+After=network.target
+
+[Service]
+ExecStartPre=/bin/mkdir -p /run/app
+ExecStartPre=/bin/chown app /run/app
+ExecStart=/usr/bin/app \
+    --config /etc/app.conf
+Environment=
+Environment=A=1 "B=two words"
+
+[service]
+User=app
+`
+
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	om := tree.(*orderedmap.OrderedMap)
+	wantSections := []string{"Unit", "Service", "service"}
+	if !reflect.DeepEqual(om.Keys(), wantSections) {
+		t.Fatalf("sections = %q, want %q", om.Keys(), wantSections)
+	}
+
+	tests := []struct {
+		path []string
+		want any
+	}{
+		{[]string{"Unit", "Description"}, `My "quoted" service ; not a comment`},
+		{[]string{"Service", "ExecStartPre"}, []any{"/bin/mkdir -p /run/app", "/bin/chown app /run/app"}},
+		{[]string{"Service", "ExecStart"}, "/usr/bin/app --config /etc/app.conf"},
+		{[]string{"Service", "Environment"}, []any{"", `A=1 "B=two words"`}},
+		{[]string{"service", "User"}, "app"},
+	}
+	for _, tt := range tests {
+		got, ok := h.GetPath(tree, path.NewArrayPath(tt.path))
+		if !ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetPath(%q) = %#v, %v; want %#v", tt.path, got, ok, tt.want)
+		}
+	}
+
+	if _, ok := h.GetPath(tree, path.NewArrayPath([]string{"Service", "user"})); ok {
+		t.Error("GetPath() should match keys case-sensitively")
+	}
+}
+
+func TestHandler_Parse_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"assignment outside section", "Description=x\n"},
+		{"missing equals", "[Unit]\nDescription\n"},
+		{"unterminated header", "[Unit\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New().Parse([]byte(tt.input), format.ParseOptions{}); err == nil {
+				t.Error("Parse() expected error")
+			}
+		})
+	}
+
+	if _, err := New().Parse([]byte("[Unit]\n"), format.ParseOptions{StripComments: true}); err == nil {
+		t.Error("Parse() with StripComments should return error for systemd")
+	}
+}
+
+func TestHandler_RoundTrip_PreservesLayout(t *testing.T) {
+	h := New()
+
+	input := `# /etc/systemd/system/app.service
+[Unit]
+Description=App
+
+[Service]
+; Reset the inherited list first
+Environment=
+Environment=A=1
+Environment=B=2
+Restart = on-failure
+
+[Install]
+WantedBy=multi-user.target
+# end
+`
+
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if string(data) != input {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, input)
+	}
+}
+
+func TestHandler_RepeatedKeysFromCurrent(t *testing.T) {
+	h := New()
+
+	managed, _ := h.Parse([]byte("[Service]\n# Set per machine\nEnvironment=DEFAULT=1\nExecStart=/usr/bin/app\n"), format.ParseOptions{})
+	current, _ := h.Parse([]byte("[Service]\nEnvironment=A=1\nEnvironment=B=2\nEnvironment=C=3\n"), format.ParseOptions{})
+
+	p := path.NewArrayPath([]string{"Service", "Environment"})
+	env, _ := h.GetPath(current, p)
+	if err := h.SetPath(managed, p, env); err != nil {
+		t.Fatalf("SetPath() error = %v", err)
+	}
+
+	data, err := h.Serialize(managed, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := "[Service]\n# Set per machine\nEnvironment=A=1\nEnvironment=B=2\nEnvironment=C=3\nExecStart=/usr/bin/app\n"
+	if string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", string(data), want)
+	}
+}
+
+func TestHandler_SetPath_Errors(t *testing.T) {
+	h := New()
+	tree, _ := h.Parse([]byte("[Service]\nUser=app\n"), format.ParseOptions{})
+
+	tests := []struct {
+		name  string
+		path  []string
+		value any
+	}{
+		{"too deep", []string{"Service", "User", "x"}, "x"},
+		{"section needs a map", []string{"Service"}, "x"},
+		{"key rejects a map", []string{"Service", "User"}, orderedmap.New()},
+		{"key rejects nested arrays", []string{"Service", "User"}, []any{[]any{"x"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := h.SetPath(tree, path.NewArrayPath(tt.path), tt.value); err == nil {
+				t.Error("SetPath() expected error")
+			}
+		})
+	}
+}
+
+func TestHandler_Conformance(t *testing.T) {
+	formattest.RunConformance(t, New(), formattest.Fixtures{
+		Document: `[Unit]
+Description=zebra
+
+[Service]
+ExecStart=/usr/bin/app
+
+[Install]
+WantedBy=default.target
+`,
+		RoundTripExact:  true,
+		OrderedKeys:     []string{"Unit", "Service", "Install"},
+		LeafPath:        []string{"Service", "ExecStart"},
+		LeafValue:       "/usr/bin/app",
+		WildcardPath:    []string{"*", "Description"},
+		WildcardMatches: [][]string{{"Unit", "Description"}},
+		DeepPath:        []string{"Timer", "OnCalendar"},
+	})
+}
+
+func TestDesktopEntry_Parse(t *testing.T) {
+	h := NewDesktopEntry()
+
+	input := `[Desktop Entry]
+Type=Application
+Name=Files
+Name[de]=Dateien
+Exec=nautilus --new-window %U
+Path=C:\\
+X-GNOME-Autostart-enabled=true
+
+[Desktop Action new-window]
+Name=New Window
+`
+
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		path []string
+		want any
+	}{
+		{[]string{"Desktop Entry", "Name"}, "Files"},
+		{[]string{"Desktop Entry", "Name[de]"}, "Dateien"},
+		{[]string{"Desktop Entry", "Path"}, `C:\\`},
+		{[]string{"Desktop Entry", "X-GNOME-Autostart-enabled"}, "true"},
+		{[]string{"Desktop Action new-window", "Name"}, "New Window"},
+	}
+	for _, tt := range tests {
+		got, ok := h.GetPath(tree, path.NewArrayPath(tt.path))
+		if !ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetPath(%q) = %#v, %v; want %#v", tt.path, got, ok, tt.want)
+		}
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if string(data) != input {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, input)
+	}
+}
+
+func TestDesktopEntry_Errors(t *testing.T) {
+	_, err := NewDesktopEntry().Parse([]byte("Name=x\n"), format.ParseOptions{})
+	if err == nil || !strings.Contains(err.Error(), "failed to parse desktop") {
+		t.Errorf("Parse() error = %v, want a desktop parse error", err)
+	}
+
+	tree, _ := NewDesktopEntry().Parse([]byte("[Desktop Entry]\nName=x\n"), format.ParseOptions{})
+	err = NewDesktopEntry().SetPath(tree, path.NewArrayPath([]string{"Desktop Entry", "Name"}), orderedmap.New())
+	if err == nil || !strings.Contains(err.Error(), "desktop") {
+		t.Errorf("SetPath() error = %v, want a desktop value error", err)
+	}
+}
+
+func TestDesktopEntry_Conformance(t *testing.T) {
+	formattest.RunConformance(t, NewDesktopEntry(), formattest.Fixtures{
+		Document: `[Desktop Entry]
+Name=zebra
+Name[de]=Zebra
+Exec=app %U
+
+[Desktop Action apple]
+Name=apple
+`,
+		RoundTripExact:  true,
+		OrderedKeys:     []string{"Desktop Entry", "Desktop Action apple"},
+		LeafPath:        []string{"Desktop Entry", "Name[de]"},
+		LeafValue:       "Zebra",
+		WildcardPath:    []string{"*", "Name"},
+		WildcardMatches: [][]string{{"Desktop Entry", "Name"}, {"Desktop Action apple", "Name"}},
+		DeepPath:        []string{"Desktop Action new", "Exec"},
+	})
+}
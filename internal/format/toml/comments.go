@@ -0,0 +1,265 @@
+package toml
+
+import (
+	"strconv"
+	"strings"
+)
+
+// entry records how a key or table header was written in a parsed document.
+type entry struct {
+	above    []string // Comment and blank lines before the entry (runs of blanks collapsed)
+	trailing string   // Comment after the entry on the same line, including the "#"
+	inline   bool     // Value was an inline table or array, not a [table] or [[table]]
+}
+
+// entryKey joins the segments of a tree location for use as a map key.
+// Elements of arrays of tables are addressed by their index.
+func entryKey(keys []string) string {
+	return strings.Join(keys, "\x00")
+}
+
+// scanComments walks a document that has already been decoded successfully
+// and returns an entry for every key and table header, keyed by tree
+// location, plus the comment lines after the last entry.
+func scanComments(data string) (map[string]entry, []string) {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	entries := make(map[string]entry)
+	tableCounts := make(map[string]int) // Elements seen so far in each array of tables
+	var table []string                  // Location of the current table
+	var pending []string                // Comment and blank lines since the last entry
+
+	record := func(keys []string, e entry) {
+		e.above = collapseBlanks(pending)
+		pending = nil
+		entries[entryKey(keys)] = e
+	}
+
+	i := 0
+	for i < len(data) {
+		i = skipSpace(data, i)
+		lineEnd := strings.IndexByte(data[i:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(data)
+		} else {
+			lineEnd += i
+		}
+
+		switch {
+		case i == lineEnd:
+			pending = append(pending, "")
+
+		case data[i] == '#':
+			pending = append(pending, strings.TrimRight(data[i:lineEnd], " \t"))
+
+		case data[i] == '[':
+			isArray := strings.HasPrefix(data[i:], "[[")
+			start := i + 1
+			if isArray {
+				start++
+			}
+			names, end := scanKey(data, start)
+			end = skipSpace(data, end) + 1
+			if isArray {
+				end++
+			}
+
+			table = resolveTable(names, tableCounts, isArray)
+			record(table, entry{trailing: trailingComment(data[end:lineEnd])})
+
+		default:
+			names, end := scanKey(data, i)
+			end = skipSpace(data, skipSpace(data, end)+1) // Past "="
+			inline := end < len(data) && (data[end] == '{' || data[end] == '[')
+			end = skipValue(data, end)
+			lineEnd = strings.IndexByte(data[end:], '\n')
+			if lineEnd < 0 {
+				lineEnd = len(data)
+			} else {
+				lineEnd += end
+			}
+
+			keys := append(append([]string{}, table...), names...)
+			record(keys, entry{trailing: trailingComment(data[end:lineEnd]), inline: inline})
+		}
+
+		i = lineEnd + 1
+	}
+
+	return entries, collapseBlanks(trimTrailingBlanks(pending))
+}
+
+// resolveTable returns the tree location of a [table] or [[table]] header.
+// Parents that are arrays of tables resolve to their latest element, and a
+// [[table]] header starts a new element.
+func resolveTable(names []string, tableCounts map[string]int, isArray bool) []string {
+	var resolved []string
+	for i, name := range names {
+		resolved = append(resolved, name)
+		n, ok := tableCounts[entryKey(resolved)]
+		if i == len(names)-1 && isArray {
+			tableCounts[entryKey(resolved)] = n + 1
+			return append(resolved, strconv.Itoa(n))
+		}
+		if ok {
+			resolved = append(resolved, strconv.Itoa(n-1))
+		}
+	}
+	return resolved
+}
+
+// scanKey reads a possibly dotted key starting at i and returns its
+// segments and the index just past it.
+func scanKey(data string, i int) ([]string, int) {
+	var names []string
+	for {
+		i = skipSpace(data, i)
+		if i >= len(data) {
+			return names, i
+		}
+
+		switch data[i] {
+		case '"':
+			end := i + 1
+			for end < len(data) && data[end] != '"' {
+				if data[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			name, err := strconv.Unquote(data[i : end+1])
+			if err != nil {
+				name = data[i+1 : end]
+			}
+			names = append(names, name)
+			i = end + 1
+		case '\'':
+			end := i + 1 + strings.IndexByte(data[i+1:], '\'')
+			names = append(names, data[i+1:end])
+			i = end + 1
+		default:
+			end := i
+			for end < len(data) && isBareKeyChar(data[end]) {
+				end++
+			}
+			names = append(names, data[i:end])
+			i = end
+		}
+
+		i = skipSpace(data, i)
+		if i >= len(data) || data[i] != '.' {
+			return names, i
+		}
+		i++
+	}
+}
+
+// skipValue returns the index just past the value starting at i, which may
+// span lines when it contains multi-line strings or arrays.
+func skipValue(data string, i int) int {
+	depth := 0
+	for i < len(data) {
+		switch c := data[i]; {
+		case strings.HasPrefix(data[i:], `"""`):
+			i = skipMultiline(data, i, `"""`)
+		case strings.HasPrefix(data[i:], `'''`):
+			i = skipMultiline(data, i, `'''`)
+		case c == '"':
+			i++
+			for i < len(data) && data[i] != '"' {
+				if data[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+		case c == '\'':
+			i += 2 + strings.IndexByte(data[i+1:], '\'')
+		case c == '[' || c == '{':
+			depth++
+			i++
+		case c == ']' || c == '}':
+			depth--
+			i++
+		case c == '#' || c == '\n':
+			if depth == 0 {
+				return i
+			}
+			// Comments inside a multi-line array are dropped
+			if c == '#' {
+				if end := strings.IndexByte(data[i:], '\n'); end >= 0 {
+					i += end
+					continue
+				}
+				return len(data)
+			}
+			i++
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+// skipMultiline returns the index just past a multi-line string starting at
+// i. Up to two extra quotes before the closing delimiter belong to the string.
+func skipMultiline(data string, i int, delim string) int {
+	i += len(delim)
+	for i < len(data) {
+		if delim == `"""` && data[i] == '\\' {
+			i += 2
+			continue
+		}
+		if strings.HasPrefix(data[i:], delim) {
+			i += len(delim)
+			for extra := 0; extra < 2 && i < len(data) && data[i] == delim[0]; extra++ {
+				i++
+			}
+			return i
+		}
+		i++
+	}
+	return i
+}
+
+// trailingComment returns the comment in the rest of a line, if any.
+func trailingComment(rest string) string {
+	rest = strings.TrimSpace(rest)
+	if strings.HasPrefix(rest, "#") {
+		return rest
+	}
+	return ""
+}
+
+// collapseBlanks squeezes each run of blank lines into one. A leading blank
+// line is kept to mark a gap before the entry.
+func collapseBlanks(lines []string) []string {
+	var result []string
+	for i, line := range lines {
+		if line == "" && i > 0 && lines[i-1] == "" {
+			continue
+		}
+		result = append(result, line)
+	}
+	return result
+}
+
+// trimTrailingBlanks drops blank lines at the end of lines.
+func trimTrailingBlanks(lines []string) []string {
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// skipSpace returns the index of the first non-space, non-tab byte at or after i.
+func skipSpace(data string, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+// isBareKeyChar reports whether c may appear in an unquoted key.
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
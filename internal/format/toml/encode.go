@@ -0,0 +1,270 @@
+package toml
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+)
+
+// bareKeyRegex matches keys that can be written without quotes.
+var bareKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// encoder writes an ordered tree as TOML in key order, placing the comments
+// recorded for each key and table header.
+type encoder struct {
+	sb      strings.Builder
+	entries map[string]entry
+}
+
+// table writes the body of the table at names, preceded by its header when
+// names is non-empty. keys is the table's tree location, which differs from
+// names by the element indexes of arrays of tables.
+func (e *encoder) table(names, keys []string, om *orderedmap.OrderedMap, isArray bool) error {
+	var values, tables []string
+	for _, k := range om.Keys() {
+		val, _ := om.Get(k)
+		if e.isTable(child(keys, k), val) {
+			tables = append(tables, k)
+		} else {
+			values = append(values, k)
+		}
+	}
+
+	// A table holding only other tables needs no header of its own, unless
+	// the document had one
+	ent, declared := e.entries[entryKey(keys)]
+	if len(names) > 0 && (isArray || declared || len(values) > 0 || len(tables) == 0) {
+		if e.sb.Len() > 0 {
+			e.blankLine()
+		}
+		e.comments(ent.above, true)
+		open, closing := "[", "]"
+		if isArray {
+			open, closing = "[[", "]]"
+		}
+		e.sb.WriteString(open + formatKey(names) + closing)
+		e.endLine(ent.trailing)
+	}
+
+	for _, k := range values {
+		val, _ := om.Get(k)
+		s, err := formatValue(val)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.Join(child(names, k), "."), err)
+		}
+		ent := e.entries[entryKey(child(keys, k))]
+		e.comments(ent.above, false)
+		e.sb.WriteString(formatKey([]string{k}) + " = " + s)
+		e.endLine(ent.trailing)
+	}
+
+	for _, k := range tables {
+		val, _ := om.Get(k)
+		childNames := child(names, k)
+		childKeys := child(keys, k)
+		if sub := format.ToOrderedMapPtr(val); sub != nil {
+			if err := e.table(childNames, childKeys, sub, false); err != nil {
+				return err
+			}
+			continue
+		}
+		for i, elem := range val.([]any) {
+			elemKeys := child(childKeys, strconv.Itoa(i))
+			if err := e.table(childNames, elemKeys, format.ToOrderedMapPtr(elem), true); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// child returns a copy of keys extended by key.
+func child(keys []string, key string) []string {
+	return append(append([]string{}, keys...), key)
+}
+
+// isTable reports whether the value at keys is written as a [table] or an
+// array of [[tables]] rather than as key = value. Maps and non-empty arrays
+// of maps are, unless the document wrote them inline.
+func (e *encoder) isTable(keys []string, val any) bool {
+	if e.entries[entryKey(keys)].inline {
+		return false
+	}
+	if format.ToOrderedMapPtr(val) != nil {
+		return true
+	}
+	arr, ok := val.([]any)
+	if !ok || len(arr) == 0 {
+		return false
+	}
+	for _, elem := range arr {
+		if format.ToOrderedMapPtr(elem) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// comments writes the lines recorded above an entry. A leading blank line is
+// dropped at the start of the document and before headers, which always get one.
+func (e *encoder) comments(lines []string, header bool) {
+	for i, line := range lines {
+		if line == "" && i == 0 && (header || e.sb.Len() == 0 || strings.HasSuffix(e.sb.String(), "\n\n")) {
+			continue
+		}
+		e.sb.WriteString(line)
+		e.sb.WriteByte('\n')
+	}
+}
+
+// endLine finishes the current line with an optional trailing comment.
+func (e *encoder) endLine(trailing string) {
+	if trailing != "" {
+		e.sb.WriteString(" " + trailing)
+	}
+	e.sb.WriteByte('\n')
+}
+
+// blankLine ensures the output ends with an empty line.
+func (e *encoder) blankLine() {
+	if !strings.HasSuffix(e.sb.String(), "\n\n") {
+		e.sb.WriteByte('\n')
+	}
+}
+
+// formatKey writes a dotted key, quoting segments that aren't bare keys.
+func formatKey(names []string) string {
+	parts := make([]string, len(names))
+	for i, name := range names {
+		if bareKeyRegex.MatchString(name) {
+			parts[i] = name
+		} else {
+			parts[i] = quoteString(name)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// formatValue writes a value in inline form.
+func formatValue(v any) (string, error) {
+	if om := format.ToOrderedMapPtr(v); om != nil {
+		if len(om.Keys()) == 0 {
+			return "{}", nil
+		}
+		parts := make([]string, 0, len(om.Keys()))
+		for _, k := range om.Keys() {
+			val, _ := om.Get(k)
+			s, err := formatValue(val)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, formatKey([]string{k})+" = "+s)
+		}
+		return "{ " + strings.Join(parts, ", ") + " }", nil
+	}
+
+	switch val := v.(type) {
+	case string:
+		return quoteString(val), nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(val), nil
+	case float32:
+		return formatFloat(float64(val)), nil
+	case float64:
+		return formatFloat(val), nil
+	case time.Time:
+		return formatTime(val), nil
+	case []any:
+		parts := make([]string, len(val))
+		for i, elem := range val {
+			s, err := formatValue(elem)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = s
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	case nil:
+		return "", fmt.Errorf("TOML cannot represent null")
+	default:
+		return "", fmt.Errorf("unsupported type %T", v)
+	}
+}
+
+// quoteString writes s as a basic string.
+func quoteString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\r':
+			sb.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&sb, `\u%04X`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// formatFloat writes a float so that it reads back as a float.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+
+	abs := math.Abs(f)
+	if abs != 0 && (abs < 1e-4 || abs >= 1e21) {
+		return strconv.FormatFloat(f, 'e', -1, 64)
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// formatTime writes a datetime, keeping the local date, time, and datetime
+// kinds the decoder marks with named zones.
+func formatTime(t time.Time) string {
+	switch t.Location().String() {
+	case "datetime-local":
+		return t.Format("2006-01-02T15:04:05.999999999")
+	case "date-local":
+		return t.Format("2006-01-02")
+	case "time-local":
+		return t.Format("15:04:05.999999999")
+	default:
+		return t.Format(time.RFC3339Nano)
+	}
+}
@@ -3,8 +3,9 @@ package toml
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"strings"
+	"slices"
 
 	"github.com/BurntSushi/toml"
 	"github.com/iancoleman/orderedmap"
@@ -13,11 +14,20 @@ import (
 )
 
 // Handler implements format.Handler for TOML files.
-type Handler struct{}
+//
+// The handler records the comments around each key and table header, and
+// whether a table or array was written inline, from the first document that
+// defines it. Parsing managed before current means managed keys keep the
+// template's comments and preserved app-only keys keep theirs.
+type Handler struct {
+	entries map[string]entry
+	footer  []string // Comments after the last entry of the first document
+	parsed  bool
+}
 
 // New creates a new TOML handler.
 func New() *Handler {
-	return &Handler{}
+	return &Handler{entries: make(map[string]entry)}
 }
 
 // Parse reads TOML bytes and returns an *orderedmap.OrderedMap.
@@ -34,38 +44,57 @@ func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
 		return nil, fmt.Errorf("failed to parse TOML: %w", err)
 	}
 
+	entries, footer := scanComments(string(data))
+	for k, e := range entries {
+		if _, seen := h.entries[k]; !seen {
+			h.entries[k] = e
+		}
+	}
+	if !h.parsed {
+		h.footer = footer
+		h.parsed = true
+	}
+
 	// Convert to ordered map using metadata for key order
-	return convertToOrderedMapWithMeta(raw, meta, nil), nil
+	return convertToOrderedMapWithMeta(raw, meta.Keys(), nil), nil
 }
 
 // convertToOrderedMapWithMeta recursively converts map[string]any to *orderedmap.OrderedMap
-// using TOML metadata to preserve key order.
-func convertToOrderedMapWithMeta(v any, meta toml.MetaData, prefix []string) any {
+// using TOML metadata to preserve key order. keys is the part of the
+// metadata that describes v: the whole document, or one array element.
+func convertToOrderedMapWithMeta(v any, keys []toml.Key, prefix []string) any {
 	switch val := v.(type) {
 	case map[string]any:
 		result := orderedmap.New()
 
-		// Get keys in document order from metadata
-		keys := getKeysInOrder(meta, prefix, val)
-
-		for _, k := range keys {
-			childVal := val[k]
-			childPrefix := append(prefix, k)
-			result.Set(k, convertToOrderedMapWithMeta(childVal, meta, childPrefix))
+		for _, k := range getKeysInOrder(keys, prefix, val) {
+			childPrefix := append(append([]string{}, prefix...), k)
+			result.Set(k, convertToOrderedMapWithMeta(val[k], keys, childPrefix))
 		}
 		return result
 	case []map[string]any:
 		// Array of tables
+		sizes := make([]int, len(val))
+		for i, item := range val {
+			sizes[i] = len(item)
+		}
+		windows := elementKeys(keys, prefix, sizes)
 		result := make([]any, len(val))
 		for i, item := range val {
-			// For array items, we use index in prefix for nested lookups
-			result[i] = convertToOrderedMapWithMeta(item, meta, prefix)
+			result[i] = convertToOrderedMapWithMeta(item, windows[i], prefix)
 		}
 		return result
 	case []any:
+		sizes := make([]int, len(val))
+		for i, item := range val {
+			if m, ok := item.(map[string]any); ok {
+				sizes[i] = len(m)
+			}
+		}
+		windows := elementKeys(keys, prefix, sizes)
 		result := make([]any, len(val))
 		for i, item := range val {
-			result[i] = convertToOrderedMapWithMeta(item, meta, prefix)
+			result[i] = convertToOrderedMapWithMeta(item, windows[i], prefix)
 		}
 		return result
 	default:
@@ -73,34 +102,76 @@ func convertToOrderedMapWithMeta(v any, meta toml.MetaData, prefix []string) any
 	}
 }
 
-// getKeysInOrder returns map keys in document order using TOML metadata.
-func getKeysInOrder(meta toml.MetaData, prefix []string, m map[string]any) []string {
-	// Build a set of keys we need to find
-	needed := make(map[string]bool)
-	for k := range m {
-		needed[k] = true
+// elementKeys splits the metadata keys of the array at prefix into one
+// window per element, so each element's keys are ordered by its own
+// definition. sizes holds the number of keys in each element.
+func elementKeys(keys []toml.Key, prefix []string, sizes []int) [][]toml.Key {
+	windows := make([][]toml.Key, len(sizes))
+
+	// Each [[table]] element starts with its own header key
+	var headers []int
+	for i, key := range keys {
+		if len(key) == len(prefix) && matchesPrefix(key, prefix) {
+			headers = append(headers, i)
+		}
+	}
+	if len(headers) == len(sizes) {
+		for i, start := range headers {
+			end := len(keys)
+			if i+1 < len(headers) {
+				end = headers[i+1]
+			}
+			windows[i] = keys[start+1 : end]
+		}
+		return windows
 	}
 
-	// Get keys in order from metadata
+	// Inline tables share one header, and their keys follow each other:
+	// an element ends when it has all its keys and the next one starts
+	pos := 0
+	for i, size := range sizes {
+		start := pos
+		seen := make(map[string]bool)
+		for pos < len(keys) {
+			key := keys[pos]
+			if len(key) == len(prefix)+1 && matchesPrefix(key, prefix) {
+				if len(seen) == size {
+					break
+				}
+				seen[key[len(prefix)]] = true
+			}
+			pos++
+		}
+		windows[i] = keys[start:pos]
+	}
+	return windows
+}
+
+// getKeysInOrder returns map keys in document order using TOML metadata.
+func getKeysInOrder(keys []toml.Key, prefix []string, m map[string]any) []string {
 	var ordered []string
-	for _, key := range meta.Keys() {
-		// Check if this key matches our prefix + one more segment
-		if len(key) == len(prefix)+1 && matchesPrefix(key, prefix) {
+	for _, key := range keys {
+		// Check if this key is below our prefix; implicit tables such as
+		// "a" in [a.b] only appear as part of longer keys
+		if len(key) > len(prefix) && matchesPrefix(key, prefix) {
 			k := key[len(prefix)]
-			if needed[k] && !contains(ordered, k) {
+			if _, ok := m[k]; ok && !slices.Contains(ordered, k) {
 				ordered = append(ordered, k)
 			}
 		}
 	}
 
-	// Add any keys not found in metadata (shouldn't happen, but be safe)
-	for k := range needed {
-		if !contains(ordered, k) {
-			ordered = append(ordered, k)
+	// Keys missing from the metadata (shouldn't happen) go last, sorted so
+	// the output is stable
+	var missing []string
+	for k := range m {
+		if !slices.Contains(ordered, k) {
+			missing = append(missing, k)
 		}
 	}
+	slices.Sort(missing)
 
-	return ordered
+	return append(ordered, missing...)
 }
 
 // matchesPrefix checks if key starts with prefix.
@@ -116,60 +187,29 @@ func matchesPrefix(key toml.Key, prefix []string) bool {
 	return true
 }
 
-// contains checks if slice contains string.
-func contains(slice []string, s string) bool {
-	for _, item := range slice {
-		if item == s {
-			return true
-		}
-	}
-	return false
-}
-
-// Serialize writes the tree to formatted TOML bytes.
+// Serialize writes the tree as TOML in key order, with the comments
+// recorded for each key and table header. Values come first in each table,
+// followed by its sub-tables and arrays of tables.
 func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, error) {
-	// Convert ordered map to regular map for TOML encoding
-	regular := convertToRegularMap(tree)
+	om := format.ToOrderedMapPtr(tree)
+	if om == nil {
+		return nil, fmt.Errorf("tree is not an ordered map")
+	}
 
-	var buf bytes.Buffer
-	encoder := toml.NewEncoder(&buf)
-	if err := encoder.Encode(regular); err != nil {
+	e := &encoder{entries: h.entries}
+	if err := e.table(nil, nil, om, false); err != nil {
 		return nil, fmt.Errorf("failed to serialize TOML: %w", err)
 	}
-
-	return buf.Bytes(), nil
-}
-
-// convertToRegularMap recursively converts *orderedmap.OrderedMap to map[string]any.
-// Note: This loses key order, but BurntSushi/toml encoder sorts keys alphabetically anyway.
-func convertToRegularMap(v any) any {
-	switch val := v.(type) {
-	case *orderedmap.OrderedMap:
-		result := make(map[string]any)
-		for _, k := range val.Keys() {
-			v, _ := val.Get(k)
-			result[k] = convertToRegularMap(v)
-		}
-		return result
-	case orderedmap.OrderedMap:
-		result := make(map[string]any)
-		for _, k := range val.Keys() {
-			v, _ := val.Get(k)
-			result[k] = convertToRegularMap(v)
-		}
-		return result
-	case []any:
-		result := make([]any, len(val))
-		for i, v := range val {
-			result[i] = convertToRegularMap(v)
-		}
-		return result
-	default:
-		return val
+	if len(h.footer) > 0 {
+		e.comments(h.footer, false)
 	}
+
+	return []byte(e.sb.String()), nil
 }
 
 // GetPath extracts a value at the given path, supporting wildcards.
+// With "**", the first match in document order is returned. A numeric
+// segment indexes into an array; an out-of-range index matches nothing.
 func (h *Handler) GetPath(tree any, p path.Path) (any, bool) {
 	return getPathWithWildcard(tree, p.Segments(), 0)
 }
@@ -181,11 +221,42 @@ func getPathWithWildcard(current any, segments []string, idx int) (any, bool) {
 	}
 
 	segment := segments[idx]
+
+	// A numeric segment indexes into an array. "**" only descends through
+	// maps, so at an array it matches zero levels.
+	if arr, ok := current.([]any); ok {
+		if segment == path.RecursiveWildcard && idx < len(segments)-1 {
+			return getPathWithWildcard(arr, segments, idx+1)
+		}
+		i, ok := format.ArrayIndex(segment, len(arr))
+		if !ok {
+			return nil, false
+		}
+		return getPathWithWildcard(arr[i], segments, idx+1)
+	}
+
 	om := format.ToOrderedMapPtr(current)
 	if om == nil {
 		return nil, false
 	}
 
+	if segment == path.RecursiveWildcard {
+		// Recursive wildcard: match at this level first, then at any depth below
+		if idx == len(segments)-1 {
+			return nil, false
+		}
+		if result, ok := getPathWithWildcard(om, segments, idx+1); ok {
+			return result, true
+		}
+		for _, key := range om.Keys() {
+			val, _ := om.Get(key)
+			if result, ok := getPathWithWildcard(val, segments, idx); ok {
+				return result, true
+			}
+		}
+		return nil, false
+	}
+
 	if segment == "*" {
 		// Wildcard: return first match from any key
 		for _, key := range om.Keys() {
@@ -206,13 +277,19 @@ func getPathWithWildcard(current any, segments []string, idx int) (any, bool) {
 
 
 // SetPath sets a value at the given path, supporting wildcards.
-// Creates intermediate maps as needed.
+// With "**", every existing match is set.
+// Creates intermediate maps as needed; array indexes must already exist.
 func (h *Handler) SetPath(tree any, p path.Path, value any) error {
 	segments := p.Segments()
 	if len(segments) == 0 {
 		return fmt.Errorf("empty path")
 	}
 
+	value, err := format.NormalizeForFormat(value, "toml")
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %w", p, err)
+	}
+
 	return setPathWithWildcard(tree, segments, 0, value)
 }
 
@@ -222,13 +299,41 @@ func setPathWithWildcard(current any, segments []string, idx int, value any) err
 		return nil
 	}
 
+	segment := segments[idx]
+	isLast := idx == len(segments)-1
+
+	// A numeric segment indexes into an existing array element
+	if arr, ok := current.([]any); ok {
+		if segment == path.RecursiveWildcard {
+			for _, keys := range format.ExpandPath(arr, segments[idx:]) {
+				_ = setPathWithWildcard(arr, keys, 0, value)
+			}
+			return nil
+		}
+		i, ok := format.ArrayIndex(segment, len(arr))
+		if !ok {
+			return fmt.Errorf("array index %q out of range (length %d)", segment, len(arr))
+		}
+		if isLast {
+			arr[i] = value
+			return nil
+		}
+		return setPathWithWildcard(arr[i], segments, idx+1, value)
+	}
+
 	om := format.ToOrderedMapPtr(current)
 	if om == nil {
 		return fmt.Errorf("cannot navigate into non-map value")
 	}
 
-	segment := segments[idx]
-	isLast := idx == len(segments)-1
+	if segment == path.RecursiveWildcard {
+		// Recursive wildcard: apply to every existing match, never create keys
+		for _, keys := range format.ExpandPath(om, segments[idx:]) {
+			// Matches already exist, so setting them can't fail
+			_ = setPathWithWildcard(om, keys, 0, value)
+		}
+		return nil
+	}
 
 	if segment == "*" {
 		// Wildcard: apply to all keys
@@ -258,26 +363,40 @@ func setPathWithWildcard(current any, segments []string, idx int, value any) err
 		om.Set(segment, next)
 	}
 
+	if _, isArr := next.([]any); isArr {
+		return setPathWithWildcard(next, segments, idx+1, value)
+	}
 	nextMap := format.ToOrderedMapPtr(next)
 	if nextMap == nil {
-		return fmt.Errorf("path segment %q is not a map", segment)
+		return fmt.Errorf("path segment %q is not a map or array", segment)
 	}
 
 	return setPathWithWildcard(nextMap, segments, idx+1, value)
 }
 
-// FormatError returns a detailed error message for TOML parse errors.
-func FormatError(content string, err error) error {
-	// BurntSushi/toml errors include line numbers in the message
-	// Extract and format them nicely
-	errStr := err.Error()
-
-	// Try to find line number in error message (format: "line X:")
-	if strings.Contains(errStr, "line ") {
-		return fmt.Errorf("TOML parse error: %w", err)
+// ErrorPosition returns the 1-based line and column of data that a TOML
+// parse error points at, or 0, 0 if err doesn't carry a position. The
+// position is taken from the error's byte offset: the parser's own line
+// number is one too high for an error at the end of a line, such as an
+// unclosed [table.
+func ErrorPosition(data []byte, err error) (line, col int) {
+	var parseErr toml.ParseError
+	if !errors.As(err, &parseErr) {
+		return 0, 0
 	}
+	start := min(max(parseErr.Position.Start, 0), len(data))
+	lineStart := bytes.LastIndexByte(data[:start], '\n') + 1
+	return bytes.Count(data[:lineStart], []byte("\n")) + 1, start - lineStart + 1
+}
 
-	return fmt.Errorf("failed to parse TOML: %w", err)
+// DeletePath removes the value at the given path. Wildcards delete every
+// match, and an array element is removed from its array.
+func (h *Handler) DeletePath(tree any, p path.Path) error {
+	segments := p.Segments()
+	if len(segments) == 0 {
+		return fmt.Errorf("empty path")
+	}
+	return format.DeleteMatches(tree, segments)
 }
 
 // Ensure Handler implements format.Handler.
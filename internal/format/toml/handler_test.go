@@ -1,10 +1,13 @@
 package toml
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/iancoleman/orderedmap"
 	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/formattest"
 	"github.com/thirteen37/chezmoi-split/internal/path"
 )
 
@@ -290,6 +293,155 @@ func TestHandler_SetPath_Wildcard(t *testing.T) {
 	}
 }
 
+func TestHandler_ArrayIndex(t *testing.T) {
+	h := New()
+
+	tree, err := h.Parse([]byte(`ports = [80, 443]
+
+[[profiles]]
+name = "work"
+
+[[profiles]]
+name = "home"
+`), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if got, ok := h.GetPath(tree, path.NewArrayPath([]string{"profiles", "1", "name"})); !ok || got != "home" {
+		t.Errorf("GetPath(profiles.1.name) = %v, %v, want home", got, ok)
+	}
+	if got, ok := h.GetPath(tree, path.NewArrayPath([]string{"ports", "0"})); !ok || got != int64(80) {
+		t.Errorf("GetPath(ports.0) = %v, %v, want 80", got, ok)
+	}
+	if _, ok := h.GetPath(tree, path.NewArrayPath([]string{"profiles", "2", "name"})); ok {
+		t.Error("GetPath() with an out-of-range index should not find a value")
+	}
+
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"profiles", "0", "name"}), "office"); err != nil {
+		t.Fatalf("SetPath() error = %v", err)
+	}
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"profiles", "2", "name"}), "x"); err == nil {
+		t.Error("SetPath() with an out-of-range index should return an error")
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := `ports = [80, 443]
+
+[[profiles]]
+name = "office"
+
+[[profiles]]
+name = "home"
+`
+	if string(data) != want {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestHandler_GetPath_RecursiveWildcard(t *testing.T) {
+	h := New()
+
+	tree, err := h.Parse([]byte(`[editor]
+font = "mono"
+
+[editor.git]
+telemetry = false
+
+[extensions.python]
+telemetry = true
+`), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	// First match in document order
+	got, found := h.GetPath(tree, path.NewArrayPath([]string{"**", "telemetry"}))
+	if !found {
+		t.Fatal("GetPath() with ** should find a match")
+	}
+	if got != false {
+		t.Errorf("GetPath() = %v, want false (editor.git)", got)
+	}
+
+	if _, found := h.GetPath(tree, path.NewArrayPath([]string{"**", "missing"})); found {
+		t.Error("GetPath() with ** should not find a missing key")
+	}
+	if _, found := h.GetPath(tree, path.NewArrayPath([]string{"editor", "**"})); found {
+		t.Error("GetPath() with trailing ** should not match")
+	}
+}
+
+func TestHandler_SetPath_RecursiveWildcard(t *testing.T) {
+	h := New()
+
+	tree, err := h.Parse([]byte(`telemetry = 1
+
+[editor]
+telemetry = 2
+
+[editor.git]
+telemetry = 3
+`), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"**", "telemetry"}), "off"); err != nil {
+		t.Fatalf("SetPath() error = %v", err)
+	}
+
+	// Every match at every depth is set
+	for _, segs := range [][]string{{"telemetry"}, {"editor", "telemetry"}, {"editor", "git", "telemetry"}} {
+		got, _ := h.GetPath(tree, path.NewArrayPath(segs))
+		if got != "off" {
+			t.Errorf("%v = %v, want off", segs, got)
+		}
+	}
+
+	// No new keys are created where nothing matched
+	if err := h.SetPath(tree, path.NewArrayPath([]string{"**", "missing"}), "x"); err != nil {
+		t.Fatalf("SetPath() error = %v", err)
+	}
+	if _, found := h.GetPath(tree, path.NewArrayPath([]string{"missing"})); found {
+		t.Error("SetPath() with ** should not create keys")
+	}
+}
+
+func TestHandler_SetPath_UnsupportedValue(t *testing.T) {
+	h := New()
+	tree := orderedmap.New()
+	tree.Set("key", "value")
+
+	p := path.NewArrayPath([]string{"key"})
+	err := h.SetPath(tree, p, []any{"a", nil})
+	if err == nil {
+		t.Fatal("SetPath() should reject a null array element")
+	}
+	var uerr *format.UnsupportedValueError
+	if !errors.As(err, &uerr) {
+		t.Fatalf("SetPath() error = %v, want *format.UnsupportedValueError", err)
+	}
+	if !strings.Contains(err.Error(), `["key"]`) {
+		t.Errorf("SetPath() error = %q, want it to name the path", err)
+	}
+	if got, _ := tree.Get("key"); got != "value" {
+		t.Errorf("tree changed to %v after a rejected SetPath()", got)
+	}
+
+	// Convertible values are normalized rather than rejected
+	if err := h.SetPath(tree, p, []int{1, 2}); err != nil {
+		t.Fatalf("SetPath() error = %v", err)
+	}
+	got, _ := tree.Get("key")
+	if arr, ok := got.([]any); !ok || len(arr) != 2 || arr[0] != int64(1) {
+		t.Errorf("SetPath() stored %#v, want []any{int64(1), int64(2)}", got)
+	}
+}
+
 func TestHandler_Serialize(t *testing.T) {
 	h := New()
 
@@ -308,6 +460,65 @@ func TestHandler_Serialize(t *testing.T) {
 	}
 }
 
+func TestHandler_Serialize_PreservesOrder(t *testing.T) {
+	h := New()
+
+	// Create ordered map with specific key order
+	tree := orderedmap.New()
+	tree.Set("zebra", "last")
+	tree.Set("apple", "first")
+	tree.Set("mango", "middle")
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	// The order should be zebra, apple, mango (insertion order)
+	want := "zebra = \"last\"\napple = \"first\"\nmango = \"middle\"\n"
+	if string(data) != want {
+		t.Errorf("Serialize() = %q, want %q", string(data), want)
+	}
+}
+
+func TestHandler_ParseAndSerialize_PreservesOrder(t *testing.T) {
+	h := New()
+
+	// Each array element keeps its own key order, including inline tables
+	input := `zebra = 1
+apple = 2
+points = [{ y = 1, x = 2 }, { x = 3, y = 4 }]
+
+[mango]
+z = true
+a = false
+
+[[servers]]
+port = 80
+host = "a"
+
+[[servers.routes]]
+path = "/"
+
+[[servers]]
+host = "b"
+port = 81
+`
+
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if string(data) != input {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, input)
+	}
+}
+
 func TestHandler_ParseAndSerialize_RoundTrip(t *testing.T) {
 	h := New()
 
@@ -347,7 +558,7 @@ enabled = true
 		t.Errorf("GetPath() server.tls.enabled = %v, want true", enabled)
 	}
 
-	// Serialize back (order may differ due to encoder)
+	// Serialize back
 	data, err := h.Serialize(tree, format.SerializeOptions{})
 	if err != nil {
 		t.Fatalf("Serialize() error = %v", err)
@@ -405,3 +616,247 @@ array = [1, 2, 3]
 		t.Errorf("array = %v (%T), want [1, 2, 3]", arr, arr)
 	}
 }
+
+func TestHandler_Conformance(t *testing.T) {
+	formattest.RunConformance(t, New(), formattest.Fixtures{
+		Document: `zebra = "z"
+apple = "a"
+
+[servers.a]
+enabled = true
+
+[servers.b]
+enabled = false
+`,
+		RoundTripExact:  true,
+		OrderedKeys:     []string{"zebra", "apple", "servers"},
+		LeafPath:        []string{"servers", "a", "enabled"},
+		LeafValue:       true,
+		WildcardPath:    []string{"servers", "*", "enabled"},
+		WildcardMatches: [][]string{{"servers", "a", "enabled"}, {"servers", "b", "enabled"}},
+		DeepPath:        []string{"new", "nested", "key"},
+	})
+}
+
+// annotatedDoc is a hand-annotated config in the style of starship.toml.
+const annotatedDoc = `# Prompt configuration
+# See https://starship.rs/config/
+
+add_newline = false # keep the prompt compact
+format = "$directory$character"
+scan_timeout = 30
+
+# Characters shown before the cursor
+[character]
+success_symbol = "[➜](bold green)"
+error_symbol = "[✗](bold red)"
+
+[directory]
+truncation_length = 3
+
+# Substitutions are matched in order
+substitutions = { "~/Documents" = "󰈙 ", "~/Downloads" = " " }
+
+[git_status]
+disabled = true # too slow on large repos
+
+[languages.python]
+symbol = "py "
+
+[[profiles]]
+name = "work"
+ratio = 0.5
+since = 2024-01-15
+
+[[profiles]]
+name = "home"
+tags = ["a", "b"]
+
+# Custom modules go below
+`
+
+func TestHandler_RoundTrip_PreservesComments(t *testing.T) {
+	h := New()
+	tree, err := h.Parse([]byte(annotatedDoc), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if string(data) != annotatedDoc {
+		t.Errorf("round-trip mismatch:\ngot:\n%s\nwant:\n%s", data, annotatedDoc)
+	}
+}
+
+func TestHandler_Merge_KeepsComments(t *testing.T) {
+	h := New()
+
+	// Managed is parsed first, so its comments apply to shared keys; keys
+	// only in current keep the comments they have there.
+	managed, err := h.Parse([]byte(`# Theme picked by the app
+theme = "light"
+
+[editor]
+# Font size in points
+font_size = 12
+`), format.ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := h.Parse([]byte(`theme = "dark" # changed in settings
+
+[editor]
+font_size = 14
+# Added by the app
+recent = ["a.txt"]
+`), format.ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, keys := range [][]string{{"theme"}, {"editor", "recent"}} {
+		p := path.NewArrayPath(keys)
+		val, _ := h.GetPath(current, p)
+		if err := h.SetPath(managed, p, val); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := h.Serialize(managed, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	want := `# Theme picked by the app
+theme = "dark"
+
+[editor]
+# Font size in points
+font_size = 12
+# Added by the app
+recent = ["a.txt"]
+`
+	if string(data) != want {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestHandler_Parse_CommentLookalikes(t *testing.T) {
+	// Hashes and brackets inside strings and multi-line values are not comments or headers
+	input := `url = "http://host/#anchor" # real comment
+literal = 'C:\path\#1'
+multi = """
+[not.a.table]
+# not a comment
+"""
+list = [
+  "a", # dropped
+  "b",
+]
+"quoted.key" = 1
+dotted.inner = 2
+`
+	h := New()
+	tree, err := h.Parse([]byte(input), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	want := `url = "http://host/#anchor" # real comment
+literal = "C:\\path\\#1"
+multi = "[not.a.table]\n# not a comment\n"
+list = ["a", "b"]
+"quoted.key" = 1
+
+[dotted]
+inner = 2
+`
+	if string(data) != want {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestHandler_Serialize_Values(t *testing.T) {
+	h := New()
+
+	inner := orderedmap.New()
+	inner.Set("x", int64(1))
+	point := orderedmap.New()
+	point.Set("y", 2)
+
+	tree := orderedmap.New()
+	tree.Set("float", 1.0)
+	tree.Set("small", 0.00001)
+	tree.Set("big", 1e300)
+	tree.Set("text", "tab\tquote\" bell\a")
+	tree.Set("points", []any{inner})
+	tree.Set("empty", []any{})
+	tree.Set("table", point)
+
+	data, err := h.Serialize(tree, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	want := `float = 1.0
+small = 1e-05
+big = 1e+300
+text = "tab\tquote\" bell\u0007"
+empty = []
+
+[[points]]
+x = 1
+
+[table]
+y = 2
+`
+	if string(data) != want {
+		t.Errorf("Serialize() =\n%s\nwant:\n%s", data, want)
+	}
+
+	if _, err := h.Parse(data, format.ParseOptions{}); err != nil {
+		t.Errorf("Re-parse serialized data error = %v", err)
+	}
+
+	tree.Set("null", nil)
+	if _, err := h.Serialize(tree, format.SerializeOptions{}); err == nil {
+		t.Error("Serialize() should reject a null value")
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantLine int
+		wantCol  int
+	}{
+		{name: "unclosed table", input: "[server]\nport = 8080\n[client\nname = \"x\"\n", wantLine: 3, wantCol: 8},
+		{name: "missing value", input: "a = 1\nb = \nc = 2\n", wantLine: 2, wantCol: 5},
+		{name: "duplicate key", input: "a = 1\na = 2\n", wantLine: 2, wantCol: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Parse([]byte(tt.input), format.ParseOptions{})
+			if err == nil {
+				t.Fatal("Parse() expected error")
+			}
+			line, col := ErrorPosition([]byte(tt.input), err)
+			if line != tt.wantLine || col != tt.wantCol {
+				t.Errorf("ErrorPosition() = %d, %d; want %d, %d", line, col, tt.wantLine, tt.wantCol)
+			}
+		})
+	}
+
+	if line, col := ErrorPosition(nil, errors.New("other")); line != 0 || col != 0 {
+		t.Errorf("ErrorPosition() = %d, %d for an error without a position", line, col)
+	}
+}
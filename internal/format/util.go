@@ -1,6 +1,10 @@
 package format
 
-import "github.com/iancoleman/orderedmap"
+import (
+	"strconv"
+
+	"github.com/iancoleman/orderedmap"
+)
 
 // ToOrderedMapPtr converts both value and pointer types of OrderedMap to a pointer.
 // Returns nil if the value is not an OrderedMap.
@@ -14,3 +18,13 @@ func ToOrderedMapPtr(v any) *orderedmap.OrderedMap {
 		return nil
 	}
 }
+
+// ArrayIndex parses segment as an index into an array of length n. It
+// reports false for segments that aren't a number or are out of range.
+func ArrayIndex(segment string, n int) (int, bool) {
+	i, err := strconv.Atoi(segment)
+	if err != nil || i < 0 || i >= n {
+		return 0, false
+	}
+	return i, true
+}
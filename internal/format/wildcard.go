@@ -0,0 +1,112 @@
+package format
+
+import (
+	"strconv"
+
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// ExpandPath resolves the wildcard segments of a path against tree and
+// returns the concrete key path of every existing match, in document order.
+//
+// "*" matches any single key or array element, and a numeric segment
+// indexes into an array. Each match binds every wildcard in segments, so a
+// path with several of them yields one match per combination in tree.
+// "**" matches zero or more levels of nested maps, so ["**", "a"] matches
+// "a" at the root as well as at any depth.
+// When one match lies inside another (e.g. ["**", "a"] against {a: {a: 1}}),
+// only the outer match is returned: writing the outer value replaces
+// everything below it, so the inner match would never be seen. A trailing
+// "**" matches nothing.
+func ExpandPath(tree any, segments []string) [][]string {
+	var matches [][]string
+	expand(tree, segments, nil, &matches)
+	return outermost(matches)
+}
+
+// expand walks tree along segments, appending each concrete match to matches.
+func expand(current any, segments []string, prefix []string, matches *[][]string) {
+	if len(segments) == 0 {
+		*matches = append(*matches, append([]string{}, prefix...))
+		return
+	}
+
+	// A numeric segment indexes into an array and "*" matches every
+	// element. "**" only descends through maps, so at an array it matches
+	// zero levels.
+	if arr, ok := current.([]any); ok {
+		switch i, isIndex := ArrayIndex(segments[0], len(arr)); {
+		case segments[0] == path.RecursiveWildcard && len(segments) > 1:
+			expand(arr, segments[1:], prefix, matches)
+		case segments[0] == path.Wildcard:
+			for i, elem := range arr {
+				expand(elem, segments[1:], append(prefix, strconv.Itoa(i)), matches)
+			}
+		case isIndex:
+			expand(arr[i], segments[1:], append(prefix, segments[0]), matches)
+		}
+		return
+	}
+
+	om := ToOrderedMapPtr(current)
+	if om == nil {
+		return
+	}
+
+	switch segments[0] {
+	case path.RecursiveWildcard:
+		if len(segments) == 1 {
+			return
+		}
+		// Zero levels first, then every child at one level deeper
+		expand(om, segments[1:], prefix, matches)
+		for _, key := range om.Keys() {
+			val, _ := om.Get(key)
+			expand(val, segments, append(prefix, key), matches)
+		}
+	case path.Wildcard:
+		for _, key := range om.Keys() {
+			val, _ := om.Get(key)
+			expand(val, segments[1:], append(prefix, key), matches)
+		}
+	default:
+		if val, ok := om.Get(segments[0]); ok {
+			expand(val, segments[1:], append(prefix, segments[0]), matches)
+		}
+	}
+}
+
+// outermost drops duplicate matches and matches nested inside another match.
+func outermost(matches [][]string) [][]string {
+	var result [][]string
+	for i, m := range matches {
+		keep := true
+		for j, other := range matches {
+			if i == j {
+				continue
+			}
+			if (len(other) < len(m) && hasPrefix(m, other)) ||
+				(len(other) == len(m) && j < i && hasPrefix(m, other)) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// hasPrefix reports whether keys starts with prefix.
+func hasPrefix(keys, prefix []string) bool {
+	if len(prefix) > len(keys) {
+		return false
+	}
+	for i := range prefix {
+		if keys[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
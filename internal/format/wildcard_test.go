@@ -0,0 +1,110 @@
+package format
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+func TestExpandPath(t *testing.T) {
+	// {"telemetry": 1, "editor": {"telemetry": 2, "git": {"telemetry": 3}}, "a": {"a": {"a": 4}},
+	//  "list": ["x", {"telemetry": 5}]}
+	git := orderedmap.New()
+	git.Set("telemetry", 3)
+	editor := orderedmap.New()
+	editor.Set("telemetry", 2)
+	editor.Set("git", git)
+	innerA := orderedmap.New()
+	innerA.Set("a", 4)
+	outerA := orderedmap.New()
+	outerA.Set("a", innerA)
+	tree := orderedmap.New()
+	tree.Set("telemetry", 1)
+	tree.Set("editor", editor)
+	tree.Set("a", outerA)
+	binding := orderedmap.New()
+	binding.Set("telemetry", 5)
+	tree.Set("list", []any{"x", binding})
+
+	tests := []struct {
+		name     string
+		segments []string
+		want     [][]string
+	}{
+		{
+			name:     "literal path",
+			segments: []string{"editor", "telemetry"},
+			want:     [][]string{{"editor", "telemetry"}},
+		},
+		{
+			name:     "missing literal path",
+			segments: []string{"editor", "missing"},
+			want:     nil,
+		},
+		{
+			name:     "single wildcard",
+			segments: []string{"*", "telemetry"},
+			want:     [][]string{{"editor", "telemetry"}},
+		},
+		{
+			name:     "recursive wildcard at every depth",
+			segments: []string{"**", "telemetry"},
+			want:     [][]string{{"telemetry"}, {"editor", "telemetry"}, {"editor", "git", "telemetry"}},
+		},
+		{
+			name:     "recursive wildcard below a key",
+			segments: []string{"editor", "**", "telemetry"},
+			want:     [][]string{{"editor", "telemetry"}, {"editor", "git", "telemetry"}},
+		},
+		{
+			name:     "nested matches keep only the outermost",
+			segments: []string{"**", "a"},
+			want:     [][]string{{"a"}},
+		},
+		{
+			name:     "overlapping trailing segments",
+			segments: []string{"**", "a", "a"},
+			want:     [][]string{{"a", "a"}},
+		},
+		{
+			name:     "repeated recursive wildcard",
+			segments: []string{"**", "**", "telemetry"},
+			want:     [][]string{{"telemetry"}, {"editor", "telemetry"}, {"editor", "git", "telemetry"}},
+		},
+		{
+			name:     "array index",
+			segments: []string{"list", "1", "telemetry"},
+			want:     [][]string{{"list", "1", "telemetry"}},
+		},
+		{
+			name:     "array index out of range",
+			segments: []string{"list", "2", "telemetry"},
+			want:     nil,
+		},
+		{
+			name:     "recursive wildcard then array index",
+			segments: []string{"**", "1", "telemetry"},
+			want:     [][]string{{"list", "1", "telemetry"}},
+		},
+		{
+			name:     "single wildcard over array elements",
+			segments: []string{"list", "*", "telemetry"},
+			want:     [][]string{{"list", "1", "telemetry"}},
+		},
+		{
+			name:     "trailing recursive wildcard matches nothing",
+			segments: []string{"editor", "**"},
+			want:     nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ExpandPath(tree, tt.segments)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExpandPath(%v) = %v, want %v", tt.segments, got, tt.want)
+			}
+		})
+	}
+}
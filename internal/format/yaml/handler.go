@@ -0,0 +1,407 @@
+// Package yaml provides a YAML format handler for chezmoi-split.
+package yaml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+	"gopkg.in/yaml.v3"
+)
+
+// Handler implements format.Handler for YAML files.
+type Handler struct{}
+
+// New creates a new YAML handler.
+func New() *Handler {
+	return &Handler{}
+}
+
+// Parse reads YAML bytes and returns an *orderedmap.OrderedMap.
+// Key order from the original YAML document is preserved, and all nested
+// mappings are converted to OrderedMaps.
+//
+// A stream of several "---"-separated documents is returned as a []any with
+// one element per document, in order; paths then address a document by its
+// index as the first segment (e.g. ["1", "spec", "replicas"]).
+func (h *Handler) Parse(data []byte, opts format.ParseOptions) (any, error) {
+	if opts.StripComments {
+		return nil, fmt.Errorf("strip-comments is not supported for YAML format")
+	}
+
+	var docs []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse YAML: %w", err)
+		}
+		docs = append(docs, &doc)
+	}
+
+	// An empty stream has no documents at all
+	if len(docs) == 0 {
+		return orderedmap.New(), nil
+	}
+
+	if len(docs) == 1 {
+		doc := docs[0]
+		if len(doc.Content) == 0 {
+			return orderedmap.New(), nil
+		}
+		root := doc.Content[0]
+		if root.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("failed to parse YAML: top-level value must be a mapping")
+		}
+		return convertNode(root)
+	}
+
+	result := make([]any, len(docs))
+	for i, doc := range docs {
+		val, err := convertNode(doc)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = val
+	}
+	return result, nil
+}
+
+// convertNode recursively converts a yaml.Node into the generic tree representation.
+// Mappings become *orderedmap.OrderedMap, sequences become []any, and scalars
+// are decoded to their native Go types.
+func convertNode(node *yaml.Node) (any, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return convertNode(node.Content[0])
+	case yaml.MappingNode:
+		result := orderedmap.New()
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyNode, valNode := node.Content[i], node.Content[i+1]
+			val, err := convertNode(valNode)
+			if err != nil {
+				return nil, err
+			}
+			result.Set(keyNode.Value, val)
+		}
+		return result, nil
+	case yaml.SequenceNode:
+		result := make([]any, len(node.Content))
+		for i, item := range node.Content {
+			val, err := convertNode(item)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = val
+		}
+		return result, nil
+	case yaml.AliasNode:
+		return convertNode(node.Alias)
+	default:
+		var val any
+		if err := node.Decode(&val); err != nil {
+			return nil, fmt.Errorf("failed to decode YAML value at line %d: %w", node.Line, err)
+		}
+		return val, nil
+	}
+}
+
+// Serialize writes the tree to formatted YAML bytes, preserving key order.
+// A []any tree (from a multi-document stream) is written as one document per
+// element, separated by "---".
+func (h *Handler) Serialize(tree any, opts format.SerializeOptions) ([]byte, error) {
+	if opts.NullStyle == "omit" {
+		tree = format.OmitNulls(tree)
+	}
+
+	docs := []any{tree}
+	if arr, ok := tree.([]any); ok {
+		docs = arr
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(indentWidth(opts.Indent))
+	for _, doc := range docs {
+		node, err := buildNode(doc, opts.BoolStyle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize YAML: %w", err)
+		}
+		if err := encoder.Encode(node); err != nil {
+			return nil, fmt.Errorf("failed to serialize YAML: %w", err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to serialize YAML: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// indentWidth converts an indent string to the number of spaces the YAML encoder expects.
+// YAML does not allow tabs for indentation, so anything else falls back to two spaces.
+func indentWidth(indent string) int {
+	if indent == "" {
+		return 2
+	}
+	for _, r := range indent {
+		if r != ' ' {
+			return 2
+		}
+	}
+	return len(indent)
+}
+
+// buildNode recursively converts the generic tree into a yaml.Node so that
+// ordered maps are emitted in insertion order. Booleans are spelled in
+// boolStyle; since YAML 1.2 reads yes/no and on/off back as strings, those
+// strings are written unquoted too when they are boolStyle's words, so a
+// value kept from the current file comes out as the app wrote it.
+func buildNode(v any, boolStyle string) (*yaml.Node, error) {
+	if om := format.ToOrderedMapPtr(v); om != nil {
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, k := range om.Keys() {
+			val, _ := om.Get(k)
+			keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}
+			valNode, err := buildNode(val, boolStyle)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, keyNode, valNode)
+		}
+		return node, nil
+	}
+
+	if arr, ok := v.([]any); ok {
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range arr {
+			itemNode, err := buildNode(item, boolStyle)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, itemNode)
+		}
+		return node, nil
+	}
+
+	if boolStyle == "yes" || boolStyle == "on" {
+		switch val := v.(type) {
+		case bool:
+			return &yaml.Node{Kind: yaml.ScalarNode, Value: format.BoolText(val, boolStyle)}, nil
+		case string:
+			if val == format.BoolText(true, boolStyle) || val == format.BoolText(false, boolStyle) {
+				return &yaml.Node{Kind: yaml.ScalarNode, Value: val}, nil
+			}
+		}
+	}
+
+	node := &yaml.Node{}
+	if err := node.Encode(v); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// GetPath extracts a value at the given path, supporting wildcards.
+// With "**", the first match in document order is returned.
+// For multi-document trees the first segment selects the document.
+func (h *Handler) GetPath(tree any, p path.Path) (any, bool) {
+	if docs, ok := tree.([]any); ok {
+		segments := p.Segments()
+		if len(segments) == 0 {
+			return tree, true
+		}
+		idx, err := documentIndex(docs, segments[0])
+		if err != nil {
+			return nil, false
+		}
+		return getPathWithWildcard(docs[idx], segments, 1)
+	}
+	return getPathWithWildcard(tree, p.Segments(), 0)
+}
+
+// documentIndex parses a document index segment and checks it against docs.
+func documentIndex(docs []any, segment string) (int, error) {
+	idx, err := strconv.Atoi(segment)
+	if err != nil {
+		return 0, fmt.Errorf("multi-document YAML paths must start with a document index, got %q", segment)
+	}
+	if idx < 0 || idx >= len(docs) {
+		return 0, fmt.Errorf("document index %d out of range (%d documents)", idx, len(docs))
+	}
+	return idx, nil
+}
+
+// getPathWithWildcard recursively navigates the tree, handling wildcards.
+func getPathWithWildcard(current any, segments []string, idx int) (any, bool) {
+	if idx >= len(segments) {
+		return current, true
+	}
+
+	segment := segments[idx]
+	om := format.ToOrderedMapPtr(current)
+	if om == nil {
+		return nil, false
+	}
+
+	if segment == path.RecursiveWildcard {
+		// Recursive wildcard: match at this level first, then at any depth below
+		if idx == len(segments)-1 {
+			return nil, false
+		}
+		if result, ok := getPathWithWildcard(om, segments, idx+1); ok {
+			return result, true
+		}
+		for _, key := range om.Keys() {
+			val, _ := om.Get(key)
+			if result, ok := getPathWithWildcard(val, segments, idx); ok {
+				return result, true
+			}
+		}
+		return nil, false
+	}
+
+	if segment == "*" {
+		// Wildcard: return first match from any key
+		for _, key := range om.Keys() {
+			val, _ := om.Get(key)
+			if result, ok := getPathWithWildcard(val, segments, idx+1); ok {
+				return result, true
+			}
+		}
+		return nil, false
+	}
+
+	val, exists := om.Get(segment)
+	if !exists {
+		return nil, false
+	}
+	return getPathWithWildcard(val, segments, idx+1)
+}
+
+// SetPath sets a value at the given path, supporting wildcards.
+// With "**", every existing match is set.
+// Creates intermediate maps as needed. For multi-document trees the first
+// segment selects an existing document.
+func (h *Handler) SetPath(tree any, p path.Path, value any) error {
+	segments := p.Segments()
+	if len(segments) == 0 {
+		return fmt.Errorf("empty path")
+	}
+
+	value, err := format.NormalizeForFormat(value, "yaml")
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %w", p, err)
+	}
+
+	if docs, ok := tree.([]any); ok {
+		idx, err := documentIndex(docs, segments[0])
+		if err != nil {
+			return err
+		}
+		if len(segments) == 1 {
+			docs[idx] = value
+			return nil
+		}
+		return setPathWithWildcard(docs[idx], segments, 1, value)
+	}
+
+	return setPathWithWildcard(tree, segments, 0, value)
+}
+
+// setPathWithWildcard recursively sets values, handling wildcards.
+func setPathWithWildcard(current any, segments []string, idx int, value any) error {
+	if idx >= len(segments) {
+		return nil
+	}
+
+	om := format.ToOrderedMapPtr(current)
+	if om == nil {
+		return fmt.Errorf("cannot navigate into non-map value")
+	}
+
+	segment := segments[idx]
+	isLast := idx == len(segments)-1
+
+	if segment == path.RecursiveWildcard {
+		// Recursive wildcard: apply to every existing match, never create keys
+		for _, keys := range format.ExpandPath(om, segments[idx:]) {
+			// Matches already exist, so setting them can't fail
+			_ = setPathWithWildcard(om, keys, 0, value)
+		}
+		return nil
+	}
+
+	if segment == "*" {
+		// Wildcard: apply to all keys
+		for _, key := range om.Keys() {
+			val, _ := om.Get(key)
+			if isLast {
+				om.Set(key, value)
+			} else {
+				if err := setPathWithWildcard(val, segments, idx+1, value); err != nil {
+					// Continue to other keys even if one fails
+					continue
+				}
+			}
+		}
+		return nil
+	}
+
+	if isLast {
+		om.Set(segment, value)
+		return nil
+	}
+
+	// Navigate deeper, creating intermediate maps if needed
+	next, exists := om.Get(segment)
+	if !exists {
+		next = orderedmap.New()
+		om.Set(segment, next)
+	}
+
+	nextMap := format.ToOrderedMapPtr(next)
+	if nextMap == nil {
+		return fmt.Errorf("path segment %q is not a map", segment)
+	}
+
+	return setPathWithWildcard(nextMap, segments, idx+1, value)
+}
+
+// DeletePath removes the value at the given path. Wildcards delete every
+// match, and an array element is removed from its array. For multi-document
+// trees the first segment selects an existing document, which itself can't
+// be deleted.
+func (h *Handler) DeletePath(tree any, p path.Path) error {
+	segments := p.Segments()
+	if len(segments) == 0 {
+		return fmt.Errorf("empty path")
+	}
+
+	if docs, ok := tree.([]any); ok {
+		idx, err := documentIndex(docs, segments[0])
+		if err != nil {
+			return err
+		}
+		if len(segments) == 1 {
+			return fmt.Errorf("cannot delete a whole document")
+		}
+		return format.DeleteMatches(docs[idx], segments[1:])
+	}
+	return format.DeleteMatches(tree, segments)
+}
+
+// Ensure Handler implements format.Handler.
+var _ format.Handler = (*Handler)(nil)
@@ -0,0 +1,123 @@
+package merge
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// StrategyCompute writes a value rendered from several current values (see Compute).
+const StrategyCompute = "compute"
+
+// Compute is a managed value built from values in current, such as a single
+// font string from separate family and size keys. Template is executed with
+// the values at Requires and its output replaces the value at Path; if
+// current lacks any of them, the managed value is kept.
+type Compute struct {
+	Path     path.Path
+	Template *template.Template
+	Requires []path.Path
+}
+
+// NewComputeTemplate parses text as a Compute template. Templates only see
+// the data they are given: .current holds the required values, nested by
+// path segment, so ["font", "size"] reads as {{ .current.font.size }} or
+// {{ index .current "font" "size" }}. No functions beyond text/template's
+// builtins are available, and a missing map key is an error.
+func NewComputeTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+// applyCompute renders c from current and sets the result at c.Path. A
+// template that fails to execute leaves the managed value and returns a
+// warning.
+func applyCompute(handler format.Handler, result, current any, c Compute) (Outcome, string) {
+	outcome := Outcome{Path: c.Path, Rule: c.Path, Strategy: StrategyCompute}
+
+	values := make(requiredValues)
+	for _, p := range c.Requires {
+		val, ok := handler.GetPath(current, p)
+		if !ok {
+			outcome.Summary = fmt.Sprintf("%s not found in current, kept managed value", p)
+			return outcome, ""
+		}
+		if err := setNested(values, p.Segments(), plainValue(val)); err != nil {
+			outcome.Summary = "requires paths conflict, kept managed value"
+			return outcome, fmt.Sprintf("compute %s: %v", c.Path, err)
+		}
+	}
+
+	var text strings.Builder
+	if err := c.Template.Execute(&text, map[string]any{"current": values}); err != nil {
+		outcome.Summary = "template failed, kept managed value"
+		return outcome, fmt.Sprintf("compute %s: %v", c.Path, err)
+	}
+
+	prev, existed := handler.GetPath(result, c.Path)
+	if err := handler.SetPath(result, c.Path, text.String()); err != nil {
+		outcome.Summary = fmt.Sprintf("skipped: %v", err)
+		return outcome, ""
+	}
+
+	outcome.Applied = true
+	switch {
+	case existed && prev == text.String():
+		outcome.Summary = "computed value matches managed, no change"
+	default:
+		outcome.Summary = fmt.Sprintf("computed from %s in current", plural(len(c.Requires), "value"))
+	}
+	return outcome, ""
+}
+
+// requiredValues holds the values a Compute template reads, nested by path
+// segment. Its own type tells the maps setNested creates from map values
+// read out of current.
+type requiredValues map[string]any
+
+// setNested stores val in m under the chain of keys, creating maps on the
+// way. It fails rather than overwrite or reach into a value stored by an
+// earlier call, as when one required path is inside another.
+func setNested(m requiredValues, keys []string, val any) error {
+	for i, k := range keys[:len(keys)-1] {
+		existing, found := m[k]
+		child, ok := existing.(requiredValues)
+		if found && !ok {
+			return fmt.Errorf("requires %s conflicts with %s", path.NewArrayPath(keys), path.NewArrayPath(keys[:i+1]))
+		}
+		if !found {
+			child = make(requiredValues)
+			m[k] = child
+		}
+		m = child
+	}
+	last := keys[len(keys)-1]
+	if _, ok := m[last].(requiredValues); ok {
+		return fmt.Errorf("requires %s conflicts with a path inside it", path.NewArrayPath(keys))
+	}
+	m[last] = val
+	return nil
+}
+
+// plainValue converts ordered maps in v to plain maps, which index and
+// field access in templates understand.
+func plainValue(v any) any {
+	if om := format.ToOrderedMapPtr(v); om != nil {
+		result := make(map[string]any, len(om.Keys()))
+		for _, k := range om.Keys() {
+			val, _ := om.Get(k)
+			result[k] = plainValue(val)
+		}
+		return result
+	}
+	if arr, ok := v.([]any); ok {
+		result := make([]any, len(arr))
+		for i, val := range arr {
+			result[i] = plainValue(val)
+		}
+		return result
+	}
+	return v
+}
@@ -0,0 +1,143 @@
+package merge
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thirteen37/chezmoi-split/internal/format/json"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// fontCompute joins the family and size keys of current into one font string.
+func fontCompute(t *testing.T, text string) Compute {
+	t.Helper()
+	tmpl, err := NewComputeTemplate(`["font"]`, text)
+	if err != nil {
+		t.Fatalf("NewComputeTemplate() error = %v", err)
+	}
+	return Compute{
+		Path:     path.NewArrayPath([]string{"font"}),
+		Template: tmpl,
+		Requires: []path.Path{
+			path.NewArrayPath([]string{"family"}),
+			path.NewArrayPath([]string{"size"}),
+		},
+	}
+}
+
+func TestMergeWithOptions_Compute(t *testing.T) {
+	handler := json.New()
+	managed := om("font", "Menlo 12", "theme", "dark")
+
+	tests := []struct {
+		name        string
+		text        string
+		current     any
+		wantFont    string
+		wantApplied bool
+		wantWarning string
+	}{
+		{
+			name:        "joins current values",
+			text:        `{{ index .current "family" }} {{ index .current "size" }}`,
+			current:     om("family", "Fira Code", "size", 14.0, "theme", "light"),
+			wantFont:    "Fira Code 14",
+			wantApplied: true,
+		},
+		{
+			name:     "missing input keeps managed value",
+			text:     `{{ .current.family }} {{ .current.size }}`,
+			current:  om("family", "Fira Code"),
+			wantFont: "Menlo 12",
+		},
+		{
+			name:     "no current keeps managed value",
+			text:     `{{ .current.family }} {{ .current.size }}`,
+			wantFont: "Menlo 12",
+		},
+		{
+			name:        "template error keeps managed value",
+			text:        `{{ .current.family }} {{ .current.weight }}`,
+			current:     om("family", "Fira Code", "size", 14.0),
+			wantFont:    "Menlo 12",
+			wantWarning: `compute ["font"]: template: ["font"]:1:33: executing "[\"font\"]" at <.current.weight>: map has no entry for key "weight"`,
+		},
+		{
+			name:        "nested values",
+			text:        `{{ .current.family }} {{ range .current.size }}{{ . }}{{ end }}`,
+			current:     om("family", "Fira Code", "size", []any{"1", "4"}),
+			wantFont:    "Fira Code 14",
+			wantApplied: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fontCompute(t, tt.text)
+			result, report := MergeWithOptions(handler, managed, tt.current, nil, Options{Computes: []Compute{c}})
+
+			if font, _ := handler.GetPath(result, c.Path); font != tt.wantFont {
+				t.Errorf("font = %v, want %q", font, tt.wantFont)
+			}
+			// Values the compute doesn't name stay managed
+			if theme, _ := handler.GetPath(result, path.NewArrayPath([]string{"theme"})); theme != "dark" {
+				t.Errorf("theme = %v, want dark", theme)
+			}
+			if len(report.Outcomes) != 1 || report.Outcomes[0].Strategy != StrategyCompute || report.Outcomes[0].Applied != tt.wantApplied {
+				t.Errorf("outcomes = %v, want one compute outcome with Applied %v", report.Outcomes, tt.wantApplied)
+			}
+			if got := strings.Join(report.Warnings, "\n"); got != tt.wantWarning {
+				t.Errorf("warnings = %q, want %q", got, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestMergeWithOptions_ComputeConflictingRequires(t *testing.T) {
+	handler := json.New()
+	tmpl, err := NewComputeTemplate(`["font"]`, `{{ .current.a }}`)
+	if err != nil {
+		t.Fatalf("NewComputeTemplate() error = %v", err)
+	}
+	managed := om("font", "Menlo 12")
+	current := om("a", om("b", "x"))
+
+	for _, requires := range [][]path.Path{
+		{path.NewArrayPath([]string{"a"}), path.NewArrayPath([]string{"a", "b"})},
+		{path.NewArrayPath([]string{"a", "b"}), path.NewArrayPath([]string{"a"})},
+	} {
+		c := Compute{Path: path.NewArrayPath([]string{"font"}), Template: tmpl, Requires: requires}
+		result, report := MergeWithOptions(handler, managed, current, nil, Options{Computes: []Compute{c}})
+		if !reflect.DeepEqual(result, managed) {
+			t.Errorf("requires %v: result = %v, want the managed value", requires, result)
+		}
+		if len(report.Warnings) != 1 || !strings.Contains(report.Warnings[0], "conflicts with") {
+			t.Errorf("requires %v: warnings = %q, want a conflict", requires, report.Warnings)
+		}
+	}
+}
+
+func TestMergeWithOptions_ComputeOverIgnore(t *testing.T) {
+	handler := json.New()
+	c := fontCompute(t, `{{ .current.family }}-{{ .current.size }}`)
+	managed := om("font", "Menlo 12")
+	current := om("font", "old", "family", "Iosevka", "size", 13.0)
+
+	// The computed value wins over an ignore rule on the same path
+	ignored := []path.Path{path.NewArrayPath([]string{"font"})}
+	result, _ := MergeWithOptions(handler, managed, current, ignored, Options{Computes: []Compute{c}})
+	if want := om("font", "Iosevka-13"); !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %v, want %v", result, want)
+	}
+}
+
+func TestNewComputeTemplate_Sandboxed(t *testing.T) {
+	// Only text/template's builtins exist
+	if _, err := NewComputeTemplate("t", `{{ env "HOME" }}`); err == nil {
+		t.Error("NewComputeTemplate() with an undefined function should fail")
+	}
+	if _, err := NewComputeTemplate("t", `{{ printf "%s-%v" .current.family .current.size }}`); err != nil {
+		t.Errorf("NewComputeTemplate() error = %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package merge
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/ini"
+	"github.com/thirteen37/chezmoi-split/internal/format/json"
+	"github.com/thirteen37/chezmoi-split/internal/format/toml"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// mergeSeed is one starting input for a merge fuzz target.
+type mergeSeed struct {
+	managed, current, ignore string
+}
+
+// fuzzMerge checks the merge invariants for one format: Merge never mutates
+// managed, and its result always serializes to something the same format
+// parses again. ignore is an ignore directive value; invalid ones merge
+// with no paths.
+func fuzzMerge(f *testing.F, newHandler func() format.Handler, seeds []mergeSeed) {
+	for _, s := range seeds {
+		f.Add(s.managed, s.current, s.ignore, false)
+		f.Add(s.managed, s.current, s.ignore, true)
+	}
+
+	f.Fuzz(func(t *testing.T, managedData, currentData, ignore string, union bool) {
+		handler := newHandler()
+		managed, err := handler.Parse([]byte(managedData), format.ParseOptions{})
+		if err != nil {
+			return
+		}
+		before, err := handler.Serialize(managed, format.SerializeOptions{})
+		if err != nil {
+			return
+		}
+
+		var current any
+		if parsed, err := handler.Parse([]byte(currentData), format.ParseOptions{}); err == nil {
+			current = parsed
+		}
+
+		var paths []path.Path
+		if parsed, err := path.ParseArrayPaths(ignore); err == nil {
+			for _, p := range parsed {
+				paths = append(paths, p)
+			}
+		}
+
+		opts := Options{}
+		if union {
+			opts.ArrayMerge = ArrayUnion
+		}
+		result, _ := MergeWithOptions(handler, managed, current, paths, opts)
+
+		after, err := handler.Serialize(managed, format.SerializeOptions{})
+		if err != nil || !bytes.Equal(before, after) {
+			t.Errorf("Merge() mutated managed: %q became %q (err %v)", before, after, err)
+		}
+
+		output, err := handler.Serialize(result, format.SerializeOptions{})
+		if err != nil {
+			t.Fatalf("Serialize() of merge result error = %v", err)
+		}
+		if _, err := newHandler().Parse(output, format.ParseOptions{}); err != nil {
+			t.Errorf("merge result doesn't parse again: %v\n%s", err, output)
+		}
+	})
+}
+
+func FuzzMergeJSON(f *testing.F) {
+	fuzzMerge(f, func() format.Handler { return json.New() }, []mergeSeed{
+		{
+			`{"agent": {"default_model": "gpt-4", "enabled": true}, "theme": "dark"}`,
+			`{"agent": {"default_model": "claude"}, "theme": "light", "extra": [1, 2]}`,
+			`[["agent", "default_model"], ["theme"]]`,
+		},
+		{
+			`{"servers": {"a": {"enabled": true}, "b": {"enabled": false}}}`,
+			`{"servers": {"a": {"enabled": false}, "c": {"enabled": true}}}`,
+			`["servers", "*", "enabled"]`,
+		},
+		{
+			`{"plugins": ["a", "b"], "nested": {"deep": {"model": "x"}}}`,
+			`{"plugins": ["b", "c"], "nested": {"deep": {"model": "y"}}}`,
+			`[["plugins"], ["**", "model"]]`,
+		},
+		{`[1, 2]`, `{"a": null}`, `["a"]`},
+	})
+}
+
+func FuzzMergeTOML(f *testing.F) {
+	fuzzMerge(f, func() format.Handler { return toml.New() }, []mergeSeed{
+		{
+			"# Managed\n[editor]\ntheme = \"dark\" # comment\nplugins = [\"a\"]\n\n[[profiles]]\nname = \"work\"\n",
+			"[editor]\ntheme = \"light\"\nplugins = [\"b\"]\nfont = { size = 12 }\n",
+			`[["editor", "theme"], ["editor", "plugins"]]`,
+		},
+		{
+			"title = \"x\"\nwhen = 1979-05-27T07:32:00Z\n[a.b]\nc = 1.5\n",
+			"[a.b]\nc = inf\nd = 2024-01-01\n",
+			`["a", "**", "c"]`,
+		},
+	})
+}
+
+func FuzzMergeINI(f *testing.F) {
+	fuzzMerge(f, func() format.Handler { return ini.New() }, []mergeSeed{
+		{
+			"; comment\n[user]\nname = Managed\nemail = m@example.com\n\n[core]\neditor = vim\n",
+			"[user]\nname = Current\n[alias]\nco = checkout\n",
+			`[["user", "name"], ["alias", "*"]]`,
+		},
+		{"key = value\n[section]\nx = 1\n", "key = other\n", `["key"]`},
+	})
+}
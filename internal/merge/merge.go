@@ -2,13 +2,108 @@
 package merge
 
 import (
+	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/iancoleman/orderedmap"
 	"github.com/thirteen37/chezmoi-split/internal/format"
 	"github.com/thirteen37/chezmoi-split/internal/path"
 )
 
+// StrategyOverlay replaces the managed value at a path with the value from current.
+const StrategyOverlay = "overlay"
+
+// StrategyPreserveIfMissing takes a managed default's value from current
+// when current has one (see Options.PreserveIfMissing).
+const StrategyPreserveIfMissing = "preserve-if-missing"
+
+// StrategySectionMerge combines an ignored section key by key (see Options.MergeSections).
+const StrategySectionMerge = "section-merge"
+
+// Array merge modes for ignored paths whose managed and current values are
+// both arrays. Elements of current that also appear in managed are not
+// repeated by append and prepend, so applying the same merge again converges.
+const (
+	ArrayReplace = "replace" // Use the current array (default)
+	ArrayAppend  = "append"  // Managed elements, then the remaining current elements
+	ArrayPrepend = "prepend" // Remaining current elements, then managed elements
+	ArrayUnion   = "union"   // Managed elements, then current elements not yet present, without duplicates
+)
+
+// ArrayModes lists the supported array merge modes.
+var ArrayModes = []string{ArrayReplace, ArrayAppend, ArrayUnion, ArrayPrepend}
+
+// Merge bases: which config the result starts from.
+const (
+	BaseManaged = "managed" // Start from managed; current only supplies ignored paths (default)
+	BaseCurrent = "current" // Start from current and write every managed value over it
+)
+
+// BaseModes lists the supported merge bases.
+var BaseModes = []string{BaseManaged, BaseCurrent}
+
+// Options configures a merge.
+type Options struct {
+	ArrayMerge string // One of ArrayModes; empty means ArrayReplace
+	Base       string // One of BaseModes; empty means BaseManaged
+	KeepExtra  bool   // Keep sections, and keys within sections, that exist only in current
+	Order      string // One of OrderModes; empty means OrderMerged
+
+	// Rules holds settings for single ignore paths, keyed by the path's
+	// String().
+	Rules map[string]Rule
+
+	// MergeSections makes an ignored top-level map (an INI section) combine
+	// with the managed one instead of replacing it: keys current has take
+	// its values, keys only managed has are kept, and current's extra keys
+	// follow the managed keys in order.
+	MergeSections bool
+
+	// KeepRecords keeps top-level entries that exist only in current (JSON
+	// Lines records the app appended) after the managed ones, like KeepExtra
+	// without filling in entries both have.
+	KeepRecords bool
+
+	// PreserveIfMissing lists managed defaults: paths where a value in
+	// current wins as a whole, and the managed value is written only when
+	// current has none. A path that is also ignored follows the ignore rule.
+	PreserveIfMissing []path.Path
+
+	// Computes are managed values rendered from current values; they run
+	// after the overlay, so a computed path wins over an ignore rule.
+	Computes []Compute
+}
+
+// Rule holds the settings given after a single ignore path.
+type Rule struct {
+	ArrayMerge    string // Overrides Options.ArrayMerge for this path; empty keeps it
+	MaxDepth      int    // Levels kept below the path; deeper content is dropped. 0 means no limit
+	ReportNewKeys bool   // Warn about keys under the path that managed doesn't have
+}
+
+// Outcome describes what the merge did at a single app-owned path.
+type Outcome struct {
+	Path     path.Path
+	Rule     path.Path // Ignore path that produced this outcome; differs from Path for expanded "**" rules
+	Strategy string    // Merge strategy that ran for this path
+	Applied  bool      // Whether a value from current was written to the result
+	Summary  string    // One-line description of the effect
+}
+
+// String formats the outcome as "<path>: <strategy>: <summary>".
+func (o Outcome) String() string {
+	return fmt.Sprintf("%s: %s: %s", o.Path.String(), o.Strategy, o.Summary)
+}
+
+// Report collects per-path outcomes from a merge.
+type Report struct {
+	Outcomes []Outcome
+	Warnings []string // Content dropped by max-depth and new keys found by report-new-keys
+	Metrics  *Metrics // Tree and rule sizes; nil unless Measure was called
+}
+
 // Merge combines a managed configuration with the current configuration,
 // preserving values at app-owned paths from current.
 //
@@ -17,28 +112,551 @@ import (
 // 2. For each app-owned path:
 //   - If the path exists in current, copy that value to result
 //   - If the path doesn't exist in current, keep managed value
+//
+// Paths containing "*" or "**" are expanded against current first, so every
+// match keeps its own value from current.
 func Merge(handler format.Handler, managed, current any, paths []path.Path) any {
+	result, _ := MergeWithReport(handler, managed, current, paths)
+	return result
+}
+
+// MergeWithReport performs the same merge as Merge and additionally returns
+// a Report describing which strategy ran at each path and what it changed.
+func MergeWithReport(handler format.Handler, managed, current any, paths []path.Path) (any, *Report) {
+	return MergeWithOptions(handler, managed, current, paths, Options{})
+}
+
+// MergeWithOptions performs MergeWithReport with the given options.
+//
+// With opts.Base set to BaseCurrent, managed is first rebased onto current:
+// keys only the app writes are kept, and every value in managed is written
+// over them. Ignored paths are then overlaid from current as usual.
+//
+// With opts.KeepExtra, top-level entries of current that the result lacks
+// (INI sections, ssh Host blocks, ...) are appended after the overlay, as
+// are the keys current has in a top-level map the result also has.
+// opts.KeepRecords appends only the top-level entries, before the overlay.
+//
+// Paths in opts.PreserveIfMissing are overlaid before the ignored paths,
+// without array or section merging.
+//
+// An ignore path's entry in opts.Rules can override the array merge mode,
+// drop content more than MaxDepth levels below the path, and report keys
+// current added below it; the last two add to report.Warnings.
+//
+// Each of opts.Computes then sets its path from current's values. A
+// template that fails to execute keeps the managed value and adds a warning.
+//
+// An empty path in paths is the document root: the result is a copy of
+// current, which the other paths, the managed defaults, and KeepExtra can't
+// add to (see mergeRoot). Computes still run.
+//
+// With opts.Order set to OrderTemplate, the keys of every map in the result
+// are finally sorted into managed's order (see reorder), so the output
+// doesn't depend on the order the app wrote current in.
+func MergeWithOptions(handler format.Handler, managed, current any, paths []path.Path, opts Options) (any, *Report) {
+	if opts.Order == OrderTemplate {
+		unordered := opts
+		unordered.Order = OrderMerged
+		result, report := MergeWithOptions(handler, managed, current, paths, unordered)
+		reorder(result, managed)
+		return result, report
+	}
+
+	if opts.Base == BaseCurrent && !isNilValue(current) {
+		managed = rebase(handler, managed, current)
+	}
+
 	// Deep copy managed to avoid modifying original
 	result := deepCopy(managed)
+	report := &Report{}
 
-	// If no current config, just return managed
+	// If no current config, just return managed. A managed root that is a
+	// bare value (a JSON null, true, or 42) is authoritative: it has no
+	// paths for current to fill in.
 	// Note: We check for typed nil (e.g., (*orderedmap.OrderedMap)(nil))
 	// because interface comparison with nil may fail for typed nil pointers
-	if isNilValue(current) {
-		return result
+	defaults := withoutPaths(opts.PreserveIfMissing, paths)
+	summary := ""
+	switch {
+	case !isContainer(managed):
+		summary = "managed root is a bare value, kept managed value"
+		if len(defaults)+len(paths)+len(opts.Computes) > 0 {
+			report.Warnings = append(report.Warnings, "managed config is a bare value, not an object; ignore rules have no effect")
+		}
+	case isNilValue(current):
+		summary = "no current config, kept managed value"
+	}
+	if summary != "" {
+		for _, p := range defaults {
+			report.Outcomes = append(report.Outcomes, Outcome{
+				Path:     p,
+				Rule:     p,
+				Strategy: StrategyPreserveIfMissing,
+				Summary:  summary,
+			})
+		}
+		for _, p := range paths {
+			report.Outcomes = append(report.Outcomes, Outcome{
+				Path:     p,
+				Rule:     p,
+				Strategy: StrategyOverlay,
+				Summary:  summary,
+			})
+		}
+		for _, c := range opts.Computes {
+			report.Outcomes = append(report.Outcomes, Outcome{
+				Path:     c.Path,
+				Rule:     c.Path,
+				Strategy: StrategyCompute,
+				Summary:  summary,
+			})
+		}
+		return result, report
+	}
+
+	for _, p := range paths {
+		if len(p.Segments()) == 0 {
+			return mergeRoot(handler, managed, current, p, defaults, paths, opts)
+		}
+	}
+
+	// Records only current has come first, whole, so a wildcard rule that
+	// matches them doesn't start a partial copy
+	if opts.KeepRecords && !opts.KeepExtra {
+		addMissing(format.ToOrderedMapPtr(result), format.ToOrderedMapPtr(current), 0)
+	}
+
+	// Managed defaults take current's value whole, then each app-owned path
+	// is overlaid from current if it exists
+	for _, p := range defaults {
+		for _, outcome := range overlayRule(handler, result, current, p, Options{}) {
+			outcome.Strategy = StrategyPreserveIfMissing
+			report.Outcomes = append(report.Outcomes, outcome)
+		}
+	}
+	for _, p := range paths {
+		rule := opts.Rules[p.String()]
+		ruleOpts := opts
+		if rule.ArrayMerge != "" {
+			ruleOpts.ArrayMerge = rule.ArrayMerge
+		}
+		outcomes := overlayRule(handler, result, current, p, ruleOpts)
+		for _, outcome := range outcomes {
+			if outcome.Applied {
+				report.Warnings = append(report.Warnings, checkSubtree(handler, managed, result, outcome.Path, rule)...)
+			}
+		}
+		report.Outcomes = append(report.Outcomes, outcomes...)
+	}
+
+	for _, c := range opts.Computes {
+		outcome, warning := applyCompute(handler, result, current, c)
+		report.Outcomes = append(report.Outcomes, outcome)
+		if warning != "" {
+			report.Warnings = append(report.Warnings, warning)
+		}
+	}
+
+	if opts.KeepExtra {
+		keepExtra(result, current)
+	}
+
+	return result, report
+}
+
+// mergeRoot is MergeWithOptions for a script that ignores the document root
+// at root. The result is a copy of current; the other ignore paths and the
+// defaults are reported as covered by the root rule.
+func mergeRoot(handler format.Handler, managed, current any, root path.Path, defaults, paths []path.Path, opts Options) (any, *Report) {
+	result := deepCopy(current)
+	report := &Report{}
+
+	outcome := Outcome{Path: root, Rule: root, Strategy: StrategyOverlay, Applied: true}
+	if reflect.DeepEqual(managed, current) {
+		outcome.Summary = "current matches managed, no change"
+	} else {
+		outcome.Summary = "replaced the whole config with current"
 	}
+	report.Outcomes = append(report.Outcomes, outcome)
 
-	// For each app-owned path, overlay value from current if it exists
+	covered := "covered by the document root rule, kept current value"
+	for _, p := range defaults {
+		report.Outcomes = append(report.Outcomes, Outcome{Path: p, Rule: p, Strategy: StrategyPreserveIfMissing, Summary: covered})
+	}
 	for _, p := range paths {
-		if val, ok := handler.GetPath(current, p); ok {
-			// Ignore errors - if we can't set, we skip
-			_ = handler.SetPath(result, p, val)
+		if len(p.Segments()) > 0 {
+			report.Outcomes = append(report.Outcomes, Outcome{Path: p, Rule: p, Strategy: StrategyOverlay, Summary: covered})
+		}
+	}
+
+	for _, c := range opts.Computes {
+		outcome, warning := applyCompute(handler, result, current, c)
+		report.Outcomes = append(report.Outcomes, outcome)
+		if warning != "" {
+			report.Warnings = append(report.Warnings, warning)
 		}
 	}
+	return result, report
+}
+
+// overlayRule overlays the value at p from current onto result. A wildcard
+// matches many places with different values, so each match in current is
+// overlaid separately.
+func overlayRule(handler format.Handler, result, current any, p path.Path, opts Options) []Outcome {
+	if !path.HasWildcard(p) {
+		return []Outcome{overlay(handler, result, current, p, opts)}
+	}
+
+	matches := format.ExpandPath(current, format.ResolvePath(handler, current, p.Segments()))
+	if len(matches) == 0 {
+		return []Outcome{{
+			Path:     p,
+			Rule:     p,
+			Strategy: StrategyOverlay,
+			Summary:  "not found in current, kept managed value",
+		}}
+	}
+	outcomes := make([]Outcome, 0, len(matches))
+	for _, keys := range matches {
+		outcome := overlay(handler, result, current, path.NewArrayPath(keys), opts)
+		outcome.Rule = p
+		outcomes = append(outcomes, outcome)
+	}
+	return outcomes
+}
 
+// withoutPaths returns the paths in ps that aren't also in exclude.
+func withoutPaths(ps, exclude []path.Path) []path.Path {
+	var result []path.Path
+	for _, p := range ps {
+		excluded := false
+		for _, e := range exclude {
+			if p.String() == e.String() {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			result = append(result, p)
+		}
+	}
 	return result
 }
 
+// keepExtra appends the entries of current that result lacks, at the top
+// level and within top-level maps both have (the keys of a section). Keys
+// are set directly rather than through the handler, so a key such as "*"
+// is taken literally.
+func keepExtra(result, current any) {
+	addMissing(format.ToOrderedMapPtr(result), format.ToOrderedMapPtr(current), 1)
+}
+
+// addMissing copies the keys of current that result lacks into result, and
+// recurses depth more levels into maps both have.
+func addMissing(result, current *orderedmap.OrderedMap, depth int) {
+	if result == nil || current == nil {
+		return
+	}
+	for _, k := range current.Keys() {
+		val, _ := current.Get(k)
+		existing, exists := result.Get(k)
+		if !exists {
+			result.Set(k, deepCopy(val))
+			continue
+		}
+		if depth > 0 {
+			addMissing(format.ToOrderedMapPtr(existing), format.ToOrderedMapPtr(val), depth-1)
+		}
+	}
+}
+
+// rebase returns a copy of current with every value in managed written over
+// it. Maps present in both are merged key by key; any other managed value
+// replaces the current one. A managed root that isn't a map is returned as is.
+func rebase(handler format.Handler, managed, current any) any {
+	if format.ToOrderedMapPtr(managed) == nil || format.ToOrderedMapPtr(current) == nil {
+		return managed
+	}
+	result := deepCopy(current)
+	overlayManaged(handler, result, managed, nil)
+	return result
+}
+
+// overlayManaged writes the entries of the managed map m, found at keys, into result.
+func overlayManaged(handler format.Handler, result, m any, keys []string) {
+	om := format.ToOrderedMapPtr(m)
+	for _, k := range om.Keys() {
+		val, _ := om.Get(k)
+		childKeys := append(append([]string{}, keys...), k)
+		p := path.NewArrayPath(childKeys)
+
+		if format.ToOrderedMapPtr(val) != nil {
+			if existing, ok := handler.GetPath(result, p); ok && format.ToOrderedMapPtr(existing) != nil {
+				overlayManaged(handler, result, val, childKeys)
+				continue
+			}
+		}
+		// A value the format can't hold was already in managed, so this can't fail
+		_ = handler.SetPath(result, p, deepCopy(val))
+	}
+}
+
+// overlay copies the value at p from current into result and describes the effect.
+// When both values are arrays, opts.ArrayMerge decides how they combine.
+func overlay(handler format.Handler, result, current any, p path.Path, opts Options) Outcome {
+	outcome := Outcome{Path: p, Rule: p, Strategy: StrategyOverlay}
+
+	val, ok := handler.GetPath(current, p)
+	if !ok {
+		outcome.Summary = "not found in current, kept managed value"
+		return outcome
+	}
+
+	prev, existed := handler.GetPath(result, p)
+
+	prevArr, prevIsArr := prev.([]any)
+	valArr, valIsArr := val.([]any)
+	if existed && prevIsArr && valIsArr && opts.ArrayMerge != "" && opts.ArrayMerge != ArrayReplace {
+		return mergeArrays(handler, result, p, prevArr, valArr, opts.ArrayMerge)
+	}
+
+	prevMap, valMap := format.ToOrderedMapPtr(prev), format.ToOrderedMapPtr(val)
+	if existed && prevMap != nil && valMap != nil && opts.MergeSections && len(p.Segments()) == 1 {
+		return mergeSection(handler, result, p, prevMap, valMap)
+	}
+
+	if err := handler.SetPath(result, p, val); err != nil {
+		// If we can't set, we skip
+		outcome.Summary = fmt.Sprintf("skipped: %v", err)
+		return outcome
+	}
+
+	outcome.Applied = true
+	switch {
+	case !existed:
+		outcome.Summary = "added " + describe(val) + " from current"
+	case reflect.DeepEqual(prev, val):
+		outcome.Summary = "current matches managed, no change"
+	default:
+		outcome.Summary = "replaced " + describe(prev) + " with " + describe(val)
+	}
+	return outcome
+}
+
+// checkSubtree applies rule's max-depth and report-new-keys settings to the
+// value just overlaid at p, returning a warning for each that found
+// something. Pruning edits the value in result in place.
+func checkSubtree(handler format.Handler, managed, result any, p path.Path, rule Rule) []string {
+	if rule.MaxDepth == 0 && !rule.ReportNewKeys {
+		return nil
+	}
+	val, ok := handler.GetPath(result, p)
+	if !ok {
+		return nil
+	}
+
+	var warnings []string
+	if rule.MaxDepth > 0 {
+		if pruned := prune(val, p.Segments(), rule.MaxDepth); len(pruned) > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s: dropped content deeper than max-depth=%d: %s",
+				p, rule.MaxDepth, strings.Join(pruned, ", ")))
+		}
+	}
+	if rule.ReportNewKeys {
+		def, _ := handler.GetPath(managed, p)
+		if added := newKeys(def, val, p.Segments()); len(added) > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s: keys not in managed: %s", p, strings.Join(added, ", ")))
+		}
+	}
+	return warnings
+}
+
+// prune empties every container depth levels below v, whose path is keys,
+// and returns the paths of the entries it dropped. Maps and arrays each
+// count as a level.
+func prune(v any, keys []string, depth int) []string {
+	var pruned []string
+	visit := func(k string, child any, set func(any)) {
+		childKeys := append(append([]string{}, keys...), k)
+		if depth > 1 {
+			pruned = append(pruned, prune(child, childKeys, depth-1)...)
+			return
+		}
+		if om := format.ToOrderedMapPtr(child); om != nil && len(om.Keys()) > 0 {
+			for _, ck := range om.Keys() {
+				pruned = append(pruned, path.NewArrayPath(append(append([]string{}, childKeys...), ck)).String())
+			}
+			set(orderedmap.New())
+		} else if arr, ok := child.([]any); ok && len(arr) > 0 {
+			for i := range arr {
+				pruned = append(pruned, path.NewArrayPath(append(append([]string{}, childKeys...), strconv.Itoa(i))).String())
+			}
+			set([]any{})
+		}
+	}
+
+	if om := format.ToOrderedMapPtr(v); om != nil {
+		for _, k := range om.Keys() {
+			child, _ := om.Get(k)
+			visit(k, child, func(empty any) { om.Set(k, empty) })
+		}
+	} else if arr, ok := v.([]any); ok {
+		for i := range arr {
+			visit(strconv.Itoa(i), arr[i], func(empty any) { arr[i] = empty })
+		}
+	}
+	return pruned
+}
+
+// newKeys returns the paths of keys in current's maps that managed's maps
+// at the same place don't have. Keys under a new key aren't listed again,
+// and arrays aren't searched.
+func newKeys(managed, current any, keys []string) []string {
+	cur := format.ToOrderedMapPtr(current)
+	if cur == nil {
+		return nil
+	}
+	def := format.ToOrderedMapPtr(managed)
+
+	var added []string
+	for _, k := range cur.Keys() {
+		childKeys := append(append([]string{}, keys...), k)
+		var defVal any
+		exists := false
+		if def != nil {
+			defVal, exists = def.Get(k)
+		}
+		if !exists {
+			added = append(added, path.NewArrayPath(childKeys).String())
+			continue
+		}
+		v, _ := cur.Get(k)
+		added = append(added, newKeys(defVal, v, childKeys)...)
+	}
+	return added
+}
+
+// mergeSection combines the managed and current sections at p key by key
+// and writes the combined section to result.
+func mergeSection(handler format.Handler, result any, p path.Path, managed, current *orderedmap.OrderedMap) Outcome {
+	outcome := Outcome{Path: p, Rule: p, Strategy: StrategySectionMerge}
+
+	combined := deepCopy(managed).(*orderedmap.OrderedMap)
+	kept := 0
+	for _, k := range managed.Keys() {
+		if _, ok := current.Get(k); !ok {
+			kept++
+		}
+	}
+	for _, k := range current.Keys() {
+		v, _ := current.Get(k)
+		combined.Set(k, deepCopy(v))
+	}
+
+	if err := handler.SetPath(result, p, combined); err != nil {
+		outcome.Summary = fmt.Sprintf("skipped: %v", err)
+		return outcome
+	}
+
+	outcome.Applied = true
+	outcome.Summary = fmt.Sprintf("took %d keys from current, kept %d managed-only keys", len(current.Keys()), kept)
+	return outcome
+}
+
+// mergeArrays combines the managed and current arrays at p using mode and
+// writes the combined array to result.
+func mergeArrays(handler format.Handler, result any, p path.Path, managed, current []any, mode string) Outcome {
+	outcome := Outcome{Path: p, Rule: p, Strategy: mode}
+
+	var combined []any
+	switch mode {
+	case ArrayAppend:
+		combined = append(append([]any{}, managed...), without(current, managed)...)
+	case ArrayPrepend:
+		combined = append(without(current, managed), managed...)
+	case ArrayUnion:
+		for _, elem := range append(append([]any{}, managed...), current...) {
+			if !containsValue(combined, elem) {
+				combined = append(combined, elem)
+			}
+		}
+	default:
+		outcome.Summary = fmt.Sprintf("skipped: unknown array merge mode %q", mode)
+		return outcome
+	}
+
+	if err := handler.SetPath(result, p, combined); err != nil {
+		outcome.Summary = fmt.Sprintf("skipped: %v", err)
+		return outcome
+	}
+
+	outcome.Applied = true
+	if added := len(combined) - len(managed); added > 0 {
+		outcome.Summary = fmt.Sprintf("%s %s from current", arrayVerbs[mode], plural(added, "new element"))
+	} else {
+		outcome.Summary = "no new elements in current"
+	}
+	return outcome
+}
+
+// arrayVerbs describes each array merge mode in outcome summaries.
+var arrayVerbs = map[string]string{
+	ArrayAppend:  "appended",
+	ArrayPrepend: "prepended",
+	ArrayUnion:   "merged",
+}
+
+// without returns the elements of from, removing one occurrence for each
+// equal element of remove.
+func without(from, remove []any) []any {
+	pending := append([]any{}, remove...)
+	var result []any
+	for _, elem := range from {
+		matched := false
+		for i, r := range pending {
+			if reflect.DeepEqual(elem, r) {
+				pending = append(pending[:i], pending[i+1:]...)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			result = append(result, elem)
+		}
+	}
+	return result
+}
+
+// containsValue reports whether arr contains an element deeply equal to v.
+func containsValue(arr []any, v any) bool {
+	for _, elem := range arr {
+		if reflect.DeepEqual(elem, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// describe returns a short human-readable description of a value's shape.
+func describe(v any) string {
+	if om := format.ToOrderedMapPtr(v); om != nil {
+		return "object (" + plural(len(om.Keys()), "key") + ")"
+	}
+	if arr, ok := v.([]any); ok {
+		return "array (" + plural(len(arr), "element") + ")"
+	}
+	return "value"
+}
+
+// plural formats a count with a singular or plural noun.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
 // deepCopy creates a deep copy of a value.
 // Works with ordered maps and slices typically found in JSON structures.
 func deepCopy(v any) any {
@@ -69,6 +687,16 @@ func deepCopy(v any) any {
 	}
 }
 
+// isContainer reports whether v is a map or an array, which paths can
+// address, rather than a bare value.
+func isContainer(v any) bool {
+	if format.ToOrderedMapPtr(v) != nil {
+		return true
+	}
+	_, ok := v.([]any)
+	return ok
+}
+
 // isNilValue checks if v is nil, including typed nil pointers inside interfaces.
 func isNilValue(v any) bool {
 	if v == nil {
@@ -1,10 +1,16 @@
 package merge
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/ini"
 	"github.com/thirteen37/chezmoi-split/internal/format/json"
+	"github.com/thirteen37/chezmoi-split/internal/format/yaml"
 	"github.com/thirteen37/chezmoi-split/internal/path"
 )
 
@@ -173,3 +179,841 @@ func TestMerge_PreservesOrder(t *testing.T) {
 		t.Errorf("Merge() apple = %v, want a2", apple)
 	}
 }
+
+func TestMergeWithReport(t *testing.T) {
+	handler := json.New()
+
+	managed := om(
+		"theme", "dark",
+		"plugins", []any{"a"},
+		"font", om("size", 12.0),
+	)
+	current := om(
+		"theme", "light",
+		"plugins", []any{"a", "b", "c"},
+		"extra", om("k", "v"),
+	)
+	paths := []path.Path{
+		path.NewArrayPath([]string{"theme"}),
+		path.NewArrayPath([]string{"plugins"}),
+		path.NewArrayPath([]string{"font", "size"}),
+		path.NewArrayPath([]string{"extra"}),
+	}
+
+	_, report := MergeWithReport(handler, managed, current, paths)
+
+	want := []struct {
+		applied bool
+		line    string
+	}{
+		{true, `["theme"]: overlay: replaced value with value`},
+		{true, `["plugins"]: overlay: replaced array (1 element) with array (3 elements)`},
+		{false, `["font","size"]: overlay: not found in current, kept managed value`},
+		{true, `["extra"]: overlay: added object (1 key) from current`},
+	}
+
+	if len(report.Outcomes) != len(want) {
+		t.Fatalf("got %d outcomes, want %d", len(report.Outcomes), len(want))
+	}
+	for i, w := range want {
+		got := report.Outcomes[i]
+		if got.Applied != w.applied {
+			t.Errorf("outcome[%d].Applied = %v, want %v", i, got.Applied, w.applied)
+		}
+		if got.String() != w.line {
+			t.Errorf("outcome[%d] = %q, want %q", i, got.String(), w.line)
+		}
+	}
+}
+
+func TestMergeWithReport_NoCurrent(t *testing.T) {
+	handler := json.New()
+
+	managed := om("key", "managed")
+	paths := []path.Path{path.NewArrayPath([]string{"key"})}
+
+	_, report := MergeWithReport(handler, managed, nil, paths)
+
+	if len(report.Outcomes) != 1 {
+		t.Fatalf("got %d outcomes, want 1", len(report.Outcomes))
+	}
+	if report.Outcomes[0].Applied {
+		t.Error("outcome should not be applied without current config")
+	}
+	if report.Outcomes[0].Strategy != StrategyOverlay {
+		t.Errorf("Strategy = %q, want %q", report.Outcomes[0].Strategy, StrategyOverlay)
+	}
+}
+
+func TestMerge_RecursiveWildcard(t *testing.T) {
+	handler := json.New()
+
+	managed := om(
+		"telemetry", false,
+		"editor", om("telemetry", false, "font", "managed"),
+		"git", om("nested", om("telemetry", false)),
+	)
+	current := om(
+		"telemetry", true,
+		"editor", om("telemetry", "limited", "font", "current"),
+		"git", om("nested", om("telemetry", "all")),
+		"extra", om("telemetry", "new"),
+	)
+	paths := []path.Path{path.NewArrayPath([]string{"**", "telemetry"})}
+
+	result, report := MergeWithReport(handler, managed, current, paths)
+
+	// Each match keeps its own value from current
+	want := map[string]any{
+		`["telemetry"]`:                true,
+		`["editor","telemetry"]`:       "limited",
+		`["git","nested","telemetry"]`: "all",
+		`["extra","telemetry"]`:        "new",
+	}
+	for p, w := range want {
+		segs, err := path.ParseArrayPath(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := handler.GetPath(result, segs)
+		if got != w {
+			t.Errorf("%s = %v, want %v", p, got, w)
+		}
+	}
+	font, _ := handler.GetPath(result, path.NewArrayPath([]string{"editor", "font"}))
+	if font != "managed" {
+		t.Errorf("editor.font = %v, want managed", font)
+	}
+
+	if len(report.Outcomes) != len(want) {
+		t.Errorf("got %d outcomes, want %d (one per match)", len(report.Outcomes), len(want))
+	}
+}
+
+func TestMerge_WildcardMatchesEachKey(t *testing.T) {
+	handler := json.New()
+
+	managed := om("servers", om(
+		"a", om("host", "a.example.com", "token", "managed"),
+		"b", om("host", "b.example.com", "token", "managed"),
+		"c", om("host", "c.example.com", "token", "managed"),
+	))
+	current := om("servers", om(
+		"a", om("host", "old", "token", "token-a"),
+		"b", om("host", "old", "token", "token-b"),
+	))
+	paths := []path.Path{path.NewArrayPath([]string{"servers", "*", "token"})}
+
+	result, report := MergeWithReport(handler, managed, current, paths)
+
+	// Each server keeps its own token; servers missing from current keep managed
+	want := om("servers", om(
+		"a", om("host", "a.example.com", "token", "token-a"),
+		"b", om("host", "b.example.com", "token", "token-b"),
+		"c", om("host", "c.example.com", "token", "managed"),
+	))
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %v, want %v", result, want)
+	}
+
+	if len(report.Outcomes) != 2 {
+		t.Fatalf("got %d outcomes, want one per match: %v", len(report.Outcomes), report.Outcomes)
+	}
+	for _, o := range report.Outcomes {
+		if !o.Applied || o.Rule.String() != `["servers","*","token"]` {
+			t.Errorf("outcome %v: want applied with the wildcard rule", o)
+		}
+	}
+}
+
+// TestMerge_MultipleWildcardsBindPerMatch checks that a rule with two
+// wildcards binds both to the keys of each match: every value goes back
+// exactly where it was found in current, never into other combinations.
+func TestMerge_MultipleWildcardsBindPerMatch(t *testing.T) {
+	rule := path.NewArrayPath([]string{"profiles", "*", "keybindings", "*"})
+
+	for _, tt := range []struct {
+		name    string
+		handler format.Handler
+	}{
+		{"json", json.New()},
+		{"yaml", yaml.New()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			managed := om("profiles", om(
+				"work", om("keybindings", om("copy", "m1", "paste", "m2"), "font", "mono"),
+				"home", om("keybindings", om("copy", "m3", "paste", "m4", "quit", "m5")),
+			))
+			current := om("profiles", om(
+				"home", om("keybindings", om("paste", "home-paste", "copy", "home-copy")),
+				"work", om("keybindings", om("copy", "work-copy", "paste", "work-paste")),
+			))
+
+			result, report := MergeWithReport(tt.handler, managed, current, []path.Path{rule})
+
+			// All four current values land where they came from; home's quit,
+			// which current lacks, keeps its managed default
+			want := om("profiles", om(
+				"work", om("keybindings", om("copy", "work-copy", "paste", "work-paste"), "font", "mono"),
+				"home", om("keybindings", om("copy", "home-copy", "paste", "home-paste", "quit", "m5")),
+			))
+			if !reflect.DeepEqual(result, want) {
+				t.Errorf("result = %v, want %v", result, want)
+			}
+
+			var got []string
+			for _, o := range report.Outcomes {
+				if !o.Applied || o.Rule.String() != rule.String() {
+					t.Errorf("outcome %v: want applied with the wildcard rule", o)
+				}
+				got = append(got, o.Path.String())
+			}
+			wantPaths := []string{
+				`["profiles","home","keybindings","paste"]`,
+				`["profiles","home","keybindings","copy"]`,
+				`["profiles","work","keybindings","copy"]`,
+				`["profiles","work","keybindings","paste"]`,
+			}
+			if !reflect.DeepEqual(got, wantPaths) {
+				t.Errorf("outcome paths = %q, want %q", got, wantPaths)
+			}
+		})
+	}
+}
+
+// TestMerge_MultipleWildcardsOverArrays binds wildcards to array indexes
+// the same way: each element keeps its own values.
+func TestMerge_MultipleWildcardsOverArrays(t *testing.T) {
+	handler := json.New()
+	rule := path.NewArrayPath([]string{"profiles", "*", "keys", "*", "action"})
+
+	managed := om("profiles", []any{
+		om("keys", []any{om("key", "a", "action", "m1"), om("key", "b", "action", "m2")}),
+		om("keys", []any{om("key", "c", "action", "m3"), om("key", "d", "action", "m4")}),
+	})
+	current := om("profiles", []any{
+		om("keys", []any{om("action", "c1"), om("action", "c2")}),
+		om("keys", []any{om("action", "c3"), om("action", "c4")}),
+	})
+
+	result, _ := MergeWithReport(handler, managed, current, []path.Path{rule})
+
+	want := om("profiles", []any{
+		om("keys", []any{om("key", "a", "action", "c1"), om("key", "b", "action", "c2")}),
+		om("keys", []any{om("key", "c", "action", "c3"), om("key", "d", "action", "c4")}),
+	})
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %v, want %v", result, want)
+	}
+}
+
+func TestMerge_RecursiveWildcard_NoMatch(t *testing.T) {
+	handler := json.New()
+
+	managed := om("key", "managed")
+	current := om("key", "current")
+	paths := []path.Path{path.NewArrayPath([]string{"**", "telemetry"})}
+
+	result, report := MergeWithReport(handler, managed, current, paths)
+
+	val, _ := handler.GetPath(result, path.NewArrayPath([]string{"key"}))
+	if val != "managed" {
+		t.Errorf("key = %v, want managed", val)
+	}
+	if len(report.Outcomes) != 1 || report.Outcomes[0].Applied {
+		t.Errorf("want one unapplied outcome, got %v", report.Outcomes)
+	}
+}
+
+func TestMergeWithOptions_ArrayMerge(t *testing.T) {
+	handler := json.New()
+	paths := []path.Path{path.NewArrayPath([]string{"plugins"})}
+
+	tests := []struct {
+		mode    string
+		managed []any
+		current []any
+		want    []any
+		summary string
+	}{
+		{
+			mode:    ArrayReplace,
+			managed: []any{"a", "b"},
+			current: []any{"c"},
+			want:    []any{"c"},
+			summary: "replaced array (2 elements) with array (1 element)",
+		},
+		{
+			mode:    ArrayAppend,
+			managed: []any{"a", "b"},
+			current: []any{"b", "c", "c"},
+			want:    []any{"a", "b", "c", "c"},
+			summary: "appended 2 new elements from current",
+		},
+		{
+			mode:    ArrayPrepend,
+			managed: []any{"a", "b"},
+			current: []any{"c", "a"},
+			want:    []any{"c", "a", "b"},
+			summary: "prepended 1 new element from current",
+		},
+		{
+			mode:    ArrayUnion,
+			managed: []any{"b", "a"},
+			current: []any{"a", "c", "c", 1.0},
+			want:    []any{"b", "a", "c", 1.0},
+			summary: "merged 2 new elements from current",
+		},
+		{
+			mode:    ArrayUnion,
+			managed: []any{om("name", "x")},
+			current: []any{om("name", "x"), om("name", "y")},
+			want:    []any{om("name", "x"), om("name", "y")},
+			summary: "merged 1 new element from current",
+		},
+		{
+			mode:    ArrayUnion,
+			managed: []any{"a"},
+			current: []any{"a"},
+			want:    []any{"a"},
+			summary: "no new elements in current",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+tt.summary, func(t *testing.T) {
+			managed := om("plugins", tt.managed)
+			current := om("plugins", tt.current)
+
+			result, report := MergeWithOptions(handler, managed, current, paths, Options{ArrayMerge: tt.mode})
+
+			got, _ := handler.GetPath(result, paths[0])
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("plugins = %v, want %v", got, tt.want)
+			}
+			if s := report.Outcomes[0].Summary; s != tt.summary {
+				t.Errorf("summary = %q, want %q", s, tt.summary)
+			}
+
+			// Merging again with the output as current must not grow the array
+			again, _ := MergeWithOptions(handler, managed, result, paths, Options{ArrayMerge: tt.mode})
+			if got2, _ := handler.GetPath(again, paths[0]); !reflect.DeepEqual(got2, tt.want) {
+				t.Errorf("second merge plugins = %v, want %v (should converge)", got2, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeWithOptions_RuleArrayMerge(t *testing.T) {
+	handler := json.New()
+	plugins := path.NewArrayPath([]string{"plugins"})
+	hosts := path.NewArrayPath([]string{"hosts"})
+	themes := path.NewArrayPath([]string{"themes"})
+	paths := []path.Path{plugins, hosts, themes}
+
+	managed := om("plugins", []any{"a", "b"}, "hosts", []any{"x"}, "themes", []any{"dark"})
+	current := om("plugins", []any{"b", "c"}, "hosts", []any{"x", "y"}, "themes", []any{"light"})
+
+	result, report := MergeWithOptions(handler, managed, current, paths, Options{
+		ArrayMerge: ArrayReplace,
+		Rules: map[string]Rule{
+			plugins.String(): {ArrayMerge: ArrayAppend},
+			hosts.String():   {ArrayMerge: ArrayUnion},
+		},
+	})
+
+	tests := []struct {
+		p        path.Path
+		want     []any
+		strategy string
+	}{
+		{plugins, []any{"a", "b", "c"}, ArrayAppend},
+		{hosts, []any{"x", "y"}, ArrayUnion},
+		{themes, []any{"light"}, StrategyOverlay},
+	}
+	for i, tt := range tests {
+		got, _ := handler.GetPath(result, tt.p)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.p, got, tt.want)
+		}
+		if s := report.Outcomes[i].Strategy; s != tt.strategy {
+			t.Errorf("%s strategy = %q, want %q", tt.p, s, tt.strategy)
+		}
+	}
+}
+
+func TestMergeWithOptions_RuleMaxDepth(t *testing.T) {
+	handler := json.New()
+	servers := path.NewArrayPath([]string{"context_servers"})
+	managed := om("context_servers", om("github", om("enabled", true)))
+	current := om("context_servers", om(
+		"github", om("enabled", false, "settings", om("token", "x")),
+		"local", om("args", []any{"a", "b"}),
+		"flag", true,
+	))
+
+	result, report := MergeWithOptions(handler, managed, current, []path.Path{servers}, Options{
+		Rules: map[string]Rule{servers.String(): {MaxDepth: 2}},
+	})
+
+	want := om(
+		"github", om("enabled", false, "settings", om()),
+		"local", om("args", []any{}),
+		"flag", true,
+	)
+	if got, _ := handler.GetPath(result, servers); !reflect.DeepEqual(got, want) {
+		t.Errorf("context_servers = %v, want %v", got, want)
+	}
+	wantWarnings := []string{`["context_servers"]: dropped content deeper than max-depth=2: ` +
+		`["context_servers","github","settings","token"], ["context_servers","local","args","0"], ["context_servers","local","args","1"]`}
+	if !reflect.DeepEqual(report.Warnings, wantWarnings) {
+		t.Errorf("Warnings = %q, want %q", report.Warnings, wantWarnings)
+	}
+
+	// Current itself is left alone
+	if token, ok := handler.GetPath(current, path.NewArrayPath([]string{"context_servers", "github", "settings", "token"})); !ok || token != "x" {
+		t.Errorf("current was pruned: token = %v, %v", token, ok)
+	}
+}
+
+func TestMergeWithOptions_RuleReportNewKeys(t *testing.T) {
+	handler := json.New()
+	servers := path.NewArrayPath([]string{"context_servers"})
+	managed := om("context_servers", om("github", om("enabled", true)))
+	current := om("context_servers", om(
+		"github", om("enabled", false, "settings", om("token", "x")),
+		"local", om("command", "mcp"),
+	))
+
+	result, report := MergeWithOptions(handler, managed, current, []path.Path{servers}, Options{
+		Rules: map[string]Rule{servers.String(): {ReportNewKeys: true}},
+	})
+
+	// Reporting doesn't limit what is kept
+	if got, _ := handler.GetPath(result, path.NewArrayPath([]string{"context_servers", "github", "settings", "token"})); got != "x" {
+		t.Errorf("token = %v, want x", got)
+	}
+	wantWarnings := []string{`["context_servers"]: keys not in managed: ["context_servers","github","settings"], ["context_servers","local"]`}
+	if !reflect.DeepEqual(report.Warnings, wantWarnings) {
+		t.Errorf("Warnings = %q, want %q", report.Warnings, wantWarnings)
+	}
+
+	// Nothing new, nothing reported
+	_, report = MergeWithOptions(handler, managed, managed, []path.Path{servers}, Options{
+		Rules: map[string]Rule{servers.String(): {ReportNewKeys: true}},
+	})
+	if len(report.Warnings) != 0 {
+		t.Errorf("Warnings = %q, want none", report.Warnings)
+	}
+}
+
+func TestMergeWithOptions_BareManagedRoot(t *testing.T) {
+	handler := json.New()
+	paths := []path.Path{path.NewArrayPath([]string{"theme"})}
+	current := om("theme", "light")
+
+	for _, managed := range []any{nil, true, float64(42), "text"} {
+		result, report := MergeWithOptions(handler, managed, current, paths, Options{
+			PreserveIfMissing: []path.Path{path.NewArrayPath([]string{"font"})},
+			KeepExtra:         true,
+		})
+		if result != managed {
+			t.Errorf("managed %#v: result = %#v, want managed unchanged", managed, result)
+		}
+		if len(report.Outcomes) != 2 {
+			t.Fatalf("managed %#v: Outcomes = %v, want one per path", managed, report.Outcomes)
+		}
+		for _, o := range report.Outcomes {
+			if o.Applied || o.Summary != "managed root is a bare value, kept managed value" {
+				t.Errorf("managed %#v: outcome = %v", managed, o)
+			}
+		}
+		if len(report.Warnings) != 1 {
+			t.Errorf("managed %#v: Warnings = %q, want one", managed, report.Warnings)
+		}
+	}
+
+	// Without ignore rules there is nothing to warn about
+	_, report := MergeWithOptions(handler, true, current, nil, Options{})
+	if len(report.Warnings) != 0 {
+		t.Errorf("Warnings = %q, want none", report.Warnings)
+	}
+
+	// A bare current value has no paths either, so managed is kept
+	managed := om("theme", "dark")
+	result, _ := MergeWithOptions(handler, managed, float64(42), paths, Options{})
+	if got, _ := handler.GetPath(result, paths[0]); got != "dark" {
+		t.Errorf("theme = %v, want dark", got)
+	}
+}
+
+func TestMergeWithOptions_ArrayMergeNonArray(t *testing.T) {
+	handler := json.New()
+	paths := []path.Path{path.NewArrayPath([]string{"plugins"})}
+
+	// Managed holds a scalar, so current replaces it as usual
+	result, report := MergeWithOptions(handler, om("plugins", "none"), om("plugins", []any{"a"}), paths,
+		Options{ArrayMerge: ArrayUnion})
+
+	got, _ := handler.GetPath(result, paths[0])
+	if !reflect.DeepEqual(got, []any{"a"}) {
+		t.Errorf("plugins = %v, want [a]", got)
+	}
+	if report.Outcomes[0].Strategy != StrategyOverlay {
+		t.Errorf("Strategy = %q, want %q", report.Outcomes[0].Strategy, StrategyOverlay)
+	}
+}
+
+func TestMergeWithOptions_BaseCurrent(t *testing.T) {
+	handler := json.New()
+	managed := om(
+		"theme", "dark",
+		"editor", om("fontSize", 14.0, "tabSize", 2.0),
+		"plugins", []any{"git"},
+	)
+	current := om(
+		"theme", "light",
+		"recentFiles", []any{"a.txt"},
+		"editor", om("fontSize", 12.0, "lastColumn", 80.0),
+		"plugins", []any{"lsp", "copilot"},
+		"window", om("width", 800.0),
+	)
+	paths := []path.Path{path.NewArrayPath([]string{"window", "width"})}
+
+	result, report := MergeWithOptions(handler, managed, current, paths, Options{Base: BaseCurrent})
+
+	// App-written keys survive; managed keys are enforced
+	want := om(
+		"theme", "dark",
+		"recentFiles", []any{"a.txt"},
+		"editor", om("fontSize", 14.0, "lastColumn", 80.0, "tabSize", 2.0),
+		"plugins", []any{"git"},
+		"window", om("width", 800.0),
+	)
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %v, want %v", result, want)
+	}
+	if len(report.Outcomes) != 1 || !report.Outcomes[0].Applied {
+		t.Errorf("want one applied outcome, got %v", report.Outcomes)
+	}
+
+	// Neither input is modified
+	if v, _ := current.Get("theme"); v != "light" {
+		t.Errorf("current.theme = %v, want light", v)
+	}
+	if _, ok := managed.Get("recentFiles"); ok {
+		t.Error("managed gained recentFiles")
+	}
+
+	// Merging again with the output as current converges
+	again, _ := MergeWithOptions(handler, managed, result, paths, Options{Base: BaseCurrent})
+	if !reflect.DeepEqual(again, want) {
+		t.Errorf("second merge = %v, want %v", again, want)
+	}
+}
+
+func TestMergeWithOptions_BaseCurrentReplacesShape(t *testing.T) {
+	handler := json.New()
+
+	// A managed map replaces a current scalar, and a managed scalar replaces a current map
+	result, _ := MergeWithOptions(handler,
+		om("a", om("x", 1.0), "b", "flat"),
+		om("a", "scalar", "b", om("y", 2.0), "c", true),
+		nil, Options{Base: BaseCurrent})
+
+	want := om("a", om("x", 1.0), "b", "flat", "c", true)
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %v, want %v", result, want)
+	}
+
+	// Without a current file the managed config is used as is
+	result, _ = MergeWithOptions(handler, om("a", 1.0), nil, nil, Options{Base: BaseCurrent})
+	if !reflect.DeepEqual(result, om("a", 1.0)) {
+		t.Errorf("result without current = %v, want managed", result)
+	}
+}
+
+func TestMergeWithOptions_KeepExtra(t *testing.T) {
+	handler := json.New()
+	managed := om("Host a", om("User", "managed"), "Host b", om("User", "managed"))
+	current := om("Host extra", om("User", "x"), "Host a", om("User", "current", "Port", "22"))
+	paths := []path.Path{path.NewArrayPath([]string{"Host a", "User"})}
+
+	// Only entries missing from the result are added, after the managed
+	// ones; shared keys keep managed values apart from ignored paths
+	result, _ := MergeWithOptions(handler, managed, current, paths, Options{KeepExtra: true})
+	want := om(
+		"Host a", om("User", "current", "Port", "22"),
+		"Host b", om("User", "managed"),
+		"Host extra", om("User", "x"),
+	)
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %v, want %v", result, want)
+	}
+
+	// The extra entry is copied, not shared with current
+	extra, _ := result.(*orderedmap.OrderedMap).Get("Host extra")
+	extra.(*orderedmap.OrderedMap).Set("User", "changed")
+	if v, _ := handler.GetPath(current, path.NewArrayPath([]string{"Host extra", "User"})); v != "x" {
+		t.Errorf("current was modified: User = %v", v)
+	}
+
+	// Only one level inside shared maps is filled in
+	result, _ = MergeWithOptions(handler, om("a", om("b", om("x", 1.0))), om("a", om("b", om("y", 2.0))), nil, Options{KeepExtra: true})
+	if want := om("a", om("b", om("x", 1.0))); !reflect.DeepEqual(result, want) {
+		t.Errorf("nested result = %v, want %v", result, want)
+	}
+
+	// Without the option, extra entries are dropped
+	result, _ = MergeWithOptions(handler, managed, current, paths, Options{})
+	if _, ok := result.(*orderedmap.OrderedMap).Get("Host extra"); ok {
+		t.Error("extra entry kept without KeepExtra")
+	}
+}
+
+func TestMergeWithOptions_RootPath(t *testing.T) {
+	handler := json.New()
+	root := path.NewArrayPath([]string{})
+	managed := om("theme", "dark", "window", om())
+
+	// Without a current file, managed is written
+	result, report := MergeWithOptions(handler, managed, nil, []path.Path{root}, Options{})
+	if !reflect.DeepEqual(result, managed) {
+		t.Errorf("result = %v, want managed %v", result, managed)
+	}
+	if len(report.Outcomes) != 1 || report.Outcomes[0].Applied {
+		t.Errorf("outcomes = %v, want one unapplied outcome", report.Outcomes)
+	}
+
+	// With one, the result is a copy of it
+	current := om("window", om("w", 1.0), "recent", []any{"a"})
+	result, report = MergeWithOptions(handler, managed, current, []path.Path{root}, Options{})
+	if !reflect.DeepEqual(result, current) {
+		t.Errorf("result = %v, want current %v", result, current)
+	}
+	result.(*orderedmap.OrderedMap).Set("recent", nil)
+	if v, _ := current.Get("recent"); v == nil {
+		t.Error("result shares its maps with current")
+	}
+	if got := report.Outcomes[0].String(); got != "[]: overlay: replaced the whole config with current" {
+		t.Errorf("outcome = %q", got)
+	}
+}
+
+func TestMergeWithOptions_RootPathShadows(t *testing.T) {
+	handler := json.New()
+	managed := om("theme", "dark", "font", "Menlo")
+	current := om("theme", "light", "family", "Iosevka")
+	paths := []path.Path{path.NewArrayPath([]string{"theme"}), path.NewArrayPath([]string{})}
+	defaults := []path.Path{path.NewArrayPath([]string{"font"})}
+
+	tmpl, err := NewComputeTemplate("font", `{{ .current.family }}`)
+	if err != nil {
+		t.Fatalf("NewComputeTemplate() error = %v", err)
+	}
+	computes := []Compute{{Path: path.NewArrayPath([]string{"font"}), Template: tmpl, Requires: []path.Path{path.NewArrayPath([]string{"family"})}}}
+
+	result, report := MergeWithOptions(handler, managed, current, paths, Options{
+		PreserveIfMissing: defaults,
+		KeepExtra:         true,
+		Computes:          computes,
+	})
+	// The other rules add nothing, but a compute still sets its path
+	if want := om("theme", "light", "family", "Iosevka", "font", "Iosevka"); !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %v, want %v", result, want)
+	}
+
+	var got []string
+	for _, o := range report.Outcomes {
+		got = append(got, o.String())
+	}
+	want := []string{
+		"[]: overlay: replaced the whole config with current",
+		`["font"]: preserve-if-missing: covered by the document root rule, kept current value`,
+		`["theme"]: overlay: covered by the document root rule, kept current value`,
+		`["font"]: compute: computed from 1 value in current`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("outcomes =\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestMergeWithOptions_KeepRecords(t *testing.T) {
+	handler := json.New()
+	managed := om("id=a", om("id", "a", "on", true), "id=b", om("id", "b"))
+	current := om("id=b", om("id", "b", "seen", 3.0), "id=c", om("id", "c"))
+
+	// Records only current has follow the managed ones; shared records
+	// aren't filled in
+	result, _ := MergeWithOptions(handler, managed, current, nil, Options{KeepRecords: true})
+	want := om(
+		"id=a", om("id", "a", "on", true),
+		"id=b", om("id", "b"),
+		"id=c", om("id", "c"),
+	)
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %v, want %v", result, want)
+	}
+}
+
+func TestMergeWithOptions_PreserveIfMissing(t *testing.T) {
+	handler := json.New()
+	managed := om("theme", "dark", "plugins", []any{"git"}, "fontSize", 12.0)
+	defaults := []path.Path{
+		path.NewArrayPath([]string{"theme"}),
+		path.NewArrayPath([]string{"plugins"}),
+		path.NewArrayPath([]string{"fontSize"}),
+	}
+
+	// Values in current win as a whole, even with array-merge set; missing
+	// ones keep the managed default
+	current := om("theme", "light", "plugins", []any{"docker"})
+	result, report := MergeWithOptions(handler, managed, current, nil, Options{ArrayMerge: ArrayUnion, PreserveIfMissing: defaults})
+	want := om("theme", "light", "plugins", []any{"docker"}, "fontSize", 12.0)
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %v, want %v", result, want)
+	}
+	if len(report.Outcomes) != 3 {
+		t.Fatalf("got %d outcomes, want 3", len(report.Outcomes))
+	}
+	for _, outcome := range report.Outcomes {
+		if outcome.Strategy != StrategyPreserveIfMissing {
+			t.Errorf("outcome %s strategy = %q, want %q", outcome.Path, outcome.Strategy, StrategyPreserveIfMissing)
+		}
+	}
+
+	// A path that is also ignored follows the ignore rule, so array-merge applies
+	ignored := []path.Path{path.NewArrayPath([]string{"plugins"})}
+	result, report = MergeWithOptions(handler, managed, current, ignored, Options{ArrayMerge: ArrayUnion, PreserveIfMissing: defaults})
+	if v, _ := handler.GetPath(result, path.NewArrayPath([]string{"plugins"})); !reflect.DeepEqual(v, []any{"git", "docker"}) {
+		t.Errorf("plugins = %v, want the union", v)
+	}
+	if len(report.Outcomes) != 3 {
+		t.Errorf("got %d outcomes, want 3 (one per path)", len(report.Outcomes))
+	}
+
+	// Without a current config every default is kept
+	result, report = MergeWithOptions(handler, managed, nil, nil, Options{PreserveIfMissing: defaults})
+	if !reflect.DeepEqual(result, managed) {
+		t.Errorf("result = %v, want %v", result, managed)
+	}
+	if len(report.Outcomes) != 3 || report.Outcomes[0].Strategy != StrategyPreserveIfMissing {
+		t.Errorf("outcomes = %v, want 3 preserve-if-missing outcomes", report.Outcomes)
+	}
+}
+
+func TestMergeWithOptions_MergeSections(t *testing.T) {
+	handler := ini.New()
+	paths := []path.Path{path.NewArrayPath([]string{"database"})}
+
+	tests := []struct {
+		name    string
+		managed *orderedmap.OrderedMap
+		current *orderedmap.OrderedMap
+		want    *orderedmap.OrderedMap
+	}{
+		{
+			name:    "disjoint keys",
+			managed: om("host", "localhost", "port", "5432"),
+			current: om("password", "secret"),
+			want:    om("host", "localhost", "port", "5432", "password", "secret"),
+		},
+		{
+			name:    "overlapping keys keep managed order",
+			managed: om("host", "localhost", "port", "5432", "pool", "5"),
+			current: om("pool", "20", "password", "secret", "host", "db.internal"),
+			want:    om("host", "db.internal", "port", "5432", "pool", "20", "password", "secret"),
+		},
+		{
+			name:    "empty current section",
+			managed: om("host", "localhost"),
+			current: om(),
+			want:    om("host", "localhost"),
+		},
+		{
+			name:    "empty managed section",
+			managed: om(),
+			current: om("host", "db.internal"),
+			want:    om("host", "db.internal"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			managed := om("app", om("name", "demo"), "database", tt.managed)
+			current := om("database", tt.current)
+
+			result, report := MergeWithOptions(handler, managed, current, paths, Options{MergeSections: true})
+			want := om("app", om("name", "demo"), "database", tt.want)
+			if !reflect.DeepEqual(result, want) {
+				t.Errorf("result = %v, want %v", result, want)
+			}
+			if len(report.Outcomes) != 1 || report.Outcomes[0].Strategy != StrategySectionMerge || !report.Outcomes[0].Applied {
+				t.Errorf("want one applied section-merge outcome, got %v", report.Outcomes)
+			}
+		})
+	}
+
+	// Without the option, the current section replaces the managed one
+	result, _ := MergeWithOptions(handler,
+		om("database", om("host", "localhost", "port", "5432")),
+		om("database", om("password", "secret")),
+		paths, Options{})
+	if want := om("database", om("password", "secret")); !reflect.DeepEqual(result, want) {
+		t.Errorf("result without MergeSections = %v, want %v", result, want)
+	}
+}
+
+func TestMerge_YAMLMultiDocument(t *testing.T) {
+	handler := yaml.New()
+
+	managed, err := handler.Parse([]byte("name: a\n---\nreplicas: 1\n---\nextra: managed\n"), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(managed) error = %v", err)
+	}
+	// Current has fewer documents than managed
+	current, err := handler.Parse([]byte("name: old\n---\nreplicas: 4\n"), format.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Parse(current) error = %v", err)
+	}
+
+	paths := []path.Path{
+		path.NewArrayPath([]string{"1", "replicas"}),
+		path.NewArrayPath([]string{"2", "extra"}),
+	}
+	result := Merge(handler, managed, current, paths)
+
+	data, err := handler.Serialize(result, format.SerializeOptions{})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	want := "name: a\n---\nreplicas: 4\n---\nextra: managed\n"
+	if string(data) != want {
+		t.Errorf("Merge() = %q, want %q", string(data), want)
+	}
+}
+
+// BenchmarkMerge_WideObject merges a flat object with every key ignored.
+// Key lookups in orderedmap are hash-based, so time per key should stay
+// flat as the object widens.
+func BenchmarkMerge_WideObject(b *testing.B) {
+	handler := json.New()
+	for _, width := range []int{100, 1000, 10000} {
+		managed := orderedmap.New()
+		current := orderedmap.New()
+		paths := make([]path.Path, 0, width)
+		for i := 0; i < width; i++ {
+			key := fmt.Sprintf("key%05d", i)
+			managed.Set(key, om("value", float64(i), "enabled", true))
+			current.Set(key, om("value", float64(-i), "enabled", false))
+			paths = append(paths, path.NewArrayPath([]string{key, "value"}))
+		}
+
+		b.Run(fmt.Sprintf("width=%d", width), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				MergeWithOptions(handler, managed, current, paths, Options{})
+			}
+		})
+	}
+}
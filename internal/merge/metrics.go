@@ -0,0 +1,111 @@
+package merge
+
+import (
+	"fmt"
+
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// TreeMetrics describes the size and shape of a config tree.
+type TreeMetrics struct {
+	Keys     int // Map entries and array elements at every depth
+	MaxDepth int // Levels of nesting; 0 for an empty or bare root, 1 for a flat map
+	Bytes    int // Length of the tree serialized by the handler
+}
+
+// String formats the metrics as "N keys, depth D, B bytes".
+func (m TreeMetrics) String() string {
+	return fmt.Sprintf("%s, depth %d, %s", plural(m.Keys, "key"), m.MaxDepth, plural(m.Bytes, "byte"))
+}
+
+// RuleMetrics counts what a single rule took from current.
+type RuleMetrics struct {
+	Rule      path.Path
+	Matched   int // Paths where the rule wrote a value from current
+	Preserved int // Bytes of those values, each serialized on its own
+}
+
+// String formats the metrics as "<rule>: N paths, B bytes preserved".
+func (m RuleMetrics) String() string {
+	return fmt.Sprintf("%s: %s, %s preserved", m.Rule, plural(m.Matched, "path"), plural(m.Preserved, "byte"))
+}
+
+// Metrics holds the size figures of a merge, for troubleshooting slow runs
+// and bloated files.
+type Metrics struct {
+	Managed TreeMetrics
+	Current TreeMetrics
+	Result  TreeMetrics
+	Rules   []RuleMetrics // In the order the rules first appear in the report
+}
+
+// Measure fills in report.Metrics for a finished merge. It is a separate
+// pass over the trees, since serializing them costs more than the merge, so
+// callers only run it when the figures will be shown. A nil current counts
+// as empty.
+func Measure(handler format.Handler, managed, current, result any, report *Report) {
+	metrics := &Metrics{
+		Managed: measureTree(handler, managed),
+		Result:  measureTree(handler, result),
+	}
+	if !isNilValue(current) {
+		metrics.Current = measureTree(handler, current)
+	}
+
+	index := make(map[string]int)
+	for _, o := range report.Outcomes {
+		rule := o.Path
+		if o.Rule != nil {
+			rule = o.Rule
+		}
+		i, seen := index[rule.String()]
+		if !seen {
+			i = len(metrics.Rules)
+			index[rule.String()] = i
+			metrics.Rules = append(metrics.Rules, RuleMetrics{Rule: rule})
+		}
+		if !o.Applied {
+			continue
+		}
+		metrics.Rules[i].Matched++
+		if val, ok := handler.GetPath(result, o.Path); ok {
+			if data, err := format.SerializeSubtree(handler, o.Path, val); err == nil {
+				metrics.Rules[i].Preserved += len(data)
+			}
+		}
+	}
+	report.Metrics = metrics
+}
+
+// measureTree counts the keys and depth of tree and serializes it for its
+// size. A tree the handler can't serialize has a size of 0.
+func measureTree(handler format.Handler, tree any) TreeMetrics {
+	keys, depth := countKeys(tree)
+	m := TreeMetrics{Keys: keys, MaxDepth: depth}
+	if data, err := handler.Serialize(tree, format.SerializeOptions{}); err == nil {
+		m.Bytes = len(data)
+	}
+	return m
+}
+
+// countKeys returns the number of map entries and array elements in v, at
+// every depth, and how deeply they nest.
+func countKeys(v any) (keys, depth int) {
+	var children []any
+	if om := format.ToOrderedMapPtr(v); om != nil {
+		for _, k := range om.Keys() {
+			child, _ := om.Get(k)
+			children = append(children, child)
+		}
+	} else if arr, ok := v.([]any); ok {
+		children = arr
+	}
+
+	for _, child := range children {
+		childKeys, childDepth := countKeys(child)
+		keys += 1 + childKeys
+		depth = max(depth, 1+childDepth)
+	}
+	return keys, depth
+}
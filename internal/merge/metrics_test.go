@@ -0,0 +1,92 @@
+package merge
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/json"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+func TestCountKeys(t *testing.T) {
+	tests := []struct {
+		name      string
+		tree      any
+		wantKeys  int
+		wantDepth int
+	}{
+		{"bare value", "x", 0, 0},
+		{"empty map", om(), 0, 0},
+		{"flat map", om("a", 1.0, "b", 2.0, "c", 3.0), 3, 1},
+		{"nested maps", om("a", om("b", om("c", true)), "d", 1.0), 4, 3},
+		{"array elements", om("list", []any{1.0, 2.0, om("x", 1.0)}), 5, 3},
+		{"empty containers", om("m", om(), "a", []any{}), 2, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, depth := countKeys(tt.tree)
+			if keys != tt.wantKeys || depth != tt.wantDepth {
+				t.Errorf("countKeys() = %d keys, depth %d; want %d keys, depth %d", keys, depth, tt.wantKeys, tt.wantDepth)
+			}
+		})
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	handler := json.New()
+	managed := om("theme", "dark", "plugins", om("git", true))
+	current := om("theme", "light", "plugins", om("git", false, "docker", true), "extra", 1.0)
+	paths := []path.Path{
+		path.NewArrayPath([]string{"plugins", "*"}),
+		path.NewArrayPath([]string{"missing"}),
+	}
+
+	result, report := MergeWithOptions(handler, managed, current, paths, Options{})
+	if report.Metrics != nil {
+		t.Fatal("MergeWithOptions() filled in Metrics; only Measure should")
+	}
+	Measure(handler, managed, current, result, report)
+	m := report.Metrics
+
+	size := func(tree any) int {
+		data, err := handler.Serialize(tree, format.SerializeOptions{})
+		if err != nil {
+			t.Fatalf("Serialize() error = %v", err)
+		}
+		return len(data)
+	}
+	if want := (TreeMetrics{Keys: 3, MaxDepth: 2, Bytes: size(managed)}); m.Managed != want {
+		t.Errorf("Managed = %+v, want %+v", m.Managed, want)
+	}
+	if want := (TreeMetrics{Keys: 5, MaxDepth: 2, Bytes: size(current)}); m.Current != want {
+		t.Errorf("Current = %+v, want %+v", m.Current, want)
+	}
+	if want := (TreeMetrics{Keys: 4, MaxDepth: 2, Bytes: size(result)}); m.Result != want {
+		t.Errorf("Result = %+v, want %+v", m.Result, want)
+	}
+
+	// {"plugins": {"git": false}} and {"plugins": {"docker": true}}, with the
+	// JSON handler's two-space indent
+	wantRules := []RuleMetrics{
+		{Rule: paths[0], Matched: 2, Preserved: 40 + 42},
+		{Rule: paths[1]},
+	}
+	if !reflect.DeepEqual(m.Rules, wantRules) {
+		t.Errorf("Rules = %+v, want %+v", m.Rules, wantRules)
+	}
+	if got, want := m.Rules[0].String(), `["plugins","*"]: 2 paths, 82 bytes preserved`; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	// Without current, only the managed and result trees have a size
+	_, report = MergeWithOptions(handler, managed, nil, paths, Options{})
+	Measure(handler, managed, nil, managed, report)
+	if report.Metrics.Current != (TreeMetrics{}) || report.Metrics.Result != report.Metrics.Managed {
+		t.Errorf("Metrics = %+v, want an empty current and result equal to managed", report.Metrics)
+	}
+	if got, want := report.Metrics.Managed.String(), "3 keys, depth 2, 58 bytes"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
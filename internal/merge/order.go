@@ -0,0 +1,70 @@
+package merge
+
+import (
+	"sort"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+)
+
+// Key orders: how the keys of each map in the result are ordered.
+const (
+	OrderMerged   = "merged"   // Keys stay where the merge put them (default)
+	OrderTemplate = "template" // Every map follows managed's key order
+)
+
+// OrderModes lists the supported key orders.
+var OrderModes = []string{OrderMerged, OrderTemplate}
+
+// reorder sorts the keys of every map in result into the order of the map
+// at the same place in template. Keys template lacks keep their relative
+// order after the ones it has. Arrays are walked element by element, so an
+// element is ordered like the template element at the same index.
+func reorder(result, template any) {
+	if om := format.ToOrderedMapPtr(result); om != nil {
+		tm := format.ToOrderedMapPtr(template)
+		if tm == nil {
+			return
+		}
+		sortKeysLike(om, tm.Keys())
+		for _, k := range om.Keys() {
+			tv, ok := tm.Get(k)
+			if !ok {
+				continue
+			}
+			v, _ := om.Get(k)
+			reorder(v, tv)
+		}
+		return
+	}
+
+	arr, ok := result.([]any)
+	tarr, tok := template.([]any)
+	if !ok || !tok {
+		return
+	}
+	for i := range min(len(arr), len(tarr)) {
+		reorder(arr[i], tarr[i])
+	}
+}
+
+// sortKeysLike reorders om's keys to follow order. Keys not in order go
+// last, in the order om had them.
+func sortKeysLike(om *orderedmap.OrderedMap, order []string) {
+	rank := make(map[string]int, len(order))
+	for i, k := range order {
+		rank[k] = i
+	}
+	om.SortKeys(func(keys []string) {
+		sort.SliceStable(keys, func(i, j int) bool {
+			ri, iok := rank[keys[i]]
+			rj, jok := rank[keys[j]]
+			switch {
+			case iok && jok:
+				return ri < rj
+			default:
+				return iok && !jok
+			}
+		})
+	})
+}
@@ -0,0 +1,91 @@
+package merge
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/thirteen37/chezmoi-split/internal/format/json"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+func TestMergeWithOptions_OrderTemplate(t *testing.T) {
+	handler := json.New()
+	managed := om(
+		"theme", "dark",
+		"editor", om("font", "mono", "size", 12.0, "tabs", false),
+		"panels", []any{om("name", "files", "width", 200.0)},
+		"window", om("x", 0.0, "y", 0.0),
+	)
+	paths := []path.Path{
+		path.NewArrayPath([]string{"window"}),
+		path.NewArrayPath([]string{"editor", "size"}),
+		path.NewArrayPath([]string{"panels"}),
+	}
+
+	// The same settings, written in different orders by the app
+	currents := []any{
+		om(
+			"window", om("y", 5.0, "x", 10.0, "maximized", true),
+			"editor", om("size", 14.0, "font", "mono"),
+			"panels", []any{om("width", 250.0, "name", "files", "open", true)},
+			"recent", []any{"a"},
+		),
+		om(
+			"recent", []any{"a"},
+			"panels", []any{om("open", true, "name", "files", "width", 250.0)},
+			"editor", om("font", "mono", "size", 14.0),
+			"window", om("maximized", true, "x", 10.0, "y", 5.0),
+		),
+		om(
+			"editor", om("size", 14.0),
+			"window", om("x", 10.0, "maximized", true, "y", 5.0),
+			"recent", []any{"a"},
+			"panels", []any{om("name", "files", "width", 250.0, "open", true)},
+		),
+	}
+
+	// Template keys come first in template order; the key the template
+	// lacks ("maximized", "open", "recent") follows
+	want := om(
+		"theme", "dark",
+		"editor", om("font", "mono", "size", 14.0, "tabs", false),
+		"panels", []any{om("name", "files", "width", 250.0, "open", true)},
+		"window", om("x", 10.0, "y", 5.0, "maximized", true),
+		"recent", []any{"a"},
+	)
+	for i, current := range currents {
+		result, _ := MergeWithOptions(handler, managed, current, paths, Options{KeepExtra: true, Order: OrderTemplate})
+		if !reflect.DeepEqual(result, want) {
+			t.Errorf("current %d: result = %v, want %v", i, result, want)
+		}
+	}
+
+	// Without it, the ignored subtree keeps the app's order
+	result, _ := MergeWithOptions(handler, managed, currents[1], paths, Options{})
+	got, _ := handler.GetPath(result, path.NewArrayPath([]string{"window"}))
+	if want := om("maximized", true, "x", 10.0, "y", 5.0); !reflect.DeepEqual(got, want) {
+		t.Errorf("window = %v, want %v", got, want)
+	}
+}
+
+func TestMergeWithOptions_OrderTemplateBase(t *testing.T) {
+	handler := json.New()
+	managed := om("a", 1.0, "b", 2.0)
+	current := om("x", 0.0, "b", 3.0, "y", 0.0, "a", 4.0)
+
+	// Rebasing onto current starts from the app's order; the template's
+	// order still wins, and the app's keys keep theirs
+	result, _ := MergeWithOptions(handler, managed, current, nil, Options{Base: BaseCurrent, Order: OrderTemplate})
+	want := om("a", 1.0, "b", 2.0, "x", 0.0, "y", 0.0)
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %v, want %v", result, want)
+	}
+
+	// The document root rule copies current, then orders it
+	root := []path.Path{path.NewArrayPath(nil)}
+	result, _ = MergeWithOptions(handler, managed, current, root, Options{Order: OrderTemplate})
+	want = om("a", 4.0, "b", 3.0, "x", 0.0, "y", 0.0)
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("root result = %v, want %v", result, want)
+	}
+}
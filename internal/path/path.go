@@ -15,6 +15,14 @@ type Path interface {
 	String() string
 }
 
+// Wildcard is a path segment that matches any single key.
+const Wildcard = "*"
+
+// RecursiveWildcard is a path segment that matches zero or more levels of
+// nested maps. It must be followed by at least one more segment, e.g.
+// ["**", "telemetry"] matches a "telemetry" key at any depth.
+const RecursiveWildcard = "**"
+
 // ArrayPath is a path specified as an array of string keys.
 // Example: ["agent", "default_model"]
 type ArrayPath struct {
@@ -33,9 +41,92 @@ func ParseArrayPath(s string) (*ArrayPath, error) {
 	if err := json.Unmarshal([]byte(s), &segments); err != nil {
 		return nil, fmt.Errorf("invalid path array: %w", err)
 	}
+	if len(segments) > 0 && segments[len(segments)-1] == RecursiveWildcard {
+		return nil, fmt.Errorf("invalid path array: %q must be followed by a key", RecursiveWildcard)
+	}
 	return &ArrayPath{segments: segments}, nil
 }
 
+// HasWildcard reports whether p contains a "*" or "**" segment.
+func HasWildcard(p Path) bool {
+	for _, seg := range p.Segments() {
+		if seg == Wildcard || seg == RecursiveWildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAncestor reports whether every location p selects lies strictly inside
+// one that ancestor selects, e.g. ["agent"] is an ancestor of
+// ["agent", "model"] and ["servers", "*"] of ["servers", "a", "enabled"].
+func IsAncestor(ancestor, p Path) bool {
+	return coversPrefix(ancestor.Segments(), p.Segments())
+}
+
+// coversPrefix reports whether outer matches a proper prefix of inner for
+// every key inner could match.
+func coversPrefix(outer, inner []string) bool {
+	if len(outer) == 0 {
+		return len(inner) > 0
+	}
+	if len(inner) == 0 {
+		return false
+	}
+	switch outer[0] {
+	case RecursiveWildcard:
+		// Match zero levels, or absorb one more segment of inner
+		return coversPrefix(outer[1:], inner) || coversPrefix(outer, inner[1:])
+	case Wildcard:
+		return inner[0] != RecursiveWildcard && coversPrefix(outer[1:], inner[1:])
+	default:
+		return outer[0] == inner[0] && coversPrefix(outer[1:], inner[1:])
+	}
+}
+
+// ParseArrayPaths parses either a single path (`["a", "b"]`) or a list of
+// paths (`[["a", "b"], ["c"]]`) from a JSON array string.
+func ParseArrayPaths(s string) ([]*ArrayPath, error) {
+	var elems []json.RawMessage
+	if err := json.Unmarshal([]byte(s), &elems); err != nil {
+		return nil, fmt.Errorf("invalid path array: %w", err)
+	}
+
+	// An array of strings (or an empty array) is a single path
+	if len(elems) == 0 || !isJSONArray(elems[0]) {
+		p, err := ParseArrayPath(s)
+		if err != nil {
+			return nil, err
+		}
+		return []*ArrayPath{p}, nil
+	}
+
+	paths := make([]*ArrayPath, 0, len(elems))
+	for i, elem := range elems {
+		p, err := ParseArrayPath(string(elem))
+		if err != nil {
+			return nil, fmt.Errorf("path %d: %w", i+1, err)
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// isJSONArray reports whether raw JSON starts with an array.
+func isJSONArray(raw json.RawMessage) bool {
+	for _, b := range raw {
+		switch b {
+		case ' ', '\t', '\n', '\r':
+			continue
+		case '[':
+			return true
+		default:
+			return false
+		}
+	}
+	return false
+}
+
 // Segments returns the path segments.
 func (p *ArrayPath) Segments() []string {
 	return p.segments
@@ -0,0 +1,82 @@
+package script
+
+import (
+	"strings"
+	"testing"
+)
+
+// FuzzParseScript checks that Parse never panics, and that a script it
+// accepts has a consistent body and line mapping.
+func FuzzParseScript(f *testing.F) {
+	f.Add(`#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore ["agent", "default_model"]
+# ignore ["context_servers", "*", "enabled"]
+#---
+// header comment
+{"agent": {"default_model": "x"}}
+`)
+	f.Add(`# version 1
+# format toml
+# strip-comments false
+# array-merge union
+# ignore [["a"], ["b", "**"]]
+#---
+[a]
+b = 1
+`)
+	f.Add(`# version 1
+# format plaintext
+#---
+# chezmoi:managed
+export EDITOR=vim
+# chezmoi:ignored name=aliases sort
+# chezmoi:end
+`)
+	f.Add(`# version 1
+# format auto
+# fingerprint true
+# base current
+# fallback-current ~/.app.toml
+#---
+`)
+	f.Add("# version 99\n#---\n{}")
+	f.Add("# format json\n")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, content string) {
+		scr, err := Parse(content)
+		if err != nil {
+			return
+		}
+		if scr.Format != "auto" && !isFormatSupported(scr.Format) {
+			t.Errorf("Parse() accepted unsupported format %q", scr.Format)
+		}
+		body := scr.Body()
+		switch {
+		case scr.Template == "":
+			// With auto format, a body without config content is all header
+			if scr.Header != body {
+				t.Errorf("Header = %q, want the whole body %q", scr.Header, body)
+			}
+		case scr.Template == body:
+			if scr.Header != "" {
+				t.Errorf("Header = %q, want none when Template is the whole body", scr.Header)
+			}
+		default:
+			if scr.Header+"\n"+scr.Template != body {
+				t.Errorf("Header %q + Template %q don't make up body %q", scr.Header, scr.Template, body)
+			}
+		}
+
+		// TemplateLine points at the template's first line in the script
+		if scr.Template != "" {
+			lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+			first, _, _ := strings.Cut(scr.Template, "\n")
+			if scr.TemplateLine < 1 || scr.TemplateLine > len(lines) || strings.TrimSuffix(lines[scr.TemplateLine-1], "\r") != first {
+				t.Errorf("TemplateLine = %d, want the line holding %q", scr.TemplateLine, first)
+			}
+		}
+	})
+}
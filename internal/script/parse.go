@@ -3,35 +3,121 @@ package script
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
+	"github.com/thirteen37/chezmoi-split/internal/charset"
+	"github.com/thirteen37/chezmoi-split/internal/fingerprint"
+	"github.com/thirteen37/chezmoi-split/internal/format"
+	"github.com/thirteen37/chezmoi-split/internal/format/plaintext"
+	"github.com/thirteen37/chezmoi-split/internal/merge"
 	"github.com/thirteen37/chezmoi-split/internal/path"
 )
 
 // CurrentVersion is the latest supported script format version.
 const CurrentVersion = 1
 
+// ErrUnsupportedVersion matches a *VersionError with errors.Is.
+var ErrUnsupportedVersion = errors.New("unsupported script version")
+
+// VersionError reports a script that declares a newer version than this
+// build supports, so it needs a newer chezmoi-split rather than a fix.
+type VersionError struct {
+	Line    int // Line of the version directive
+	Version int // Version the script declares
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("line %d: unsupported version %d (max supported: %d), please upgrade chezmoi-split", e.Line, e.Version, CurrentVersion)
+}
+
+// Is makes errors.Is(err, ErrUnsupportedVersion) true for a *VersionError.
+func (e *VersionError) Is(target error) bool {
+	return target == ErrUnsupportedVersion
+}
+
 // SupportedFormats lists the config formats that are currently supported.
-var SupportedFormats = []string{"json", "toml", "ini", "plaintext", "auto"}
+var SupportedFormats = []string{"json", "toml", "ini", "phpini", "yaml", "dotenv", "properties", "plist", "hcl", "sshconfig", "systemd", "desktop", "reg", "nginx", "kdl", "lua", "jsonl", "plaintext", "auto"}
+
+// arrayModeAliases maps other names accepted after an ignore path to array
+// merge modes.
+var arrayModeAliases = map[string]string{"concat": merge.ArrayAppend}
+
+// HeaderCommentStyles lists the comment prefixes accepted by the
+// header-comment-style directive.
+var HeaderCommentStyles = []string{"#", ";", "//"}
+
+// What to do when the current file fails to parse but looks like another
+// format, as when an app has moved its config from JSON to TOML.
+const (
+	FormatMismatchError     = "error"     // Fail, leaving the file alone (default)
+	FormatMismatchOverwrite = "overwrite" // Merge as if there were no current file
+)
+
+// FormatMismatchModes lists the values of the on-format-mismatch directive.
+var FormatMismatchModes = []string{FormatMismatchError, FormatMismatchOverwrite}
 
 // Script represents a parsed chezmoi-split script.
 type Script struct {
-	Version       int
-	Format        string
-	StripComments bool
-	IgnorePaths   []path.Path
-	Header        string   // Lines before the config content (comments, etc.)
-	Template      string   // The actual config content (JSON/YAML)
-	Warnings      []string // Non-fatal warnings encountered during parsing
+	Version             int
+	Format              string
+	StripComments       bool
+	JSON5               bool   // Parse JSON as JSON5: comments, single quotes, unquoted keys, trailing commas
+	KeepComments        bool   // Keep JSON comments above keys in the output (managed template's win)
+	ArrayMerge          string // How arrays at ignored paths combine (see merge.ArrayModes)
+	Base                string // Which config the merge starts from (see merge.BaseModes)
+	KeepExtra           bool   // Keep top-level entries that exist only in the current file
+	CanonicalOrder      string // Key order of the merged output (see merge.OrderModes)
+	RequirePreservation bool   // Fail, rather than warn, when a non-empty current file contributes nothing
+	MatchKey            string // Record field matching JSON Lines records between files; empty means "id"
+	BoolStyle           string // Spelling of booleans in INI and YAML output (see format.BoolStyles); empty writes true/false
+	NullStyle           string // Whether JSON and YAML output writes or omits nulls (see format.NullStyles); empty writes them
+	Indent              string // One level of JSON, YAML, and plist output indentation; empty follows the current file
+	Output              string // Layout of JSON output (see format.OutputStyles); empty is pretty
+	OmitFinalNewline    bool   // End JSON and plaintext output without a newline
+	Encoding            string // Character encoding of the target file (see charset.Names); empty means UTF-8
+	HeaderCommentStyle  string // Comment prefix of header lines ("#", ";", "//"); empty uses format heuristics
+	CommentPrefix       string // Comment syntax of lines the plaintext merge writes (see plaintext.CommentPresets); empty follows the markers
+	IgnorePaths         []path.Path
+	IgnoreRules         map[string]merge.Rule // Options given after an ignore path, keyed by the path's String()
+	PreserveIfMissing   []path.Path           // Managed defaults: current's value wins when it has one
+	DeletePaths         []path.Path           // Paths removed from the merged result
+	Computes            []merge.Compute       // Managed values rendered from current values
+	FallbackCurrent     []string              // Files to read as current when the target is empty, first found wins
+	OnFormatMismatch    string                // What to do with a current file in another format (see FormatMismatchModes)
+	Fingerprint         bool                  // Embed a hash of the managed template in the output
+	FingerprintKey      string                // Key holding the fingerprint in structured formats
+	Header              string                // Lines before the config content (comments, etc.)
+	Template            string                // The actual config content (JSON/YAML)
+	TemplateLine        int                   // Script line number of the first Template line
+	Warnings            []string              // Non-fatal warnings encountered during parsing
+	FormatDetected      bool                  // Format was "auto" and has been resolved by ResolveFormat
+
+	body           []string       // All lines after #---, kept so the format can be resolved later
+	bodyLine       int            // Script line number of the first body line
+	directiveLines map[string]int // Line number of the first use of each directive
+	ignoreLines    []int          // Line number of the directive for each of IgnorePaths
+	preserveLines  []int          // Line number of the directive for each of PreserveIfMissing
 }
 
 // Parse parses a chezmoi-split script from its content.
-// Directives are prefixed with '# ' and the template section starts after '#---'.
+// Directives are prefixed with '# ' and the template section starts after '#---',
+// which may name the format in place of a format directive ("#--- toml").
 // Lines before the actual config content (JSON/YAML) are preserved as Header.
 func Parse(content string) (*Script, error) {
 	script := &Script{
-		Format: "auto", // default to auto-detection
+		Format:           "auto", // default to auto-detection
+		ArrayMerge:       merge.ArrayReplace,
+		Base:             merge.BaseManaged,
+		CanonicalOrder:   merge.OrderMerged,
+		OnFormatMismatch: FormatMismatchError,
+		FingerprintKey:   fingerprint.DefaultKey,
+		directiveLines:   make(map[string]int),
 	}
 
 	scanner := bufio.NewScanner(strings.NewReader(content))
@@ -67,9 +153,14 @@ func Parse(content string) (*Script, error) {
 			continue
 		}
 
-		// Check for separator marking start of template
-		if trimmed == "#---" {
+		// Check for separator marking start of template, optionally
+		// annotated with the format ("#--- toml")
+		if rest, ok := strings.CutPrefix(trimmed, "#---"); ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+			if err := script.setSeparatorFormat(strings.TrimSpace(rest), lineNum); err != nil {
+				return nil, err
+			}
 			inTemplate = true
+			script.bodyLine = lineNum + 1
 			continue
 		}
 
@@ -87,6 +178,9 @@ func Parse(content string) (*Script, error) {
 
 		directive := parts[0]
 		value := strings.TrimSpace(parts[1])
+		if _, seen := script.directiveLines[directive]; !seen {
+			script.directiveLines[directive] = lineNum
+		}
 
 		switch directive {
 		case "version":
@@ -98,7 +192,7 @@ func Parse(content string) (*Script, error) {
 				return nil, fmt.Errorf("line %d: invalid version %q", lineNum, value)
 			}
 			if v > CurrentVersion {
-				return nil, fmt.Errorf("line %d: unsupported version %d (max supported: %d), please upgrade chezmoi-split", lineNum, v, CurrentVersion)
+				return nil, &VersionError{Line: lineNum, Version: v}
 			}
 			if v < 1 {
 				return nil, fmt.Errorf("line %d: invalid version %d", lineNum, v)
@@ -128,15 +222,262 @@ func Parse(content string) (*Script, error) {
 				return nil, fmt.Errorf("line %d: strip-comments must be true or false", lineNum)
 			}
 
+		case "json5":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			switch value {
+			case "true":
+				script.JSON5 = true
+			case "false":
+				script.JSON5 = false
+			default:
+				return nil, fmt.Errorf("line %d: json5 must be true or false", lineNum)
+			}
+
+		case "keep-comments":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			switch value {
+			case "true":
+				script.KeepComments = true
+			case "false":
+				script.KeepComments = false
+			default:
+				return nil, fmt.Errorf("line %d: keep-comments must be true or false", lineNum)
+			}
+
+		case "fingerprint":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			switch value {
+			case "true":
+				script.Fingerprint = true
+			case "false":
+				script.Fingerprint = false
+			default:
+				return nil, fmt.Errorf("line %d: fingerprint must be true or false", lineNum)
+			}
+
+		case "match-key":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			script.MatchKey = value
+
+		case "fingerprint-key":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			script.FingerprintKey = value
+
+		case "array-merge":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			if !slices.Contains(merge.ArrayModes, value) {
+				return nil, fmt.Errorf("line %d: array-merge must be one of %v, got %q", lineNum, merge.ArrayModes, value)
+			}
+			script.ArrayMerge = value
+
+		case "base":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			if !slices.Contains(merge.BaseModes, value) {
+				return nil, fmt.Errorf("line %d: base must be one of %v, got %q", lineNum, merge.BaseModes, value)
+			}
+			script.Base = value
+
+		case "canonical-order":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			if !slices.Contains(merge.OrderModes, value) {
+				return nil, fmt.Errorf("line %d: canonical-order must be one of %v, got %q", lineNum, merge.OrderModes, value)
+			}
+			script.CanonicalOrder = value
+
+		case "keep-extra":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			switch value {
+			case "true":
+				script.KeepExtra = true
+			case "false":
+				script.KeepExtra = false
+			default:
+				return nil, fmt.Errorf("line %d: keep-extra must be true or false", lineNum)
+			}
+
+		case "require-preservation":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			switch value {
+			case "true":
+				script.RequirePreservation = true
+			case "false":
+				script.RequirePreservation = false
+			default:
+				return nil, fmt.Errorf("line %d: require-preservation must be true or false", lineNum)
+			}
+
+		case "bool-style":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			if !slices.Contains(format.BoolStyles, value) {
+				return nil, fmt.Errorf("line %d: bool-style must be one of %v, got %q", lineNum, format.BoolStyles, value)
+			}
+			script.BoolStyle = value
+
+		case "indent":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			indent, err := format.ParseIndent(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			script.Indent = indent
+
+		case "encoding":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			encoding, ok := charset.Lookup(value)
+			if !ok {
+				return nil, fmt.Errorf("line %d: encoding must be one of %v, got %q", lineNum, charset.Names, value)
+			}
+			script.Encoding = encoding
+
+		case "null-style":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			if !slices.Contains(format.NullStyles, value) {
+				return nil, fmt.Errorf("line %d: null-style must be one of %v, got %q", lineNum, format.NullStyles, value)
+			}
+			script.NullStyle = value
+
+		case "output":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			if !slices.Contains(format.OutputStyles, value) {
+				return nil, fmt.Errorf("line %d: output must be one of %v, got %q", lineNum, format.OutputStyles, value)
+			}
+			script.Output = value
+
+		case "final-newline":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			switch value {
+			case "true":
+				script.OmitFinalNewline = false
+			case "false":
+				script.OmitFinalNewline = true
+			default:
+				return nil, fmt.Errorf("line %d: final-newline must be true or false", lineNum)
+			}
+
+		case "comment-prefix":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			prefix, err := plaintext.ResolveCommentPrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			script.CommentPrefix = prefix
+
+		case "header-comment-style":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			if !slices.Contains(HeaderCommentStyles, value) {
+				return nil, fmt.Errorf("line %d: header-comment-style must be one of %v, got %q", lineNum, HeaderCommentStyles, value)
+			}
+			script.HeaderCommentStyle = value
+
+		case "on-format-mismatch":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			if !slices.Contains(FormatMismatchModes, value) {
+				return nil, fmt.Errorf("line %d: on-format-mismatch must be one of %v, got %q", lineNum, FormatMismatchModes, value)
+			}
+			script.OnFormatMismatch = value
+
+		case "fallback-current":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			script.FallbackCurrent = append(script.FallbackCurrent, value)
+
 		case "ignore":
 			if !versionSeen {
 				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
 			}
-			p, err := path.ParseArrayPath(value)
+			spec, options := splitIgnoreOptions(value)
+			paths, err := path.ParseArrayPaths(spec)
 			if err != nil {
 				return nil, fmt.Errorf("line %d: invalid ignore path %q: %w", lineNum, value, err)
 			}
-			script.IgnorePaths = append(script.IgnorePaths, p)
+			rule, err := parseRule(options)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			if rule != (merge.Rule{}) && script.IgnoreRules == nil {
+				script.IgnoreRules = make(map[string]merge.Rule)
+			}
+			for _, p := range paths {
+				script.IgnorePaths = append(script.IgnorePaths, p)
+				script.ignoreLines = append(script.ignoreLines, lineNum)
+				if rule != (merge.Rule{}) {
+					script.IgnoreRules[p.String()] = rule
+				}
+			}
+
+		case "preserve-if-missing":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			paths, err := path.ParseArrayPaths(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid preserve-if-missing path %q: %w", lineNum, value, err)
+			}
+			for _, p := range paths {
+				script.PreserveIfMissing = append(script.PreserveIfMissing, p)
+				script.preserveLines = append(script.preserveLines, lineNum)
+			}
+
+		case "delete":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			paths, err := path.ParseArrayPaths(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid delete path %q: %w", lineNum, value, err)
+			}
+			for _, p := range paths {
+				script.DeletePaths = append(script.DeletePaths, p)
+			}
+
+		case "compute":
+			if !versionSeen {
+				return nil, fmt.Errorf("line %d: version directive must come first", lineNum)
+			}
+			c, err := parseCompute(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			script.Computes = append(script.Computes, c)
 
 		default:
 			return nil, fmt.Errorf("line %d: unknown directive %q", lineNum, directive)
@@ -155,42 +496,472 @@ func Parse(content string) (*Script, error) {
 		return nil, fmt.Errorf("no template content found")
 	}
 
+	script.warnOverlappingIgnores()
+	script.warnIgnoredDefaults()
+	script.warnShadowedByRoot()
+
+	// A Latin-1 template can't be told from UTF-8 by its directives, which
+	// are ASCII, so it is decoded only when it isn't valid UTF-8
+	if script.Encoding == charset.Latin1 && !utf8.ValidString(strings.Join(templateLines, "\n")) {
+		for i, line := range templateLines {
+			decoded, _ := charset.Decode([]byte(line), charset.Latin1)
+			templateLines[i] = string(decoded)
+		}
+	}
+
+	script.body = templateLines
+	if err := script.splitTemplate(); err != nil {
+		return nil, err
+	}
+
+	return script, nil
+}
+
+// setSeparatorFormat applies the format named on the "#---" separator line.
+// It must be supported and agree with any earlier format directive.
+func (s *Script) setSeparatorFormat(value string, lineNum int) error {
+	if value == "" {
+		return nil
+	}
+	if !isFormatSupported(value) {
+		return fmt.Errorf("line %d: unsupported format %q (supported: %v)", lineNum, value, SupportedFormats)
+	}
+	if formatLine, seen := s.directiveLines["format"]; seen && s.Format != value {
+		return fmt.Errorf("line %d: separator format %q conflicts with format %q on line %d", lineNum, value, s.Format, formatLine)
+	}
+	if _, seen := s.directiveLines["format"]; !seen {
+		s.directiveLines["format"] = lineNum
+	}
+	s.Format = value
+	return nil
+}
+
+// warnOverlappingIgnores adds a warning for each ignore path that lies inside
+// another one, since the broader path already preserves everything below it.
+func (s *Script) warnOverlappingIgnores() {
+	for i, a := range s.IgnorePaths {
+		for j, b := range s.IgnorePaths[i+1:] {
+			j += i + 1
+			outer, inner, line := a, b, s.ignoreLines[j]
+			if !path.IsAncestor(outer, inner) {
+				outer, inner = b, a
+				if !path.IsAncestor(outer, inner) {
+					continue
+				}
+			}
+			s.Warnings = append(s.Warnings, fmt.Sprintf(
+				"line %d: ignore path %s is inside ignore path %s, which already preserves it", line, inner, outer))
+		}
+	}
+}
+
+// splitIgnoreOptions splits an ignore directive's value into its path array
+// and the options after it (`["plugins"] union max-depth=2`).
+func splitIgnoreOptions(value string) (spec, options string) {
+	end := strings.LastIndex(value, "]")
+	if end < 0 {
+		return value, ""
+	}
+	return value[:end+1], strings.TrimSpace(value[end+1:])
+}
+
+// parseRule reads the options after an ignore path: an array merge mode, and
+// max-depth=N and report-new-keys=true|false settings.
+func parseRule(options string) (merge.Rule, error) {
+	var rule merge.Rule
+	for _, opt := range strings.Fields(options) {
+		name, value, hasValue := strings.Cut(opt, "=")
+		switch {
+		case !hasValue:
+			mode := opt
+			if alias, ok := arrayModeAliases[mode]; ok {
+				mode = alias
+			}
+			if !slices.Contains(merge.ArrayModes, mode) {
+				return rule, fmt.Errorf("ignore array merge mode must be one of %v or concat, got %q", merge.ArrayModes, opt)
+			}
+			rule.ArrayMerge = mode
+		case name == "max-depth":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return rule, fmt.Errorf("ignore max-depth must be a positive integer, got %q", value)
+			}
+			rule.MaxDepth = n
+		case name == "report-new-keys":
+			switch value {
+			case "true":
+				rule.ReportNewKeys = true
+			case "false":
+				rule.ReportNewKeys = false
+			default:
+				return rule, fmt.Errorf("ignore report-new-keys must be true or false")
+			}
+		default:
+			return rule, fmt.Errorf("unknown ignore option %q", name)
+		}
+	}
+	return rule, nil
+}
+
+// parseCompute reads a compute directive's value: the output path, then
+// template="..." (a Go-quoted string) and requires=[...] (the current paths
+// the template reads), e.g.
+// `["font"] template="{{ .current.family }} {{ .current.size }}" requires=[["family"],["size"]]`.
+func parseCompute(value string) (merge.Compute, error) {
+	var c merge.Compute
+	spec, rest, err := cutJSON(value)
+	if err != nil {
+		return c, fmt.Errorf("invalid compute path %q: %w", value, err)
+	}
+	p, err := path.ParseArrayPath(spec)
+	if err != nil {
+		return c, fmt.Errorf("invalid compute path %q: %w", spec, err)
+	}
+	c.Path = p
+
+	text := ""
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		name, arg, _ := strings.Cut(rest, "=")
+		switch name {
+		case "template":
+			quoted, err := strconv.QuotedPrefix(arg)
+			if err != nil {
+				return c, fmt.Errorf("compute template must be a quoted string")
+			}
+			text, _ = strconv.Unquote(quoted)
+			rest = arg[len(quoted):]
+		case "requires":
+			raw, after, err := cutJSON(arg)
+			if err != nil {
+				return c, fmt.Errorf("invalid compute requires %q: %w", arg, err)
+			}
+			paths, err := path.ParseArrayPaths(raw)
+			if err != nil {
+				return c, fmt.Errorf("invalid compute requires %q: %w", raw, err)
+			}
+			for _, p := range paths {
+				c.Requires = append(c.Requires, p)
+			}
+			rest = after
+		default:
+			return c, fmt.Errorf("unknown compute option %q", name)
+		}
+	}
+
+	switch {
+	case text == "":
+		return c, fmt.Errorf("compute %s needs a template=\"...\" option", c.Path)
+	case len(c.Requires) == 0:
+		return c, fmt.Errorf("compute %s needs a requires=[...] option", c.Path)
+	case path.HasWildcard(c.Path) || len(c.Path.Segments()) == 0:
+		return c, fmt.Errorf("compute path %s must name a single value", c.Path)
+	}
+	for _, p := range c.Requires {
+		if path.HasWildcard(p) || len(p.Segments()) == 0 {
+			return c, fmt.Errorf("compute requires path %s must name a single value", p)
+		}
+	}
+	for i, p := range c.Requires {
+		for _, q := range c.Requires[:i] {
+			if path.IsAncestor(p, q) || path.IsAncestor(q, p) {
+				return c, fmt.Errorf("compute requires paths %s and %s overlap", q, p)
+			}
+		}
+	}
+	if c.Template, err = merge.NewComputeTemplate(c.Path.String(), text); err != nil {
+		return c, fmt.Errorf("invalid compute template: %w", err)
+	}
+	return c, nil
+}
+
+// cutJSON splits the JSON value at the start of s from the text after it.
+func cutJSON(s string) (value, rest string, err error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return "", "", err
+	}
+	end := dec.InputOffset()
+	return s[:end], s[end:], nil
+}
+
+// warnIgnoredDefaults adds a warning for each preserve-if-missing path that
+// is also an ignore path. Both keep current's value, but the ignore rule is
+// the one applied, so array-merge still combines arrays at that path.
+func (s *Script) warnIgnoredDefaults() {
+	for i, p := range s.PreserveIfMissing {
+		for _, ignored := range s.IgnorePaths {
+			if p.String() == ignored.String() {
+				s.Warnings = append(s.Warnings, fmt.Sprintf(
+					"line %d: path %s is both ignored and preserve-if-missing; the ignore rule applies", s.preserveLines[i], p))
+				break
+			}
+		}
+	}
+}
+
+// warnShadowedByRoot adds a warning for each rule that an ignore of the
+// document root (`# ignore []`) makes pointless, since the merge then keeps
+// the whole current file. Other ignore paths are already reported by
+// warnOverlappingIgnores.
+func (s *Script) warnShadowedByRoot() {
+	root := slices.IndexFunc(s.IgnorePaths, func(p path.Path) bool { return len(p.Segments()) == 0 })
+	if root < 0 {
+		return
+	}
+	shadowed := func(line int, what string) {
+		s.Warnings = append(s.Warnings, fmt.Sprintf(
+			"line %d: %s has no effect; ignore [] on line %d keeps the whole current file", line, what, s.ignoreLines[root]))
+	}
+	for i, p := range s.PreserveIfMissing {
+		shadowed(s.preserveLines[i], "preserve-if-missing path "+p.String())
+	}
+	if s.KeepExtra {
+		shadowed(s.directiveLines["keep-extra"], "keep-extra")
+	}
+	if s.Base == merge.BaseCurrent {
+		shadowed(s.directiveLines["base"], "base current")
+	}
+}
+
+// DirectiveLine returns the script line number of the first use of a
+// directive ("format" also counts a format on the separator line), or 0 if
+// the script doesn't use it.
+func (s *Script) DirectiveLine(directive string) int {
+	return s.directiveLines[directive]
+}
+
+// IgnoreLine returns the script line number of the directive for
+// IgnorePaths[i].
+func (s *Script) IgnoreLine(i int) int {
+	return s.ignoreLines[i]
+}
+
+// PreserveLine returns the script line number of the directive for
+// PreserveIfMissing[i].
+func (s *Script) PreserveLine(i int) int {
+	return s.preserveLines[i]
+}
+
+// Body returns everything after the #--- separator, before any header/content split.
+func (s *Script) Body() string {
+	return strings.Join(s.body, "\n")
+}
+
+// ResolveFormat replaces an "auto" format with a concrete one and re-splits the
+// template accordingly. Used once the actual format has been detected.
+func (s *Script) ResolveFormat(format string) error {
+	if !isFormatSupported(format) || format == "auto" {
+		return fmt.Errorf("cannot resolve to unsupported format %q", format)
+	}
+	if s.Format == "auto" {
+		s.FormatDetected = true
+	}
+	s.Format = format
+	return s.splitTemplate()
+}
+
+// splitTemplate fills Header and Template from the body according to Format.
+func (s *Script) splitTemplate() error {
+	if s.JSON5 && s.Format != "json" && s.Format != "auto" {
+		s.Warnings = append(s.Warnings, fmt.Sprintf(
+			"line %d: json5 is only used with json format", s.directiveLines["json5"]))
+	}
+	if s.KeepComments && s.Format != "json" && s.Format != "auto" {
+		s.Warnings = append(s.Warnings, fmt.Sprintf(
+			"line %d: keep-comments is only used with json format", s.directiveLines["keep-comments"]))
+	} else if s.KeepComments && s.JSON5 {
+		s.Warnings = append(s.Warnings, fmt.Sprintf(
+			"line %d: keep-comments has no effect with json5; comments are dropped", s.directiveLines["keep-comments"]))
+	}
+	if s.MatchKey != "" && s.Format != "jsonl" && s.Format != "auto" {
+		s.Warnings = append(s.Warnings, fmt.Sprintf(
+			"line %d: match-key is only used with jsonl format", s.directiveLines["match-key"]))
+	}
+	if s.Fingerprint && s.Format == "jsonl" {
+		return fmt.Errorf("line %d: fingerprint is not supported for jsonl format, where every line is a record", s.directiveLines["fingerprint"])
+	}
+	if _, ok := s.directiveLines["fingerprint-key"]; ok && fingerprint.UsesComment(s.Format) {
+		s.Warnings = append(s.Warnings, fmt.Sprintf(
+			"line %d: fingerprint-key is only used with json and plist formats; %s stores the fingerprint as a comment", s.directiveLines["fingerprint-key"], s.Format))
+	}
+	if s.BoolStyle != "" && !slices.Contains([]string{"ini", "phpini", "yaml", "auto"}, s.Format) {
+		s.Warnings = append(s.Warnings, fmt.Sprintf(
+			"line %d: bool-style is only used with ini, phpini, and yaml formats", s.directiveLines["bool-style"]))
+	}
+	if s.Indent != "" && !slices.Contains([]string{"json", "yaml", "plist", "auto"}, s.Format) {
+		s.Warnings = append(s.Warnings, fmt.Sprintf(
+			"line %d: indent is only used with json, yaml, and plist formats", s.directiveLines["indent"]))
+	}
+	if s.CommentPrefix != "" && s.Format != "plaintext" && s.Format != "auto" {
+		s.Warnings = append(s.Warnings, fmt.Sprintf(
+			"line %d: comment-prefix is only used with plaintext format; use header-comment-style for headers", s.directiveLines["comment-prefix"]))
+	}
+	if s.NullStyle != "" && !slices.Contains([]string{"json", "yaml", "auto"}, s.Format) {
+		s.Warnings = append(s.Warnings, fmt.Sprintf(
+			"line %d: null-style is only used with json and yaml formats", s.directiveLines["null-style"]))
+	}
+	if s.Output != "" && s.Format != "json" && s.Format != "auto" {
+		s.Warnings = append(s.Warnings, fmt.Sprintf(
+			"line %d: output is only used with json format", s.directiveLines["output"]))
+	} else if s.Output == "compact" {
+		if s.Indent != "" {
+			s.Warnings = append(s.Warnings, fmt.Sprintf(
+				"line %d: indent has no effect with output compact", s.directiveLines["indent"]))
+		}
+		if s.KeepComments {
+			s.Warnings = append(s.Warnings, fmt.Sprintf(
+				"line %d: keep-comments has no effect with output compact; comments are dropped", s.directiveLines["keep-comments"]))
+		}
+	}
+	if _, ok := s.directiveLines["final-newline"]; ok && !slices.Contains([]string{"json", "plaintext", "auto"}, s.Format) {
+		s.Warnings = append(s.Warnings, fmt.Sprintf(
+			"line %d: final-newline is only used with json and plaintext formats", s.directiveLines["final-newline"]))
+	}
+
 	// For plaintext format, treat everything after #--- as template content
 	// (no header/content separation based on config patterns)
-	if script.Format == "plaintext" {
-		script.Template = strings.Join(templateLines, "\n")
+	if s.Format == "plaintext" {
+		s.Header = ""
+		s.Template = strings.Join(s.body, "\n")
+		s.TemplateLine = s.bodyLine
 		// Warn about directives that don't apply to plaintext
-		if len(script.IgnorePaths) > 0 {
-			script.Warnings = append(script.Warnings,
-				"ignore directives are not used with plaintext format; use chezmoi:ignored blocks instead")
+		if len(s.IgnorePaths) > 0 {
+			s.Warnings = append(s.Warnings, fmt.Sprintf(
+				"line %d: ignore directives are not used with plaintext format; use chezmoi:ignored blocks instead", s.directiveLines["ignore"]))
+		}
+		if s.Base != merge.BaseManaged {
+			s.Warnings = append(s.Warnings, fmt.Sprintf(
+				"line %d: base is not used with plaintext format; use chezmoi:ignored blocks instead", s.directiveLines["base"]))
+		}
+		if s.CanonicalOrder != merge.OrderMerged {
+			s.Warnings = append(s.Warnings, fmt.Sprintf(
+				"line %d: canonical-order is not used with plaintext format, which keeps the template's line order", s.directiveLines["canonical-order"]))
+		}
+		if s.OnFormatMismatch != FormatMismatchError {
+			s.Warnings = append(s.Warnings, fmt.Sprintf(
+				"line %d: on-format-mismatch is not used with plaintext format, which reads any file", s.directiveLines["on-format-mismatch"]))
+		}
+		if s.HeaderCommentStyle != "" {
+			s.Warnings = append(s.Warnings, fmt.Sprintf(
+				"line %d: header-comment-style is not used with plaintext format, which has no header", s.directiveLines["header-comment-style"]))
+		}
+		if len(s.PreserveIfMissing) > 0 {
+			s.Warnings = append(s.Warnings, fmt.Sprintf(
+				"line %d: preserve-if-missing is not used with plaintext format; use chezmoi:ignored blocks instead", s.directiveLines["preserve-if-missing"]))
+		}
+		if len(s.DeletePaths) > 0 {
+			s.Warnings = append(s.Warnings, fmt.Sprintf(
+				"line %d: delete is not used with plaintext format; leave the lines out of the template instead", s.directiveLines["delete"]))
 		}
-		if script.StripComments {
-			script.Warnings = append(script.Warnings,
-				"strip-comments is not supported for plaintext format")
+		if len(s.Computes) > 0 {
+			s.Warnings = append(s.Warnings, fmt.Sprintf(
+				"line %d: compute is not used with plaintext format", s.directiveLines["compute"]))
 		}
-		return script, nil
+		if s.KeepExtra {
+			s.Warnings = append(s.Warnings, fmt.Sprintf(
+				"line %d: keep-extra is not used with plaintext format; use chezmoi:ignored blocks instead", s.directiveLines["keep-extra"]))
+		}
+		if s.RequirePreservation {
+			s.Warnings = append(s.Warnings, fmt.Sprintf(
+				"line %d: require-preservation is not used with plaintext format", s.directiveLines["require-preservation"]))
+		}
+		if s.StripComments {
+			s.Warnings = append(s.Warnings, fmt.Sprintf(
+				"line %d: strip-comments is not supported for plaintext format", s.directiveLines["strip-comments"]))
+		}
+		return nil
 	}
 
 	// Separate header lines from actual config content
-	header, template := splitHeaderAndContent(templateLines)
-	script.Header = header
-	script.Template = template
+	s.Header, s.Template = splitHeaderAndContent(s.body, s.isContentStart)
+	s.TemplateLine = s.bodyLine
+	if s.Template != "" {
+		// Count header lines from the body: a header of blank lines joins to ""
+		s.TemplateLine += len(s.body) - strings.Count(s.Template, "\n") - 1
+	}
 
-	if script.Template == "" {
-		return nil, fmt.Errorf("no config content found (only header lines)")
+	// With "auto", the content may turn out to be plaintext; leave the
+	// decision to ResolveFormat
+	if s.Template == "" && s.Format != "auto" {
+		return fmt.Errorf("no config content found (only header lines)")
 	}
 
-	return script, nil
+	return nil
+}
+
+// isContentStart reports whether a trimmed body line starts the config
+// content. With a header comment style, every line that isn't blank or a
+// comment in that style does. So does it in sshconfig, whose lines are
+// "Keyword arguments", in nginx, whose lines are directives, in kdl, whose
+// lines are nodes and whose comments start with "//", and in lua, whose
+// comments start with "--". In json, a line that is a whole JSON value
+// (null, true, 42) starts a bare-value document; other formats use
+// isConfigStart.
+func (s *Script) isContentStart(line string) bool {
+	switch {
+	case s.HeaderCommentStyle != "":
+		return line != "" && !strings.HasPrefix(line, s.HeaderCommentStyle)
+	case s.Format == "sshconfig", s.Format == "nginx":
+		return line != "" && !strings.HasPrefix(line, "#")
+	case s.Format == "kdl":
+		return line != "" && !strings.HasPrefix(line, "//")
+	case s.Format == "lua":
+		return line != "" && !strings.HasPrefix(line, "--")
+	case s.Format == "reg":
+		return line != "" && !strings.HasPrefix(line, ";")
+	case s.Format == "json":
+		return isConfigStart(line) || json.Valid([]byte(line))
+	default:
+		return isConfigStart(line)
+	}
+}
+
+// StripHeader removes the script's Header from the start of current, so a
+// target written by an earlier run doesn't bring the banner back into the
+// merge, where it can make the file unparsable (a // comment before JSON)
+// or appear twice. The leading lines of current that the script would treat
+// as header must match Header once surrounding whitespace and blank lines
+// are ignored; otherwise current is returned unchanged.
+func (s *Script) StripHeader(current []byte) []byte {
+	if s.Header == "" || s.Format == "plaintext" {
+		return current
+	}
+	lines := strings.SplitAfter(string(current), "\n")
+	end := len(lines)
+	for i, line := range lines {
+		if s.isContentStart(strings.TrimSpace(line)) {
+			end = i
+			break
+		}
+	}
+	if bannerText(strings.Join(lines[:end], "")) != bannerText(s.Header) {
+		return current
+	}
+	return []byte(strings.Join(lines[end:], ""))
+}
+
+// bannerText normalizes header lines for StripHeader: each line trimmed,
+// runs of whitespace collapsed, and blank lines dropped.
+func bannerText(header string) string {
+	var lines []string
+	for _, line := range strings.Split(header, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			lines = append(lines, strings.Join(fields, " "))
+		}
+	}
+	return strings.Join(lines, "\n")
 }
 
 // splitHeaderAndContent separates header lines (comments, blank lines before config)
-// from the actual config content (JSON/YAML).
-func splitHeaderAndContent(lines []string) (header, content string) {
+// from the actual config content (JSON/YAML). isStart reports whether a
+// trimmed line starts the content.
+func splitHeaderAndContent(lines []string, isStart func(string) bool) (header, content string) {
 	contentStart := -1
 
 	for i, line := range lines {
 		trimmed := strings.TrimSpace(line)
-		if isConfigStart(trimmed) {
+		if isStart(trimmed) {
 			contentStart = i
 			break
 		}
@@ -213,14 +984,31 @@ func splitHeaderAndContent(lines []string) (header, content string) {
 }
 
 // isConfigStart checks if a line looks like the start of config content.
-// Detects JSON ({ or [), TOML (key = value or [section]), and INI ([section] or key = value).
+// Detects JSON ({ or [), TOML (key = value or [section]), INI ([section] or key = value),
+// and YAML (key: value, - item, or a --- document marker).
 func isConfigStart(line string) bool {
 	// JSON object or array
 	if strings.HasPrefix(line, "{") || strings.HasPrefix(line, "[") {
 		return true
 	}
-	// TOML/INI key = value pattern (but not a comment)
-	if strings.Contains(line, "=") && !strings.HasPrefix(line, "#") {
+	// XML declaration or element (plist)
+	if strings.HasPrefix(line, "<") {
+		return true
+	}
+	// Comments are never config content
+	if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+		return false
+	}
+	// TOML/INI key = value pattern
+	if strings.Contains(line, "=") {
+		return true
+	}
+	// HCL block header, e.g. provider "aws" {
+	if strings.HasSuffix(line, "{") {
+		return true
+	}
+	// YAML mapping, sequence, or document start
+	if strings.Contains(line, ":") || strings.HasPrefix(line, "- ") || line == "---" {
 		return true
 	}
 	return false
@@ -1,7 +1,14 @@
 package script
 
 import (
+	"errors"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+
+	"github.com/thirteen37/chezmoi-split/internal/merge"
 )
 
 func TestParse(t *testing.T) {
@@ -88,6 +95,62 @@ func TestParse(t *testing.T) {
 # ignore not-a-json-array
 #---
 {"key": "value"}
+`,
+			wantErr: true,
+		},
+		{
+			name: "multiple paths in one ignore directive",
+			content: `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# ignore [["agent", "default_model"], ["features"]]
+# ignore ["theme"]
+#---
+{"key": "value"}
+`,
+			wantVersion: 1,
+			wantFormat:  "json",
+			wantPaths:   3,
+		},
+		{
+			name: "invalid path inside ignore list",
+			content: `#!/usr/bin/env chezmoi-split
+# version 1
+# ignore [["ok"], [1, 2]]
+#---
+{"key": "value"}
+`,
+			wantErr: true,
+		},
+		{
+			name: "mixed strings and arrays in ignore list",
+			content: `#!/usr/bin/env chezmoi-split
+# version 1
+# ignore [["ok"], "bad"]
+#---
+{"key": "value"}
+`,
+			wantErr: true,
+		},
+		{
+			name: "recursive wildcard path",
+			content: `#!/usr/bin/env chezmoi-split
+# version 1
+# ignore ["**", "telemetry"]
+#---
+{"key": "value"}
+`,
+			wantVersion: 1,
+			wantFormat:  "auto",
+			wantPaths:   1,
+		},
+		{
+			name: "trailing recursive wildcard",
+			content: `#!/usr/bin/env chezmoi-split
+# version 1
+# ignore ["editor", "**"]
+#---
+{"key": "value"}
 `,
 			wantErr: true,
 		},
@@ -133,6 +196,22 @@ func TestParse(t *testing.T) {
 			wantPaths:   0,
 			wantHeader:  "// First comment line\n// Second comment line\n",
 		},
+		{
+			name: "hcl block after comment header",
+			content: `#!/usr/bin/env chezmoi-split
+# version 1
+# format hcl
+#---
+# Managed by chezmoi
+provider "aws" {
+  region = "us-east-1"
+}
+`,
+			wantVersion: 1,
+			wantFormat:  "hcl",
+			wantPaths:   0,
+			wantHeader:  "# Managed by chezmoi",
+		},
 		{
 			name: "empty comment lines in directives",
 			content: `#!/usr/bin/env chezmoi-split
@@ -170,7 +249,7 @@ format json
 			name: "unsupported format",
 			content: `#!/usr/bin/env chezmoi-split
 # version 1
-# format yaml
+# format xml
 #---
 {"key": "value"}
 `,
@@ -207,6 +286,30 @@ format json
 	}
 }
 
+func TestParse_IgnorePathList(t *testing.T) {
+	content := `#!/usr/bin/env chezmoi-split
+# version 1
+# ignore [["a", "b"], ["c"]]
+# ignore ["d", "e"]
+#---
+{"key": "value"}
+`
+	script, err := Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := []string{`["a","b"]`, `["c"]`, `["d","e"]`}
+	if len(script.IgnorePaths) != len(want) {
+		t.Fatalf("len(IgnorePaths) = %d, want %d", len(script.IgnorePaths), len(want))
+	}
+	for i, p := range script.IgnorePaths {
+		if p.String() != want[i] {
+			t.Errorf("IgnorePaths[%d] = %s, want %s", i, p.String(), want[i])
+		}
+	}
+}
+
 func TestParse_TemplateContent(t *testing.T) {
 	content := `#!/usr/bin/env chezmoi-split
 # version 1
@@ -268,112 +371,1067 @@ func TestParse_HeaderAndTemplate(t *testing.T) {
 	}
 }
 
-func TestParse_PlaintextFormat(t *testing.T) {
+func TestParse_YAMLHeaderAndTemplate(t *testing.T) {
 	content := `#!/usr/bin/env chezmoi-split
 # version 1
-# format plaintext
+# format yaml
 #---
-# chezmoi:managed
-set number
-set expandtab
+# Alacritty configuration
+window:
+  opacity: 0.9
+`
+	script, err := Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
 
-# chezmoi:ignored
-colorscheme gruvbox
+	if script.Format != "yaml" {
+		t.Errorf("Format = %q, want %q", script.Format, "yaml")
+	}
+	if script.Header != "# Alacritty configuration" {
+		t.Errorf("Header = %q, want %q", script.Header, "# Alacritty configuration")
+	}
+	expectedTemplate := "window:\n  opacity: 0.9"
+	if script.Template != expectedTemplate {
+		t.Errorf("Template = %q, want %q", script.Template, expectedTemplate)
+	}
+}
 
+func TestScript_ResolveFormat(t *testing.T) {
+	content := `#!/usr/bin/env chezmoi-split
+# version 1
+#---
+# chezmoi:managed
+set number
 # chezmoi:end
 `
 	script, err := Parse(content)
 	if err != nil {
 		t.Fatalf("Parse() error = %v", err)
 	}
+	if script.Format != "auto" {
+		t.Fatalf("Format = %q, want auto", script.Format)
+	}
 
+	if err := script.ResolveFormat("plaintext"); err != nil {
+		t.Fatalf("ResolveFormat() error = %v", err)
+	}
 	if script.Format != "plaintext" {
-		t.Errorf("Format = %q, want %q", script.Format, "plaintext")
+		t.Errorf("Format = %q, want plaintext", script.Format)
 	}
-	// For plaintext, Header should be empty (no header/content split)
-	if script.Header != "" {
-		t.Errorf("Header = %q, want empty for plaintext", script.Header)
+	want := "# chezmoi:managed\nset number\n# chezmoi:end"
+	if script.Template != want {
+		t.Errorf("Template = %q, want %q", script.Template, want)
 	}
-	// Template should contain everything after #---
-	if !contains(script.Template, "chezmoi:managed") {
-		t.Errorf("Template should contain chezmoi:managed marker")
+	if script.Header != "" {
+		t.Errorf("Header = %q, want empty", script.Header)
 	}
-	if !contains(script.Template, "set number") {
-		t.Errorf("Template should contain 'set number'")
+
+	if err := script.ResolveFormat("auto"); err == nil {
+		t.Error("ResolveFormat(auto) should return an error")
 	}
 }
 
-func TestParse_PlaintextWithIgnoreWarning(t *testing.T) {
+func TestParse_Fingerprint(t *testing.T) {
 	content := `#!/usr/bin/env chezmoi-split
 # version 1
-# format plaintext
-# ignore ["should", "warn"]
+# fingerprint true
+# fingerprint-key x-managed-by
 #---
-# chezmoi:managed
-some content
-# chezmoi:end
+{"key": "value"}
 `
 	script, err := Parse(content)
 	if err != nil {
 		t.Fatalf("Parse() error = %v", err)
 	}
+	if !script.Fingerprint {
+		t.Error("Fingerprint = false, want true")
+	}
+	if script.FingerprintKey != "x-managed-by" {
+		t.Errorf("FingerprintKey = %q, want %q", script.FingerprintKey, "x-managed-by")
+	}
 
-	if len(script.Warnings) == 0 {
-		t.Error("Expected warning for ignore directive with plaintext format")
+	_, err = Parse("# version 1\n# fingerprint maybe\n#---\n{}\n")
+	if err == nil {
+		t.Error("Parse() should reject a non-boolean fingerprint value")
 	}
+}
 
-	foundWarning := false
-	for _, w := range script.Warnings {
-		if contains(w, "ignore") {
-			foundWarning = true
-			break
+func TestParse_ArrayMerge(t *testing.T) {
+	script, err := Parse("# version 1\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if script.ArrayMerge != "replace" {
+		t.Errorf("default ArrayMerge = %q, want replace", script.ArrayMerge)
+	}
+
+	for _, mode := range []string{"replace", "append", "union", "prepend"} {
+		script, err := Parse("# version 1\n# array-merge " + mode + "\n#---\n{}\n")
+		if err != nil {
+			t.Fatalf("Parse(array-merge %s) error = %v", mode, err)
+		}
+		if script.ArrayMerge != mode {
+			t.Errorf("ArrayMerge = %q, want %q", script.ArrayMerge, mode)
 		}
 	}
-	if !foundWarning {
-		t.Errorf("Expected warning about ignore directives, got: %v", script.Warnings)
+
+	if _, err := Parse("# version 1\n# array-merge concat\n#---\n{}\n"); err == nil {
+		t.Error("Parse() should reject an unknown array-merge mode")
+	}
+	if _, err := Parse("# array-merge union\n# version 1\n#---\n{}\n"); err == nil {
+		t.Error("Parse() should require version before array-merge")
 	}
 }
 
-func TestParse_PlaintextWithStripCommentsWarning(t *testing.T) {
-	content := `#!/usr/bin/env chezmoi-split
-# version 1
-# format plaintext
-# strip-comments true
+func TestParse_IgnoreRules(t *testing.T) {
+	script, err := Parse(`# version 1
+# ignore ["plugins"] concat
+# ignore [["hosts"], ["servers", "*", "tags"]] union
+# ignore ["themes"] replace
+# ignore ["context_servers"] max-depth=2 report-new-keys=true
+# ignore ["theme"]
 #---
-# chezmoi:managed
-some content
-# chezmoi:end
-`
-	script, err := Parse(content)
+{}
+`)
 	if err != nil {
 		t.Fatalf("Parse() error = %v", err)
 	}
 
-	if len(script.Warnings) == 0 {
-		t.Error("Expected warning for strip-comments with plaintext format")
+	want := map[string]merge.Rule{
+		`["plugins"]`:            {ArrayMerge: "append"},
+		`["hosts"]`:              {ArrayMerge: "union"},
+		`["servers","*","tags"]`: {ArrayMerge: "union"},
+		`["themes"]`:             {ArrayMerge: "replace"},
+		`["context_servers"]`:    {MaxDepth: 2, ReportNewKeys: true},
+	}
+	if !maps.Equal(script.IgnoreRules, want) {
+		t.Errorf("IgnoreRules = %v, want %v", script.IgnoreRules, want)
+	}
+	if len(script.IgnorePaths) != 6 {
+		t.Errorf("len(IgnorePaths) = %d, want 6", len(script.IgnorePaths))
 	}
 
-	foundWarning := false
-	for _, w := range script.Warnings {
-		if contains(w, "strip-comments") {
-			foundWarning = true
-			break
+	for _, tt := range []struct{ options, want string }{
+		{"zip", "line 2: ignore array merge mode"},
+		{"max-depth=0", "line 2: ignore max-depth must be a positive integer"},
+		{"max-depth=x", "line 2: ignore max-depth must be a positive integer"},
+		{"report-new-keys=yes", "line 2: ignore report-new-keys must be true or false"},
+		{"depth=2", `line 2: unknown ignore option "depth"`},
+	} {
+		_, err := Parse("# version 1\n# ignore [\"plugins\"] " + tt.options + "\n#---\n{}\n")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want containing %q", tt.options, err, tt.want)
 		}
 	}
-	if !foundWarning {
-		t.Errorf("Expected warning about strip-comments, got: %v", script.Warnings)
+}
+
+func TestParse_UnsupportedVersionError(t *testing.T) {
+	_, err := Parse("#!/usr/bin/env chezmoi-split\n# version 999\n#---\n{}\n")
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("Parse() error = %v, want ErrUnsupportedVersion", err)
+	}
+	var versionErr *VersionError
+	if !errors.As(err, &versionErr) || versionErr.Version != 999 || versionErr.Line != 2 {
+		t.Errorf("Parse() error = %#v, want VersionError{Line: 2, Version: 999}", err)
+	}
+
+	// Other errors don't match
+	if _, err := Parse("# version 0\n#---\n{}\n"); errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Parse() error = %v, should not be ErrUnsupportedVersion", err)
 	}
 }
 
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && (s == substr || len(s) > 0 && containsHelper(s, substr))
+func TestParse_Base(t *testing.T) {
+	script, err := Parse("# version 1\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if script.Base != "managed" {
+		t.Errorf("default Base = %q, want managed", script.Base)
+	}
+
+	script, err = Parse("# version 1\n# base current\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse(base current) error = %v", err)
+	}
+	if script.Base != "current" {
+		t.Errorf("Base = %q, want current", script.Base)
+	}
+
+	if _, err := Parse("# version 1\n# base theirs\n#---\n{}\n"); err == nil {
+		t.Error("Parse() should reject an unknown base")
+	}
+	if _, err := Parse("# base current\n# version 1\n#---\n{}\n"); err == nil {
+		t.Error("Parse() should require version before base")
+	}
+
+	script, err = Parse("# version 1\n# format plaintext\n# base current\n#---\nx\n")
+	if err != nil {
+		t.Fatalf("Parse(plaintext) error = %v", err)
+	}
+	if len(script.Warnings) != 1 || !strings.Contains(script.Warnings[0], "line 3: base is not used") {
+		t.Errorf("Warnings = %v, want a base warning for line 3", script.Warnings)
+	}
 }
 
-func containsHelper(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
+func TestParse_SeparatorFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "annotated separator alone",
+			content: "# version 1\n#--- toml\nkey = 1\n",
+			want:    "toml",
+		},
+		{
+			name:    "matching format directive",
+			content: "# version 1\n# format toml\n#---  toml\nkey = 1\n",
+			want:    "toml",
+		},
+		{
+			name:    "plain separator keeps directive",
+			content: "# version 1\n# format ini\n#---\nkey = 1\n",
+			want:    "ini",
+		},
+		{
+			name:    "conflicting format directive",
+			content: "# version 1\n# format json\n#--- toml\nkey = 1\n",
+			wantErr: `line 3: separator format "toml" conflicts with format "json" on line 2`,
+		},
+		{
+			name:    "unsupported format",
+			content: "# version 1\n#--- xml\n<a/>\n",
+			wantErr: `line 2: unsupported format "xml"`,
+		},
+		{
+			name:    "not a separator",
+			content: "# version 1\n#---toml\nkey = 1\n",
+			wantErr: "line 2: expected directive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, err := Parse(tt.content)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if script.Format != tt.want {
+				t.Errorf("Format = %q, want %q", script.Format, tt.want)
+			}
+			if script.Template != "key = 1" {
+				t.Errorf("Template = %q, want %q", script.Template, "key = 1")
+			}
+		})
+	}
+}
+
+func TestParse_HeaderCommentStyle(t *testing.T) {
+	content := `# version 1
+# format toml
+# header-comment-style #
+#---
+# Managed by chezmoi
+# Docs: https://example.com/config
+
+#   key = value pairs below are merged
+title = "app"
+# Comment inside the content
+[server]
+port = 8080`
+	script, err := Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if script.HeaderCommentStyle != "#" {
+		t.Errorf("HeaderCommentStyle = %q, want #", script.HeaderCommentStyle)
+	}
+	wantHeader := "# Managed by chezmoi\n# Docs: https://example.com/config\n\n#   key = value pairs below are merged"
+	if script.Header != wantHeader {
+		t.Errorf("Header = %q, want %q", script.Header, wantHeader)
+	}
+	if !strings.HasPrefix(script.Template, `title = "app"`) {
+		t.Errorf("Template = %q, want it to start at the first key", script.Template)
+	}
+
+	// A ";" header line containing ":" would otherwise start the INI content
+	script, err = Parse("# version 1\n# format ini\n# header-comment-style ;\n#---\n; Note: managed\n[core]\nkey = 1")
+	if err != nil {
+		t.Fatalf("Parse(ini) error = %v", err)
+	}
+	if script.Header != "; Note: managed" || script.Template != "[core]\nkey = 1" {
+		t.Errorf("Header, Template = %q, %q; want the ; line as header", script.Header, script.Template)
+	}
+
+	if _, err := Parse("# version 1\n# header-comment-style --\n#---\n{}\n"); err == nil {
+		t.Error("Parse() should reject an unknown comment style")
+	}
+
+	script, err = Parse("# version 1\n# format plaintext\n# header-comment-style #\n#---\nx\n")
+	if err != nil {
+		t.Fatalf("Parse(plaintext) error = %v", err)
+	}
+	if len(script.Warnings) != 1 || !strings.Contains(script.Warnings[0], "line 3: header-comment-style is not used") {
+		t.Errorf("Warnings = %v, want a header-comment-style warning for line 3", script.Warnings)
+	}
+}
+
+func TestParse_KeepExtra(t *testing.T) {
+	script, err := Parse("# version 1\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if script.KeepExtra {
+		t.Error("KeepExtra should default to false")
+	}
+
+	script, err = Parse("# version 1\n# keep-extra true\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse(keep-extra true) error = %v", err)
+	}
+	if !script.KeepExtra {
+		t.Error("KeepExtra = false, want true")
+	}
+
+	if _, err := Parse("# version 1\n# keep-extra yes\n#---\n{}\n"); err == nil {
+		t.Error("Parse() should reject a non-boolean keep-extra")
+	}
+
+	script, err = Parse("# version 1\n# format plaintext\n# keep-extra true\n#---\nx\n")
+	if err != nil {
+		t.Fatalf("Parse(plaintext) error = %v", err)
+	}
+	if len(script.Warnings) != 1 || !strings.Contains(script.Warnings[0], "line 3: keep-extra is not used") {
+		t.Errorf("Warnings = %v, want a keep-extra warning for line 3", script.Warnings)
+	}
+}
+
+func TestParse_OnFormatMismatch(t *testing.T) {
+	script, err := Parse("# version 1\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if script.OnFormatMismatch != FormatMismatchError {
+		t.Errorf("OnFormatMismatch = %q, want %q", script.OnFormatMismatch, FormatMismatchError)
+	}
+
+	script, err = Parse("# version 1\n# on-format-mismatch overwrite\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse(on-format-mismatch overwrite) error = %v", err)
+	}
+	if script.OnFormatMismatch != FormatMismatchOverwrite {
+		t.Errorf("OnFormatMismatch = %q, want %q", script.OnFormatMismatch, FormatMismatchOverwrite)
+	}
+
+	if _, err := Parse("# version 1\n# on-format-mismatch ignore\n#---\n{}\n"); err == nil {
+		t.Error("Parse() should reject an unknown on-format-mismatch value")
+	}
+
+	script, err = Parse("# version 1\n# format plaintext\n# on-format-mismatch overwrite\n#---\nx\n")
+	if err != nil {
+		t.Fatalf("Parse(plaintext) error = %v", err)
+	}
+	if len(script.Warnings) != 1 || !strings.Contains(script.Warnings[0], "line 3: on-format-mismatch is not used") {
+		t.Errorf("Warnings = %v, want an on-format-mismatch warning for line 3", script.Warnings)
+	}
+}
+
+func TestParse_CanonicalOrder(t *testing.T) {
+	script, err := Parse("# version 1\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if script.CanonicalOrder != merge.OrderMerged {
+		t.Errorf("CanonicalOrder = %q, want %q", script.CanonicalOrder, merge.OrderMerged)
+	}
+
+	script, err = Parse("# version 1\n# canonical-order template\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse(canonical-order template) error = %v", err)
+	}
+	if script.CanonicalOrder != merge.OrderTemplate {
+		t.Errorf("CanonicalOrder = %q, want %q", script.CanonicalOrder, merge.OrderTemplate)
+	}
+
+	if _, err := Parse("# version 1\n# canonical-order sorted\n#---\n{}\n"); err == nil {
+		t.Error("Parse() should reject an unknown canonical-order")
+	}
+
+	script, err = Parse("# version 1\n# format plaintext\n# canonical-order template\n#---\nx\n")
+	if err != nil {
+		t.Fatalf("Parse(plaintext) error = %v", err)
+	}
+	if len(script.Warnings) != 1 || !strings.Contains(script.Warnings[0], "line 3: canonical-order is not used") {
+		t.Errorf("Warnings = %v, want a canonical-order warning for line 3", script.Warnings)
+	}
+}
+
+func TestParse_RequirePreservation(t *testing.T) {
+	script, err := Parse("# version 1\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if script.RequirePreservation {
+		t.Error("RequirePreservation should default to false")
+	}
+
+	script, err = Parse("# version 1\n# require-preservation true\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse(require-preservation true) error = %v", err)
+	}
+	if !script.RequirePreservation {
+		t.Error("RequirePreservation = false, want true")
+	}
+
+	if _, err := Parse("# version 1\n# require-preservation 1\n#---\n{}\n"); err == nil {
+		t.Error("Parse() should reject a non-boolean require-preservation")
+	}
+
+	script, err = Parse("# version 1\n# format plaintext\n# require-preservation true\n#---\nx\n")
+	if err != nil {
+		t.Fatalf("Parse(plaintext) error = %v", err)
+	}
+	if len(script.Warnings) != 1 || !strings.Contains(script.Warnings[0], "line 3: require-preservation is not used") {
+		t.Errorf("Warnings = %v, want a require-preservation warning for line 3", script.Warnings)
+	}
+}
+
+func TestParse_Delete(t *testing.T) {
+	script, err := Parse("# version 1\n# delete [\"flags\", \"old\"]\n# delete [[\"a\"], [\"b\"]]\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	var got []string
+	for _, p := range script.DeletePaths {
+		got = append(got, p.String())
+	}
+	want := []string{`["flags","old"]`, `["a"]`, `["b"]`}
+	if !slices.Equal(got, want) {
+		t.Errorf("DeletePaths = %v, want %v", got, want)
+	}
+
+	if _, err := Parse("# version 1\n# delete flags\n#---\n{}\n"); err == nil {
+		t.Error("Parse() should reject an invalid delete path")
+	}
+
+	script, err = Parse("# version 1\n# format plaintext\n# delete [\"x\"]\n#---\nx\n")
+	if err != nil {
+		t.Fatalf("Parse(plaintext) error = %v", err)
+	}
+	if len(script.Warnings) != 1 || !strings.Contains(script.Warnings[0], "line 3: delete is not used") {
+		t.Errorf("Warnings = %v, want a delete warning for line 3", script.Warnings)
+	}
+}
+
+func TestParse_PreserveIfMissing(t *testing.T) {
+	script, err := Parse("# version 1\n# ignore [\"theme\"]\n# preserve-if-missing [\"theme\"]\n# preserve-if-missing [\"editor\", \"fontSize\"]\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	var got []string
+	for _, p := range script.PreserveIfMissing {
+		got = append(got, p.String())
+	}
+	want := []string{`["theme"]`, `["editor","fontSize"]`}
+	if !slices.Equal(got, want) {
+		t.Errorf("PreserveIfMissing = %v, want %v", got, want)
+	}
+	if len(script.Warnings) != 1 || !strings.Contains(script.Warnings[0], "line 3: path [\"theme\"] is both ignored and preserve-if-missing") {
+		t.Errorf("Warnings = %v, want a precedence warning for line 3", script.Warnings)
+	}
+
+	if _, err := Parse("# version 1\n# preserve-if-missing [theme]\n#---\n{}\n"); err == nil {
+		t.Error("Parse() should reject an invalid preserve-if-missing path")
+	}
+}
+
+func TestParse_SSHConfigHeader(t *testing.T) {
+	script, err := Parse("# version 1\n# format sshconfig\n#---\n# Managed by chezmoi\n\nHost github.com\n    User git\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if script.Header != "# Managed by chezmoi\n" {
+		t.Errorf("Header = %q, want the comment and blank line", script.Header)
+	}
+	if script.Template != "Host github.com\n    User git" {
+		t.Errorf("Template = %q, want the Host block", script.Template)
+	}
+}
+
+func TestParse_JSONBareValue(t *testing.T) {
+	script, err := Parse("# version 1\n# format json\n#---\n// Disabled on this machine\nnull\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if script.Header != "// Disabled on this machine" {
+		t.Errorf("Header = %q, want the comment", script.Header)
+	}
+	if script.Template != "null" {
+		t.Errorf("Template = %q, want null", script.Template)
+	}
+}
+
+func TestParse_FallbackCurrent(t *testing.T) {
+	script, err := Parse("# version 1\n# fallback-current ~/.app.toml\n# fallback-current /etc/app.toml\n#---\nkey = 1\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := []string{"~/.app.toml", "/etc/app.toml"}
+	if !slices.Equal(script.FallbackCurrent, want) {
+		t.Errorf("FallbackCurrent = %v, want %v", script.FallbackCurrent, want)
+	}
+
+	if _, err := Parse("# fallback-current ~/.app.toml\n# version 1\n#---\nkey = 1\n"); err == nil {
+		t.Error("Parse() should require version before fallback-current")
+	}
+}
+
+func TestParse_OverlappingIgnores(t *testing.T) {
+	tests := []struct {
+		name    string
+		ignores string
+		want    []string
+	}{
+		{
+			name:    "ancestor then descendant",
+			ignores: "# ignore [\"agent\"]\n# ignore [\"agent\", \"model\"]\n",
+			want:    []string{`line 3: ignore path ["agent","model"] is inside ignore path ["agent"], which already preserves it`},
+		},
+		{
+			name:    "descendant then ancestor",
+			ignores: "# ignore [[\"agent\", \"model\"], [\"agent\"]]\n",
+			want:    []string{`line 2: ignore path ["agent","model"] is inside ignore path ["agent"], which already preserves it`},
+		},
+		{
+			name:    "wildcard ancestor",
+			ignores: "# ignore [\"servers\", \"*\"]\n# ignore [\"servers\", \"a\", \"enabled\"]\n",
+			want:    []string{`line 3: ignore path ["servers","a","enabled"] is inside ignore path ["servers","*"], which already preserves it`},
+		},
+		{
+			name:    "recursive wildcard ancestor",
+			ignores: "# ignore [\"**\", \"telemetry\"]\n# ignore [\"app\", \"telemetry\", \"level\"]\n",
+			want:    []string{`line 3: ignore path ["app","telemetry","level"] is inside ignore path ["**","telemetry"], which already preserves it`},
+		},
+		{
+			name:    "unrelated paths",
+			ignores: "# ignore [\"agent\", \"model\"]\n# ignore [\"agent\", \"theme\"]\n# ignore [\"editor\"]\n",
+		},
+		{
+			name:    "sibling wildcard is not an ancestor",
+			ignores: "# ignore [\"servers\", \"a\"]\n# ignore [\"servers\", \"*\", \"enabled\"]\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, err := Parse("# version 1\n" + tt.ignores + "#---\n{}\n")
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !slices.Equal(script.Warnings, tt.want) {
+				t.Errorf("Warnings = %q, want %q", script.Warnings, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_PlaintextFormat(t *testing.T) {
+	content := `#!/usr/bin/env chezmoi-split
+# version 1
+# format plaintext
+#---
+# chezmoi:managed
+set number
+set expandtab
+
+# chezmoi:ignored
+colorscheme gruvbox
+
+# chezmoi:end
+`
+	script, err := Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if script.Format != "plaintext" {
+		t.Errorf("Format = %q, want %q", script.Format, "plaintext")
+	}
+	// For plaintext, Header should be empty (no header/content split)
+	if script.Header != "" {
+		t.Errorf("Header = %q, want empty for plaintext", script.Header)
+	}
+	// Template should contain everything after #---
+	if !contains(script.Template, "chezmoi:managed") {
+		t.Errorf("Template should contain chezmoi:managed marker")
+	}
+	if !contains(script.Template, "set number") {
+		t.Errorf("Template should contain 'set number'")
+	}
+}
+
+func TestParse_PlaintextWithIgnoreWarning(t *testing.T) {
+	content := `#!/usr/bin/env chezmoi-split
+# version 1
+# format plaintext
+# ignore ["should", "warn"]
+#---
+# chezmoi:managed
+some content
+# chezmoi:end
+`
+	script, err := Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if len(script.Warnings) == 0 {
+		t.Error("Expected warning for ignore directive with plaintext format")
+	}
+
+	foundWarning := false
+	for _, w := range script.Warnings {
+		if contains(w, "ignore") {
+			foundWarning = true
+			break
+		}
+	}
+	if !foundWarning {
+		t.Errorf("Expected warning about ignore directives, got: %v", script.Warnings)
+	}
+}
+
+func TestParse_PlaintextWithStripCommentsWarning(t *testing.T) {
+	content := `#!/usr/bin/env chezmoi-split
+# version 1
+# format plaintext
+# strip-comments true
+#---
+# chezmoi:managed
+some content
+# chezmoi:end
+`
+	script, err := Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if len(script.Warnings) == 0 {
+		t.Error("Expected warning for strip-comments with plaintext format")
+	}
+
+	foundWarning := false
+	for _, w := range script.Warnings {
+		if contains(w, "strip-comments") {
+			foundWarning = true
+			break
+		}
+	}
+	if !foundWarning {
+		t.Errorf("Expected warning about strip-comments, got: %v", script.Warnings)
+	}
+}
+
+func TestParse_JSON5(t *testing.T) {
+	content := `#!/usr/bin/env chezmoi-split
+# version 1
+# format json
+# json5 true
+#---
+{theme: 'dark',}
+`
+	script, err := Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !script.JSON5 {
+		t.Error("JSON5 = false, want true")
+	}
+	if len(script.Warnings) != 0 {
+		t.Errorf("Warnings = %v, want none", script.Warnings)
+	}
+
+	if _, err := Parse("# version 1\n# json5 yes\n#---\n{}\n"); err == nil || !contains(err.Error(), "json5 must be true or false") {
+		t.Errorf("Parse() error = %v, want a json5 value error", err)
+	}
+
+	script, err = Parse("# version 1\n# format toml\n# json5 true\n#---\nkey = 1\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(script.Warnings) != 1 || !contains(script.Warnings[0], "line 3: json5 is only used with json format") {
+		t.Errorf("Warnings = %v, want a json5 warning", script.Warnings)
+	}
+}
+
+func TestParse_MatchKey(t *testing.T) {
+	script, err := Parse("# version 1\n# format jsonl\n# match-key name\n#---\n{\"name\":\"a\"}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if script.MatchKey != "name" || len(script.Warnings) != 0 {
+		t.Errorf("MatchKey = %q, Warnings = %v, want name and no warnings", script.MatchKey, script.Warnings)
+	}
+
+	script, err = Parse("# version 1\n# format json\n# match-key name\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(script.Warnings) != 1 || !contains(script.Warnings[0], "line 3: match-key is only used with jsonl format") {
+		t.Errorf("Warnings = %v, want a match-key warning", script.Warnings)
+	}
+
+	if _, err := Parse("# version 1\n# format jsonl\n# fingerprint true\n#---\n{}\n"); err == nil || !contains(err.Error(), "line 3: fingerprint is not supported for jsonl format") {
+		t.Errorf("Parse() error = %v, want a fingerprint error", err)
+	}
+
+	script, err = Parse("# version 1\n# format toml\n# fingerprint-key x\n#---\na = 1\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(script.Warnings) != 1 || !contains(script.Warnings[0], "line 3: fingerprint-key is only used with json and plist formats") {
+		t.Errorf("Warnings = %v, want a fingerprint-key warning", script.Warnings)
+	}
+}
+
+func TestParse_BoolAndNullStyle(t *testing.T) {
+	script, err := Parse("# version 1\n# format yaml\n# bool-style on\n# null-style omit\n#---\nkey: 1\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if script.BoolStyle != "on" || script.NullStyle != "omit" {
+		t.Errorf("BoolStyle, NullStyle = %q, %q, want on, omit", script.BoolStyle, script.NullStyle)
+	}
+	if len(script.Warnings) != 0 {
+		t.Errorf("Warnings = %v, want none", script.Warnings)
+	}
+
+	for _, input := range []string{"# bool-style True", "# null-style none"} {
+		if _, err := Parse("# version 1\n" + input + "\n#---\n{}\n"); err == nil || !contains(err.Error(), "must be one of") {
+			t.Errorf("Parse(%q) error = %v, want a value error", input, err)
+		}
+	}
+
+	script, err = Parse("# version 1\n# format json\n# bool-style yes\n# null-style omit\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(script.Warnings) != 1 || !contains(script.Warnings[0], "line 3: bool-style is only used with ini, phpini, and yaml formats") {
+		t.Errorf("Warnings = %v, want a bool-style warning", script.Warnings)
+	}
+
+	script, err = Parse("# version 1\n# format ini\n# null-style omit\n#---\n[a]\nb = c\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(script.Warnings) != 1 || !contains(script.Warnings[0], "line 3: null-style is only used with json and yaml formats") {
+		t.Errorf("Warnings = %v, want a null-style warning", script.Warnings)
+	}
+}
+
+func TestParse_OutputAndFinalNewline(t *testing.T) {
+	script, err := Parse("# version 1\n# format json\n# output compact\n# final-newline false\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if script.Output != "compact" || !script.OmitFinalNewline {
+		t.Errorf("Output, OmitFinalNewline = %q, %v, want compact, true", script.Output, script.OmitFinalNewline)
+	}
+	if len(script.Warnings) != 0 {
+		t.Errorf("Warnings = %v, want none", script.Warnings)
+	}
+
+	for _, input := range []string{"# output minified", "# final-newline no"} {
+		if _, err := Parse("# version 1\n" + input + "\n#---\n{}\n"); err == nil || !contains(err.Error(), "must be") {
+			t.Errorf("Parse(%q) error = %v, want a value error", input, err)
+		}
+	}
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"# format yaml\n# output compact\n", "line 3: output is only used with json format"},
+		{"# format json\n# indent 4\n# output compact\n", "line 3: indent has no effect with output compact"},
+		{"# format json\n# keep-comments true\n# output compact\n", "line 3: keep-comments has no effect with output compact"},
+		{"# format toml\n# final-newline false\n", "line 3: final-newline is only used with json and plaintext formats"},
+	}
+	for _, tt := range tests {
+		script, err := Parse("# version 1\n" + tt.input + "#---\n{}\n")
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.input, err)
+		}
+		if len(script.Warnings) != 1 || !contains(script.Warnings[0], tt.want) {
+			t.Errorf("Parse(%q) Warnings = %v, want %q", tt.input, script.Warnings, tt.want)
+		}
+	}
+}
+
+func contains(s, substr string) bool {
+	return len(s) >= len(substr) && (s == substr || len(s) > 0 && containsHelper(s, substr))
+}
+
+func containsHelper(s, substr string) bool {
+	for i := 0; i <= len(s)-len(substr); i++ {
+		if s[i:i+len(substr)] == substr {
+			return true
+		}
+	}
+	return false
+}
+
+func TestParse_TemplateLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    int
+	}{
+		{"no header", "#!/usr/bin/env chezmoi-split\n# version 1\n# format json\n#---\n{}\n", 5},
+		{"header lines", "# version 1\n# format json\n#---\n// one\n// two\n{}\n", 6},
+		{"plaintext keeps everything", "# version 1\n# format plaintext\n#---\n# note\nx\n", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, err := Parse(tt.content)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if script.TemplateLine != tt.want {
+				t.Errorf("TemplateLine = %d, want %d", script.TemplateLine, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_Compute(t *testing.T) {
+	script, err := Parse(`# version 1
+# format json
+# compute ["font"] template="{{ index .current \"family\" }} {{ index .current \"size\" }}" requires=[["family"],["size"]]
+# compute ["editor", "title"] requires=["window", "title"] template="{{ .current.window.title }}"
+#---
+{"font": "Menlo 12"}
+`)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(script.Computes) != 2 {
+		t.Fatalf("got %d computes, want 2", len(script.Computes))
+	}
+	font := script.Computes[0]
+	if font.Path.String() != `["font"]` || len(font.Requires) != 2 || font.Requires[1].String() != `["size"]` {
+		t.Errorf("compute = %s requires %v, want [\"font\"] requires family and size", font.Path, font.Requires)
+	}
+	if len(script.Computes[1].Requires) != 1 {
+		t.Errorf("second compute requires %v, want one path", script.Computes[1].Requires)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"no template", `["font"] requires=["family"]`, `compute ["font"] needs a template="..." option`},
+		{"no requires", `["font"] template="x"`, `compute ["font"] needs a requires=[...] option`},
+		{"unquoted template", `["font"] template={{ .current }} requires=["a"]`, "compute template must be a quoted string"},
+		{"bad template", `["font"] template="{{ .current" requires=["a"]`, "invalid compute template"},
+		{"undefined function", `["font"] template="{{ env \"HOME\" }}" requires=["a"]`, `function "env" not defined`},
+		{"wildcard path", `["*", "font"] template="x" requires=["a"]`, "must name a single value"},
+		{"wildcard requires", `["font"] template="x" requires=["*"]`, "must name a single value"},
+		{"unknown option", `["font"] template="x" requires=["a"] when=always`, `unknown compute option "when"`},
+		{"overlapping requires", `["font"] template="x" requires=[["a"],["a","b"]]`, `compute requires paths ["a"] and ["a","b"] overlap`},
+		{"bad path", `font template="x"`, "invalid compute path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse("# version 1\n# format json\n# compute " + tt.value + "\n#---\n{}\n")
+			if err == nil || !contains(err.Error(), "line 3: ") || !contains(err.Error(), tt.want) {
+				t.Errorf("Parse() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_Encoding(t *testing.T) {
+	script, err := Parse("# version 1\n# format ini\n# encoding iso-8859-1\n#---\n[user]\nname = Zo\xeb\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if script.Encoding != "latin1" {
+		t.Errorf("Encoding = %q, want latin1", script.Encoding)
+	}
+	// A Latin-1 template is read as Latin-1
+	if want := "[user]\nname = Zoë"; script.Template != want {
+		t.Errorf("Template = %q, want %q", script.Template, want)
+	}
+
+	// A UTF-8 template stays as written
+	script, err = Parse("# version 1\n# format ini\n# encoding latin1\n#---\n[user]\nname = Zoë\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if want := "[user]\nname = Zoë"; script.Template != want {
+		t.Errorf("Template = %q, want %q", script.Template, want)
+	}
+
+	if _, err := Parse("# version 1\n# encoding cp1252\n#---\n{}\n"); err == nil || !contains(err.Error(), "line 2: encoding must be one of") {
+		t.Errorf("Parse() error = %v, want an encoding error", err)
+	}
+}
+
+func TestParse_IgnoreRoot(t *testing.T) {
+	script, err := Parse("# version 1\n# format json\n# ignore []\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(script.IgnorePaths) != 1 || len(script.IgnorePaths[0].Segments()) != 0 || len(script.Warnings) != 0 {
+		t.Errorf("IgnorePaths = %v, Warnings = %v, want the root path and no warnings", script.IgnorePaths, script.Warnings)
+	}
+
+	script, err = Parse(`# version 1
+# format json
+# keep-extra true
+# ignore ["theme"]
+# ignore []
+# preserve-if-missing ["font"]
+#---
+{}
+`)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := []string{
+		`line 5: ignore path ["theme"] is inside ignore path [], which already preserves it`,
+		`line 6: preserve-if-missing path ["font"] has no effect; ignore [] on line 5 keeps the whole current file`,
+		`line 3: keep-extra has no effect; ignore [] on line 5 keeps the whole current file`,
+	}
+	if !reflect.DeepEqual(script.Warnings, want) {
+		t.Errorf("Warnings =\n%s\nwant:\n%s", strings.Join(script.Warnings, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParse_Indent(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"2", "  "},
+		{"4", "    "},
+		{"tab", "\t"},
+		{`"\t\t"`, "\t\t"},
+	}
+	for _, tt := range tests {
+		script, err := Parse("# version 1\n# format json\n# indent " + tt.value + "\n#---\n{}\n")
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.value, err)
+		}
+		if script.Indent != tt.want {
+			t.Errorf("Parse(%q) Indent = %q, want %q", tt.value, script.Indent, tt.want)
+		}
+	}
+
+	script, err := Parse("# version 1\n# format toml\n# indent 4\n#---\na = 1\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(script.Warnings) != 1 || !strings.Contains(script.Warnings[0], "line 3: indent is only used with json, yaml, and plist formats") {
+		t.Errorf("Warnings = %v, want an indent warning for line 3", script.Warnings)
+	}
+
+	if _, err := Parse("# version 1\n# indent wide\n#---\n{}\n"); err == nil || !contains(err.Error(), "line 2: indent must be") {
+		t.Errorf("Parse() error = %v, want an indent error", err)
+	}
+}
+
+func TestParse_KeepComments(t *testing.T) {
+	script, err := Parse("# version 1\n# format json\n# keep-comments true\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !script.KeepComments || len(script.Warnings) != 0 {
+		t.Errorf("KeepComments = %v, Warnings = %v, want true and no warnings", script.KeepComments, script.Warnings)
+	}
+
+	script, err = Parse("# version 1\n# format toml\n# keep-comments true\n#---\na = 1\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(script.Warnings) != 1 || !strings.Contains(script.Warnings[0], "line 3: keep-comments is only used with json format") {
+		t.Errorf("Warnings = %v, want a keep-comments warning for line 3", script.Warnings)
+	}
+
+	if _, err := Parse("# version 1\n# keep-comments yes\n#---\n{}\n"); err == nil || !contains(err.Error(), "line 2: keep-comments must be true or false") {
+		t.Errorf("Parse() error = %v, want a keep-comments error", err)
+	}
+}
+
+func TestParse_CommentPrefix(t *testing.T) {
+	script, err := Parse("# version 1\n# format plaintext\n# comment-prefix vim\n#---\nset number\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if script.CommentPrefix != `"` {
+		t.Errorf("CommentPrefix = %q, want %q", script.CommentPrefix, `"`)
+	}
+	if len(script.Warnings) != 0 {
+		t.Errorf("Warnings = %v, want none", script.Warnings)
+	}
+
+	script, err = Parse("# version 1\n# format json\n# comment-prefix \"//\"\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(script.Warnings) != 1 || !strings.Contains(script.Warnings[0], "line 3: comment-prefix is only used with plaintext format") {
+		t.Errorf("Warnings = %v, want a comment-prefix warning for line 3", script.Warnings)
+	}
+
+	if _, err := Parse("# version 1\n# comment-prefix rem\n#---\nx\n"); err == nil || !contains(err.Error(), "line 2: comment prefix \"rem\" must be a preset") {
+		t.Errorf("Parse() error = %v, want a comment-prefix error", err)
+	}
+}
+
+func TestScript_StripHeader(t *testing.T) {
+	script, err := Parse("# version 1\n# format json\n#---\n// Managed by chezmoi\n//   Edit the source instead\n\n{\"a\": 1}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		current string
+		want    string
+	}{
+		{"exact", "// Managed by chezmoi\n//   Edit the source instead\n\n{\"a\": 2}\n", "{\"a\": 2}\n"},
+		{"whitespace differs", "//  Managed by chezmoi  \r\n// Edit the source instead\r\n{\"a\": 2}\r\n", "{\"a\": 2}\r\n"},
+		{"different banner", "// Written by the app\n{\"a\": 2}\n", "// Written by the app\n{\"a\": 2}\n"},
+		{"banner with extra line", "// Managed by chezmoi\n// Edit the source instead\n// note\n{}\n", "// Managed by chezmoi\n// Edit the source instead\n// note\n{}\n"},
+		{"no banner", "{\"a\": 2}\n", "{\"a\": 2}\n"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(script.StripHeader([]byte(tt.current))); got != tt.want {
+				t.Errorf("StripHeader() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Without a header there is nothing to strip
+	script, err = Parse("# version 1\n# format json\n#---\n{}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got := string(script.StripHeader([]byte("// x\n{}\n"))); got != "// x\n{}\n" {
+		t.Errorf("StripHeader() = %q, want the input", got)
 	}
-	return false
 }
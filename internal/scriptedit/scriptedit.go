@@ -0,0 +1,197 @@
+// Package scriptedit changes the directives in the header of a chezmoi-split
+// script as text, leaving every other line, including the template, as it
+// was. It backs the bulk-edit command, whose language is deliberately
+// small: a condition matches a directive's value exactly, and a change sets
+// or removes one directive.
+package scriptedit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// separator starts the template, optionally followed by a format.
+const separator = "#---"
+
+// Condition matches scripts whose directive Name has the value Value.
+type Condition struct {
+	Name  string
+	Value string
+}
+
+// Change sets the directive Name to Value, or removes it when Unset is set.
+type Change struct {
+	Name  string
+	Value string
+	Unset bool
+}
+
+// ParseCondition parses a condition written as name=value.
+func ParseCondition(s string) (Condition, error) {
+	name, value, err := splitAssignment(s)
+	if err != nil {
+		return Condition{}, err
+	}
+	return Condition{Name: name, Value: value}, nil
+}
+
+// ParseSet parses a change written as name=value.
+func ParseSet(s string) (Change, error) {
+	name, value, err := splitAssignment(s)
+	if err != nil {
+		return Change{}, err
+	}
+	if value == "" {
+		return Change{}, fmt.Errorf("%q: value is empty; use unset to remove %s", s, name)
+	}
+	return Change{Name: name, Value: value}, nil
+}
+
+// ParseUnset parses the name of a directive to remove.
+func ParseUnset(s string) (Change, error) {
+	name := strings.TrimSpace(s)
+	if err := checkName(name); err != nil {
+		return Change{}, err
+	}
+	if name == "version" {
+		return Change{}, fmt.Errorf("the version directive cannot be removed")
+	}
+	return Change{Name: name, Unset: true}, nil
+}
+
+// splitAssignment splits name=value, trimming both sides.
+func splitAssignment(s string) (string, string, error) {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return "", "", fmt.Errorf("%q: expected name=value", s)
+	}
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	if err := checkName(name); err != nil {
+		return "", "", err
+	}
+	return name, value, nil
+}
+
+// checkName rejects names that can't be a directive.
+func checkName(name string) error {
+	if name == "" || strings.ContainsAny(name, " \t=#") {
+		return fmt.Errorf("invalid directive name %q", name)
+	}
+	return nil
+}
+
+// directive is one directive line of a header.
+type directive struct {
+	line  int // Index into the script's lines
+	name  string
+	value string
+}
+
+// header returns the directive lines of content, and the index of the
+// separator line (-1 if there is none).
+func header(lines []string) ([]directive, int) {
+	var directives []directive
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if i == 0 && strings.HasPrefix(trimmed, "#!") {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(trimmed, separator); ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+			return directives, i
+		}
+		text, ok := strings.CutPrefix(trimmed, "# ")
+		if !ok {
+			continue
+		}
+		name, value, _ := strings.Cut(text, " ")
+		directives = append(directives, directive{line: i, name: name, value: strings.TrimSpace(value)})
+	}
+	return directives, -1
+}
+
+// Values returns the values of every name directive in content's header,
+// in order.
+func Values(content, name string) []string {
+	directives, _ := header(strings.Split(content, "\n"))
+	var values []string
+	for _, d := range directives {
+		if d.name == name {
+			values = append(values, d.value)
+		}
+	}
+	return values
+}
+
+// Matches reports whether content's header has a c.Name directive with
+// the value c.Value.
+func (c Condition) Matches(content string) bool {
+	for _, v := range Values(content, c.Name) {
+		if v == c.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns content with changes made in order, and a summary of each
+// change that did something ("set format toml (was ini)"). A set replaces
+// the directive's line, or adds one after the last directive; a directive
+// that appears more than once, such as ignore, can only be removed. An
+// unset removes every line of the directive.
+func Apply(content string, changes []Change) (string, []string, error) {
+	lines := strings.Split(content, "\n")
+	var summary []string
+	for _, c := range changes {
+		directives, sep := header(lines)
+		if sep < 0 {
+			return "", nil, fmt.Errorf("no %s separator", separator)
+		}
+		var matches []directive
+		for _, d := range directives {
+			if d.name == c.Name {
+				matches = append(matches, d)
+			}
+		}
+
+		switch {
+		case c.Unset:
+			if len(matches) == 0 {
+				continue
+			}
+			for i := len(matches) - 1; i >= 0; i-- {
+				lines = append(lines[:matches[i].line], lines[matches[i].line+1:]...)
+			}
+			summary = append(summary, fmt.Sprintf("unset %s (was %s)", c.Name, joinValues(matches)))
+
+		case len(matches) > 1:
+			return "", nil, fmt.Errorf("%s appears %d times; only a single directive can be set", c.Name, len(matches))
+
+		case len(matches) == 1:
+			d := matches[0]
+			if d.value == c.Value {
+				continue
+			}
+			indent := lines[d.line][:len(lines[d.line])-len(strings.TrimLeft(lines[d.line], " \t"))]
+			lines[d.line] = indent + "# " + c.Name + " " + c.Value
+			summary = append(summary, fmt.Sprintf("set %s %s (was %s)", c.Name, c.Value, d.value))
+
+		default:
+			at := sep
+			if len(directives) > 0 {
+				at = directives[len(directives)-1].line + 1
+			}
+			lines = append(lines[:at], append([]string{"# " + c.Name + " " + c.Value}, lines[at:]...)...)
+			summary = append(summary, fmt.Sprintf("set %s %s", c.Name, c.Value))
+		}
+	}
+	return strings.Join(lines, "\n"), summary, nil
+}
+
+// joinValues lists the values of directives for a summary.
+func joinValues(directives []directive) string {
+	values := make([]string, len(directives))
+	for i, d := range directives {
+		values[i] = d.value
+	}
+	return strings.Join(values, ", ")
+}
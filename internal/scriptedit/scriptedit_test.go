@@ -0,0 +1,171 @@
+package scriptedit
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sample = `#!/usr/bin/env chezmoi-split
+# version 1
+# format ini
+# ignore ["user", "email"]
+# ignore ["user", "name"]
+#---
+# not a directive
+[user]
+email = jane@example.com
+`
+
+func TestParseCondition(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Condition
+		wantErr bool
+	}{
+		{in: "format=ini", want: Condition{Name: "format", Value: "ini"}},
+		{in: " format = ini ", want: Condition{Name: "format", Value: "ini"}},
+		{in: `ignore=["a", "b=c"]`, want: Condition{Name: "ignore", Value: `["a", "b=c"]`}},
+		{in: "json5=", want: Condition{Name: "json5"}},
+		{in: "format", wantErr: true},
+		{in: "=ini", wantErr: true},
+		{in: "bad name=x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCondition(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCondition(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseCondition(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseSetAndUnset(t *testing.T) {
+	if got, err := ParseSet("format=toml"); err != nil || got != (Change{Name: "format", Value: "toml"}) {
+		t.Errorf("ParseSet() = %+v, %v", got, err)
+	}
+	if _, err := ParseSet("format="); err == nil {
+		t.Error("ParseSet() should reject an empty value")
+	}
+	if _, err := ParseSet("format"); err == nil {
+		t.Error("ParseSet() should reject a missing =")
+	}
+	if got, err := ParseUnset("json5"); err != nil || got != (Change{Name: "json5", Unset: true}) {
+		t.Errorf("ParseUnset() = %+v, %v", got, err)
+	}
+	if _, err := ParseUnset("json5=true"); err == nil {
+		t.Error("ParseUnset() should reject a value")
+	}
+	if _, err := ParseUnset("version"); err == nil {
+		t.Error("ParseUnset() should refuse to remove version")
+	}
+}
+
+func TestCondition_Matches(t *testing.T) {
+	tests := []struct {
+		cond Condition
+		want bool
+	}{
+		{Condition{"format", "ini"}, true},
+		{Condition{"format", "toml"}, false},
+		{Condition{"ignore", `["user", "name"]`}, true},
+		{Condition{"json5", "true"}, false},
+		// Lines after the separator are template, not directives
+		{Condition{"not", "a directive"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.cond.Matches(sample); got != tt.want {
+			t.Errorf("%+v.Matches() = %v, want %v", tt.cond, got, tt.want)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name        string
+		changes     []Change
+		want        string // Header lines after the shebang, up to the separator
+		wantSummary []string
+		wantErr     string
+	}{
+		{
+			name:        "replace a value",
+			changes:     []Change{{Name: "format", Value: "toml"}},
+			want:        "# version 1\n# format toml\n# ignore [\"user\", \"email\"]\n# ignore [\"user\", \"name\"]\n",
+			wantSummary: []string{"set format toml (was ini)"},
+		},
+		{
+			name:        "add after the last directive",
+			changes:     []Change{{Name: "canonical-order", Value: "template"}},
+			want:        "# version 1\n# format ini\n# ignore [\"user\", \"email\"]\n# ignore [\"user\", \"name\"]\n# canonical-order template\n",
+			wantSummary: []string{"set canonical-order template"},
+		},
+		{
+			name:    "same value is no change",
+			changes: []Change{{Name: "format", Value: "ini"}},
+			want:    "# version 1\n# format ini\n# ignore [\"user\", \"email\"]\n# ignore [\"user\", \"name\"]\n",
+		},
+		{
+			name:        "unset every line",
+			changes:     []Change{{Name: "ignore", Unset: true}},
+			want:        "# version 1\n# format ini\n",
+			wantSummary: []string{`unset ignore (was ["user", "email"], ["user", "name"])`},
+		},
+		{
+			name:    "unset a missing directive is no change",
+			changes: []Change{{Name: "json5", Unset: true}},
+			want:    "# version 1\n# format ini\n# ignore [\"user\", \"email\"]\n# ignore [\"user\", \"name\"]\n",
+		},
+		{
+			name:    "repeated directive cannot be set",
+			changes: []Change{{Name: "ignore", Value: `["x"]`}},
+			wantErr: "ignore appears 2 times",
+		},
+		{
+			name:        "changes apply in order",
+			changes:     []Change{{Name: "ignore", Unset: true}, {Name: "ignore", Value: `["x"]`}},
+			want:        "# version 1\n# format ini\n# ignore [\"x\"]\n",
+			wantSummary: []string{`unset ignore (was ["user", "email"], ["user", "name"])`, `set ignore ["x"]`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, summary, err := Apply(sample, tt.changes)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Apply() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			_, template, _ := strings.Cut(sample, "#---\n")
+			want := "#!/usr/bin/env chezmoi-split\n" + tt.want + "#---\n" + template
+			if got != want {
+				t.Errorf("Apply() =\n%s\nwant:\n%s", got, want)
+			}
+			if !reflect.DeepEqual(summary, tt.wantSummary) {
+				t.Errorf("summary = %q, want %q", summary, tt.wantSummary)
+			}
+		})
+	}
+}
+
+func TestApply_KeepsIndentAndNeedsSeparator(t *testing.T) {
+	got, _, err := Apply("#!/usr/bin/env chezmoi-split\n  # version 1\n  # format ini\n#--- \n{}\n", []Change{{Name: "format", Value: "json"}})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if !strings.Contains(got, "\n  # format json\n") {
+		t.Errorf("Apply() = %q, want the line's indentation kept", got)
+	}
+
+	if _, _, err := Apply("# version 1\n# format ini\n", []Change{{Name: "format", Value: "json"}}); err == nil {
+		t.Error("Apply() should fail without a separator")
+	}
+}
@@ -0,0 +1,233 @@
+// Package stats records how often each ignore rule matches the current file,
+// so rules the app no longer writes can be found and removed.
+package stats
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thirteen37/chezmoi-split/internal/atomicfile"
+	"github.com/thirteen37/chezmoi-split/internal/merge"
+)
+
+// DirEnv names the environment variable that enables stats recording and
+// sets the directory the stats files are written to.
+const DirEnv = "CHEZMOI_SPLIT_STATS_DIR"
+
+// DefaultStaleAfter is the number of runs without a match after which a rule
+// is suggested for removal.
+const DefaultStaleAfter = 10
+
+// Rule holds the counters for one ignore rule.
+type Rule struct {
+	Hits       int       `json:"hits"`
+	Misses     int       `json:"misses"`
+	LastHit    time.Time `json:"last_hit"`
+	LastHitRun int       `json:"last_hit_run,omitempty"` // Run number of the last hit (1-based)
+	FirstRun   int       `json:"first_run"`              // Run number the rule was first seen in
+}
+
+// File is the persisted stats for one target.
+type File struct {
+	Target string           `json:"target"`
+	Runs   int              `json:"runs"`
+	Rules  map[string]*Rule `json:"rules"`
+}
+
+// RuleSummary is a rule's counters together with its name, for reporting.
+type RuleSummary struct {
+	Name string
+	Rule
+	RunsSinceHit int  // Runs since the last hit, or since the rule was first seen if it never hit
+	Stale        bool // No match in at least the requested number of runs
+}
+
+// Record adds the outcome of one merge to the stats for target in dir.
+// A rule counts as a hit when any of its outcomes applied a value from current.
+// The file is rewritten atomically (temp file + rename).
+func Record(dir, target string, report *merge.Report, now time.Time) error {
+	f, err := Load(dir, target)
+	if err != nil {
+		return err
+	}
+
+	hit := make(map[string]bool)
+	var order []string
+	for _, o := range report.Outcomes {
+		rule := o.Path
+		if o.Rule != nil {
+			rule = o.Rule
+		}
+		name := rule.String()
+		if _, seen := hit[name]; !seen {
+			order = append(order, name)
+		}
+		hit[name] = hit[name] || o.Applied
+	}
+
+	f.Runs++
+	for _, name := range order {
+		r, ok := f.Rules[name]
+		if !ok {
+			r = &Rule{FirstRun: f.Runs}
+			f.Rules[name] = r
+		}
+		if hit[name] {
+			r.Hits++
+			r.LastHit = now.UTC()
+			r.LastHitRun = f.Runs
+		} else {
+			r.Misses++
+		}
+	}
+
+	return save(dir, target, f)
+}
+
+// Load reads the stats for target from dir. A missing file yields empty stats.
+func Load(dir, target string) (*File, error) {
+	f := &File{Target: target, Rules: make(map[string]*Rule)}
+
+	data, err := os.ReadFile(fileFor(dir, target))
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats: %w", err)
+	}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("failed to parse stats file %s: %w", fileFor(dir, target), err)
+	}
+	if f.Rules == nil {
+		f.Rules = make(map[string]*Rule)
+	}
+	return f, nil
+}
+
+// save writes f to its file in dir atomically.
+func save(dir, target string, f *File) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create stats directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode stats: %w", err)
+	}
+
+	if err := atomicfile.WriteFile(fileFor(dir, target), append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to write stats: %w", err)
+	}
+	return nil
+}
+
+// fileFor returns the stats file path for target. Targets are hashed so any
+// path maps to a safe, fixed-length file name.
+func fileFor(dir, target string) string {
+	sum := sha256.Sum256([]byte(target))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// Summarize returns the rules of f sorted by last-hit time, oldest first
+// (rules that never hit come first). Rules without a match in at least
+// staleAfter runs are flagged as stale.
+func (f *File) Summarize(staleAfter int) []RuleSummary {
+	summaries := make([]RuleSummary, 0, len(f.Rules))
+	for name, r := range f.Rules {
+		since := f.Runs - r.LastHitRun
+		if r.LastHitRun == 0 {
+			since = f.Runs - r.FirstRun + 1
+		}
+		summaries = append(summaries, RuleSummary{
+			Name:         name,
+			Rule:         *r,
+			RunsSinceHit: since,
+			Stale:        since >= staleAfter,
+		})
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		if !a.LastHit.Equal(b.LastHit) {
+			return a.LastHit.Before(b.LastHit)
+		}
+		return a.Name < b.Name
+	})
+	return summaries
+}
+
+// TargetName derives the target path (relative to the home directory) that
+// stats are keyed by.
+//
+// When chezmoi provides the source file through CHEZMOI_SOURCE_FILE, its
+// relative path is mapped back to the target name (dot_config/zed/
+// modify_settings.json.tmpl ⇒ .config/zed/settings.json). Otherwise the
+// script path is used, relative to CHEZMOI_SOURCE_DIR when it lies inside it.
+func TargetName(scriptPath string) string {
+	source := os.Getenv("CHEZMOI_SOURCE_FILE")
+	if source == "" {
+		source = scriptPath
+		if dir := os.Getenv("CHEZMOI_SOURCE_DIR"); dir != "" {
+			if rel, err := filepath.Rel(dir, scriptPath); err == nil && !strings.HasPrefix(rel, "..") {
+				source = rel
+			}
+		}
+	}
+	if filepath.IsAbs(source) {
+		source = filepath.Base(source)
+	}
+	return SourceTarget(source)
+}
+
+// SourceTarget maps a path relative to the chezmoi source directory to its
+// target name (dot_config/zed/modify_settings.json.tmpl ⇒
+// .config/zed/settings.json).
+func SourceTarget(source string) string {
+	parts := strings.Split(filepath.ToSlash(source), "/")
+	for i, part := range parts {
+		parts[i] = targetComponent(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// attributePrefixes are the chezmoi source-state prefixes stripped from
+// each path component, in the order chezmoi allows them.
+var attributePrefixes = []string{
+	"create_", "modify_", "remove_", "exact_", "encrypted_", "private_",
+	"readonly_", "empty_", "executable_", "symlink_", "literal_",
+}
+
+// targetComponent maps one source-state path component to its target name.
+func targetComponent(name string) string {
+	name = strings.TrimSuffix(name, ".tmpl")
+	for _, prefix := range attributePrefixes {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	if rest, ok := strings.CutPrefix(name, "dot_"); ok {
+		name = "." + rest
+	}
+	return name
+}
+
+// NormalizeTarget turns a target given on the command line (absolute, ~/...,
+// or already relative to home) into the form TargetName produces.
+func NormalizeTarget(target, home string) string {
+	if rest, ok := strings.CutPrefix(target, "~/"); ok {
+		return filepath.ToSlash(filepath.Clean(rest))
+	}
+	if filepath.IsAbs(target) && home != "" {
+		if rel, err := filepath.Rel(home, target); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(filepath.Clean(target))
+}
@@ -0,0 +1,226 @@
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/thirteen37/chezmoi-split/internal/format/json"
+	"github.com/thirteen37/chezmoi-split/internal/merge"
+	"github.com/thirteen37/chezmoi-split/internal/path"
+)
+
+// om builds an ordered map from key-value pairs.
+func om(pairs ...any) *orderedmap.OrderedMap {
+	m := orderedmap.New()
+	for i := 0; i < len(pairs); i += 2 {
+		m.Set(pairs[i].(string), pairs[i+1])
+	}
+	return m
+}
+
+func TestRecord_AggregatesAcrossMerges(t *testing.T) {
+	dir := t.TempDir()
+	handler := json.New()
+	managed := om("theme", "dark", "font", om("size", 12.0))
+	paths := []path.Path{
+		path.NewArrayPath([]string{"theme"}),
+		path.NewArrayPath([]string{"font", "size"}),
+		path.NewArrayPath([]string{"legacy"}),
+		path.NewArrayPath([]string{"**", "telemetry"}),
+	}
+
+	// Three merges: theme always present, font.size only in the first,
+	// telemetry present at two depths in the last, legacy never
+	currents := []*orderedmap.OrderedMap{
+		om("theme", "light", "font", om("size", 14.0)),
+		om("theme", "light"),
+		om("theme", "solar", "telemetry", true, "editor", om("telemetry", false)),
+	}
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, current := range currents {
+		_, report := merge.MergeWithReport(handler, managed, current, paths)
+		if err := Record(dir, ".config/app/settings.json", report, base.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatalf("Record() run %d error = %v", i+1, err)
+		}
+	}
+
+	f, err := Load(dir, ".config/app/settings.json")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if f.Runs != 3 {
+		t.Errorf("Runs = %d, want 3", f.Runs)
+	}
+
+	tests := []struct {
+		rule       string
+		hits       int
+		misses     int
+		lastHitRun int
+	}{
+		{`["theme"]`, 3, 0, 3},
+		{`["font","size"]`, 1, 2, 1},
+		{`["legacy"]`, 0, 3, 0},
+		// Two matches in one run still count as a single hit for the rule
+		{`["**","telemetry"]`, 1, 2, 3},
+	}
+	for _, tt := range tests {
+		r, ok := f.Rules[tt.rule]
+		if !ok {
+			t.Errorf("rule %s not recorded", tt.rule)
+			continue
+		}
+		if r.Hits != tt.hits || r.Misses != tt.misses || r.LastHitRun != tt.lastHitRun {
+			t.Errorf("rule %s = hits %d, misses %d, last hit run %d; want %d, %d, %d",
+				tt.rule, r.Hits, r.Misses, r.LastHitRun, tt.hits, tt.misses, tt.lastHitRun)
+		}
+	}
+
+	if got := f.Rules[`["theme"]`].LastHit; !got.Equal(base.Add(2 * time.Hour)) {
+		t.Errorf("theme LastHit = %v, want %v", got, base.Add(2*time.Hour))
+	}
+}
+
+func TestRecord_TargetsAreSeparate(t *testing.T) {
+	dir := t.TempDir()
+	report := &merge.Report{Outcomes: []merge.Outcome{
+		{Path: path.NewArrayPath([]string{"a"}), Applied: true},
+	}}
+
+	if err := Record(dir, "one.json", report, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := Record(dir, "two.json", report, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := Record(dir, "two.json", report, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	one, _ := Load(dir, "one.json")
+	two, _ := Load(dir, "two.json")
+	if one.Runs != 1 || two.Runs != 2 {
+		t.Errorf("runs = %d, %d; want 1, 2", one.Runs, two.Runs)
+	}
+
+	// Only the stats files remain; temp files are cleaned up
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("stats dir has %d entries, want 2", len(entries))
+	}
+}
+
+func TestLoad_CorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(fileFor(dir, "x"), []byte("not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir, "x"); err == nil {
+		t.Error("Load() should fail on a corrupt stats file")
+	}
+}
+
+func TestRecord_UnwritableDir(t *testing.T) {
+	// A file where the directory should be makes recording fail without panicking
+	blocker := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	report := &merge.Report{Outcomes: []merge.Outcome{{Path: path.NewArrayPath([]string{"a"})}}}
+	if err := Record(filepath.Join(blocker, "stats"), "t", report, time.Now()); err == nil {
+		t.Error("Record() should fail when the stats directory can't be created")
+	}
+}
+
+func TestFile_Summarize(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := &File{
+		Runs: 20,
+		Rules: map[string]*Rule{
+			`["recent"]`: {Hits: 20, LastHit: base.Add(20 * time.Hour), LastHitRun: 20, FirstRun: 1},
+			`["old"]`:    {Hits: 5, Misses: 15, LastHit: base.Add(5 * time.Hour), LastHitRun: 5, FirstRun: 1},
+			`["never"]`:  {Misses: 20, FirstRun: 1},
+			`["new"]`:    {Misses: 3, FirstRun: 18},
+		},
+	}
+
+	got := f.Summarize(10)
+
+	wantOrder := []string{`["never"]`, `["new"]`, `["old"]`, `["recent"]`}
+	wantStale := map[string]bool{`["never"]`: true, `["new"]`: false, `["old"]`: true, `["recent"]`: false}
+	wantSince := map[string]int{`["never"]`: 20, `["new"]`: 3, `["old"]`: 15, `["recent"]`: 0}
+
+	if len(got) != len(wantOrder) {
+		t.Fatalf("got %d summaries, want %d", len(got), len(wantOrder))
+	}
+	for i, s := range got {
+		if s.Name != wantOrder[i] {
+			t.Errorf("summary[%d] = %s, want %s", i, s.Name, wantOrder[i])
+		}
+		if s.Stale != wantStale[s.Name] {
+			t.Errorf("%s Stale = %v, want %v", s.Name, s.Stale, wantStale[s.Name])
+		}
+		if s.RunsSinceHit != wantSince[s.Name] {
+			t.Errorf("%s RunsSinceHit = %d, want %d", s.Name, s.RunsSinceHit, wantSince[s.Name])
+		}
+	}
+}
+
+func TestTargetName(t *testing.T) {
+	tests := []struct {
+		name       string
+		sourceFile string
+		sourceDir  string
+		scriptPath string
+		want       string
+	}{
+		{
+			name:       "from CHEZMOI_SOURCE_FILE",
+			sourceFile: "dot_config/zed/modify_private_settings.json.tmpl",
+			scriptPath: "/tmp/chezmoi-123/script",
+			want:       ".config/zed/settings.json",
+		},
+		{
+			name:       "script inside source dir",
+			sourceDir:  "/home/u/.local/share/chezmoi",
+			scriptPath: "/home/u/.local/share/chezmoi/private_dot_app/modify_config.toml",
+			want:       ".app/config.toml",
+		},
+		{
+			name:       "script elsewhere",
+			scriptPath: "/tmp/modify_dot_gitconfig.tmpl",
+			want:       ".gitconfig",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CHEZMOI_SOURCE_FILE", tt.sourceFile)
+			t.Setenv("CHEZMOI_SOURCE_DIR", tt.sourceDir)
+			if got := TargetName(tt.scriptPath); got != tt.want {
+				t.Errorf("TargetName(%q) = %q, want %q", tt.scriptPath, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"~/.config/zed/settings.json", ".config/zed/settings.json"},
+		{"/home/u/.config/zed/settings.json", ".config/zed/settings.json"},
+		{".config/zed/settings.json", ".config/zed/settings.json"},
+		{"/etc/app.conf", "/etc/app.conf"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeTarget(tt.target, "/home/u"); got != tt.want {
+			t.Errorf("NormalizeTarget(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Package textdiff produces line-based unified diffs, like diff -u.
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// contextLines is the number of unchanged lines shown around each change.
+const contextLines = 3
+
+// op is one line of an edit script: an unchanged, deleted, or inserted line.
+type op struct {
+	kind byte // ' ', '-', or '+'
+	line string
+}
+
+// Unified returns a unified diff turning a into b, with oldName and newName
+// in the file header lines, or "" if they are equal.
+func Unified(oldName, newName string, a, b []byte) string {
+	return UnifiedNotes(oldName, newName, a, b, nil)
+}
+
+// UnifiedNotes is Unified with a note after the @@ header of each hunk that
+// inserts lines. note gets the first and last line of b the hunk inserts,
+// from 1, and returns the note, or "" for none.
+func UnifiedNotes(oldName, newName string, a, b []byte, note func(start, end int) string) string {
+	if string(a) == string(b) {
+		return ""
+	}
+
+	ops := diffLines(splitLines(string(a)), splitLines(string(b)))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range hunks(ops) {
+		writeHunk(&sb, ops, h, note)
+	}
+	return sb.String()
+}
+
+// splitLines splits s into lines, each keeping its "\n"; only the last may
+// lack one.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns a shortest edit script from a to b (Myers' algorithm).
+func diffLines(a, b []string) []op {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+
+	// trace[d] is v before step d, for walking the path back
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		done := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				done = true
+				break
+			}
+		}
+		if done {
+			break
+		}
+	}
+
+	var ops []op
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, op{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, op{'+', b[y-1]})
+			} else {
+				ops = append(ops, op{'-', a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// hunk is a range of ops [start, end) printed under one @@ header.
+type hunk struct {
+	start, end int
+}
+
+// hunks groups the changes in ops with their context, merging groups whose
+// context would overlap or touch.
+func hunks(ops []op) []hunk {
+	var result []hunk
+	for i, o := range ops {
+		if o.kind == ' ' {
+			continue
+		}
+		start := max(0, i-contextLines)
+		end := min(len(ops), i+1+contextLines)
+		if n := len(result); n > 0 && start <= result[n-1].end {
+			result[n-1].end = end
+			continue
+		}
+		result = append(result, hunk{start, end})
+	}
+	return result
+}
+
+// writeHunk writes the @@ header, with any note, and lines of h.
+func writeHunk(sb *strings.Builder, ops []op, h hunk, note func(start, end int) string) {
+	// Line numbers before the hunk
+	aLine, bLine := 0, 0
+	for _, o := range ops[:h.start] {
+		if o.kind != '+' {
+			aLine++
+		}
+		if o.kind != '-' {
+			bLine++
+		}
+	}
+	aCount, bCount := 0, 0
+	firstInsert, lastInsert := 0, 0
+	for _, o := range ops[h.start:h.end] {
+		if o.kind != '+' {
+			aCount++
+		}
+		if o.kind != '-' {
+			bCount++
+		}
+		if o.kind == '+' {
+			lastInsert = bLine + bCount
+			if firstInsert == 0 {
+				firstInsert = lastInsert
+			}
+		}
+	}
+
+	fmt.Fprintf(sb, "@@ -%s +%s @@", hunkRange(aLine, aCount), hunkRange(bLine, bCount))
+	if note != nil && firstInsert > 0 {
+		if text := note(firstInsert, lastInsert); text != "" {
+			sb.WriteString(" " + text)
+		}
+	}
+	sb.WriteByte('\n')
+	for _, o := range ops[h.start:h.end] {
+		sb.WriteByte(o.kind)
+		sb.WriteString(o.line)
+		if !strings.HasSuffix(o.line, "\n") {
+			sb.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats the start and length of one side of a hunk. An empty
+// range starts at the line before it, as in diff -u.
+func hunkRange(before, count int) string {
+	start := before + 1
+	if count == 0 {
+		start = before
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
@@ -0,0 +1,113 @@
+package textdiff
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "equal",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+			want: "",
+		},
+		{
+			name: "changed line",
+			a:    "a\nb\nc\n",
+			b:    "a\nB\nc\n",
+			want: "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "from empty",
+			a:    "",
+			b:    "a\nb\n",
+			want: "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "to empty",
+			a:    "a\n",
+			b:    "",
+			want: "--- old\n+++ new\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name: "missing final newline",
+			a:    "a\nb",
+			b:    "a\nb\n",
+			want: "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name: "separate hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			want: "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			name: "nearby changes share a hunk",
+			a:    "1\n2\n3\n4\n5\n6\n7\n",
+			b:    "one\n2\n3\n4\n5\n6\nseven\n",
+			want: "--- old\n+++ new\n@@ -1,7 +1,7 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n-7\n+seven\n",
+		},
+		{
+			name: "insertion in the middle",
+			a:    "a\nc\n",
+			b:    "a\nb\nc\n",
+			want: "--- old\n+++ new\n@@ -1,2 +1,3 @@\n a\n+b\n c\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Unified("old", "new", []byte(tt.a), []byte(tt.b))
+			if got != tt.want {
+				t.Errorf("Unified() =\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestUnified_Minimal checks that the edit script keeps every common line
+// of a reordering, rather than deleting and re-adding everything.
+func TestUnified_Minimal(t *testing.T) {
+	a := "a\nb\nc\nd\ne\n"
+	b := "b\nc\nd\ne\na\n"
+	got := Unified("old", "new", []byte(a), []byte(b))
+	if strings.Count(got, "\n-") != 1 || strings.Count(got, "\n+") != 2 { // "+++ new" counts once
+		t.Errorf("Unified() =\n%s\nwant one deletion and one insertion", got)
+	}
+}
+
+func TestUnifiedNotes(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	b := "1\nX\n3\n4\n5\n6\n7\n8\n9\n10\nY\nZ\n12\n"
+	var calls [][2]int
+	got := UnifiedNotes("old", "new", []byte(a), []byte(b), func(start, end int) string {
+		calls = append(calls, [2]int{start, end})
+		if start == 2 {
+			return "note"
+		}
+		return ""
+	})
+	want := "--- old\n+++ new\n" +
+		"@@ -1,5 +1,5 @@ note\n 1\n-2\n+X\n 3\n 4\n 5\n" +
+		"@@ -8,5 +8,6 @@\n 8\n 9\n 10\n-11\n+Y\n+Z\n 12\n"
+	if got != want {
+		t.Errorf("UnifiedNotes() =\n%s\nwant:\n%s", got, want)
+	}
+	if len(calls) != 2 || calls[0] != [2]int{2, 2} || calls[1] != [2]int{11, 12} {
+		t.Errorf("note calls = %v, want [2 2] and [11 12]", calls)
+	}
+
+	// A hunk that only deletes has no inserted lines to note
+	got = UnifiedNotes("old", "new", []byte("a\nb\n"), []byte("a\n"), func(start, end int) string {
+		t.Errorf("note(%d, %d) called for a deletion", start, end)
+		return "note"
+	})
+	if strings.Contains(got, "note") {
+		t.Errorf("UnifiedNotes() = %q, want no note", got)
+	}
+}